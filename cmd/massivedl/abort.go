@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dimkouv/massivedl/internal/logging"
+	"github.com/dimkouv/massivedl/pkg/massivedl"
+)
+
+// abortTopCauses is the number of errors and hosts the diagnosis of
+// -abort-on-failure-rate lists
+const abortTopCauses = 3
+
+// runAborted is set when -abort-on-failure-rate stopped the run
+var runAborted bool
+
+// parsePercent parses a share like "50%" or "0.5" into a number between 0
+// and 1
+func parsePercent(s string) (float64, error) {
+	v := strings.TrimSpace(s)
+	percent := strings.HasSuffix(v, "%")
+	f, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+	if percent {
+		f /= 100
+	}
+	if err != nil || f < 0 || f > 1 {
+		return 0, fmt.Errorf("invalid share %q, expected e.g. 50%% or 0.5", s)
+	}
+	return f, nil
+}
+
+// failureTracker counts the results of the run for -abort-on-failure-rate
+// and remembers the causes of the failures for the diagnosis
+type failureTracker struct {
+	total, failed int
+	causes        map[string]int // failures by error
+	hosts         map[string]int // failures by host
+}
+
+func newFailureTracker() *failureTracker {
+	return &failureTracker{causes: map[string]int{}, hosts: map[string]int{}}
+}
+
+// add counts the result of a download
+func (t *failureTracker) add(res logging.LogEntry) {
+	// capped urls weren't tried
+	if res.Capped {
+		return
+	}
+	t.total++
+	if res.Result {
+		return
+	}
+	t.failed++
+
+	// the same error of different urls is one cause
+	cause := res.Error
+	var httpErr *massivedl.HTTPError
+	if errors.As(res.Err, &httpErr) {
+		cause = fmt.Sprintf("%d %s", httpErr.StatusCode, http.StatusText(httpErr.StatusCode))
+	}
+	t.causes[strings.ReplaceAll(cause, res.Url, "<url>")]++
+	if u, err := url.Parse(res.Url); err == nil {
+		t.hosts[u.Host]++
+	}
+}
+
+// exceeded reports whether the share of failures reached
+// -abort-on-failure-rate after at least -abort-min-sample results
+func (t *failureTracker) exceeded() bool {
+	return p.AbortFailureRate > 0 && t.total >= p.AbortMinSample &&
+		float64(t.failed) >= p.AbortFailureRate*float64(t.total)
+}
+
+// diagnosis describes why the run was aborted, with the most common errors
+// and the hosts with the most failures
+func (t *failureTracker) diagnosis() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Aborted: %d of the first %d downloads failed (%.0f%%), -abort-on-failure-rate is %.0f%%\n",
+		t.failed, t.total, 100*float64(t.failed)/float64(t.total), 100*p.AbortFailureRate)
+
+	fmt.Fprintln(&b, "Most common errors:")
+	for _, cause := range topCounts(t.causes, abortTopCauses) {
+		fmt.Fprintf(&b, "  %5d  %s\n", t.causes[cause], cause)
+	}
+	fmt.Fprintln(&b, "Hosts with the most failures:")
+	for _, host := range topCounts(t.hosts, abortTopCauses) {
+		fmt.Fprintf(&b, "  %5d  %s\n", t.hosts[host], host)
+	}
+	return b.String()
+}
+
+// topCounts returns the n keys of counts with the highest counts
+func topCounts(counts map[string]int, n int) []string {
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/schedule"
+)
+
+// scheduleCheckInterval is how often the schedule of -active-hours and
+// -active-cron is checked at least, in case the clock jumps
+const scheduleCheckInterval = time.Minute
+
+// offHours holds back the workers outside of -active-hours and -active-cron
+var offHours = newPauseGate()
+
+// activeSchedule parses -active-hours or -active-cron, nil if neither is set
+func activeSchedule() schedule.Schedule {
+	switch {
+	case p.ActiveHours != "":
+		windows, err := schedule.ParseWindows(p.ActiveHours)
+		if err != nil {
+			log.Fatalf("invalid -active-hours: %v", err)
+		}
+		return windows
+	case p.ActiveCron != "":
+		cron, err := schedule.ParseCron(p.ActiveCron)
+		if err != nil {
+			log.Fatalf("invalid -active-cron: %v", err)
+		}
+		return cron
+	}
+	return nil
+}
+
+// followSchedule pauses the downloads outside of s and resumes them inside
+// of it until the run is over. Running downloads are finished.
+func followSchedule(s schedule.Schedule) {
+	for {
+		next := applySchedule(s)
+
+		wait := scheduleCheckInterval
+		if d := time.Until(next); !next.IsZero() && d < wait {
+			wait = d
+		}
+		if !sleep(wait) {
+			// the waiting workers stop once the run is over
+			offHours.Set(false)
+			return
+		}
+	}
+}
+
+// applySchedule pauses or resumes the downloads by s and returns when that
+// changes next, the zero time if not within a week
+func applySchedule(s schedule.Schedule) time.Time {
+	now := time.Now()
+	active := s.Active(now)
+	next := schedule.Next(s, now)
+
+	if active == offHours.On() {
+		switch {
+		case active:
+			log.Println("[SCHEDULE] inside the active hours, resuming")
+		case next.IsZero():
+			log.Println("[SCHEDULE] outside the active hours, pausing")
+		default:
+			log.Printf("[SCHEDULE] outside the active hours, pausing until %s", next.Format("2006-01-02 15:04"))
+		}
+		offHours.Set(!active)
+	}
+	return next
+}
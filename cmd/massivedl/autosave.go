@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// autosavePattern matches the autosaves of a run, the %s is the run id
+const autosavePattern = "%s_autosave_*.save"
+
+// startAutosave saves the progress into the directory of the save files
+// every -autosave interval until the returned function is called, which
+// waits for a save that is being written. Only the last -autosave-keep
+// snapshots are kept, a -session is saved under its name instead.
+func startAutosave() (stop func()) {
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		autosave(done)
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+func autosave(done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case <-runCtx.Done():
+			return
+		case <-time.After(p.Autosave):
+		}
+
+		// a session is saved under its name
+		if p.Session != "" {
+			if err := writeSaveFile(sessionPath(p.Session)); err != nil {
+				log.Println("[AUTOSAVE]", err)
+			}
+			continue
+		}
+
+		name := fmt.Sprintf("%s_autosave_%d.save", runID, time.Now().UnixNano())
+		saveFilePath := filepath.Join(getSaveFilesDirectory(), name)
+		if err := writeSaveFile(saveFilePath); err != nil {
+			log.Println("[AUTOSAVE]", err)
+			continue
+		}
+
+		autosaves := listAutosaves()
+		for len(autosaves) > p.AutosaveKeep {
+			removeAutosave(autosaves[0])
+			autosaves = autosaves[1:]
+		}
+	}
+}
+
+// listAutosaves returns the autosaves of the run, the oldest first
+func listAutosaves() []string {
+	paths, err := filepath.Glob(filepath.Join(getSaveFilesDirectory(), fmt.Sprintf(autosavePattern, runID)))
+	if err != nil {
+		log.Println("[AUTOSAVE]", err)
+	}
+	// the timestamps have the same number of digits for centuries
+	sort.Strings(paths)
+	return paths
+}
+
+// removeAutosaves removes the autosaves of a run that finished, there is
+// nothing left to continue
+func removeAutosaves() {
+	for _, path := range listAutosaves() {
+		removeAutosave(path)
+	}
+}
+
+func removeAutosave(path string) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		log.Println("[AUTOSAVE]", err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/dimkouv/massivedl/internal/yaml"
+)
+
+// refererOrigin as the referer of a bundle sends the origin of the requested
+// url, e.g. https://cdn.example.com/
+const refererOrigin = "origin"
+
+// hostBundle is an entry of -host-bundles: the headers that are sent to the
+// hosts matching Host, e.g. for CDNs that only serve requests that seem to
+// come from their site
+type hostBundle struct {
+	Host      string                `json:"host"` // host name or pattern like *.example.com
+	Referer   string                `json:"referer"`
+	UserAgent string                `json:"userAgent"`
+	Cookies   string                `json:"cookies"` // "name=value; name2=value2"
+	Headers   map[string]scalarList `json:"headers"`
+}
+
+// hostBundles are the bundles of -host-bundles, in the order of the file
+var hostBundles []hostBundle
+
+// loadHostBundles reads the bundles of the JSON or YAML file at bundlesPath,
+// a list of bundles
+func loadHostBundles(bundlesPath string) []hostBundle {
+	data, err := ioutil.ReadFile(bundlesPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the YAML list is decoded like a JSON one
+	if inputFormat(bundlesPath) != inputJSON {
+		document, err := yaml.Unmarshal(data)
+		if err != nil {
+			log.Fatalf("%s: %v", bundlesPath, err)
+		}
+		if data, err = json.Marshal(document); err != nil {
+			log.Fatalf("%s: %v", bundlesPath, err)
+		}
+	}
+
+	var bundles []hostBundle
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&bundles); err != nil {
+		log.Fatalf("%s: expected a list of bundles: %v", bundlesPath, err)
+	}
+	for i, bundle := range bundles {
+		if bundle.Host == "" {
+			log.Fatalf("%s: bundle %d has no host", bundlesPath, i+1)
+		}
+		if _, err = path.Match(bundle.Host, ""); err != nil {
+			log.Fatalf("%s: invalid host %q", bundlesPath, bundle.Host)
+		}
+		for name := range bundle.Headers {
+			if _, _, err = parseHeader(name + ": "); err != nil {
+				log.Fatalf("%s: %v", bundlesPath, err)
+			}
+		}
+	}
+	return bundles
+}
+
+// bundleOf returns the first bundle whose host matches the host of u
+func bundleOf(u *url.URL) (hostBundle, bool) {
+	if u == nil {
+		return hostBundle{}, false
+	}
+	host := strings.ToLower(u.Hostname())
+	for _, bundle := range hostBundles {
+		if ok, _ := path.Match(strings.ToLower(bundle.Host), host); ok {
+			return bundle, true
+		}
+	}
+	return hostBundle{}, false
+}
+
+// apply sets the headers of bundle for a request of u
+func (bundle hostBundle) apply(header http.Header, u *url.URL) {
+	if bundle.UserAgent != "" {
+		header.Set("User-Agent", bundle.UserAgent)
+	}
+	switch bundle.Referer {
+	case "":
+	case refererOrigin:
+		header.Set("Referer", fmt.Sprintf("%s://%s/", u.Scheme, u.Host))
+	default:
+		header.Set("Referer", bundle.Referer)
+	}
+	if bundle.Cookies != "" {
+		header.Set("Cookie", bundle.Cookies)
+	}
+	for name, values := range bundle.Headers {
+		header.Del(name)
+		for _, value := range values {
+			header.Add(name, string(value))
+		}
+	}
+}
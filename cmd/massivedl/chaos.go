@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/simulate"
+)
+
+// parseChaos parses a -chaos value like "drop=10%,delay=5%:30s,corrupt=1%"
+// into the faults injected into simulated runs. An empty value injects none.
+func parseChaos(spec string) (simulate.Chaos, error) {
+	var chaos simulate.Chaos
+	if spec == "" {
+		return chaos, nil
+	}
+	for _, part := range strings.Split(spec, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			return chaos, fmt.Errorf("invalid -chaos %q, expected e.g. drop=10%%,delay=5%%:30s,corrupt=1%%", spec)
+		}
+
+		value := kv[1]
+		delay := ""
+		if kv[0] == "delay" {
+			i := strings.Index(value, ":")
+			if i < 0 {
+				return chaos, fmt.Errorf("invalid -chaos delay %q, expected a share and a duration, e.g. 5%%:30s", value)
+			}
+			value, delay = value[:i], value[i+1:]
+		}
+		rate, err := parsePercent(value)
+		if err != nil {
+			return chaos, fmt.Errorf("invalid -chaos %s: %v", kv[0], err)
+		}
+
+		switch kv[0] {
+		case "drop":
+			chaos.DropRate = rate
+		case "delay":
+			chaos.DelayRate = rate
+			if chaos.Delay, err = time.ParseDuration(delay); err != nil || chaos.Delay <= 0 {
+				return chaos, fmt.Errorf("invalid -chaos delay duration %q", delay)
+			}
+		case "corrupt":
+			chaos.CorruptRate = rate
+		default:
+			return chaos, fmt.Errorf("invalid -chaos %q, unknown fault %s (supported: drop, delay, corrupt)", spec, kv[0])
+		}
+	}
+	return chaos, nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/dimkouv/massivedl/internal/gcs"
+	"github.com/dimkouv/massivedl/internal/s3"
+)
+
+// the transports of s3:// and gs:// urls, they also list the objects of
+// prefix entries
+var (
+	s3Transport  *s3.Transport
+	gcsTransport *gcs.Transport
+)
+
+// isPrefixEntry reports whether entry stands for all objects under an s3://
+// or gs:// prefix, which is written with a trailing "/"
+func isPrefixEntry(entry dataEntry) bool {
+	switch entry.url.Scheme {
+	case s3.Scheme, gcs.Scheme:
+		return entry.url.Path == "" || strings.HasSuffix(entry.url.Path, "/")
+	}
+	return false
+}
+
+// expandPrefixes replaces every prefix entry with one entry per object under
+// the prefix, which keep the request headers of the prefix entry
+func expandPrefixes(entries []dataEntry) ([]dataEntry, error) {
+	expanded := make([]dataEntry, 0, len(entries))
+
+	for _, entry := range entries {
+		if !isPrefixEntry(entry) {
+			entry.index = len(expanded)
+			expanded = append(expanded, entry)
+			continue
+		}
+
+		bucket := entry.url.Host
+		prefix := strings.TrimPrefix(entry.url.Path, "/")
+
+		var keys []string
+		var err error
+		if entry.url.Scheme == s3.Scheme {
+			keys, err = s3Transport.List(context.Background(), bucket, prefix)
+		} else {
+			keys, err = gcsTransport.List(context.Background(), bucket, prefix)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		for _, key := range keys {
+			u := &url.URL{Scheme: entry.url.Scheme, Host: bucket, Path: "/" + key}
+			expanded = append(expanded, dataEntry{index: len(expanded), url: u, header: entry.header})
+		}
+		if len(keys) == 0 {
+			fmt.Printf("%s: no objects found\n", entry.url)
+		}
+	}
+
+	return expanded, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/dimkouv/massivedl/internal/metadb"
+)
+
+// metaDBFilename is the name of the metadata DB in the output directory
+const metaDBFilename = ".massivedl-meta.tsv"
+
+// metaDB holds the validators of the files downloaded with -conditional,
+// nil without it
+var metaDB *metadb.DB
+
+// openMetaDB opens the metadata DB of the output directory
+func openMetaDB() *metadb.DB {
+	db, err := metadb.Open(path.Join(p.OutputDir, metaDBFilename))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return db
+}
+
+// conditionalRecord returns the record of an earlier download of entry if
+// its file is still there as it was saved, so that a conditional request can
+// be sent for it
+func conditionalRecord(entry dataEntry) (metadb.Record, string, bool) {
+	if metaDB == nil {
+		return metadb.Record{}, "", false
+	}
+
+	record, ok := metaDB.Get(entry.url.String())
+	if !ok || record.ETag == "" && record.LastModified == "" {
+		return metadb.Record{}, "", false
+	}
+
+	dir, _ := findOutput(record.Name)
+	filepath := path.Join(dir, record.Name)
+	fi, err := os.Stat(filepath)
+	if err != nil || fi.Size() != record.Size {
+		return metadb.Record{}, "", false
+	}
+
+	return record, filepath, true
+}
+
+// setConditionalHeaders makes header ask for the file only if it differs
+// from record
+func setConditionalHeaders(header http.Header, record metadb.Record) {
+	if record.ETag != "" {
+		header.Set("If-None-Match", record.ETag)
+	}
+	if record.LastModified != "" {
+		header.Set("If-Modified-Since", record.LastModified)
+	}
+}
+
+// recordDownload stores the validators of the response that savePath was
+// downloaded from
+func recordDownload(entry dataEntry, savePath string, responseHeader http.Header) {
+	if metaDB == nil {
+		return
+	}
+
+	fi, err := os.Stat(savePath)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	record := metadb.Record{
+		Name:         relOutputPath(savePath),
+		Size:         fi.Size(),
+		ETag:         strings.TrimSpace(responseHeader.Get("ETag")),
+		LastModified: strings.TrimSpace(responseHeader.Get("Last-Modified")),
+	}
+	if record.ETag == "" && record.LastModified == "" {
+		err = metaDB.Delete(entry.url.String())
+	} else {
+		err = metaDB.Put(entry.url.String(), record)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
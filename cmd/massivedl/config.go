@@ -0,0 +1,154 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dimkouv/massivedl/internal/yaml"
+)
+
+const (
+	// configProfilesKey holds the profiles of the config file
+	configProfilesKey = "profiles"
+	// configNone as -config skips the config file
+	configNone = "none"
+)
+
+// configOnlyFlags can't be set by the config file itself
+var configOnlyFlags = map[string]bool{"config": true, "profile": true}
+
+// defaultConfigPath returns ~/.config/massivedl/config.yaml, or the config
+// directory of the platform
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "massivedl", "config.yaml")
+}
+
+// applyConfig sets the flags that weren't given on the command line to the
+// values of the config file at configPath: the top level settings, then those
+// of profile. The keys are the names of the flags. A missing config file is
+// only an error if it was asked for with -config or a profile.
+func applyConfig(configPath string, explicitPath bool, profile string) {
+	if configPath == "" {
+		if profile != "" {
+			log.Fatalf("-profile %s needs a config file", profile)
+		}
+		return
+	}
+
+	data, err := ioutil.ReadFile(configPath)
+	if os.IsNotExist(err) && !explicitPath && profile == "" {
+		return
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	settings, err := parseConfig(data)
+	if err != nil {
+		log.Fatalf("%s: %v", configPath, err)
+	}
+	profiles, err := configMap(settings[configProfilesKey], configProfilesKey)
+	if err != nil {
+		log.Fatalf("%s: %v", configPath, err)
+	}
+	delete(settings, configProfilesKey)
+
+	if profile != "" {
+		profileSettings, ok := profiles[profile]
+		if !ok {
+			names := make([]string, 0, len(profiles))
+			for name := range profiles {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			log.Fatalf("%s: no profile %q, the profiles are: %s", configPath, profile, strings.Join(names, ", "))
+		}
+		overrides, err := configMap(profileSettings, "profile "+profile)
+		if err != nil {
+			log.Fatalf("%s: %v", configPath, err)
+		}
+		for name, value := range overrides {
+			settings[name] = value
+		}
+	}
+
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err = setConfigFlag(name, settings[name], given[name]); err != nil {
+			log.Fatalf("%s: %v", configPath, err)
+		}
+	}
+}
+
+// parseConfig parses a config file, a YAML mapping
+func parseConfig(data []byte) (map[string]interface{}, error) {
+	document, err := yaml.Unmarshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return configMap(document, "the config")
+}
+
+// configMap returns value as a mapping, nil is an empty one
+func configMap(value interface{}, what string) (map[string]interface{}, error) {
+	if value == nil {
+		return map[string]interface{}{}, nil
+	}
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected %s to be a mapping", what)
+	}
+	return m, nil
+}
+
+// setConfigFlag sets the flag name to value unless it was given on the
+// command line. A list sets a repeatable flag once per item.
+func setConfigFlag(name string, value interface{}, given bool) error {
+	f := flag.Lookup(name)
+	if f == nil || configOnlyFlags[name] || hiddenFlags[name] {
+		return fmt.Errorf("unknown setting %q", name)
+	}
+	if given || value == nil {
+		return nil
+	}
+
+	values, ok := value.([]interface{})
+	if !ok {
+		values = []interface{}{value}
+	}
+	for _, v := range values {
+		var s string
+		switch v := v.(type) {
+		case string:
+			s = v
+		case bool:
+			s = strconv.FormatBool(v)
+		case float64:
+			s = strconv.FormatFloat(v, 'f', -1, 64)
+		default:
+			return fmt.Errorf("%s: expected a value or a list of values", name)
+		}
+		if err := flag.Set(name, s); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
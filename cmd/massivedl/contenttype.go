@@ -0,0 +1,17 @@
+package main
+
+import (
+	"strings"
+)
+
+// parseContentTypes parses a comma separated list of media types like
+// application/zip or image/*
+func parseContentTypes(list string) []string {
+	var types []string
+	for _, t := range strings.Split(list, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
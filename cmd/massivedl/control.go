@@ -0,0 +1,209 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/dimkouv/massivedl/internal/statistics"
+)
+
+// controlMaxBody limits the url lists posted to the control API
+const controlMaxBody = 64 << 20
+
+// controlStatus is the answer of GET /progress
+type controlStatus struct {
+	Stats         statistics.Statistics `json:"stats"`
+	Workers       int                   `json:"workers"`
+	Paused        bool                  `json:"paused"`
+	EstimatedCost float64               `json:"estimatedCost,omitempty"` // in $, with -egress-cost
+}
+
+// startControlServer serves the control API of -control-addr to the
+// requests that carry the token of -control-token, or a random one:
+//
+//	GET  /progress       statistics, number of workers and whether paused
+//	POST /urls?job=<job> queue the urls of the body (lines like in -urlfile, or
+//	                     a JSON or YAML manifest by its Content-Type)
+//	POST /pause          finish the running downloads but start no new ones
+//	POST /resume         start new downloads again
+//	POST /workers?n=<n>  change the number of workers
+//	POST /save           save the progress like Ctrl+C does, for -load
+//	GET  /debug/queue    pending entries per host, why hosts are blocked and
+//	                     what every worker is doing
+func startControlServer() {
+	l, err := net.Listen("tcp", p.ControlAddr)
+	if err != nil {
+		log.Fatalf("unable to serve -control-addr: %v", err)
+	}
+	token := p.ControlToken
+	if token == "" {
+		token = newControlToken()
+		fmt.Printf("Control API: http://%s (Authorization: Bearer %s)\n", l.Addr(), token)
+	} else {
+		fmt.Println("Control API: http://" + l.Addr().String())
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/progress", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodGet) {
+			return
+		}
+		writeJSON(w, http.StatusOK, controlStatus{Stats: stats.Snapshot(), Workers: downloader.Workers(), Paused: downloader.Paused(), EstimatedCost: stats.Cost()})
+	})
+	mux.HandleFunc("/urls", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+		entries, err := readEntriesFormat(http.MaxBytesReader(w, r.Body, controlMaxBody), requestFormat(r), 0)
+		if err == nil {
+			entries, err = expandPrefixes(entries)
+		}
+		job := r.URL.Query().Get("job")
+		if job == "" {
+			job = jobControl
+		}
+		setJob(entries, job)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+			return
+		}
+		if err = addEntries(entries); err != nil {
+			writeJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+			return
+		}
+		log.Printf("[CONTROL] queued %d urls", len(entries))
+		writeJSON(w, http.StatusOK, map[string]int{"added": len(entries)})
+	})
+	mux.HandleFunc("/pause", func(w http.ResponseWriter, r *http.Request) {
+		if allowMethod(w, r, http.MethodPost) {
+			downloader.Pause()
+			log.Println("[CONTROL] paused")
+			writeJSON(w, http.StatusOK, map[string]bool{"paused": true})
+		}
+	})
+	mux.HandleFunc("/resume", func(w http.ResponseWriter, r *http.Request) {
+		if allowMethod(w, r, http.MethodPost) {
+			downloader.Resume()
+			log.Println("[CONTROL] resumed")
+			writeJSON(w, http.StatusOK, map[string]bool{"paused": false})
+		}
+	})
+	mux.HandleFunc("/workers", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+		n, err := strconv.Atoi(r.URL.Query().Get("n"))
+		if err != nil || n < 1 || n > maxWorkers() {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("n must be a number of workers from 1 to %d (-max-workers)", maxWorkers())})
+			return
+		}
+		downloader.SetWorkers(n)
+		log.Printf("[CONTROL] %d workers", n)
+		writeJSON(w, http.StatusOK, map[string]int{"workers": n})
+	})
+	mux.HandleFunc("/debug/queue", func(w http.ResponseWriter, r *http.Request) {
+		if allowMethod(w, r, http.MethodGet) {
+			writeJSON(w, http.StatusOK, snapshotQueue())
+		}
+	})
+	mux.HandleFunc("/save", func(w http.ResponseWriter, r *http.Request) {
+		if !allowMethod(w, r, http.MethodPost) {
+			return
+		}
+		saveFilePath, err := writeProgress()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		log.Println("[CONTROL] progress saved to", saveFilePath)
+		writeJSON(w, http.StatusOK, map[string]string{"path": saveFilePath})
+	})
+
+	go func() {
+		if err := http.Serve(l, controlAuth(mux, token, controlHosts(l.Addr()))); err != nil {
+			log.Println("[CONTROL]", err)
+		}
+	}()
+}
+
+// newControlToken returns a random token for the control API
+func newControlToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("unable to create a control token: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// controlHosts returns the Host headers the control API accepts: the
+// address it listens on, as given and as resolved, and localhost for
+// loopback addresses
+func controlHosts(addr net.Addr) map[string]bool {
+	hosts := map[string]bool{strings.ToLower(p.ControlAddr): true, addr.String(): true}
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok && tcpAddr.IP.IsLoopback() {
+		hosts[net.JoinHostPort("localhost", strconv.Itoa(tcpAddr.Port))] = true
+	}
+	return hosts
+}
+
+// controlAuth passes the requests to next that carry token in their
+// Authorization header and one of hosts in their Host header. Checking the
+// Host keeps web pages that a browser on the same machine opens from
+// reaching the API through DNS rebinding.
+func controlAuth(next http.Handler, token string, hosts map[string]bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !hosts[strings.ToLower(r.Host)] {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "unexpected Host " + r.Host})
+			return
+		}
+		received := r.Header.Get("Authorization")
+		if !strings.HasPrefix(received, "Bearer ") ||
+			subtle.ConstantTimeCompare([]byte(received[len("Bearer "):]), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or wrong token, see -control-token"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowMethod answers requests with another method than method with 405
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "use " + method})
+	return false
+}
+
+// requestFormat returns the input format of the url list in the body of r:
+// json or yaml by its Content-Type, or that of -input-format
+func requestFormat(r *http.Request) string {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/json", "application/x-ndjson":
+		return inputJSON
+	case "application/yaml", "application/x-yaml", "text/yaml":
+		return inputYAML
+	}
+	return inputFormat("")
+}
+
+// writeJSON answers with v encoded as JSON
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("[CONTROL] %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestControlAuth(t *testing.T) {
+	p = cmdLineParams{ControlAddr: "127.0.0.1:8089"}
+	defer func() { p = cmdLineParams{} }()
+
+	hosts := controlHosts(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8089})
+	handler := controlAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), "secret", hosts)
+
+	testCases := []struct {
+		name          string
+		host          string
+		authorization string
+		expected      int
+	}{
+		{"token", "127.0.0.1:8089", "Bearer secret", http.StatusOK},
+		{"localhost", "localhost:8089", "Bearer secret", http.StatusOK},
+		{"no token", "127.0.0.1:8089", "", http.StatusUnauthorized},
+		{"wrong token", "127.0.0.1:8089", "Bearer secrets", http.StatusUnauthorized},
+		{"token without scheme", "127.0.0.1:8089", "secret", http.StatusUnauthorized},
+		// a page of another site that resolves to the loopback address
+		{"rebinding", "attacker.example:8089", "Bearer secret", http.StatusForbidden},
+	}
+
+	for _, testCase := range testCases {
+		r := httptest.NewRequest(http.MethodGet, "/progress", nil)
+		r.Host = testCase.host
+		if testCase.authorization != "" {
+			r.Header.Set("Authorization", testCase.authorization)
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != testCase.expected {
+			t.Errorf("%s: expected %d received %d", testCase.name, testCase.expected, w.Code)
+		}
+	}
+}
+
+func TestMaxWorkers(t *testing.T) {
+	defer func() { p = cmdLineParams{} }()
+
+	testCases := []struct {
+		workers, maxWorkers, expected int
+	}{
+		{10, 256, 256},
+		{500, 256, 500},
+		// parameters saved by older versions have no -max-workers
+		{10, 0, 10},
+	}
+
+	for _, testCase := range testCases {
+		p = cmdLineParams{ConcurrentRequests: testCase.workers, MaxWorkers: testCase.maxWorkers}
+		if received := maxWorkers(); received != testCase.expected {
+			t.Errorf("-workers %d -max-workers %d: expected %d received %d", testCase.workers, testCase.maxWorkers, testCase.expected, received)
+		}
+	}
+}
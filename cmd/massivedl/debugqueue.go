@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dimkouv/massivedl/pkg/massivedl"
+)
+
+// debugHostLines is the number of hosts, those with the most pending
+// entries, that -debug-queue logs
+const debugHostLines = 10
+
+// what a worker is doing, see workerStates
+const (
+	workerWaiting     = "waiting for a job"
+	workerPaused      = "paused"
+	workerOffHours    = "outside the active hours"
+	workerDownloading = "downloading"
+	workerRetryAfter  = "waiting for Retry-After"
+	workerDelay       = "waiting -delay"
+	workerDiskSpace   = "waiting for free space"
+)
+
+// workerStates holds what every worker is doing and since when, for
+// -debug-queue, GET /debug/queue and -profile-run
+var workerStates = struct {
+	lock       sync.Mutex
+	byWorker   map[int]workerState
+	retryAfter map[string]time.Time // end of the Retry-After waits, by url
+}{byWorker: map[int]workerState{}, retryAfter: map[string]time.Time{}}
+
+type workerState struct {
+	state string
+	url   string
+	since time.Time
+}
+
+// debuggingQueue reports whether the states of the workers are tracked
+func debuggingQueue() bool {
+	return p.DebugQueue > 0 || p.ControlAddr != "" || p.ProfileRun
+}
+
+// setWorkerState records that worker id is in state, working on url if it
+// isn't empty
+func setWorkerState(id int, state, url string) {
+	if !debuggingQueue() {
+		return
+	}
+	workerStates.lock.Lock()
+	defer workerStates.lock.Unlock()
+
+	now := time.Now()
+	if previous, ok := workerStates.byWorker[id]; ok {
+		runProfile.addState(previous.state, now.Sub(previous.since))
+	}
+	workerStates.byWorker[id] = workerState{state: state, url: url, since: now}
+}
+
+// removeWorkerState forgets the state of the stopped worker id
+func removeWorkerState(id int) {
+	if !debuggingQueue() {
+		return
+	}
+	workerStates.lock.Lock()
+	defer workerStates.lock.Unlock()
+
+	if previous, ok := workerStates.byWorker[id]; ok {
+		runProfile.addState(previous.state, time.Since(previous.since))
+	}
+	delete(workerStates.byWorker, id)
+}
+
+// setRetryAfter records that the download of url waits for a Retry-After
+// until, or that it stopped waiting if until is zero
+func setRetryAfter(url string, until time.Time) {
+	if !debuggingQueue() {
+		return
+	}
+	workerStates.lock.Lock()
+	defer workerStates.lock.Unlock()
+
+	if until.IsZero() {
+		delete(workerStates.retryAfter, url)
+	} else {
+		workerStates.retryAfter[url] = until
+	}
+}
+
+// queueDebug is the answer of GET /debug/queue
+type queueDebug struct {
+	Queued   int           `json:"queued"`   // entries queued in the run
+	Pending  int           `json:"pending"`  // entries waiting for a worker
+	InFlight int           `json:"inFlight"` // entries being downloaded
+	Paused   bool          `json:"paused"`
+	Idle     string        `json:"idle,omitempty"` // why workers wait for a job
+	Hosts    []hostDebug   `json:"hosts"`
+	Workers  []workerDebug `json:"workers"`
+}
+
+type hostDebug struct {
+	Host    string     `json:"host"`
+	Pending int        `json:"pending"`
+	Active  int        `json:"active"`
+	Blocked string     `json:"blocked,omitempty"` // max-per-host or delay-per-host
+	Until   *time.Time `json:"until,omitempty"`   // end of the delay-per-host
+}
+
+type workerDebug struct {
+	ID    int        `json:"id"`
+	State string     `json:"state"`
+	URL   string     `json:"url,omitempty"`
+	Since time.Time  `json:"since"`
+	Until *time.Time `json:"until,omitempty"` // end of the Retry-After wait
+}
+
+// snapshotQueue describes the queue, the hosts and the workers
+func snapshotQueue() queueDebug {
+	d := queueDebug{Paused: downloader.Paused(), Hosts: []hostDebug{}, Workers: []workerDebug{}}
+
+	runQueue.lock.Lock()
+	d.Queued = runQueue.count
+	runQueue.lock.Unlock()
+
+	blocked := 0
+	for _, h := range downloader.Hosts() {
+		hd := hostDebug{Host: h.Host, Pending: h.Pending, Active: h.Active, Blocked: h.Blocked}
+		if !h.Until.IsZero() {
+			until := h.Until
+			hd.Until = &until
+		}
+		d.Hosts = append(d.Hosts, hd)
+		d.Pending += h.Pending
+		d.InFlight += h.Active
+		if h.Pending > 0 && h.Blocked != "" {
+			blocked++
+		}
+	}
+
+	workerStates.lock.Lock()
+	for id, ws := range workerStates.byWorker {
+		wd := workerDebug{ID: id, State: ws.state, URL: ws.url, Since: ws.since}
+		if until, ok := workerStates.retryAfter[ws.url]; ok && ws.state == workerDownloading {
+			wd.State = workerRetryAfter
+			wd.Until = &until
+		}
+		d.Workers = append(d.Workers, wd)
+	}
+	workerStates.lock.Unlock()
+	sort.Slice(d.Workers, func(i, j int) bool { return d.Workers[i].ID < d.Workers[j].ID })
+
+	switch {
+	case d.Paused:
+		d.Idle = "the run is paused"
+	case d.Pending == 0:
+		d.Idle = "the queue is empty"
+	case blocked == countPending(d.Hosts):
+		d.Idle = "all hosts with pending entries are at -max-per-host or within -delay-per-host"
+	}
+	return d
+}
+
+// countPending returns the number of hosts with pending entries
+func countPending(hosts []hostDebug) int {
+	n := 0
+	for _, h := range hosts {
+		if h.Pending > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// logQueue writes the state of the queue to the log every -debug-queue
+func logQueue() {
+	for sleep(p.DebugQueue) {
+		d := snapshotQueue()
+
+		states := map[string]int{}
+		for _, w := range d.Workers {
+			states[w.State]++
+		}
+		var counts []string
+		for _, state := range topCounts(states, len(states)) {
+			counts = append(counts, fmt.Sprintf("%d %s", states[state], state))
+		}
+		line := fmt.Sprintf("[QUEUE] queued=%d pending=%d in-flight=%d hosts=%d workers: %s",
+			d.Queued, d.Pending, d.InFlight, len(d.Hosts), strings.Join(counts, ", "))
+		if states[workerWaiting] > 0 && d.Idle != "" {
+			line += " (" + d.Idle + ")"
+		}
+		log.Println(line)
+
+		hosts := d.Hosts
+		sort.SliceStable(hosts, func(i, j int) bool { return hosts[i].Pending > hosts[j].Pending })
+		if len(hosts) > debugHostLines {
+			hosts = hosts[:debugHostLines]
+		}
+		for _, h := range hosts {
+			line = fmt.Sprintf("[QUEUE] host=%s pending=%d active=%d", h.Host, h.Pending, h.Active)
+			switch h.Blocked {
+			case massivedl.BlockedMaxPerHost:
+				line += " blocked by -max-per-host"
+			case massivedl.BlockedDelayPerHost:
+				line += fmt.Sprintf(" blocked by -delay-per-host for %s", time.Until(*h.Until).Round(time.Millisecond))
+			}
+			log.Println(line)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/dimkouv/massivedl/internal/checksum"
+	"github.com/dimkouv/massivedl/internal/httputil"
+)
+
+// what -dedup drops
+const (
+	dedupOff     = "off"     // download every entry
+	dedupURL     = "url"     // drop entries whose normalized url was queued before
+	dedupContent = "content" // also hard-link files whose content was downloaded before
+)
+
+// dedupURLs reports whether entries with the same normalized url are dropped
+func dedupURLs() bool {
+	return p.Dedup == dedupURL || p.Dedup == dedupContent
+}
+
+// dropDuplicates removes the entries whose normalized url appeared earlier
+// in entries or is in queued, and adds the urls of the kept entries to
+// queued. It returns the number of dropped entries.
+func dropDuplicates(entries []dataEntry, queued map[string]bool) ([]dataEntry, int) {
+	kept := entries[:0]
+	for _, entry := range entries {
+		key := httputil.NormalizeURL(entry.url)
+		if queued[key] {
+			continue
+		}
+		queued[key] = true
+		kept = append(kept, entry)
+	}
+	return kept, len(entries) - len(kept)
+}
+
+// contents maps the sha256 sums of the files downloaded in this run to their
+// paths, for -dedup content
+var contents = struct {
+	lock   sync.Mutex
+	byHash map[string]string
+	linked int // number of files replaced with links
+}{byHash: map[string]string{}}
+
+// linkDuplicate replaces the file at path with a hard link to a file of this
+// run with the same content, if there is one. Files that can't be linked,
+// e.g. across file systems, are kept as they are.
+func linkDuplicate(path string) error {
+	sum, err := checksum.SumFile("sha256", path)
+	if err != nil {
+		return err
+	}
+
+	contents.lock.Lock()
+	original, ok := contents.byHash[sum.String()]
+	if !ok {
+		contents.byHash[sum.String()] = path
+	}
+	contents.lock.Unlock()
+	if !ok || original == path {
+		return nil
+	}
+
+	// the link is made next to the file and renamed over it, so that the
+	// file is never missing
+	tmpPath := path + ".dedup"
+	if err = os.Link(original, tmpPath); err != nil {
+		log.Printf("[DEDUP] keeping %s, unable to link it to %s: %v", path, original, err)
+		return nil
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		if removeErr := os.Remove(tmpPath); removeErr != nil {
+			log.Println(removeErr)
+		}
+		return fmt.Errorf("unable to replace %s with a link: %v", path, err)
+	}
+
+	log.Println("[DEDUP]", path, "is a link to", original)
+	contents.lock.Lock()
+	contents.linked++
+	contents.lock.Unlock()
+	return nil
+}
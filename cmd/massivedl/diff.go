@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/dimkouv/massivedl/internal/sizeutil"
+)
+
+// diffUsage describes "massivedl diff"
+const diffUsage = `Usage: massivedl diff [-all] <old run> <new run>
+
+Compares two runs recorded with -report json:<file>. A run is given as
+<file>@<run id>, or as <file> for the last run recorded in the file.
+The exit status is 1 if downloads newly failed or disappeared.
+`
+
+// runDiff implements "massivedl diff" and returns the exit status
+func runDiff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), diffUsage)
+		fs.PrintDefaults()
+	}
+	all := fs.Bool("all", false, "Also list the urls that didn't change")
+	if err := fs.Parse(args); err != nil || fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	runs := make([]map[string]reportRecord, 2)
+	for i, spec := range fs.Args() {
+		var err error
+		if runs[i], err = loadRun(spec); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+	}
+
+	d := diffRuns(runs[0], runs[1])
+	d.print(os.Stdout, *all)
+	if len(d.failed) > 0 || len(d.disappeared) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// loadRun returns the last record of every url of the run spec, which is a
+// -report file optionally followed by @ and a run id
+func loadRun(spec string) (map[string]reportRecord, error) {
+	reportPath, id := spec, ""
+	if i := strings.LastIndex(spec, "@"); i >= 0 {
+		reportPath, id = spec[:i], spec[i+1:]
+	}
+
+	fh, err := os.Open(reportPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := fh.Close(); err != nil {
+			fmt.Printf("unable to close file: %v", err)
+		}
+	}()
+
+	byRun := map[string]map[string]reportRecord{}
+	last := ""
+	scanner := bufio.NewScanner(fh)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		var record reportRecord
+		if err = json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", reportPath, n, err)
+		}
+		if byRun[record.RunID] == nil {
+			byRun[record.RunID] = map[string]reportRecord{}
+		}
+		byRun[record.RunID][record.Url] = record
+		last = record.RunID
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if id == "" {
+		id = last
+	}
+	records, ok := byRun[id]
+	if !ok {
+		return nil, fmt.Errorf("%s: no run %q recorded", reportPath, id)
+	}
+	return records, nil
+}
+
+// runDelta lists the urls whose results differ between two runs
+type runDelta struct {
+	failed      []string // failed in the new run, but not in the old one
+	recovered   []string // failed in the old run only
+	changed     []string // downloaded by both, with a different size or checksum
+	added       []string // only in the new run
+	disappeared []string // only in the old run
+	unchanged   []string
+
+	old, new map[string]reportRecord
+}
+
+// diffRuns compares the records of the runs old and new
+func diffRuns(old, new map[string]reportRecord) runDelta {
+	d := runDelta{old: old, new: new}
+	for u, n := range new {
+		o, ok := old[u]
+		switch {
+		case !ok:
+			d.added = append(d.added, u)
+		case o.Success && !n.Success:
+			d.failed = append(d.failed, u)
+		case !o.Success && n.Success:
+			d.recovered = append(d.recovered, u)
+		case o.Success && contentChanged(o, n):
+			d.changed = append(d.changed, u)
+		default:
+			d.unchanged = append(d.unchanged, u)
+		}
+	}
+	for u := range old {
+		if _, ok := new[u]; !ok {
+			d.disappeared = append(d.disappeared, u)
+		}
+	}
+
+	for _, urls := range [][]string{d.failed, d.recovered, d.changed, d.added, d.disappeared, d.unchanged} {
+		sort.Strings(urls)
+	}
+	return d
+}
+
+// contentChanged reports whether two successful downloads differ. Checksums
+// of the same algorithm are compared, otherwise the sizes, which are only
+// known for files that were actually downloaded.
+func contentChanged(o, n reportRecord) bool {
+	if o.Checksum != "" && n.Checksum != "" && checksumAlgorithm(o.Checksum) == checksumAlgorithm(n.Checksum) {
+		return !strings.EqualFold(o.Checksum, n.Checksum)
+	}
+	return o.Bytes > 0 && n.Bytes > 0 && o.Bytes != n.Bytes
+}
+
+// checksumAlgorithm returns the algorithm of a checksum like sha256:<hex>
+func checksumAlgorithm(sum string) string {
+	if i := strings.Index(sum, ":"); i >= 0 {
+		return strings.ToLower(sum[:i])
+	}
+	return ""
+}
+
+// print writes the differences to w, the unchanged urls only with all
+func (d runDelta) print(w io.Writer, all bool) {
+	section := func(title string, urls []string, describe func(string) string) {
+		if len(urls) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "%s (%d):\n", title, len(urls))
+		for _, u := range urls {
+			if detail := describe(u); detail != "" {
+				fmt.Fprintf(w, "  %s  %s\n", u, detail)
+			} else {
+				fmt.Fprintf(w, "  %s\n", u)
+			}
+		}
+		fmt.Fprintln(w)
+	}
+
+	section("Newly failed", d.failed, func(u string) string { return failureText(d.new[u]) })
+	section("Recovered", d.recovered, func(u string) string { return "was: " + failureText(d.old[u]) })
+	section("Changed", d.changed, func(u string) string {
+		o, n := d.old[u], d.new[u]
+		if o.Bytes != n.Bytes && o.Bytes > 0 && n.Bytes > 0 {
+			return fmt.Sprintf("%s -> %s", sizeutil.FormatSize(int64(o.Bytes)), sizeutil.FormatSize(int64(n.Bytes)))
+		}
+		return "checksum " + n.Checksum
+	})
+	section("Disappeared", d.disappeared, func(u string) string { return "" })
+	section("New", d.added, func(u string) string {
+		if !d.new[u].Success {
+			return failureText(d.new[u])
+		}
+		return ""
+	})
+	if all {
+		section("Unchanged", d.unchanged, func(u string) string { return "" })
+	}
+
+	fmt.Fprintf(w, "%d newly failed, %d recovered, %d changed, %d disappeared, %d new, %d unchanged\n",
+		len(d.failed), len(d.recovered), len(d.changed), len(d.disappeared), len(d.added), len(d.unchanged))
+}
+
+// failureText describes why the download of record failed
+func failureText(record reportRecord) string {
+	if record.Error != "" {
+		return record.Error
+	}
+	if record.Status != 0 {
+		return fmt.Sprintf("status %d", record.Status)
+	}
+	return "failed"
+}
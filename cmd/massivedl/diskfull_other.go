@@ -0,0 +1,14 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// isDiskFull reports whether err is caused by a full disk
+func isDiskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC)
+}
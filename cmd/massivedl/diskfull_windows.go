@@ -0,0 +1,17 @@
+package main
+
+import (
+	"errors"
+	"syscall"
+)
+
+// the Windows errors of a full disk
+const (
+	errorHandleDiskFull syscall.Errno = 39
+	errorDiskFull       syscall.Errno = 112
+)
+
+// isDiskFull reports whether err is caused by a full disk
+func isDiskFull(err error) bool {
+	return errors.Is(err, errorDiskFull) || errors.Is(err, errorHandleDiskFull) || errors.Is(err, syscall.ENOSPC)
+}
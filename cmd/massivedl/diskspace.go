@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/fileutil"
+	"github.com/dimkouv/massivedl/internal/sizeutil"
+)
+
+// preflightTimeout is how long the preflight waits for a HEAD response
+const preflightTimeout = 30 * time.Second
+
+// diskSpaceCheckInterval is how often a paused worker checks the free space
+// again
+const diskSpaceCheckInterval = 10 * time.Second
+
+// preflightSpace sends a HEAD request for every entry that is going to be
+// downloaded, sums the sizes the servers announce and compares them with the
+// free space of the output directory. The run is aborted when the announced
+// sizes alone don't fit.
+func preflightSpace(entries []dataEntry) {
+	free, err := freeSpace()
+	if err != nil {
+		fmt.Printf("Preflight: unable to determine the free space of %s: %v\n", strings.Join(outputDirs(), ","), err)
+		return
+	}
+
+	var lock sync.Mutex
+	var total int64
+	files, unknown := 0, 0
+
+	jobs := make(chan dataEntry)
+	var wg sync.WaitGroup
+	for i := 0; i < p.ConcurrentRequests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range jobs {
+				size := announcedSize(entry)
+
+				lock.Lock()
+				files++
+				if size < 0 {
+					unknown++
+				} else {
+					total += size
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	for _, entry := range entries {
+		if p.SkipExisting && fileutil.FileOrPathExists(locateOutput(entry.name)) {
+			continue
+		}
+		jobs <- entry
+	}
+	close(jobs)
+	wg.Wait()
+
+	fmt.Printf("Preflight: %d files, %s announced, %d of unknown size, %s free\n",
+		files, sizeutil.FormatSize(total), unknown, sizeutil.FormatSize(int64(free)))
+
+	if uint64(total+p.MinFreeSpace) > free {
+		fmt.Printf("Not enough space in %s: %s are needed, keeping %s free\n",
+			strings.Join(outputDirs(), ","), sizeutil.FormatSize(total), sizeutil.FormatSize(p.MinFreeSpace))
+		os.Exit(1)
+	}
+	if unknown > 0 {
+		fmt.Printf("The size of %d files is unknown, they may need more space\n", unknown)
+	}
+}
+
+// announcedSize returns the Content-Length of a HEAD request for entry, or
+// unknownSize
+func announcedSize(entry dataEntry) int64 {
+	req, err := http.NewRequestWithContext(runCtx, "HEAD", entry.url.String(), nil)
+	if err != nil {
+		return unknownSize
+	}
+	req.Header = requestHeader(entry, userAgent())
+
+	client := &http.Client{Transport: transport, Jar: cookieJar, Timeout: preflightTimeout}
+	response, err := client.Do(req)
+	if err != nil {
+		return unknownSize
+	}
+	if err = response.Body.Close(); err != nil {
+		log.Printf("error closing response body: %v", err)
+	}
+
+	if response.StatusCode != http.StatusOK {
+		return unknownSize
+	}
+	return response.ContentLength
+}
+
+// waitForFreeSpace blocks while the output directory, or every one of
+// several, has less than -min-free-space free
+func waitForFreeSpace() {
+	paused := false
+
+	for !stopped() {
+		free, err := mostFreeSpace()
+		if err != nil || free >= uint64(p.MinFreeSpace) {
+			if paused {
+				log.Println("[DISK] free space recovered, resuming")
+			}
+			return
+		}
+
+		if !paused {
+			log.Printf("[DISK] only %s free in %s, pausing", sizeutil.FormatSize(int64(free)), strings.Join(outputDirs(), ","))
+			paused = true
+		}
+		sleep(diskSpaceCheckInterval)
+	}
+}
+
+// diskFullResumeSpace is the free space, or -min-free-space if that is more,
+// at which a run paused by a full disk resumes
+const diskFullResumeSpace = 64 * 1024 * 1024
+
+// diskFull is set while the run is paused because the disk was full
+var diskFull struct {
+	lock sync.Mutex
+	on   bool
+}
+
+// pauseForDiskFull pauses the run after a write failed with err because the
+// disk is full, and alerts -notify. The run resumes once enough space is free
+// again, or when the operator resumes it with p, SIGUSR1 or POST /resume.
+func pauseForDiskFull(err error) {
+	diskFull.lock.Lock()
+	defer diskFull.lock.Unlock()
+
+	if downloader.Paused() {
+		// a pause of the operator is left to them
+		if !diskFull.on {
+			log.Printf("[DISK] %v", err)
+		}
+		return
+	}
+
+	downloader.Pause()
+	message := fmt.Sprintf("%v, paused until space is freed in %s or the run is resumed", err, strings.Join(outputDirs(), ","))
+	log.Println("[DISK]", message)
+	notify(notifyDiskFull, message)
+	if !diskFull.on {
+		diskFull.on = true
+		go watchDiskFull()
+	}
+}
+
+// watchDiskFull resumes the run paused by pauseForDiskFull once enough space
+// is free, unless the operator resumed it before
+func watchDiskFull() {
+	resumeSpace := uint64(diskFullResumeSpace)
+	if uint64(p.MinFreeSpace) > resumeSpace {
+		resumeSpace = uint64(p.MinFreeSpace)
+	}
+
+	for sleep(diskSpaceCheckInterval) {
+		if resumeAfterDiskFull(resumeSpace) {
+			return
+		}
+	}
+}
+
+// resumeAfterDiskFull resumes the run if resumeSpace is free, and reports
+// whether the run is no longer paused because of the full disk
+func resumeAfterDiskFull(resumeSpace uint64) bool {
+	diskFull.lock.Lock()
+	defer diskFull.lock.Unlock()
+
+	if !downloader.Paused() {
+		diskFull.on = false
+		return true
+	}
+
+	free, err := mostFreeSpace()
+	if err != nil || free < resumeSpace {
+		return false
+	}
+	diskFull.on = false
+	downloader.Resume()
+	message := fmt.Sprintf("%s free in %s, resuming", sizeutil.FormatSize(int64(free)), strings.Join(outputDirs(), ","))
+	log.Println("[DISK]", message)
+	notify(notifyDiskRecovered, message)
+	return true
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/yaml"
+)
+
+// domainRule is an entry of -domain-rules: the settings of the hosts matching
+// Host, e.g. a slower pace for a fragile origin server than for its CDN. The
+// headers are those of a host bundle.
+type domainRule struct {
+	hostBundle
+	Workers int    `json:"workers"` // parallel requests to each matching host
+	Delay   scalar `json:"delay"`   // minimum time between two requests to a host
+	Auth    string `json:"auth"`    // user:password for basic authentication
+	Proxy   string `json:"proxy"`   // proxy url or direct
+
+	delay time.Duration
+	proxy *url.URL // nil for direct
+}
+
+// domainRules are the rules of -domain-rules, in the order of the file
+var domainRules []domainRule
+
+// loadDomainRules reads the rules of the JSON or YAML file at rulesPath, a
+// list of rules
+func loadDomainRules(rulesPath string) []domainRule {
+	data, err := ioutil.ReadFile(rulesPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the YAML list is decoded like a JSON one
+	if inputFormat(rulesPath) != inputJSON {
+		document, err := yaml.Unmarshal(data)
+		if err != nil {
+			log.Fatalf("%s: %v", rulesPath, err)
+		}
+		if data, err = json.Marshal(document); err != nil {
+			log.Fatalf("%s: %v", rulesPath, err)
+		}
+	}
+
+	var rules []domainRule
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&rules); err != nil {
+		log.Fatalf("%s: expected a list of rules: %v", rulesPath, err)
+	}
+	for i := range rules {
+		rule := &rules[i]
+		if rule.Host == "" {
+			log.Fatalf("%s: rule %d has no host", rulesPath, i+1)
+		}
+		if _, err = path.Match(rule.Host, ""); err != nil {
+			log.Fatalf("%s: invalid host %q", rulesPath, rule.Host)
+		}
+		for name := range rule.Headers {
+			if _, _, err = parseHeader(name + ": "); err != nil {
+				log.Fatalf("%s: %v", rulesPath, err)
+			}
+		}
+		if rule.Workers < 0 {
+			log.Fatalf("%s: %s: invalid workers %d", rulesPath, rule.Host, rule.Workers)
+		}
+		if rule.Delay != "" {
+			if rule.delay, err = time.ParseDuration(string(rule.Delay)); err != nil || rule.delay < 0 {
+				log.Fatalf("%s: %s: invalid delay %q", rulesPath, rule.Host, rule.Delay)
+			}
+		}
+		if rule.Auth != "" && !strings.Contains(rule.Auth, ":") {
+			log.Fatalf("%s: %s: invalid auth, expected user:password", rulesPath, rule.Host)
+		}
+		if rule.Proxy != "" && rule.Proxy != proxyDirect {
+			if p.Tor {
+				log.Fatalf("%s: %s: proxies can't be combined with -tor", rulesPath, rule.Host)
+			}
+			proxy := rule.Proxy
+			if !strings.Contains(proxy, "://") {
+				proxy = "http://" + proxy
+			}
+			if rule.proxy, err = url.Parse(proxy); err != nil {
+				log.Fatalf("%s: %s: invalid proxy %q: %v", rulesPath, rule.Host, rule.Proxy, err)
+			}
+			switch rule.proxy.Scheme {
+			case "http", "https", "socks5":
+			default:
+				log.Fatalf("%s: %s: invalid proxy %q: unsupported scheme %s", rulesPath, rule.Host, rule.Proxy, rule.proxy.Scheme)
+			}
+		}
+	}
+	return rules
+}
+
+// ruleOf returns the first rule whose host matches host, which may have a
+// port
+func ruleOf(host string) (*domainRule, bool) {
+	if len(domainRules) == 0 {
+		return nil, false
+	}
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	host = strings.ToLower(host)
+	for i := range domainRules {
+		if ok, _ := path.Match(strings.ToLower(domainRules[i].Host), host); ok {
+			return &domainRules[i], true
+		}
+	}
+	return nil, false
+}
+
+// domainRuleLimit returns the workers and the delay of the rule of host, with
+// which the downloader hands out its entries
+func domainRuleLimit(host string) (int, time.Duration) {
+	rule, ok := ruleOf(host)
+	if !ok {
+		return 0, 0
+	}
+	return rule.Workers, rule.delay
+}
+
+// applyDomainRule sets the headers of the rule of u for a request of u
+func applyDomainRule(header http.Header, u *url.URL) {
+	if u == nil {
+		return
+	}
+	rule, ok := ruleOf(u.Host)
+	if !ok {
+		return
+	}
+	rule.apply(header, u)
+	if rule.Auth != "" {
+		header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(rule.Auth)))
+	}
+}
+
+// withDomainRuleProxy sends the requests to the hosts of rules with a proxy
+// through that proxy, the others through proxy
+func withDomainRuleProxy(proxy func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		if rule, ok := ruleOf(req.URL.Host); ok && rule.Proxy != "" {
+			return rule.proxy, nil
+		}
+		if proxy == nil {
+			return nil, nil
+		}
+		return proxy(req)
+	}
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/fileutil"
+	"github.com/dimkouv/massivedl/internal/httputil"
+	"github.com/dimkouv/massivedl/internal/logging"
+	"github.com/dimkouv/massivedl/pkg/massivedl"
+)
+
+// unknownSize is the ContentLength of responses without Content-Length, like
+// chunked ones
+const unknownSize = -1
+
+// retryStatuses are the status codes of -retry-on, responses with other
+// error codes fail their url at once
+var retryStatuses httputil.StatusSet
+
+// defaultRetryOn are the status codes that are retried without -retry-on
+const defaultRetryOn = "429,5xx"
+
+// parseRetryOn parses the status codes of -retry-on
+func parseRetryOn() httputil.StatusSet {
+	// parameters saved by older versions have no -retry-on
+	if p.RetryOn == "" {
+		p.RetryOn = defaultRetryOn
+	}
+
+	set, err := httputil.ParseStatusSet(p.RetryOn)
+	if err != nil {
+		log.Fatalf("invalid -retry-on %q: %v", p.RetryOn, err)
+	}
+	return set
+}
+
+// downloader runs the downloads of the run, see newDownloader
+var downloader *massivedl.Downloader
+
+// newDownloader returns the Downloader that runs the downloads with the
+// command line parameters. The command takes part in the downloads through
+// the hooks below: it decides which files are skipped, where they are
+// placed, which headers are sent and what happens to the files once they
+// are saved.
+func newDownloader() *massivedl.Downloader {
+	opts := []massivedl.Option{
+		massivedl.WithWorkers(p.ConcurrentRequests),
+		massivedl.WithMaxWorkers(maxWorkers()),
+		massivedl.WithRetries(p.MaxRetries),
+		massivedl.WithConnectRetries(p.ConnectRetries),
+		massivedl.WithRetryOn(retryStatuses.Contains),
+		massivedl.WithDelay(p.DelayPerRequest),
+		massivedl.WithStagger(p.Stagger),
+		massivedl.WithMaxPerHost(p.MaxPerHost),
+		massivedl.WithDelayPerHost(p.DelayPerHost),
+		massivedl.WithHostCaps(p.MaxFilesPerHost, p.MaxBytesPerHost),
+		massivedl.WithOutputDir(p.OutputDir),
+		// existing files are found by skipEntry, in any directory of -outdir
+		massivedl.WithSkipExisting(false),
+		massivedl.WithKeepPartial(!p.DiscardPartial),
+		massivedl.WithUserAgent(userAgent()),
+		massivedl.WithHeader(commandHeader()),
+		massivedl.WithHTTPClient(httpClient),
+		massivedl.WithMaxTime(p.MaxTime),
+		massivedl.WithMinSpeed(p.MinSpeed, p.MinSpeedTime),
+		massivedl.WithRateLimit(p.LimitRate, p.LimitRatePerConn),
+		massivedl.WithSegments(p.Segments, p.SegmentMinSize),
+		massivedl.WithMemoryBuffer(p.ToMemory),
+		massivedl.WithDigestHeaders(!p.IgnoreDigestHeaders),
+		massivedl.WithChecksumFailAction(p.ChecksumFailAction),
+		massivedl.WithContentTypes(p.AcceptContentTypes, p.RejectContentTypes),
+		massivedl.WithFastestMirror(p.MirrorSelect == mirrorSelectFastest),
+		massivedl.WithStatistics(&stats, func(e massivedl.Entry) string {
+			return route(e.Data.(dataEntry).url)
+		}),
+		massivedl.WithLogger(log.Default()),
+		massivedl.WithListener(followEvents),
+		massivedl.WithHooks(massivedl.Hooks{
+			Wait:    waitActiveHours,
+			Skip:    skipEntry,
+			Place:   placeFile,
+			Header:  setEntryHeader,
+			Reuse:   reuseFile,
+			Check:   checkResponse,
+			Save:    saveFile,
+			Suspend: suspendForDiskFull,
+		}),
+	}
+	if len(domainRules) > 0 {
+		opts = append(opts, massivedl.WithHostLimit(domainRuleLimit))
+	}
+	if p.FairShare {
+		opts = append(opts, massivedl.WithFairShare(weightOf))
+	}
+
+	return massivedl.New(opts...)
+}
+
+// maxWorkers is the most workers the run has: -max-workers, or -workers if
+// that is more
+func maxWorkers() int {
+	if p.ConcurrentRequests > p.MaxWorkers {
+		return p.ConcurrentRequests
+	}
+	return p.MaxWorkers
+}
+
+// downloaderEntry returns the Entry of the Downloader for entry, which is
+// passed on in its Data
+func downloaderEntry(entry dataEntry) massivedl.Entry {
+	e := massivedl.Entry{
+		URL:      entry.url.String(),
+		Path:     relOutputPath(entry.name),
+		Header:   entry.header,
+		Metadata: entry.metadata,
+		Data:     entry,
+		Priority: entry.priority,
+		Job:      entry.job,
+		Timeout:  entry.limits.timeout,
+		MaxSize:  entry.limits.maxSize,
+		Retries:  entry.limits.retries,
+	}
+	for _, m := range entry.mirrors {
+		e.Mirrors = append(e.Mirrors, m.String())
+	}
+	if !entry.checksum.IsZero() {
+		e.Checksum = entry.checksum.String()
+	}
+	return e
+}
+
+// logEntry converts the result of a download for the log, the reports and
+// the hooks of the command
+func logEntry(res massivedl.Result) logging.LogEntry {
+	entry := res.Entry.Data.(dataEntry)
+	row := logging.LogEntry{
+		Url:        entry.url.String(),
+		Name:       res.Path,
+		Result:     res.Err == nil,
+		NBytes:     uint64(res.Bytes),
+		Duration:   res.Duration,
+		StatusCode: res.StatusCode,
+		Attempts:   res.Attempts,
+		Skipped:    res.Skipped,
+	}
+	if res.Err != nil {
+		row.Error, row.Err = res.Err.Error(), res.Err
+		row.Capped = errors.Is(res.Err, massivedl.ErrCapped)
+	}
+	// a file that didn't change is where the earlier run saved it
+	if res.NotModified {
+		if _, recordPath, ok := conditionalRecord(entry); ok {
+			row.Name = recordPath
+		}
+	}
+	return row
+}
+
+// followEvents keeps the activities of -tui and -progress-file, the worker
+// states of -debug-queue and the profile of -profile-run up to date
+func followEvents(event massivedl.Event) {
+	switch event.Type {
+	case massivedl.WorkerChanged:
+		switch event.State {
+		case massivedl.WorkerStopped:
+			stopActivity(event.Worker)
+			removeWorkerState(event.Worker)
+		case massivedl.WorkerDownloading:
+			setWorkerState(event.Worker, workerDownloading, event.Entry.URL)
+		case massivedl.WorkerPaused:
+			startActivity(event.Worker, "", "")
+			setWorkerState(event.Worker, workerPaused, "")
+		case massivedl.WorkerDelay:
+			startActivity(event.Worker, "", "")
+			setWorkerState(event.Worker, workerDelay, "")
+		default:
+			startActivity(event.Worker, "", "")
+			setWorkerState(event.Worker, workerWaiting, "")
+		}
+	case massivedl.EntryStarted:
+		markActive(event.Entry.URL)
+		startActivity(event.Worker, event.Entry.URL, event.Path)
+	case massivedl.EntryProgress:
+		trackProgress(event.Worker, event.Bytes, event.Size)
+	case massivedl.EntryRetry:
+		runProfile.addRetry(event.Duration + event.RetryAfter)
+		if event.RetryAfter > 0 {
+			setRetryAfter(event.Entry.URL, time.Now().Add(event.RetryAfter))
+		} else {
+			setRetryAfter(event.Entry.URL, time.Time{})
+		}
+	case massivedl.EntryFinished:
+		setRetryAfter(event.Entry.URL, time.Time{})
+		runProfile.addDiskWrite(event.Result.WriteTime)
+	}
+}
+
+// waitActiveHours holds back worker outside of -active-hours and
+// -active-cron
+func waitActiveHours(worker int) {
+	if offHours.On() {
+		setWorkerState(worker, workerOffHours, "")
+	}
+	offHours.Wait()
+}
+
+// skipEntry skips the files that were downloaded before, in any directory
+// of -outdir and under the name the server or the data gave them, unless
+// they are checked for changes with -conditional or -refresh. Urls that
+// failed permanently in an earlier run are skipped too, see knownDead.
+func skipEntry(e massivedl.Entry, filepath string) (massivedl.Result, bool) {
+	entry := e.Data.(dataEntry)
+
+	existing := locateOutput(filepath)
+	if p.TrustServerNames || namedAfterDownload {
+		if name, ok := savedServerName(entry); ok {
+			existing = name
+		}
+	}
+
+	_, err := os.Stat(existing)
+	if _, _, ok := conditionalRecord(entry); ok || p.Refresh > 0 {
+		err = os.ErrNotExist
+	}
+	if err == nil && p.SkipExisting {
+		return massivedl.Result{Path: existing, Skipped: true}, true
+	}
+	return knownDead(entry)
+}
+
+// placeFile waits for -min-free-space and places the file of an entry in
+// one of the directories of -outdir, see placeEntry
+func placeFile(worker int, e massivedl.Entry, filepath string) (string, func()) {
+	if p.MinFreeSpace > 0 {
+		setWorkerState(worker, workerDiskSpace, e.URL)
+		waitForFreeSpace()
+	}
+	return placeEntry(e.Data.(dataEntry))
+}
+
+// setEntryHeader adds the headers of the -host-bundles bundle and of the
+// -domain-rules rule of an entry, and asks only for changes of files with
+// -conditional
+func setEntryHeader(e massivedl.Entry, header http.Header) {
+	entry := e.Data.(dataEntry)
+	applyHostHeaders(header, entry)
+	if record, _, ok := conditionalRecord(entry); ok {
+		setConditionalHeaders(header, record)
+	}
+}
+
+// reuseFile saves a file that an earlier run saved elsewhere from the same
+// url without a download, see reuseHistory
+func reuseFile(e massivedl.Entry, filepath string) (bool, error) {
+	entry := e.Data.(dataEntry)
+	if !reuseHistory(entry, filepath) {
+		return false, nil
+	}
+	err := finishFile(entry, filepath, nil)
+	if err != nil {
+		log.Println(err)
+	}
+	return true, err
+}
+
+// checkResponse checks a downloaded response against -success-if
+func checkResponse(e massivedl.Entry, response *massivedl.Response, partPath string, attempt int) error {
+	if successCheck == nil {
+		return nil
+	}
+	return checkSuccess(e.URL, response.StatusCode, response.Header, partPath, attempt)
+}
+
+// saveFile moves a complete download into place: it is transformed with
+// -transform-jq, appended to the -ndjson and -parquet sinks or saved under
+// the name it has, the server gives it or its data gives it. Then it is
+// finished, see finishFile.
+func saveFile(e massivedl.Entry, partPath, filepath string, response *massivedl.Response) (string, error) {
+	entry := e.Data.(dataEntry)
+
+	if transformQuery != nil {
+		if err := transformFile(partPath); err != nil {
+			// the response is kept so that it can be inspected
+			log.Println("[TRANSFORM]", e.URL, filepath, err)
+			if err := os.Rename(partPath, filepath+".raw"); err != nil {
+				log.Println(err)
+			}
+			return "", err
+		}
+	}
+
+	if ndjsonSink != nil || parquetSink != nil {
+		return filepath, appendToSinks(e.URL, response.StatusCode, response.Header, partPath)
+	}
+
+	savePath := filepath
+	var err error
+	if namedAfterDownload && entry.outputName == "" {
+		savePath, err = saveNamedAfterDownload(entry, partPath, response)
+	} else {
+		if p.TrustServerNames {
+			savePath = claimServerName(entry, filepath, serverName(entry.url, response.URL, response.Header))
+		}
+		err = os.Rename(partPath, savePath)
+	}
+	if err != nil {
+		return "", err
+	}
+	if savePath != filepath {
+		recordServerName(entry, savePath)
+	}
+	return savePath, finishFile(entry, savePath, response.Header)
+}
+
+// suspendForDiskFull pauses the run when a write failed because the disk is
+// full, the download is resumed with the run
+func suspendForDiskFull(err error) bool {
+	if !isDiskFull(err) || stopped() {
+		return false
+	}
+	pauseForDiskFull(err)
+	return true
+}
+
+// finishFile does what is due for the file of entry once it is saved at
+// savePath: it links duplicates, writes the sidecars, remembers it for later
+// runs, extracts it and uploads it to -sink
+func finishFile(entry dataEntry, savePath string, responseHeader http.Header) error {
+	var err error
+	if p.Dedup == dedupContent {
+		err = linkDuplicate(savePath)
+	}
+	if err == nil && responseHeader != nil {
+		recordDownload(entry, savePath, responseHeader)
+	}
+	if err == nil && len(p.CaptureHeaders) > 0 {
+		err = writeHeadersSidecar(savePath, captureHeaders(responseHeader))
+	}
+	if err == nil && p.MetadataSidecar {
+		err = writeMetadataSidecar(savePath, entry.metadata)
+	}
+	if err == nil {
+		recordHistory(entry, savePath)
+	}
+	if err == nil && p.Extract {
+		err = extractArchive(savePath)
+	}
+	if err == nil && sinkURL != nil {
+		err = uploadToSink(savePath)
+		if err == nil && len(p.CaptureHeaders) > 0 {
+			err = uploadToSink(savePath + headersSuffix)
+		}
+		if err == nil && p.MetadataSidecar && len(entry.metadata) > 0 {
+			err = uploadToSink(savePath + metadataSuffix)
+		}
+	}
+	return err
+}
+
+// transformFile replaces the JSON document in path with the output of
+// transformQuery
+func transformFile(path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	out, err := transformQuery.Transform(b)
+	if err != nil {
+		return err
+	}
+
+	return fileutil.WriteFileAtomic(path, out, os.ModePerm)
+}
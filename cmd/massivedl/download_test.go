@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/checksum"
+	"github.com/dimkouv/massivedl/internal/logging"
+	"github.com/dimkouv/massivedl/internal/statistics"
+	"github.com/dimkouv/massivedl/pkg/massivedl"
+)
+
+// testContent is the data of the files of the test server
+var testContent = []byte(strings.Repeat("0123456789", 1000))
+
+// testServer serves testContent with Range and If-Range support under /file,
+// without them under /no-ranges, fails the first request to /flaky and
+// answers 404 to anything else. It counts the requests by path.
+func testServer(t *testing.T) (*httptest.Server, map[string]int) {
+	var lock sync.Mutex
+	requests := map[string]int{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests[r.URL.Path]++
+		n := requests[r.URL.Path]
+		lock.Unlock()
+
+		switch r.URL.Path {
+		case "/file":
+			w.Header().Set("ETag", `"v1"`)
+			http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(testContent))
+		case "/no-ranges":
+			_, _ = w.Write(testContent)
+		case "/flaky":
+			if n == 1 {
+				http.Error(w, "try again", http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write(testContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, requests
+}
+
+// setupDownloadTest sets the parameters the downloader needs and returns the
+// output directory, which is removed after the test
+func setupDownloadTest(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "massivedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		p, httpClient = cmdLineParams{}, nil
+		if err := os.RemoveAll(dir); err != nil {
+			t.Error(err)
+		}
+	})
+
+	p = cmdLineParams{
+		OutputDir:          dir,
+		ConcurrentRequests: 1,
+		ChecksumFailAction: massivedl.ChecksumFailDelete,
+		ConnectRetries:     1,
+		Dedup:              dedupURL,
+		SegmentMinSize:     1,
+	}
+	httpClient = &http.Client{}
+	retryStatuses = parseRetryOn()
+	stats = statistics.New()
+
+	return dir
+}
+
+// testEntry returns the entry of rawURL
+func testEntry(t *testing.T, rawURL string) dataEntry {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dataEntry{url: u}
+}
+
+// runDownload downloads entry to savePath with the downloader of the
+// command and returns the logged result
+func runDownload(t *testing.T, entry dataEntry, savePath string) logging.LogEntry {
+	entry.name = savePath
+	downloader = newDownloader()
+	t.Cleanup(func() { downloader = nil })
+
+	results, err := downloader.Run(context.Background(), []massivedl.Entry{downloaderEntry(entry)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logEntry(results[0])
+}
+
+// checkFile fails t if the file at savePath doesn't hold testContent or if a
+// part file of it was left behind
+func checkFile(t *testing.T, name, savePath string) {
+	data, err := ioutil.ReadFile(savePath)
+	if err != nil || !bytes.Equal(data, testContent) {
+		t.Errorf("%s: expected %d bytes of content received %d (%v)", name, len(testContent), len(data), err)
+	}
+	for _, suffix := range []string{massivedl.PartSuffix, massivedl.SegmentedPartSuffix} {
+		if _, err = os.Stat(savePath + suffix); !os.IsNotExist(err) {
+			t.Errorf("%s: expected no %s file received %v", name, suffix, err)
+		}
+	}
+}
+
+func TestDownload(t *testing.T) {
+	server, requests := testServer(t)
+	dir := setupDownloadTest(t)
+	p.MaxRetries = 2
+
+	sum := sha256.Sum256(testContent)
+	goodSum, _ := checksum.Parse(fmt.Sprintf("sha256:%x", sum))
+	badSum, _ := checksum.Parse(fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("other"))))
+
+	testCases := []struct {
+		name             string
+		path             string
+		checksum         checksum.Checksum
+		expectedResult   bool
+		expectedRequests int
+		expectedErr      error
+	}{
+		{"complete", "/file", goodSum, true, 1, nil},
+		{"retried", "/flaky", checksum.Checksum{}, true, 2, nil},
+		{"not found", "/missing", checksum.Checksum{}, false, 1, &massivedl.HTTPError{StatusCode: http.StatusNotFound}},
+		{"checksum mismatch", "/no-ranges", badSum, false, 3, massivedl.ErrChecksumMismatch},
+		// the requests are counted by path, this is the second one of /file
+		{"too large", "/file", checksum.Checksum{}, false, 2, massivedl.ErrTooLarge},
+	}
+
+	for _, testCase := range testCases {
+		entry := testEntry(t, server.URL+testCase.path)
+		entry.checksum = testCase.checksum
+		if testCase.expectedErr == massivedl.ErrTooLarge {
+			entry.limits.maxSize = 100
+		}
+		savePath := path.Join(dir, testCase.name)
+
+		res := runDownload(t, entry, savePath)
+		if res.Result != testCase.expectedResult || requests[testCase.path] != testCase.expectedRequests {
+			t.Errorf("%s: expected result %v after %d requests received %v after %d (%s)", testCase.name,
+				testCase.expectedResult, testCase.expectedRequests, res.Result, requests[testCase.path], res.Error)
+		}
+		var httpErr *massivedl.HTTPError
+		if expected, ok := testCase.expectedErr.(*massivedl.HTTPError); ok {
+			if !errors.As(res.Err, &httpErr) || httpErr.StatusCode != expected.StatusCode {
+				t.Errorf("%s: expected status %d received %v", testCase.name, expected.StatusCode, res.Err)
+			}
+		} else if !errors.Is(res.Err, testCase.expectedErr) {
+			t.Errorf("%s: expected error %v received %v", testCase.name, testCase.expectedErr, res.Err)
+		}
+		if testCase.expectedResult {
+			checkFile(t, testCase.name, savePath)
+		} else if _, err := os.Stat(savePath); !os.IsNotExist(err) {
+			t.Errorf("%s: expected no file received %v", testCase.name, err)
+		}
+	}
+}
+
+func TestDownloadSkipExisting(t *testing.T) {
+	server, requests := testServer(t)
+	dir := setupDownloadTest(t)
+
+	savePath := path.Join(dir, "existing")
+	if err := ioutil.WriteFile(savePath, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// the file is found by the command, without -skip-existing it is
+	// downloaded again
+	for _, skip := range []bool{true, false} {
+		p.SkipExisting = skip
+		res := runDownload(t, testEntry(t, server.URL+"/file"), savePath)
+		if !res.Result || res.Skipped != skip {
+			t.Errorf("-skip-existing %v: expected the file to be skipped %v received %v (%s)", skip, skip, res.Skipped, res.Error)
+		}
+	}
+	if requests["/file"] != 1 {
+		t.Errorf("expected 1 request received %d", requests["/file"])
+	}
+	checkFile(t, "existing", savePath)
+}
+
+func TestDownloadSegmented(t *testing.T) {
+	server, _ := testServer(t)
+	dir := setupDownloadTest(t)
+	p.Segments = 4
+
+	// the server without range support gets a single request instead
+	for _, name := range []string{"file", "no-ranges"} {
+		savePath := path.Join(dir, name)
+		res := runDownload(t, testEntry(t, server.URL+"/"+name), savePath)
+		if !res.Result {
+			t.Errorf("%s: expected the download to succeed received %s", name, res.Error)
+		}
+		checkFile(t, name, savePath)
+	}
+}
+
+func TestFinishFileDedup(t *testing.T) {
+	dir := setupDownloadTest(t)
+	p.Dedup = dedupContent
+	defer func() { contents.byHash = map[string]string{} }()
+
+	paths := []string{path.Join(dir, "a"), path.Join(dir, "b"), path.Join(dir, "c")}
+	for i, savePath := range paths {
+		data := testContent
+		if i == 2 {
+			data = []byte("other")
+		}
+		if err := ioutil.WriteFile(savePath, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := finishFile(testEntry(t, "http://example.com/"+path.Base(savePath)), savePath, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	a, _ := os.Stat(paths[0])
+	b, _ := os.Stat(paths[1])
+	c, _ := os.Stat(paths[2])
+	if !os.SameFile(a, b) {
+		t.Error("expected b to be a link to a")
+	}
+	if os.SameFile(a, c) {
+		t.Error("expected c to be kept")
+	}
+}
+
+func TestFollowEvents(t *testing.T) {
+	setupDownloadTest(t)
+	p.ProgressFile = "progress.json"
+
+	entry := massivedl.Entry{URL: "http://example.com/a"}
+	events := []massivedl.Event{
+		{Type: massivedl.WorkerChanged, Worker: 1, State: massivedl.WorkerWaiting},
+		{Type: massivedl.EntryStarted, Entry: entry, Worker: 1, Path: "downloads/a"},
+		{Type: massivedl.EntryProgress, Entry: entry, Worker: 1, Bytes: 5, Size: 10},
+	}
+	for _, event := range events {
+		followEvents(event)
+	}
+	active := activeDownloads()
+	if len(active) != 1 || active[0].Url != entry.URL || active[0].Path != "downloads/a" || active[0].Bytes != 5 || active[0].Size != 10 {
+		t.Errorf("expected 5 of 10 bytes of %s in downloads/a received %+v", entry.URL, active)
+	}
+
+	// the worker is idle after the download and gone once it stopped
+	events = []massivedl.Event{
+		{Type: massivedl.EntryFinished, Entry: entry, Worker: 1, Result: &massivedl.Result{Entry: entry}},
+		{Type: massivedl.WorkerChanged, Worker: 1, State: massivedl.WorkerWaiting},
+	}
+	for _, event := range events {
+		followEvents(event)
+	}
+	if active = activeDownloads(); len(active) != 0 {
+		t.Errorf("expected no downloads received %+v", active)
+	}
+	followEvents(massivedl.Event{Type: massivedl.WorkerChanged, Worker: 1, State: massivedl.WorkerStopped})
+	if _, ok := activities.byWorker[1]; ok {
+		t.Error("expected the stopped worker to be removed")
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/fileutil"
+	"github.com/dimkouv/massivedl/internal/httputil"
+	"github.com/dimkouv/massivedl/internal/sizeutil"
+)
+
+// dryRunTimeout is how long -dry-run waits for the response of an entry
+const dryRunTimeout = 30 * time.Second
+
+// probeResult is what -dry-run found out about an entry without downloading
+// it
+type probeResult struct {
+	status      int
+	size        int64 // unknownSize if the server didn't announce it
+	contentType string
+	skipped     bool // the file exists and -skip-existing is set
+	err         error
+}
+
+// dryRun checks every entry with a HEAD request and prints its status, size
+// and content type next to the path it would be saved under, followed by a
+// summary. Nothing is written to the output directory. The process exits with
+// status 1 if any entry fails.
+func dryRun(entries []dataEntry) {
+	results := make([]probeResult, len(entries))
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < p.ConcurrentRequests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = probeEntry(entries[i])
+			}
+		}()
+	}
+	for i, entry := range entries {
+		if p.SkipExisting && fileutil.FileOrPathExists(locateOutput(entry.name)) {
+			results[i].skipped = true
+			continue
+		}
+		if stopped() {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var total int64
+	ok, failed, skipped, unknown := 0, 0, 0, 0
+	statuses := map[int]int{}
+	for i, res := range results {
+		entry := entries[i]
+		switch {
+		case res.skipped:
+			skipped++
+			fmt.Printf("%-5s %10s  %-24s %s -> %s\n", "SKIP", "", "", entry.url, entry.name)
+			continue
+		case res.err != nil:
+			failed++
+			fmt.Printf("%-5s %10s  %-24s %s: %v\n", "ERR", "", "", entry.url, res.err)
+			continue
+		}
+
+		statuses[res.status]++
+		size := "?"
+		if res.size < 0 {
+			unknown++
+		} else {
+			size = sizeutil.FormatSize(res.size)
+			total += res.size
+		}
+		if res.status >= 200 && res.status <= 299 {
+			ok++
+		} else {
+			failed++
+		}
+		fmt.Printf("%-5d %10s  %-24s %s -> %s\n", res.status, size, res.contentType, entry.url, entry.name)
+	}
+
+	codes := make([]int, 0, len(statuses))
+	for code := range statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+
+	fmt.Printf("\nDry run: %d entries, %d ok, %d failed, %d existing\n", len(entries), ok, failed, skipped)
+	for _, code := range codes {
+		fmt.Printf("  %d %s: %d\n", code, http.StatusText(code), statuses[code])
+	}
+	fmt.Printf("%s announced, %d of unknown size\n", sizeutil.FormatSize(total), unknown)
+
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// probeEntry sends a HEAD request for entry. Servers that don't allow HEAD
+// are asked for the first byte with a GET request instead.
+func probeEntry(entry dataEntry) probeResult {
+	res := probeResult{size: unknownSize}
+
+	response, err := probeRequest(entry, "HEAD")
+	if err == nil && (response.StatusCode == http.StatusMethodNotAllowed || response.StatusCode == http.StatusNotImplemented) {
+		response, err = probeRequest(entry, "GET")
+	}
+	if err != nil {
+		res.err = err
+		return res
+	}
+
+	res.status = response.StatusCode
+	res.contentType = response.Header.Get("Content-Type")
+	switch {
+	case response.StatusCode == http.StatusPartialContent:
+		if _, _, total, err := httputil.ParseContentRange(response.Header.Get("Content-Range")); err == nil && total >= 0 {
+			res.size = total
+		}
+		res.status = http.StatusOK
+	case response.StatusCode == http.StatusOK:
+		res.size = response.ContentLength
+	}
+	return res
+}
+
+// probeRequest sends a request for entry, GET requests only ask for the first
+// byte. The body of the response is closed.
+func probeRequest(entry dataEntry, method string) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(runCtx, dryRunTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, entry.url.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = requestHeader(entry, userAgent())
+	if method == "GET" {
+		req.Header.Set("Range", "bytes=0-0")
+	}
+
+	response, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if err = response.Body.Close(); err != nil {
+		log.Printf("error closing response body: %v", err)
+	}
+	return response, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// egressPresets are the list prices in $ per GB of the first tier of
+// internet egress of the cloud providers, which -egress-cost accepts by name
+var egressPresets = map[string]float64{
+	"aws":   0.09,
+	"gcp":   0.12,
+	"azure": 0.087,
+}
+
+// parseEgressCost parses -egress-cost, a price in $ per GB like 0.05 or the
+// name of one of egressPresets
+func parseEgressCost(s string) (float64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return 0, nil
+	}
+	if rate, ok := egressPresets[s]; ok {
+		return rate, nil
+	}
+
+	rate, err := strconv.ParseFloat(strings.TrimPrefix(s, "$"), 64)
+	if err != nil || rate < 0 {
+		names := make([]string, 0, len(egressPresets))
+		for name := range egressPresets {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return 0, fmt.Errorf("invalid -egress-cost %q, expected $ per GB or one of %s", s, strings.Join(names, ", "))
+	}
+	return rate, nil
+}
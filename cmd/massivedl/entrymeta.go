@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/dimkouv/massivedl/internal/fileutil"
+)
+
+// metaPrefix starts the columns of an entry that hold metadata, like
+// meta:order=1234
+const metaPrefix = "meta:"
+
+// metadataSuffix is appended to the name of a file to get the name of the
+// file with the metadata of its entry
+const metadataSuffix = ".meta.json"
+
+// parseMetadata parses a "key=value" metadata pair, without metaPrefix
+func parseMetadata(pair string) (key, value string, err error) {
+	i := strings.Index(pair, "=")
+	if i <= 0 {
+		return "", "", fmt.Errorf("invalid metadata %q, expected \"%skey=value\"", pair, metaPrefix)
+	}
+	return strings.TrimSpace(pair[:i]), strings.TrimSpace(pair[i+1:]), nil
+}
+
+// formatMetadata is the reverse of parseMetadata for all pairs of metadata
+func formatMetadata(metadata map[string]string) []string {
+	var pairs []string
+	for key, value := range metadata {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return pairs
+}
+
+// writeMetadataSidecar saves the metadata of the entry of the file in
+// filepath next to it. Nothing is written for entries without metadata.
+func writeMetadataSidecar(filepath string, metadata map[string]string) error {
+	if len(metadata) == 0 {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return fileutil.WriteFileAtomic(filepath+metadataSuffix, append(b, '\n'), 0644)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/dimkouv/massivedl/internal/extract"
+)
+
+// extracted counts the archives -extract unpacked, and those it couldn't
+var extracted struct {
+	lock     sync.Mutex
+	archives int
+	files    int
+	failed   int
+}
+
+// unsupportedArchives holds the formats that can't be unpacked here, and why
+var unsupportedArchives = map[string]error{}
+
+// checkArchiveFormats tells at startup which archives -extract leaves packed,
+// .tar.zst ones without the zstd command
+func checkArchiveFormats() {
+	for _, format := range []string{extract.Zip, extract.TarGz, extract.TarZst} {
+		if err := extract.Supported(format); err != nil {
+			unsupportedArchives[format] = err
+			fmt.Printf("-extract leaves .%s archives packed, %v\n", format, err)
+		}
+	}
+}
+
+// extractArchive unpacks the file at savePath into a directory named after
+// it if it is a .zip, .tar.gz or .tar.zst archive, and removes it with
+// -extract-delete. Members that would leave the directory or that exist
+// already are skipped, and the extraction fails once the files take more than
+// -extract-max-size. A failed extraction is only logged, the download of the
+// archive still succeeded; what it wrote is removed again and the archive is
+// kept.
+func extractArchive(savePath string) error {
+	format := extract.Format(savePath)
+	if format == "" {
+		return nil
+	}
+	if err := unsupportedArchives[format]; err != nil {
+		log.Printf("[EXTRACT] %s: not extracted, %v", savePath, err)
+		return nil
+	}
+
+	dir := extract.Dir(savePath)
+	res, err := extract.Extract(savePath, dir, p.ExtractMaxSize)
+	for _, skipped := range res.Skipped {
+		log.Printf("[EXTRACT] %s: skipped %s", savePath, skipped)
+	}
+	if err != nil {
+		log.Printf("[EXTRACT] %s: not extracted, %v", savePath, err)
+		extracted.lock.Lock()
+		extracted.failed++
+		extracted.lock.Unlock()
+		return nil
+	}
+	log.Printf("[EXTRACT] %s: %d files in %s", savePath, res.Files, dir)
+
+	extracted.lock.Lock()
+	extracted.archives++
+	extracted.files += res.Files
+	extracted.lock.Unlock()
+
+	if p.ExtractDelete {
+		return os.Remove(savePath)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExtractArchiveFailure(t *testing.T) {
+	p = cmdLineParams{Extract: true, ExtractMaxSize: 1}
+	defer func() { p = cmdLineParams{} }()
+
+	dir, err := ioutil.TempDir("", "massivedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = os.RemoveAll(dir); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// the archive takes more than -extract-max-size once extracted
+	archivePath := filepath.Join(dir, "a.zip")
+	f, err := os.Create(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := zip.NewWriter(f)
+	for _, name := range []string{"a.txt", "b.txt"} {
+		member, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = member.Write([]byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = extractArchive(archivePath); err != nil {
+		t.Errorf("expected the download to succeed received %v", err)
+	}
+	if _, err = os.Stat(archivePath); err != nil {
+		t.Errorf("expected the archive to be kept received %v", err)
+	}
+	if _, err = os.Stat(filepath.Join(dir, "a")); !os.IsNotExist(err) {
+		t.Errorf("expected the partial extraction to be removed received %v", err)
+	}
+	if extracted.failed != 1 {
+		t.Errorf("expected 1 failed extraction received %d", extracted.failed)
+	}
+}
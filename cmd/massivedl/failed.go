@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/dimkouv/massivedl/internal/checksum"
+	"github.com/dimkouv/massivedl/internal/logging"
+)
+
+// failedFilename is the name of the file in the output directory that lists
+// the downloads which failed in the last run
+const failedFilename = "failed.csv"
+
+// failedHeader is the first row of a failed downloads file
+var failedHeader = []string{"url", "error", "status", "attempts", "checksum", "headers", "metadata", "limits", "name", "priority"}
+
+// writeFailed writes the failed downloads into failed.csv in the output
+// directory, or removes a failed.csv of an earlier run if nothing failed.
+// byURL maps the urls of the downloads to their entries.
+func writeFailed(failed []logging.LogEntry, byURL map[string]dataEntry) {
+	failedPath := path.Join(p.OutputDir, failedFilename)
+
+	if len(failed) == 0 {
+		if err := os.Remove(failedPath); err != nil && !os.IsNotExist(err) {
+			log.Println(err)
+		}
+		return
+	}
+
+	f, err := os.Create(failedPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		if err = f.Close(); err != nil {
+			fmt.Printf("unable to close file: %v", err)
+		}
+	}()
+
+	w := csv.NewWriter(f)
+	if err = w.Write(failedHeader); err != nil {
+		log.Fatal(err)
+	}
+
+	for _, res := range failed {
+		urls, sum, headers, metadata, limits, name, priority := res.Url, "", "", "", "", "", ""
+		if entry, ok := byURL[res.Url]; ok {
+			urls = joinURLs(entry)
+			if !entry.checksum.IsZero() {
+				sum = entry.checksum.String()
+			}
+			headers = strings.Join(formatHeader(entry.header), "\n")
+			metadata = strings.Join(formatMetadata(entry.metadata), "\n")
+			limits = strings.Join(formatLimits(entry.limits), "\n")
+			name = entry.outputName
+			if entry.priority != 0 {
+				priority = strconv.Itoa(entry.priority)
+			}
+		}
+
+		row := []string{urls, res.Error, strconv.Itoa(res.StatusCode), strconv.Itoa(res.Attempts), sum, headers, metadata, limits, name, priority}
+		if err = w.Write(row); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	w.Flush()
+	if err = w.Error(); err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Printf("\n%d downloads failed, they are listed in %s\n", len(failed), failedPath)
+	fmt.Printf("Use the following command to retry them\n\n\tmassivedl -retry-failed %s\n", failedPath)
+}
+
+// loadFailed loads the entries of a failed downloads file
+func loadFailed(failedPath string) ([]dataEntry, error) {
+	f, err := os.Open(failedPath)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err = f.Close(); err != nil {
+			log.Printf("unable to close file: %v", err)
+		}
+	}()
+
+	r := csv.NewReader(f)
+	r.FieldsPerRecord = -1
+
+	rows, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]dataEntry, 0, len(rows))
+	for i, row := range rows {
+		if i == 0 && len(row) > 0 && row[0] == failedHeader[0] {
+			continue
+		}
+
+		var entry dataEntry
+		if entry.url, entry.mirrors, err = parseURLs(row[0]); err != nil {
+			log.Printf("%s: %s\n", row[0], err)
+			continue
+		}
+		if len(row) > 4 && row[4] != "" {
+			if entry.checksum, err = checksum.Parse(row[4]); err != nil {
+				log.Printf("%s: %s\n", row[0], err)
+			}
+		}
+
+		if len(row) > 5 && row[5] != "" {
+			entry.header = make(http.Header)
+			for _, line := range strings.Split(row[5], "\n") {
+				name, value, err := parseHeader(line)
+				if err != nil {
+					log.Printf("%s: %s\n", row[0], err)
+					continue
+				}
+				entry.header.Add(name, value)
+			}
+		}
+
+		if len(row) > 6 && row[6] != "" {
+			entry.metadata = make(map[string]string)
+			for _, pair := range strings.Split(row[6], "\n") {
+				key, value, err := parseMetadata(pair)
+				if err != nil {
+					log.Printf("%s: %s\n", row[0], err)
+					continue
+				}
+				entry.metadata[key] = value
+			}
+		}
+
+		if len(row) > 7 && row[7] != "" {
+			for _, column := range strings.Split(row[7], "\n") {
+				if _, err := parseLimit(column, &entry.limits); err != nil {
+					log.Printf("%s: %s\n", row[0], err)
+				}
+			}
+		}
+
+		if len(row) > 8 && row[8] != "" {
+			if entry.outputName, err = checkOutputName(row[8]); err != nil {
+				log.Printf("%s: %s\n", row[0], err)
+				continue
+			}
+		}
+		if len(row) > 9 && row[9] != "" {
+			if entry.priority, err = strconv.Atoi(row[9]); err != nil {
+				log.Printf("%s: %s\n", row[0], err)
+			}
+		}
+
+		entry.index = len(entries)
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
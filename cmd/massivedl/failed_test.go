@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestLoadFailedNames(t *testing.T) {
+	dir, err := ioutil.TempDir("", "massivedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = os.RemoveAll(dir); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// names that leave the output directory are dropped with their entries
+	failedPath := path.Join(dir, failedFilename)
+	content := "url,error,status,attempts,checksum,headers,metadata,limits,name\n" +
+		"http://example.com/a,,0,1,,,,,sub/../a.zip\n" +
+		"http://example.com/b,,0,1,,,,,../../etc/passwd\n" +
+		"http://example.com/c,,0,1,,,,,/etc/passwd\n" +
+		"http://example.com/d,,0,1,,,,,\n"
+	if err = ioutil.WriteFile(failedPath, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := loadFailed(failedPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"http://example.com/a": "a.zip", "http://example.com/d": ""}
+	if len(entries) != len(expected) {
+		t.Errorf("expected %d entries received %d", len(expected), len(entries))
+	}
+	for _, entry := range entries {
+		if name, ok := expected[entry.url.String()]; !ok || entry.outputName != name {
+			t.Errorf("%s: expected the name %q received %q", entry.url, name, entry.outputName)
+		}
+	}
+
+	// names that are set otherwise still stay in the output directory
+	p = cmdLineParams{OutputDir: dir}
+	defer func() { p = cmdLineParams{} }()
+	if received := outputPath(dataEntry{outputName: "../../x"}, nil); received != path.Join(dir, "x") {
+		t.Errorf("expected %s received %s", path.Join(dir, "x"), received)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/dimkouv/massivedl/internal/sliceutil"
+)
+
+// the filters, in the order they are applied and listed in the summary
+var filterNames = []string{"-include-regex", "-exclude-regex", "-accept-ext", "-reject-ext", "-min-size", "-max-size"}
+
+// includeRegex and excludeRegex are compiled from -include-regex and
+// -exclude-regex, nil if not set
+var includeRegex, excludeRegex *regexp.Regexp
+
+// compileFilters compiles the regular expressions of the filters
+func compileFilters() {
+	var err error
+	if p.IncludeRegex != "" {
+		if includeRegex, err = regexp.Compile(p.IncludeRegex); err != nil {
+			log.Fatalf("invalid -include-regex: %v", err)
+		}
+	}
+	if p.ExcludeRegex != "" {
+		if excludeRegex, err = regexp.Compile(p.ExcludeRegex); err != nil {
+			log.Fatalf("invalid -exclude-regex: %v", err)
+		}
+	}
+}
+
+// hasFilters reports whether any filter is set
+func hasFilters() bool {
+	return includeRegex != nil || excludeRegex != nil || len(p.AcceptExt) > 0 || len(p.RejectExt) > 0 || hasSizeFilter()
+}
+
+// hasSizeFilter reports whether the sizes of the files are needed
+func hasSizeFilter() bool {
+	return p.MinSize > 0 || p.MaxSize > 0
+}
+
+// parseExtensions parses a comma separated list of file extensions, with or
+// without dots, into their lower case form without dot
+func parseExtensions(list string) []string {
+	var exts []string
+	for _, ext := range strings.Split(list, ",") {
+		if ext = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(ext), ".")); ext != "" {
+			exts = append(exts, ext)
+		}
+	}
+	return exts
+}
+
+// urlExtension returns the extension of the path of entry's url in the form
+// of parseExtensions
+func urlExtension(entry dataEntry) string {
+	return strings.ToLower(strings.TrimPrefix(path.Ext(entry.url.Path), "."))
+}
+
+// urlFilter returns the name of the first url filter that rejects entry, or
+// "" if it passes all of them
+func urlFilter(entry dataEntry) string {
+	url := entry.url.String()
+	ext := urlExtension(entry)
+	switch {
+	case includeRegex != nil && !includeRegex.MatchString(url):
+		return "-include-regex"
+	case excludeRegex != nil && excludeRegex.MatchString(url):
+		return "-exclude-regex"
+	case len(p.AcceptExt) > 0 && sliceutil.StrIndexOf(p.AcceptExt, ext) < 0:
+		return "-accept-ext"
+	case len(p.RejectExt) > 0 && sliceutil.StrIndexOf(p.RejectExt, ext) >= 0:
+		return "-reject-ext"
+	}
+	return ""
+}
+
+// sizeFilter returns the name of the size filter that rejects entry, or ""
+// if it passes them. Files whose size can't be found out with a HEAD request
+// pass, their download decides.
+func sizeFilter(entry dataEntry) string {
+	res := probeEntry(entry)
+	if res.err != nil || res.size < 0 {
+		return ""
+	}
+
+	switch {
+	case p.MinSize > 0 && res.size < p.MinSize:
+		return "-min-size"
+	case p.MaxSize > 0 && res.size > p.MaxSize:
+		return "-max-size"
+	}
+	return ""
+}
+
+// filterEntries drops the entries that don't pass the filters and counts
+// them by the name of the filter that dropped them. The url filters are
+// applied first, the remaining entries are checked for their size with
+// parallel HEAD requests.
+func filterEntries(entries []dataEntry) ([]dataEntry, map[string]int) {
+	counts := map[string]int{}
+	if !hasFilters() {
+		return entries, counts
+	}
+
+	kept := entries[:0]
+	for _, entry := range entries {
+		if name := urlFilter(entry); name != "" {
+			counts[name]++
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	if !hasSizeFilter() {
+		return kept, counts
+	}
+
+	rejected := make([]string, len(kept))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < p.ConcurrentRequests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				rejected[i] = sizeFilter(kept[i])
+			}
+		}()
+	}
+	for i := range kept {
+		if stopped() {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	sized := kept[:0]
+	for i, entry := range kept {
+		if rejected[i] != "" {
+			counts[rejected[i]]++
+			continue
+		}
+		sized = append(sized, entry)
+	}
+	return sized, counts
+}
+
+// formatFilterCounts describes the counts of filterEntries, e.g.
+// "12 by -exclude-regex, 3 by -max-size"
+func formatFilterCounts(counts map[string]int) string {
+	var parts []string
+	for _, name := range filterNames {
+		if counts[name] > 0 {
+			parts = append(parts, fmt.Sprintf("%d by %s", counts[name], name))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// sumCounts returns the number of entries counted in counts
+func sumCounts(counts map[string]int) int {
+	n := 0
+	for _, count := range counts {
+		n += count
+	}
+	return n
+}
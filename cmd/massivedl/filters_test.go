@@ -0,0 +1,49 @@
+package main
+
+import (
+	"net/url"
+	"regexp"
+	"testing"
+)
+
+func TestFilterEntries(t *testing.T) {
+	defer func() {
+		p = cmdLineParams{}
+		includeRegex, excludeRegex = nil, nil
+	}()
+
+	p = cmdLineParams{AcceptExt: parseExtensions("zip, .ISO,tar"), RejectExt: parseExtensions("tar")}
+	includeRegex = regexp.MustCompile(`example\.com`)
+	excludeRegex = regexp.MustCompile(`/old/`)
+
+	testCases := []struct {
+		url      string
+		expected string // the filter that drops the url
+	}{
+		{"http://example.com/a.zip", ""},
+		{"http://example.com/A.ISO", ""},
+		{"http://example.org/a.zip", "-include-regex"},
+		{"http://example.com/old/a.zip", "-exclude-regex"},
+		{"http://example.com/a.txt", "-accept-ext"},
+		{"http://example.com/a.tar", "-reject-ext"},
+	}
+
+	var entries []dataEntry
+	for _, testCase := range testCases {
+		u, _ := url.Parse(testCase.url)
+		entries = append(entries, dataEntry{url: u})
+		if received := urlFilter(dataEntry{url: u}); received != testCase.expected {
+			t.Errorf("%s: expected %q received %q", testCase.url, testCase.expected, received)
+		}
+	}
+
+	kept, counts := filterEntries(entries)
+	if len(kept) != 2 || kept[0].url.Path != "/a.zip" || kept[1].url.Path != "/A.ISO" {
+		t.Errorf("expected a.zip and A.ISO to be kept received %v", kept)
+	}
+	for _, name := range []string{"-include-regex", "-exclude-regex", "-accept-ext", "-reject-ext"} {
+		if counts[name] != 1 {
+			t.Errorf("%s: expected 1 dropped entry received %d", name, counts[name])
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// stringsFlag is a flag.Value that collects every occurrence of a repeatable
+// command line flag
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ", ")
+}
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// hiddenFlags are left out of the usage, they are meant for testing
+// massivedl itself
+var hiddenFlags = map[string]bool{"chaos": true}
+
+// printUsage prints the usage of the command line flags without hiddenFlags
+func printUsage() {
+	var b strings.Builder
+	flag.CommandLine.SetOutput(&b)
+	flag.PrintDefaults()
+	flag.CommandLine.SetOutput(nil)
+
+	// every flag starts with "  -name" and its description lines are
+	// indented further
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
+	hidden := false
+	for _, line := range strings.SplitAfter(b.String(), "\n") {
+		if strings.HasPrefix(line, "  -") {
+			name := strings.Fields(line)[0][1:]
+			hidden = hiddenFlags[name]
+		}
+		if !hidden {
+			fmt.Fprint(flag.CommandLine.Output(), line)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/dimkouv/massivedl/internal/cookiefile"
+	"github.com/dimkouv/massivedl/internal/fileutil"
+)
+
+// cookieJar holds the cookies of -cookie-jar, nil without one
+var cookieJar http.CookieJar
+
+// headersSuffix is appended to the name of a file to get the name of the
+// file with its captured response headers
+const headersSuffix = ".headers.json"
+
+// captureHeaders returns the response headers that match p.CaptureHeaders,
+// or nil if none match. Patterns are header names, or prefixes followed by
+// '*' like x-amz-*, and are matched case insensitively. Repeated headers
+// are joined with ", ".
+func captureHeaders(header http.Header) map[string]string {
+	var captured map[string]string
+
+	for name, values := range header {
+		lower := strings.ToLower(name)
+		for _, pattern := range p.CaptureHeaders {
+			pattern = strings.ToLower(pattern)
+			if lower == pattern || strings.HasSuffix(pattern, "*") && strings.HasPrefix(lower, strings.TrimSuffix(pattern, "*")) {
+				if captured == nil {
+					captured = make(map[string]string)
+				}
+				captured[lower] = strings.Join(values, ", ")
+				break
+			}
+		}
+	}
+
+	return captured
+}
+
+// writeHeadersSidecar saves the captured headers of the file in filepath
+// next to it. Nothing is written if no header was captured.
+func writeHeadersSidecar(filepath string, captured map[string]string) error {
+	if captured == nil {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(captured, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return fileutil.WriteFileAtomic(filepath+headersSuffix, append(b, '\n'), 0644)
+}
+
+// parseHeader parses a "Name: value" header line
+func parseHeader(line string) (name, value string, err error) {
+	i := strings.Index(line, ":")
+	if i <= 0 {
+		return "", "", fmt.Errorf("invalid header %q, expected \"Name: value\"", line)
+	}
+
+	name = strings.TrimSpace(line[:i])
+	for _, c := range name {
+		if !(c == '-' || c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return "", "", fmt.Errorf("invalid header name %q", name)
+		}
+	}
+
+	return name, strings.TrimSpace(line[i+1:]), nil
+}
+
+// requestHeader returns the headers of the requests for an entry. The
+// headers of the entry replace the ones of the -host-bundles bundle of its
+// host, which replace the ones of -header, which replace the User-Agent of
+// -useragent.
+func requestHeader(entry dataEntry, userAgent string) http.Header {
+	header := make(http.Header)
+	header.Set("User-Agent", userAgent)
+
+	for name, values := range commandHeader() {
+		header[name] = values
+	}
+	applyHostHeaders(header, entry)
+	for name, values := range entry.header {
+		header[name] = values
+	}
+
+	return header
+}
+
+// commandHeader returns the headers of -header
+func commandHeader() http.Header {
+	header := make(http.Header)
+	for _, line := range p.Headers {
+		if name, value, err := parseHeader(line); err == nil {
+			header.Set(name, value)
+		}
+	}
+	return header
+}
+
+// applyHostHeaders sets the headers of the -host-bundles bundle and of the
+// -domain-rules rule of the host of entry
+func applyHostHeaders(header http.Header, entry dataEntry) {
+	if bundle, ok := bundleOf(entry.url); ok {
+		bundle.apply(header, entry.url)
+	}
+	applyDomainRule(header, entry.url)
+}
+
+// formatHeader is the reverse of the per entry headers of loadEntries
+func formatHeader(header http.Header) []string {
+	var lines []string
+	for name, values := range header {
+		for _, value := range values {
+			lines = append(lines, name+": "+value)
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// loadCookieJar loads the cookies of a Netscape format cookie file
+func loadCookieJar(path string) http.CookieJar {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		if err = f.Close(); err != nil {
+			log.Printf("unable to close file: %v", err)
+		}
+	}()
+
+	jar, err := cookiefile.NewJar(f)
+	if err != nil {
+		log.Fatalf("%s: %v", path, err)
+	}
+
+	return jar
+}
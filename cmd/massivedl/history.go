@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+
+	"github.com/dimkouv/massivedl/internal/fileutil"
+	"github.com/dimkouv/massivedl/internal/history"
+)
+
+// historyFilename is the name of the download history in the directory of
+// the save files
+const historyFilename = "history.tsv"
+
+// downloadHistory remembers where the urls were saved by all runs, nil in
+// simulated and replayed runs
+var downloadHistory *history.History
+
+// openHistory opens the download history in ~/.massivedl
+func openHistory() *history.History {
+	h, err := history.Open(filepath.Join(getSaveFilesDirectory(), historyFilename))
+	if err != nil {
+		log.Fatal(err)
+	}
+	return h
+}
+
+// reuseHistory saves the file of entry at filePath by hard-linking, or
+// copying, the file an earlier run saved from the same url elsewhere, if
+// that is still as it was saved. It reports whether it did. The files of
+// -conditional and -refresh are always checked with the server, and nothing
+// is reused with -refetch or for files that are named after their data.
+func reuseHistory(entry dataEntry, filePath string) bool {
+	if downloadHistory == nil || p.Refetch || p.Conditional || ndjsonSink != nil || parquetSink != nil {
+		return false
+	}
+	if namedAfterDownload && entry.outputName == "" {
+		return false
+	}
+
+	url := entry.url.String()
+	record, ok := downloadHistory.Get(url)
+	if !ok {
+		return false
+	}
+	if abs, err := filepath.Abs(filePath); err != nil || abs == record.Path {
+		return false
+	}
+	if !record.Unchanged() {
+		if err := downloadHistory.Delete(url); err != nil {
+			log.Println(err)
+		}
+		return false
+	}
+
+	if !entry.checksum.IsZero() {
+		if err := entry.checksum.VerifyFile(record.Path); err != nil {
+			log.Printf("[HISTORY] not reusing %s for %s: %v", record.Path, url, err)
+			return false
+		}
+	}
+	linked, err := fileutil.LinkOrCopy(record.Path, filePath)
+	if err != nil {
+		log.Printf("[HISTORY] unable to reuse %s for %s: %v", record.Path, url, err)
+		return false
+	}
+
+	if linked {
+		log.Println("[HISTORY]", filePath, "is a link to", record.Path)
+	} else {
+		log.Println("[HISTORY]", filePath, "is a copy of", record.Path)
+	}
+	return true
+}
+
+// recordHistory remembers that entry was saved at savePath. Files that are
+// removed once they are uploaded to -sink are not remembered.
+func recordHistory(entry dataEntry, savePath string) {
+	if downloadHistory == nil || sinkURL != nil && !p.SinkKeepLocal {
+		return
+	}
+
+	abs, err := filepath.Abs(savePath)
+	if err == nil {
+		err = downloadHistory.Put(entry.url.String(), abs)
+	}
+	if err != nil {
+		log.Println(err)
+	}
+}
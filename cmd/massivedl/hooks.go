@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/logging"
+	"github.com/dimkouv/massivedl/internal/statistics"
+)
+
+// the events of -on-complete, -on-failure and -on-finish
+const (
+	hookComplete = "complete"
+	hookFailure  = "failure"
+	hookFinish   = "finish"
+)
+
+// hookPayload is the JSON body posted to a webhook hook
+type hookPayload struct {
+	Run      string            `json:"run"`
+	Event    string            `json:"event"`
+	Time     time.Time         `json:"time"`
+	URL      string            `json:"url,omitempty"`
+	Path     string            `json:"path,omitempty"`
+	Bytes    uint64            `json:"bytes,omitempty"`
+	Status   int               `json:"status,omitempty"`
+	Attempts int               `json:"attempts,omitempty"`
+	Checksum string            `json:"checksum,omitempty"`
+	Error    string            `json:"error,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"` // of the meta: columns of the entry
+	Stats    *hookStats        `json:"stats,omitempty"`    // of the finish event
+}
+
+// hookStats are the counters of the run in the finish event
+type hookStats struct {
+	Downloaded int    `json:"downloaded"`
+	Failed     int    `json:"failed"`
+	Capped     int    `json:"capped"`
+	Bytes      uint64 `json:"bytes"`
+	DurationMs int64  `json:"durationMs"`
+	Aborted    bool   `json:"aborted"`
+}
+
+// hooks runs the hooks of the files in the background, at most as many at
+// once as there are -workers
+var hooks struct {
+	wg    sync.WaitGroup
+	slots chan struct{}
+}
+
+// isWebhook reports whether the hook is a webhook url rather than a command
+func isWebhook(hook string) bool {
+	return strings.HasPrefix(hook, "http://") || strings.HasPrefix(hook, "https://")
+}
+
+// runFileHook runs -on-complete for a downloaded file and -on-failure for a
+// failed one, the files that existed already and the capped urls have none
+func runFileHook(res logging.LogEntry) {
+	hook, event := p.OnComplete, hookComplete
+	if !res.Result {
+		hook, event = p.OnFailure, hookFailure
+	}
+	if hook == "" || res.Skipped || res.Capped {
+		return
+	}
+
+	payload := hookPayload{
+		Run:      runID,
+		Event:    event,
+		Time:     time.Now(),
+		URL:      res.Url,
+		Path:     res.Name,
+		Bytes:    res.NBytes,
+		Status:   res.StatusCode,
+		Attempts: res.Attempts,
+		Error:    res.Error,
+	}
+	entry := queuedEntry(res.Url)
+	if res.Result && !entry.checksum.IsZero() {
+		payload.Checksum = entry.checksum.String()
+	}
+	payload.Metadata = entry.metadata
+
+	if hooks.slots == nil {
+		hooks.slots = make(chan struct{}, p.ConcurrentRequests)
+	}
+	hooks.slots <- struct{}{}
+	hooks.wg.Add(1)
+	go func() {
+		defer hooks.wg.Done()
+		defer func() { <-hooks.slots }()
+		runHook(hook, payload, res.Name, res.Url)
+	}()
+}
+
+// runFinishHook waits for the hooks of the files and runs -on-finish
+func runFinishHook(s statistics.Statistics, aborted bool) {
+	hooks.wg.Wait()
+	if p.OnFinish == "" {
+		return
+	}
+
+	runHook(p.OnFinish, hookPayload{
+		Run:   runID,
+		Event: hookFinish,
+		Time:  time.Now(),
+		Stats: &hookStats{
+			Downloaded: s.TotalDownloaded,
+			Failed:     s.TotalFailed,
+			Capped:     s.TotalCapped,
+			Bytes:      s.TotalDownloadedBytes,
+			DurationMs: time.Since(s.StartTime).Milliseconds(),
+			Aborted:    aborted,
+		},
+	})
+}
+
+// runHook posts payload to the webhook hook, or runs the command hook with
+// args and the payload in MASSIVEDL_* environment variables. Failures are
+// only logged.
+func runHook(hook string, payload hookPayload, args ...string) {
+	if isWebhook(hook) {
+		postHook(hook, payload)
+		return
+	}
+
+	fields := strings.Fields(hook)
+	cmd := exec.Command(fields[0], append(fields[1:], args...)...)
+	cmd.Env = append(os.Environ(), hookEnv(payload)...)
+	out, err := cmd.CombinedOutput()
+	if len(out) > 0 {
+		log.Printf("[HOOK] %s %s: %s", payload.Event, fields[0], bytes.TrimSpace(out))
+	}
+	if err != nil {
+		log.Printf("[HOOK] %s %s: %v", payload.Event, fields[0], err)
+	}
+}
+
+// postHook posts payload to the webhook url
+func postHook(url string, payload hookPayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Println("[HOOK]", err)
+		return
+	}
+	client := &http.Client{Timeout: notifyTimeout}
+	response, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		log.Printf("[HOOK] %s: %v", payload.Event, err)
+		return
+	}
+	if err = response.Body.Close(); err != nil {
+		log.Printf("error closing response body: %v", err)
+	}
+	if response.StatusCode >= http.StatusMultipleChoices {
+		log.Printf("[HOOK] %s: %s answered %s", payload.Event, url, response.Status)
+	}
+}
+
+// hookEnv returns the environment variables that pass payload to a command
+func hookEnv(payload hookPayload) []string {
+	env := []string{
+		"MASSIVEDL_RUN_ID=" + payload.Run,
+		"MASSIVEDL_EVENT=" + payload.Event,
+	}
+	if payload.Stats != nil {
+		return append(env,
+			"MASSIVEDL_DOWNLOADED="+strconv.Itoa(payload.Stats.Downloaded),
+			"MASSIVEDL_FAILED="+strconv.Itoa(payload.Stats.Failed),
+			"MASSIVEDL_CAPPED="+strconv.Itoa(payload.Stats.Capped),
+			"MASSIVEDL_BYTES="+strconv.FormatUint(payload.Stats.Bytes, 10),
+			"MASSIVEDL_ABORTED="+strconv.FormatBool(payload.Stats.Aborted),
+		)
+	}
+	env = append(env,
+		"MASSIVEDL_URL="+payload.URL,
+		"MASSIVEDL_PATH="+payload.Path,
+		"MASSIVEDL_BYTES="+strconv.FormatUint(payload.Bytes, 10),
+		"MASSIVEDL_STATUS="+strconv.Itoa(payload.Status),
+		"MASSIVEDL_CHECKSUM="+payload.Checksum,
+		"MASSIVEDL_ERROR="+payload.Error,
+	)
+	keys := make([]string, 0, len(payload.Metadata))
+	for key := range payload.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		env = append(env, hookMetaEnv(key)+"="+payload.Metadata[key])
+	}
+	return env
+}
+
+// hookMetaEnv returns the name of the environment variable of the metadata
+// key: MASSIVEDL_META_ and the key in upper case, with the characters that
+// can't be in a name replaced by _
+func hookMetaEnv(key string) string {
+	return "MASSIVEDL_META_" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// checkHook validates the hook of flag name
+func checkHook(name, hook string) {
+	if hook != "" && !isWebhook(hook) && len(strings.Fields(hook)) == 0 {
+		log.Fatalf("invalid -%s %q, expected a webhook url or a command", name, hook)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestRunHookCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the hook is a shell script")
+	}
+	dir, err := ioutil.TempDir("", "massivedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = os.RemoveAll(dir); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	// the hook writes its arguments and environment to out
+	out := filepath.Join(dir, "out")
+	script := filepath.Join(dir, "hook.sh")
+	body := "#!/bin/sh\necho \"$1 $2 $MASSIVEDL_EVENT $MASSIVEDL_STATUS $MASSIVEDL_BYTES $MASSIVEDL_META_ORDER_ID\" > " + out + "\n"
+	if err = ioutil.WriteFile(script, []byte(body), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	payload := hookPayload{Event: hookComplete, URL: "http://example.com/a", Path: "downloads/a", Bytes: 42, Status: 200,
+		Metadata: map[string]string{"order-id": "1234"}}
+	runHook(script, payload, payload.Path, payload.URL)
+
+	data, err := ioutil.ReadFile(out)
+	expected := "downloads/a http://example.com/a complete 200 42 1234"
+	if err != nil || strings.TrimSpace(string(data)) != expected {
+		t.Errorf("expected %q received %q (%v)", expected, data, err)
+	}
+}
+
+func TestPostHook(t *testing.T) {
+	received := make(chan hookPayload, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload hookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+		received <- payload
+	}))
+	defer server.Close()
+
+	postHook(server.URL, hookPayload{Event: hookFailure, URL: "http://example.com/a",
+		Metadata: map[string]string{"order": "1234", "customer": "c-99"}})
+
+	payload := <-received
+	if payload.Event != hookFailure || payload.Metadata["order"] != "1234" || payload.Metadata["customer"] != "c-99" {
+		t.Errorf("expected the failure event with the metadata received %+v", payload)
+	}
+}
+
+func TestHookMetaEnv(t *testing.T) {
+	testCases := []struct {
+		key      string
+		expected string
+	}{
+		{"order", "MASSIVEDL_META_ORDER"},
+		{"Customer_ID", "MASSIVEDL_META_CUSTOMER_ID"},
+		{"order-id.v2", "MASSIVEDL_META_ORDER_ID_V2"},
+		{"grüße", "MASSIVEDL_META_GR__E"},
+	}
+	for _, testCase := range testCases {
+		if received := hookMetaEnv(testCase.key); received != testCase.expected {
+			t.Errorf("%s: expected %s received %s", testCase.key, testCase.expected, received)
+		}
+	}
+}
+
+func TestIsWebhook(t *testing.T) {
+	testCases := []struct {
+		hook     string
+		expected bool
+	}{
+		{"https://example.com/hook", true},
+		{"http://127.0.0.1:8080", true},
+		{"./notify.sh --quiet", false},
+		{"httpie", false},
+	}
+	for _, testCase := range testCases {
+		if received := isWebhook(testCase.hook); received != testCase.expected {
+			t.Errorf("%s: expected %v received %v", testCase.hook, testCase.expected, received)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+//go:build http3
+// +build http3
+
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// http3Transport sends the https requests over HTTP/3 and the urls of the
+// other schemes, like ftp or s3, through base
+type http3Transport struct {
+	base http.RoundTripper
+	h3   *http3.Transport
+}
+
+func (t http3Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	switch req.URL.Scheme {
+	case "https":
+		return t.h3.RoundTrip(req)
+	case "http":
+		return nil, fmt.Errorf("%s: HTTP/3 is only spoken over TLS, -http-version 3 requires https urls", req.URL.Redacted())
+	}
+	return t.base.RoundTrip(req)
+}
+
+// newHTTP3Transport returns the transport of -http-version 3, which speaks
+// QUIC with the TLS settings and connect timeout of the command line
+func newHTTP3Transport(base http.RoundTripper) (http.RoundTripper, error) {
+	tlsConfig := newTLSConfig()
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+
+	h3 := &http3.Transport{
+		TLSClientConfig: tlsConfig,
+		QUICConfig:      &quic.Config{HandshakeIdleTimeout: p.ConnectTimeout},
+	}
+	return http3Transport{base: base, h3: h3}, nil
+}
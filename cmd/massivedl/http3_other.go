@@ -0,0 +1,16 @@
+//go:build !http3
+// +build !http3
+
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// newHTTP3Transport fails in the default build, which only depends on the
+// Go standard library. The QUIC implementation is compiled in with the
+// http3 build tag.
+func newHTTP3Transport(http.RoundTripper) (http.RoundTripper, error) {
+	return nil, errors.New("this build of massivedl has no HTTP/3 support, rebuild it with go build -tags http3")
+}
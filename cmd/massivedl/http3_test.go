@@ -0,0 +1,56 @@
+//go:build http3
+// +build http3
+
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+func TestHTTP3Transport(t *testing.T) {
+	defer func() { p = cmdLineParams{} }()
+	p.TLSInsecure = true
+
+	// the certificate of an httptest server is reused for the QUIC one
+	tlsServer := httptest.NewTLSServer(http.NotFoundHandler())
+	defer tlsServer.Close()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http3.Server{
+		TLSConfig: http3.ConfigureTLSConfig(tlsServer.TLS.Clone()),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(r.Proto))
+		}),
+	}
+	go func() { _ = server.Serve(conn) }()
+	defer server.Close()
+
+	transport, err := newHTTP3Transport(http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: transport}
+
+	response, err := client.Get("https://" + conn.LocalAddr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(response.Body)
+	_ = response.Body.Close()
+	if err != nil || string(body) != "HTTP/3.0" {
+		t.Errorf("expected the request to be sent over HTTP/3.0 received %q (%v)", body, err)
+	}
+
+	if _, err = client.Get("http://" + conn.LocalAddr().String() + "/"); err == nil {
+		t.Error("expected http urls to fail")
+	}
+}
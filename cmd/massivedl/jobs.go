@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// the jobs of entries that don't come from a url list file
+const (
+	jobStdin   = "stdin"
+	jobControl = "control"
+)
+
+// jobWeight is a weight of -job-weights
+type jobWeight struct {
+	pattern string // a path.Match pattern of job names
+	weight  float64
+}
+
+// jobWeights are parsed from -job-weights by compileJobWeights
+var jobWeights []jobWeight
+
+// compileJobWeights parses the "pattern=weight" pairs of -job-weights
+func compileJobWeights() {
+	for _, pair := range p.JobWeights {
+		i := strings.LastIndex(pair, "=")
+		if i <= 0 {
+			log.Fatalf("invalid -job-weights %q, expected pattern=weight", pair)
+		}
+		pattern := strings.TrimSpace(pair[:i])
+		weight, err := strconv.ParseFloat(strings.TrimSpace(pair[i+1:]), 64)
+		if err != nil || weight <= 0 {
+			log.Fatalf("invalid -job-weights %q, the weight must be a positive number", pair)
+		}
+		if _, err = path.Match(pattern, ""); err != nil {
+			log.Fatalf("invalid -job-weights %q: %v", pair, err)
+		}
+		jobWeights = append(jobWeights, jobWeight{pattern: pattern, weight: weight})
+	}
+}
+
+// weightOf returns the weight of the first pattern of -job-weights that
+// matches job, or 1
+func weightOf(job string) float64 {
+	for _, w := range jobWeights {
+		if ok, _ := path.Match(w.pattern, job); ok {
+			return w.weight
+		}
+	}
+	return 1
+}
+
+// jobName returns the job of the entries of the url list at listPath: its
+// file name without the extension
+func jobName(listPath string) string {
+	if listPath == urlFileStdin {
+		return jobStdin
+	}
+	name := filepath.Base(listPath)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// setJob sets the job of entries
+func setJob(entries []dataEntry, job string) {
+	for i := range entries {
+		entries[i].job = job
+	}
+}
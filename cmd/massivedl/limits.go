@@ -0,0 +1,73 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/sizeutil"
+)
+
+// the keys of the limit columns of an entry
+const (
+	limitTimeout = "timeout"
+	limitMaxSize = "max-size"
+	limitRetries = "retries"
+)
+
+// entryLimits override the global limits for a single entry, the zero value
+// keeps all of them
+type entryLimits struct {
+	timeout time.Duration // replaces -max-time
+	maxSize int64         // fails larger files, 0 for no limit
+	retries *int          // replaces -retries
+}
+
+// parseLimit parses a "key=value" limit column into limits. ok is false if
+// column isn't a limit at all.
+func parseLimit(column string, limits *entryLimits) (ok bool, err error) {
+	i := strings.Index(column, "=")
+	if i < 0 {
+		return false, nil
+	}
+	key, value := strings.TrimSpace(column[:i]), strings.TrimSpace(column[i+1:])
+
+	switch key {
+	case limitTimeout:
+		limits.timeout, err = time.ParseDuration(value)
+		if err == nil && limits.timeout <= 0 {
+			err = errors.New("must be positive")
+		}
+	case limitMaxSize:
+		limits.maxSize, err = sizeutil.ParseSize(value)
+	case limitRetries:
+		var n int
+		if n, err = strconv.Atoi(value); err == nil && n < 0 {
+			err = errors.New("must not be negative")
+		}
+		limits.retries = &n
+	default:
+		return false, nil
+	}
+	if err != nil {
+		return true, fmt.Errorf("invalid %s %q: %v", key, value, err)
+	}
+	return true, nil
+}
+
+// formatLimits is the reverse of parseLimit for all limits that are set
+func formatLimits(limits entryLimits) []string {
+	var columns []string
+	if limits.timeout > 0 {
+		columns = append(columns, limitTimeout+"="+limits.timeout.String())
+	}
+	if limits.maxSize > 0 {
+		columns = append(columns, limitMaxSize+"="+strconv.FormatInt(limits.maxSize, 10))
+	}
+	if limits.retries != nil {
+		columns = append(columns, limitRetries+"="+strconv.Itoa(*limits.retries))
+	}
+	return columns
+}
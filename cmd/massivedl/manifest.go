@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/dimkouv/massivedl/internal/checksum"
+	"github.com/dimkouv/massivedl/internal/yaml"
+)
+
+// the formats of the url lists, see -input-format
+const (
+	inputAuto  = "auto"  // json or yaml by the extension of the file, csv otherwise
+	inputCSV   = "csv"   // a url or mirrors per line, followed by optional columns
+	inputLines = "lines" // a url per line, taken as it is
+	inputJSON  = "json"  // an array of manifest entries or a stream of them
+	inputYAML  = "yaml"  // a list of manifest entries
+)
+
+// manifestEntry is an entry of a JSON or YAML manifest
+type manifestEntry struct {
+	URL      string                `json:"url"`
+	Mirrors  []string              `json:"mirrors"`
+	Name     string                `json:"name"` // output path relative to -outdir
+	Headers  map[string]scalarList `json:"headers"`
+	Checksum string                `json:"checksum"`
+	Metadata map[string]scalar     `json:"metadata"`
+	Timeout  scalar                `json:"timeout"`
+	MaxSize  scalar                `json:"maxSize"`
+	Retries  *int                  `json:"retries"`
+	Priority int                   `json:"priority"`
+}
+
+// scalar is a string that may also be written as a number or a bool, e.g.
+// the value of a header or a size
+type scalar string
+
+func (s *scalar) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case string:
+		*s = scalar(v)
+	case float64, bool:
+		*s = scalar(strings.TrimSpace(string(data)))
+	default:
+		return fmt.Errorf("expected a string, found %s", data)
+	}
+	return nil
+}
+
+// scalarList is a list of scalars, of which a single one may be written
+// without the list
+type scalarList []scalar
+
+func (l *scalarList) UnmarshalJSON(data []byte) error {
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		return json.Unmarshal(data, (*[]scalar)(l))
+	}
+	var s scalar
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*l = scalarList{s}
+	return nil
+}
+
+// inputFormat returns the format of the url list name: -input-format, or the
+// one its extension suggests
+func inputFormat(name string) string {
+	if p.InputFormat != inputAuto && p.InputFormat != "" {
+		return p.InputFormat
+	}
+	switch strings.ToLower(path.Ext(name)) {
+	case ".json", ".jsonl", ".ndjson":
+		return inputJSON
+	case ".yaml", ".yml":
+		return inputYAML
+	}
+	return inputCSV
+}
+
+// readEntriesFormat reads the entries of a url list in format from r. Their
+// indexes start at firstIndex. Invalid entries are logged and skipped.
+func readEntriesFormat(r io.Reader, format string, firstIndex int) ([]dataEntry, error) {
+	var entries []dataEntry
+	add := func(batch []dataEntry) error {
+		for _, entry := range batch {
+			entry.index = firstIndex + len(entries)
+			entries = append(entries, entry)
+		}
+		return nil
+	}
+
+	var err error
+	switch format {
+	case inputJSON:
+		err = decodeJSONManifest(r, add)
+	case inputYAML:
+		err = decodeYAMLManifest(r, add)
+	case inputLines:
+		entries, err = readLines(r, firstIndex)
+	default:
+		entries, err = readEntries(r, firstIndex)
+	}
+	if entries == nil {
+		entries = make([]dataEntry, 0)
+	}
+	return entries, err
+}
+
+// readLines reads a url per line from r
+func readLines(r io.Reader, firstIndex int) ([]dataEntry, error) {
+	entries := make([]dataEntry, 0)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		u, err := url.Parse(line)
+		if err != nil {
+			log.Printf("%s: %s\n", line, err)
+			continue
+		}
+		entries = append(entries, dataEntry{url: u, index: firstIndex + len(entries)})
+	}
+	return entries, scanner.Err()
+}
+
+// decodeJSONManifest passes the entries of the JSON manifest in r to add as
+// they are read. The manifest is an array of entries, or a stream of
+// entries or arrays of them like one object per line.
+func decodeJSONManifest(r io.Reader, add func([]dataEntry) error) error {
+	dec := json.NewDecoder(r)
+	for {
+		var value json.RawMessage
+		if err := dec.Decode(&value); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		items := []json.RawMessage{value}
+		if bytes.HasPrefix(bytes.TrimSpace(value), []byte("[")) {
+			if err := json.Unmarshal(value, &items); err != nil {
+				return err
+			}
+		}
+		if err := add(decodeManifestEntries(items)); err != nil {
+			return err
+		}
+	}
+}
+
+// decodeYAMLManifest passes the entries of the YAML manifest in r, a list of
+// entries, to add
+func decodeYAMLManifest(r io.Reader, add func([]dataEntry) error) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	document, err := yaml.Unmarshal(data)
+	if err != nil || document == nil {
+		return err
+	}
+	list, ok := document.([]interface{})
+	if !ok {
+		return errors.New("expected a list of entries")
+	}
+
+	// the entries are decoded like those of a JSON manifest
+	items := make([]json.RawMessage, 0, len(list))
+	for _, item := range list {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		items = append(items, data)
+	}
+	return add(decodeManifestEntries(items))
+}
+
+// decodeManifestEntries decodes the manifest entries of items, invalid ones
+// are logged and skipped
+func decodeManifestEntries(items []json.RawMessage) []dataEntry {
+	entries := make([]dataEntry, 0, len(items))
+	for _, item := range items {
+		dec := json.NewDecoder(bytes.NewReader(item))
+		dec.DisallowUnknownFields()
+
+		var m manifestEntry
+		err := dec.Decode(&m)
+		var entry dataEntry
+		if err == nil {
+			entry, err = m.toEntry()
+		}
+		if err != nil {
+			log.Printf("%s: %s\n", item, err)
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// toEntry validates m and converts it into the entry to download
+func (m manifestEntry) toEntry() (dataEntry, error) {
+	var entry dataEntry
+	var err error
+
+	if m.URL == "" {
+		return entry, errors.New("no url given")
+	}
+	if entry.url, err = url.Parse(m.URL); err != nil {
+		return entry, err
+	}
+	for _, mirror := range m.Mirrors {
+		u, err := url.Parse(mirror)
+		if err != nil {
+			return entry, err
+		}
+		entry.mirrors = append(entry.mirrors, u)
+	}
+
+	if m.Name != "" {
+		if entry.outputName, err = checkOutputName(m.Name); err != nil {
+			return entry, err
+		}
+	}
+
+	if len(m.Headers) > 0 {
+		entry.header = make(http.Header)
+		names := make([]string, 0, len(m.Headers))
+		for name := range m.Headers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, value := range m.Headers[name] {
+				entry.header.Add(name, string(value))
+			}
+		}
+	}
+
+	if m.Checksum != "" {
+		if entry.checksum, err = checksum.Parse(m.Checksum); err != nil {
+			return entry, err
+		}
+	}
+
+	if len(m.Metadata) > 0 {
+		entry.metadata = make(map[string]string, len(m.Metadata))
+		for key, value := range m.Metadata {
+			entry.metadata[key] = string(value)
+		}
+	}
+
+	// the limits are checked like the columns of a csv list
+	for key, value := range map[string]scalar{limitTimeout: m.Timeout, limitMaxSize: m.MaxSize} {
+		if value != "" {
+			if _, err = parseLimit(key+"="+string(value), &entry.limits); err != nil {
+				return entry, err
+			}
+		}
+	}
+	if m.Retries != nil {
+		if *m.Retries < 0 {
+			return entry, fmt.Errorf("invalid retries %d: must not be negative", *m.Retries)
+		}
+		retries := *m.Retries
+		entry.limits.retries = &retries
+	}
+
+	entry.priority = m.Priority
+	return entry, nil
+}
+
+// checkOutputName returns the cleaned output name of an entry, as given by a
+// manifest or failed.csv, or an error if it leaves the output directory
+func checkOutputName(name string) (string, error) {
+	clean := path.Clean(name)
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("name %q is outside of the output directory", name)
+	}
+	return clean, nil
+}
+
+// sortByPriority orders entries by decreasing priority, entries of the same
+// priority keep their order
+func sortByPriority(entries []dataEntry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].priority > entries[j].priority
+	})
+}
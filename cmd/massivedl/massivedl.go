@@ -2,8 +2,10 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
@@ -14,38 +16,210 @@ import (
 	"os"
 	"os/signal"
 	"path"
-	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
+	"github.com/dimkouv/massivedl/internal/jq"
 	"github.com/dimkouv/massivedl/internal/logging"
-
+	"github.com/dimkouv/massivedl/internal/nametemplate"
+	"github.com/dimkouv/massivedl/internal/ndjson"
+	"github.com/dimkouv/massivedl/internal/parquet"
+	"github.com/dimkouv/massivedl/internal/proctitle"
+	"github.com/dimkouv/massivedl/internal/proxypool"
+	"github.com/dimkouv/massivedl/internal/scrape"
+
+	"github.com/dimkouv/massivedl/internal/checksum"
 	"github.com/dimkouv/massivedl/internal/clitool"
 
+	"github.com/dimkouv/massivedl/internal/expr"
 	"github.com/dimkouv/massivedl/internal/fileutil"
+	"github.com/dimkouv/massivedl/internal/h2pool"
+	"github.com/dimkouv/massivedl/internal/sharelink"
+	"github.com/dimkouv/massivedl/internal/simulate"
+	"github.com/dimkouv/massivedl/internal/sizeutil"
 	"github.com/dimkouv/massivedl/internal/statistics"
 	"github.com/dimkouv/massivedl/internal/timeutil"
+	"github.com/dimkouv/massivedl/internal/vcr"
+	"github.com/dimkouv/massivedl/pkg/massivedl"
 )
 
 // a dataEntry has the required information to download a file
 // a dataEntry is normally loaded from a .csv file and is stored in a slice
 type dataEntry struct {
-	name string
-	url  string
+	name     string // path of the output file
+	index    int    // position of the entry in the input list
+	url      *url.URL
+	mirrors  []*url.URL        // other urls of the same file, tried when url fails
+	header   http.Header       // request headers of this entry
+	checksum checksum.Checksum // expected checksum, if one was given
+	metadata map[string]string // passed on to the reports untouched
+	limits   entryLimits       // timeout, max-size and retries of this entry
+
+	outputName string // path relative to -outdir given by a manifest, instead of -name-strategy
+	priority   int    // entries with higher priorities are queued first
+	job        string // name of the url list the entry comes from, see -fair-share
 }
 
 // cmdLineParams - Configuration struct
 type cmdLineParams struct {
-	ConcurrentRequests int           `json:"concurrentRequests"`
-	EntriesFilepath    string        `json:"entriesFilepath"`
-	OutputDir          string        `json:"outputDir"`
-	MaxRetries         int           `json:"maxRetries"`
-	Offset             int           `json:"offset"`
-	DelayPerRequest    time.Duration `json:"delayPerRequest"`
-	UserAgent          string        `json:"userAgent"`
-	SkipExisting       bool          `json:"skipExisting"`
-	UseChecksumAsPath  bool          `json:"useChecksumAsPath"`
+	ConcurrentRequests    int           `json:"concurrentRequests"`
+	EntriesFilepath       string        `json:"entriesFilepath"`
+	RetryFailedPath       string        `json:"retryFailedPath"`
+	FromSitemaps          []string      `json:"fromSitemaps"`
+	FromFeeds             []string      `json:"fromFeeds"`
+	FollowSitemapIndex    bool          `json:"followSitemapIndex"`
+	Scrapes               []string      `json:"scrapes"`
+	LinkSelector          string        `json:"linkSelector"`
+	LinkRegex             string        `json:"linkRegex"`
+	RecurseDepth          int           `json:"recurseDepth"`
+	SpanHosts             string        `json:"spanHosts"`
+	InputFormat           string        `json:"inputFormat"`
+	WatchDir              string        `json:"watchDir"`
+	WatchInterval         time.Duration `json:"watchInterval"`
+	OutputDir             string        `json:"outputDir"`
+	OutputDirs            []string      `json:"outputDirs"` // the further directories of -outdir
+	MaxRetries            int           `json:"maxRetries"`
+	ConnectRetries        int           `json:"connectRetries"`
+	RetryOn               string        `json:"retryOn"`
+	Offset                int           `json:"offset"` // unused, resuming matches saveEntry.StateFile
+	DelayPerRequest       time.Duration `json:"delayPerRequest"`
+	UserAgent             string        `json:"userAgent"`
+	Headers               []string      `json:"headers"`
+	CookieJar             string        `json:"cookieJar"`
+	HostBundles           string        `json:"hostBundles"`
+	DomainRules           string        `json:"domainRules"`
+	SkipExisting          bool          `json:"skipExisting"`
+	Refetch               bool          `json:"refetch"`
+	Conditional           bool          `json:"conditional"`
+	Refresh               time.Duration `json:"refresh"`
+	Report                string        `json:"report"`
+	TLSReport             string        `json:"tlsReport"`
+	SumsFile              string        `json:"sumsFile"`
+	SumsSignKey           string        `json:"sumsSignKey"`
+	DiscardPartial        bool          `json:"discardPartial"` // inverted -keep-partial, so that older parameter files keep their parts
+	Stagger               bool          `json:"stagger"`
+	ActiveHours           string        `json:"activeHours"`
+	ActiveCron            string        `json:"activeCron"`
+	UseChecksumAsPath     bool          `json:"useChecksumAsPath"`
+	NameTemplate          string        `json:"nameTemplate"`
+	NameStrategy          string        `json:"nameStrategy"`
+	OnConflict            string        `json:"onConflict"`
+	TrustServerNames      bool          `json:"trustServerNames"`
+	MirrorSelect          string        `json:"mirrorSelect"`
+	Dedup                 string        `json:"dedup"`
+	Progress              string        `json:"progress"`
+	ProgressInterval      time.Duration `json:"progressInterval"`
+	ProgressFile          string        `json:"progressFile"`
+	Autosave              time.Duration `json:"autosave"`
+	AutosaveKeep          int           `json:"autosaveKeep"`
+	ProcessTitle          bool          `json:"processTitle"`
+	ControlAddr           string        `json:"controlAddr"`
+	ControlToken          string        `json:"controlToken"`
+	Notify                string        `json:"notify"`
+	OnComplete            string        `json:"onComplete"`
+	OnFailure             string        `json:"onFailure"`
+	OnFinish              string        `json:"onFinish"`
+	Session               string        `json:"session"`
+	DebugQueue            time.Duration `json:"debugQueue"`
+	ProfileRun            bool          `json:"profileRun"`
+	Extract               bool          `json:"extract"`
+	ExtractDelete         bool          `json:"extractDelete"`
+	ExtractMaxSize        int64         `json:"extractMaxSize"`
+	Sink                  string        `json:"sink"`
+	SinkKeepLocal         bool          `json:"sinkKeepLocal"`
+	NDJSONDir             string        `json:"ndjsonDir"`
+	NDJSONMaxSize         int64         `json:"ndjsonMaxSize"`
+	CaptureHeaders        []string      `json:"captureHeaders"`
+	MetadataSidecar       bool          `json:"metadataSidecar"`
+	ParquetDir            string        `json:"parquetDir"`
+	ParquetMaxBody        int64         `json:"parquetMaxBody"`
+	ParquetRowsPerFile    int           `json:"parquetRowsPerFile"`
+	TransformJQ           string        `json:"transformJQ"`
+	Segments              int           `json:"segments"`
+	SegmentMinSize        int64         `json:"segmentMinSize"`
+	Preflight             bool          `json:"preflight"`
+	DryRun                bool          `json:"dryRun"`
+	MinFreeSpace          int64         `json:"minFreeSpace"`
+	ToMemory              int64         `json:"toMemory"`
+	IncludeRegex          string        `json:"includeRegex"`
+	ExcludeRegex          string        `json:"excludeRegex"`
+	AcceptExt             []string      `json:"acceptExt"`
+	RejectExt             []string      `json:"rejectExt"`
+	MinSize               int64         `json:"minSize"`
+	AcceptContentTypes    []string      `json:"acceptContentTypes"`
+	RejectContentTypes    []string      `json:"rejectContentTypes"`
+	MaxSize               int64         `json:"maxSize"`
+	Simulate              bool          `json:"simulate"`
+	SimulateLatency       time.Duration `json:"simulateLatency"`
+	SimulateFailRate      float64       `json:"simulateFailRate"`
+	SimulateMinSize       int64         `json:"simulateMinSize"`
+	SimulateMaxSize       int64         `json:"simulateMaxSize"`
+	SimulateSeed          int64         `json:"simulateSeed"`
+	Chaos                 string        `json:"chaos"`
+	LimitRate             int64         `json:"limitRate"`
+	LimitRatePerConn      int64         `json:"limitRatePerConn"`
+	FairShare             bool          `json:"fairShare"`
+	JobWeights            []string      `json:"jobWeights"`
+	PriorityRegexes       []string      `json:"priorityRegexes"`
+	Shuffle               bool          `json:"shuffle"`
+	SortBySize            bool          `json:"sortBySize"`
+	RecordDir             string        `json:"recordDir"`
+	ReplayDir             string        `json:"replayDir"`
+	ChecksumFailAction    string        `json:"checksumFailAction"`
+	IgnoreDigestHeaders   bool          `json:"ignoreDigestHeaders"` // inverse of -verify-digest-headers
+	SuccessIf             string        `json:"successIf"`
+	NegativeCacheTTL      time.Duration `json:"negativeCacheTTL"`
+	IgnoreNegativeCache   bool          `json:"ignoreNegativeCache"`
+	SeenFilter            string        `json:"seenFilter"`
+	SeenCapacity          uint64        `json:"seenCapacity"`
+	SeenFalsePositiveRate float64       `json:"seenFalsePositiveRate"`
+	Resolve               []string      `json:"resolve"`
+	UnixSocket            string        `json:"unixSocket"`
+	LocalAddr             string        `json:"localAddr"`
+	DialKeepAlive         time.Duration `json:"dialKeepAlive"`
+	FallbackDelay         time.Duration `json:"fallbackDelay"`
+	ConnectTimeout        time.Duration `json:"connectTimeout"`
+	ResponseTimeout       time.Duration `json:"responseTimeout"`
+	MaxTime               time.Duration `json:"maxTime"`
+	MinSpeed              int64         `json:"minSpeed"`
+	MinSpeedTime          time.Duration `json:"minSpeedTime"`
+	MaxIdleConnsPerHost   int           `json:"maxIdleConnsPerHost"`
+	DisableHTTP2          bool          `json:"disableHTTP2"`
+	HTTPVersion           string        `json:"httpVersion"`
+	H2Streams             int           `json:"h2Streams"`
+	TLSInsecure           bool          `json:"tlsInsecure"`
+	CACert                string        `json:"caCert"`
+	FallbackPorts         []string      `json:"fallbackPorts"`
+	Debug                 bool          `json:"debug"`
+	ShareLinks            bool          `json:"shareLinks"`
+	Proxy                 string        `json:"proxy"`
+	ProxyFile             string        `json:"proxyFile"`
+	ProxyPAC              string        `json:"proxyPAC"`
+	ProxyUser             string        `json:"proxyUser"`
+	Tor                   bool          `json:"tor"`
+	TorProxy              string        `json:"torProxy"`
+	TorIsolateHosts       bool          `json:"torIsolateHosts"`
+	TorControl            string        `json:"torControl"`
+	TorControlPassword    string        `json:"torControlPassword"`
+	TorNewnym             time.Duration `json:"torNewnym"`
+	ProxyRotate           string        `json:"proxyRotate"`
+	ProxyMaxFailures      int           `json:"proxyMaxFailures"`
+	SSHKey                string        `json:"sshKey"`
+	SSHAgentForward       bool          `json:"sshAgentForward"`
+	SSHOptions            []string      `json:"sshOptions"`
+	MaxPerHost            int           `json:"maxPerHost"`
+	DelayPerHost          time.Duration `json:"delayPerHost"`
+	MaxFilesPerHost       int           `json:"maxFilesPerHost"`
+	MaxBytesPerHost       int64         `json:"maxBytesPerHost"`
+	TargetThroughput      int64         `json:"targetThroughput"`
+	MaxErrorRate          float64       `json:"maxErrorRate"`
+	MaxWorkers            int           `json:"maxWorkers"`
+	AbortFailureRate      float64       `json:"abortFailureRate"`
+	AbortMinSample        int           `json:"abortMinSample"`
+	EgressCostPerGB       float64       `json:"egressCostPerGB"`
 }
 
 // saveEntry - data required for saving/loading progress
@@ -53,62 +227,777 @@ type saveEntry struct {
 	WorkingDirectory string                `json:"workingDirectory"`
 	Parameters       cmdLineParams         `json:"cmdLineParams"`
 	Stats            statistics.Statistics `json:"stats"`
+	CommandLine      []string              `json:"commandLine"`
+	StateFile        string                `json:"stateFile"`           // the state of every url, see urlStates
+	Completed        []string              `json:"completed,omitempty"` // urls that were downloaded, written by older versions
 }
 
 var stats statistics.Statistics
 var p cmdLineParams
-var stopWorking bool // workers check this flag before taking a job
 
-func loadURLs(urlFile string) ([]*url.URL, error) {
+// runCtx is canceled on SIGINT: the workers take no more jobs, the running
+// requests are aborted and the background loops stop
+var runCtx, cancelRun = context.WithCancel(context.Background())
+
+// stopped reports whether the run was interrupted
+func stopped() bool {
+	return runCtx.Err() != nil
+}
+
+// sleep waits for d and reports whether the run goes on, it returns early
+// when the run is interrupted
+func sleep(d time.Duration) bool {
+	select {
+	case <-runCtx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// transport is used by every http.Client that downloads files
+var transport http.RoundTripper
+
+// httpClient sends the download requests, all workers share it and with it
+// the idle connections of transport
+var httpClient *http.Client
+
+// transformQuery reshapes every downloaded JSON document, if set
+var transformQuery *jq.Query
+
+// ndjsonSink collects the responses instead of saving them as files, if set
+var ndjsonSink *ndjson.Writer
+
+// parquetSink collects the responses into Parquet files, if set
+var parquetSink *parquet.Writer
+
+// loadEntries loads the entries to download from urlFile, in the format of
+// inputFormat. In the csv format every line holds a url, or several mirror
+// urls of the same file separated by '|', optionally followed by comma
+// separated columns with the expected checksum of the file prefixed with its
+// algorithm (md5:, sha1: or sha256:) and with request headers of the entry
+// ("Name: value").
+func loadEntries(urlFile string) ([]dataEntry, error) {
 	fh, err := os.Open(urlFile)
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		if err = fh.Close(); err != nil {
+			log.Printf("unable to close file: %v", err)
+		}
+	}()
 
-	urls := make([]*url.URL, 0)
-	scanner := bufio.NewScanner(fh)
+	return readEntriesFormat(fh, inputFormat(urlFile), 0)
+}
+
+// readEntries reads entries in the csv format of loadEntries from r. Their
+// indexes start at firstIndex.
+func readEntries(r io.Reader, firstIndex int) ([]dataEntry, error) {
+	var err error
+	entries := make([]dataEntry, 0)
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
-		u, err := url.Parse(line)
+		if line == "" {
+			continue
+		}
+
+		var entry dataEntry
+		hasPriority := false
+
+		// urls may contain commas, so only split off the last columns while
+		// they actually are a checksum, metadata, a limit or a header
+		for {
+			i := strings.LastIndex(line, ",")
+			if i < 0 {
+				break
+			}
+			column := strings.TrimSpace(line[i+1:])
+
+			if checksum.HasPrefix(column) && entry.checksum.IsZero() {
+				if entry.checksum, err = checksum.Parse(column); err != nil {
+					break
+				}
+			} else if strings.HasPrefix(column, metaPrefix) {
+				var key, value string
+				if key, value, err = parseMetadata(column[len(metaPrefix):]); err != nil {
+					break
+				}
+				if entry.metadata == nil {
+					entry.metadata = make(map[string]string)
+				}
+				// the columns are read from the end, the last one of a key wins
+				if _, ok := entry.metadata[key]; !ok {
+					entry.metadata[key] = value
+				}
+			} else if strings.HasPrefix(column, priorityPrefix) {
+				// the columns are read from the end, the last priority wins
+				if !hasPriority {
+					value := strings.TrimSpace(column[len(priorityPrefix):])
+					if entry.priority, err = strconv.Atoi(value); err != nil {
+						err = fmt.Errorf("invalid priority %q", value)
+						break
+					}
+					hasPriority = true
+				}
+			} else if ok, limitErr := parseLimit(column, &entry.limits); ok {
+				if err = limitErr; err != nil {
+					break
+				}
+			} else if name, value, headerErr := parseHeader(column); headerErr == nil && strings.Contains(column, ": ") {
+				if entry.header == nil {
+					entry.header = make(http.Header)
+				}
+				entry.header[http.CanonicalHeaderKey(name)] = append([]string{value}, entry.header.Values(name)...)
+			} else {
+				break
+			}
+			line = strings.TrimSpace(line[:i])
+		}
 		if err != nil {
 			log.Printf("%s: %s\n", line, err)
+			continue
+		}
+
+		if entry.url, entry.mirrors, err = parseURLs(line); err != nil {
+			log.Printf("%s: %s\n", line, err)
+			continue
 		}
 
-		urls = append(urls, u)
+		entry.index = firstIndex + len(entries)
+		entries = append(entries, entry)
 	}
 
-	return urls, nil
+	return entries, scanner.Err()
 }
 
 func parseCmdLineParams() {
 	var version = flag.Bool("version", false, "Print version info")
 	var loadedFile = flag.String("load", "", "Saved progress file to load")
+	var configFlag = flag.String("config", "", "Config file with default flags and profiles (default: ~/.config/massivedl/config.yaml, none to skip it)")
+	var profileFlag = flag.String("profile", "", "Profile of the config file whose flags to use")
+	var session = flag.String("session", "", "Name of the run, its progress is saved as ~/.massivedl/<name>.session and continued with massivedl sessions resume <name>")
 	var entriesFilepath = flag.String("urlfile", "", "Input downloads csv file")
+	var inputFormatName = flag.String("input-format", inputAuto, "Format of the url lists: csv, lines, json, yaml or auto (json and yaml by the extension, csv otherwise)")
+	var watchDir = flag.String("watch", "", "Keep running and download the url lists that are dropped into this directory")
+	var watchInterval = flag.Duration("watch-interval", 5*time.Second, "How often -watch looks for new url lists")
+	var retryFailedPath = flag.String("retry-failed", "", "Only download the entries of a failed.csv file from an earlier run")
+	var fromSitemaps stringsFlag
+	flag.Var(&fromSitemaps, "from-sitemap", "Download the pages of the sitemap at this url (repeatable)")
+	var fromFeeds stringsFlag
+	flag.Var(&fromFeeds, "from-feed", "Download the enclosures, or else the links, of the items of the RSS or Atom feed at this url (repeatable)")
+	var followSitemapIndex = flag.Bool("follow-sitemap-index", true, "Also read the sitemaps listed by a sitemap index file")
+	var scrapes stringsFlag
+	flag.Var(&scrapes, "scrape", "Download the links of the HTML page at this url that match -link-selector and -link-regex (repeatable)")
+	var linkSelector = flag.String("link-selector", "a", "CSS selector of the elements whose links -scrape downloads, e.g. a[href$=\".pdf\"]")
+	var linkRegex = flag.String("link-regex", "", "Only download the links found by -scrape that match this regular expression")
+	var recurseDepth = flag.Int("recurse-depth", 0, "Mirror the sites of the urls: follow the links of the downloaded pages up to this many levels (0 to follow none)")
+	var spanHosts = flag.String("span-hosts", "", "Comma separated host patterns, e.g. *.example.com, that -recurse-depth may follow links to besides the hosts of the urls (* for any)")
 	var concurrentRequests = flag.Int("workers", 20, "Number of parallel requests")
-	var outputDir = flag.String("outdir", "downloads", "Directory to place downloads")
+	var outputDir = flag.String("outdir", "downloads", "Directory to place downloads, or comma separated directories to spread them over by free space")
 	var maxRetries = flag.Int("retries", 3, "Number of retries for failed downloads")
+	var retryOn = flag.String("retry-on", defaultRetryOn, "Status codes that are retried, e.g. 408,429,5xx; other error statuses fail at once")
+	var connectRetries = flag.Int("connect-retries", 1, "Number of retries for downloads that failed to connect (DNS, refused, TLS handshake)")
 	var delayPerRequest = flag.Duration("delay", 1*time.Second, "Delay per request")
-	var userAgent = flag.String("useragent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_6) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.0.2 Safari/605.1.15", "User Agent to use")
+	var activeHours = flag.String("active-hours", "", "Only download within these daily time windows, e.g. 23:00-07:00 or 09:00-12:00,14:00-17:00 (local time)")
+	var activeCron = flag.String("active-cron", "", "Only download in the minutes matching this cron expression, e.g. '* 0-6 * * 1-5' (local time)")
+	var stagger = flag.Bool("stagger", false, "Spread the first requests of the workers over the -delay interval")
+	var userAgent = flag.String("useragent", "", "User Agent to use (default: a Safari User-Agent followed by massivedl (run=<run id>))")
+	var runIDFlag = flag.String("run-id", "", "Id of this run in the User-Agent and the log file (default: random)")
+	var headers stringsFlag
+	flag.Var(&headers, "header", "Extra request header \"Name: value\" (repeatable)")
+	var cookieJarPath = flag.String("cookie-jar", "", "Send the cookies of this Netscape format cookie file")
+	var hostBundlesPath = flag.String("host-bundles", "", "JSON or YAML file with the Referer, cookies, User-Agent and headers to send to each host")
+	var domainRulesPath = flag.String("domain-rules", "", "JSON or YAML file with the workers, delay, headers, authentication and proxy of each host")
 	var skipExisting = flag.Bool("skip-existing", true, "Don't load files that already exist locally")
+	var refetch = flag.Bool("refetch", false, "Download every url again instead of linking the file an earlier run saved from it elsewhere")
+	var conditional = flag.Bool("conditional", false, "Remember the ETag and Last-Modified of downloaded files and only download them again if they changed")
+	var refresh = flag.Duration("refresh", 0, "Keep checking the urls for changes at this interval and replace the files that changed, until interrupted (implies -conditional)")
+	var sumsFile = flag.String("sums", "", "Write the sha256 checksums of the downloaded files to this SHA256SUMS file at the end of the run")
+	var sumsSignKey = flag.String("sums-sign-key", "", "Ed25519 private key (PKCS #8 PEM) to sign the -sums file with, the signature is written to <file>.sig")
+	var reportSpec = flag.String("report", "", "Append the result of every download to this file, e.g. json:results.ndjson for one JSON object per line")
+	var tlsReportPath = flag.String("tls-report", "", "Append the TLS certificate (issuer, expiry, SANs) of every host contacted to this NDJSON file")
+	var keepPartial = flag.Bool("keep-partial", true, "Keep the .part files of failed downloads so that a later run resumes them")
 	var useChecksumAsPath = flag.Bool("checksum-path", false, "Use the SHA checksum of the URL as file name locally")
+	var nameTemplate = flag.String("name-template", "", "Template for the output paths, e.g. {host}/{path} (placeholders: "+strings.Join(nametemplate.Placeholders, ", ")+")")
+	var nameStrategy = flag.String("name-strategy", "basename", "How files are named: "+strings.Join(massivedl.NamerStrategies(), ", ")+", e.g. template:{host}/{path}")
+	var onConflict = flag.String("on-conflict", "rename", "What to do when several urls are saved under the same name: skip, overwrite, rename or error")
+	var trustServerNames = flag.Bool("trust-server-names", false, "Name files after their Content-Disposition header or the url they were redirected to")
+	var transformJQ = flag.String("transform-jq", "", "jq expression applied to every downloaded JSON document before it is saved, e.g. '.items[] | {id, name}'")
+	var extractFlag = flag.Bool("extract", false, "Unpack downloaded .zip, .tar.gz and .tar.zst archives into a directory named after the archive")
+	var extractDelete = flag.Bool("extract-delete", false, "Remove the archives that -extract unpacked")
+	var extractMaxSize = flag.String("extract-max-size", "10GB", "Stop unpacking an archive once its files take more than this, 0 for no limit")
+	var sink = flag.String("sink", "", "Upload completed downloads to this s3://, gs://, sftp:// or scp:// directory")
+	var sinkKeepLocal = flag.Bool("sink-keep-local", false, "Keep the local copy of files uploaded to -sink")
+	var progress = flag.String("progress", "table", "How progress is printed: table (redrawn in place), line (one line per change), none or tui (a progress bar per worker)")
+	var notifyURL = flag.String("notify", "", "POST a JSON alert to this webhook when the run needs attention, e.g. when the disk is full")
+	var onComplete = flag.String("on-complete", "", "Webhook url to POST a JSON event to, or command to run with the path and url as arguments, as each file is downloaded")
+	var onFailure = flag.String("on-failure", "", "Webhook url or command like -on-complete, run for each file that failed")
+	var onFinish = flag.String("on-finish", "", "Webhook url or command like -on-complete, run once with the statistics at the end of the run")
+	var controlAddr = flag.String("control-addr", "", "Serve an HTTP API on this address, e.g. 127.0.0.1:8089, to query and control the run")
+	var controlToken = flag.String("control-token", "", "Bearer token the control API requires (default: a random one, printed at the start)")
+	var debugQueue = flag.Duration("debug-queue", 0, "Log the queue depth, the state of every host and what the workers wait for at this interval")
+	var profileRun = flag.Bool("profile-run", false, "Print where the time of the workers went at the end of the run and which settings to change")
+	var processTitle = flag.Bool("process-title", false, "Show the progress in the command line of the process, e.g. in ps and top (Linux)")
+	var tuiFlag = flag.Bool("tui", false, "Show a progress bar for every worker, the same as -progress tui")
+	var progressInterval = flag.Duration("progress-interval", 500*time.Millisecond, "How often the progress is checked for changes")
+	var autosaveInterval = flag.Duration("autosave", 0, "Save the progress to ~/.massivedl at this interval, so that a run killed by a crash or power loss can be continued with -load")
+	var autosaveKeep = flag.Int("autosave-keep", 3, "Number of -autosave snapshots that are kept")
+	var progressFile = flag.String("progress-file", "", "Keep writing the progress (counts, speeds, ETA, active downloads) as JSON to this file")
+	var dedup = flag.String("dedup", dedupURL, "Drop duplicates: off, url (entries with the same normalized url) or content (also hard-link files with the same content)")
+	var mirrorSelect = flag.String("mirror-select", "order", "Which mirror of an entry is tried first: order (as listed) or fastest")
+	var ndjsonDir = flag.String("ndjson-dir", "", "Append all responses as records to rotating NDJSON files in this directory instead of saving one file per url")
+	var ndjsonMaxSize = flag.String("ndjson-max-size", "100MB", "Size after which a new NDJSON file is started")
+	var metadataSidecar = flag.Bool("metadata-sidecar", false, "Save the meta: columns of every entry as JSON next to its file")
+	var captureHeaders = flag.String("capture-headers", "", "Comma separated response headers to save, e.g. x-request-id,content-md5,x-amz-* (next to the files or in the NDJSON/Parquet records)")
+	var parquetDir = flag.String("parquet-dir", "", "Collect the responses into Parquet files in this directory instead of saving one file per url")
+	var parquetMaxBody = flag.String("parquet-max-body", "64KB", "Bodies larger than this are left out of the Parquet files, only their metadata is kept")
+	var parquetRowsPerFile = flag.Int("parquet-rows-per-file", 10000, "Number of responses per Parquet file")
+	var segments = flag.Int("segments", 1, "Number of parallel connections used for a single large file")
+	var preflight = flag.Bool("preflight", false, "Check that the announced sizes of all files fit on the disk before downloading")
+	var dryRunFlag = flag.Bool("dry-run", false, "Only check every url with a HEAD request and print status, size, content type and output path")
+	var minFreeSpace = flag.String("min-free-space", "0", "Pause the downloads while less than this space is free, e.g. 1GB")
+	var includeRegex = flag.String("include-regex", "", "Only download urls matching this regular expression")
+	var excludeRegex = flag.String("exclude-regex", "", "Don't download urls matching this regular expression")
+	var acceptExt = flag.String("accept-ext", "", "Only download urls with these comma separated file extensions, e.g. jpg,png")
+	var rejectExt = flag.String("reject-ext", "", "Don't download urls with these comma separated file extensions")
+	var acceptContentType = flag.String("accept-content-type", "", "Only save responses with these comma separated content types, e.g. application/zip,image/*")
+	var rejectContentType = flag.String("reject-content-type", "", "Don't save responses with these comma separated content types, e.g. text/html")
+	var minSize = flag.String("min-size", "0", "Skip files smaller than this, by the size a HEAD request announces")
+	var maxSize = flag.String("max-size", "0", "Skip files larger than this, by the size a HEAD request announces, e.g. 100MB")
+	var abortFailureRate = flag.String("abort-on-failure-rate", "0", "Abort the run once this share of the downloads failed, e.g. 50%, 0 to never abort")
+	var abortMinSample = flag.Int("abort-min-sample", 100, "Number of finished downloads before -abort-on-failure-rate is evaluated")
+	var egressCost = flag.String("egress-cost", "", "Estimate the egress cost of the downloaded bytes at this price in $ per GB, or at that of aws, gcp or azure")
+	var toMemory = flag.String("to-memory", "0", "Receive files up to this size into memory and write them once complete, without a .part file, e.g. 1MB")
+	var segmentMinSize = flag.String("segment-min-size", "50MB", "Minimum file size for a segmented download")
+	var simulate = flag.Bool("simulate", false, "Simulate the downloads without network access")
+	var simulateLatency = flag.Duration("simulate-latency", 100*time.Millisecond, "Average latency of simulated responses")
+	var simulateFailRate = flag.Float64("simulate-failure-rate", 0.1, "Probability (0-1) that a simulated request fails")
+	var simulateMinSize = flag.String("simulate-min-size", "10KB", "Minimum size of simulated files")
+	var simulateMaxSize = flag.String("simulate-max-size", "1MB", "Maximum size of simulated files")
+	var simulateSeed = flag.Int64("simulate-seed", 1, "Seed for the simulated sizes, latencies and failures")
+	var chaos = flag.String("chaos", "", "Inject faults into simulated runs, e.g. drop=10%,delay=5%:30s,corrupt=1%")
+	var limitRate = flag.String("limit-rate", "", "Maximum total download speed, e.g. 2MB/s")
+	var fairShare = flag.Bool("fair-share", false, "Split -limit-rate between the url lists (jobs) with running downloads by their -job-weights")
+	var jobWeights = flag.String("job-weights", "", "Comma separated weights of the jobs for -fair-share, e.g. nightly=3,adhoc-*=1 (default 1)")
+	var priorityRegexes stringsFlag
+	flag.Var(&priorityRegexes, "priority-regex", "Give the urls matching a regular expression a priority, e.g. '\\.iso$=10' (repeatable, the first match wins, higher priorities are downloaded first)")
+	var shuffle = flag.Bool("shuffle", false, "Download the urls in random order")
+	var sortBySize = flag.Bool("sort-by-size", false, "Download the smallest files first, by the size a HEAD request announces")
+	var limitRatePerConn = flag.String("limit-rate-per-conn", "", "Maximum download speed of a single connection")
+	var recordDir = flag.String("record", "", "Record all responses into this directory")
+	var replayDir = flag.String("replay", "", "Replay the responses recorded in this directory instead of using the network")
+	var maxPerHost = flag.Int("max-per-host", 0, "Maximum number of parallel requests per host (0 = unlimited)")
+	var maxFilesPerHost = flag.Int("max-files-per-host", 0, "Maximum number of files downloaded from a host in this run, the others are reported as capped (0 = unlimited)")
+	var maxBytesPerHost = flag.String("max-bytes-per-host", "0", "Maximum size downloaded from a host in this run, e.g. 10GB, the files after it are reported as capped (0 = unlimited)")
+	var delayPerHost = flag.Duration("delay-per-host", 0, "Minimum delay between two requests to the same host")
+	var successIf = flag.String("success-if", "", "Expression that decides whether a response is a success, e.g. 'status == 200 && size > 1024'")
+	var negativeCacheTTL = flag.Duration("negative-cache-ttl", 7*24*time.Hour, "How long urls answered with 404 or 410 are skipped in later runs (0 = disable the cache)")
+	var ignoreNegativeCache = flag.Bool("ignore-negative-cache", false, "Download urls even if they were answered with 404 or 410 in an earlier run")
+	var seenFilterPath = flag.String("seen-filter", "", "Bloom filter file of downloaded urls, urls found in it are skipped")
+	var seenCapacity = flag.Uint64("seen-capacity", 10000000, "Number of urls a new -seen-filter is sized for")
+	var seenFalsePositiveRate = flag.Float64("seen-false-positive-rate", 0.001, "Share of new urls a new -seen-filter wrongly reports as seen")
+	var verifyDigestHeaders = flag.Bool("verify-digest-headers", true, "Verify downloads against the Content-MD5, x-amz-checksum-* and x-goog-hash headers of the responses")
+	var checksumFailAction = flag.String("checksum-fail-action", "delete", "What to do with files that fail checksum verification: delete, keep or rename")
+	var resolve stringsFlag
+	flag.Var(&resolve, "resolve", "Connect to host:port at the given address(es) instead of resolving it, e.g. example.com:443:10.0.0.1,10.0.0.2 (repeatable)")
+	var unixSocket = flag.String("unix-socket", "", "Send all requests over this unix domain socket")
+	var localAddr = flag.String("local-addr", "", "Local IP address to connect from")
+	var dialKeepAlive = flag.Duration("dial-keepalive", 30*time.Second, "Interval of TCP keep-alive probes (negative to disable)")
+	var connectTimeout = flag.Duration("connect-timeout", 30*time.Second, "Maximum time to connect to a server, TLS handshake included")
+	var responseTimeout = flag.Duration("response-timeout", time.Minute, "Maximum time to wait for the response headers after sending a request (0 for no limit)")
+	var maxTime = flag.Duration("max-time", 0, "Maximum time for the download of a file, retries included (0 for no limit)")
+	var minSpeed = flag.String("min-speed", "", "Abort and retry transfers that are slower than this for -min-speed-time, e.g. 10KB/s")
+	var minSpeedTime = flag.Duration("min-speed-time", 30*time.Second, "How long a transfer may stay below -min-speed")
+	var maxIdleConnsPerHost = flag.Int("max-idle-conns-per-host", 0, "Idle connections kept open per host for the next requests (0 for one per worker)")
+	var http2 = flag.Bool("http2", true, "Use HTTP/2 with servers that support it")
+	var h2Streams = flag.Int("h2-streams", 0, "Maximum number of parallel requests over one HTTP/2 connection, more connections to the host are opened for more (0 = one connection)")
+	var httpVersion = flag.String("http-version", httpVersionAuto, "HTTP version to use: auto, 1.1, 2 or 3 (with -tags http3)")
+	var tlsInsecure = flag.Bool("tls-insecure", false, "Don't verify the TLS certificates of the servers")
+	var caCert = flag.String("ca-cert", "", "PEM file with additional CA certificates to trust")
+	var fallbackDelay = flag.Duration("fallback-delay", 300*time.Millisecond, "How long to wait for IPv6 before also trying IPv4 (negative to try the addresses one after the other)")
+	var fallbackPorts = flag.String("fallback-ports", "", "Comma separated ports to try when a host can't be reached on the port of the url, e.g. 8080,443")
+	var debug = flag.Bool("debug", false, "Log the address and address family of every connection")
+	var proxy = flag.String("proxy", "", "Proxy url (http://, https:// or socks5://, user:password@ allowed) or direct to ignore HTTP_PROXY/HTTPS_PROXY")
+	var tor = flag.Bool("tor", false, "Send all requests through Tor (.onion urls always are)")
+	var torProxy = flag.String("tor-proxy", "127.0.0.1:9050", "Address of the SOCKS port of Tor")
+	var torIsolateHosts = flag.Bool("tor-isolate-hosts", false, "Reach every host over its own Tor circuits")
+	var torControl = flag.String("tor-control", "", "Address of the Tor control port, e.g. 127.0.0.1:9051, for -tor-newnym")
+	var torControlPassword = flag.String("tor-control-password", "", "Password of the Tor control port (default: $"+torControlPasswordEnv+", else cookie authentication)")
+	var torNewnym = flag.Duration("tor-newnym", 0, "Switch to new Tor circuits, and so exit addresses, this often (needs -tor-control, 0 = never)")
+	var proxyPAC = flag.String("proxy-pac", "", "Proxy auto-config (PAC) file or http(s) url that chooses the proxy of every request")
+	var proxyUser = flag.String("proxy-user", "", "Credentials user:password for proxies that don't carry their own (password from "+proxyPasswordEnv+" if omitted)")
+	var proxyFile = flag.String("proxy-file", "", "File with one proxy url per line, the requests are spread over them")
+	var proxyRotate = flag.String("proxy-rotate", "round-robin", "How the proxies of -proxy-file are picked: round-robin or random")
+	var proxyMaxFailures = flag.Int("proxy-max-failures", 3, "Consecutive failures after which a proxy of -proxy-file is no longer used (0 = never)")
+	var sshKey = flag.String("ssh-key", "", "Private key for sftp:// and scp:// urls")
+	var sshAgentForward = flag.Bool("ssh-agent-forward", false, "Forward the SSH authentication agent for sftp:// and scp:// urls")
+	var sshOptions stringsFlag
+	flag.Var(&sshOptions, "ssh-option", "Option for ssh, e.g. StrictHostKeyChecking=accept-new (repeatable)")
+	var shareLinks = flag.Bool("share-links", true, "Download the files behind Google Drive and Dropbox share links")
+	var targetThroughput = flag.String("target-throughput", "", "Adjust the workers automatically to reach this speed, e.g. 200MBps")
+	var maxErrorRate = flag.Float64("max-error-rate", 0.05, "Share of failed downloads (0-1) at which the auto-tuner backs off")
+	var maxWorkers = flag.Int("max-workers", 256, "Maximum number of workers the auto-tuner and the control API may start")
+	flag.Usage = printUsage
 	flag.Parse()
 
-	if *version || *entriesFilepath == "" {
+	// the flags of the command line win over the profile, which wins over
+	// the defaults of the config file
+	switch *configFlag {
+	case "":
+		applyConfig(defaultConfigPath(), false, *profileFlag)
+	case configNone:
+		applyConfig("", false, *profileFlag)
+	default:
+		applyConfig(*configFlag, true, *profileFlag)
+	}
+
+	// "massivedl -" and a list piped into massivedl without -urlfile read the
+	// urls from the standard input
+	if flag.NArg() == 1 && flag.Arg(0) == urlFileStdin && *entriesFilepath == "" {
+		*entriesFilepath = urlFileStdin
+	}
+	remoteLists := len(fromSitemaps) > 0 || len(fromFeeds) > 0 || len(scrapes) > 0
+	if *entriesFilepath == "" && *retryFailedPath == "" && *loadedFile == "" && *watchDir == "" && !remoteLists && stdinIsPipe() {
+		*entriesFilepath = urlFileStdin
+	}
+
+	if *version || (*entriesFilepath == "" && *retryFailedPath == "" && *loadedFile == "" && *watchDir == "" && !remoteLists) {
 		PrintVersionInfo()
 		os.Exit(0)
 	}
 
+	// every run gets its own id, also when it continues a saved one
+	runID = *runIDFlag
+	if runID == "" {
+		runID = newRunID()
+	} else if !validRunID.MatchString(runID) {
+		log.Fatalf("invalid -run-id %q, only letters, digits, '.', '_' and '-' are allowed", runID)
+	}
+
 	if *loadedFile != "" {
 		p = loadProgress(*loadedFile)
 	} else {
+		commandLine = os.Args
 		p.EntriesFilepath = *entriesFilepath
+		p.RetryFailedPath = *retryFailedPath
+		p.FromSitemaps = fromSitemaps
+		p.FromFeeds = fromFeeds
+		p.FollowSitemapIndex = *followSitemapIndex
+		p.Scrapes = scrapes
+		p.LinkSelector = *linkSelector
+		p.LinkRegex = *linkRegex
+		p.RecurseDepth = *recurseDepth
+		if p.RecurseDepth < 0 {
+			log.Fatalf("invalid -recurse-depth %d", p.RecurseDepth)
+		}
+		p.SpanHosts = *spanHosts
+		if remoteLists && (p.EntriesFilepath != "" || p.RetryFailedPath != "") {
+			log.Fatal("-from-sitemap, -from-feed and -scrape can't be combined with -urlfile or -retry-failed")
+		}
+		if _, err := scrape.ParseSelector(p.LinkSelector); err != nil {
+			log.Fatal(err)
+		}
+		if _, err := regexp.Compile(p.LinkRegex); err != nil {
+			log.Fatalf("invalid -link-regex: %v", err)
+		}
+		p.WatchDir = *watchDir
+		p.WatchInterval = *watchInterval
+		if p.WatchDir != "" && p.WatchInterval <= 0 {
+			log.Fatalf("invalid -watch-interval %s", p.WatchInterval)
+		}
 		p.ConcurrentRequests = *concurrentRequests
-		p.OutputDir = *outputDir
+		p.OutputDir, p.OutputDirs = parseOutputDirs(*outputDir)
 		p.MaxRetries = *maxRetries
+		p.ConnectRetries = *connectRetries
+		p.RetryOn = *retryOn
 		p.DelayPerRequest = *delayPerRequest
 		p.UserAgent = *userAgent
+		p.Headers = headers
+		p.CookieJar = *cookieJarPath
+		p.HostBundles = *hostBundlesPath
+		p.DomainRules = *domainRulesPath
 		p.SkipExisting = *skipExisting
+		p.Refetch = *refetch
+		p.DiscardPartial = !*keepPartial
+		p.Conditional = *conditional
+		p.Refresh = *refresh
+		if p.Refresh < 0 {
+			log.Fatalf("invalid -refresh %q", p.Refresh)
+		}
+		if p.Refresh > 0 {
+			p.Conditional = true
+		}
+		p.Report = *reportSpec
+		p.TLSReport = *tlsReportPath
+		p.SumsFile = *sumsFile
+		p.SumsSignKey = *sumsSignKey
+		if p.SumsSignKey != "" && p.SumsFile == "" {
+			log.Fatal("-sums-sign-key needs -sums")
+		}
+		if p.Report != "" {
+			if _, _, err := parseReport(p.Report); err != nil {
+				log.Fatal(err)
+			}
+		}
+		p.Stagger = *stagger
+		p.ActiveHours = *activeHours
+		p.ActiveCron = *activeCron
+		if p.ActiveHours != "" && p.ActiveCron != "" {
+			log.Fatal("-active-hours can't be combined with -active-cron")
+		}
+		activeSchedule()
 		p.UseChecksumAsPath = *useChecksumAsPath
+		p.NameTemplate = *nameTemplate
+		p.NameStrategy = *nameStrategy
+		p.OnConflict = *onConflict
+		p.TrustServerNames = *trustServerNames
+		p.TransformJQ = *transformJQ
+		p.MirrorSelect = *mirrorSelect
+		p.Dedup = *dedup
+		p.InputFormat = *inputFormatName
+		p.Progress = *progress
+		if *tuiFlag {
+			p.Progress = progressTUI
+		}
+		p.ProcessTitle = *processTitle
+		p.ControlAddr = *controlAddr
+		p.ControlToken = *controlToken
+		p.Notify = *notifyURL
+		if p.Notify != "" && !strings.HasPrefix(p.Notify, "http://") && !strings.HasPrefix(p.Notify, "https://") {
+			log.Fatalf("invalid -notify %s, must be an http:// or https:// url", p.Notify)
+		}
+		p.OnComplete = *onComplete
+		p.OnFailure = *onFailure
+		p.OnFinish = *onFinish
+		checkHook("on-complete", p.OnComplete)
+		checkHook("on-failure", p.OnFailure)
+		checkHook("on-finish", p.OnFinish)
+		p.DebugQueue = *debugQueue
+		p.ProfileRun = *profileRun
+		if p.DebugQueue < 0 {
+			log.Fatalf("invalid -debug-queue %s", p.DebugQueue)
+		}
+		p.Extract = *extractFlag
+		p.ExtractDelete = *extractDelete
+		if p.ExtractDelete && !p.Extract {
+			log.Fatal("-extract-delete requires -extract")
+		}
+		p.Sink = *sink
+		p.SinkKeepLocal = *sinkKeepLocal
+		p.ProgressInterval = *progressInterval
+		p.ProgressFile = *progressFile
+		p.Autosave = *autosaveInterval
+		p.AutosaveKeep = *autosaveKeep
+		if p.Autosave < 0 || p.Autosave > 0 && p.Autosave < time.Second {
+			log.Fatalf("invalid -autosave %s, must be at least 1s", p.Autosave)
+		}
+		if p.AutosaveKeep < 1 {
+			log.Fatalf("invalid -autosave-keep %d, must be at least 1", p.AutosaveKeep)
+		}
+		if p.Autosave > 0 && p.EntriesFilepath == urlFileStdin {
+			log.Fatal("-autosave can't save the progress of urls read from the standard input")
+		}
+		p.Session = *session
+		if p.Session != "" && !validRunID.MatchString(p.Session) {
+			log.Fatalf("invalid -session %q, only letters, digits, '.', '_' and '-' are allowed", p.Session)
+		}
+		if p.Session != "" && p.EntriesFilepath == urlFileStdin {
+			log.Fatal("-session can't save the progress of urls read from the standard input")
+		}
+		if p.Session != "" && fileutil.FileOrPathExists(sessionPath(p.Session)) {
+			log.Fatalf("session %s exists, continue it with massivedl sessions resume %[1]s or delete it with massivedl sessions delete %[1]s", p.Session)
+		}
+		p.NDJSONDir = *ndjsonDir
+		p.ParquetDir = *parquetDir
+		for _, name := range strings.Split(*captureHeaders, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				p.CaptureHeaders = append(p.CaptureHeaders, name)
+			}
+		}
+		p.MetadataSidecar = *metadataSidecar
+		p.ParquetRowsPerFile = *parquetRowsPerFile
+		p.Segments = *segments
+
+		var err error
+		p.Preflight = *preflight
+		p.DryRun = *dryRunFlag
+		if p.DryRun && p.WatchDir != "" {
+			log.Fatal("-dry-run can't be combined with -watch")
+		}
+		if p.MinFreeSpace, err = sizeutil.ParseSize(*minFreeSpace); err != nil {
+			log.Fatal(err)
+		}
+		if p.ToMemory, err = sizeutil.ParseSize(*toMemory); err != nil {
+			log.Fatal(err)
+		}
+		p.IncludeRegex = *includeRegex
+		p.ExcludeRegex = *excludeRegex
+		p.AcceptExt = parseExtensions(*acceptExt)
+		p.RejectExt = parseExtensions(*rejectExt)
+		p.AcceptContentTypes = parseContentTypes(*acceptContentType)
+		p.RejectContentTypes = parseContentTypes(*rejectContentType)
+		if p.MinSize, err = sizeutil.ParseSize(*minSize); err != nil {
+			log.Fatal(err)
+		}
+		if p.MaxSize, err = sizeutil.ParseSize(*maxSize); err != nil {
+			log.Fatal(err)
+		}
+		if p.ExtractMaxSize, err = sizeutil.ParseSize(*extractMaxSize); err != nil {
+			log.Fatal(err)
+		}
+		if p.MaxSize > 0 && p.MinSize > p.MaxSize {
+			log.Fatal("-min-size must not be larger than -max-size")
+		}
+		if p.SegmentMinSize, err = sizeutil.ParseSize(*segmentMinSize); err != nil {
+			log.Fatal(err)
+		}
+		if p.AbortFailureRate, err = parsePercent(*abortFailureRate); err != nil {
+			log.Fatalf("invalid -abort-on-failure-rate: %v", err)
+		}
+		p.AbortMinSample = *abortMinSample
+		if p.EgressCostPerGB, err = parseEgressCost(*egressCost); err != nil {
+			log.Fatal(err)
+		}
+		if p.AbortMinSample < 1 {
+			log.Fatal("-abort-min-sample must be at least 1")
+		}
+
+		p.Simulate = *simulate
+		p.SimulateLatency = *simulateLatency
+		p.SimulateFailRate = *simulateFailRate
+		p.SimulateSeed = *simulateSeed
+		if *chaos != "" {
+			if !p.Simulate {
+				log.Fatal("-chaos only works with -simulate")
+			}
+			if _, err = parseChaos(*chaos); err != nil {
+				log.Fatal(err)
+			}
+			p.Chaos = *chaos
+		}
+		p.RecordDir = *recordDir
+		p.ReplayDir = *replayDir
+		p.ChecksumFailAction = *checksumFailAction
+		p.IgnoreDigestHeaders = !*verifyDigestHeaders
+		p.SuccessIf = *successIf
+		p.NegativeCacheTTL = *negativeCacheTTL
+		p.IgnoreNegativeCache = *ignoreNegativeCache
+		p.SeenFilter = *seenFilterPath
+		p.SeenCapacity = *seenCapacity
+		p.SeenFalsePositiveRate = *seenFalsePositiveRate
+		if p.SeenFalsePositiveRate <= 0 || p.SeenFalsePositiveRate >= 1 {
+			log.Fatalf("invalid -seen-false-positive-rate %v", p.SeenFalsePositiveRate)
+		}
+		p.Resolve = resolve
+		p.UnixSocket = *unixSocket
+		p.LocalAddr = *localAddr
+		p.DialKeepAlive = *dialKeepAlive
+		p.FallbackDelay = *fallbackDelay
+		p.ConnectTimeout = *connectTimeout
+		p.ResponseTimeout = *responseTimeout
+		p.MaxTime = *maxTime
+		p.MinSpeedTime = *minSpeedTime
+		if p.ConnectTimeout <= 0 || p.ResponseTimeout < 0 || p.MaxTime < 0 || p.MinSpeedTime <= 0 {
+			log.Fatal("invalid timeout, -connect-timeout and -min-speed-time must be positive, -response-timeout and -max-time must not be negative")
+		}
+		p.MaxIdleConnsPerHost = *maxIdleConnsPerHost
+		if p.MaxIdleConnsPerHost < 0 {
+			log.Fatal("-max-idle-conns-per-host must not be negative")
+		}
+		p.DisableHTTP2 = !*http2
+		p.HTTPVersion = *httpVersion
+		switch p.HTTPVersion {
+		case httpVersionAuto:
+		case httpVersion11:
+			p.DisableHTTP2 = true
+		case httpVersion2:
+			if p.DisableHTTP2 {
+				log.Fatal("-http-version 2 can't be combined with -http2=false")
+			}
+		case httpVersion3:
+		default:
+			log.Fatalf("invalid -http-version %q, expected auto, 1.1, 2 or 3", p.HTTPVersion)
+		}
+		p.H2Streams = *h2Streams
+		if p.H2Streams < 0 {
+			log.Fatalf("invalid -h2-streams %d", p.H2Streams)
+		}
+		if p.H2Streams > 0 && p.DisableHTTP2 {
+			log.Fatal("-h2-streams can't be combined with HTTP/1.1")
+		}
+		p.TLSInsecure = *tlsInsecure
+		p.CACert = *caCert
+		p.FallbackPorts = nil
+		for _, port := range strings.Split(*fallbackPorts, ",") {
+			if port = strings.TrimSpace(port); port == "" {
+				continue
+			}
+			if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+				log.Fatalf("invalid port %q in -fallback-ports", port)
+			}
+			p.FallbackPorts = append(p.FallbackPorts, port)
+		}
+		p.Debug = *debug
+		p.ShareLinks = *shareLinks
+		p.Proxy = *proxy
+		p.ProxyFile = *proxyFile
+		p.ProxyPAC = *proxyPAC
+		p.Tor = *tor
+		p.TorProxy = *torProxy
+		p.TorIsolateHosts = *torIsolateHosts
+		p.TorControl = *torControl
+		p.TorControlPassword = *torControlPassword
+		if p.TorControlPassword == "" {
+			p.TorControlPassword = os.Getenv(torControlPasswordEnv)
+		}
+		p.TorNewnym = *torNewnym
+		p.ProxyUser = *proxyUser
+		p.ProxyRotate = *proxyRotate
+		p.ProxyMaxFailures = *proxyMaxFailures
+		// HTTP/3 runs over UDP, which proxies, Tor and unix sockets don't carry
+		if p.HTTPVersion == httpVersion3 && (p.Proxy != "" && p.Proxy != proxyDirect || p.ProxyFile != "" || p.ProxyPAC != "" ||
+			p.Tor || p.UnixSocket != "" || len(p.Resolve) > 0 || p.LocalAddr != "") {
+			log.Fatal("-http-version 3 can't be combined with -proxy, -proxy-file, -proxy-pac, -tor, -unix-socket, -resolve or -local-addr")
+		}
+		p.SSHKey = *sshKey
+		p.SSHAgentForward = *sshAgentForward
+		p.SSHOptions = sshOptions
+		p.MaxPerHost = *maxPerHost
+		p.DelayPerHost = *delayPerHost
+		p.MaxFilesPerHost = *maxFilesPerHost
+		if p.MaxFilesPerHost < 0 {
+			log.Fatalf("invalid -max-files-per-host %d", p.MaxFilesPerHost)
+		}
+		if p.MaxBytesPerHost, err = sizeutil.ParseSize(*maxBytesPerHost); err != nil {
+			log.Fatal(err)
+		}
+		p.MaxErrorRate = *maxErrorRate
+		p.MaxWorkers = *maxWorkers
+		if p.NDJSONMaxSize, err = sizeutil.ParseSize(*ndjsonMaxSize); err != nil {
+			log.Fatal(err)
+		}
+		if p.ParquetMaxBody, err = sizeutil.ParseSize(*parquetMaxBody); err != nil {
+			log.Fatal(err)
+		}
+		if p.SimulateMinSize, err = sizeutil.ParseSize(*simulateMinSize); err != nil {
+			log.Fatal(err)
+		}
+		if p.SimulateMaxSize, err = sizeutil.ParseSize(*simulateMaxSize); err != nil {
+			log.Fatal(err)
+		}
+		if *limitRate != "" {
+			if p.LimitRate, err = sizeutil.ParseRate(*limitRate); err != nil {
+				log.Fatal(err)
+			}
+		}
+		p.FairShare = *fairShare
+		if p.FairShare && p.LimitRate <= 0 {
+			log.Fatal("-fair-share needs -limit-rate")
+		}
+		p.JobWeights = nil
+		for _, pair := range strings.Split(*jobWeights, ",") {
+			if pair = strings.TrimSpace(pair); pair != "" {
+				p.JobWeights = append(p.JobWeights, pair)
+			}
+		}
+		p.PriorityRegexes = priorityRegexes
+		p.Shuffle = *shuffle
+		p.SortBySize = *sortBySize
+		if p.Shuffle && p.SortBySize {
+			log.Fatal("-shuffle can't be combined with -sort-by-size")
+		}
+		if p.NameStrategy != "basename" && (p.NameTemplate != "" || p.UseChecksumAsPath) {
+			log.Fatal("-name-strategy can't be combined with -name-template or -checksum-path")
+		}
+		newOutputNamer()
+		switch p.OnConflict {
+		case conflictSkip, conflictOverwrite, conflictRename, conflictError:
+		default:
+			log.Fatalf("invalid -on-conflict %q", p.OnConflict)
+		}
+		for _, line := range p.Headers {
+			if _, _, err = parseHeader(line); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if p.Proxy != "" && p.ProxyFile != "" {
+			log.Fatal("-proxy and -proxy-file cannot be used together")
+		}
+		if p.ProxyPAC != "" && (p.Proxy != "" || p.ProxyFile != "") {
+			log.Fatal("-proxy-pac cannot be used together with -proxy or -proxy-file")
+		}
+		if p.Tor && (p.Proxy != "" || p.ProxyFile != "" || p.ProxyPAC != "") {
+			log.Fatal("-tor cannot be used together with -proxy, -proxy-file or -proxy-pac")
+		}
+		if p.TorNewnym > 0 && (!p.Tor || p.TorControl == "") {
+			log.Fatal("-tor-newnym needs -tor and -tor-control")
+		}
+		if p.TorNewnym > 0 && p.TorNewnym < 10*time.Second {
+			log.Fatalf("invalid -tor-newnym %s, Tor allows new circuits at most every 10s", p.TorNewnym)
+		}
+		switch p.ProxyRotate {
+		case proxyRotateRoundRobin, proxyRotateRandom:
+		default:
+			log.Fatalf("invalid -proxy-rotate %q", p.ProxyRotate)
+		}
+		if p.ConnectRetries < 0 {
+			log.Fatalf("invalid -connect-retries %d", p.ConnectRetries)
+		}
+		if p.Sink != "" && (p.NDJSONDir != "" || p.ParquetDir != "") {
+			log.Fatal("-sink cannot be used together with -ndjson-dir or -parquet-dir")
+		}
+		if p.Extract && (p.Sink != "" || p.NDJSONDir != "" || p.ParquetDir != "") {
+			log.Fatal("-extract cannot be used together with -sink, -ndjson-dir or -parquet-dir")
+		}
+		if p.Extract {
+			checkArchiveFormats()
+		}
+		switch p.Progress {
+		case progressTable, progressLine, progressNone, progressTUI:
+		default:
+			log.Fatalf("invalid -progress %q", p.Progress)
+		}
+		if p.ProgressInterval <= 0 {
+			log.Fatalf("invalid -progress-interval %q", p.ProgressInterval)
+		}
+
+		switch p.MirrorSelect {
+		case mirrorSelectOrder, mirrorSelectFastest:
+		default:
+			log.Fatalf("invalid -mirror-select %q", p.MirrorSelect)
+		}
+		switch p.InputFormat {
+		case inputAuto, inputCSV, inputLines, inputJSON, inputYAML:
+		default:
+			log.Fatalf("invalid -input-format %q", p.InputFormat)
+		}
+		switch p.Dedup {
+		case dedupOff, dedupURL, dedupContent:
+		default:
+			log.Fatalf("invalid -dedup %q", p.Dedup)
+		}
+		switch p.ChecksumFailAction {
+		case massivedl.ChecksumFailDelete, massivedl.ChecksumFailKeep, massivedl.ChecksumFailRename:
+		default:
+			log.Fatalf("invalid -checksum-fail-action %q", p.ChecksumFailAction)
+		}
+		if *targetThroughput != "" {
+			if p.TargetThroughput, err = sizeutil.ParseRate(*targetThroughput); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *minSpeed != "" {
+			if p.MinSpeed, err = sizeutil.ParseRate(*minSpeed); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if *limitRatePerConn != "" {
+			if p.LimitRatePerConn, err = sizeutil.ParseRate(*limitRatePerConn); err != nil {
+				log.Fatal(err)
+			}
+		}
 	}
 }
 
@@ -130,39 +1019,55 @@ func getSaveFilesDirectory() string {
 }
 
 func getSaveFilePath() string {
+	if p.Session != "" {
+		return sessionPath(p.Session)
+	}
 	filename := fmt.Sprintf("%d_progress.save", timeutil.GetCurrentTimestamp())
 	return path.Join(getSaveFilesDirectory(), filename)
 }
 
 func saveProgress() {
-	var err error
+	saveFilePath, err := writeProgress()
+	if err != nil {
+		log.Fatal(err)
+	}
 
+	fmt.Println("\nProgress has been saved!")
+	fmt.Println("Use the following command to continue downloading")
+	if p.Session != "" {
+		fmt.Printf("\n\tmassivedl sessions resume %s\n", p.Session)
+	} else {
+		fmt.Printf("\n\tmassivedl --load %s\n", saveFilePath)
+	}
+}
+
+// writeProgress saves the parameters and the progress of the run and returns
+// the path of the save file
+func writeProgress() (string, error) {
+	saveFilePath := getSaveFilePath()
+	return saveFilePath, writeSaveFile(saveFilePath)
+}
+
+// writeSaveFile saves the parameters and the progress of the run to
+// saveFilePath, which is replaced at once once the new file is complete
+func writeSaveFile(saveFilePath string) error {
 	workDir, err := os.Getwd()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	var save saveEntry
-	p.Offset = stats.TotalDownloads - stats.FilesRemaining - 1
 	save.WorkingDirectory = workDir
 	save.Parameters = p
-	save.Stats = stats
+	save.Stats = stats.Snapshot()
+	save.CommandLine = commandLine
+	save.StateFile = stateFilePath
 
 	b, err := json.Marshal(save)
 	if err != nil {
-		log.Fatal(err)
-	}
-
-	saveFilePath := getSaveFilePath()
-
-	err = ioutil.WriteFile(saveFilePath, b, os.ModePerm)
-	if err != nil {
-		log.Fatal(err)
-	} else {
-		fmt.Println("\nProgress has been saved!")
-		fmt.Println("Use the following command to continue downloading")
-		fmt.Printf("\n\tmassivedl --load %s\n", saveFilePath)
+		return err
 	}
+	return fileutil.WriteFileAtomic(saveFilePath, b, os.ModePerm)
 }
 
 func loadProgress(saveFile string) cmdLineParams {
@@ -179,13 +1084,12 @@ func loadProgress(saveFile string) cmdLineParams {
 		log.Fatal(err)
 	}
 
+	commandLine = l.CommandLine
+	stateFilePath = l.StateFile
+	loadedCompleted = l.Completed
+
 	// load statistics
-	stats = l.Stats
-	// reset stats that do not make sense to be loaded
-	stats.AverageSpeedBytesPerSec = 0
-	stats.AverageSpeedFilesPerSec = 0
-	stats.SpeedBytesPerSec = 0
-	stats.StartTime = time.Now()
+	stats = statistics.Resume(l.Stats)
 
 	err = os.Chdir(l.WorkingDirectory)
 	if err != nil {
@@ -202,140 +1106,296 @@ func registerSignalHandlers() {
 
 	go func() {
 		<-sigChan
-		stopWorking = true
-		stats.Print()
+		cancelRun()
+		typed := stopReadingKeys()
+
+		// a second Ctrl+C quits at once, also while asking to save
+		go func() {
+			<-sigChan
+			fmt.Println("\nQuitting without saving")
+			os.Exit(1)
+		}()
+
+		printProgressRow()
 		stats.PrintEnd()
+		if p.ProfileRun {
+			fmt.Println()
+			fmt.Print(runProfile.report())
+		}
+		if p.ProgressFile != "" {
+			writeProgressFile(progressInterrupted, stats.Snapshot(), 0)
+		}
 
-		if clitool.AskUserBool("Do you want to save progress?", true, nil) {
+		saveSeenFilter()
+		// the urls of the standard input can't be read again
+		if p.EntriesFilepath == urlFileStdin {
+			fmt.Println("\nThe progress of urls read from the standard input can't be saved")
+			closeStateStore(true)
+		} else if p.Session != "" || clitool.AskUserBool("Do you want to save progress?", true, typed) {
 			saveProgress()
+		} else if p.Autosave == 0 {
+			closeStateStore(true)
 		}
 
 		os.Exit(0)
 	}()
 }
 
-// Downloads a file on the specified url
-// @param filepath - The file where the output will be saved
-func download(url, filepath string, maxRetries int, userAgent string) logging.LogEntry {
-	totalTries := 0
-	logRow := logging.LogEntry{Url: url, Name: filepath, Result: false, NBytes: 0, Duration: 0}
-	var response *http.Response
-	var err error
-	var file *os.File
+// outputNamer names the entries without a name of their manifest and
+// namedAfterDownload is true if it needs their data for it, like
+// -name-strategy content-hash
+var (
+	outputNamer        massivedl.Namer
+	namedAfterDownload bool
+)
 
-	startTime := time.Now()
-	defer func() {
-		if response == nil {
-			return
-		}
+// nameStrategy returns the spec of the naming strategy. -name-template and
+// -checksum-path are short for template strategies.
+func nameStrategy() string {
+	switch {
+	case p.NameTemplate != "":
+		return "template:" + p.NameTemplate
+	case p.UseChecksumAsPath:
+		return "template:{sha256(url)}"
+	case p.NameStrategy == "":
+		return "basename"
+	}
+	return p.NameStrategy
+}
 
-		if err = response.Body.Close(); err != nil {
-			log.Printf("error closing response body: %v", err)
-		}
-	}()
+// newOutputNamer returns the Namer of the naming strategy
+func newOutputNamer() massivedl.Namer {
+	namer, err := massivedl.NewNamer(nameStrategy())
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	for {
-		if totalTries > maxRetries {
-			return logRow
-		}
+	return namer
+}
 
-		client := &http.Client{}
+// assignOutputNames sets the output path of every entry and returns the
+// entries that remain after resolving name conflicts
+func assignOutputNames(entries []dataEntry) []dataEntry {
+	for i := range entries {
+		entries[i].name = outputPath(entries[i], outputNamer)
+	}
 
-		req, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			log.Println(err)
-			break
-		}
+	return resolveConflicts(entries)
+}
 
-		req.Header.Set("User-Agent", userAgent)
+// outputPath returns the path entry is saved at, before name conflicts are
+// resolved: the name given by its manifest or the one of namer. Until a
+// namer that needs the data names the entry, it is downloaded to a hidden
+// file named after its url.
+func outputPath(entry dataEntry, namer massivedl.Namer) string {
+	if entry.outputName != "" {
+		return outputDirPath(entry.outputName)
+	}
 
-		response, err = client.Do(req)
-		if err != nil {
-			log.Println("[RETRY]", totalTries, url, filepath)
-			totalTries++
-			continue
+	name, err := namer.Name(massivedl.Entry{URL: entry.url.String(), Metadata: entry.metadata}, &massivedl.Response{Index: entry.index})
+	if err == nil && name == "" {
+		err = errors.New("empty file name")
+	}
+	if err != nil {
+		if !errors.Is(err, massivedl.ErrNameAfterDownload) {
+			log.Printf("[NAME] %s: %v", entry.url, err)
 		}
+		name = fmt.Sprintf(".massivedl-%x", sha256.Sum256([]byte(entry.url.String())))
+	}
+	return outputDirPath(name)
+}
+
+// outputDirPath returns the path of name in the output directory, which it
+// never leaves
+func outputDirPath(name string) string {
+	return path.Join(p.OutputDir, path.Clean("/"+name))
+}
 
-		break
+func run(_ cmdLineParams) {
+	registerSignalHandlers()
+
+	compilePriorityRules()
+	if p.FairShare {
+		compileJobWeights()
 	}
+	stats.SetCostPerGB(p.EgressCostPerGB)
 
-	logRow.Duration = (time.Now()).Sub(startTime)
+	if p.TransformJQ != "" {
+		var err error
+		if transformQuery, err = jq.Compile(p.TransformJQ); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	retryStatuses = parseRetryOn()
+	compileFilters()
 
-	// create subdirectories if they do not exist
-	parts := strings.Split(filepath, "/")
-	if len(parts) > 1 {
-		if err = os.MkdirAll(strings.Join(parts[:len(parts)-1], "/"), os.ModePerm); err != nil {
-			log.Fatalf("unable to create directories: %v", err)
+	if p.SuccessIf != "" {
+		var err error
+		if successCheck, err = expr.Compile(p.SuccessIf); err != nil {
+			log.Fatal(err)
 		}
 	}
 
-	file, err = os.Create(filepath)
-	if err != nil {
-		log.Fatal(err)
-		return logRow
+	if p.NegativeCacheTTL > 0 && !p.Simulate && p.ReplayDir == "" {
+		negativeCache = openNegativeCache()
+		defer func() {
+			if err := negativeCache.Close(); err != nil {
+				fmt.Printf("unable to close file: %v", err)
+			}
+		}()
 	}
-	defer func() {
-		if err = file.Close(); err != nil {
-			fmt.Printf("unable to close file: %v", err)
-		}
-	}()
 
-	nBytes, err := io.Copy(file, response.Body)
-	if err != nil {
-		log.Fatal(err)
-		return logRow
+	if !p.Simulate && p.ReplayDir == "" {
+		downloadHistory = openHistory()
+		defer func() {
+			if err := downloadHistory.Close(); err != nil {
+				fmt.Printf("unable to close file: %v", err)
+			}
+		}()
 	}
 
-	logRow.Result = true
-	logRow.NBytes = uint64(nBytes)
+	if p.CookieJar != "" {
+		cookieJar = loadCookieJar(p.CookieJar)
+	}
+	if p.HostBundles != "" {
+		hostBundles = loadHostBundles(p.HostBundles)
+	}
+	if p.DomainRules != "" {
+		domainRules = loadDomainRules(p.DomainRules)
+	}
 
-	return logRow
-}
+	if p.Sink != "" {
+		sinkURL = parseSink(p.Sink)
+	}
 
-func worker(_ int, jobs <-chan *url.URL, results chan<- logging.LogEntry) {
-	for j := range jobs {
-		if stopWorking {
-			break
+	base := newTransport()
+	transport = base
+	// with -h2-streams the requests to an HTTP/2 server are spread over
+	// several connections
+	if p.H2Streams > 0 {
+		transport = &h2pool.Transport{Transport: base, Streams: p.H2Streams}
+	}
+	if p.HTTPVersion == httpVersion2 {
+		transport = requireHTTP2{transport}
+	}
+	if p.HTTPVersion == httpVersion3 {
+		h3, err := newHTTP3Transport(transport)
+		if err != nil {
+			log.Fatalf("-http-version 3: %v", err)
 		}
+		transport = h3
+	}
+	if p.TorNewnym > 0 {
+		rotateTorCircuits()
+	}
+	if p.ProxyFile != "" {
+		transport = &proxypool.Transport{Pool: newProxyPool(), Transport: transport}
+	}
 
-		outFile := path.Join(p.OutputDir, filepath.Base(j.Path))
-		if p.UseChecksumAsPath {
-			outFile = path.Join(p.OutputDir, fmt.Sprintf("%x", sha256.Sum256([]byte(j.String()))))
+	// simulated runs download generated files into a temporary directory
+	if p.Simulate {
+		chaos, _ := parseChaos(p.Chaos)
+		transport = &simulate.Transport{
+			Latency:     p.SimulateLatency,
+			FailureRate: p.SimulateFailRate,
+			MinSize:     p.SimulateMinSize,
+			MaxSize:     p.SimulateMaxSize,
+			Seed:        p.SimulateSeed,
+			Chaos:       chaos,
 		}
-		_, err := os.Stat(outFile)
-		if err == nil && p.SkipExisting {
-			results <- logging.LogEntry{Url: j.String(), Name: outFile, Result: true, NBytes: 0, Duration: 0}
-			continue
+
+		tmpDir, err := ioutil.TempDir("", "massivedl-simulate")
+		if err != nil {
+			log.Fatal(err)
 		}
-		res := download(j.String(), outFile, p.MaxRetries, p.UserAgent)
-		stats.Update(res)
-		res.Print()
-		results <- res
+		defer func() {
+			if err = os.RemoveAll(tmpDir); err != nil {
+				fmt.Printf("unable to remove %s: %v", tmpDir, err)
+			}
+		}()
+		p.OutputDir = tmpDir
+		p.OutputDirs = nil
+	}
 
-		time.Sleep(p.DelayPerRequest)
+	if p.ReplayDir != "" {
+		transport = &vcr.Player{Dir: p.ReplayDir}
+	} else if p.RecordDir != "" {
+		transport = &vcr.Recorder{Dir: p.RecordDir, Transport: transport}
 	}
-}
 
-func run(_ cmdLineParams) {
-	// flag that is raised on SIGINT signal
-	stopWorking = false
+	if p.ShareLinks {
+		transport = &sharelink.Transport{Transport: transport}
+	}
+	if p.Debug || p.TLSReport != "" {
+		transport = connTracer{transport}
+	}
+	httpClient = &http.Client{Transport: transport, Jar: cookieJar}
+	registerSchemes(transport)
+
+	// create downloads dir if it doesn't exist, a dry run doesn't write
+	// anything into it
+	if !p.DryRun {
+		for _, dir := range outputDirs() {
+			if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+				log.Fatalf("unable to create directories: %v", err)
+			}
+		}
+	}
 
-	registerSignalHandlers()
+	if p.NDJSONDir != "" && !p.DryRun {
+		var err error
+		if ndjsonSink, err = ndjson.New(p.NDJSONDir, p.NDJSONMaxSize); err != nil {
+			log.Fatal(err)
+		}
+		defer func() {
+			if err = ndjsonSink.Close(); err != nil {
+				fmt.Printf("unable to close file: %v", err)
+			}
+		}()
+	}
 
-	// create downloads dir if it doesn't exist
-	if err := os.MkdirAll(p.OutputDir, os.ModePerm); err != nil {
-		log.Fatalf("unable to create directories: %v", err)
+	if p.ParquetDir != "" && !p.DryRun {
+		var err error
+		if parquetSink, err = parquet.NewWriter(p.ParquetDir, parquetColumns, p.ParquetRowsPerFile); err != nil {
+			log.Fatal(err)
+		}
+		defer func() {
+			if err = parquetSink.Close(); err != nil {
+				fmt.Printf("unable to close file: %v", err)
+			}
+		}()
 	}
 
-	// load urls - entries to download
-	urls, err := loadURLs(p.EntriesFilepath)
+	// load entries to download
+	var entries []dataEntry
+	var err error
+	if p.RetryFailedPath != "" {
+		entries, err = loadFailed(p.RetryFailedPath)
+	} else if len(p.FromSitemaps) > 0 || len(p.FromFeeds) > 0 || len(p.Scrapes) > 0 {
+		entries, err = loadRemoteLists()
+	} else if p.EntriesFilepath != urlFileStdin && (p.EntriesFilepath != "" || p.WatchDir == "") {
+		entries, err = loadEntries(p.EntriesFilepath)
+	} else if p.EntriesFilepath == urlFileStdin && p.DryRun {
+		// a dry run checks the whole list before it reports
+		entries, err = readEntriesFormat(os.Stdin, inputFormat(""), 0)
+	}
 	if err != nil {
 		log.Fatal(err)
 	}
-	stats.TotalDownloads = len(urls)
+	if entries, err = expandPrefixes(entries); err != nil {
+		log.Fatal(err)
+	}
+	if p.RetryFailedPath != "" {
+		setJob(entries, jobName(p.RetryFailedPath))
+	} else if p.EntriesFilepath != "" {
+		setJob(entries, jobName(p.EntriesFilepath))
+	}
 
-	// set number of workers from command line parameters
-	numWorkers := p.ConcurrentRequests
+	// onion services are reached through Tor also without -tor
+	if !p.Tor && !p.Simulate && p.ReplayDir == "" && hasOnion(entries) {
+		checkTorProxy()
+	}
 
 	// create log file
 	f, err := os.OpenFile(path.Join(getSaveFilesDirectory(), "massivedl.log"), os.O_RDWR|os.O_CREATE|os.O_APPEND, os.ModePerm)
@@ -348,49 +1408,288 @@ func run(_ cmdLineParams) {
 		}
 	}()
 
-	// redirect logger output on the log file
+	// redirect logger output on the log file, every line names the run
 	log.SetOutput(f)
+	log.SetPrefix("run=" + runID + " ")
+	fmt.Println("Run id:", runID)
 
-	// create jobs channel
-	jobs := make(chan *url.URL)
+	// decide where every entry is saved
+	if !p.DryRun {
+		openStateStore()
+	}
+	entries = dropCompleted(entries)
+	if dedupURLs() {
+		var n int
+		entries, n = dropDuplicates(entries, runQueue.urls)
+		if n > 0 {
+			fmt.Printf("Skipping %d duplicate urls\n", n)
+		}
+	}
+	if p.SeenFilter != "" {
+		seenFilter = loadSeenFilter()
+		entries = dropSeen(entries)
+	}
+	if p.RecurseDepth > 0 {
+		startRecursion(entries)
+	}
 
-	// create results channel
-	results := make(chan logging.LogEntry, stats.TotalDownloads)
+	if hasFilters() {
+		var counts map[string]int
+		entries, counts = filterEntries(entries)
+		if n := sumCounts(counts); n > 0 {
+			fmt.Printf("Skipping %d entries that were filtered out: %s\n", n, formatFilterCounts(counts))
+		}
+	}
 
-	// print output header
-	stats.PrintHeader()
+	outputNamer = newOutputNamer()
+	namedAfterDownload = massivedl.NamesAfterDownload(outputNamer)
+	entries = assignOutputNames(entries)
+	stats.TotalDownloads = len(entries)
 
-	// run output goroutine
-	// this goroutine updates the statics in stdout
-	go func() {
-		for !stopWorking {
-			stats.Print()
-			time.Sleep(500 * time.Millisecond)
+	if p.DryRun {
+		dryRun(entries)
+		return
+	}
+
+	if p.TrustServerNames || namedAfterDownload {
+		openServerNames(entries)
+		defer closeServerNames()
+	}
+
+	if p.Conditional {
+		metaDB = openMetaDB()
+		defer func() {
+			if err = metaDB.Close(); err != nil {
+				fmt.Printf("unable to close file: %v", err)
+			}
+		}()
+	}
+
+	if p.Report != "" {
+		openReport()
+		defer closeReport()
+	}
+	if p.SumsSignKey != "" {
+		sumsSignKey = loadSignKey(p.SumsSignKey)
+	}
+
+	if p.TLSReport != "" {
+		openTLSReport()
+		defer closeTLSReport()
+	}
+
+	if p.Preflight {
+		preflightSpace(entries)
+	}
+
+	// the downloads are paused through the downloader, also by SIGUSR1
+	downloader = newDownloader()
+	registerPauseSignals()
+
+	// p pauses the downloads in -tui mode, the terminal is restored at the
+	// end
+	readKeys()
+	defer stopReadingKeys()
+
+	// run output goroutines
+	// these goroutines update the statistics in stdout, -progress-file and
+	// -process-title
+	stopReporters := startReporters()
+	stopAutosave := func() {}
+	if p.Autosave > 0 {
+		stopAutosave = startAutosave()
+	}
+
+	if p.DebugQueue > 0 {
+		go logQueue()
+	}
+	// outside the active hours the workers wait from the start
+	if s := activeSchedule(); s != nil {
+		applySchedule(s)
+		go followSchedule(s)
+	}
+
+	// catch results, until those of all queued entries are in
+	var failed []logging.LogEntry
+	failures := newFailureTracker()
+	capped := 0
+	received := 0
+	handle := func(result massivedl.Result) {
+		// the results that are still in after an abort are dropped
+		if runAborted {
+			return
 		}
-	}()
+		res := logEntry(result)
+		received++
+		if !res.Skipped && !res.Capped {
+			res.Print()
+		}
+		// the entries of the negative cache weren't requested, they keep
+		// the time they were found dead
+		if result.Attempts > 0 {
+			updateNegativeCache(res)
+		}
+		if p.Refresh > 0 {
+			noteIdle(received)
+			failed = dropFailure(failed, res.Url)
+		}
+		if p.Report != "" {
+			writeReport(res, queuedEntry(res.Url))
+		}
+		failures.add(res)
+		markFinished(res)
+		runFileHook(res)
+		if res.Capped {
+			capped++
+		} else if !res.Result {
+			failed = append(failed, res)
+		} else {
+			if seenFilter != nil {
+				seenFilter.Add([]byte(res.Url))
+			}
+			if p.RecurseDepth > 0 {
+				followLinks(queuedEntry(res.Url), res.Name)
+			}
+		}
+		if failures.exceeded() {
+			// stop the workers, the entries that weren't downloaded yet
+			// are lost like on an interrupt
+			runAborted = true
+			cancelRun()
+		}
+	}
+
+	// init worker goroutines, the number of workers is set from the
+	// command line parameters
+	if err = downloader.Start(runCtx, handle); err != nil {
+		log.Fatal(err)
+	}
 
-	// init worker goroutines
-	for i := 0; i < numWorkers; i++ {
-		go worker(i, jobs, results)
+	// let the auto-tuner adjust the workers when a target speed is set
+	tunerDone := make(chan struct{})
+	tunerResults := make(chan tunerResult, 1)
+	if p.TargetThroughput > 0 {
+		go func() {
+			tunerResults <- autoTune(tunerDone)
+		}()
 	}
 
-	// start sending jobs
-	for i := 0; i < stats.TotalDownloads; i++ {
-		jobs <- urls[i]
+	// start sending jobs, the downloader decides which one is next
+	orderEntries(entries)
+	applyPriorities(entries)
+	sortByPriority(entries)
+	if err = queueEntries(entries); err != nil {
+		log.Fatal(err)
 	}
-	close(jobs)
 
-	// catch results
-	for i := 0; i < stats.TotalDownloads; i++ {
-		<-results
+	// drive the run through the control API
+	if p.ControlAddr != "" {
+		startControlServer()
+	}
+
+	// keep adding the lists of the spool directory and the standard input.
+	// The feed of -watch is never closed.
+	if p.WatchDir != "" {
+		downloader.Feed()
+		go watchSpool()
+	}
+	if p.EntriesFilepath == urlFileStdin {
+		go readStdin(downloader.Feed())
+	}
+	// -refresh queues the entries again and again, the run only ends when
+	// it's interrupted
+	if p.Refresh > 0 {
+		downloader.Feed()
+		go refreshLoop()
+	}
+	downloader.Close()
+	_ = downloader.Wait()
+
+	// an interrupt is handled by the signal handler, which exits once the
+	// progress is saved
+	if stopped() && !runAborted {
+		select {}
+	}
+
+	// print the final statistics, once the progress is no longer printed
+	stopReporters()
+	printProgressRow()
+	if p.ProgressFile != "" {
+		state := progressFinished
+		if runAborted {
+			state = progressInterrupted
+		}
+		writeProgressFile(state, stats.Snapshot(), 0)
+	}
+
+	if p.TargetThroughput > 0 {
+		close(tunerDone)
+		res := <-tunerResults
+		fmt.Println()
+		res.Print()
+	}
+
+	// list the failures so that they can be retried
+	writeFailed(failed, runQueue.byURL)
+	if p.SumsFile != "" && !runAborted {
+		writeSums()
+	}
+	saveSeenFilter()
+	stopAutosave()
+	if p.Autosave > 0 && !runAborted {
+		removeAutosaves()
+	}
+	// a session is kept until it's deleted, the autosaves of an aborted run
+	// still need the url states
+	if p.Session != "" {
+		if err := writeSaveFile(sessionPath(p.Session)); err != nil {
+			log.Println(err)
+		}
+	}
+	closeStateStore(p.Session == "" && (!runAborted || p.Autosave == 0))
+	runFinishHook(stats.Snapshot(), runAborted)
+	if capped > 0 {
+		fmt.Printf("%d urls were not downloaded because their hosts reached -max-files-per-host or -max-bytes-per-host\n", capped)
+	}
+	if contents.linked > 0 {
+		fmt.Printf("%d files had the same content as others and were replaced with hard links\n", contents.linked)
+	}
+	if extracted.archives > 0 {
+		fmt.Printf("%d archives were extracted to %d files\n", extracted.archives, extracted.files)
+	}
+	if extracted.failed > 0 {
+		fmt.Printf("%d archives couldn't be extracted, see the [EXTRACT] lines of the log\n", extracted.failed)
 	}
 
-	// print the final statistics
-	stats.Print()
 	stats.PrintEnd()
+	if p.ProfileRun {
+		fmt.Println()
+		fmt.Print(runProfile.report())
+	}
+	if runAborted {
+		fmt.Println()
+		fmt.Print(failures.diagnosis())
+	}
 }
 
 func main() {
+	// must come before anything keeps a reference to the arguments
+	proctitle.Init()
+
+	// "massivedl diff" compares the reports of two runs
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		os.Exit(runDiff(os.Args[2:]))
+	}
+	// "massivedl sessions" manages the named sessions, resuming one is a run
+	// with -load
+	if len(os.Args) > 1 && os.Args[1] == "sessions" {
+		args, status := runSessions(os.Args[2:])
+		if args == nil {
+			os.Exit(status)
+		}
+		os.Args = append(os.Args[:1], args...)
+	}
+
 	// initialize statistics
 	// statistics should be initialized before parsing cmdLineParams
 	// parsing command line params might alter the statistics when loading progress
@@ -401,4 +1700,7 @@ func main() {
 
 	// start downloading
 	run(p)
+	if runAborted {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// how the mirrors of an entry are ordered before downloading
+const (
+	mirrorSelectOrder   = "order"   // try the urls in the order they are listed
+	mirrorSelectFastest = "fastest" // try the url that answers a HEAD request first
+)
+
+// mirrorSeparator separates the mirror urls of an entry in the input files
+const mirrorSeparator = "|"
+
+// parseURLs parses a list of urls separated by mirrorSeparator. The first one
+// is the main url of the entry, the others are its mirrors.
+func parseURLs(s string) (*url.URL, []*url.URL, error) {
+	var urls []*url.URL
+	for _, part := range strings.Split(s, mirrorSeparator) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		u, err := url.Parse(part)
+		if err != nil {
+			return nil, nil, err
+		}
+		urls = append(urls, u)
+	}
+
+	if len(urls) == 0 {
+		return nil, nil, errors.New("no url given")
+	}
+
+	return urls[0], urls[1:], nil
+}
+
+// joinURLs is the reverse of parseURLs
+func joinURLs(entry dataEntry) string {
+	s := entry.url.String()
+	for _, m := range entry.mirrors {
+		s += mirrorSeparator + m.String()
+	}
+	return s
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+)
+
+// what happens when several entries are saved under the same name
+const (
+	conflictSkip      = "skip"      // only download the first entry
+	conflictOverwrite = "overwrite" // only download the last entry
+	conflictRename    = "rename"    // append (1), (2), ... to the later names
+	conflictError     = "error"     // refuse to start
+)
+
+// conflictKey returns the key under which name is checked for conflicts,
+// which ignores case on file systems that usually do
+func conflictKey(name string) string {
+	if runtime.GOOS == "windows" || runtime.GOOS == "darwin" {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// numberedName returns name with " (n)" inserted before its extension
+func numberedName(name string, n int) string {
+	ext := path.Ext(name)
+	return fmt.Sprintf("%s (%d)%s", strings.TrimSuffix(name, ext), n, ext)
+}
+
+// resolveConflicts applies p.OnConflict to entries that share an output name
+// and returns the entries that should be downloaded. Conflicts are resolved in
+// list order, so the same list always results in the same names.
+func resolveConflicts(entries []dataEntry) []dataEntry {
+	claimed := make(map[string]int, len(entries)) // key -> position in resolved
+	resolved := make([]dataEntry, 0, len(entries))
+	dropped := 0
+
+	for _, entry := range entries {
+		key := conflictKey(entry.name)
+
+		first, taken := claimed[key]
+		if !taken {
+			claimed[key] = len(resolved)
+			resolved = append(resolved, entry)
+			continue
+		}
+
+		switch p.OnConflict {
+		case conflictError:
+			fmt.Printf("%s and %s would both be saved as %s\n",
+				resolved[first].url, entry.url, entry.name)
+			os.Exit(1)
+
+		case conflictSkip:
+			log.Printf("[CONFLICT] skipping %s, %s is already saved as %s", entry.url, resolved[first].url, entry.name)
+			dropped++
+
+		case conflictOverwrite:
+			log.Printf("[CONFLICT] skipping %s, %s overwrites %s", resolved[first].url, entry.url, entry.name)
+			resolved[first] = entry
+			dropped++
+
+		default:
+			name := entry.name
+			for n := 1; taken; n++ {
+				name = numberedName(entry.name, n)
+				_, taken = claimed[conflictKey(name)]
+			}
+			log.Printf("[CONFLICT] saving %s as %s", entry.url, name)
+
+			entry.name = name
+			claimed[conflictKey(name)] = len(resolved)
+			resolved = append(resolved, entry)
+		}
+	}
+
+	if dropped > 0 {
+		fmt.Printf("%d entries are not downloaded because of name conflicts (-on-conflict=%s)\n", dropped, p.OnConflict)
+	}
+
+	return resolved
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func TestResolveConflicts(t *testing.T) {
+	defer func() { p = cmdLineParams{} }()
+
+	// a.zip is the name of the first three urls
+	names := []string{"a.zip", "a.zip", "a.zip", "b.zip"}
+	testCases := []struct {
+		onConflict string
+		expected   []string // url paths and names of the resolved entries
+	}{
+		{conflictRename, []string{"/0 a.zip", "/1 a (1).zip", "/2 a (2).zip", "/3 b.zip"}},
+		{conflictSkip, []string{"/0 a.zip", "/3 b.zip"}},
+		{conflictOverwrite, []string{"/2 a.zip", "/3 b.zip"}},
+	}
+
+	for _, testCase := range testCases {
+		p = cmdLineParams{OnConflict: testCase.onConflict}
+		var entries []dataEntry
+		for i, name := range names {
+			u, _ := url.Parse("http://example.com/" + strconv.Itoa(i))
+			entries = append(entries, dataEntry{url: u, name: name})
+		}
+
+		resolved := resolveConflicts(entries)
+		if len(resolved) != len(testCase.expected) {
+			t.Errorf("%s: expected %d entries received %d", testCase.onConflict, len(testCase.expected), len(resolved))
+			continue
+		}
+		for i, entry := range resolved {
+			if received := entry.url.Path + " " + entry.name; received != testCase.expected[i] {
+				t.Errorf("%s: expected %s received %s", testCase.onConflict, testCase.expected[i], received)
+			}
+		}
+	}
+}
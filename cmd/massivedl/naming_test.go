@@ -0,0 +1,99 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/dimkouv/massivedl/pkg/massivedl"
+)
+
+func TestOutputPathMatchesNamer(t *testing.T) {
+	defer func() { p = cmdLineParams{} }()
+
+	urls := []string{
+		"http://example.com/",
+		"http://example.com/a/b/c.zip",
+		"https://example.com/a%20b.txt?x=1",
+		"https://example.com/dir/",
+	}
+	testCases := []struct {
+		params cmdLineParams
+		spec   string
+	}{
+		{cmdLineParams{}, "basename"},
+		{cmdLineParams{NameStrategy: "basename"}, "basename"},
+		{cmdLineParams{NameStrategy: "preserve-path"}, "preserve-path"},
+		{cmdLineParams{NameStrategy: "template:{host}/{index}.{ext}"}, "template:{host}/{index}.{ext}"},
+		{cmdLineParams{NameStrategy: "basename", NameTemplate: "{host}/{path}"}, "template:{host}/{path}"},
+		{cmdLineParams{NameStrategy: "basename", UseChecksumAsPath: true}, "template:{sha256(url)}"},
+	}
+
+	for _, testCase := range testCases {
+		p = testCase.params
+		p.OutputDir = "downloads"
+		namer, err := massivedl.NewNamer(testCase.spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, raw := range urls {
+			u, _ := url.Parse(raw)
+			expected, err := namer.Name(massivedl.Entry{URL: raw}, &massivedl.Response{Index: i})
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected = path.Join("downloads", expected)
+
+			received := outputPath(dataEntry{url: u, index: i}, newOutputNamer())
+			if received != expected {
+				t.Errorf("%s %s: expected %s received %s", testCase.spec, raw, expected, received)
+			}
+		}
+	}
+}
+
+func TestSaveNamedAfterDownload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "massivedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = os.RemoveAll(dir); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	p = cmdLineParams{OutputDir: dir, OnConflict: conflictRename, NameStrategy: "content-hash"}
+	outputNamer = newOutputNamer()
+	defer func() { p, outputNamer = cmdLineParams{}, nil }()
+
+	// two urls with the same data are saved once
+	expected := path.Join(dir, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824.txt")
+	for _, raw := range []string{"http://example.com/a.txt", "http://example.com/b.txt"} {
+		u, _ := url.Parse(raw)
+		entry := dataEntry{url: u}
+		partPath := outputPath(entry, outputNamer) + massivedl.PartSuffix
+		if err = ioutil.WriteFile(partPath, []byte("hello"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		received, err := saveNamedAfterDownload(entry, partPath, &massivedl.Response{StatusCode: 200})
+		if err != nil || received != expected {
+			t.Errorf("%s: expected %s received %s (%v)", raw, expected, received, err)
+		}
+		if _, err = os.Stat(partPath); !os.IsNotExist(err) {
+			t.Errorf("%s: expected the part file to be gone received %v", raw, err)
+		}
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected 1 file received %d", len(files))
+	}
+}
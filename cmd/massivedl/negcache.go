@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"path"
+
+	"github.com/dimkouv/massivedl/internal/logging"
+	"github.com/dimkouv/massivedl/internal/negcache"
+	"github.com/dimkouv/massivedl/pkg/massivedl"
+)
+
+// negativeCacheFilename is the name of the negative cache in the directory
+// of the save files
+const negativeCacheFilename = "negative-cache.tsv"
+
+// negativeCache remembers the urls that were answered with 404 or 410 in
+// earlier runs, nil if -negative-cache-ttl is 0
+var negativeCache *negcache.Cache
+
+// openNegativeCache opens the negative cache in ~/.massivedl
+func openNegativeCache() *negcache.Cache {
+	c, err := negcache.Open(path.Join(getSaveFilesDirectory(), negativeCacheFilename), p.NegativeCacheTTL)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return c
+}
+
+// knownDead returns a failed result for an entry whose url failed
+// permanently in an earlier run
+func knownDead(entry dataEntry) (massivedl.Result, bool) {
+	if negativeCache == nil || p.IgnoreNegativeCache {
+		return massivedl.Result{}, false
+	}
+
+	dead, ok := negativeCache.Get(entry.url.String())
+	if !ok {
+		return massivedl.Result{}, false
+	}
+
+	return massivedl.Result{
+		Path:       entry.name,
+		StatusCode: dead.Status,
+		Err: fmt.Errorf("skipped, %w on %s (-ignore-negative-cache to retry)",
+			&massivedl.HTTPError{StatusCode: dead.Status, Status: fmt.Sprintf("%d %s", dead.Status, http.StatusText(dead.Status))},
+			dead.Time.Format("2006-01-02 15:04")),
+	}, true
+}
+
+// updateNegativeCache adds urls that were not found to the negative cache
+// and removes urls that were downloaded
+func updateNegativeCache(res logging.LogEntry) {
+	if negativeCache == nil {
+		return
+	}
+
+	var err error
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+		err = negativeCache.Put(res.Url, res.StatusCode)
+	case res.Result:
+		err = negativeCache.Delete(res.Url)
+	}
+
+	if err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+)
+
+// notifyTimeout is how long the -notify webhook may take to answer
+const notifyTimeout = 10 * time.Second
+
+// the events that -notify alerts about
+const (
+	notifyDiskFull      = "disk-full"
+	notifyDiskRecovered = "disk-space-recovered"
+)
+
+// notification is the JSON body posted to -notify
+type notification struct {
+	Run     string    `json:"run"`
+	Event   string    `json:"event"`
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+}
+
+// notify posts event to the -notify webhook in the background, failures are
+// only logged
+func notify(event, message string) {
+	if p.Notify == "" {
+		return
+	}
+	body, err := json.Marshal(notification{Run: runID, Event: event, Message: message, Time: time.Now()})
+	if err != nil {
+		log.Println("[NOTIFY]", err)
+		return
+	}
+
+	go func() {
+		client := &http.Client{Timeout: notifyTimeout}
+		response, err := client.Post(p.Notify, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Println("[NOTIFY]", err)
+			return
+		}
+		if err = response.Body.Close(); err != nil {
+			log.Printf("error closing response body: %v", err)
+		}
+		if response.StatusCode >= http.StatusMultipleChoices {
+			log.Printf("[NOTIFY] %s answered %s", p.Notify, response.Status)
+		}
+	}()
+}
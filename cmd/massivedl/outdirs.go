@@ -0,0 +1,139 @@
+package main
+
+import (
+	"log"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/dimkouv/massivedl/internal/diskspace"
+	"github.com/dimkouv/massivedl/internal/fileutil"
+	"github.com/dimkouv/massivedl/pkg/massivedl"
+)
+
+// placements counts the running downloads of every output directory, see
+// placeEntry
+var placements = struct {
+	lock   sync.Mutex
+	active map[string]int
+}{active: make(map[string]int)}
+
+// parseOutputDirs splits the comma separated directories of -outdir. The
+// first one is returned on its own, it holds the files of the run like
+// failed.csv, and is where entries are named.
+func parseOutputDirs(list string) (string, []string) {
+	var dirs []string
+	for _, dir := range strings.Split(list, ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			dirs = append(dirs, path.Clean(dir))
+		}
+	}
+	if len(dirs) == 0 {
+		log.Fatal("-outdir must not be empty")
+	}
+	return dirs[0], dirs[1:]
+}
+
+// outputDirs returns all directories of -outdir, the first one first
+func outputDirs() []string {
+	return append([]string{p.OutputDir}, p.OutputDirs...)
+}
+
+// relOutputPath returns savePath relative to the directory of -outdir it is
+// in
+func relOutputPath(savePath string) string {
+	for _, dir := range outputDirs() {
+		if rel := strings.TrimPrefix(savePath, path.Clean(dir)); rel != savePath && strings.HasPrefix(rel, "/") {
+			return rel[1:]
+		}
+	}
+	return strings.TrimPrefix(savePath, "/")
+}
+
+// findOutput returns the directory of -outdir in which the file rel already
+// exists or is partially downloaded, or the first one if it is in none of
+// them
+func findOutput(rel string) (string, bool) {
+	for _, dir := range outputDirs() {
+		filepath := path.Join(dir, rel)
+		if fileutil.FileOrPathExists(filepath) || fileutil.FileOrPathExists(filepath+massivedl.PartSuffix) {
+			return dir, true
+		}
+	}
+	return p.OutputDir, false
+}
+
+// locateOutput returns the path of the file name, which is named under the
+// first directory of -outdir, in the directory it already exists in
+func locateOutput(name string) string {
+	rel := relOutputPath(name)
+	dir, _ := findOutput(rel)
+	return path.Join(dir, rel)
+}
+
+// placeEntry returns where the file of entry is saved with several
+// directories of -outdir: where it already exists or is partially
+// downloaded, or else in the directory with most free space per running
+// download, leaving out those with less than -min-free-space. The returned
+// function must be called once the download is over.
+func placeEntry(entry dataEntry) (string, func()) {
+	if len(p.OutputDirs) == 0 {
+		return entry.name, func() {}
+	}
+
+	rel := relOutputPath(entry.name)
+	dir, found := findOutput(rel)
+
+	placements.lock.Lock()
+	defer placements.lock.Unlock()
+
+	if !found {
+		best := -1.0
+		for _, d := range outputDirs() {
+			free, err := diskspace.Free(d)
+			if err != nil || free < uint64(p.MinFreeSpace) {
+				continue
+			}
+			if score := float64(free) / float64(placements.active[d]+1); score > best {
+				dir, best = d, score
+			}
+		}
+	}
+	placements.active[dir]++
+
+	return path.Join(dir, rel), func() {
+		placements.lock.Lock()
+		defer placements.lock.Unlock()
+
+		placements.active[dir]--
+	}
+}
+
+// freeSpace returns the free space of all directories of -outdir together
+func freeSpace() (uint64, error) {
+	var total uint64
+	for _, dir := range outputDirs() {
+		free, err := diskspace.Free(dir)
+		if err != nil {
+			return 0, err
+		}
+		total += free
+	}
+	return total, nil
+}
+
+// mostFreeSpace returns the free space of the directory of -outdir that has
+// the most
+func mostFreeSpace() (uint64, error) {
+	var most uint64
+	for _, dir := range outputDirs() {
+		free, err := diskspace.Free(dir)
+		if err != nil {
+			return 0, err
+		}
+		if free > most {
+			most = free
+		}
+	}
+	return most, nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"github.com/dimkouv/massivedl/internal/term"
+)
+
+// pauseKey pauses and resumes the downloads in -tui mode
+const pauseKey = 'p'
+
+// keys reads the key presses of -tui from the terminal, see readKeys
+var keys struct {
+	restore func() error // restores the mode of the terminal, nil if no keys are read
+	typed   *os.File     // what is typed after the run was interrupted
+}
+
+// togglePause pauses the downloads, or resumes them if they are paused. The
+// workers finish their current download before they wait.
+func togglePause(by string) {
+	if downloader.Paused() {
+		downloader.Resume()
+		log.Printf("[PAUSE] resumed by %s", by)
+	} else {
+		downloader.Pause()
+		log.Printf("[PAUSE] paused by %s", by)
+	}
+}
+
+// readKeys toggles the pause whenever p is pressed in -tui mode. The
+// terminal is put into a mode in which the keys are read as they are
+// pressed, nothing happens if the standard input isn't a terminal or
+// carries the url list.
+func readKeys() {
+	if p.Progress != progressTUI || p.EntriesFilepath == urlFileStdin {
+		return
+	}
+	restore, err := term.Cbreak(os.Stdin)
+	if err != nil {
+		return
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		log.Println(err)
+		if err = restore(); err != nil {
+			log.Println(err)
+		}
+		return
+	}
+	keys.restore, keys.typed = restore, r
+
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := os.Stdin.Read(buf)
+			if err != nil {
+				return
+			}
+			// once the run is interrupted the input answers the question
+			// whether to save the progress
+			if stopped() {
+				if _, err = w.Write(buf[:n]); err != nil {
+					return
+				}
+				continue
+			}
+			for _, key := range buf[:n] {
+				if key == pauseKey {
+					togglePause("key press")
+				}
+			}
+		}
+	}()
+}
+
+// stopReadingKeys restores the mode of the terminal. It returns what is
+// typed from now on, nil if the keys weren't read and the standard input
+// can be read as usual.
+func stopReadingKeys() *os.File {
+	if keys.restore == nil {
+		return nil
+	}
+	if err := keys.restore(); err != nil {
+		log.Println(err)
+	}
+	return keys.typed
+}
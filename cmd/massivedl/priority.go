@@ -0,0 +1,130 @@
+package main
+
+import (
+	"log"
+	"math/rand"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// priorityPrefix starts the priority column of an entry, e.g. priority=10
+const priorityPrefix = "priority="
+
+// priorityRule is a rule of -priority-regex
+type priorityRule struct {
+	regex    *regexp.Regexp
+	priority int
+}
+
+// priorityRules are compiled from -priority-regex by compilePriorityRules
+var priorityRules []priorityRule
+
+// compilePriorityRules parses the "regex=priority" rules of -priority-regex.
+// The regular expression may contain "=", the priority is what follows the
+// last one.
+func compilePriorityRules() {
+	for _, rule := range p.PriorityRegexes {
+		i := strings.LastIndex(rule, "=")
+		if i <= 0 {
+			log.Fatalf("invalid -priority-regex %q, expected regex=priority", rule)
+		}
+		priority, err := strconv.Atoi(strings.TrimSpace(rule[i+1:]))
+		if err != nil {
+			log.Fatalf("invalid -priority-regex %q, the priority must be an integer", rule)
+		}
+		regex, err := regexp.Compile(rule[:i])
+		if err != nil {
+			log.Fatalf("invalid -priority-regex %q: %v", rule, err)
+		}
+		priorityRules = append(priorityRules, priorityRule{regex: regex, priority: priority})
+	}
+}
+
+// applyPriorities gives the entries without a priority of their own the
+// priority of the first rule of -priority-regex that matches their url
+func applyPriorities(entries []dataEntry) {
+	if len(priorityRules) == 0 {
+		return
+	}
+	for i := range entries {
+		if entries[i].priority != 0 {
+			continue
+		}
+		url := entries[i].url.String()
+		for _, rule := range priorityRules {
+			if rule.regex.MatchString(url) {
+				entries[i].priority = rule.priority
+				break
+			}
+		}
+	}
+}
+
+// orderEntries puts entries in the order of -shuffle or -sort-by-size, the
+// priorities are applied on top of it by sortByPriority
+func orderEntries(entries []dataEntry) {
+	switch {
+	case p.Shuffle:
+		rnd := rand.New(rand.NewSource(time.Now().UnixNano()))
+		rnd.Shuffle(len(entries), func(i, j int) {
+			entries[i], entries[j] = entries[j], entries[i]
+		})
+	case p.SortBySize:
+		sortBySize(entries)
+	}
+}
+
+// sortBySize orders entries by increasing size, as announced to parallel
+// HEAD requests. Entries of unknown size go last in their original order.
+func sortBySize(entries []dataEntry) {
+	sizes := make([]int64, len(entries))
+	for i := range sizes {
+		sizes[i] = unknownSize
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < p.ConcurrentRequests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				sizes[i] = probeEntry(entries[i]).size
+			}
+		}()
+	}
+	for i := range entries {
+		if stopped() {
+			break
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	sort.Stable(bySize{entries: entries, sizes: sizes})
+}
+
+// bySize sorts entries by their sizes, the unknown ones last
+type bySize struct {
+	entries []dataEntry
+	sizes   []int64
+}
+
+func (s bySize) Len() int { return len(s.entries) }
+
+func (s bySize) Less(i, j int) bool {
+	if s.sizes[i] < 0 || s.sizes[j] < 0 {
+		return s.sizes[i] >= 0
+	}
+	return s.sizes[i] < s.sizes[j]
+}
+
+func (s bySize) Swap(i, j int) {
+	s.entries[i], s.entries[j] = s.entries[j], s.entries[i]
+	s.sizes[i], s.sizes[j] = s.sizes[j], s.sizes[i]
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/proctitle"
+)
+
+// processTitleInterval is how often -process-title is updated
+const processTitleInterval = 2 * time.Second
+
+// updateProcessTitle shows the progress in the command line of the process
+// until done is closed or the downloads stop, e.g.
+// "massivedl [3456/100000 42.0MB/s]". The speed is the one of the last
+// interval.
+func updateProcessTitle(done <-chan struct{}) {
+	last := stats.Snapshot()
+	for !stopped() {
+		current := stats.Snapshot()
+		speed := float64(current.TotalDownloadedBytes-last.TotalDownloadedBytes) / processTitleInterval.Seconds()
+		title := fmt.Sprintf("massivedl [%d/%d %.1fMB/s]",
+			current.TotalDownloaded+current.TotalFailed+current.TotalCapped, current.TotalDownloads, speed/1000000)
+		if err := proctitle.Set(title); err != nil {
+			log.Println("[TITLE]", err)
+			return
+		}
+
+		last = current
+		if !sleepReporting(done, processTitleInterval) {
+			return
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// profileHintShare is the share of the worker time above which -profile-run
+// suggests what to change
+const profileHintShare = 0.2
+
+// profileBusyShare is the utilization above which -profile-run suggests more
+// workers
+const profileBusyShare = 0.9
+
+// runProfile adds up where the time of the workers goes, for -profile-run
+var runProfile = &profile{byState: map[string]time.Duration{}}
+
+type profile struct {
+	lock       sync.Mutex
+	byState    map[string]time.Duration // worker time by state of workerStates
+	retries    time.Duration            // failed attempts that were retried and their Retry-After waits
+	retried    int
+	diskWrites time.Duration // writes of the downloads into files
+}
+
+// addState adds the time d a worker spent in state
+func (pr *profile) addState(state string, d time.Duration) {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+
+	pr.byState[state] += d
+}
+
+// addRetry adds the time d of an attempt that is retried
+func (pr *profile) addRetry(d time.Duration) {
+	if !p.ProfileRun {
+		return
+	}
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+
+	pr.retries += d
+	pr.retried++
+}
+
+// addDiskWrite adds the time d spent writing into a file
+func (pr *profile) addDiskWrite(d time.Duration) {
+	if !p.ProfileRun {
+		return
+	}
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+
+	pr.diskWrites += d
+}
+
+// report describes where the time of the workers went, including the
+// current states of the workers that are still running, and which settings
+// could make the run faster
+func (pr *profile) report() string {
+	workerStates.lock.Lock()
+	pr.lock.Lock()
+	byState := make(map[string]time.Duration, len(pr.byState))
+	var total time.Duration
+	for state, d := range pr.byState {
+		byState[state] += d
+		total += d
+	}
+	for _, ws := range workerStates.byWorker {
+		d := time.Since(ws.since)
+		byState[ws.state] += d
+		total += d
+	}
+	retries, retried, diskWrites := pr.retries, pr.retried, pr.diskWrites
+	pr.lock.Unlock()
+	workerStates.lock.Unlock()
+
+	var b strings.Builder
+	if total <= 0 {
+		fmt.Fprintln(&b, "Run profile: no worker time to analyse")
+		return b.String()
+	}
+	share := func(d time.Duration) float64 {
+		return float64(d) / float64(total)
+	}
+
+	states := make([]string, 0, len(byState))
+	for state := range byState {
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if byState[states[i]] != byState[states[j]] {
+			return byState[states[i]] > byState[states[j]]
+		}
+		return states[i] < states[j]
+	})
+
+	fmt.Fprintf(&b, "Run profile, %s of worker time:\n", total.Round(time.Millisecond))
+	for _, state := range states {
+		fmt.Fprintf(&b, "  %-26s %12s %5.1f%%\n", state, byState[state].Round(time.Millisecond), 100*share(byState[state]))
+	}
+
+	downloading := byState[workerDownloading]
+	fmt.Fprintf(&b, "Worker utilization:         %.1f%%\n", 100*share(downloading))
+	fmt.Fprintf(&b, "Time lost to delays:        %s (%.1f%%)\n", byState[workerDelay].Round(time.Millisecond), 100*share(byState[workerDelay]))
+	fmt.Fprintf(&b, "Time lost to retries:       %s in %d retries (%.1f%%)\n", retries.Round(time.Millisecond), retried, 100*share(retries))
+	fmt.Fprintf(&b, "Disk-write wait:            %s (%.1f%%)\n", diskWrites.Round(time.Millisecond), 100*share(diskWrites))
+
+	var hints []string
+	if share(byState[workerDelay]) > profileHintShare {
+		hints = append(hints, "the workers spend much time in -delay: lower it, or use -delay-per-host which only spaces out the requests to the same host")
+	}
+	if share(byState[workerWaiting]) > profileHintShare {
+		hints = append(hints, "the workers often wait for a job: with few hosts raise -max-per-host or lower -delay-per-host, otherwise fewer -workers are enough")
+	}
+	if share(retries) > profileHintShare {
+		hints = append(hints, "retries cost much time: check the [RETRY] lines of the log, and -retries and -retry-on")
+	}
+	if share(diskWrites) > profileHintShare {
+		hints = append(hints, "writing to the disk is slow: fewer -workers or -segments, -to-memory for small files, or an -outdir on a faster disk")
+	}
+	if share(byState[workerDiskSpace]) > profileHintShare {
+		hints = append(hints, "the workers wait for free space: lower -min-free-space or add directories to -outdir")
+	}
+	if len(hints) == 0 && share(downloading) > profileBusyShare {
+		hints = append(hints, "the workers are busy downloading: more -workers may be faster if the bandwidth and the servers allow")
+	}
+
+	if len(hints) == 0 {
+		fmt.Fprintln(&b, "Nothing stands out")
+	}
+	for _, hint := range hints {
+		fmt.Fprintf(&b, "Hint: %s\n", hint)
+	}
+	return b.String()
+}
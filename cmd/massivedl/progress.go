@@ -0,0 +1,121 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/statistics"
+)
+
+// how the progress of the downloads is printed
+const (
+	progressTable = "table" // a table row that is redrawn in place
+	progressLine  = "line"  // a new summary line for every change
+	progressNone  = "none"  // nothing until the end of the run
+	progressTUI   = "tui"   // a progress bar per worker and a summary, redrawn in place
+)
+
+// startReporters prints the progress and keeps -progress-file and
+// -process-title up to date until the returned function is called, which
+// waits for them to stop so that nothing is reported after the final row
+func startReporters() (stop func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	start := func(report func(done <-chan struct{})) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			report(done)
+		}()
+	}
+
+	start(printProgress)
+	if p.ProgressFile != "" {
+		start(updateProgressFile)
+	}
+	if p.ProcessTitle {
+		start(updateProcessTitle)
+	}
+
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// sleepReporting waits for d and reports whether a reporter goes on, it
+// returns early when done is closed or the run is interrupted
+func sleepReporting(done <-chan struct{}, d time.Duration) bool {
+	select {
+	case <-done:
+		return false
+	case <-runCtx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// printProgress prints the statistics every p.ProgressInterval until done is
+// closed or the downloads stop. A row is only printed when the statistics
+// changed since the previous one, so idle periods don't fill up redirected
+// output.
+func printProgress(done <-chan struct{}) {
+	if p.Progress == progressNone {
+		return
+	}
+
+	// parameters saved by older versions have neither of the progress fields
+	interval := p.ProgressInterval
+	if interval <= 0 {
+		interval = 500 * time.Millisecond
+	}
+
+	// the bars move with every received byte, so they are always redrawn
+	if p.Progress == progressTUI {
+		for !stopped() {
+			printTUI()
+			if !sleepReporting(done, interval) {
+				return
+			}
+		}
+		return
+	}
+
+	// the table starts with an empty row, summary lines only start with the
+	// first finished download
+	last := stats.Snapshot()
+	if p.Progress != progressLine {
+		stats.PrintHeader()
+		stats.Print()
+	}
+
+	for sleepReporting(done, interval) {
+		if current := stats.Snapshot(); progressChanged(last, current) {
+			printProgressRow()
+			last = current
+		}
+	}
+}
+
+// printProgressRow prints the current statistics in the format of -progress
+func printProgressRow() {
+	switch p.Progress {
+	case progressLine:
+		stats.PrintLine()
+	case progressNone:
+	case progressTUI:
+		printTUI()
+	default:
+		stats.Print()
+	}
+}
+
+// progressChanged reports whether any of the printed counters differ
+func progressChanged(a, b statistics.Statistics) bool {
+	return a.TotalDownloads != b.TotalDownloads ||
+		a.TotalDownloaded != b.TotalDownloaded ||
+		a.TotalFailed != b.TotalFailed ||
+		a.TotalCapped != b.TotalCapped ||
+		a.TotalDownloadedBytes != b.TotalDownloadedBytes
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/fileutil"
+	"github.com/dimkouv/massivedl/internal/statistics"
+)
+
+// progressFileInterval is how often -progress-file is written
+const progressFileInterval = time.Second
+
+// the states of a run in -progress-file
+const (
+	progressRunning     = "running"
+	progressFinished    = "finished"
+	progressInterrupted = "interrupted"
+)
+
+// progressRecord is the content of -progress-file
+type progressRecord struct {
+	RunID           string                `json:"runId"`
+	Time            time.Time             `json:"time"`
+	State           string                `json:"state"`
+	Stats           statistics.Statistics `json:"stats"`
+	BytesPerSec     float64               `json:"bytesPerSec"` // over the last interval
+	FilesPerSec     float64               `json:"filesPerSec"` // since the start
+	EtaSeconds      *float64              `json:"etaSeconds"`  // null until the first download finished
+	Paused          bool                  `json:"paused"`
+	ActiveDownloads []activeDownload      `json:"activeDownloads"`
+}
+
+// activeDownload is a download that a worker is busy with
+type activeDownload struct {
+	Worker    int    `json:"worker"`
+	Url       string `json:"url"`
+	Path      string `json:"path"`
+	Bytes     int64  `json:"bytes"`
+	Size      int64  `json:"size"` // -1 if unknown
+	ElapsedMs int64  `json:"elapsedMs"`
+}
+
+// updateProgressFile writes -progress-file every progressFileInterval until
+// done is closed or the downloads stop
+func updateProgressFile(done <-chan struct{}) {
+	last := stats.Snapshot()
+	for !stopped() {
+		current := stats.Snapshot()
+		speed := float64(current.TotalDownloadedBytes-last.TotalDownloadedBytes) / progressFileInterval.Seconds()
+		writeProgressFile(progressRunning, current, speed)
+
+		last = current
+		if !sleepReporting(done, progressFileInterval) {
+			return
+		}
+	}
+}
+
+// writeProgressFile replaces -progress-file with the current progress, so
+// that readers never see a partly written file
+func writeProgressFile(state string, s statistics.Statistics, bytesPerSec float64) {
+	record := progressRecord{
+		RunID:           runID,
+		Time:            time.Now().UTC(),
+		State:           state,
+		Stats:           s,
+		BytesPerSec:     bytesPerSec,
+		Paused:          downloader.Paused(),
+		ActiveDownloads: activeDownloads(),
+	}
+
+	done := s.TotalDownloaded + s.TotalFailed + s.TotalCapped
+	if elapsed := time.Since(s.StartTime).Seconds(); elapsed > 0 && done > 0 {
+		record.FilesPerSec = float64(done) / elapsed
+		eta := float64(s.TotalDownloads-done) / record.FilesPerSec
+		record.EtaSeconds = &eta
+	}
+
+	b, err := json.MarshalIndent(record, "", "  ")
+	if err == nil {
+		err = fileutil.WriteFileAtomic(p.ProgressFile, append(b, '\n'), 0644)
+	}
+	if err != nil {
+		log.Printf("unable to write -progress-file: %v", err)
+	}
+}
+
+// activeDownloads returns the downloads the workers are busy with, by worker
+func activeDownloads() []activeDownload {
+	activities.lock.Lock()
+	defer activities.lock.Unlock()
+
+	active := []activeDownload{}
+	for id, a := range activities.byWorker {
+		if a == nil {
+			continue
+		}
+		active = append(active, activeDownload{
+			Worker:    id,
+			Url:       a.url,
+			Path:      a.name,
+			Bytes:     a.done,
+			Size:      a.size,
+			ElapsedMs: time.Since(a.started).Milliseconds(),
+		})
+	}
+	sort.Slice(active, func(i, j int) bool { return active[i].Worker < active[j].Worker })
+
+	return active
+}
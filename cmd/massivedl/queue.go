@@ -0,0 +1,143 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"github.com/dimkouv/massivedl/pkg/massivedl"
+)
+
+// runQueue holds the entries of the run that were passed to the downloader.
+// Entries can be added while the others are downloaded, e.g. through
+// -control-addr, until the run is over.
+var runQueue = struct {
+	lock  sync.Mutex
+	byURL map[string]dataEntry
+	urls  map[string]bool // normalized urls, for -dedup
+	names map[string]bool // conflict keys of the output names
+	count int             // number of queued entries
+}{byURL: map[string]dataEntry{}, urls: map[string]bool{}, names: map[string]bool{}}
+
+// queueEntries queues entries, which already have their output names
+func queueEntries(entries []dataEntry) error {
+	runQueue.lock.Lock()
+	defer runQueue.lock.Unlock()
+
+	return queueEntriesLocked(entries)
+}
+
+// queueEntriesLocked queues entries, runQueue.lock must be held. It fails
+// with massivedl.ErrRunFinished once the run is over.
+func queueEntriesLocked(entries []dataEntry) error {
+	added := make([]massivedl.Entry, len(entries))
+	for i, entry := range entries {
+		added[i] = downloaderEntry(entry)
+	}
+	markPending(entries)
+	if err := downloader.Add(added...); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		runQueue.byURL[entry.url.String()] = entry
+		runQueue.names[conflictKey(entry.name)] = true
+	}
+	runQueue.count += len(entries)
+
+	return nil
+}
+
+// addEntries names and queues entries that are added while the run goes
+// on. Names that are already used in the run are numbered, like with
+// -on-conflict rename.
+func addEntries(entries []dataEntry) error {
+	// the filters may send HEAD requests, so they run before the queue is
+	// locked
+	if hasFilters() {
+		var counts map[string]int
+		entries, counts = filterEntries(entries)
+		if n := sumCounts(counts); n > 0 {
+			log.Printf("[FILTER] skipping %d added entries: %s", n, formatFilterCounts(counts))
+		}
+	}
+
+	orderEntries(entries)
+	applyPriorities(entries)
+
+	runQueue.lock.Lock()
+	defer runQueue.lock.Unlock()
+
+	if dedupURLs() {
+		var n int
+		if entries, n = dropDuplicates(entries, runQueue.urls); n > 0 {
+			log.Printf("[DEDUP] skipping %d added entries that were queued before", n)
+		}
+	}
+
+	for i := range entries {
+		entries[i].index += runQueue.count
+		base := outputPath(entries[i], outputNamer)
+		name := base
+		for n := 1; runQueue.names[conflictKey(name)]; n++ {
+			name = numberedName(base, n)
+		}
+		entries[i].name = name
+		runQueue.names[conflictKey(name)] = true
+	}
+
+	sortByPriority(entries)
+	if err := queueEntriesLocked(entries); err != nil {
+		return err
+	}
+	stats.AddDownloads(len(entries))
+	return nil
+}
+
+// queuedEntry returns the entry of url
+func queuedEntry(url string) dataEntry {
+	runQueue.lock.Lock()
+	defer runQueue.lock.Unlock()
+
+	return runQueue.byURL[url]
+}
+
+// pauseGate holds back the workers while the downloads are paused
+type pauseGate struct {
+	lock sync.Mutex
+	cond *sync.Cond
+	on   bool
+}
+
+func newPauseGate() *pauseGate {
+	g := &pauseGate{}
+	g.cond = sync.NewCond(&g.lock)
+	return g
+}
+
+// Set pauses or resumes the downloads. Running downloads are finished, the
+// workers only wait before taking the next one.
+func (g *pauseGate) Set(on bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.on = on
+	g.cond.Broadcast()
+}
+
+// On reports whether the downloads are paused
+func (g *pauseGate) On() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	return g.on
+}
+
+// Wait blocks while the downloads are paused
+func (g *pauseGate) Wait() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	for g.on {
+		g.cond.Wait()
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/dimkouv/massivedl/internal/httputil"
+	"github.com/dimkouv/massivedl/internal/scrape"
+)
+
+// the elements whose links -recurse-depth follows. Pages count as a level,
+// the assets of a page are also downloaded with the last level of pages.
+var (
+	pageSelector  = mustParseSelector("a, area, frame, iframe")
+	assetSelector = mustParseSelector("img, script, link[rel~=stylesheet i], link[rel~=icon i], source, video, audio, embed, object")
+)
+
+// sniffLen is the number of bytes http.DetectContentType looks at
+const sniffLen = 512
+
+func mustParseSelector(s string) *scrape.Selector {
+	sel, err := scrape.ParseSelector(s)
+	if err != nil {
+		panic(err)
+	}
+	return sel
+}
+
+// recursion holds the state of -recurse-depth. It's only used by the
+// results loop of run.
+var recursion struct {
+	visited map[string]bool // normalized urls and mirror names that were queued
+	hosts   map[string]bool // hosts of the seeds, which may always be spanned
+	depth   map[string]int  // levels of the queued urls below the seeds
+}
+
+// startRecursion makes the entries the seeds of -recurse-depth. They are
+// saved at their host and path under -outdir, like the pages that are found.
+func startRecursion(entries []dataEntry) {
+	recursion.visited = map[string]bool{}
+	recursion.hosts = map[string]bool{}
+	recursion.depth = map[string]int{}
+
+	for i := range entries {
+		recursion.hosts[entries[i].url.Hostname()] = true
+		if entries[i].outputName == "" {
+			entries[i].outputName = mirrorName(entries[i].url)
+		}
+		recursion.visited[httputil.NormalizeURL(entries[i].url)] = true
+		recursion.visited[entries[i].outputName] = true
+	}
+}
+
+// mirrorName returns the path relative to -outdir of u in a mirror:
+// <host>/<path>, with index.html for directories and the query appended like
+// wget does
+func mirrorName(u *url.URL) string {
+	name := u.Host + "/" + strings.TrimPrefix(u.EscapedPath(), "/")
+	if strings.HasSuffix(name, "/") {
+		name += "index.html"
+	}
+	if u.RawQuery != "" {
+		name += "?" + u.RawQuery
+	}
+	return path.Clean(strings.ReplaceAll(name, "..", "_"))
+}
+
+// mayVisit reports whether the links to u are followed: the seed hosts are,
+// other hosts only if they match -span-hosts
+func mayVisit(u *url.URL) bool {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	host := u.Hostname()
+	if recursion.hosts[host] {
+		return true
+	}
+	for _, pattern := range strings.Split(p.SpanHosts, ",") {
+		if ok, _ := path.Match(strings.TrimSpace(pattern), host); ok && pattern != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// followLinks queues the links of the page of entry that was saved at
+// filePath. Pages above -recurse-depth have all of their links followed,
+// those at the depth only their assets, and other files none.
+func followLinks(entry dataEntry, filePath string) {
+	if entry.url == nil {
+		return
+	}
+	depth := recursion.depth[entry.url.String()]
+	if depth > p.RecurseDepth || !isHTML(filePath) {
+		return
+	}
+
+	links := pageLinks(entry.url, filePath, assetSelector)
+	if depth < p.RecurseDepth {
+		links = append(pageLinks(entry.url, filePath, pageSelector), links...)
+	}
+
+	// urls that are saved under the same name, like / and /index.html, are
+	// downloaded once
+	var found []dataEntry
+	for _, u := range links {
+		u.Fragment = ""
+		key, name := httputil.NormalizeURL(u), mirrorName(u)
+		if recursion.visited[key] || recursion.visited[name] || !mayVisit(u) {
+			continue
+		}
+		recursion.visited[key] = true
+		recursion.visited[name] = true
+		recursion.depth[u.String()] = depth + 1
+		found = append(found, dataEntry{url: u, outputName: name, header: entry.header, job: entry.job})
+	}
+	if len(found) == 0 {
+		return
+	}
+
+	log.Printf("[RECURSE] %s: following %d links at depth %d", entry.url, len(found), depth+1)
+	if err := addEntries(found); err != nil {
+		log.Printf("[RECURSE] %v", err)
+	}
+}
+
+// isHTML reports whether the file at filePath looks like an HTML page
+func isHTML(filePath string) bool {
+	fh, err := os.Open(filePath)
+	if err != nil {
+		return false
+	}
+	defer func() {
+		if err = fh.Close(); err != nil {
+			log.Printf("unable to close file: %v", err)
+		}
+	}()
+
+	head := make([]byte, sniffLen)
+	n, _ := fh.Read(head)
+	return strings.HasPrefix(http.DetectContentType(head[:n]), "text/html")
+}
+
+// pageLinks returns the links of the elements matching sel of the page at
+// filePath, which was downloaded from pageURL
+func pageLinks(pageURL *url.URL, filePath string, sel *scrape.Selector) []*url.URL {
+	fh, err := os.Open(filePath)
+	if err != nil {
+		log.Printf("[RECURSE] %v", err)
+		return nil
+	}
+	page, err := scrape.Links(fh, sel)
+	if closeErr := fh.Close(); closeErr != nil {
+		log.Printf("unable to close file: %v", closeErr)
+	}
+	if err != nil {
+		log.Printf("[RECURSE] %s: %v", filePath, err)
+		return nil
+	}
+
+	base := pageURL
+	if page.Base != "" {
+		if b, err := url.Parse(page.Base); err == nil {
+			base = pageURL.ResolveReference(b)
+		}
+	}
+	var links []*url.URL
+	for _, entry := range resolveEntries(base, page.Links) {
+		links = append(links, entry.url)
+	}
+	return links
+}
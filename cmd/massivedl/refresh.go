@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log"
+	"sort"
+
+	"github.com/dimkouv/massivedl/internal/logging"
+)
+
+// queueIdle is signaled with -refresh when the results of all queued entries
+// are in
+var queueIdle = make(chan struct{}, 1)
+
+// noteIdle signals queueIdle if received is the number of queued entries
+func noteIdle(received int) {
+	runQueue.lock.Lock()
+	defer runQueue.lock.Unlock()
+
+	if received == runQueue.count {
+		select {
+		case queueIdle <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// refreshLoop queues all entries of the run again -refresh after the
+// downloads of the previous round are done, until the run is interrupted.
+// With -conditional, which -refresh implies, files that didn't change are
+// answered with 304 Not Modified and kept.
+func refreshLoop() {
+	for {
+		select {
+		case <-runCtx.Done():
+			return
+		case <-queueIdle:
+		}
+		if !sleep(p.Refresh) {
+			return
+		}
+
+		entries := queuedEntries()
+		log.Printf("[REFRESH] checking %d urls", len(entries))
+		stats.AddDownloads(len(entries))
+		if err := queueEntries(entries); err != nil {
+			log.Printf("[REFRESH] %v", err)
+			return
+		}
+	}
+}
+
+// queuedEntries returns every entry queued in the run, in the order of their
+// indexes
+func queuedEntries() []dataEntry {
+	runQueue.lock.Lock()
+	defer runQueue.lock.Unlock()
+
+	entries := make([]dataEntry, 0, len(runQueue.byURL))
+	for _, entry := range runQueue.byURL {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].index < entries[j].index })
+	return entries
+}
+
+// dropFailure removes an earlier failure of url from failed, so that with
+// -refresh only the last result of a url counts
+func dropFailure(failed []logging.LogEntry, url string) []logging.LogEntry {
+	for i, res := range failed {
+		if res.Url == url {
+			return append(failed[:i], failed[i+1:]...)
+		}
+	}
+	return failed
+}
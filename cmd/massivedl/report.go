@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/checksum"
+	"github.com/dimkouv/massivedl/internal/fileutil"
+	"github.com/dimkouv/massivedl/internal/logging"
+)
+
+// reportFormatJSON writes one JSON object per download, see reportRecord
+const reportFormatJSON = "json"
+
+// reportChecksumAlgorithm is used for the files that have no expected checksum
+const reportChecksumAlgorithm = "sha256"
+
+// reportSyncInterval is how often the records are flushed to the disk
+const reportSyncInterval = time.Second
+
+// reportRecord is a line of a -report json file
+type reportRecord struct {
+	RunID      string            `json:"runId"`
+	Time       time.Time         `json:"time"`
+	Url        string            `json:"url"`
+	Path       string            `json:"path"`
+	Success    bool              `json:"success"`
+	Status     int               `json:"status,omitempty"`
+	Bytes      uint64            `json:"bytes"`
+	DurationMs int64             `json:"durationMs"`
+	Attempts   int               `json:"attempts"`
+	Checksum   string            `json:"checksum,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Capped     bool              `json:"capped,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+// report receives the results of the downloads with -report
+var report struct {
+	file     *os.File
+	enc      *json.Encoder
+	lastSync time.Time
+}
+
+// parseReport splits a -report value into its format and path
+func parseReport(spec string) (string, string, error) {
+	i := strings.Index(spec, ":")
+	if i < 0 || spec[i+1:] == "" {
+		return "", "", fmt.Errorf("invalid -report %q, expected format:path, e.g. json:results.ndjson", spec)
+	}
+	format := spec[:i]
+	if format != reportFormatJSON {
+		return "", "", fmt.Errorf("invalid -report %q, unsupported format %s (supported: %s)", spec, format, reportFormatJSON)
+	}
+	return format, spec[i+1:], nil
+}
+
+// openReport opens the file of -report, records are appended to the ones of
+// earlier runs. The unfinished record of a run that crashed is dropped.
+func openReport() {
+	_, reportPath, err := parseReport(p.Report)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if report.file, err = fileutil.OpenAppendLines(reportPath, 0644); err != nil {
+		log.Fatal(err)
+	}
+	report.enc = json.NewEncoder(report.file)
+	report.enc.SetEscapeHTML(false)
+}
+
+// closeReport closes the file opened by openReport
+func closeReport() {
+	if report.file == nil {
+		return
+	}
+	if err := report.file.Sync(); err != nil {
+		log.Printf("unable to write -report: %v", err)
+	}
+	if err := report.file.Close(); err != nil {
+		fmt.Printf("unable to close file: %v", err)
+	}
+}
+
+// writeReport appends the result of the download of entry to the report as
+// soon as it finished, so that the report of a run that crashed still lists
+// every download it completed. The checksum is the expected one of the entry,
+// which was verified, or else the sha256 of the saved file.
+func writeReport(res logging.LogEntry, entry dataEntry) {
+	record := reportRecord{
+		RunID:      runID,
+		Time:       time.Now().UTC(),
+		Url:        res.Url,
+		Path:       res.Name,
+		Success:    res.Result,
+		Status:     res.StatusCode,
+		Bytes:      res.NBytes,
+		DurationMs: res.Duration.Milliseconds(),
+		Attempts:   res.Attempts,
+		Error:      res.Error,
+		Capped:     res.Capped,
+		Metadata:   entry.metadata,
+	}
+
+	if res.Result {
+		if !entry.checksum.IsZero() {
+			record.Checksum = entry.checksum.String()
+		} else if sum, err := checksum.SumFile(reportChecksumAlgorithm, res.Name); err == nil {
+			record.Checksum = sum.String()
+		}
+	}
+
+	// every record is written with a single write
+	if err := report.enc.Encode(record); err != nil {
+		log.Printf("unable to write -report: %v", err)
+	}
+	if time.Since(report.lastSync) >= reportSyncInterval {
+		if err := report.file.Sync(); err != nil {
+			log.Printf("unable to write -report: %v", err)
+		}
+		report.lastSync = time.Now()
+	}
+}
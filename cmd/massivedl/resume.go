@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/dimkouv/massivedl/internal/logging"
+	"github.com/dimkouv/massivedl/internal/urlstate"
+)
+
+// urlStates holds the state of every url of this run and of the runs that a
+// -load file continues. A resumed run skips the urls that are done, so lines
+// inserted into or removed from the list in the meantime and downloads that
+// finished out of order don't matter. nil in dry runs.
+var urlStates *urlstate.Store
+
+// stateFilePath is the file of urlStates, the one of the -load file or
+// ~/.massivedl/<run id>.state
+var stateFilePath string
+
+// loadedCompleted holds the completed urls of a save file of an older
+// version, which listed them instead of naming a state file
+var loadedCompleted []string
+
+// openStateStore opens the url states of the run
+func openStateStore() {
+	// a run that isn't continued starts over, also under the -run-id of an
+	// earlier run
+	if stateFilePath == "" {
+		stateFilePath = filepath.Join(getSaveFilesDirectory(), runID+".state")
+		if err := os.Remove(stateFilePath); err != nil && !os.IsNotExist(err) {
+			log.Fatal(err)
+		}
+	}
+
+	var err error
+	if urlStates, err = urlstate.Open(stateFilePath); err != nil {
+		log.Fatal(err)
+	}
+
+	records := make([]urlstate.Record, len(loadedCompleted))
+	for i, url := range loadedCompleted {
+		records[i] = urlstate.Record{URL: url, Status: urlstate.Done}
+	}
+	if err = urlStates.Put(records...); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// closeStateStore closes the url states, and removes them if nothing is
+// left that a save file could continue
+func closeStateStore(remove bool) {
+	if urlStates == nil {
+		return
+	}
+	if err := urlStates.Close(); err != nil {
+		fmt.Printf("unable to close file: %v", err)
+	}
+	if remove {
+		if err := os.Remove(stateFilePath); err != nil && !os.IsNotExist(err) {
+			log.Println(err)
+		}
+	}
+}
+
+// markPending records that entries are queued, the attempts of earlier runs
+// are kept
+func markPending(entries []dataEntry) {
+	if urlStates == nil {
+		return
+	}
+
+	records := make([]urlstate.Record, len(entries))
+	for i, entry := range entries {
+		url := entry.url.String()
+		record, _ := urlStates.Get(url)
+		records[i] = urlstate.Record{URL: url, Status: urlstate.Pending, Attempts: record.Attempts}
+	}
+	if err := urlStates.Put(records...); err != nil {
+		log.Println(err)
+	}
+}
+
+// markActive records that the download of url started
+func markActive(url string) {
+	if urlStates == nil {
+		return
+	}
+
+	record, _ := urlStates.Get(url)
+	record.URL, record.Status = url, urlstate.Active
+	if err := urlStates.Put(record); err != nil {
+		log.Println(err)
+	}
+}
+
+// markFinished records the result of a download
+func markFinished(res logging.LogEntry) {
+	if urlStates == nil {
+		return
+	}
+
+	// a capped url is left for a later run
+	if res.Capped {
+		return
+	}
+
+	record, _ := urlStates.Get(res.Url)
+	record = urlstate.Record{
+		URL:      res.Url,
+		Status:   urlstate.Failed,
+		Bytes:    int64(res.NBytes),
+		Attempts: record.Attempts + res.Attempts,
+		Error:    res.Error,
+	}
+	if res.Result {
+		record.Status, record.Path = urlstate.Done, res.Name
+		if sum := queuedEntry(res.Url).checksum; !sum.IsZero() {
+			record.Checksum = sum.String()
+		}
+	}
+	if err := urlStates.Put(record); err != nil {
+		log.Println(err)
+	}
+}
+
+// dropCompleted removes the entries that were downloaded before the progress
+// was saved
+func dropCompleted(entries []dataEntry) []dataEntry {
+	if urlStates == nil {
+		return entries
+	}
+
+	kept := entries[:0]
+	for _, entry := range entries {
+		if record, _ := urlStates.Get(entry.url.String()); record.Status != urlstate.Done {
+			kept = append(kept, entry)
+		}
+	}
+
+	if dropped := len(entries) - len(kept); dropped > 0 {
+		fmt.Printf("Skipping %d urls that were completed before the progress was saved\n", dropped)
+	}
+
+	return kept
+}
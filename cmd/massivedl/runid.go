@@ -0,0 +1,36 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+	"regexp"
+
+	"github.com/dimkouv/massivedl/pkg/massivedl"
+)
+
+// runID identifies the current run in the User-Agent and the log file, so
+// that the requests of a batch can be told apart in server and own logs
+var runID string
+
+// validRunID matches the ids that may be given with -run-id, they must fit
+// into a User-Agent comment
+var validRunID = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// newRunID returns a random id of 8 hex digits
+func newRunID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		log.Fatalf("unable to create a run id: %v", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// userAgent returns the User-Agent of all requests, the one of -useragent or
+// a browser User-Agent that names massivedl and the run id
+func userAgent() string {
+	if p.UserAgent != "" {
+		return p.UserAgent
+	}
+	return massivedl.DefaultUserAgent + " (run=" + runID + ")"
+}
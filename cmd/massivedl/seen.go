@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/dimkouv/massivedl/internal/bloom"
+)
+
+// seenFilter holds the urls that were downloaded by this and earlier runs,
+// nil without -seen-filter
+var seenFilter *bloom.Filter
+
+// loadSeenFilter loads the filter of -seen-filter, or creates a new one
+// sized from -seen-capacity and -seen-false-positive-rate
+func loadSeenFilter() *bloom.Filter {
+	f, err := bloom.Load(p.SeenFilter)
+	if os.IsNotExist(err) {
+		f = bloom.New(p.SeenCapacity, p.SeenFalsePositiveRate)
+		log.Printf("[SEEN] created %s (%d bytes)", p.SeenFilter, f.SizeBytes())
+		return f
+	}
+	if err != nil {
+		log.Fatalf("%s: %v", p.SeenFilter, err)
+	}
+
+	return f
+}
+
+// dropSeen removes the entries that were downloaded before, according to
+// seenFilter, and duplicates within entries. The duplicates are found with a
+// second filter of the same size, so that the full list of urls is never
+// kept in memory.
+func dropSeen(entries []dataEntry) []dataEntry {
+	inRun := bloom.New(p.SeenCapacity, p.SeenFalsePositiveRate)
+
+	kept := entries[:0]
+	for _, entry := range entries {
+		url := []byte(entry.url.String())
+		if seenFilter.Test(url) || inRun.TestAndAdd(url) {
+			continue
+		}
+		kept = append(kept, entry)
+	}
+
+	if dropped := len(entries) - len(kept); dropped > 0 {
+		fmt.Printf("Skipping %d urls that were already seen\n", dropped)
+	}
+
+	return kept
+}
+
+// saveSeenFilter stores seenFilter in -seen-filter
+func saveSeenFilter() {
+	if seenFilter == nil {
+		return
+	}
+
+	if err := seenFilter.Save(p.SeenFilter); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/dimkouv/massivedl/internal/checksum"
+	"github.com/dimkouv/massivedl/internal/fileutil"
+	"github.com/dimkouv/massivedl/internal/httputil"
+	"github.com/dimkouv/massivedl/pkg/massivedl"
+)
+
+// serverNamesFilename lists, in the output directory, the names that files
+// were saved under with -trust-server-names or a -name-strategy that names
+// them after their data, so that -skip-existing finds them in later runs
+const serverNamesFilename = ".massivedl-names.tsv"
+
+// serverNames holds the names chosen with -trust-server-names or after the
+// data of the files
+var serverNames struct {
+	lock    sync.Mutex
+	byURL   map[string]string // url -> path relative to the output directory
+	claimed map[string]bool   // conflict keys of the paths chosen in this run
+	file    *os.File
+}
+
+// openServerNames loads the names of earlier runs and opens the list for
+// appending. The names of entries are reserved, so that no server name
+// takes the place of a file that is yet to be downloaded.
+func openServerNames(entries []dataEntry) {
+	serverNames.byURL = map[string]string{}
+	serverNames.claimed = map[string]bool{}
+	for _, entry := range entries {
+		serverNames.claimed[conflictKey(entry.name)] = true
+	}
+
+	listPath := path.Join(p.OutputDir, serverNamesFilename)
+	f, err := fileutil.OpenAppendLines(listPath, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) == 2 {
+			serverNames.byURL[fields[0]] = fields[1]
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		log.Fatalf("%s: %v", listPath, err)
+	}
+
+	serverNames.file = f
+}
+
+// closeServerNames closes the list opened by openServerNames
+func closeServerNames() {
+	if serverNames.file == nil {
+		return
+	}
+	if err := serverNames.file.Close(); err != nil {
+		fmt.Printf("unable to close file: %v", err)
+	}
+}
+
+// savedServerName returns the path an earlier run saved entry under, if it
+// took the name from the server or from the data
+func savedServerName(entry dataEntry) (string, bool) {
+	serverNames.lock.Lock()
+	defer serverNames.lock.Unlock()
+
+	name, ok := serverNames.byURL[entry.url.String()]
+	if !ok {
+		return "", false
+	}
+	dir, _ := findOutput(name)
+	return path.Join(dir, name), true
+}
+
+// serverName returns the file name the server suggests for a response: the
+// name of its Content-Disposition header, or else the last element of the url
+// final that requested was redirected to. "" is returned if there is neither.
+func serverName(requested, final *url.URL, header http.Header) string {
+	if name := httputil.DispositionFilename(header); name != "" {
+		return name
+	}
+	if final == nil || final.String() == requested.String() {
+		return ""
+	}
+	if name := path.Base(final.Path); name != "/" && name != "." {
+		return httputil.SafeFilename(name)
+	}
+	return ""
+}
+
+// claimServerName returns where a download of entry is saved, given the
+// file name the server suggested: next to filepath, numbered like with
+// -on-conflict rename if another entry of the run or an existing file
+// already uses the name, unless -on-conflict is overwrite. filepath itself
+// is returned if the server suggested nothing.
+func claimServerName(entry dataEntry, filepath, name string) string {
+	if name == "" {
+		return filepath
+	}
+
+	serverNames.lock.Lock()
+	defer serverNames.lock.Unlock()
+
+	dir := path.Dir(filepath)
+	candidate := path.Join(dir, name)
+	for n := 1; candidate != filepath && (serverNames.claimed[conflictKey(candidate)] ||
+		p.OnConflict != conflictOverwrite && fileutil.FileOrPathExists(candidate)); n++ {
+		candidate = path.Join(dir, numberedName(name, n))
+	}
+	serverNames.claimed[conflictKey(candidate)] = true
+
+	if candidate != filepath {
+		log.Printf("[NAME] saving %s as %s", entry.url, candidate)
+	}
+	return candidate
+}
+
+// recordServerName remembers that entry was saved as savePath
+func recordServerName(entry dataEntry, savePath string) {
+	rel := relOutputPath(savePath)
+
+	serverNames.lock.Lock()
+	defer serverNames.lock.Unlock()
+
+	if serverNames.byURL[entry.url.String()] == rel {
+		return
+	}
+	serverNames.byURL[entry.url.String()] = rel
+	if _, err := fmt.Fprintf(serverNames.file, "%s\t%s\n", entry.url, rel); err != nil {
+		log.Println(err)
+	}
+}
+
+// saveNamedAfterDownload moves the complete part file of entry to the name
+// outputNamer gives it after its data and last, the last response of the
+// download, and returns where it was saved. A
+// file of that name holds the same data already with content-hash, so the
+// part file is dropped and the file is kept, unless -on-conflict is
+// overwrite.
+func saveNamedAfterDownload(entry dataEntry, partPath string, last *massivedl.Response) (string, error) {
+	sum, err := checksum.SumFile("sha256", partPath)
+	if err != nil {
+		return "", err
+	}
+	response := *last
+	response.Index, response.Downloaded, response.Checksum = entry.index, true, sum.String()
+	if response.URL == nil {
+		response.URL = entry.url
+	}
+	name, err := outputNamer.Name(massivedl.Entry{URL: entry.url.String(), Metadata: entry.metadata}, &response)
+	if err == nil && name == "" {
+		err = errors.New("empty file name")
+	}
+	if err != nil {
+		return "", fmt.Errorf("unable to name %s: %w", entry.url, err)
+	}
+	savePath := outputDirPath(name)
+
+	serverNames.lock.Lock()
+	defer serverNames.lock.Unlock()
+
+	if p.OnConflict != conflictOverwrite && fileutil.FileOrPathExists(savePath) {
+		log.Printf("[NAME] %s has the data of %s already", savePath, entry.url)
+		return savePath, os.Remove(partPath)
+	}
+	if err = os.MkdirAll(path.Dir(savePath), 0755); err != nil {
+		return "", err
+	}
+	log.Printf("[NAME] saving %s as %s", entry.url, savePath)
+	return savePath, os.Rename(partPath, savePath)
+}
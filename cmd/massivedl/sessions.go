@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/sizeutil"
+	"github.com/dimkouv/massivedl/internal/urlstate"
+)
+
+// sessionSuffix is the extension of the save files of named sessions
+const sessionSuffix = ".session"
+
+// sessionsUsage describes "massivedl sessions"
+const sessionsUsage = `Usage: massivedl sessions list
+       massivedl sessions show <name>
+       massivedl sessions resume <name>
+       massivedl sessions delete <name>
+
+Manages the runs started with -session <name>, which are saved in
+~/.massivedl/<name>.session. resume continues a session like -load.
+`
+
+// commandLine is the command line that started the run, that of the first
+// run of a -load file
+var commandLine []string
+
+// sessionPath returns the save file of the session name
+func sessionPath(name string) string {
+	return filepath.Join(getSaveFilesDirectory(), name+sessionSuffix)
+}
+
+// runSessions implements "massivedl sessions". It returns the arguments
+// that resume a session instead of an exit status, nil otherwise.
+func runSessions(args []string) (resumeArgs []string, status int) {
+	if len(args) == 1 && args[0] == "list" {
+		return nil, listSessions()
+	}
+	if len(args) != 2 || !validRunID.MatchString(args[1]) {
+		fmt.Fprint(os.Stderr, sessionsUsage)
+		return nil, 2
+	}
+
+	name := args[1]
+	save, err := readSession(name)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, 2
+	}
+
+	switch args[0] {
+	case "show":
+		showSession(name, save)
+		return nil, 0
+	case "resume":
+		return []string{"-load", sessionPath(name)}, 0
+	case "delete":
+		return nil, deleteSession(name, save)
+	}
+	fmt.Fprint(os.Stderr, sessionsUsage)
+	return nil, 2
+}
+
+// readSession reads the save file of the session name
+func readSession(name string) (saveEntry, error) {
+	var save saveEntry
+	b, err := ioutil.ReadFile(sessionPath(name))
+	if os.IsNotExist(err) {
+		return save, fmt.Errorf("there is no session %s", name)
+	}
+	if err != nil {
+		return save, err
+	}
+	if err = json.Unmarshal(b, &save); err != nil {
+		return save, fmt.Errorf("%s: %v", sessionPath(name), err)
+	}
+	return save, nil
+}
+
+// sessionProgress counts the urls of the session by their state
+func sessionProgress(save saveEntry) (counts map[urlstate.Status]int, total int) {
+	counts = map[urlstate.Status]int{}
+	records, err := urlstate.Read(save.StateFile)
+	if err != nil {
+		// a save file of an older version only lists the completed urls
+		counts[urlstate.Done] = len(save.Completed)
+		return counts, save.Stats.TotalDownloads
+	}
+	for _, record := range records {
+		counts[record.Status]++
+	}
+	return counts, len(records)
+}
+
+// formatDone describes how many of the total urls are done
+func formatDone(done, total int) string {
+	if total == 0 {
+		return fmt.Sprintf("%d/?", done)
+	}
+	return fmt.Sprintf("%d/%d (%.1f%%)", done, total, 100*float64(done)/float64(total))
+}
+
+// formatCommandLine joins args for a shell, quoting those that need it
+func formatCommandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if i == 0 {
+			arg = filepath.Base(arg)
+		}
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"\\$*?[]{}()<>|&;#~`") {
+			arg = "'" + strings.Replace(arg, "'", `'\''`, -1) + "'"
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+// listSessions prints every session with its progress
+func listSessions() int {
+	paths, err := filepath.Glob(filepath.Join(getSaveFilesDirectory(), "*"+sessionSuffix))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	sort.Strings(paths)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tDONE\tSAVED\tCOMMAND")
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), sessionSuffix)
+		save, err := readSession(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			continue
+		}
+		saved := "?"
+		if fi, err := os.Stat(path); err == nil {
+			saved = fi.ModTime().Format("2006-01-02 15:04")
+		}
+		counts, total := sessionProgress(save)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, formatDone(counts[urlstate.Done], total), saved, formatCommandLine(save.CommandLine))
+	}
+	if err = w.Flush(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	return 0
+}
+
+// showSession prints the details of the session name
+func showSession(name string, save saveEntry) {
+	counts, total := sessionProgress(save)
+
+	saved := "?"
+	if fi, err := os.Stat(sessionPath(name)); err == nil {
+		saved = fi.ModTime().Format(time.RFC1123)
+	}
+
+	fmt.Printf("Session:     %s\n", name)
+	fmt.Printf("Save file:   %s\n", sessionPath(name))
+	fmt.Printf("Saved:       %s\n", saved)
+	fmt.Printf("Directory:   %s\n", save.WorkingDirectory)
+	fmt.Printf("Command:     %s\n", formatCommandLine(save.CommandLine))
+	fmt.Printf("Done:        %s\n", formatDone(counts[urlstate.Done], total))
+	fmt.Printf("Failed:      %d\n", counts[urlstate.Failed])
+	fmt.Printf("Pending:     %d\n", counts[urlstate.Pending]+counts[urlstate.Active])
+	fmt.Printf("Downloaded:  %s\n", sizeutil.FormatSize(int64(save.Stats.TotalDownloadedBytes)))
+	if save.StateFile != "" {
+		fmt.Printf("State file:  %s\n", save.StateFile)
+	}
+}
+
+// deleteSession removes the save file and the url states of the session
+// name
+func deleteSession(name string, save saveEntry) int {
+	status := 0
+	for _, path := range []string{save.StateFile, sessionPath(name)} {
+		if path == "" {
+			continue
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+		}
+	}
+	if status == 0 {
+		fmt.Println("Deleted session", name)
+	}
+	return status
+}
@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package main
+
+// registerPauseSignals does nothing, there are no pause signals on this
+// system
+func registerPauseSignals() {}
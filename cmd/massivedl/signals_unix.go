@@ -0,0 +1,31 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// registerPauseSignals toggles the pause with SIGUSR1 and SIGTSTP (Ctrl+Z),
+// which then no longer suspends the process
+func registerPauseSignals() {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGUSR1, syscall.SIGTSTP)
+
+	go func() {
+		for sig := range sigChan {
+			togglePause(signalName(sig))
+		}
+	}()
+}
+
+// signalName returns the usual name of the pause signals
+func signalName(sig os.Signal) string {
+	if sig == syscall.SIGTSTP {
+		return "SIGTSTP"
+	}
+	return "SIGUSR1"
+}
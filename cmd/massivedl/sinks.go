@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/ndjson"
+	"github.com/dimkouv/massivedl/internal/parquet"
+)
+
+// parquetColumns are the columns of the files written by -parquet-dir
+var parquetColumns = []parquet.Column{
+	{Name: "url", Type: parquet.String},
+	{Name: "status", Type: parquet.Int32},
+	{Name: "time", Type: parquet.Timestamp},
+	{Name: "size", Type: parquet.Int64},
+	{Name: "content_type", Type: parquet.String},
+	{Name: "body", Type: parquet.Bytes, Optional: true},
+	{Name: "headers", Type: parquet.String, Optional: true},
+}
+
+// appendToSinks appends the response in partPath to the NDJSON and Parquet
+// sinks that are enabled and removes it
+func appendToSinks(url string, status int, header http.Header, partPath string) error {
+	b, err := ioutil.ReadFile(partPath)
+	if err != nil {
+		return err
+	}
+
+	captured := captureHeaders(header)
+
+	if ndjsonSink != nil {
+		rec := ndjson.NewRecord(url, status, b)
+		rec.Headers = captured
+		if err = ndjsonSink.Write(rec); err != nil {
+			return err
+		}
+	}
+
+	if parquetSink != nil {
+		// large bodies are left out, only their metadata is kept
+		var body interface{}
+		if int64(len(b)) <= p.ParquetMaxBody {
+			body = b
+		}
+
+		// captured headers are stored as a JSON object
+		var headers interface{}
+		if captured != nil {
+			j, err := json.Marshal(captured)
+			if err != nil {
+				return err
+			}
+			headers = string(j)
+		}
+
+		row := []interface{}{url, int32(status), time.Now(), int64(len(b)), header.Get("Content-Type"), body, headers}
+		if err = parquetSink.Write(row); err != nil {
+			return err
+		}
+	}
+
+	return os.Remove(partPath)
+}
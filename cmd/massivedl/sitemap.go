@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/feed"
+	"github.com/dimkouv/massivedl/internal/scrape"
+	"github.com/dimkouv/massivedl/internal/sitemap"
+)
+
+// maxSitemapDepth is the number of sitemap index files -from-sitemap follows
+// in a row, index files may list other index files
+const maxSitemapDepth = 5
+
+// listFetchTimeout bounds the download of a sitemap or a feed
+const listFetchTimeout = 5 * time.Minute
+
+// loadRemoteLists returns the entries of the sitemaps of -from-sitemap, of
+// the feeds of -from-feed and of the pages of -scrape. The job of an entry is
+// named after its list.
+func loadRemoteLists() ([]dataEntry, error) {
+	var entries []dataEntry
+	for _, raw := range p.FromSitemaps {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		batch, err := loadSitemap(u, 0, map[string]bool{})
+		if err != nil {
+			return nil, err
+		}
+		setJob(batch, jobName(u.Host+u.Path))
+		entries = append(entries, batch...)
+	}
+	for _, raw := range p.FromFeeds {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		batch, err := loadFeed(u)
+		if err != nil {
+			return nil, err
+		}
+		setJob(batch, jobName(u.Host+u.Path))
+		entries = append(entries, batch...)
+	}
+	if len(p.Scrapes) > 0 {
+		sel, err := scrape.ParseSelector(p.LinkSelector)
+		if err != nil {
+			return nil, err
+		}
+		var linkRegex *regexp.Regexp
+		if p.LinkRegex != "" {
+			if linkRegex, err = regexp.Compile(p.LinkRegex); err != nil {
+				return nil, err
+			}
+		}
+		for _, raw := range p.Scrapes {
+			u, err := url.Parse(raw)
+			if err != nil {
+				return nil, err
+			}
+			batch, err := loadPage(u, sel, linkRegex)
+			if err != nil {
+				return nil, err
+			}
+			setJob(batch, jobName(u.Host+u.Path))
+			entries = append(entries, batch...)
+		}
+	}
+	return entries, nil
+}
+
+// loadSitemap returns the pages of the sitemap at u and, with
+// -follow-sitemap-index, of the sitemaps an index file at u lists. Sitemaps
+// of an index that can't be read are reported and skipped.
+func loadSitemap(u *url.URL, depth int, visited map[string]bool) ([]dataEntry, error) {
+	visited[u.String()] = true
+
+	body, err := fetchList(u)
+	if err != nil {
+		return nil, err
+	}
+	sm, err := sitemap.Parse(body)
+	if closeErr := body.Close(); closeErr != nil {
+		log.Printf("error closing response body: %v", closeErr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", u, err)
+	}
+
+	entries := resolveEntries(u, sm.URLs)
+	if len(sm.Sitemaps) > 0 && !p.FollowSitemapIndex {
+		fmt.Printf("%s: skipping the %d sitemaps of the index, -follow-sitemap-index is off\n", u, len(sm.Sitemaps))
+		return entries, nil
+	}
+	for _, child := range resolveEntries(u, sm.Sitemaps) {
+		switch {
+		case visited[child.url.String()]:
+			continue
+		case depth+1 >= maxSitemapDepth:
+			fmt.Printf("%s: skipping, sitemap indexes are nested more than %d levels deep\n", child.url, maxSitemapDepth)
+			continue
+		}
+		batch, err := loadSitemap(child.url, depth+1, visited)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		entries = append(entries, batch...)
+	}
+	return entries, nil
+}
+
+// loadFeed returns the enclosures of the items of the feed at u, or the
+// links of the items without enclosures
+func loadFeed(u *url.URL) ([]dataEntry, error) {
+	body, err := fetchList(u)
+	if err != nil {
+		return nil, err
+	}
+	items, err := feed.Parse(body)
+	if closeErr := body.Close(); closeErr != nil {
+		log.Printf("error closing response body: %v", closeErr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", u, err)
+	}
+
+	var urls []string
+	for _, item := range items {
+		if len(item.Enclosures) > 0 {
+			urls = append(urls, item.Enclosures...)
+		} else if item.Link != "" {
+			urls = append(urls, item.Link)
+		}
+	}
+	return resolveEntries(u, urls), nil
+}
+
+// loadPage returns the links of the elements of the page at u that match
+// sel, and with -link-regex whose resolved url matches linkRegex
+func loadPage(u *url.URL, sel *scrape.Selector, linkRegex *regexp.Regexp) ([]dataEntry, error) {
+	body, err := fetchList(u)
+	if err != nil {
+		return nil, err
+	}
+	page, err := scrape.Links(body, sel)
+	if closeErr := body.Close(); closeErr != nil {
+		log.Printf("error closing response body: %v", closeErr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", u, err)
+	}
+
+	// links are relative to the <base> of the page, if it has one
+	base := u
+	if page.Base != "" {
+		if b, err := url.Parse(page.Base); err == nil {
+			base = u.ResolveReference(b)
+		}
+	}
+
+	entries := resolveEntries(base, page.Links)
+	kept := entries[:0]
+	for _, entry := range entries {
+		if entry.url.Scheme != "http" && entry.url.Scheme != "https" {
+			continue // mailto:, javascript: and the like
+		}
+		if linkRegex == nil || linkRegex.MatchString(entry.url.String()) {
+			kept = append(kept, entry)
+		}
+	}
+	if len(kept) == 0 {
+		fmt.Printf("%s: no links found\n", u)
+	}
+	return kept, nil
+}
+
+// resolveEntries returns an entry for every url of a list at base, relative
+// urls are resolved against base. Invalid urls are reported and skipped.
+func resolveEntries(base *url.URL, urls []string) []dataEntry {
+	entries := make([]dataEntry, 0, len(urls))
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil {
+			fmt.Printf("%s: %s\n", raw, err)
+			continue
+		}
+		entries = append(entries, dataEntry{url: base.ResolveReference(u)})
+	}
+	return entries
+}
+
+// fetchList downloads a sitemap, a feed or a page with the headers of the
+// downloads
+func fetchList(u *url.URL) (io.ReadCloser, error) {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("%s: only http and https lists can be read", u)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), listFetchTimeout)
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	req.Header = requestHeader(dataEntry{url: u}, userAgent())
+
+	response, err := httpClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if response.StatusCode != http.StatusOK {
+		if err = response.Body.Close(); err != nil {
+			log.Printf("error closing response body: %v", err)
+		}
+		cancel()
+		return nil, fmt.Errorf("%s: %s", u, response.Status)
+	}
+	return cancelOnClose{response.Body, cancel}, nil
+}
+
+// cancelOnClose cancels the context of a request when its body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
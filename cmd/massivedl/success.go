@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/dimkouv/massivedl/internal/expr"
+)
+
+// errRejected is returned for responses that do not match -success-if
+var errRejected = errors.New("response rejected by -success-if")
+
+// successCheck decides whether a response counts as a success, if set
+var successCheck *expr.Expr
+
+// checkSuccess evaluates successCheck for a downloaded response. The
+// variables status, size, url and attempt and the function header(name) are
+// available to the expression.
+func checkSuccess(url string, status int, header http.Header, partPath string, attempt int) error {
+	fi, err := os.Stat(partPath)
+	if err != nil {
+		return err
+	}
+
+	env := expr.Env{
+		Vars: map[string]interface{}{
+			"status":  status,
+			"size":    fi.Size(),
+			"url":     url,
+			"attempt": attempt,
+		},
+		Funcs: map[string]func(args ...interface{}) (interface{}, error){
+			"header": func(args ...interface{}) (interface{}, error) {
+				if len(args) != 1 {
+					return nil, fmt.Errorf("header expects 1 argument, received %d", len(args))
+				}
+				name, ok := args[0].(string)
+				if !ok {
+					return nil, errors.New("header expects a string")
+				}
+				return header.Get(name), nil
+			},
+		},
+	}
+
+	ok, err := successCheck.EvalBool(env)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%w (status %d, %d bytes)", errRejected, status, fi.Size())
+	}
+
+	return nil
+}
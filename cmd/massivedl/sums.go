@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dimkouv/massivedl/internal/checksum"
+	"github.com/dimkouv/massivedl/internal/fileutil"
+	"github.com/dimkouv/massivedl/internal/urlstate"
+)
+
+// signatureSuffix is appended to the name of -sums for its signature
+const signatureSuffix = ".sig"
+
+// sumsSignKey is the key of -sums-sign-key, nil if the manifest isn't signed
+var sumsSignKey ed25519.PrivateKey
+
+// loadSignKey reads the Ed25519 private key of a PKCS #8 PEM file, as
+// written by "openssl genpkey -algorithm ed25519"
+func loadSignKey(keyPath string) ed25519.PrivateKey {
+	data, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		log.Fatalf("%s: no PEM data", keyPath)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		log.Fatalf("%s: %v", keyPath, err)
+	}
+	signKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		log.Fatalf("%s: expected an Ed25519 key, found %T", keyPath, key)
+	}
+	return signKey
+}
+
+// sumsLine formats a line of a SHA256SUMS file like sha256sum does, names
+// with a backslash or a newline are escaped and the line starts with a
+// backslash
+func sumsLine(hexSum, name string) string {
+	if !strings.ContainsAny(name, "\\\n") {
+		return hexSum + "  " + name + "\n"
+	}
+	name = strings.NewReplacer("\\", "\\\\", "\n", "\\n").Replace(name)
+	return "\\" + hexSum + "  " + name + "\n"
+}
+
+// writeSums writes the sha256 checksums of every file that was downloaded,
+// also by the earlier runs a -load file continues, to -sums in the format of
+// sha256sum. The paths are relative to the directory of the manifest. With
+// -sums-sign-key the Ed25519 signature of the manifest is written next to it.
+func writeSums() {
+	if urlStates == nil {
+		return
+	}
+
+	dir := filepath.Dir(p.SumsFile)
+	lines := map[string]string{}
+	missing := 0
+	for _, url := range urlStates.URLs(urlstate.Done) {
+		record, _ := urlStates.Get(url)
+		if record.Path == "" {
+			missing++
+			continue
+		}
+		sum, err := checksum.SumFile("sha256", record.Path)
+		if err != nil {
+			log.Printf("[SUMS] %v", err)
+			missing++
+			continue
+		}
+		name := record.Path
+		if rel, err := filepath.Rel(dir, record.Path); err == nil {
+			name = filepath.ToSlash(rel)
+		}
+		lines[name] = sumsLine(sum.String()[len("sha256:"):], name)
+	}
+
+	names := make([]string, 0, len(lines))
+	for name := range lines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(lines[name])
+	}
+	manifest := []byte(b.String())
+
+	if err := fileutil.WriteFileAtomic(p.SumsFile, manifest, 0644); err != nil {
+		log.Printf("unable to write -sums: %v", err)
+		return
+	}
+	fmt.Printf("Wrote the checksums of %d files to %s\n", len(names), p.SumsFile)
+	if missing > 0 {
+		fmt.Printf("%d downloaded files are missing from %s, they were moved or saved by an older version\n", missing, p.SumsFile)
+	}
+
+	if sumsSignKey != nil {
+		signature := ed25519.Sign(sumsSignKey, manifest)
+		if err := fileutil.WriteFileAtomic(p.SumsFile+signatureSuffix, signature, 0644); err != nil {
+			log.Printf("unable to write the signature of -sums: %v", err)
+			return
+		}
+		fmt.Printf("Signed %s in %s\n", p.SumsFile, p.SumsFile+signatureSuffix)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/certinfo"
+)
+
+// tlsRecord is a line of the -tls-report file
+type tlsRecord struct {
+	RunID   string    `json:"runId"`
+	Time    time.Time `json:"time"`
+	Address string    `json:"address"` // that the connection went to
+	certinfo.Info
+}
+
+// tlsReport receives the certificate of every host with -tls-report
+var tlsReport struct {
+	lock sync.Mutex
+	seen map[string]bool // hosts that were recorded
+	file *os.File
+	enc  *json.Encoder
+}
+
+// openTLSReport opens the file of -tls-report, records are appended to the
+// ones of earlier runs
+func openTLSReport() {
+	f, err := os.OpenFile(p.TLSReport, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+	tlsReport.seen = map[string]bool{}
+	tlsReport.file = f
+	tlsReport.enc = json.NewEncoder(f)
+	tlsReport.enc.SetEscapeHTML(false)
+}
+
+// closeTLSReport closes the file opened by openTLSReport
+func closeTLSReport() {
+	tlsReport.lock.Lock()
+	defer tlsReport.lock.Unlock()
+
+	if tlsReport.file == nil {
+		return
+	}
+	if err := tlsReport.file.Close(); err != nil {
+		fmt.Printf("unable to close file: %v", err)
+	}
+	tlsReport.file = nil
+}
+
+// recordCertificate appends the certificate of a new TLS connection to addr
+// to the report, unless its host was recorded before
+func recordCertificate(state tls.ConnectionState, addr string) {
+	info, ok := certinfo.FromState(state)
+	if !ok {
+		return
+	}
+
+	// connections to addresses carry no host name
+	if info.Host == "" {
+		info.Host, _, _ = net.SplitHostPort(addr)
+	}
+	host := info.Host
+
+	tlsReport.lock.Lock()
+	defer tlsReport.lock.Unlock()
+
+	if tlsReport.file == nil || tlsReport.seen[host] {
+		return
+	}
+	tlsReport.seen[host] = true
+
+	record := tlsRecord{RunID: runID, Time: time.Now().UTC(), Address: addr, Info: info}
+	if err := tlsReport.enc.Encode(record); err != nil {
+		log.Printf("unable to write -tls-report: %v", err)
+	}
+}
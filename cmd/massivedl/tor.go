@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/netutil"
+	"github.com/dimkouv/massivedl/internal/torctl"
+)
+
+// torControlPasswordEnv holds the password of the control port if
+// -tor-control-password isn't set
+const torControlPasswordEnv = "TOR_CONTROL_PASSWORD"
+
+// routes of the downloads, for the statistics
+const (
+	routeTor      = "tor"
+	routeClearnet = "clearnet"
+)
+
+// newTorProxyFunc returns the Proxy function of the transport for -tor. With
+// -tor-isolate-hosts every host is reached over its own circuits: Tor keeps
+// streams with different SOCKS credentials apart (IsolateSOCKSAuth, which is
+// on by default), so the host is sent as user name.
+func newTorProxyFunc() func(*http.Request) (*url.URL, error) {
+	checkTorProxy()
+	return torProxy
+}
+
+// checkTorProxy exits if nothing listens on the SOCKS port of -tor-proxy
+func checkTorProxy() {
+	conn, err := net.DialTimeout("tcp", p.TorProxy, 5*time.Second)
+	if err != nil {
+		log.Fatalf("Tor is not reachable at %s (-tor-proxy): %v", p.TorProxy, err)
+	}
+	if err = conn.Close(); err != nil {
+		log.Printf("error closing connection: %v", err)
+	}
+}
+
+// torProxy returns the SOCKS port of Tor as the proxy of req
+func torProxy(req *http.Request) (*url.URL, error) {
+	proxy := &url.URL{Scheme: "socks5", Host: p.TorProxy}
+	if p.TorIsolateHosts {
+		proxy.User = url.UserPassword(torIsolationUser(req.URL.Hostname()), "isolate")
+	}
+	return proxy, nil
+}
+
+// isOnion reports whether host is an onion service, which can only be
+// reached through Tor
+func isOnion(host string) bool {
+	return strings.HasSuffix(strings.ToLower(strings.TrimSuffix(host, ".")), ".onion")
+}
+
+// hasOnion reports whether any of entries is an onion service
+func hasOnion(entries []dataEntry) bool {
+	for _, entry := range entries {
+		if isOnion(entry.url.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// route returns over which network u is downloaded
+func route(u *url.URL) string {
+	if p.Tor || isOnion(u.Hostname()) {
+		return routeTor
+	}
+	return routeClearnet
+}
+
+// withOnionProxy sends the requests to onion services through Tor and all
+// others to the proxy chosen by next, which may be nil for none
+func withOnionProxy(next func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		if isOnion(req.URL.Hostname()) {
+			return torProxy(req)
+		}
+		if next == nil {
+			return nil, nil
+		}
+		return next(req)
+	}
+}
+
+// withOnionDialer connects to onion services through Tor and to all other
+// addresses with next
+func withOnionDialer(next, dial netutil.DialFunc) netutil.DialFunc {
+	tor := torDialer(dial)
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, _, _ := net.SplitHostPort(addr); isOnion(host) {
+			return tor(ctx, network, addr)
+		}
+		return next(ctx, network, addr)
+	}
+}
+
+// clearnetOnly fails the requests to onion services, for the protocols that
+// can't be sent through Tor
+type clearnetOnly struct {
+	next http.RoundTripper
+}
+
+func (t clearnetOnly) RoundTrip(req *http.Request) (*http.Response, error) {
+	if isOnion(req.URL.Hostname()) {
+		return nil, errors.New(req.URL.Scheme + " urls can't be downloaded through Tor")
+	}
+	return t.next.RoundTrip(req)
+}
+
+// torDialer returns a dialer that connects through Tor, for the protocols
+// that don't use the Proxy function of the transport
+func torDialer(dial netutil.DialFunc) netutil.DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		username, password := "", ""
+		if p.TorIsolateHosts {
+			host, _, _ := net.SplitHostPort(addr)
+			username, password = torIsolationUser(host), "isolate"
+		}
+		return netutil.SOCKS5Dialer(dial, p.TorProxy, username, password)(ctx, network, addr)
+	}
+}
+
+// torIsolationUser is the SOCKS user name that gives host circuits of its own
+func torIsolationUser(host string) string {
+	return "massivedl-" + host
+}
+
+// rotateTorCircuits asks Tor for new circuits every -tor-newnym, so that new
+// connections leave the Tor network through other exits
+func rotateTorCircuits() {
+	c, err := torctl.Dial(p.TorControl, p.TorControlPassword)
+	if err != nil {
+		log.Fatalf("unable to use the Tor control port %s: %v", p.TorControl, err)
+	}
+
+	go func() {
+		defer func() {
+			if err := c.Close(); err != nil {
+				log.Printf("error closing connection: %v", err)
+			}
+		}()
+
+		for range time.Tick(p.TorNewnym) {
+			if stopped() {
+				return
+			}
+			if err := c.Signal("NEWNYM"); err != nil {
+				log.Println("[TOR] NEWNYM failed:", err)
+				continue
+			}
+			log.Println("[TOR] switched to new circuits")
+		}
+	}()
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/ftp"
+	"github.com/dimkouv/massivedl/internal/gcs"
+	"github.com/dimkouv/massivedl/internal/netutil"
+	"github.com/dimkouv/massivedl/internal/pac"
+	"github.com/dimkouv/massivedl/internal/proxypool"
+	"github.com/dimkouv/massivedl/internal/s3"
+	"github.com/dimkouv/massivedl/internal/sshfile"
+	"github.com/dimkouv/massivedl/pkg/massivedl"
+)
+
+// proxyDirect disables proxies, including the ones set in the environment
+const proxyDirect = "direct"
+
+// how the proxies of -proxy-file are rotated
+const (
+	proxyRotateRoundRobin = "round-robin"
+	proxyRotateRandom     = "random"
+)
+
+// proxyPasswordEnv holds the password of -proxy-user if it has none
+const proxyPasswordEnv = "MASSIVEDL_PROXY_PASSWORD"
+
+// pacTimeout limits the download of a -proxy-pac url
+const pacTimeout = 30 * time.Second
+
+// the values of -http-version
+const (
+	httpVersionAuto = "auto"
+	httpVersion11   = "1.1"
+	httpVersion2    = "2"
+	httpVersion3    = "3"
+)
+
+// sshTransport downloads sftp:// and scp:// urls, and uploads to a -sink
+// with these schemes
+var sshTransport *sshfile.Transport
+
+// newDialer returns the net.Dialer for tcp connections, configured from the
+// command line parameters
+func newDialer() *net.Dialer {
+	// parameters saved by older versions have no -connect-timeout
+	timeout := p.ConnectTimeout
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	dialer := &net.Dialer{
+		Timeout:       timeout,
+		KeepAlive:     p.DialKeepAlive,
+		FallbackDelay: p.FallbackDelay,
+	}
+
+	if p.LocalAddr != "" {
+		ip := net.ParseIP(p.LocalAddr)
+		if ip == nil {
+			log.Fatalf("invalid -local-addr %q", p.LocalAddr)
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+
+	return dialer
+}
+
+// idleConnsPerHost returns the number of idle connections kept per host, by
+// default one for every connection the workers may have open at a time
+func idleConnsPerHost() int {
+	if p.MaxIdleConnsPerHost > 0 {
+		return p.MaxIdleConnsPerHost
+	}
+
+	n := p.ConcurrentRequests
+	if p.TargetThroughput > 0 && p.MaxWorkers > n {
+		n = p.MaxWorkers
+	}
+	if p.Segments > 1 {
+		n *= p.Segments
+	}
+	if n < 1 {
+		n = http.DefaultMaxIdleConnsPerHost
+	}
+	return n
+}
+
+// newTLSConfig returns the TLS settings of -tls-insecure and -ca-cert, nil
+// if neither is set
+func newTLSConfig() *tls.Config {
+	if !p.TLSInsecure && p.CACert == "" {
+		return nil
+	}
+
+	cfg := &tls.Config{InsecureSkipVerify: p.TLSInsecure}
+	if p.CACert != "" {
+		pem, err := ioutil.ReadFile(p.CACert)
+		if err != nil {
+			log.Fatalf("unable to read -ca-cert: %v", err)
+		}
+		// the certificates are trusted in addition to the ones of the
+		// system
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			log.Fatalf("no PEM certificates found in -ca-cert %s", p.CACert)
+		}
+		cfg.RootCAs = pool
+	}
+	return cfg
+}
+
+// requireHTTP2 fails the http and https requests that weren't answered over
+// HTTP/2, for -http-version 2. Go can't speak HTTP/2 over plain http, so only
+// https urls can succeed.
+type requireHTTP2 struct {
+	http.RoundTripper
+}
+
+func (t requireHTTP2) RoundTrip(req *http.Request) (*http.Response, error) {
+	response, err := t.RoundTripper.RoundTrip(req)
+	if err != nil || response.ProtoMajor == 2 || req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+		return response, err
+	}
+
+	if err = response.Body.Close(); err != nil {
+		log.Printf("error closing response body: %v", err)
+	}
+	return nil, fmt.Errorf("%s answered over %s, -http-version 2 requires HTTP/2", req.URL.Host, response.Proto)
+}
+
+// errTransport fails all requests with err
+type errTransport struct {
+	err error
+}
+
+func (t errTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, t.err
+}
+
+// newTransport returns the http.Transport that is used for all downloads,
+// configured from the command line parameters
+func newTransport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	dialer := newDialer()
+	t.DialContext = dialer.DialContext
+	t.TLSHandshakeTimeout = dialer.Timeout
+	t.ResponseHeaderTimeout = p.ResponseTimeout
+
+	// the workers keep their connections to a host open between requests
+	// instead of reconnecting for every file
+	t.MaxIdleConnsPerHost = idleConnsPerHost()
+	if t.MaxIdleConns < t.MaxIdleConnsPerHost {
+		t.MaxIdleConns = t.MaxIdleConnsPerHost
+	}
+
+	t.TLSClientConfig = newTLSConfig()
+	if p.DisableHTTP2 {
+		// a non-nil empty map keeps the transport from upgrading to h2
+		t.ForceAttemptHTTP2 = false
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
+
+	if len(p.Resolve) > 0 {
+		resolver := netutil.NewResolver()
+		for _, spec := range p.Resolve {
+			if err := resolver.Add(spec); err != nil {
+				log.Fatal(err)
+			}
+		}
+		t.DialContext = resolver.Dialer(dialer.DialContext)
+	}
+
+	// ftp urls are downloaded over the same tcp connections, but they
+	// don't use http proxies or the unix socket
+	ftpTransport := &ftp.Transport{Dial: t.DialContext, TLSConfig: t.TLSClientConfig}
+	if p.Tor {
+		ftpTransport.Dial = torDialer(dialer.DialContext)
+	} else {
+		ftpTransport.Dial = withOnionDialer(ftpTransport.Dial, dialer.DialContext)
+	}
+	for _, scheme := range ftp.Schemes {
+		t.RegisterProtocol(scheme, ftpTransport)
+	}
+
+	// sftp and scp urls are downloaded with the ssh command, which would
+	// bypass Tor
+	sshTransport = &sshfile.Transport{KeyFile: p.SSHKey, ForwardAgent: p.SSHAgentForward, Options: p.SSHOptions}
+	for _, scheme := range sshfile.Schemes {
+		if p.Tor {
+			t.RegisterProtocol(scheme, errTransport{errors.New(scheme + " urls can't be downloaded through Tor")})
+		} else {
+			t.RegisterProtocol(scheme, clearnetOnly{sshTransport})
+		}
+	}
+
+	// s3 and gs urls are sent to the object stores over https with the
+	// ambient credentials of the environment
+	s3Transport = s3.FromEnv()
+	s3Transport.Transport = t
+	t.RegisterProtocol(s3.Scheme, s3Transport)
+	gcsTransport = gcs.FromEnv()
+	gcsTransport.Transport = t
+	t.RegisterProtocol(gcs.Scheme, gcsTransport)
+
+	// hosts that can't be reached on the port of the url are tried on
+	// other ports, ftp and ssh connections keep their ports
+	if len(p.FallbackPorts) > 0 {
+		t.DialContext = netutil.PortFallback(t.DialContext, p.FallbackPorts)
+	}
+
+	// send every request over a single unix socket, like curl --unix-socket
+	if p.UnixSocket != "" {
+		unixDialer := &net.Dialer{}
+		t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return unixDialer.DialContext(ctx, "unix", p.UnixSocket)
+		}
+	}
+
+	// without -proxy the HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment
+	// variables are used, like by DefaultTransport
+	if p.Proxy != "" {
+		t.Proxy = newProxyFunc(p.Proxy)
+	}
+
+	// the proxies of -proxy-file are chosen by the proxypool.Transport that
+	// wraps this one
+	if p.ProxyFile != "" {
+		t.Proxy = proxypool.Proxy
+	}
+
+	if p.ProxyPAC != "" {
+		t.Proxy = loadPAC(p.ProxyPAC).Proxy
+	}
+
+	// Tor resolves the host names, none are looked up locally
+	if p.Tor {
+		t.Proxy = newTorProxyFunc()
+	}
+
+	// the hosts of -domain-rules with a proxy of their own
+	if p.DomainRules != "" {
+		t.Proxy = withDomainRuleProxy(t.Proxy)
+	}
+
+	if p.ProxyUser != "" && t.Proxy != nil {
+		t.Proxy = withProxyUser(t.Proxy, proxyUserInfo(p.ProxyUser))
+	}
+
+	// onion services are only reachable through Tor, the other hosts are
+	// reached as configured above
+	if !p.Tor {
+		t.Proxy = withOnionProxy(t.Proxy)
+	}
+
+	t.RegisterProtocol(netutil.UnixScheme, &netutil.UnixTransport{
+		New: func() *http.Transport {
+			return http.DefaultTransport.(*http.Transport).Clone()
+		},
+	})
+
+	return t
+}
+
+// registerSchemes has the downloader fetch the urls of the schemes that
+// newTransport handles besides http with rt, the transport of the run, so
+// that they pass -record, -proxy-file and the rest like http urls
+func registerSchemes(rt http.RoundTripper) {
+	schemes := append(append([]string{s3.Scheme, gcs.Scheme, netutil.UnixScheme}, ftp.Schemes...), sshfile.Schemes...)
+	fetcher := massivedl.TransportFetcher(rt)
+	for _, scheme := range schemes {
+		massivedl.RegisterScheme(scheme, fetcher)
+	}
+}
+
+// connTracer passes the requests to next with the trace of withConnTrace
+type connTracer struct {
+	next http.RoundTripper
+}
+
+func (t connTracer) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.next.RoundTrip(withConnTrace(req))
+}
+
+// withConnTrace logs, with -debug, which address and address family the new
+// connections of req go to, and the connection attempts that fail. With
+// -tls-report the certificates of new TLS connections are recorded.
+func withConnTrace(req *http.Request) *http.Request {
+	if !p.Debug && p.TLSReport == "" {
+		return req
+	}
+
+	trace := &httptrace.ClientTrace{
+		ConnectDone: func(network, addr string, err error) {
+			if err != nil && p.Debug {
+				log.Printf("[DEBUG] %s: connecting to %s failed: %v", req.URL, addr, err)
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			if info.Reused {
+				return
+			}
+			addr := info.Conn.RemoteAddr()
+			if tlsConn, ok := info.Conn.(*tls.Conn); ok && p.TLSReport != "" {
+				recordCertificate(tlsConn.ConnectionState(), addr.String())
+			}
+			if !p.Debug {
+				return
+			}
+			family := netutil.AddressFamily(addr)
+			if family == "" {
+				family = addr.Network()
+			}
+			log.Printf("[DEBUG] %s: connected to %s (%s)", req.URL, addr, family)
+		},
+	}
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// newProxyFunc returns the Proxy function of the transport for the -proxy
+// parameter, which is either the url of an http, https or socks5 proxy or
+// "direct" to ignore the proxy environment variables
+func newProxyFunc(proxy string) func(*http.Request) (*url.URL, error) {
+	if proxy == proxyDirect {
+		return nil
+	}
+
+	// host:port is an http proxy, like in the environment variables
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+
+	u, err := url.Parse(proxy)
+	if err != nil {
+		log.Fatalf("invalid -proxy %q: %v", p.Proxy, err)
+	}
+
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		log.Fatalf("invalid -proxy %q: unsupported scheme %s", p.Proxy, u.Scheme)
+	}
+
+	return http.ProxyURL(u)
+}
+
+// loadPAC loads the proxy auto-config file of -proxy-pac from a local file or
+// an http(s) url
+func loadPAC(location string) *pac.Script {
+	var src []byte
+	var err error
+
+	if strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://") {
+		client := &http.Client{Timeout: pacTimeout}
+		var response *http.Response
+		if response, err = client.Get(location); err != nil {
+			log.Fatalf("unable to load -proxy-pac: %v", err)
+		}
+		defer func() {
+			if err = response.Body.Close(); err != nil {
+				log.Printf("error closing response body: %v", err)
+			}
+		}()
+		if response.StatusCode != http.StatusOK {
+			log.Fatalf("unable to load -proxy-pac %s: %s", location, response.Status)
+		}
+		src, err = ioutil.ReadAll(response.Body)
+	} else {
+		src, err = ioutil.ReadFile(location)
+	}
+	if err != nil {
+		log.Fatalf("unable to load -proxy-pac: %v", err)
+	}
+
+	script, err := pac.Parse(string(src))
+	if err != nil {
+		log.Fatalf("%s: %v", location, err)
+	}
+	return script
+}
+
+// proxyUserInfo parses the user:password of -proxy-user, the password is
+// taken from the environment if it is left out
+func proxyUserInfo(userPassword string) *url.Userinfo {
+	i := strings.Index(userPassword, ":")
+	if i < 0 {
+		return url.UserPassword(userPassword, os.Getenv(proxyPasswordEnv))
+	}
+	return url.UserPassword(userPassword[:i], userPassword[i+1:])
+}
+
+// withProxyUser adds user to the proxies chosen by proxy that have no
+// credentials of their own
+func withProxyUser(proxy func(*http.Request) (*url.URL, error), user *url.Userinfo) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		u, err := proxy(req)
+		if err != nil || u == nil || u.User != nil {
+			return u, err
+		}
+		withUser := *u
+		withUser.User = user
+		return &withUser, nil
+	}
+}
+
+// newProxyPool loads the proxies of -proxy-file
+func newProxyPool() *proxypool.Pool {
+	f, err := os.Open(p.ProxyFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		if err = f.Close(); err != nil {
+			log.Printf("unable to close file: %v", err)
+		}
+	}()
+
+	proxies, err := proxypool.Parse(f)
+	if err != nil {
+		log.Fatalf("%s: %v", p.ProxyFile, err)
+	}
+	if len(proxies) == 0 {
+		log.Fatalf("%s: no proxies found", p.ProxyFile)
+	}
+
+	pool := proxypool.New(proxies)
+	pool.Random = p.ProxyRotate == proxyRotateRandom
+	pool.MaxFailures = p.ProxyMaxFailures
+	pool.OnBlacklist = func(u *url.URL) {
+		log.Println("[PROXY] blacklisted", u.Redacted())
+	}
+
+	return pool
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/tui"
+)
+
+// tuiWidth is the width of the -tui lines if $COLUMNS isn't set
+const tuiWidth = 120
+
+// activity is what a worker is downloading, for -tui and -progress-file
+type activity struct {
+	url     string
+	name    string // output path
+	done    int64
+	size    int64
+	started time.Time
+}
+
+// activities holds the activity of every running worker, by worker id. Idle
+// workers have a nil activity.
+var activities = struct {
+	lock     sync.Mutex
+	byWorker map[int]*activity
+}{byWorker: map[int]*activity{}}
+
+// trackingActivities reports whether the activities of the workers are
+// shown anywhere
+func trackingActivities() bool {
+	return p.Progress == progressTUI || p.ProgressFile != ""
+}
+
+// startActivity records that worker id starts downloading url into name, and
+// registers the worker if it is new
+func startActivity(id int, url, name string) {
+	if !trackingActivities() {
+		return
+	}
+
+	activities.lock.Lock()
+	defer activities.lock.Unlock()
+
+	if url == "" {
+		activities.byWorker[id] = nil
+		return
+	}
+	activities.byWorker[id] = &activity{url: url, name: name, size: unknownSize, started: time.Now()}
+}
+
+// stopActivity removes worker id when it quits
+func stopActivity(id int) {
+	if !trackingActivities() {
+		return
+	}
+
+	activities.lock.Lock()
+	defer activities.lock.Unlock()
+
+	delete(activities.byWorker, id)
+}
+
+// trackProgress sets the bytes of the file worker id downloads and its
+// size, unknownSize until it is known. done includes the bytes that were
+// received before, e.g. by an earlier run of a resumed download.
+func trackProgress(id int, done, size int64) {
+	if !trackingActivities() {
+		return
+	}
+
+	activities.lock.Lock()
+	defer activities.lock.Unlock()
+
+	if a := activities.byWorker[id]; a != nil {
+		a.done, a.size = done, size
+	}
+}
+
+// tuiScreen draws the frames of printTUI
+var tuiScreen = tui.NewScreen(os.Stdout)
+
+// printTUI draws a progress bar for every worker followed by the statistics
+func printTUI() {
+	width := tuiWidth
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		width = columns
+	}
+
+	var workers []tui.Worker
+	activities.lock.Lock()
+	for id, a := range activities.byWorker {
+		w := tui.Worker{ID: id}
+		if a != nil {
+			w.URL, w.Done, w.Size, w.Elapsed = a.url, a.done, a.size, time.Since(a.started)
+		}
+		workers = append(workers, w)
+	}
+	activities.lock.Unlock()
+	sort.Slice(workers, func(i, j int) bool { return workers[i].ID < workers[j].ID })
+
+	lines := make([]string, 0, len(workers)+3)
+	for _, w := range workers {
+		lines = append(lines, w.Line(width))
+	}
+
+	// the speeds are averages since the start, like in the table
+	s := stats.Snapshot()
+	elapsed := time.Since(s.StartTime)
+	lines = append(lines,
+		strings.Repeat("-", minInt(width, 60)),
+		fmt.Sprintf("downloaded %d  failed %d  remaining %d  of %d",
+			s.TotalDownloaded, s.TotalFailed, s.TotalDownloads-s.TotalDownloaded-s.TotalFailed-s.TotalCapped, s.TotalDownloads),
+		fmt.Sprintf("%.2f mB  %.2f files/sec  %.2f mB/sec  running %s",
+			float64(s.TotalDownloadedBytes)/1000000.0, float64(s.TotalDownloaded)/elapsed.Seconds(),
+			float64(s.TotalDownloadedBytes)/1000000.0/elapsed.Seconds(), elapsed.Round(time.Second)),
+	)
+	if p.EgressCostPerGB > 0 {
+		lines[len(lines)-1] += fmt.Sprintf("  est. cost $%.2f", s.Cost())
+	}
+	switch {
+	case downloader.Paused() && keys.restore != nil:
+		lines = append(lines, "paused, press p to resume")
+	case downloader.Paused():
+		lines = append(lines, "paused")
+	case offHours.On():
+		lines = append(lines, "paused outside the active hours")
+	case keys.restore != nil:
+		lines = append(lines, "press p to pause")
+	}
+
+	if err := tuiScreen.Draw(lines); err != nil {
+		fmt.Printf("unable to draw the progress: %v", err)
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
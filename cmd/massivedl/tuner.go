@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// tuneInterval is how often the auto-tuner measures and adjusts the settings
+const tuneInterval = 5 * time.Second
+
+// tunerResult holds the settings the auto-tuner ended up with
+type tunerResult struct {
+	workers    int
+	maxPerHost int
+	throughput float64 // bytes per second over the last interval
+}
+
+// autoTune adjusts the number of workers and the per host limit every
+// tuneInterval to approach p.TargetThroughput while keeping the share of
+// failed downloads below p.MaxErrorRate. It returns when done is closed.
+func autoTune(done <-chan struct{}) tunerResult {
+	prev := stats.Snapshot()
+	res := tunerResult{workers: downloader.Workers(), maxPerHost: downloader.MaxPerHost()}
+
+	ticker := time.NewTicker(tuneInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return res
+		case <-ticker.C:
+		}
+
+		cur := stats.Snapshot()
+		finished := (cur.TotalDownloaded + cur.TotalFailed) - (prev.TotalDownloaded + prev.TotalFailed)
+		throughput := float64(cur.TotalDownloadedBytes-prev.TotalDownloadedBytes) / tuneInterval.Seconds()
+		errorRate := 0.0
+		if finished > 0 {
+			errorRate = float64(cur.TotalFailed-prev.TotalFailed) / float64(finished)
+		}
+		prev = cur
+
+		workers, maxPerHost := tuneStep(downloader.Workers(), downloader.MaxPerHost(), throughput, errorRate)
+
+		if workers != downloader.Workers() || maxPerHost != downloader.MaxPerHost() {
+			log.Printf("[TUNE] %.2f mB/s, error rate %.2f: %d -> %d workers, per host %d -> %d",
+				throughput/1000000, errorRate, downloader.Workers(), workers, downloader.MaxPerHost(), maxPerHost)
+		}
+
+		downloader.SetWorkers(workers)
+		downloader.SetMaxPerHost(maxPerHost)
+
+		res = tunerResult{workers: downloader.Workers(), maxPerHost: downloader.MaxPerHost(), throughput: throughput}
+	}
+}
+
+// tuneStep returns the number of workers and the per host limit (0 for
+// unlimited) the auto-tuner sets next, given the current ones and what it
+// measured over the last interval
+func tuneStep(workers, maxPerHost int, throughput, errorRate float64) (int, int) {
+	target := float64(p.TargetThroughput)
+	switch {
+	case errorRate > p.MaxErrorRate:
+		// too many failures, back off. Without a per host limit every
+		// worker may be busy with the same host, so the limit starts from
+		// the number of workers.
+		if maxPerHost == 0 {
+			maxPerHost = workers
+		}
+		workers -= workers/4 + 1
+		if workers < 1 {
+			workers = 1
+		}
+		if maxPerHost > 1 {
+			maxPerHost--
+		}
+	case throughput < target*0.95 && workers < p.MaxWorkers:
+		workers += workers/4 + 1
+		if workers > p.MaxWorkers {
+			workers = p.MaxWorkers
+		}
+		// a limit that no longer holds back any worker is lifted again
+		if maxPerHost > 0 {
+			if maxPerHost++; maxPerHost >= p.MaxWorkers {
+				maxPerHost = 0
+			}
+		}
+	case throughput > target*1.05 && workers > 1:
+		workers--
+	}
+	return workers, maxPerHost
+}
+
+// Print prints the settings the auto-tuner converged on
+func (res tunerResult) Print() {
+	perHost := "unlimited"
+	if res.maxPerHost > 0 {
+		perHost = fmt.Sprint(res.maxPerHost)
+	}
+
+	fmt.Printf("Auto-tuner converged on -workers %d -max-per-host %s (%.2f mB/s)\n",
+		res.workers, perHost, res.throughput/1000000)
+}
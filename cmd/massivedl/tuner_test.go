@@ -0,0 +1,36 @@
+package main
+
+import "testing"
+
+func TestTuneStep(t *testing.T) {
+	p = cmdLineParams{TargetThroughput: 100e6, MaxErrorRate: 0.1, MaxWorkers: 32}
+	defer func() { p = cmdLineParams{} }()
+
+	testCases := []struct {
+		name               string
+		workers            int
+		maxPerHost         int
+		throughput         float64
+		errorRate          float64
+		expectedWorkers    int
+		expectedMaxPerHost int
+	}{
+		{"slow, unlimited", 8, 0, 50e6, 0, 11, 0},
+		{"slow, limited", 8, 2, 50e6, 0, 11, 3},
+		{"slow, limit reaches the workers", 30, 31, 50e6, 0, 32, 0},
+		{"slow, at the maximum", 32, 4, 50e6, 0, 32, 4},
+		{"fast", 8, 2, 200e6, 0, 7, 2},
+		{"on target", 8, 2, 100e6, 0, 8, 2},
+		{"failing, limited", 8, 4, 50e6, 0.5, 5, 3},
+		{"failing, unlimited", 8, 0, 50e6, 0.5, 5, 7},
+		{"failing, one worker", 1, 0, 50e6, 0.5, 1, 1},
+	}
+
+	for _, testCase := range testCases {
+		workers, maxPerHost := tuneStep(testCase.workers, testCase.maxPerHost, testCase.throughput, testCase.errorRate)
+		if workers != testCase.expectedWorkers || maxPerHost != testCase.expectedMaxPerHost {
+			t.Errorf("%s: expected %d workers, %d per host received %d, %d",
+				testCase.name, testCase.expectedWorkers, testCase.expectedMaxPerHost, workers, maxPerHost)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/dimkouv/massivedl/internal/gcs"
+	"github.com/dimkouv/massivedl/internal/s3"
+	"github.com/dimkouv/massivedl/internal/sshfile"
+)
+
+// sinkURL is where completed downloads are uploaded to, nil without -sink
+var sinkURL *url.URL
+
+// parseSink parses the -sink parameter, which is an s3://, gs://, sftp:// or
+// scp:// url of a directory
+func parseSink(sink string) *url.URL {
+	u, err := url.Parse(sink)
+	if err != nil {
+		log.Fatalf("invalid -sink %q: %v", sink, err)
+	}
+
+	switch u.Scheme {
+	case s3.Scheme, gcs.Scheme:
+	default:
+		if !isSSHScheme(u.Scheme) {
+			log.Fatalf("invalid -sink %q: unsupported scheme %q", sink, u.Scheme)
+		}
+		if p.Tor {
+			log.Fatalf("invalid -sink %q: %s can't be used through Tor", sink, u.Scheme)
+		}
+	}
+	if u.Host == "" {
+		log.Fatalf("invalid -sink %q: no bucket or host", sink)
+	}
+
+	return u
+}
+
+func isSSHScheme(scheme string) bool {
+	for _, s := range sshfile.Schemes {
+		if scheme == s {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadToSink uploads the file at localPath to sinkURL, under its path
+// relative to the output directory. The local file is removed afterwards
+// unless -sink-keep-local is set.
+func uploadToSink(localPath string) error {
+	rel := relOutputPath(filepath.ToSlash(localPath))
+	dest := *sinkURL
+	dest.Path = path.Join("/", sinkURL.Path, rel)
+
+	f, err := os.Open(localPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Printf("unable to close file: %v", err)
+		}
+	}()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	key := strings.TrimPrefix(dest.Path, "/")
+	switch dest.Scheme {
+	case s3.Scheme:
+		err = s3Transport.Put(context.Background(), dest.Host, key, f, fi.Size())
+	case gcs.Scheme:
+		err = gcsTransport.Put(context.Background(), dest.Host, key, f, fi.Size())
+	default:
+		err = sshTransport.Put(context.Background(), &dest, f)
+	}
+	if err != nil {
+		return fmt.Errorf("uploading to %s: %v", dest.Redacted(), err)
+	}
+
+	if p.SinkKeepLocal {
+		return nil
+	}
+	return os.Remove(localPath)
+}
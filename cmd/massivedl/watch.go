@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/dimkouv/massivedl/internal/fileutil"
+)
+
+// urlFileStdin is the -urlfile that reads the urls from the standard input
+const urlFileStdin = "-"
+
+// watchProcessedDir is the directory in -watch that url lists are moved to
+// once they are queued
+const watchProcessedDir = "processed"
+
+// watchSpool queues the url lists that appear in -watch every
+// -watch-interval. Files starting with a dot or ending in .tmp are left
+// alone, so lists can be written under such a name and renamed when they are
+// complete.
+func watchSpool() {
+	fi, err := os.Stat(p.WatchDir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if fi.Mode()&os.ModeNamedPipe != 0 {
+		watchPipe()
+		return
+	}
+
+	processed := path.Join(p.WatchDir, watchProcessedDir)
+	if err := os.MkdirAll(processed, os.ModePerm); err != nil {
+		log.Fatalf("unable to create directories: %v", err)
+	}
+	fmt.Printf("Watching %s for url lists\n", p.WatchDir)
+
+	for !stopped() {
+		files, err := ioutil.ReadDir(p.WatchDir)
+		if err != nil {
+			log.Println("[WATCH]", err)
+		}
+		for _, fi := range files {
+			name := fi.Name()
+			if !fi.Mode().IsRegular() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".tmp") {
+				continue
+			}
+			queueSpoolFile(path.Join(p.WatchDir, name), path.Join(processed, name))
+		}
+
+		sleep(p.WatchInterval)
+	}
+}
+
+// watchPipe queues the urls written to the named pipe of -watch, a line at a
+// time. The pipe is opened again whenever its writers closed it.
+func watchPipe() {
+	fmt.Printf("Reading url lines from %s\n", p.WatchDir)
+
+	for !stopped() {
+		// blocks until a writer opens the pipe
+		f, err := os.Open(p.WatchDir)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if err = queueStream(f, inputFormat(p.WatchDir), jobName(p.WatchDir)); err != nil {
+			log.Printf("[WATCH] %s: %v", p.WatchDir, err)
+		}
+		if err = f.Close(); err != nil {
+			fmt.Printf("unable to close file: %v", err)
+		}
+	}
+}
+
+// queueStream queues the entries of job read from r in format as soon as
+// they are complete, until r ends: a line at a time, a JSON value at a time,
+// or the whole YAML list at its end
+func queueStream(r io.Reader, format, job string) error {
+	add := func(entries []dataEntry) error {
+		entries, err := expandPrefixes(entries)
+		if err == nil {
+			setJob(entries, job)
+			err = addEntries(entries)
+		}
+		return err
+	}
+	switch format {
+	case inputJSON:
+		return decodeJSONManifest(r, add)
+	case inputYAML:
+		return decodeYAMLManifest(r, add)
+	}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		entries, err := readEntriesFormat(strings.NewReader(scanner.Text()), format, 0)
+		if err == nil {
+			err = add(entries)
+		}
+		if err != nil {
+			log.Printf("[WATCH] %s: %v", scanner.Text(), err)
+		}
+	}
+	return scanner.Err()
+}
+
+// readStdin queues the urls of the standard input with -urlfile -, the run
+// ends when the input ended and its downloads are done. done closes the feed
+// of the downloader.
+func readStdin(done func()) {
+	defer done()
+
+	if err := queueStream(os.Stdin, inputFormat(""), jobStdin); err != nil {
+		log.Printf("[WATCH] standard input: %v", err)
+	}
+}
+
+// stdinIsPipe reports whether the standard input is a pipe, e.g. in
+// "grep pdf links.txt | massivedl"
+func stdinIsPipe() bool {
+	fi, err := os.Stdin.Stat()
+	return err == nil && fi.Mode()&os.ModeNamedPipe != 0
+}
+
+// queueSpoolFile queues the entries of the url list at listPath and moves it
+// to donePath
+func queueSpoolFile(listPath, donePath string) {
+	entries, err := loadEntries(listPath)
+	if err == nil {
+		entries, err = expandPrefixes(entries)
+	}
+	if err == nil {
+		setJob(entries, jobName(listPath))
+		err = addEntries(entries)
+	}
+	if err != nil {
+		log.Printf("[WATCH] %s: %v", listPath, err)
+		return
+	}
+
+	// a list of the same name from an earlier day gets a number
+	target := donePath
+	for n := 1; fileutil.FileOrPathExists(target); n++ {
+		target = numberedName(donePath, n)
+	}
+	if err = os.Rename(listPath, target); err != nil {
+		log.Fatalf("[WATCH] unable to move %s out of the way: %v", listPath, err)
+	}
+	log.Printf("[WATCH] queued %d urls of %s", len(entries), listPath)
+}
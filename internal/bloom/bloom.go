@@ -0,0 +1,198 @@
+// Package bloom implements a Bloom filter that can be stored in a file
+package bloom
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"sync"
+)
+
+// magic starts every stored filter
+const magic = "MDLBLOOM1"
+
+// ErrInvalidFile is returned when loading a file that is not a stored filter
+var ErrInvalidFile = errors.New("bloom: not a filter file")
+
+// Filter is a Bloom filter. It may report that an item was added although it
+// was not (a false positive), but never the other way around. A Filter is
+// safe for concurrent use.
+type Filter struct {
+	lock sync.RWMutex
+	bits []uint64
+	m    uint64 // number of bits
+	k    uint32 // number of hash functions
+}
+
+// New creates a filter that holds up to n items with a false positive rate
+// of about fpRate
+func New(n uint64, fpRate float64) *Filter {
+	if n == 0 {
+		n = 1
+	}
+	if fpRate <= 0 || fpRate >= 1 {
+		fpRate = 0.01
+	}
+
+	m := uint64(math.Ceil(-float64(n) * math.Log(fpRate) / (math.Ln2 * math.Ln2)))
+	k := uint32(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+
+	return newFilter(m, k)
+}
+
+func newFilter(m uint64, k uint32) *Filter {
+	return &Filter{bits: make([]uint64, (m+63)/64), m: m, k: k}
+}
+
+// locations returns the k bit positions of item, using double hashing
+func (f *Filter) locations(item []byte) []uint64 {
+	h := fnv.New128a()
+	_, _ = h.Write(item)
+	sum := h.Sum(nil)
+	h1 := binary.BigEndian.Uint64(sum[:8])
+	h2 := binary.BigEndian.Uint64(sum[8:]) | 1
+
+	locs := make([]uint64, f.k)
+	for i := range locs {
+		locs[i] = (h1 + uint64(i)*h2) % f.m
+	}
+	return locs
+}
+
+// Add adds item to the filter
+func (f *Filter) Add(item []byte) {
+	locs := f.locations(item)
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, loc := range locs {
+		f.bits[loc/64] |= 1 << (loc % 64)
+	}
+}
+
+// Test reports whether item was probably added to the filter
+func (f *Filter) Test(item []byte) bool {
+	locs := f.locations(item)
+
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	for _, loc := range locs {
+		if f.bits[loc/64]&(1<<(loc%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// TestAndAdd adds item to the filter and reports whether it was probably
+// added before
+func (f *Filter) TestAndAdd(item []byte) bool {
+	locs := f.locations(item)
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	seen := true
+	for _, loc := range locs {
+		if f.bits[loc/64]&(1<<(loc%64)) == 0 {
+			seen = false
+			f.bits[loc/64] |= 1 << (loc % 64)
+		}
+	}
+	return seen
+}
+
+// SizeBytes returns the memory used by the bits of the filter
+func (f *Filter) SizeBytes() int {
+	return len(f.bits) * 8
+}
+
+// WriteTo writes the filter to w
+func (f *Filter) WriteTo(w io.Writer) (int64, error) {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(magic); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, f.m); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, f.k); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, f.bits); err != nil {
+		return 0, err
+	}
+
+	return int64(len(magic) + 12 + len(f.bits)*8), bw.Flush()
+}
+
+// Read reads a filter that was written with WriteTo
+func Read(r io.Reader) (*Filter, error) {
+	br := bufio.NewReader(r)
+
+	header := make([]byte, len(magic))
+	if _, err := io.ReadFull(br, header); err != nil || string(header) != magic {
+		return nil, ErrInvalidFile
+	}
+
+	var m uint64
+	var k uint32
+	if err := binary.Read(br, binary.LittleEndian, &m); err != nil {
+		return nil, ErrInvalidFile
+	}
+	if err := binary.Read(br, binary.LittleEndian, &k); err != nil {
+		return nil, ErrInvalidFile
+	}
+	if m == 0 || k == 0 {
+		return nil, ErrInvalidFile
+	}
+
+	f := newFilter(m, k)
+	if err := binary.Read(br, binary.LittleEndian, f.bits); err != nil {
+		return nil, ErrInvalidFile
+	}
+
+	return f, nil
+}
+
+// Load reads the filter stored in path
+func Load(path string) (*Filter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Read(file)
+}
+
+// Save stores the filter in path, replacing the previous file atomically
+func (f *Filter) Save(path string) error {
+	tmpPath := path + ".tmp"
+	file, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	if _, err = f.WriteTo(file); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, path)
+}
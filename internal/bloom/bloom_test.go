@@ -0,0 +1,71 @@
+package bloom
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	const n = 10000
+	f := New(n, 0.01)
+
+	for i := 0; i < n; i++ {
+		f.Add([]byte(fmt.Sprintf("http://example.com/%d", i)))
+	}
+	for i := 0; i < n; i++ {
+		if !f.Test([]byte(fmt.Sprintf("http://example.com/%d", i))) {
+			t.Fatalf("item %d is missing", i)
+		}
+	}
+
+	falsePositives := 0
+	for i := n; i < 2*n; i++ {
+		if f.Test([]byte(fmt.Sprintf("http://example.com/%d", i))) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / n; rate > 0.02 {
+		t.Errorf("false positive rate %.4f is too high", rate)
+	}
+
+	if f.TestAndAdd([]byte("new")) {
+		t.Error("expected a new item not to be seen")
+	}
+	if !f.TestAndAdd([]byte("new")) {
+		t.Error("expected the item to be seen the second time")
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bloom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "seen.bloom")
+
+	f := New(1000, 0.001)
+	f.Add([]byte("a"))
+	if err = f.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.Test([]byte("a")) || loaded.Test([]byte("b")) {
+		t.Error("loaded filter does not match the saved one")
+	}
+	if loaded.SizeBytes() != f.SizeBytes() {
+		t.Errorf("expected %d bytes received %d", f.SizeBytes(), loaded.SizeBytes())
+	}
+
+	if _, err = Read(bytes.NewReader([]byte("garbage"))); err != ErrInvalidFile {
+		t.Errorf("expected ErrInvalidFile received %v", err)
+	}
+}
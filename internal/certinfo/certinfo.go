@@ -0,0 +1,67 @@
+// Package certinfo summarizes the certificates that servers present in TLS
+// handshakes, for audit records
+package certinfo
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// Info describes the leaf certificate of a TLS connection
+type Info struct {
+	Host        string    `json:"host"`
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	Serial      string    `json:"serial"`
+	NotBefore   time.Time `json:"notBefore"`
+	NotAfter    time.Time `json:"notAfter"`
+	DNSNames    []string  `json:"dnsNames,omitempty"`
+	IPAddresses []string  `json:"ipAddresses,omitempty"`
+	SHA256      string    `json:"sha256"`
+	Verified    bool      `json:"verified"` // whether the chain was verified
+	TLSVersion  string    `json:"tlsVersion"`
+	CipherSuite string    `json:"cipherSuite"`
+}
+
+// versions names the TLS versions
+var versions = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// FromState returns the Info of the certificate that the server of state
+// presented, false if it presented none
+func FromState(state tls.ConnectionState) (Info, bool) {
+	if len(state.PeerCertificates) == 0 {
+		return Info{}, false
+	}
+	cert := state.PeerCertificates[0]
+
+	info := Info{
+		Host:        state.ServerName,
+		Subject:     cert.Subject.String(),
+		Issuer:      cert.Issuer.String(),
+		Serial:      cert.SerialNumber.Text(16),
+		NotBefore:   cert.NotBefore.UTC(),
+		NotAfter:    cert.NotAfter.UTC(),
+		DNSNames:    cert.DNSNames,
+		Verified:    len(state.VerifiedChains) > 0,
+		TLSVersion:  versions[state.Version],
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+	}
+	for _, ip := range cert.IPAddresses {
+		info.IPAddresses = append(info.IPAddresses, ip.String())
+	}
+	sum := sha256.Sum256(cert.Raw)
+	info.SHA256 = hex.EncodeToString(sum[:])
+	if info.TLSVersion == "" {
+		info.TLSVersion = fmt.Sprintf("0x%04x", state.Version)
+	}
+
+	return info, true
+}
@@ -0,0 +1,48 @@
+package certinfo
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestFromState(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	response, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	info, ok := FromState(*response.TLS)
+	if !ok {
+		t.Fatal("expected a certificate")
+	}
+
+	cert := server.Certificate()
+	sum := sha256.Sum256(cert.Raw)
+	if info.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("expected the fingerprint of the server certificate, received %s", info.SHA256)
+	}
+	if !info.Verified {
+		t.Error("expected the chain to be verified")
+	}
+	if info.Issuer != cert.Issuer.String() || !info.NotAfter.Equal(cert.NotAfter) {
+		t.Errorf("unexpected issuer %q or expiry %s", info.Issuer, info.NotAfter)
+	}
+	if len(info.IPAddresses) == 0 || info.IPAddresses[0] != "127.0.0.1" {
+		t.Errorf("expected 127.0.0.1 in the SAN, received %v", info.IPAddresses)
+	}
+	if info.TLSVersion == "" || info.CipherSuite == "" {
+		t.Errorf("expected the version and cipher suite, received %q %q", info.TLSVersion, info.CipherSuite)
+	}
+
+	if _, ok = FromState(tls.ConnectionState{}); ok {
+		t.Error("expected no certificate for a state without peer certificates")
+	}
+}
@@ -0,0 +1,134 @@
+package checksum
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+	"os"
+	"strings"
+)
+
+// ErrMismatch is returned when a file does not match its expected checksum
+var ErrMismatch = errors.New("checksum mismatch")
+
+// algorithms maps the supported prefixes to their hash constructors
+var algorithms = map[string]func() hash.Hash{
+	"md5":       md5.New,
+	"sha1":      sha1.New,
+	"sha256":    sha256.New,
+	"crc32":     func() hash.Hash { return crc32.NewIEEE() },
+	"crc32c":    func() hash.Hash { return crc32.New(crc32.MakeTable(crc32.Castagnoli)) },
+	"crc64nvme": func() hash.Hash { return crc64.New(crc64NVMETable) },
+}
+
+// crc64NVMETable is the table of the CRC-64/NVME polynomial, which S3 uses
+var crc64NVMETable = crc64.MakeTable(0x9a6c9329ac4bc9b5)
+
+// Checksum is an expected digest of a file, e.g. "sha256:9f86d0..."
+type Checksum struct {
+	Algorithm string
+	Sum       []byte
+}
+
+// HasPrefix returns true if s starts with the prefix of a supported algorithm
+func HasPrefix(s string) bool {
+	i := strings.Index(s, ":")
+	if i < 0 {
+		return false
+	}
+	_, ok := algorithms[strings.ToLower(strings.TrimSpace(s[:i]))]
+	return ok
+}
+
+// Parse parses a checksum in the form "<algorithm>:<hex digest>"
+func Parse(s string) (Checksum, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), ":", 2)
+	if len(parts) != 2 {
+		return Checksum{}, fmt.Errorf("invalid checksum %q", s)
+	}
+
+	algorithm := strings.ToLower(parts[0])
+	newHash, ok := algorithms[algorithm]
+	if !ok {
+		return Checksum{}, fmt.Errorf("unsupported checksum algorithm %q", parts[0])
+	}
+
+	sum, err := hex.DecodeString(parts[1])
+	if err != nil || len(sum) != newHash().Size() {
+		return Checksum{}, fmt.Errorf("invalid %s digest %q", algorithm, parts[1])
+	}
+
+	return Checksum{Algorithm: algorithm, Sum: sum}, nil
+}
+
+// IsZero returns true if no checksum was set
+func (c Checksum) IsZero() bool {
+	return c.Algorithm == ""
+}
+
+// String returns the checksum in the form it is parsed from
+func (c Checksum) String() string {
+	return c.Algorithm + ":" + hex.EncodeToString(c.Sum)
+}
+
+// VerifyFile returns ErrMismatch if the content of path doesn't match c
+func (c Checksum) VerifyFile(path string) error {
+	actual, err := SumFile(c.Algorithm, path)
+	if err != nil {
+		return err
+	}
+
+	return c.compare(actual)
+}
+
+// Verify returns ErrMismatch if data doesn't match c
+func (c Checksum) Verify(data []byte) error {
+	newHash, ok := algorithms[c.Algorithm]
+	if !ok {
+		return fmt.Errorf("unsupported checksum algorithm %q", c.Algorithm)
+	}
+
+	h := newHash()
+	_, _ = h.Write(data)
+	return c.compare(Checksum{Algorithm: c.Algorithm, Sum: h.Sum(nil)})
+}
+
+// compare returns ErrMismatch if the actual checksum differs from c
+func (c Checksum) compare(actual Checksum) error {
+	if !bytes.Equal(actual.Sum, c.Sum) {
+		return fmt.Errorf("%w: expected %s received %s", ErrMismatch, c, actual)
+	}
+
+	return nil
+}
+
+// SumFile returns the checksum of the content of path with algorithm
+func SumFile(algorithm, path string) (Checksum, error) {
+	newHash, ok := algorithms[algorithm]
+	if !ok {
+		return Checksum{}, fmt.Errorf("unsupported checksum algorithm %q", algorithm)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return Checksum{}, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	h := newHash()
+	if _, err = io.Copy(h, f); err != nil {
+		return Checksum{}, err
+	}
+
+	return Checksum{Algorithm: algorithm, Sum: h.Sum(nil)}, nil
+}
@@ -0,0 +1,103 @@
+package checksum
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		value     string
+		expectErr bool
+	}{
+		{"md5:5d41402abc4b2a76b9719d911017c592", false},
+		{"SHA1:aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d", false},
+		{"sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", false},
+		{"sha256:2cf24dba", true},
+		{"md5:not-hex", true},
+		{"crc64:1234", true},
+		{"5d41402abc4b2a76b9719d911017c592", true},
+	}
+
+	for _, testCase := range testCases {
+		_, err := Parse(testCase.value)
+		if (err != nil) != testCase.expectErr {
+			t.Errorf("value=%#v returned err=%v", testCase.value, err)
+		}
+	}
+}
+
+func TestVerifyFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = os.Remove(f.Name()); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	if _, err = f.WriteString("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	good, _ := Parse("md5:5d41402abc4b2a76b9719d911017c592")
+	if err = good.VerifyFile(f.Name()); err != nil {
+		t.Errorf("expected a match, received %v", err)
+	}
+
+	bad, _ := Parse("md5:00000000000000000000000000000000")
+	if err = bad.VerifyFile(f.Name()); !errors.Is(err, ErrMismatch) {
+		t.Errorf("expected ErrMismatch, received %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	good, _ := Parse("crc32c:9a71bb4c")
+	if err := good.Verify([]byte("hello")); err != nil {
+		t.Errorf("expected a match, received %v", err)
+	}
+
+	bad, _ := Parse("crc32c:00000000")
+	if err := bad.Verify([]byte("hello")); !errors.Is(err, ErrMismatch) {
+		t.Errorf("expected ErrMismatch, received %v", err)
+	}
+}
+
+func TestSumFile(t *testing.T) {
+	f, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = os.Remove(f.Name()); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	if _, err = f.WriteString("hello"); err != nil {
+		t.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	sum, err := SumFile("sha256", f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	if sum.String() != expected {
+		t.Errorf("expected %s received %s", expected, sum)
+	}
+
+	if _, err = SumFile("crc64", f.Name()); err == nil {
+		t.Error("expected an error for an unsupported algorithm")
+	}
+}
@@ -0,0 +1,62 @@
+package checksum
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// amzHeaders are the x-amz-checksum-* headers of S3 and their algorithms
+var amzHeaders = [][2]string{
+	{"X-Amz-Checksum-Crc32", "crc32"},
+	{"X-Amz-Checksum-Crc32c", "crc32c"},
+	{"X-Amz-Checksum-Crc64nvme", "crc64nvme"},
+	{"X-Amz-Checksum-Sha1", "sha1"},
+	{"X-Amz-Checksum-Sha256", "sha256"},
+}
+
+// FromHeader returns the checksums of the body that a response announces in
+// its Content-MD5, x-amz-checksum-* and x-goog-hash headers. Their values are
+// base64 encoded. Checksums of multipart uploads, which are checksums of the
+// checksums of the parts (e.g. "...-3"), are left out because they can't be
+// verified from the data alone.
+func FromHeader(h http.Header) []Checksum {
+	var sums []Checksum
+
+	add := func(algorithm, value string) {
+		value = strings.TrimSpace(value)
+		sum, err := base64.StdEncoding.DecodeString(value)
+		if err != nil || len(sum) != algorithms[algorithm]().Size() {
+			return
+		}
+		sums = append(sums, Checksum{Algorithm: algorithm, Sum: sum})
+	}
+
+	if value := h.Get("Content-MD5"); value != "" {
+		add("md5", value)
+	}
+
+	if !strings.EqualFold(h.Get("X-Amz-Checksum-Type"), "COMPOSITE") {
+		for _, header := range amzHeaders {
+			if value := h.Get(header[0]); value != "" {
+				add(header[1], value)
+			}
+		}
+	}
+
+	// x-goog-hash: crc32c=n03x6A==, md5=Ojk9c3dhfxgoKVVHYwFbHQ==
+	for _, header := range h.Values("X-Goog-Hash") {
+		for _, part := range strings.Split(header, ",") {
+			kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch algorithm := strings.ToLower(kv[0]); algorithm {
+			case "md5", "crc32c":
+				add(algorithm, kv[1])
+			}
+		}
+	}
+
+	return sums
+}
@@ -0,0 +1,59 @@
+package checksum
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCRCAlgorithms(t *testing.T) {
+	// the check values of the algorithms, the checksums of "123456789"
+	testCases := []struct {
+		algorithm string
+		expected  string
+	}{
+		{"crc32", "cbf43926"},
+		{"crc32c", "e3069283"},
+		{"crc64nvme", "ae8b14860a799888"},
+	}
+
+	for _, testCase := range testCases {
+		h := algorithms[testCase.algorithm]()
+		h.Write([]byte("123456789"))
+		if received := hex.EncodeToString(h.Sum(nil)); received != testCase.expected {
+			t.Errorf("%s: expected %s received %s", testCase.algorithm, testCase.expected, received)
+		}
+	}
+}
+
+func TestFromHeader(t *testing.T) {
+	testCases := []struct {
+		header   http.Header
+		expected []string
+	}{
+		{http.Header{"Content-Md5": {"XUFAKrxLKna5cZ2REBfFkg=="}}, []string{"md5:5d41402abc4b2a76b9719d911017c592"}},
+		{http.Header{
+			"X-Amz-Checksum-Crc32":  {"NhCmhg=="},
+			"X-Amz-Checksum-Sha256": {"LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="},
+		}, []string{"crc32:3610a686", "sha256:2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"}},
+		{http.Header{"X-Goog-Hash": {"crc32c=n03x6A==", "md5=XUFAKrxLKna5cZ2REBfFkg=="}}, []string{"crc32c:9f4df1e8", "md5:5d41402abc4b2a76b9719d911017c592"}},
+		{http.Header{"X-Goog-Hash": {"crc32c=n03x6A==,md5=XUFAKrxLKna5cZ2REBfFkg=="}}, []string{"crc32c:9f4df1e8", "md5:5d41402abc4b2a76b9719d911017c592"}},
+		// checksums of multipart uploads
+		{http.Header{"X-Amz-Checksum-Crc32": {"NhCmhg==-3"}}, nil},
+		{http.Header{"X-Amz-Checksum-Crc32": {"NhCmhg=="}, "X-Amz-Checksum-Type": {"COMPOSITE"}}, nil},
+		// wrong length and no base64
+		{http.Header{"Content-Md5": {"NhCmhg=="}}, nil},
+		{http.Header{"Content-Md5": {"5d41402abc4b2a76b9719d911017c592"}}, nil},
+	}
+
+	for _, testCase := range testCases {
+		var received []string
+		for _, sum := range FromHeader(testCase.header) {
+			received = append(received, sum.String())
+		}
+		if strings.Join(received, " ") != strings.Join(testCase.expected, " ") {
+			t.Errorf("%v: expected %v received %v", testCase.header, testCase.expected, received)
+		}
+	}
+}
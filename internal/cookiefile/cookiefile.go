@@ -0,0 +1,117 @@
+// Package cookiefile loads cookies from files in the Netscape cookie format
+// that is written by curl, wget and browser extensions
+package cookiefile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// httpOnlyPrefix marks HttpOnly cookies, which would otherwise be comments
+const httpOnlyPrefix = "#HttpOnly_"
+
+// Cookie is a cookie of a cookie file together with the site it belongs to
+type Cookie struct {
+	*http.Cookie
+
+	// IncludeSubdomains is true when the cookie is sent to the subdomains
+	// of its domain too
+	IncludeSubdomains bool
+}
+
+// URL returns the url that the cookie is set for
+func (c Cookie) URL() *url.URL {
+	scheme := "http"
+	if c.Secure {
+		scheme = "https"
+	}
+	return &url.URL{Scheme: scheme, Host: strings.TrimPrefix(c.Domain, "."), Path: c.Path}
+}
+
+// Parse reads the cookies of a Netscape cookie file. Every line holds the
+// tab separated fields domain, include subdomains, path, secure, expiry,
+// name and value.
+func Parse(r io.Reader) ([]Cookie, error) {
+	var cookies []Cookie
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		httpOnly := strings.HasPrefix(line, httpOnlyPrefix)
+		if httpOnly {
+			line = strings.TrimPrefix(line, httpOnlyPrefix)
+		} else if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		fields := strings.Split(line, "\t")
+		if len(fields) == 6 {
+			// cookies without a value
+			fields = append(fields, "")
+		}
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("line %d: expected 7 tab separated fields, found %d", n, len(fields))
+		}
+
+		expiry, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid expiry %q", n, fields[4])
+		}
+
+		c := Cookie{
+			Cookie: &http.Cookie{
+				Domain:   fields[0],
+				Path:     fields[2],
+				Secure:   strings.EqualFold(fields[3], "TRUE"),
+				Name:     fields[5],
+				Value:    fields[6],
+				HttpOnly: httpOnly,
+			},
+			IncludeSubdomains: strings.EqualFold(fields[1], "TRUE"),
+		}
+		// an expiry of 0 marks a session cookie
+		if expiry > 0 {
+			c.Expires = time.Unix(expiry, 0)
+		}
+		cookies = append(cookies, c)
+	}
+
+	return cookies, scanner.Err()
+}
+
+// NewJar creates a cookie jar holding the cookies of a Netscape cookie file
+func NewJar(r io.Reader) (*cookiejar.Jar, error) {
+	cookies, err := Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range cookies {
+		u := c.URL()
+
+		// cookies with a Domain attribute are sent to subdomains too,
+		// others only to the host itself
+		stored := *c.Cookie
+		if c.IncludeSubdomains {
+			stored.Domain = u.Host
+		} else {
+			stored.Domain = ""
+		}
+		jar.SetCookies(u, []*http.Cookie{&stored})
+	}
+
+	return jar, nil
+}
@@ -0,0 +1,71 @@
+package cookiefile
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const cookieFile = "# Netscape HTTP Cookie File\n" +
+	"\n" +
+	".example.com\tTRUE\t/\tFALSE\t0\tsession\tabc\n" +
+	"www.example.org\tFALSE\t/private\tTRUE\t4102444800\ttoken\txyz\n" +
+	"#HttpOnly_example.net\tFALSE\t/\tFALSE\t0\tsid\t42\n" +
+	"old.example.com\tFALSE\t/\tFALSE\t1\texpired\tgone\n"
+
+func TestParse(t *testing.T) {
+	cookies, err := Parse(strings.NewReader(cookieFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cookies) != 4 {
+		t.Fatalf("expected 4 cookies received %d", len(cookies))
+	}
+
+	c := cookies[1]
+	if c.Name != "token" || c.Value != "xyz" || !c.Secure || c.IncludeSubdomains || c.Expires.Year() != 2100 {
+		t.Errorf("unexpected cookie %+v", c.Cookie)
+	}
+	if u := c.URL().String(); u != "https://www.example.org/private" {
+		t.Errorf("unexpected url %s", u)
+	}
+	if !cookies[2].HttpOnly || cookies[2].Name != "sid" {
+		t.Errorf("expected an HttpOnly cookie sid, received %+v", cookies[2].Cookie)
+	}
+
+	if _, err = Parse(strings.NewReader("example.com\tTRUE\t/\n")); err == nil {
+		t.Error("expected an error for a line with missing fields")
+	}
+}
+
+func TestNewJar(t *testing.T) {
+	jar, err := NewJar(strings.NewReader(cookieFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		url      string
+		expected string
+	}{
+		{"http://example.com/", "session=abc"},
+		{"http://sub.example.com/x", "session=abc"},
+		{"https://www.example.org/private/file", "token=xyz"},
+		{"http://www.example.org/private/file", ""},
+		{"https://www.example.org/public", ""},
+		{"http://example.net/", "sid=42"},
+		{"http://sub.example.net/", ""},
+		{"http://old.example.com/", "session=abc"},
+	}
+
+	for _, testCase := range testCases {
+		u, _ := url.Parse(testCase.url)
+		var names []string
+		for _, c := range jar.Cookies(u) {
+			names = append(names, c.Name+"="+c.Value)
+		}
+		if received := strings.Join(names, "; "); received != testCase.expected {
+			t.Errorf("url=%s expected %q received %q", testCase.url, testCase.expected, received)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+// Package diskspace reports the free space of file systems.
+package diskspace
+
+import "errors"
+
+// ErrUnsupported is returned by Free on operating systems where the free
+// space can't be determined
+var ErrUnsupported = errors.New("free disk space is not supported on this system")
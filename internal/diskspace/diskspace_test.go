@@ -0,0 +1,30 @@
+package diskspace
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestFree(t *testing.T) {
+	dir, err := ioutil.TempDir("", "diskspace")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	free, err := Free(dir)
+	if err == ErrUnsupported {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if free == 0 {
+		t.Error("expected some free space in the temporary directory")
+	}
+
+	if _, err = Free(dir + "/missing"); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+}
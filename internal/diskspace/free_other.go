@@ -0,0 +1,9 @@
+//go:build !linux && !darwin && !freebsd && !windows
+// +build !linux,!darwin,!freebsd,!windows
+
+package diskspace
+
+// Free returns ErrUnsupported
+func Free(path string) (uint64, error) {
+	return 0, ErrUnsupported
+}
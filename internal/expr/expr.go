@@ -0,0 +1,520 @@
+// Package expr implements a small expression language for deciding whether
+// a response is acceptable, e.g.
+//
+//	status == 200 && size > 1024 && header("content-type") startsWith "image/"
+//
+// The whole language is
+//
+//	or         = and { "||" and }
+//	and        = not { "&&" not }
+//	not        = "!" not | comparison
+//	comparison = operand [ op operand ] | operand "matches" string
+//	op         = "==" | "!=" | "<" | "<=" | ">" | ">=" | "startsWith" | "endsWith" | "contains"
+//	operand    = number | string | "true" | "false" | name | name "(" [ constant { "," constant } ] ")" | "(" or ")"
+//	constant   = number | string
+//
+// Strings are quoted with " or ', and the pattern of matches is a regular
+// expression that is compiled with the expression. Values are numbers,
+// strings and booleans, there is no arithmetic and comparisons don't chain.
+// Variables and functions are provided by an Env; the arguments of functions
+// are constants.
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Env provides the variables and functions of an expression
+type Env struct {
+	Vars  map[string]interface{}
+	Funcs map[string]func(args ...interface{}) (interface{}, error)
+}
+
+// Expr is a compiled expression
+type Expr struct {
+	src  string
+	root node
+}
+
+// Compile parses an expression
+func Compile(src string) (*Expr, error) {
+	p := &parser{src: src}
+	if err := p.tokenize(); err != nil {
+		return nil, err
+	}
+
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("expr: unexpected %q in %q", p.tokens[p.pos].text, src)
+	}
+
+	return &Expr{src: src, root: root}, nil
+}
+
+// String returns the source of the expression
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Eval evaluates the expression
+func (e *Expr) Eval(env Env) (interface{}, error) {
+	return e.root.eval(env)
+}
+
+// EvalBool evaluates an expression that must produce a boolean
+func (e *Expr) EvalBool(env Env) (bool, error) {
+	v, err := e.Eval(env)
+	if err != nil {
+		return false, err
+	}
+
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expr: %q is %s, not a boolean", e.src, typeName(v))
+	}
+	return b, nil
+}
+
+type tokKind int
+
+const (
+	tokNumber tokKind = iota
+	tokString
+	tokIdent
+	tokOp
+)
+
+type token struct {
+	kind tokKind
+	text string
+}
+
+type parser struct {
+	src    string
+	tokens []token
+	pos    int
+}
+
+// operators, longest first
+var operators = []string{"||", "&&", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")", ","}
+
+func (p *parser) tokenize() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+
+		case c == '"' || c == '\'':
+			j := i + 1
+			for j < len(s) && s[j] != c {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return fmt.Errorf("expr: unterminated string in %q", p.src)
+			}
+			text := s[i+1 : j]
+			if c == '"' {
+				unquoted, err := strconv.Unquote(s[i : j+1])
+				if err != nil {
+					return fmt.Errorf("expr: invalid string %s", s[i:j+1])
+				}
+				text = unquoted
+			}
+			p.tokens = append(p.tokens, token{tokString, text})
+			i = j + 1
+
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(s) && (s[j] >= '0' && s[j] <= '9' || s[j] == '.') {
+				j++
+			}
+			p.tokens = append(p.tokens, token{tokNumber, s[i:j]})
+			i = j
+
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i
+			for j < len(s) && (s[j] == '_' || s[j] >= 'a' && s[j] <= 'z' || s[j] >= 'A' && s[j] <= 'Z' || s[j] >= '0' && s[j] <= '9') {
+				j++
+			}
+			p.tokens = append(p.tokens, token{tokIdent, s[i:j]})
+			i = j
+
+		default:
+			found := false
+			for _, op := range operators {
+				if strings.HasPrefix(s[i:], op) {
+					p.tokens = append(p.tokens, token{tokOp, op})
+					i += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("expr: unexpected %q in %q", c, p.src)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p *parser) peek() (token, bool) {
+	if p.pos >= len(p.tokens) {
+		return token{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+// accept consumes the next token if it is the operator or keyword text
+func (p *parser) accept(text string) bool {
+	if t, ok := p.peek(); ok && (t.kind == tokOp || t.kind == tokIdent) && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("expr: "+format+" in %q", append(args, p.src)...)
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("&&") {
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &logical{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if p.accept("!") {
+		operand, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		return &not{operand: operand}, nil
+	}
+	return p.parseComparison()
+}
+
+var comparisons = []string{"==", "!=", "<=", ">=", "<", ">", "startsWith", "endsWith", "contains", "matches"}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range comparisons {
+		if !p.accept(op) {
+			continue
+		}
+
+		right, err := p.parsePrimary()
+		if err != nil {
+			return nil, err
+		}
+
+		c := &comparison{op: op, left: left, right: right}
+		if op == "matches" {
+			lit, ok := right.(*literal)
+			pattern, isString := "", false
+			if ok {
+				pattern, isString = lit.value.(string)
+			}
+			if !isString {
+				return nil, p.errorf("matches expects a quoted pattern")
+			}
+			if c.re, err = regexp.Compile(pattern); err != nil {
+				return nil, p.errorf("invalid pattern %q: %v", pattern, err)
+			}
+		}
+		return c, nil
+	}
+
+	return left, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t, ok := p.peek()
+	if !ok {
+		return nil, p.errorf("unexpected end")
+	}
+	p.pos++
+
+	switch t.kind {
+	case tokNumber:
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", t.text)
+		}
+		return &literal{f}, nil
+
+	case tokString:
+		return &literal{t.text}, nil
+
+	case tokIdent:
+		switch t.text {
+		case "true":
+			return &literal{true}, nil
+		case "false":
+			return &literal{false}, nil
+		}
+
+		if !p.accept("(") {
+			return &variable{t.text}, nil
+		}
+
+		call := &call{name: t.text}
+		if p.accept(")") {
+			return call, nil
+		}
+		for {
+			arg, ok := p.peek()
+			if !ok || arg.kind != tokString && arg.kind != tokNumber {
+				return nil, p.errorf("the arguments of %s must be quoted strings or numbers", t.text)
+			}
+			constant, err := p.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			call.args = append(call.args, constant)
+			if p.accept(")") {
+				return call, nil
+			}
+			if !p.accept(",") {
+				return nil, p.errorf("expected , or ) in the arguments of %s", t.text)
+			}
+		}
+
+	default:
+		if t.text == "(" {
+			n, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if !p.accept(")") {
+				return nil, p.errorf("missing )")
+			}
+			return n, nil
+		}
+	}
+
+	return nil, p.errorf("unexpected %q", t.text)
+}
+
+type node interface {
+	eval(env Env) (interface{}, error)
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (n *literal) eval(Env) (interface{}, error) {
+	return n.value, nil
+}
+
+type variable struct {
+	name string
+}
+
+func (n *variable) eval(env Env) (interface{}, error) {
+	v, ok := env.Vars[n.name]
+	if !ok {
+		return nil, fmt.Errorf("expr: unknown variable %s", n.name)
+	}
+	return normalize(v), nil
+}
+
+type call struct {
+	name string
+	args []node
+}
+
+func (n *call) eval(env Env) (interface{}, error) {
+	f, ok := env.Funcs[n.name]
+	if !ok {
+		return nil, fmt.Errorf("expr: unknown function %s", n.name)
+	}
+
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+
+	v, err := f(args...)
+	return normalize(v), err
+}
+
+// normalize converts the integer types to float64
+func normalize(v interface{}) interface{} {
+	switch x := v.(type) {
+	case int:
+		return float64(x)
+	case int64:
+		return float64(x)
+	case uint64:
+		return float64(x)
+	}
+	return v
+}
+
+type logical struct {
+	op          string
+	left, right node
+}
+
+func (n *logical) eval(env Env) (interface{}, error) {
+	left, err := evalBool(n.left, env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "||" && left || n.op == "&&" && !left {
+		return left, nil
+	}
+	return evalBool(n.right, env)
+}
+
+type not struct {
+	operand node
+}
+
+func (n *not) eval(env Env) (interface{}, error) {
+	b, err := evalBool(n.operand, env)
+	return !b, err
+}
+
+func evalBool(n node, env Env) (bool, error) {
+	v, err := n.eval(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expr: expected a boolean, received %s", typeName(v))
+	}
+	return b, nil
+}
+
+type comparison struct {
+	op          string
+	left, right node
+	re          *regexp.Regexp // the pattern of matches
+}
+
+func (n *comparison) eval(env Env) (interface{}, error) {
+	left, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	right, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.op {
+	case "==":
+		return left == right, nil
+	case "!=":
+		return left != right, nil
+	}
+
+	if ls, ok := left.(string); ok {
+		rs, ok := right.(string)
+		if !ok {
+			return nil, fmt.Errorf("expr: cannot compare string with %s using %s", typeName(right), n.op)
+		}
+		switch n.op {
+		case "startsWith":
+			return strings.HasPrefix(ls, rs), nil
+		case "endsWith":
+			return strings.HasSuffix(ls, rs), nil
+		case "contains":
+			return strings.Contains(ls, rs), nil
+		case "matches":
+			return n.re.MatchString(ls), nil
+		}
+		return compareOrdered(n.op, strings.Compare(ls, rs)), nil
+	}
+
+	lf, lok := left.(float64)
+	rf, rok := right.(float64)
+	if !lok || !rok {
+		return nil, fmt.Errorf("expr: cannot compare %s with %s using %s", typeName(left), typeName(right), n.op)
+	}
+	switch {
+	case lf < rf:
+		return compareOrdered(n.op, -1), nil
+	case lf > rf:
+		return compareOrdered(n.op, 1), nil
+	default:
+		return compareOrdered(n.op, 0), nil
+	}
+}
+
+// compareOrdered applies <, <=, > or >= to the result of a comparison
+func compareOrdered(op string, cmp int) bool {
+	switch op {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+	return false
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case float64:
+		return "a number"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case nil:
+		return "nothing"
+	}
+	return fmt.Sprintf("%T", v)
+}
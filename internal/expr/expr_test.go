@@ -0,0 +1,76 @@
+package expr
+
+import (
+	"strings"
+	"testing"
+)
+
+func testEnv() Env {
+	headers := map[string]string{"content-type": "image/png", "x-cache": "HIT"}
+	return Env{
+		Vars: map[string]interface{}{"status": 200, "size": int64(2048), "url": "https://example.com/a.png"},
+		Funcs: map[string]func(args ...interface{}) (interface{}, error){
+			"header": func(args ...interface{}) (interface{}, error) {
+				return headers[strings.ToLower(args[0].(string))], nil
+			},
+		},
+	}
+}
+
+func TestEvalBool(t *testing.T) {
+	testCases := []struct {
+		src      string
+		expected bool
+	}{
+		{`status == 200`, true},
+		{`status == 200 && size > 1024 && header("content-type") startsWith "image/"`, true},
+		{`status != 200 || size < 1024`, false},
+		{`!(status >= 400)`, true},
+		{`status >= 200 && status <= 299`, true},
+		{`url endsWith ".png" && url contains "example"`, true},
+		{`url matches '^https://[a-z.]+/a\.png$'`, true},
+		{`header("Content-Type") == "image/png"`, true},
+		{`header("x-missing") == ""`, true},
+		{`header("x-cache") != "HIT"`, false},
+		{`status == 404 || status == 200`, true},
+		{`true && !false`, true},
+	}
+
+	for _, testCase := range testCases {
+		e, err := Compile(testCase.src)
+		if err != nil {
+			t.Errorf("src=%s: %v", testCase.src, err)
+			continue
+		}
+
+		received, err := e.EvalBool(testEnv())
+		if err != nil {
+			t.Errorf("src=%s: %v", testCase.src, err)
+			continue
+		}
+		if received != testCase.expected {
+			t.Errorf("src=%s expected %v received %v", testCase.src, testCase.expected, received)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	// outside of the language as well
+	for _, src := range []string{`status ==`, `(status == 200`, `"open`, `status # 1`, `url matches "("`,
+		`size + 1 > 2`, `status == 200 == true`, `url matches url`, `header(url) == ""`, `header(header("a")) == ""`} {
+		if _, err := Compile(src); err == nil {
+			t.Errorf("src=%s expected a compile error", src)
+		}
+	}
+
+	for _, src := range []string{`unknown == 1`, `status`, `url > 1`, `nope()`, `status && true`} {
+		e, err := Compile(src)
+		if err != nil {
+			t.Errorf("src=%s: %v", src, err)
+			continue
+		}
+		if _, err = e.EvalBool(testEnv()); err == nil {
+			t.Errorf("src=%s expected an evaluation error", src)
+		}
+	}
+}
@@ -0,0 +1,390 @@
+// Package extract unpacks .zip, .tar.gz and .tar.zst archives. Members whose
+// path would leave the target directory, links and special files are
+// skipped, existing files are never overwritten, and nothing is written
+// through a symbolic link that exists in the target directory already.
+package extract
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// the formats Extract knows
+const (
+	Zip    = "zip"
+	TarGz  = "tar.gz"
+	TarZst = "tar.zst"
+)
+
+// ZstdCommand decompresses .tar.zst archives, Go has no zstd decoder of its
+// own
+var ZstdCommand = "zstd"
+
+// ErrTooLarge is returned when the members of an archive are larger than the
+// limit passed to Extract
+var ErrTooLarge = errors.New("archive is larger than the limit once extracted")
+
+// extensions maps the file extensions to their formats
+var extensions = []struct {
+	suffix string
+	format string
+}{
+	{".zip", Zip},
+	{".tar.gz", TarGz},
+	{".tgz", TarGz},
+	{".tar.zst", TarZst},
+	{".tar.zstd", TarZst},
+	{".tzst", TarZst},
+}
+
+// Format returns the format of the archive name by its extension, "" if it
+// isn't an archive Extract knows
+func Format(name string) string {
+	name = strings.ToLower(name)
+	for _, ext := range extensions {
+		if strings.HasSuffix(name, ext.suffix) {
+			return ext.format
+		}
+	}
+	return ""
+}
+
+// Supported returns an error if archives of format can't be extracted here,
+// .tar.zst ones need ZstdCommand
+func Supported(format string) error {
+	if format != TarZst {
+		return nil
+	}
+	if _, err := exec.LookPath(ZstdCommand); err != nil {
+		return fmt.Errorf("the %s command is needed: %v", ZstdCommand, err)
+	}
+	return nil
+}
+
+// Dir returns the directory the archive at archivePath is extracted into by
+// convention: its path without the archive extension, e.g. data for
+// data.tar.gz, or with .d appended if nothing is left of the name
+func Dir(archivePath string) string {
+	lower := strings.ToLower(archivePath)
+	for _, ext := range extensions {
+		if strings.HasSuffix(lower, ext.suffix) && len(filepath.Base(archivePath)) > len(ext.suffix) {
+			return archivePath[:len(archivePath)-len(ext.suffix)]
+		}
+	}
+	return archivePath + ".d"
+}
+
+// Result is what Extract unpacked
+type Result struct {
+	Files   int      // regular files written
+	Skipped []string // members that were not written and why
+}
+
+// Extract unpacks the archive at archivePath into dir. Members that exist in
+// dir already are skipped rather than overwritten. Once more than maxBytes
+// were written, the extraction stops with ErrTooLarge; 0 means no limit. If
+// the extraction fails, the files and directories it created are removed
+// again, including dir if it didn't exist.
+func Extract(archivePath, dir string, maxBytes int64) (Result, error) {
+	x := &extractor{dir: filepath.Clean(dir), maxBytes: maxBytes}
+	var err error
+	switch Format(archivePath) {
+	case Zip:
+		err = x.zip(archivePath)
+	case TarGz:
+		err = x.tarGz(archivePath)
+	case TarZst:
+		err = x.tarZst(archivePath)
+	default:
+		err = fmt.Errorf("%s is not a .zip, .tar.gz or .tar.zst archive", archivePath)
+	}
+	if err != nil {
+		x.removeCreated()
+	}
+	return x.result, err
+}
+
+type extractor struct {
+	dir      string
+	maxBytes int64    // 0 for no limit
+	written  int64    // bytes of the members written so far
+	created  []string // the files and directories created, in that order
+	result   Result
+}
+
+// removeCreated removes what the extraction created, the newest first, so
+// that the directories are empty by the time they are removed. Directories
+// that something else wrote to in the meantime are kept.
+func (x *extractor) removeCreated() {
+	for i := len(x.created) - 1; i >= 0; i-- {
+		_ = os.Remove(x.created[i])
+	}
+	x.created = nil
+}
+
+func (x *extractor) zip(archivePath string) (err error) {
+	r, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := r.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	for _, f := range r.File {
+		mode := f.Mode()
+		if !mode.IsDir() && !mode.IsRegular() {
+			x.skip(f.Name, "not a regular file")
+			continue
+		}
+		target, ok := x.target(f.Name)
+		if !ok {
+			continue
+		}
+		if mode.IsDir() {
+			if err = x.mkdir(target); err != nil {
+				return err
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+		err = x.writeFile(target, rc, mode)
+		if closeErr := rc.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (x *extractor) tarGz(archivePath string) (err error) {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return fmt.Errorf("%s: %v", archivePath, err)
+	}
+	if err = x.tar(gz); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func (x *extractor) tarZst(archivePath string) (err error) {
+	if err = Supported(TarZst); err != nil {
+		return err
+	}
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	var stderr strings.Builder
+	cmd := exec.Command(ZstdCommand, "-d", "-c", "-q")
+	cmd.Stdin = f
+	cmd.Stderr = &stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err = cmd.Start(); err != nil {
+		return err
+	}
+	err = x.tar(out)
+	// the rest is drained so that zstd doesn't block on a full pipe
+	_, _ = io.Copy(ioutil.Discard, out)
+	if waitErr := cmd.Wait(); waitErr != nil && err == nil {
+		err = fmt.Errorf("%s: %s: %v %s", archivePath, ZstdCommand, waitErr, strings.TrimSpace(stderr.String()))
+	}
+	return err
+}
+
+func (x *extractor) tar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir, tar.TypeReg, tar.TypeRegA:
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			x.skip(hdr.Name, "not a regular file")
+			continue
+		}
+		target, ok := x.target(hdr.Name)
+		if !ok {
+			continue
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			if err = x.mkdir(target); err != nil {
+				return err
+			}
+			continue
+		}
+		if err = x.writeFile(target, tr, hdr.FileInfo().Mode()); err != nil {
+			return err
+		}
+	}
+}
+
+// target returns where the member name is written, false if its path is
+// absolute or leaves the directory
+func (x *extractor) target(name string) (string, bool) {
+	// zip files made on Windows may separate with backslashes
+	clean := strings.ReplaceAll(name, "\\", "/")
+	if path.IsAbs(clean) || filepath.VolumeName(clean) != "" {
+		x.skip(name, "absolute path")
+		return "", false
+	}
+	clean = path.Clean(clean)
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		x.skip(name, "path leaves the directory")
+		return "", false
+	}
+	if clean == "." {
+		return "", false
+	}
+	return filepath.Join(x.dir, filepath.FromSlash(clean)), true
+}
+
+// checkPath fails if target or a directory between x.dir and target is a
+// symbolic link, which an earlier archive or anything else may have left
+// there
+func (x *extractor) checkPath(target string) error {
+	rel, err := filepath.Rel(x.dir, target)
+	if err != nil || rel == "." {
+		return err
+	}
+	current := x.dir
+	for _, element := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, element)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s is a symbolic link, not extracting into it", current)
+		}
+	}
+	return nil
+}
+
+func (x *extractor) mkdir(target string) error {
+	if err := x.checkPath(target); err != nil {
+		return err
+	}
+
+	// the directories that are missing, the innermost first
+	var missing []string
+	for dir := target; ; dir = filepath.Dir(dir) {
+		if _, err := os.Lstat(dir); !os.IsNotExist(err) || filepath.Dir(dir) == dir {
+			break
+		}
+		missing = append(missing, dir)
+	}
+	if err := os.MkdirAll(target, 0755); err != nil {
+		return err
+	}
+	for i := len(missing) - 1; i >= 0; i-- {
+		x.created = append(x.created, missing[i])
+	}
+	return nil
+}
+
+// writeFile writes the member r to target with the permission bits of mode,
+// setuid and the like are dropped. target is created and must not exist, so
+// neither an existing file nor a link at target is written through.
+func (x *extractor) writeFile(target string, r io.Reader, mode os.FileMode) error {
+	if err := x.mkdir(filepath.Dir(target)); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode.Perm()|0600)
+	if os.IsExist(err) {
+		rel, _ := filepath.Rel(x.dir, target)
+		x.skip(filepath.ToSlash(rel), "exists already")
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	err = x.copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// the umask doesn't apply to the permissions of the archive
+		err = os.Chmod(target, mode.Perm()|0600)
+	}
+	if err != nil {
+		if removeErr := os.Remove(target); removeErr != nil {
+			return fmt.Errorf("%s: %v, unable to remove it: %v", target, err, removeErr)
+		}
+		return fmt.Errorf("%s: %w", target, err)
+	}
+	x.created = append(x.created, target)
+	x.result.Files++
+	return nil
+}
+
+// copy copies r to w and fails with ErrTooLarge once the members written so
+// far exceed x.maxBytes
+func (x *extractor) copy(w io.Writer, r io.Reader) error {
+	if x.maxBytes <= 0 {
+		n, err := io.Copy(w, r)
+		x.written += n
+		return err
+	}
+	n, err := io.CopyN(w, r, x.maxBytes-x.written+1)
+	x.written += n
+	if err == io.EOF {
+		err = nil
+	}
+	if err == nil && x.written > x.maxBytes {
+		err = ErrTooLarge
+	}
+	return err
+}
+
+func (x *extractor) skip(name, reason string) {
+	x.result.Skipped = append(x.result.Skipped, name+": "+reason)
+}
@@ -0,0 +1,324 @@
+package extract
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// member is a file, directory or link of a test archive
+type member struct {
+	name string
+	body string
+	mode os.FileMode
+	link string
+}
+
+// members has a safe file and directory and all kinds of members that must
+// not be written
+var members = []member{
+	{name: "dir/", mode: os.ModeDir | 0755},
+	{name: "dir/a.txt", body: "a", mode: 0644},
+	{name: "./b.txt", body: "b", mode: 04755},
+	{name: "../evil.txt", body: "evil", mode: 0644},
+	{name: "dir/../../evil2.txt", body: "evil", mode: 0644},
+	{name: "/abs.txt", body: "evil", mode: 0644},
+	{name: "..\\win.txt", body: "evil", mode: 0644},
+	{name: "link", mode: os.ModeSymlink | 0777, link: "/etc"},
+}
+
+func writeZip(t *testing.T, archivePath string) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, m := range members {
+		hdr := &zip.FileHeader{Name: m.name, Method: zip.Deflate}
+		hdr.SetMode(m.mode)
+		f, err := w.CreateHeader(hdr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := m.body
+		if m.link != "" {
+			body = m.link
+		}
+		if _, err = f.Write([]byte(body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(archivePath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tarData(t *testing.T) []byte {
+	var buf bytes.Buffer
+	w := tar.NewWriter(&buf)
+	for _, m := range members {
+		hdr := &tar.Header{Name: m.name, Mode: int64(m.mode.Perm()), Size: int64(len(m.body))}
+		switch {
+		case m.mode.IsDir():
+			hdr.Typeflag = tar.TypeDir
+		case m.link != "":
+			hdr.Typeflag, hdr.Linkname = tar.TypeSymlink, m.link
+		default:
+			hdr.Typeflag = tar.TypeReg
+		}
+		if m.mode&os.ModeSetuid != 0 {
+			hdr.Mode |= 04000
+		}
+		if err := w.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(m.body)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func writeTarGz(t *testing.T, archivePath string) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(tarData(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(archivePath, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTarZst(t *testing.T, archivePath string) {
+	cmd := exec.Command(ZstdCommand, "-q", "-o", archivePath)
+	cmd.Stdin = bytes.NewReader(tarData(t))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expected string
+	}{
+		{"a.zip", Zip},
+		{"A.ZIP", Zip},
+		{"a.tar.gz", TarGz},
+		{"a.tgz", TarGz},
+		{"a.tar.zst", TarZst},
+		{"a.tzst", TarZst},
+		{"a.gz", ""},
+		{"a.zip.txt", ""},
+	}
+	for _, testCase := range testCases {
+		if received := Format(testCase.name); received != testCase.expected {
+			t.Errorf("%s: expected %q received %q", testCase.name, testCase.expected, received)
+		}
+	}
+}
+
+func TestDir(t *testing.T) {
+	testCases := []struct {
+		archivePath string
+		expected    string
+	}{
+		{"out/data.zip", "out/data"},
+		{"out/data.TAR.GZ", "out/data"},
+		{"out/data.tar.zst", "out/data"},
+		{"out/.zip", "out/.zip.d"},
+		{"out/data.bin", "out/data.bin.d"},
+	}
+	for _, testCase := range testCases {
+		if received := Dir(testCase.archivePath); received != testCase.expected {
+			t.Errorf("%s: expected %s received %s", testCase.archivePath, testCase.expected, received)
+		}
+	}
+}
+
+func TestExtract(t *testing.T) {
+	testCases := []struct {
+		name  string
+		write func(*testing.T, string)
+	}{
+		{"a.zip", writeZip},
+		{"a.tar.gz", writeTarGz},
+		{"a.tar.zst", writeTarZst},
+	}
+	for _, testCase := range testCases {
+		if Format(testCase.name) == TarZst {
+			if _, err := exec.LookPath(ZstdCommand); err != nil {
+				t.Logf("%s: no %s command", testCase.name, ZstdCommand)
+				continue
+			}
+		}
+
+		root, err := ioutil.TempDir("", "extract")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(root)
+		dir := filepath.Join(root, "out")
+		if err = os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		archivePath := filepath.Join(dir, testCase.name)
+		testCase.write(t, archivePath)
+
+		res, err := Extract(archivePath, dir, 0)
+		if err != nil {
+			t.Errorf("%s: %v", testCase.name, err)
+			continue
+		}
+		if res.Files != 2 || len(res.Skipped) != 5 {
+			t.Errorf("%s: expected 2 files and 5 skipped received %d and %v", testCase.name, res.Files, res.Skipped)
+		}
+		for name, expected := range map[string]string{"dir/a.txt": "a", "b.txt": "b"} {
+			data, err := ioutil.ReadFile(filepath.Join(dir, name))
+			if err != nil || string(data) != expected {
+				t.Errorf("%s: %s: expected %q received %q (%v)", testCase.name, name, expected, data, err)
+			}
+		}
+		if info, err := os.Stat(filepath.Join(dir, "b.txt")); err == nil && info.Mode()&os.ModeSetuid != 0 {
+			t.Errorf("%s: expected setuid to be dropped", testCase.name)
+		}
+		for _, name := range []string{"evil.txt", "evil2.txt", "abs.txt", "win.txt"} {
+			if _, err := os.Stat(filepath.Join(root, name)); err == nil {
+				t.Errorf("%s: %s was written outside the directory", testCase.name, name)
+			}
+		}
+		if _, err := os.Lstat(filepath.Join(dir, "link")); err == nil {
+			t.Errorf("%s: expected the link to be skipped", testCase.name)
+		}
+	}
+}
+
+func TestExtractThroughSymlink(t *testing.T) {
+	root, err := ioutil.TempDir("", "extract")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	dir, outside := filepath.Join(root, "out"), filepath.Join(root, "outside")
+	for _, d := range []string{dir, outside} {
+		if err = os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a link an earlier archive or someone else left behind
+	if err = os.Symlink(outside, filepath.Join(dir, "dir")); err != nil {
+		t.Skip(err)
+	}
+
+	archivePath := filepath.Join(dir, "a.zip")
+	writeZip(t, archivePath)
+	if _, err = Extract(archivePath, dir, 0); err == nil {
+		t.Error("expected an error for a directory that is a symbolic link")
+	}
+	if _, err = os.Stat(filepath.Join(outside, "a.txt")); err == nil {
+		t.Error("a.txt was written through the symbolic link")
+	}
+}
+
+// extractTestArchives writes a.zip and a.tar.gz to a new directory and calls
+// check with the path of each, the directory is removed afterwards
+func extractTestArchives(t *testing.T, check func(archivePath, dir string)) {
+	for name, write := range map[string]func(*testing.T, string){"a.zip": writeZip, "a.tar.gz": writeTarGz} {
+		root, err := ioutil.TempDir("", "extract")
+		if err != nil {
+			t.Fatal(err)
+		}
+		archivePath := filepath.Join(root, name)
+		write(t, archivePath)
+		dir := filepath.Join(root, "out")
+		if err = os.Mkdir(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		check(archivePath, dir)
+
+		if err = os.RemoveAll(root); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestExtractExisting(t *testing.T) {
+	extractTestArchives(t, func(archivePath, dir string) {
+		// a file that was downloaded or extracted before, and a link
+		if err := ioutil.WriteFile(filepath.Join(dir, "b.txt"), []byte("old"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Mkdir(filepath.Join(dir, "dir"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		outside := filepath.Join(filepath.Dir(dir), "outside.txt")
+		if err := os.Symlink(outside, filepath.Join(dir, "dir", "a.txt")); err != nil {
+			t.Skip(err)
+		}
+
+		res, err := Extract(archivePath, dir, 0)
+		if err != nil {
+			t.Fatalf("%s: %v", archivePath, err)
+		}
+		if res.Files != 0 || len(res.Skipped) != 7 {
+			t.Errorf("%s: expected 0 files and 7 skipped received %d and %v", archivePath, res.Files, res.Skipped)
+		}
+		if data, err := ioutil.ReadFile(filepath.Join(dir, "b.txt")); err != nil || string(data) != "old" {
+			t.Errorf("%s: expected b.txt to be kept received %q (%v)", archivePath, data, err)
+		}
+		if _, err = os.Stat(outside); err == nil {
+			t.Errorf("%s: a.txt was written through the link", archivePath)
+		}
+	})
+}
+
+func TestExtractTooLarge(t *testing.T) {
+	extractTestArchives(t, func(archivePath, dir string) {
+		// dir/a.txt fits, b.txt is the byte too many
+		res, err := Extract(archivePath, dir, 1)
+		if !errors.Is(err, ErrTooLarge) {
+			t.Errorf("%s: expected %v received %v", archivePath, ErrTooLarge, err)
+		}
+		if res.Files != 1 {
+			t.Errorf("%s: expected 1 file received %d", archivePath, res.Files)
+		}
+		// dir/a.txt and dir were written, they are removed with b.txt
+		for _, name := range []string{"b.txt", "dir/a.txt", "dir"} {
+			if _, err = os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+				t.Errorf("%s: expected %s to be removed received %v", archivePath, name, err)
+			}
+		}
+		// the directory existed before the extraction
+		if _, err = os.Stat(dir); err != nil {
+			t.Errorf("%s: expected %s to be kept received %v", archivePath, dir, err)
+		}
+
+		// a directory the extraction created is removed as well
+		created := filepath.Join(dir, "new", "out")
+		if _, err = Extract(archivePath, created, 1); !errors.Is(err, ErrTooLarge) {
+			t.Errorf("%s: expected %v received %v", archivePath, ErrTooLarge, err)
+		}
+		if _, err = os.Stat(filepath.Join(dir, "new")); !os.IsNotExist(err) {
+			t.Errorf("%s: expected the directories to be removed received %v", archivePath, err)
+		}
+
+		if res, err = Extract(archivePath, filepath.Join(dir, "all"), 2); err != nil || res.Files != 2 {
+			t.Errorf("%s: expected 2 files within the limit received %d (%v)", archivePath, res.Files, err)
+		}
+	})
+}
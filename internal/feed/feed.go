@@ -0,0 +1,86 @@
+// Package feed reads the links of RSS 2.0, RSS 1.0 (RDF) and Atom feeds.
+package feed
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Item is an item of an RSS feed or an entry of an Atom feed
+type Item struct {
+	Link       string   // the page of the item
+	Enclosures []string // attached files, like the episodes of a podcast
+}
+
+type rssItem struct {
+	Link       string `xml:"link"`
+	Enclosures []struct {
+		URL string `xml:"url,attr"`
+	} `xml:"enclosure"`
+}
+
+type atomEntry struct {
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+	} `xml:"link"`
+}
+
+type document struct {
+	XMLName xml.Name
+	Channel struct {
+		Items []rssItem `xml:"item"`
+	} `xml:"channel"`
+	Items   []rssItem   `xml:"item"`  // RSS 1.0 lists the items next to the channel
+	Entries []atomEntry `xml:"entry"` // Atom
+}
+
+// Parse reads the items of the feed in r. The urls are returned as they are
+// written, relative urls of Atom feeds are not resolved.
+func Parse(r io.Reader) ([]Item, error) {
+	var doc document
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	items := make([]Item, 0)
+	switch doc.XMLName.Local {
+	case "rss":
+		items = appendRSS(items, doc.Channel.Items)
+	case "RDF":
+		items = appendRSS(items, doc.Items)
+	case "feed":
+		for _, entry := range doc.Entries {
+			var item Item
+			for _, link := range entry.Links {
+				href := strings.TrimSpace(link.Href)
+				switch {
+				case href == "":
+				case link.Rel == "enclosure":
+					item.Enclosures = append(item.Enclosures, href)
+				case (link.Rel == "" || link.Rel == "alternate") && item.Link == "":
+					item.Link = href
+				}
+			}
+			items = append(items, item)
+		}
+	default:
+		return nil, fmt.Errorf("expected <rss>, <rdf:RDF> or <feed>, found <%s>", doc.XMLName.Local)
+	}
+	return items, nil
+}
+
+func appendRSS(items []Item, rss []rssItem) []Item {
+	for _, ri := range rss {
+		item := Item{Link: strings.TrimSpace(ri.Link)}
+		for _, enclosure := range ri.Enclosures {
+			if u := strings.TrimSpace(enclosure.URL); u != "" {
+				item.Enclosures = append(item.Enclosures, u)
+			}
+		}
+		items = append(items, item)
+	}
+	return items
+}
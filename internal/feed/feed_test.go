@@ -0,0 +1,57 @@
+package feed
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		document string
+		expected []Item
+	}{
+		{`<?xml version="1.0"?>
+<rss version="2.0"><channel>
+  <title>Podcast</title><link>http://example.com/</link>
+  <item><link>http://example.com/1</link><enclosure url="http://example.com/1.mp3" length="1" type="audio/mpeg"/></item>
+  <item><link> http://example.com/2 </link></item>
+</channel></rss>`, []Item{
+			{Link: "http://example.com/1", Enclosures: []string{"http://example.com/1.mp3"}},
+			{Link: "http://example.com/2"},
+		}},
+		{`<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#" xmlns="http://purl.org/rss/1.0/">
+  <channel><link>http://example.com/</link></channel>
+  <item><link>http://example.com/a</link></item>
+</rdf:RDF>`, []Item{{Link: "http://example.com/a"}}},
+		{`<feed xmlns="http://www.w3.org/2005/Atom">
+  <link href="http://example.com/"/>
+  <entry>
+    <link rel="self" href="http://example.com/a.atom"/>
+    <link href="http://example.com/a"/>
+    <link rel="enclosure" href="/a.pdf"/>
+  </entry>
+  <entry><link rel="alternate" href="http://example.com/b"/></entry>
+</feed>`, []Item{
+			{Link: "http://example.com/a", Enclosures: []string{"/a.pdf"}},
+			{Link: "http://example.com/b"},
+		}},
+		{`<rss><channel></channel></rss>`, []Item{}},
+	}
+	for _, testCase := range testCases {
+		received, err := Parse(strings.NewReader(testCase.document))
+		if err != nil {
+			t.Errorf("%q: %v", testCase.document, err)
+			continue
+		}
+		if !reflect.DeepEqual(received, testCase.expected) {
+			t.Errorf("%q: expected %#v received %#v", testCase.document, testCase.expected, received)
+		}
+	}
+
+	for _, invalid := range []string{"", "<urlset></urlset>", "<rss><channel>"} {
+		if _, err := Parse(strings.NewReader(invalid)); err == nil {
+			t.Errorf("%q expected an error", invalid)
+		}
+	}
+}
@@ -1,8 +1,12 @@
 package fileutil
 
 import (
+	"bytes"
+	"io"
+	"io/ioutil"
 	"os"
 	"os/user"
+	"path/filepath"
 )
 
 // FileOrPathExists returns true/false whether or not the specified path exists
@@ -22,3 +26,125 @@ func GetUserHomeDirectory() (string, error) {
 
 	return usr.HomeDir, err
 }
+
+// WriteFileAtomic writes data to path like ioutil.WriteFile, but through a
+// temporary file in the same directory that is renamed to path once it is
+// complete. Readers of path see either the old or the new content, never a
+// truncated file.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpPath := f.Name()
+
+	if _, err = f.Write(data); err == nil {
+		err = f.Chmod(perm)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+
+	return err
+}
+
+// LinkOrCopy makes dst a hard link to src, or a copy of it where links are
+// not possible, e.g. across file systems. Like with WriteFileAtomic, dst is
+// only replaced once it is complete. linked reports whether dst is a link.
+func LinkOrCopy(src, dst string) (linked bool, err error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), "."+filepath.Base(dst)+".*.tmp")
+	if err != nil {
+		return false, err
+	}
+	tmpPath := tmp.Name()
+	tmp.Close()
+	os.Remove(tmpPath)
+
+	if err = os.Link(src, tmpPath); err == nil {
+		linked = true
+	} else {
+		err = copyFile(src, tmpPath)
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, dst)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+	}
+
+	return linked, err
+}
+
+// copyFile copies the content and the permissions of src to the new file dst
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// OpenAppendLines opens the line based file at path for reading and appending,
+// creating it if needed. An unfinished last line, which a process that crashed
+// while writing it leaves behind, is cut off so that the lines appended next
+// start at the beginning of a line.
+func OpenAppendLines(path string, perm os.FileMode) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = cutUnfinishedLine(f); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// cutUnfinishedLine truncates f after its last newline
+func cutUnfinishedLine(f *os.File) error {
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4096)
+	for end := fi.Size(); end > 0; {
+		start := end - int64(len(buf))
+		if start < 0 {
+			start = 0
+		}
+		n, err := f.ReadAt(buf[:end-start], start)
+		if err != nil {
+			return err
+		}
+		if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+			if start+int64(i)+1 == fi.Size() {
+				return nil
+			}
+			return f.Truncate(start + int64(i) + 1)
+		}
+		end = start
+	}
+	return f.Truncate(0)
+}
@@ -0,0 +1,144 @@
+package fileutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fileutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "file.json")
+	testCases := []string{"first", "second, longer content", ""}
+
+	for _, data := range testCases {
+		if err = WriteFileAtomic(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != data {
+			t.Errorf("expected %q received %q", data, b)
+		}
+	}
+
+	// no temporary files are left behind
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("expected 1 file received %d", len(files))
+	}
+
+	if err = WriteFileAtomic(filepath.Join(dir, "missing", "file"), nil, 0644); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+}
+
+func TestLinkOrCopy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fileutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	src := filepath.Join(dir, "src")
+	if err = ioutil.WriteFile(src, []byte("content"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// an existing file is replaced
+	dst := filepath.Join(dir, "dst")
+	if err = ioutil.WriteFile(dst, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	linked, err := LinkOrCopy(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "content" {
+		t.Errorf("expected %q received %q", "content", b)
+	}
+	srcInfo, _ := os.Stat(src)
+	dstInfo, _ := os.Stat(dst)
+	if linked != os.SameFile(srcInfo, dstInfo) {
+		t.Errorf("expected linked to be %v", os.SameFile(srcInfo, dstInfo))
+	}
+
+	// the fallback copies
+	if err = copyFile(src, filepath.Join(dir, "copy")); err != nil {
+		t.Fatal(err)
+	}
+	if b, err = ioutil.ReadFile(filepath.Join(dir, "copy")); err != nil || string(b) != "content" {
+		t.Errorf("expected %q received %q %v", "content", b, err)
+	}
+
+	if _, err = LinkOrCopy(filepath.Join(dir, "missing"), dst); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 {
+		t.Errorf("expected 3 files received %d", len(files))
+	}
+}
+
+func TestOpenAppendLines(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fileutil")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "lines.ndjson")
+	longLine := strings.Repeat("x", 10000)
+	testCases := []struct {
+		content  string
+		expected string
+	}{
+		{"", "next\n"},
+		{"a\nb\n", "a\nb\nnext\n"},
+		{"a\nb\n{\"unfini", "a\nb\nnext\n"},
+		{"{\"unfini", "next\n"},
+		{"a\n" + longLine, "a\nnext\n"},
+		{longLine + "\n" + longLine, longLine + "\nnext\n"},
+	}
+
+	for _, testCase := range testCases {
+		if err = ioutil.WriteFile(path, []byte(testCase.content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		f, err := OpenAppendLines(path, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = f.WriteString("next\n"); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != testCase.expected {
+			t.Errorf("%q: expected %q received %q", testCase.content, testCase.expected, b)
+		}
+	}
+}
@@ -0,0 +1,443 @@
+// Package ftp downloads files from FTP and FTPS servers through an
+// http.RoundTripper, so that they can be fetched with an http.Client like
+// any other url.
+//
+// GET requests retrieve files with RETR. Range requests are translated to
+// REST, and answered with 206 Partial Content like by an http server. HEAD
+// requests report the size (SIZE) and modification time (MDTM) of a file.
+// Files that do not exist are answered with 404 Not Found.
+package ftp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// url schemes that are handled by Transport
+const (
+	Scheme            = "ftp"   // plain FTP
+	SchemeImplicitTLS = "ftps"  // TLS from the start, port 990 by default
+	SchemeExplicitTLS = "ftpes" // upgraded to TLS with AUTH TLS, port 21 by default
+)
+
+// Schemes are all url schemes handled by Transport
+var Schemes = []string{Scheme, SchemeImplicitTLS, SchemeExplicitTLS}
+
+// Transport is an http.RoundTripper for ftp, ftps and ftpes urls. Every
+// request uses a new control connection. User names and passwords are taken
+// from the url, anonymous is used without one.
+type Transport struct {
+	// Dial opens the control and data connections, net.Dialer is used if
+	// nil
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// TLSConfig is used for ftps and ftpes connections, ServerName is set
+	// from the url if empty
+	TLSConfig *tls.Config
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return nil, fmt.Errorf("ftp: method %s is not supported", req.Method)
+	}
+
+	c, err := t.connect(req.Context(), req.URL)
+	if err != nil {
+		return statusResponse(req, err)
+	}
+
+	filePath := req.URL.Path
+	if strings.HasPrefix(filePath, "/") {
+		// ftp://host/dir/file is relative to the login directory and
+		// ftp://host//dir/file absolute, like in curl
+		filePath = filePath[1:]
+	}
+
+	response, err := c.retrieve(req, filePath)
+	if err != nil {
+		c.quit()
+		return statusResponse(req, err)
+	}
+	return response, nil
+}
+
+// statusResponse answers requests that failed with a reply of the server
+// with the matching http status, other errors are returned as they are
+func statusResponse(req *http.Request, err error) (*http.Response, error) {
+	var replyErr *textproto.Error
+	if !errors.As(err, &replyErr) {
+		return nil, err
+	}
+
+	status := http.StatusBadGateway
+	switch replyErr.Code {
+	case 550:
+		status = http.StatusNotFound
+	case 530, 532:
+		status = http.StatusUnauthorized
+	case 421, 425, 426, 450, 451:
+		status = http.StatusServiceUnavailable
+	}
+
+	body := fmt.Sprintf("%d %s\n", replyErr.Code, replyErr.Msg)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"text/plain"}},
+		Body:          ioutil.NopCloser(strings.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// conn is a logged in control connection
+type conn struct {
+	t      *Transport
+	ctx    context.Context
+	host   string
+	text   *textproto.Conn
+	raw    net.Conn
+	tlsCfg *tls.Config // set when the data connections must use TLS
+}
+
+func (t *Transport) dial(ctx context.Context, addr string) (net.Conn, error) {
+	if t.Dial != nil {
+		return t.Dial(ctx, "tcp", addr)
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", addr)
+}
+
+func (t *Transport) tlsConfig(host string) *tls.Config {
+	cfg := &tls.Config{}
+	if t.TLSConfig != nil {
+		cfg = t.TLSConfig.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName = host
+	}
+	// many servers require the data connections to resume the TLS session
+	// of the control connection
+	if cfg.ClientSessionCache == nil {
+		cfg.ClientSessionCache = tls.NewLRUClientSessionCache(4)
+	}
+	return cfg
+}
+
+// connect opens a control connection and logs in
+func (t *Transport) connect(ctx context.Context, u *url.URL) (*conn, error) {
+	port := u.Port()
+	if port == "" {
+		port = "21"
+		if u.Scheme == SchemeImplicitTLS {
+			port = "990"
+		}
+	}
+
+	raw, err := t.dial(ctx, net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return nil, err
+	}
+
+	c := &conn{t: t, ctx: ctx, host: u.Hostname(), raw: raw}
+	if u.Scheme == SchemeImplicitTLS {
+		c.tlsCfg = t.tlsConfig(c.host)
+		c.raw = tls.Client(raw, c.tlsCfg)
+	}
+	c.text = textproto.NewConn(c.raw)
+
+	if err = c.login(u); err != nil {
+		c.raw.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *conn) cmd(expectCode int, format string, args ...interface{}) (int, string, error) {
+	if _, err := c.text.Cmd(format, args...); err != nil {
+		return 0, "", err
+	}
+	return c.text.ReadResponse(expectCode)
+}
+
+func (c *conn) login(u *url.URL) error {
+	if _, _, err := c.text.ReadResponse(220); err != nil {
+		return err
+	}
+
+	if u.Scheme == SchemeExplicitTLS {
+		if _, _, err := c.cmd(234, "AUTH TLS"); err != nil {
+			return err
+		}
+		c.tlsCfg = c.t.tlsConfig(c.host)
+		c.raw = tls.Client(c.raw, c.tlsCfg)
+		c.text = textproto.NewConn(c.raw)
+	}
+
+	user, password := "anonymous", "anonymous@"
+	if u.User != nil {
+		user = u.User.Username()
+		password, _ = u.User.Password()
+	}
+
+	code, msg, err := c.cmd(0, "USER %s", user)
+	if err != nil {
+		return err
+	}
+	switch code {
+	case 230:
+	case 331, 332:
+		if _, _, err = c.cmd(230, "PASS %s", password); err != nil {
+			return err
+		}
+	default:
+		return &textproto.Error{Code: code, Msg: msg}
+	}
+
+	if c.tlsCfg != nil {
+		if _, _, err = c.cmd(200, "PBSZ 0"); err != nil {
+			return err
+		}
+		if _, _, err = c.cmd(200, "PROT P"); err != nil {
+			return err
+		}
+	}
+
+	_, _, err = c.cmd(200, "TYPE I")
+	return err
+}
+
+// quit ends the session and closes the control connection
+func (c *conn) quit() {
+	_ = c.raw.SetDeadline(time.Now().Add(5 * time.Second))
+	_, _, _ = c.cmd(221, "QUIT")
+	c.raw.Close()
+}
+
+// size returns the size of a file, or -1 if the server does not know it
+func (c *conn) size(filePath string) (int64, error) {
+	_, msg, err := c.cmd(213, "SIZE %s", filePath)
+	if err != nil {
+		var replyErr *textproto.Error
+		if errors.As(err, &replyErr) && replyErr.Code == 550 {
+			return 0, err
+		}
+		return -1, nil
+	}
+	return strconv.ParseInt(strings.TrimSpace(msg), 10, 64)
+}
+
+// modTime returns the modification time of a file, if the server knows it
+func (c *conn) modTime(filePath string) (time.Time, bool) {
+	_, msg, err := c.cmd(213, "MDTM %s", filePath)
+	if err != nil {
+		return time.Time{}, false
+	}
+	t, err := time.Parse("20060102150405", strings.TrimSpace(msg))
+	return t, err == nil
+}
+
+// dataConn opens a passive data connection
+func (c *conn) dataConn() (net.Conn, error) {
+	port, err := c.passivePort()
+	if err != nil {
+		return nil, err
+	}
+
+	// the address in PASV replies is ignored, it is often a private one
+	// behind NAT, like curl does
+	raw, err := c.t.dial(c.ctx, net.JoinHostPort(c.host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, err
+	}
+	if c.tlsCfg != nil {
+		return tls.Client(raw, c.tlsCfg), nil
+	}
+	return raw, nil
+}
+
+func (c *conn) passivePort() (int, error) {
+	// 229 Entering Extended Passive Mode (|||port|)
+	if _, msg, err := c.cmd(229, "EPSV"); err == nil {
+		start, end := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)")
+		if start >= 0 && end > start+4 {
+			return strconv.Atoi(msg[start+4 : end])
+		}
+		return 0, fmt.Errorf("ftp: invalid EPSV reply %q", msg)
+	}
+
+	// 227 Entering Passive Mode (h1,h2,h3,h4,p1,p2)
+	_, msg, err := c.cmd(227, "PASV")
+	if err != nil {
+		return 0, err
+	}
+	start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+	if start < 0 || end < start {
+		return 0, fmt.Errorf("ftp: invalid PASV reply %q", msg)
+	}
+	fields := strings.Split(msg[start+1:end], ",")
+	if len(fields) != 6 {
+		return 0, fmt.Errorf("ftp: invalid PASV reply %q", msg)
+	}
+	p1, err1 := strconv.Atoi(strings.TrimSpace(fields[4]))
+	p2, err2 := strconv.Atoi(strings.TrimSpace(fields[5]))
+	if err1 != nil || err2 != nil {
+		return 0, fmt.Errorf("ftp: invalid PASV reply %q", msg)
+	}
+	return p1<<8 | p2, nil
+}
+
+// parseRange parses the single ranges "bytes=start-" and "bytes=start-end",
+// end is -1 if it is open
+func parseRange(s string) (start, end int64, ok bool) {
+	if !strings.HasPrefix(s, "bytes=") || strings.Contains(s, ",") {
+		return 0, 0, false
+	}
+	bounds := strings.SplitN(strings.TrimPrefix(s, "bytes="), "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, false
+	}
+
+	start, err := strconv.ParseInt(bounds[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	end = -1
+	if bounds[1] != "" {
+		if end, err = strconv.ParseInt(bounds[1], 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+	}
+	return start, end, true
+}
+
+// retrieve answers a GET or HEAD request for filePath
+func (c *conn) retrieve(req *http.Request, filePath string) (*http.Response, error) {
+	size, err := c.size(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		ContentLength: size,
+		Request:       req,
+	}
+	if size >= 0 {
+		response.Header.Set("Accept-Ranges", "bytes")
+		response.Header.Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+	if t, ok := c.modTime(filePath); ok {
+		response.Header.Set("Last-Modified", t.UTC().Format(http.TimeFormat))
+	}
+
+	if req.Method == http.MethodHead {
+		c.quit()
+		response.Body = http.NoBody
+		return response, nil
+	}
+
+	start, end, ranged := parseRange(req.Header.Get("Range"))
+	if ranged && size >= 0 {
+		if start >= size {
+			c.quit()
+			response.Status, response.StatusCode = "416 Requested Range Not Satisfiable", http.StatusRequestedRangeNotSatisfiable
+			response.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			response.Header.Del("Content-Length")
+			response.ContentLength, response.Body = 0, http.NoBody
+			return response, nil
+		}
+		if end < 0 || end >= size {
+			end = size - 1
+		}
+	}
+
+	data, err := c.dataConn()
+	if err != nil {
+		return nil, err
+	}
+
+	// without SIZE or REST the whole file is sent
+	if ranged && size >= 0 && start > 0 {
+		if _, _, err = c.cmd(350, "REST %d", start); err != nil {
+			ranged = false
+		}
+	}
+
+	if _, _, err = c.cmd(1, "RETR %s", filePath); err != nil {
+		data.Close()
+		return nil, err
+	}
+
+	body := &dataBody{conn: c, data: data, reader: data}
+	if ranged && size >= 0 {
+		n := end - start + 1
+		body.reader = io.LimitReader(data, n)
+		body.partial = end < size-1
+		response.Status, response.StatusCode = "206 Partial Content", http.StatusPartialContent
+		response.ContentLength = n
+		response.Header.Set("Content-Length", strconv.FormatInt(n, 10))
+		response.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	}
+	response.Body = body
+
+	return response, nil
+}
+
+// dataBody is the body of a response, it ends the session when it is closed
+type dataBody struct {
+	conn    *conn
+	data    net.Conn
+	reader  io.Reader
+	partial bool // only a part of the file is read
+	eof     bool
+}
+
+// Read reads from the data connection. At the end of the file the final
+// reply of the server is checked, so that aborted transfers are not
+// mistaken for complete ones.
+func (b *dataBody) Read(p []byte) (int, error) {
+	if b.eof {
+		return 0, io.EOF
+	}
+
+	n, err := b.reader.Read(p)
+	if err == io.EOF {
+		b.eof = true
+		if !b.partial {
+			// 226 Transfer complete
+			_ = b.conn.raw.SetDeadline(time.Now().Add(30 * time.Second))
+			if _, _, replyErr := b.conn.text.ReadResponse(2); replyErr != nil {
+				return n, replyErr
+			}
+		}
+	}
+	return n, err
+}
+
+func (b *dataBody) Close() error {
+	err := b.data.Close()
+	b.conn.quit()
+	return err
+}
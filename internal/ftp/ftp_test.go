@@ -0,0 +1,165 @@
+package ftp
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// serveFTP runs a minimal passive mode FTP server for the given files
+func serveFTP(t *testing.T, files map[string]string) (addr string, stop func()) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go handleFTP(c, files)
+		}
+	}()
+
+	return ln.Addr().String(), func() { ln.Close() }
+}
+
+func handleFTP(c net.Conn, files map[string]string) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	reply := func(format string, args ...interface{}) { fmt.Fprintf(c, format+"\r\n", args...) }
+
+	var dataLn net.Listener
+	var rest int64
+	reply("220 test server")
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.SplitN(strings.TrimSpace(line), " ", 2)
+		arg := ""
+		if len(fields) > 1 {
+			arg = fields[1]
+		}
+
+		switch fields[0] {
+		case "USER":
+			if arg == "anonymous" || arg == "bob" {
+				reply("331 password please")
+			} else {
+				reply("530 unknown user")
+			}
+		case "PASS":
+			reply("230 logged in")
+		case "TYPE":
+			reply("200 ok")
+		case "SIZE":
+			if content, ok := files[arg]; ok {
+				reply("213 %d", len(content))
+			} else {
+				reply("550 no such file")
+			}
+		case "MDTM":
+			reply("213 20200102030405")
+		case "EPSV":
+			reply("500 not supported")
+		case "PASV":
+			dataLn, _ = net.Listen("tcp", "127.0.0.1:0")
+			port := dataLn.Addr().(*net.TCPAddr).Port
+			// a private address that must be ignored
+			reply("227 Entering Passive Mode (10,0,0,1,%d,%d)", port>>8, port&0xff)
+		case "REST":
+			rest, _ = strconv.ParseInt(arg, 10, 64)
+			reply("350 restarting")
+		case "RETR":
+			content, ok := files[arg]
+			if !ok {
+				reply("550 no such file")
+				continue
+			}
+			reply("150 sending")
+			data, err := dataLn.Accept()
+			dataLn.Close()
+			if err != nil {
+				return
+			}
+			_, _ = data.Write([]byte(content[rest:]))
+			data.Close()
+			rest = 0
+			reply("226 done")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestTransport(t *testing.T) {
+	addr, stop := serveFTP(t, map[string]string{"dir/file.txt": "0123456789"})
+	defer stop()
+
+	client := &http.Client{Transport: &Transport{}}
+
+	testCases := []struct {
+		method         string
+		url            string
+		rangeHeader    string
+		expectedStatus int
+		expectedBody   string
+		expectedRange  string
+	}{
+		{"GET", "ftp://" + addr + "/dir/file.txt", "", 200, "0123456789", ""},
+		{"GET", "ftp://bob:secret@" + addr + "/dir/file.txt", "bytes=4-", 206, "456789", "bytes 4-9/10"},
+		{"GET", "ftp://" + addr + "/dir/file.txt", "bytes=2-5", 206, "2345", "bytes 2-5/10"},
+		{"GET", "ftp://" + addr + "/dir/file.txt", "bytes=10-", 416, "", "bytes */10"},
+		{"GET", "ftp://" + addr + "/missing", "", 404, "550 no such file\n", ""},
+		{"GET", "ftp://eve@" + addr + "/dir/file.txt", "", 401, "530 unknown user\n", ""},
+		{"HEAD", "ftp://" + addr + "/dir/file.txt", "", 200, "", ""},
+	}
+
+	for _, testCase := range testCases {
+		req, _ := http.NewRequest(testCase.method, testCase.url, nil)
+		if testCase.rangeHeader != "" {
+			req.Header.Set("Range", testCase.rangeHeader)
+		}
+
+		response, err := client.Do(req)
+		if err != nil {
+			t.Errorf("%s %s: %v", testCase.method, testCase.url, err)
+			continue
+		}
+		body, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			t.Errorf("%s %s: %v", testCase.method, testCase.url, err)
+		}
+
+		if response.StatusCode != testCase.expectedStatus || string(body) != testCase.expectedBody ||
+			response.Header.Get("Content-Range") != testCase.expectedRange {
+			t.Errorf("%s %s range=%s expected %d %q %q received %d %q %q", testCase.method, testCase.url, testCase.rangeHeader,
+				testCase.expectedStatus, testCase.expectedBody, testCase.expectedRange,
+				response.StatusCode, body, response.Header.Get("Content-Range"))
+		}
+	}
+
+	req, _ := http.NewRequest("HEAD", "ftp://"+addr+"/dir/file.txt", nil)
+	response, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.Header.Get("Content-Length") != "10" || response.Header.Get("Accept-Ranges") != "bytes" ||
+		response.Header.Get("Last-Modified") != "Thu, 02 Jan 2020 03:04:05 GMT" {
+		t.Errorf("unexpected HEAD headers %v", response.Header)
+	}
+}
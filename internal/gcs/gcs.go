@@ -0,0 +1,332 @@
+// Package gcs downloads objects from Google Cloud Storage through an
+// http.RoundTripper, so that gs://bucket/object urls can be fetched with an
+// http.Client like any other url.
+//
+// Requests carry the OAuth2 access token of the environment when there is
+// one, and are sent anonymously otherwise, which works for public buckets.
+// Range and HEAD requests are passed on to Cloud Storage unchanged, and Put
+// uploads files.
+package gcs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scheme is the url scheme handled by Transport
+const Scheme = "gs"
+
+// DefaultEndpoint is the Cloud Storage api
+const DefaultEndpoint = "https://storage.googleapis.com"
+
+// ErrNoBucket is returned for urls without a bucket, like gs:///object
+var ErrNoBucket = errors.New("gcs: url has no bucket")
+
+// Transport is an http.RoundTripper for gs urls
+type Transport struct {
+	// Token returns the access token that is sent with every request,
+	// requests are anonymous if Token is nil or returns ""
+	Token func(ctx context.Context) (string, error)
+
+	// Endpoint of the api, DefaultEndpoint if empty
+	Endpoint string
+
+	// Transport sends the https requests, http.DefaultTransport is used if
+	// nil
+	Transport http.RoundTripper
+}
+
+// FromEnv returns a Transport that uses the ambient credentials of an
+// AmbientToken and the endpoint of STORAGE_EMULATOR_HOST, if it is set
+func FromEnv() *Transport {
+	t := &Transport{Token: (&AmbientToken{}).Token}
+
+	if host := os.Getenv("STORAGE_EMULATOR_HOST"); host != "" {
+		if !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		t.Endpoint = host
+	}
+
+	return t
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == "" {
+		return nil, ErrNoBucket
+	}
+
+	u, err := url.Parse(t.endpoint() + "/" + req.URL.Host + "/" + escapeObject(strings.TrimPrefix(req.URL.Path, "/"), false))
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := t.newRequest(req.Context(), req.Method, u, req.Header)
+	if err != nil {
+		return nil, err
+	}
+
+	return t.transport().RoundTrip(out)
+}
+
+// Put uploads size bytes of r as object in bucket
+func (t *Transport) Put(ctx context.Context, bucket, object string, r io.Reader, size int64) error {
+	u, err := url.Parse(t.endpoint() + "/" + bucket + "/" + escapeObject(object, false))
+	if err != nil {
+		return err
+	}
+	req, err := t.newRequest(ctx, http.MethodPut, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Body = ioutil.NopCloser(r)
+	req.ContentLength = size
+
+	response, err := t.transport().RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("gcs: uploading %s/%s: %s", bucket, object, errorMessage(response.Status, b))
+	}
+
+	return nil
+}
+
+// List returns the names of all objects in bucket that start with prefix.
+// Names ending in "/", which consoles create as folders, are left out.
+func (t *Transport) List(ctx context.Context, bucket, prefix string) ([]string, error) {
+	var names []string
+	pageToken := ""
+
+	for {
+		query := url.Values{"prefix": {prefix}, "fields": {"items(name),nextPageToken"}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+
+		u, err := url.Parse(t.endpoint() + "/storage/v1/b/" + escapeObject(bucket, true) + "/o?" + query.Encode())
+		if err != nil {
+			return nil, err
+		}
+		req, err := t.newRequest(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		response, err := t.transport().RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		b, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if response.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("gcs: listing %s/%s: %s", bucket, prefix, errorMessage(response.Status, b))
+		}
+
+		var result struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if err = json.Unmarshal(b, &result); err != nil {
+			return nil, fmt.Errorf("gcs: listing %s/%s: %v", bucket, prefix, err)
+		}
+
+		for _, item := range result.Items {
+			if !strings.HasSuffix(item.Name, "/") {
+				names = append(names, item.Name)
+			}
+		}
+
+		if result.NextPageToken == "" {
+			return names, nil
+		}
+		pageToken = result.NextPageToken
+	}
+}
+
+// errorMessage returns the message of a json error response, or status if
+// body isn't one
+func errorMessage(status string, body []byte) string {
+	var e struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &e) != nil || e.Error.Message == "" {
+		return status
+	}
+	return e.Error.Message
+}
+
+func (t *Transport) endpoint() string {
+	if t.Endpoint == "" {
+		return DefaultEndpoint
+	}
+	return strings.TrimSuffix(t.Endpoint, "/")
+}
+
+func (t *Transport) transport() http.RoundTripper {
+	if t.Transport == nil {
+		return http.DefaultTransport
+	}
+	return t.Transport
+}
+
+// newRequest returns a request for u with header and the access token
+func (t *Transport) newRequest(ctx context.Context, method string, u *url.URL, header http.Header) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if header != nil {
+		req.Header = header.Clone()
+	}
+
+	if t.Token != nil {
+		token, err := t.Token(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	}
+
+	return req, nil
+}
+
+// escapeObject percent-encodes an object name for a url path, keeping "/"
+// unless slash is set
+func escapeObject(name string, slash bool) string {
+	parts := strings.Split(name, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	if slash {
+		return strings.Join(parts, "%2F")
+	}
+	return strings.Join(parts, "/")
+}
+
+// AmbientToken finds the access token of the environment, in this order:
+//
+//   - the GOOGLE_OAUTH_ACCESS_TOKEN environment variable
+//   - gcloud auth print-access-token, if gcloud is installed
+//   - the metadata server of Compute Engine, Cloud Run and GKE
+//
+// Tokens are cached until shortly before they expire. Without any of them
+// requests are sent anonymously.
+type AmbientToken struct {
+	// MetadataHost is the address of the metadata server, GCE_METADATA_HOST
+	// or metadata.google.internal if empty
+	MetadataHost string
+
+	lock    sync.Mutex
+	token   string
+	expiry  time.Time
+	noToken bool // none of the sources has a token
+}
+
+// tokenLifetime is how long a token of gcloud is used, they are valid for
+// an hour
+const tokenLifetime = 45 * time.Minute
+
+// Token returns the current access token, or "" if there is none
+func (a *AmbientToken) Token(ctx context.Context) (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.noToken {
+		return "", nil
+	}
+	if a.token != "" && time.Now().Before(a.expiry) {
+		return a.token, nil
+	}
+
+	if _, err := exec.LookPath("gcloud"); err == nil {
+		out, err := exec.CommandContext(ctx, "gcloud", "auth", "print-access-token").Output()
+		if token := strings.TrimSpace(string(out)); err == nil && token != "" {
+			a.token, a.expiry = token, time.Now().Add(tokenLifetime)
+			return a.token, nil
+		}
+	}
+
+	token, expiresIn, err := a.metadataToken(ctx)
+	if err != nil {
+		// not running on Google Cloud
+		a.noToken = true
+		return "", nil
+	}
+
+	// refresh a minute before the token expires
+	a.token, a.expiry = token, time.Now().Add(expiresIn-time.Minute)
+	return a.token, nil
+}
+
+// metadataToken asks the metadata server for a token of the default service
+// account
+func (a *AmbientToken) metadataToken(ctx context.Context) (string, time.Duration, error) {
+	host := a.MetadataHost
+	if host == "" {
+		host = os.Getenv("GCE_METADATA_HOST")
+	}
+	if host == "" {
+		host = "metadata.google.internal"
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("gcs: metadata server: %s", response.Status)
+	}
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return "", 0, err
+	}
+
+	return result.AccessToken, time.Duration(result.ExpiresIn) * time.Second, nil
+}
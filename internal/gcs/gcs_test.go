@@ -0,0 +1,166 @@
+package gcs
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTransport(t *testing.T) {
+	objects := map[string]string{"data/a.txt": "aaa", "data/b c.txt": "bbb", "data/sub/": "", "data/sub/c.txt": "ccc", "other.txt": "ooo"}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error":{"code":401,"message":"Anonymous caller does not have storage.objects.list access"}}`))
+			return
+		}
+
+		if r.URL.Path == "/storage/v1/b/bucket/o" {
+			var names []string
+			for name := range objects {
+				if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+					names = append(names, name)
+				}
+			}
+			sort.Strings(names)
+
+			// two names per page
+			start, _ := strconv.Atoi(r.URL.Query().Get("pageToken"))
+			var result struct {
+				Items []struct {
+					Name string `json:"name"`
+				} `json:"items"`
+				NextPageToken string `json:"nextPageToken,omitempty"`
+			}
+			for i := start; i < len(names) && i < start+2; i++ {
+				result.Items = append(result.Items, struct {
+					Name string `json:"name"`
+				}{names[i]})
+			}
+			if start+2 < len(names) {
+				result.NextPageToken = strconv.Itoa(start + 2)
+			}
+			json.NewEncoder(w).Encode(result)
+			return
+		}
+
+		name := strings.TrimPrefix(r.URL.Path, "/bucket/")
+		body, ok := objects[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		http.ServeContent(w, r, name, time.Time{}, strings.NewReader(body))
+	}))
+	defer server.Close()
+
+	tr := &Transport{
+		Token:    func(context.Context) (string, error) { return "tok", nil },
+		Endpoint: server.URL,
+	}
+	client := &http.Client{Transport: tr}
+
+	tests := []struct {
+		url    string
+		rng    string
+		status int
+		body   string
+	}{
+		{"gs://bucket/data/a.txt", "", http.StatusOK, "aaa"},
+		{"gs://bucket/data/b%20c.txt", "", http.StatusOK, "bbb"},
+		{"gs://bucket/data/a.txt", "bytes=1-", http.StatusPartialContent, "aa"},
+		{"gs://bucket/missing.txt", "", http.StatusNotFound, ""},
+	}
+
+	for _, tt := range tests {
+		req, err := http.NewRequest("GET", tt.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tt.rng != "" {
+			req.Header.Set("Range", tt.rng)
+		}
+		response, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if response.StatusCode != tt.status || tt.status < 300 && string(body) != tt.body {
+			t.Errorf("%s %s: got %d %q, want %d %q", tt.url, tt.rng, response.StatusCode, body, tt.status, tt.body)
+		}
+	}
+
+	names, err := tr.List(context.Background(), "bucket", "data/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"data/a.txt", "data/b c.txt", "data/sub/c.txt"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("List: got %q, want %q", names, want)
+	}
+
+	anonymous := &Transport{Endpoint: server.URL}
+	_, err = anonymous.List(context.Background(), "bucket", "")
+	if err == nil || !strings.Contains(err.Error(), "Anonymous caller") {
+		t.Errorf("List without a token: got error %v", err)
+	}
+}
+
+func TestMetadataToken(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"access_token":"meta","expires_in":3599,"token_type":"Bearer"}`))
+	}))
+	defer server.Close()
+
+	a := &AmbientToken{MetadataHost: strings.TrimPrefix(server.URL, "http://")}
+	token, expiresIn, err := a.metadataToken(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "meta" || expiresIn != 3599*time.Second {
+		t.Errorf("got %q %v", token, expiresIn)
+	}
+}
+
+func TestPut(t *testing.T) {
+	objects := map[string]string{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut || r.Header.Get("Authorization") != "Bearer tok" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		objects[r.URL.Path] = string(b)
+	}))
+	defer server.Close()
+
+	tr := &Transport{
+		Token:    func(context.Context) (string, error) { return "tok", nil },
+		Endpoint: server.URL,
+	}
+	if err := tr.Put(context.Background(), "bucket", "dir/a b.txt", strings.NewReader("data"), 4); err != nil {
+		t.Fatal(err)
+	}
+	if got := objects["/bucket/dir/a b.txt"]; got != "data" {
+		t.Errorf("got %q, want %q", got, "data")
+	}
+
+	anonymous := &Transport{Endpoint: server.URL}
+	if err := anonymous.Put(context.Background(), "bucket", "x", strings.NewReader(""), 0); err == nil {
+		t.Error("expected an error without a token")
+	}
+}
@@ -0,0 +1,166 @@
+// Package h2pool spreads the requests to HTTP/2 servers over a few
+// connections that carry a limited number of parallel streams each
+package h2pool
+
+import (
+	"io"
+	"net/http"
+	"sync"
+)
+
+// Transport sends the https requests to a host that answered over HTTP/2
+// over connections that carry at most Streams requests at once. A request
+// that finds all connections to its host busy opens another one, so n
+// parallel requests use ceil(n/Streams) connections. The other requests go
+// to Transport.
+//
+// Until the first response of a host tells its protocol, and until the
+// first response over a new connection shows that it's established, the
+// further requests wait instead of dialing connections of their own.
+type Transport struct {
+	// Transport sends the requests that aren't spread, and is the first
+	// connection pool of the spread ones. Every further pool is a clone of
+	// it with connections of its own.
+	Transport *http.Transport
+
+	// Streams is the maximum number of requests over one connection
+	Streams int
+
+	lock      sync.Mutex
+	cond      *sync.Cond // signaled when a stream is freed or a response arrived
+	pools     []*pool
+	protocols map[string]int  // major HTTP version by host, once it answered
+	probing   map[string]bool // hosts with a first request in flight
+}
+
+type pool struct {
+	transport *http.Transport
+	active    map[string]int  // requests in flight by host
+	connected map[string]bool // hosts the pool has a connection to
+}
+
+func newPool(transport *http.Transport) *pool {
+	return &pool{transport: transport, active: map[string]int{}, connected: map[string]bool{}}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "https" || t.Streams < 1 {
+		return t.Transport.RoundTrip(req)
+	}
+
+	host := req.URL.Host
+	pl := t.acquire(host)
+	response, err := pl.transport.RoundTrip(req)
+
+	t.lock.Lock()
+	delete(t.probing, host)
+	if err == nil {
+		t.protocols[host] = response.ProtoMajor
+		pl.connected[host] = true
+	}
+	t.cond.Broadcast()
+	t.lock.Unlock()
+
+	if err != nil {
+		t.release(pl, host)
+		return nil, err
+	}
+	response.Body = &releasingBody{ReadCloser: response.Body, release: func() { t.release(pl, host) }}
+	return response, nil
+}
+
+// acquire returns the pool that sends the next request to host, the first
+// one with a free stream
+func (t *Transport) acquire(host string) *pool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.pools == nil {
+		t.pools = []*pool{newPool(t.Transport)}
+		t.protocols = map[string]int{}
+		t.probing = map[string]bool{}
+		t.cond = sync.NewCond(&t.lock)
+	}
+
+	for {
+		chosen := t.choose(host)
+		if chosen != nil {
+			chosen.active[host]++
+			return chosen
+		}
+		t.cond.Wait()
+	}
+}
+
+// choose returns the pool for the next request to host, or nil if the
+// request has to wait
+func (t *Transport) choose(host string) *pool {
+	switch t.protocols[host] {
+	case 0:
+		if t.probing[host] {
+			return nil
+		}
+		t.probing[host] = true
+		return t.pools[0]
+	case 2:
+	default:
+		// HTTP/1 needs a connection per request anyway
+		return t.pools[0]
+	}
+
+	connecting := false
+	for _, pl := range t.pools {
+		if pl.active[host] >= t.Streams {
+			continue
+		}
+		if pl.connected[host] || pl.active[host] == 0 {
+			return pl
+		}
+		connecting = true
+	}
+	if connecting {
+		return nil
+	}
+
+	pl := newPool(t.Transport.Clone())
+	t.pools = append(t.pools, pl)
+	return pl
+}
+
+func (t *Transport) release(pl *pool, host string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	pl.active[host]--
+	if pl.active[host] == 0 {
+		delete(pl.active, host)
+	}
+	t.cond.Broadcast()
+}
+
+// releasingBody frees the stream of its request when it's closed
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// CloseIdleConnections closes the idle connections of all pools
+func (t *Transport) CloseIdleConnections() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.Transport.CloseIdleConnections()
+	for i, pl := range t.pools {
+		if i > 0 {
+			pl.transport.CloseIdleConnections()
+		}
+	}
+}
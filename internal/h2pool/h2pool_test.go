@@ -0,0 +1,86 @@
+package h2pool
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTransport(t *testing.T) {
+	var conns, inFlight int32
+	release := make(chan struct{})
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the response headers arrive at once, the body waits
+		if r.URL.Path == "/wait" {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			atomic.AddInt32(&inFlight, 1)
+			<-release
+		}
+		w.Write([]byte(r.Proto))
+	}))
+	server.EnableHTTP2 = true
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.StartTLS()
+	defer server.Close()
+
+	transport := &Transport{Transport: server.Client().Transport.(*http.Transport), Streams: 3}
+	client := &http.Client{Transport: transport}
+	get := func(path string) error {
+		response, err := client.Get(server.URL + path)
+		if err != nil {
+			return err
+		}
+		defer response.Body.Close()
+		_, err = ioutil.ReadAll(response.Body)
+		return err
+	}
+
+	// the first response tells that the server speaks HTTP/2
+	if err := get("/"); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := get("/wait"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	for deadline := time.Now().Add(5 * time.Second); atomic.LoadInt32(&inFlight) < 10; {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected 10 requests in flight received %d", atomic.LoadInt32(&inFlight))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	close(release)
+	wg.Wait()
+
+	// 3 streams on the first connection and 7 on three more
+	if n := atomic.LoadInt32(&conns); n != 4 {
+		t.Errorf("expected 4 connections received %d", n)
+	}
+
+	// the streams were freed, the next requests fit onto the first connection
+	for i := 0; i < 3; i++ {
+		if err := get("/"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := atomic.LoadInt32(&conns); n != 4 {
+		t.Errorf("expected 4 connections received %d", n)
+	}
+}
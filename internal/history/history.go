@@ -0,0 +1,180 @@
+// Package history remembers where the files of urls were saved, so that
+// later runs can reuse them instead of downloading them again
+package history
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is the file a url was saved as
+type Entry struct {
+	Path    string    // absolute path of the file
+	Size    int64     // size of the file when it was saved
+	ModTime time.Time // modification time of the file when it was saved
+}
+
+// Unchanged reports whether the file of the entry still exists as it was
+// saved
+func (e Entry) Unchanged() bool {
+	fi, err := os.Stat(e.Path)
+	return err == nil && fi.Mode().IsRegular() && fi.Size() == e.Size && fi.ModTime().Equal(e.ModTime)
+}
+
+// History maps urls to the files they were saved as, stored in a file. Every
+// change is appended to the file as a line
+// "url<TAB>size<TAB>modification time in unix nanoseconds<TAB>path", a size
+// of -1 removes the url again. The file is compacted when it is opened. A
+// History is safe for concurrent use.
+type History struct {
+	lock    sync.Mutex
+	entries map[string]Entry
+	file    *os.File
+}
+
+// Open loads the history stored in path
+func Open(path string) (*History, error) {
+	h := &History{entries: make(map[string]Entry)}
+
+	if err := h.load(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// rewrite the file with the current entries
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	for url, entry := range h.entries {
+		if _, err = fmt.Fprint(w, line(url, entry)); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err = f.Close(); err != nil {
+		return nil, err
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return nil, err
+	}
+
+	if h.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+func (h *History) load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 4)
+		if len(fields) != 4 {
+			continue
+		}
+
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		nsec, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if size < 0 {
+			delete(h.entries, fields[0])
+			continue
+		}
+		h.entries[fields[0]] = Entry{Path: fields[3], Size: size, ModTime: time.Unix(0, nsec)}
+	}
+
+	return scanner.Err()
+}
+
+// line formats the line of url and entry in the file of a History
+func line(url string, entry Entry) string {
+	return fmt.Sprintf("%s\t%d\t%d\t%s\n", url, entry.Size, entry.ModTime.UnixNano(), entry.Path)
+}
+
+// Get returns the entry of url
+func (h *History) Get(url string) (Entry, bool) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	entry, ok := h.entries[url]
+	return entry, ok
+}
+
+// Put records that url was saved as the file at path, which must be absolute
+func (h *History) Put(url, path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return h.append(url, Entry{Path: path, Size: fi.Size(), ModTime: fi.ModTime()})
+}
+
+// Delete removes url from the history
+func (h *History) Delete(url string) error {
+	h.lock.Lock()
+	_, ok := h.entries[url]
+	h.lock.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return h.append(url, Entry{Size: -1, ModTime: time.Unix(0, 0)})
+}
+
+func (h *History) append(url string, entry Entry) error {
+	if strings.ContainsAny(url, "\t\n") || strings.Contains(entry.Path, "\n") {
+		return fmt.Errorf("history: url %q or path %q contains a tab or newline", url, entry.Path)
+	}
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if entry.Size < 0 {
+		delete(h.entries, url)
+	} else {
+		h.entries[url] = entry
+	}
+
+	_, err := fmt.Fprint(h.file, line(url, entry))
+	return err
+}
+
+// Len returns the number of urls in the history
+func (h *History) Len() int {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return len(h.entries)
+}
+
+// Close closes the file of the history
+func (h *History) Close() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return h.file.Close()
+}
@@ -0,0 +1,81 @@
+package history
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "history.tsv")
+
+	file := filepath.Join(dir, "file.bin")
+	if err = ioutil.WriteFile(file, []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = h.Put("http://a/file.bin", file); err != nil {
+		t.Fatal(err)
+	}
+	if err = h.Put("http://b/file.bin", file); err != nil {
+		t.Fatal(err)
+	}
+	if err = h.Delete("http://b/file.bin"); err != nil {
+		t.Fatal(err)
+	}
+	if err = h.Put("http://missing", filepath.Join(dir, "missing")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+	if err = h.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopened, with garbage appended
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteString("broken line\nhttp://c\tx\t1\t/c\n"); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if h, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	if h.Len() != 1 {
+		t.Errorf("expected 1 entry received %d", h.Len())
+	}
+	entry, ok := h.Get("http://a/file.bin")
+	if !ok || entry.Path != file || entry.Size != 7 {
+		t.Fatalf("expected %s of 7 bytes received %v %v", file, entry, ok)
+	}
+	if !entry.Unchanged() {
+		t.Error("expected the file to be unchanged")
+	}
+	if _, ok = h.Get("http://b/file.bin"); ok {
+		t.Error("expected http://b/file.bin to be removed")
+	}
+
+	// a changed file is no longer the one of the url
+	later := time.Now().Add(time.Hour)
+	if err = os.Chtimes(file, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Unchanged() {
+		t.Error("expected the file to be changed")
+	}
+}
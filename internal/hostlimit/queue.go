@@ -0,0 +1,374 @@
+package hostlimit
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// why none of the pending jobs of a host can start, see HostState
+const (
+	BlockedMax   = "max-per-host"   // the host has MaxPerHost jobs in flight
+	BlockedDelay = "delay-per-host" // the host was contacted less than DelayPerHost ago
+)
+
+// HostState describes a host with pending jobs or jobs in flight
+type HostState struct {
+	Host    string
+	Pending int
+	Active  int
+	Blocked string    // BlockedMax, BlockedDelay or "" if a job can start
+	Until   time.Time // end of the delay of BlockedDelay
+}
+
+// Limit overrides the MaxPerHost and DelayPerHost of the queue for a host,
+// zero fields keep those of the queue
+type Limit struct {
+	MaxPerHost   int
+	DelayPerHost time.Duration
+}
+
+// Queue holds pending jobs grouped by host and hands them out round-robin,
+// skipping hosts that already have MaxPerHost jobs in flight or that were
+// contacted less than DelayPerHost ago. This keeps workers busy with other
+// hosts instead of blocking on a single busy one. Jobs with a higher priority
+// are handed out before all others that can start.
+type Queue struct {
+	lock *sync.Mutex
+	cond *sync.Cond
+
+	maxPerHost   int           // 0 means unlimited
+	delayPerHost time.Duration // minimum time between two jobs of a host
+	hostLimit    func(host string) Limit
+	limits       map[string]Limit // results of hostLimit by host
+
+	pending map[string]*lanes
+	hosts   []string // hosts with pending jobs, in round-robin order
+	active  map[string]int
+	next    map[string]time.Time
+	closed  bool
+	ranked  bool // a job with a priority other than 0 was pushed
+	timer   *time.Timer
+	wakeup  time.Time // when timer fires
+}
+
+// New returns an empty Queue
+func New(maxPerHost int, delayPerHost time.Duration) *Queue {
+	lock := &sync.Mutex{}
+	return &Queue{
+		lock:         lock,
+		cond:         sync.NewCond(lock),
+		maxPerHost:   maxPerHost,
+		delayPerHost: delayPerHost,
+		pending:      make(map[string]*lanes),
+		active:       make(map[string]int),
+		next:         make(map[string]time.Time),
+		limits:       make(map[string]Limit),
+	}
+}
+
+// SetHostLimit makes the queue ask limit for the limits of every host, the
+// answers are cached
+func (q *Queue) SetHostLimit(limit func(host string) Limit) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.hostLimit = limit
+	q.limits = make(map[string]Limit)
+	q.cond.Broadcast()
+}
+
+// limitsOf returns the maximum number of jobs in flight and the delay
+// between two jobs of host
+func (q *Queue) limitsOf(host string) (int, time.Duration) {
+	maxPerHost, delayPerHost := q.maxPerHost, q.delayPerHost
+	if q.hostLimit == nil {
+		return maxPerHost, delayPerHost
+	}
+
+	limit, ok := q.limits[host]
+	if !ok {
+		limit = q.hostLimit(host)
+		q.limits[host] = limit
+	}
+	if limit.MaxPerHost > 0 {
+		maxPerHost = limit.MaxPerHost
+	}
+	if limit.DelayPerHost > 0 {
+		delayPerHost = limit.DelayPerHost
+	}
+	return maxPerHost, delayPerHost
+}
+
+// item is a pending job with its priority
+type item struct {
+	priority int
+	job      interface{}
+}
+
+// lanes are the pending jobs of a host, grouped by lane. The jobs of a lane
+// are ordered by decreasing priority, jobs of the same priority by the order
+// they were pushed in.
+type lanes struct {
+	jobs  map[string][]item
+	order []string // lanes with pending jobs, in round-robin order
+}
+
+// push adds a job to lane
+func (l *lanes) push(lane string, priority int, job interface{}) {
+	jobs := l.jobs[lane]
+	if len(jobs) == 0 {
+		l.order = append(l.order, lane)
+	}
+
+	// jobs are mostly pushed in the order of their priorities, so the
+	// position is searched for from the end
+	i := len(jobs)
+	for i > 0 && jobs[i-1].priority < priority {
+		i--
+	}
+	jobs = append(jobs, item{})
+	copy(jobs[i+1:], jobs[i:])
+	jobs[i] = item{priority: priority, job: job}
+	l.jobs[lane] = jobs
+}
+
+// next returns the position in order of the lane whose first job has the
+// highest priority, the first one of those in the round-robin order
+func (l *lanes) next() int {
+	best := 0
+	for i, lane := range l.order {
+		if l.jobs[lane][0].priority > l.jobs[l.order[best]][0].priority {
+			best = i
+		}
+	}
+	return best
+}
+
+// priority returns the priority of the job pop returns
+func (l *lanes) priority() int {
+	return l.jobs[l.order[l.next()]][0].priority
+}
+
+// pop removes the next job, of the lane next returns, and moves the lane to
+// the end of the round-robin order
+func (l *lanes) pop() interface{} {
+	i := l.next()
+	lane := l.order[i]
+	job := l.jobs[lane][0].job
+	l.jobs[lane] = l.jobs[lane][1:]
+
+	l.order = append(l.order[:i], l.order[i+1:]...)
+	if len(l.jobs[lane]) > 0 {
+		l.order = append(l.order, lane)
+	} else {
+		delete(l.jobs, lane)
+	}
+	return job
+}
+
+// Push adds a job for host to the queue
+func (q *Queue) Push(host string, job interface{}) {
+	q.PushLane(host, "", job)
+}
+
+// PushLane adds a job for host to lane. The jobs of a host are handed out
+// round-robin between its lanes, e.g. the lists the jobs come from, so that
+// the jobs of one lane don't have to wait for all of those that were pushed
+// to another lane before.
+func (q *Queue) PushLane(host, lane string, job interface{}) {
+	q.PushPriority(host, lane, 0, job)
+}
+
+// PushPriority adds a job with a priority for host to lane. Of the jobs that
+// can start, the one with the highest priority is handed out first.
+func (q *Queue) PushPriority(host, lane string, priority int, job interface{}) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	if q.pending[host] == nil {
+		q.pending[host] = &lanes{jobs: make(map[string][]item)}
+		q.hosts = append(q.hosts, host)
+	}
+	q.pending[host].push(lane, priority, job)
+	if priority != 0 {
+		q.ranked = true
+	}
+	q.cond.Broadcast()
+}
+
+// Close marks the end of the input. Pop returns false once all remaining jobs
+// have been handed out.
+func (q *Queue) Close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// SetMaxPerHost changes the number of jobs allowed in flight per host
+func (q *Queue) SetMaxPerHost(n int) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.maxPerHost = n
+	q.cond.Broadcast()
+}
+
+// MaxPerHost returns the number of jobs allowed in flight per host
+func (q *Queue) MaxPerHost() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	return q.maxPerHost
+}
+
+// Pop blocks until a job can be started and returns it together with its host.
+// The caller must call Done(host) once the job is finished. ok is false when
+// the queue is closed and empty.
+func (q *Queue) Pop() (host string, job interface{}, ok bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	for {
+		if len(q.hosts) == 0 {
+			if q.closed {
+				return "", nil, false
+			}
+			q.cond.Wait()
+			continue
+		}
+
+		now := time.Now()
+		var wakeup time.Time
+
+		// of the hosts that can start a job, the first one in the
+		// round-robin order with the highest priority is chosen
+		chosen := -1
+		for i, h := range q.hosts {
+			if maxPerHost, _ := q.limitsOf(h); maxPerHost > 0 && q.active[h] >= maxPerHost {
+				continue
+			}
+			if next := q.next[h]; now.Before(next) {
+				if wakeup.IsZero() || next.Before(wakeup) {
+					wakeup = next
+				}
+				continue
+			}
+			if chosen < 0 || q.pending[h].priority() > q.pending[q.hosts[chosen]].priority() {
+				chosen = i
+			}
+			if !q.ranked {
+				break
+			}
+		}
+
+		if chosen >= 0 {
+			i, h := chosen, q.hosts[chosen]
+			job = q.pending[h].pop()
+
+			// move the host to the end of the round-robin order, or drop
+			// it if it has no more pending jobs
+			q.hosts = append(q.hosts[:i], q.hosts[i+1:]...)
+			if len(q.pending[h].order) > 0 {
+				q.hosts = append(q.hosts, h)
+			} else {
+				delete(q.pending, h)
+			}
+
+			q.active[h]++
+			if _, delayPerHost := q.limitsOf(h); delayPerHost > 0 {
+				q.next[h] = now.Add(delayPerHost)
+			}
+
+			return h, job, true
+		}
+
+		// nothing can start right now, wait for a job to finish or for the
+		// earliest politeness delay to pass
+		switch {
+		case wakeup.IsZero():
+		case q.timer == nil:
+			q.wakeup = wakeup
+			q.timer = time.AfterFunc(wakeup.Sub(now), func() {
+				q.lock.Lock()
+				defer q.lock.Unlock()
+
+				q.timer = nil
+				q.cond.Broadcast()
+			})
+		case wakeup.Before(q.wakeup):
+			// the delay of another host ends before the one the timer
+			// waits for
+			q.wakeup = wakeup
+			q.timer.Reset(wakeup.Sub(now))
+		}
+		q.cond.Wait()
+	}
+}
+
+// Done marks a job of host returned by Pop as finished
+func (q *Queue) Done(host string) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	q.active[host]--
+	if q.active[host] <= 0 {
+		delete(q.active, host)
+	}
+	q.cond.Broadcast()
+}
+
+// Active returns the number of jobs in flight per host
+func (q *Queue) Active() map[string]int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	active := make(map[string]int, len(q.active))
+	for h, n := range q.active {
+		active[h] = n
+	}
+
+	return active
+}
+
+// State returns the hosts with pending jobs or jobs in flight, sorted by
+// host
+func (q *Queue) State() []HostState {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+
+	now := time.Now()
+	byHost := make(map[string]*HostState)
+	state := func(h string) *HostState {
+		if byHost[h] == nil {
+			byHost[h] = &HostState{Host: h}
+		}
+		return byHost[h]
+	}
+	for h, l := range q.pending {
+		s := state(h)
+		for _, jobs := range l.jobs {
+			s.Pending += len(jobs)
+		}
+		maxPerHost, _ := q.limitsOf(h)
+		switch next := q.next[h]; {
+		case maxPerHost > 0 && q.active[h] >= maxPerHost:
+			s.Blocked = BlockedMax
+		case now.Before(next):
+			s.Blocked = BlockedDelay
+			s.Until = next
+		}
+	}
+	for h, n := range q.active {
+		state(h).Active = n
+	}
+
+	hosts := make([]HostState, 0, len(byHost))
+	for _, s := range byHost {
+		hosts = append(hosts, *s)
+	}
+	sort.Slice(hosts, func(i, j int) bool { return hosts[i].Host < hosts[j].Host })
+	return hosts
+}
@@ -0,0 +1,266 @@
+package hostlimit
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestQueueRoundRobin(t *testing.T) {
+	q := New(0, 0)
+	for _, job := range []string{"a1", "a2", "a3", "b1", "c1"} {
+		q.Push(job[:1], job)
+	}
+	q.Close()
+
+	expected := []string{"a1", "b1", "c1", "a2", "a3"}
+	for _, e := range expected {
+		host, job, ok := q.Pop()
+		if !ok || job != e {
+			t.Fatalf("expected %s received %v (ok=%v)", e, job, ok)
+		}
+		q.Done(host)
+	}
+
+	if _, _, ok := q.Pop(); ok {
+		t.Error("expected an empty queue")
+	}
+}
+
+func TestQueueLanes(t *testing.T) {
+	q := New(0, 0)
+	for _, job := range []string{"x1", "x2", "x3", "y1", "y2"} {
+		q.PushLane("host", job[:1], job)
+	}
+	q.Push("host", "z1")
+	q.Close()
+
+	expected := []string{"x1", "y1", "z1", "x2", "y2", "x3"}
+	for _, e := range expected {
+		host, job, ok := q.Pop()
+		if !ok || job != e {
+			t.Fatalf("expected %s received %v (ok=%v)", e, job, ok)
+		}
+		q.Done(host)
+	}
+
+	if _, _, ok := q.Pop(); ok {
+		t.Error("expected an empty queue")
+	}
+}
+
+func TestQueueMaxPerHost(t *testing.T) {
+	q := New(1, 0)
+	q.Push("a", "a1")
+	q.Push("a", "a2")
+	q.Push("b", "b1")
+	q.Close()
+
+	host, job, _ := q.Pop()
+	if job != "a1" {
+		t.Fatalf("expected a1 received %v", job)
+	}
+
+	// a is busy, so b has to come next
+	if _, job, _ = q.Pop(); job != "b1" {
+		t.Fatalf("expected b1 received %v", job)
+	}
+
+	done := make(chan interface{})
+	go func() {
+		_, job, _ := q.Pop()
+		done <- job
+	}()
+
+	select {
+	case job = <-done:
+		t.Fatalf("received %v while host a was busy", job)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	q.Done(host)
+	if job = <-done; job != "a2" {
+		t.Errorf("expected a2 received %v", job)
+	}
+}
+
+func TestQueueDelayPerHost(t *testing.T) {
+	delay := 100 * time.Millisecond
+	q := New(0, delay)
+	q.Push("a", "a1")
+	q.Push("a", "a2")
+	q.Close()
+
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		host, _, _ := q.Pop()
+		q.Done(host)
+	}
+
+	if elapsed := time.Since(start); elapsed < delay {
+		t.Errorf("two jobs of the same host were started within %v", elapsed)
+	}
+}
+
+func TestQueueHostLimit(t *testing.T) {
+	q := New(1, 0)
+	q.SetHostLimit(func(host string) Limit {
+		if host == "cdn" {
+			return Limit{MaxPerHost: 2}
+		}
+		return Limit{DelayPerHost: time.Hour}
+	})
+	for _, job := range []string{"cdn1", "cdn2", "cdn3", "origin1", "origin2"} {
+		q.Push(job[:len(job)-1], job)
+	}
+
+	// cdn allows two jobs in flight, origin one job an hour
+	expected := []string{"cdn1", "origin1", "cdn2"}
+	for _, e := range expected {
+		if _, job, _ := q.Pop(); job != e {
+			t.Fatalf("expected %s received %v", e, job)
+		}
+	}
+	q.Done("origin")
+
+	done := make(chan interface{})
+	go func() {
+		_, job, _ := q.Pop()
+		done <- job
+	}()
+	select {
+	case job := <-done:
+		t.Fatalf("received %v while cdn was busy and origin delayed", job)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	q.Done("cdn")
+	if job := <-done; job != "cdn3" {
+		t.Errorf("expected cdn3 received %v", job)
+	}
+}
+
+func TestQueueDelaysOfHosts(t *testing.T) {
+	delay := 100 * time.Millisecond
+	q := New(1, 0)
+	q.SetHostLimit(func(host string) Limit {
+		if host == "fast" {
+			return Limit{DelayPerHost: delay}
+		}
+		return Limit{DelayPerHost: time.Hour}
+	})
+	for _, job := range []string{"fast1", "fast2", "slow1", "slow2"} {
+		q.Push(job[:len(job)-1], job)
+	}
+	for _, e := range []string{"fast1", "slow1"} {
+		if _, job, _ := q.Pop(); job != e {
+			t.Fatalf("expected %s received %v", e, job)
+		}
+	}
+	q.Done("slow")
+
+	// fast is busy, so Pop waits for the delay of slow first
+	done := make(chan interface{})
+	go func() {
+		_, job, _ := q.Pop()
+		done <- job
+	}()
+	select {
+	case job := <-done:
+		t.Fatalf("received %v while fast was busy and slow delayed", job)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the delay of fast ends long before the one of slow
+	q.Done("fast")
+	select {
+	case job := <-done:
+		if job != "fast2" {
+			t.Errorf("expected fast2 received %v", job)
+		}
+	case <-time.After(10 * delay):
+		t.Error("expected fast2 once the delay of fast passed")
+	}
+}
+
+func TestQueueState(t *testing.T) {
+	q := New(1, time.Hour)
+	q.Push("a", "a1")
+	q.Push("a", "a2")
+	q.Push("b", "b1")
+	q.Push("b", "b2")
+	q.Push("c", "c1")
+
+	// a and b have a job in flight, c is idle
+	for i := 0; i < 2; i++ {
+		if _, _, ok := q.Pop(); !ok {
+			t.Fatal("expected a job")
+		}
+	}
+	q.Done("b")
+
+	received := q.State()
+	expected := []HostState{
+		{Host: "a", Pending: 1, Active: 1, Blocked: BlockedMax},
+		{Host: "b", Pending: 1, Blocked: BlockedDelay},
+		{Host: "c", Pending: 1},
+	}
+	if len(received) != len(expected) {
+		t.Fatalf("expected %v received %v", expected, received)
+	}
+	for i := range expected {
+		received[i].Until = time.Time{}
+		if received[i] != expected[i] {
+			t.Errorf("expected %+v received %+v", expected[i], received[i])
+		}
+	}
+}
+
+func TestQueuePriority(t *testing.T) {
+	q := New(0, 0)
+	q.Push("a", "a1")
+	q.PushPriority("a", "", 5, "a2")
+	q.PushPriority("b", "", 1, "b1")
+	q.PushPriority("b", "x", 5, "b2")
+	q.PushPriority("c", "", 9, "c1")
+	q.Push("c", "c2")
+	q.Close()
+
+	// the highest priority first, equal ones round-robin between the hosts
+	expected := []string{"c1", "a2", "b2", "b1", "c2", "a1"}
+	for _, e := range expected {
+		host, job, ok := q.Pop()
+		if !ok || job != e {
+			t.Fatalf("expected %s received %v (ok=%v)", e, job, ok)
+		}
+		q.Done(host)
+	}
+}
+
+func benchmarkQueue(b *testing.B, hosts, priorities int) {
+	names := make([]string, hosts)
+	for i := range names {
+		names[i] = fmt.Sprintf("host%d", i)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		q := New(2, 0)
+		for j := 0; j < 1000; j++ {
+			q.PushPriority(names[j%hosts], "", j%priorities, j)
+		}
+		q.Close()
+		for {
+			host, _, ok := q.Pop()
+			if !ok {
+				break
+			}
+			q.Done(host)
+		}
+	}
+}
+
+func BenchmarkQueueOneHost(b *testing.B)        { benchmarkQueue(b, 1, 1) }
+func BenchmarkQueueManyHosts(b *testing.B)      { benchmarkQueue(b, 100, 1) }
+func BenchmarkQueueManyPriorities(b *testing.B) { benchmarkQueue(b, 100, 10) }
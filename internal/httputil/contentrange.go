@@ -0,0 +1,46 @@
+package httputil
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ParseContentRange parses the value of a Content-Range response header,
+// e.g. "bytes 100-199/1000". A total of -1 is returned when the server
+// sent "*" as the complete length.
+func ParseContentRange(v string) (start, end, total int64, err error) {
+	v = strings.TrimSpace(v)
+	if !strings.HasPrefix(v, "bytes ") {
+		return 0, 0, 0, fmt.Errorf("invalid content range %q", v)
+	}
+	v = strings.TrimPrefix(v, "bytes ")
+
+	slash := strings.Index(v, "/")
+	if slash < 0 {
+		return 0, 0, 0, fmt.Errorf("invalid content range %q", v)
+	}
+
+	total = -1
+	if t := v[slash+1:]; t != "*" {
+		if total, err = strconv.ParseInt(t, 10, 64); err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid content range total %q: %v", t, err)
+		}
+	}
+
+	bounds := strings.SplitN(v[:slash], "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, 0, fmt.Errorf("invalid content range %q", v)
+	}
+	if start, err = strconv.ParseInt(bounds[0], 10, 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid content range start %q: %v", bounds[0], err)
+	}
+	if end, err = strconv.ParseInt(bounds[1], 10, 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid content range end %q: %v", bounds[1], err)
+	}
+	if end < start {
+		return 0, 0, 0, fmt.Errorf("invalid content range %q", v)
+	}
+
+	return start, end, total, nil
+}
@@ -0,0 +1,40 @@
+package httputil
+
+import "testing"
+
+func TestParseContentRange(t *testing.T) {
+	testCases := []struct {
+		value         string
+		expectedStart int64
+		expectedEnd   int64
+		expectedTotal int64
+		expectErr     bool
+	}{
+		{"bytes 0-499/1234", 0, 499, 1234, false},
+		{"bytes 500-1233/1234", 500, 1233, 1234, false},
+		{"bytes 42-42/*", 42, 42, -1, false},
+		{"bytes */1234", 0, 0, 0, true},
+		{"bytes 10-5/100", 0, 0, 0, true},
+		{"items 0-1/2", 0, 0, 0, true},
+		{"", 0, 0, 0, true},
+	}
+
+	for _, testCase := range testCases {
+		start, end, total, err := ParseContentRange(testCase.value)
+		if testCase.expectErr {
+			if err == nil {
+				t.Errorf("value=%#v expected an error", testCase.value)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("value=%#v returned error: %v", testCase.value, err)
+			continue
+		}
+
+		if start != testCase.expectedStart || end != testCase.expectedEnd || total != testCase.expectedTotal {
+			t.Errorf("value=%#v returned start=%d end=%d total=%d", testCase.value, start, end, total)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package httputil
+
+import (
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// unsafeNameChars are replaced in file names chosen by servers
+var unsafeNameChars = regexp.MustCompile(`[<>:"|?*\\/\x00-\x1f]`)
+
+// DispositionFilename returns the file name suggested by the
+// Content-Disposition header of h, preferring the UTF-8 filename* parameter
+// over filename. Directories are stripped and characters that aren't allowed
+// in file names are replaced, so the result is a single path element, or ""
+// if there is no usable name.
+func DispositionFilename(h http.Header) string {
+	_, params, err := mime.ParseMediaType(h.Get("Content-Disposition"))
+	if err != nil {
+		return ""
+	}
+	// mime decodes filename* into filename
+	return SafeFilename(params["filename"])
+}
+
+// SafeFilename returns the last element of name, which may use / or \ as
+// separator, with unsafe characters replaced by _. "" is returned for names
+// that can't be used as a file name, like "" or "..".
+func SafeFilename(name string) string {
+	name = strings.ReplaceAll(name, `\`, "/")
+	name = path.Base(strings.TrimSpace(name))
+	name = unsafeNameChars.ReplaceAllString(name, "_")
+	name = strings.Trim(name, " .")
+
+	if name == "" || name == "_" {
+		return ""
+	}
+	return name
+}
@@ -0,0 +1,34 @@
+package httputil
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestDispositionFilename(t *testing.T) {
+	testCases := []struct {
+		header   string
+		expected string
+	}{
+		{`attachment; filename="report.pdf"`, "report.pdf"},
+		{`attachment; filename=report.pdf`, "report.pdf"},
+		{`inline; filename="a.txt"; filename*=UTF-8''%E2%82%AC%20rates.txt`, "€ rates.txt"},
+		{`attachment; filename="../../etc/passwd"`, "passwd"},
+		{`attachment; filename="C:\\temp\\data.csv"`, "data.csv"},
+		{`attachment; filename="a:b?.txt"`, "a_b_.txt"},
+		{`attachment; filename=".."`, ""},
+		{`attachment`, ""},
+		{``, ""},
+		{`attachment; filename="unterminated`, ""},
+	}
+
+	for _, testCase := range testCases {
+		h := http.Header{}
+		if testCase.header != "" {
+			h.Set("Content-Disposition", testCase.header)
+		}
+		if received := DispositionFilename(h); received != testCase.expected {
+			t.Errorf("%s: expected %q received %q", testCase.header, testCase.expected, received)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package httputil
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// defaultPorts are left out of normalized urls
+var defaultPorts = map[string]string{"http": "80", "https": "443", "ftp": "21"}
+
+// NormalizeURL returns a form of u that is the same for urls which differ
+// only in the case of the scheme and host, an explicit default port, an
+// empty path or a fragment. The query is kept as it is, since servers may
+// depend on the order of its parameters.
+func NormalizeURL(u *url.URL) string {
+	n := *u
+	n.Scheme = strings.ToLower(n.Scheme)
+	n.Host = strings.ToLower(n.Host)
+	if host, port, err := net.SplitHostPort(n.Host); err == nil && defaultPorts[n.Scheme] == port {
+		n.Host = host
+		if strings.Contains(host, ":") {
+			n.Host = "[" + host + "]"
+		}
+	}
+	if n.Path == "" && n.RawPath == "" && n.Opaque == "" {
+		n.Path = "/"
+	}
+	n.Fragment = ""
+	n.RawFragment = ""
+	return n.String()
+}
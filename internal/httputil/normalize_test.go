@@ -0,0 +1,33 @@
+package httputil
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestNormalizeURL(t *testing.T) {
+	testCases := []struct {
+		url      string
+		expected string
+	}{
+		{"http://example.com/a.zip", "http://example.com/a.zip"},
+		{"HTTP://Example.COM/a.zip", "http://example.com/a.zip"},
+		{"http://example.com:80/a.zip", "http://example.com/a.zip"},
+		{"https://example.com:443/a.zip", "https://example.com/a.zip"},
+		{"https://example.com:8443/a.zip", "https://example.com:8443/a.zip"},
+		{"http://example.com:443/a.zip", "http://example.com:443/a.zip"},
+		{"http://[::1]:80/a.zip", "http://[::1]/a.zip"},
+		{"http://example.com", "http://example.com/"},
+		{"http://example.com/a.zip#part", "http://example.com/a.zip"},
+		{"http://example.com/A.zip?b=1&a=2", "http://example.com/A.zip?b=1&a=2"},
+	}
+	for _, testCase := range testCases {
+		u, err := url.Parse(testCase.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if received := NormalizeURL(u); received != testCase.expected {
+			t.Errorf("%s: expected %s received %s", testCase.url, testCase.expected, received)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package httputil
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+)
+
+// PartialBody returns the offset of the first byte of a 206 response and a
+// reader for its data. Responses of type multipart/byteranges are assembled
+// into one range, which requires their parts to be in order and without
+// gaps between them; the reader fails at the first gap.
+func PartialBody(h http.Header, body io.Reader) (int64, io.Reader, error) {
+	mediaType, params, err := mime.ParseMediaType(h.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/byteranges" {
+		start, _, _, err := ParseContentRange(h.Get("Content-Range"))
+		return start, body, err
+	}
+
+	r := &rangesReader{parts: multipart.NewReader(body, params["boundary"])}
+	if err = r.nextPart(); err != nil {
+		return 0, nil, err
+	}
+
+	return r.start, r, nil
+}
+
+// rangesReader reads the parts of a multipart/byteranges body one after
+// another
+type rangesReader struct {
+	parts *multipart.Reader
+	part  *multipart.Part
+	start int64 // first byte of the first part
+	next  int64 // byte that the next part must start at
+}
+
+func (r *rangesReader) nextPart() error {
+	for {
+		part, err := r.parts.NextPart()
+		if err != nil {
+			return err
+		}
+
+		start, end, _, err := ParseContentRange(part.Header.Get("Content-Range"))
+		if err != nil {
+			return err
+		}
+		if r.part == nil {
+			r.start, r.next = start, start
+		}
+		if start > r.next {
+			return fmt.Errorf("missing bytes %d-%d between the ranges", r.next, start-1)
+		}
+		if end < r.next {
+			// all bytes were already read from earlier parts
+			continue
+		}
+
+		// overlapping bytes were already read from the previous part
+		if _, err = io.CopyN(ioutil.Discard, part, r.next-start); err != nil {
+			return err
+		}
+
+		r.part = part
+		r.next = end + 1
+		return nil
+	}
+}
+
+func (r *rangesReader) Read(b []byte) (int, error) {
+	for {
+		n, err := r.part.Read(b)
+		if err != io.EOF {
+			return n, err
+		}
+		if n > 0 {
+			return n, nil
+		}
+		if err = r.nextPart(); err != nil {
+			return 0, err
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package httputil
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestPartialBody(t *testing.T) {
+	multipartBody := func(parts ...string) string {
+		var b strings.Builder
+		for i := 0; i < len(parts); i += 2 {
+			b.WriteString("--SEP\r\nContent-Type: text/plain\r\nContent-Range: " + parts[i] + "\r\n\r\n" + parts[i+1] + "\r\n")
+		}
+		b.WriteString("--SEP--\r\n")
+		return b.String()
+	}
+
+	testCases := []struct {
+		contentType   string
+		contentRange  string
+		body          string
+		expectedStart int64
+		expectedData  string
+		expectErr     bool
+	}{
+		{"text/plain", "bytes 5-9/10", "56789", 5, "56789", false},
+		{"text/plain", "", "56789", 0, "", true},
+		{"multipart/byteranges; boundary=SEP", "", multipartBody("bytes 2-4/10", "234", "bytes 5-6/10", "56"), 2, "23456", false},
+		{"multipart/byteranges; boundary=SEP", "", multipartBody("bytes 2-4/10", "234", "bytes 3-7/10", "34567", "bytes 4-5/10", "45"), 2, "234567", false},
+		{"multipart/byteranges; boundary=SEP", "", multipartBody("bytes 2-4/10", "234", "bytes 7-8/10", "78"), 2, "", true},
+	}
+
+	for _, testCase := range testCases {
+		h := http.Header{"Content-Type": {testCase.contentType}}
+		if testCase.contentRange != "" {
+			h.Set("Content-Range", testCase.contentRange)
+		}
+
+		start, r, err := PartialBody(h, strings.NewReader(testCase.body))
+		var data []byte
+		if err == nil {
+			data, err = ioutil.ReadAll(r)
+		}
+		if testCase.expectErr {
+			if err == nil {
+				t.Errorf("%q expected an error received %q", testCase.body, data)
+			}
+			continue
+		}
+		if err != nil || start != testCase.expectedStart || string(data) != testCase.expectedData {
+			t.Errorf("%q expected %d %q received %d %q %v", testCase.body, testCase.expectedStart, testCase.expectedData, start, data, err)
+		}
+	}
+}
+
+func TestValidator(t *testing.T) {
+	testCases := []struct {
+		etag         string
+		lastModified string
+		expected     string
+	}{
+		{`"abc"`, "Wed, 21 Oct 2015 07:28:00 GMT", `"abc"`},
+		{`W/"abc"`, "Wed, 21 Oct 2015 07:28:00 GMT", "Wed, 21 Oct 2015 07:28:00 GMT"},
+		{`W/"abc"`, "", ""},
+		{"", "", ""},
+	}
+
+	for _, testCase := range testCases {
+		h := http.Header{}
+		if testCase.etag != "" {
+			h.Set("ETag", testCase.etag)
+		}
+		if testCase.lastModified != "" {
+			h.Set("Last-Modified", testCase.lastModified)
+		}
+		if v := Validator(h); v != testCase.expected {
+			t.Errorf("etag=%s last-modified=%s expected %q received %q", testCase.etag, testCase.lastModified, testCase.expected, v)
+		}
+	}
+
+	if !Changed(`"a"`, http.Header{"Etag": {`"b"`}}) || Changed(`"a"`, http.Header{"Etag": {`"a"`}}) || Changed(`"a"`, http.Header{}) {
+		t.Error("Changed returned a wrong result")
+	}
+}
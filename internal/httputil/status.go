@@ -0,0 +1,70 @@
+package httputil
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatusSet is a set of http status codes
+type StatusSet struct {
+	codes   map[int]bool
+	classes [10]bool // classes[5] for 5xx
+}
+
+// ParseStatusSet parses a comma separated list of status codes like 429 and
+// classes like 5xx
+func ParseStatusSet(s string) (StatusSet, error) {
+	set := StatusSet{codes: make(map[int]bool)}
+
+	for _, item := range strings.Split(s, ",") {
+		item = strings.ToLower(strings.TrimSpace(item))
+		if item == "" {
+			continue
+		}
+
+		if len(item) == 3 && strings.HasSuffix(item, "xx") && item[0] >= '1' && item[0] <= '9' {
+			set.classes[item[0]-'0'] = true
+			continue
+		}
+
+		code, err := strconv.Atoi(item)
+		if err != nil || code < 100 || code > 999 {
+			return StatusSet{}, fmt.Errorf("invalid status code %q", item)
+		}
+		set.codes[code] = true
+	}
+
+	return set, nil
+}
+
+// Contains reports whether code is in the set
+func (s StatusSet) Contains(code int) bool {
+	if code < 100 || code > 999 {
+		return false
+	}
+	return s.codes[code] || s.classes[code/100]
+}
+
+// RetryAfter returns the delay requested by the Retry-After header of h,
+// which is given in seconds or as a date, or 0 if there is none
+func RetryAfter(h http.Header, now time.Time) time.Duration {
+	v := strings.TrimSpace(h.Get("Retry-After"))
+	if v == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(v); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
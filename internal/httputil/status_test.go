@@ -0,0 +1,66 @@
+package httputil
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStatusSet(t *testing.T) {
+	set, err := ParseStatusSet("429, 5xx,408")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		code     int
+		expected bool
+	}{
+		{429, true},
+		{408, true},
+		{500, true},
+		{503, true},
+		{599, true},
+		{404, false},
+		{200, false},
+		{0, false},
+	}
+	for _, testCase := range testCases {
+		if received := set.Contains(testCase.code); received != testCase.expected {
+			t.Errorf("code=%d expected %v received %v", testCase.code, testCase.expected, received)
+		}
+	}
+
+	for _, invalid := range []string{"abc", "42", "0xx", "5x"} {
+		if _, err = ParseStatusSet(invalid); err == nil {
+			t.Errorf("%q expected an error", invalid)
+		}
+	}
+
+	if empty, err := ParseStatusSet(""); err != nil || empty.Contains(500) {
+		t.Errorf("empty set contains 500 or failed: %v", err)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+
+	testCases := []struct {
+		value    string
+		expected time.Duration
+	}{
+		{"120", 2 * time.Minute},
+		{"Wed, 21 Oct 2015 07:28:30 GMT", 30 * time.Second},
+		{"Wed, 21 Oct 2015 07:27:00 GMT", 0},
+		{"-1", 0},
+		{"soon", 0},
+		{"", 0},
+	}
+	for _, testCase := range testCases {
+		h := http.Header{}
+		h.Set("Retry-After", testCase.value)
+		if received := RetryAfter(h, now); received != testCase.expected {
+			t.Errorf("value=%q expected %v received %v", testCase.value, testCase.expected, received)
+		}
+	}
+}
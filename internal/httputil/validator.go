@@ -0,0 +1,25 @@
+package httputil
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Validator returns the value of an If-Range header that only matches the
+// version of the resource described by the response header h: its strong
+// ETag, or else its Last-Modified date. "" is returned if there is neither,
+// weak ETags can't be used with If-Range.
+func Validator(h http.Header) string {
+	if etag := strings.TrimSpace(h.Get("ETag")); etag != "" && !strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return strings.TrimSpace(h.Get("Last-Modified"))
+}
+
+// Changed reports whether the response header h belongs to another version
+// of the resource than validator, which was returned by Validator. Responses
+// without a validator are assumed to be unchanged.
+func Changed(validator string, h http.Header) bool {
+	current := Validator(h)
+	return validator != "" && current != "" && current != validator
+}
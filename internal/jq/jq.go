@@ -0,0 +1,647 @@
+// Package jq implements the subset of the jq language that reshapes JSON
+// documents, not the whole language:
+//
+//   - paths: ., .a.b, ."key", .["key"], .[0], .[-1] and .[] to iterate
+//   - pipes: a | b
+//   - construction: [a], {key, key: a, "key": a}
+//   - literals: strings, numbers, true, false and null
+//   - comparisons: ==, !=, <, <=, > and >=
+//   - functions: select(a) and map(a)
+//
+// Everything else, like the comma operator, ?, arithmetic, variables,
+// definitions and the other functions, fails to compile.
+package jq
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Query is a compiled jq expression
+type Query struct {
+	src  string
+	eval evalFunc
+}
+
+// evalFunc produces the outputs of an expression for one input
+type evalFunc func(v interface{}) ([]interface{}, error)
+
+// Compile parses a jq expression
+func Compile(src string) (*Query, error) {
+	p := &parser{src: src}
+	p.next()
+
+	eval, err := p.parsePipe()
+	if err != nil {
+		return nil, err
+	}
+	if p.tok.kind != tokEOF {
+		return nil, p.errorf("unexpected %q", p.tok.text)
+	}
+
+	return &Query{src: src, eval: eval}, nil
+}
+
+// Run evaluates the query for the JSON value v (as decoded by encoding/json)
+// and returns all of its outputs
+func (q *Query) Run(v interface{}) ([]interface{}, error) {
+	return q.eval(v)
+}
+
+// Transform decodes the JSON document in b, runs the query on it and returns
+// its outputs encoded as JSON, one per line
+func (q *Query) Transform(b []byte) ([]byte, error) {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+
+	outputs, err := q.Run(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+	for _, out := range outputs {
+		line, err := json.Marshal(out)
+		if err != nil {
+			return nil, err
+		}
+		sb.Write(line)
+		sb.WriteByte('\n')
+	}
+
+	return []byte(sb.String()), nil
+}
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokPunct
+	tokInvalid // an unterminated or invalid string
+)
+
+type token struct {
+	kind tokKind
+	text string
+}
+
+type parser struct {
+	src string
+	pos int
+	tok token
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("jq: "+format+" at position %d in %q", append(args, p.pos, p.src)...)
+}
+
+// next reads the next token into p.tok
+func (p *parser) next() {
+	for p.pos < len(p.src) && strings.ContainsRune(" \t\r\n", rune(p.src[p.pos])) {
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		p.tok = token{kind: tokEOF}
+		return
+	}
+
+	c := p.src[p.pos]
+	start := p.pos
+
+	switch {
+	case c == '"':
+		p.pos++
+		for p.pos < len(p.src) && p.src[p.pos] != '"' {
+			if p.src[p.pos] == '\\' {
+				p.pos++
+			}
+			p.pos++
+		}
+		p.pos++
+		s, err := strconv.Unquote(p.src[start:min(p.pos, len(p.src))])
+		if err != nil {
+			p.tok = token{kind: tokInvalid, text: p.src[start:min(p.pos, len(p.src))]}
+			return
+		}
+		p.tok = token{kind: tokString, text: s}
+
+	case c >= '0' && c <= '9' || c == '-' && p.pos+1 < len(p.src) && p.src[p.pos+1] >= '0' && p.src[p.pos+1] <= '9':
+		p.pos++
+		for p.pos < len(p.src) && strings.ContainsRune("0123456789.eE", rune(p.src[p.pos])) {
+			p.pos++
+		}
+		p.tok = token{kind: tokNumber, text: p.src[start:p.pos]}
+
+	case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || p.src[p.pos] >= 'a' && p.src[p.pos] <= 'z' ||
+			p.src[p.pos] >= 'A' && p.src[p.pos] <= 'Z' || p.src[p.pos] >= '0' && p.src[p.pos] <= '9') {
+			p.pos++
+		}
+		p.tok = token{kind: tokIdent, text: p.src[start:p.pos]}
+
+	default:
+		for _, op := range []string{"==", "!=", "<=", ">="} {
+			if strings.HasPrefix(p.src[p.pos:], op) {
+				p.pos += 2
+				p.tok = token{kind: tokPunct, text: op}
+				return
+			}
+		}
+		p.pos++
+		p.tok = token{kind: tokPunct, text: string(c)}
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// expect consumes the punctuation text or returns an error
+func (p *parser) expect(text string) error {
+	if p.tok.kind != tokPunct || p.tok.text != text {
+		return p.errorf("expected %q", text)
+	}
+	p.next()
+	return nil
+}
+
+func (p *parser) isPunct(text string) bool {
+	return p.tok.kind == tokPunct && p.tok.text == text
+}
+
+// parsePipe parses expr ('|' expr)*
+func (p *parser) parsePipe() (evalFunc, error) {
+	left, err := p.parseCompare()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.isPunct("|") {
+		p.next()
+		right, err := p.parseCompare()
+		if err != nil {
+			return nil, err
+		}
+		left = pipe(left, right)
+	}
+
+	return left, nil
+}
+
+func pipe(left, right evalFunc) evalFunc {
+	return func(v interface{}) ([]interface{}, error) {
+		ins, err := left(v)
+		if err != nil {
+			return nil, err
+		}
+
+		var outs []interface{}
+		for _, in := range ins {
+			res, err := right(in)
+			if err != nil {
+				return nil, err
+			}
+			outs = append(outs, res...)
+		}
+		return outs, nil
+	}
+}
+
+// parseCompare parses postfix (op postfix)?
+func (p *parser) parseCompare() (evalFunc, error) {
+	left, err := p.parsePostfix()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.tok.kind != tokPunct {
+		return left, nil
+	}
+	op := p.tok.text
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+	default:
+		return left, nil
+	}
+	p.next()
+
+	right, err := p.parsePostfix()
+	if err != nil {
+		return nil, err
+	}
+
+	return func(v interface{}) ([]interface{}, error) {
+		as, err := left(v)
+		if err != nil {
+			return nil, err
+		}
+		bs, err := right(v)
+		if err != nil {
+			return nil, err
+		}
+
+		var outs []interface{}
+		for _, a := range as {
+			for _, b := range bs {
+				outs = append(outs, compare(op, a, b))
+			}
+		}
+		return outs, nil
+	}, nil
+}
+
+func compare(op string, a, b interface{}) bool {
+	switch op {
+	case "==":
+		return reflect.DeepEqual(a, b)
+	case "!=":
+		return !reflect.DeepEqual(a, b)
+	}
+
+	af, aok := a.(float64)
+	bf, bok := b.(float64)
+	if !aok || !bok {
+		as, aok := a.(string)
+		bs, bok := b.(string)
+		if !aok || !bok {
+			return false
+		}
+		af, bf = float64(strings.Compare(as, bs)), 0
+	}
+
+	switch op {
+	case "<":
+		return af < bf
+	case "<=":
+		return af <= bf
+	case ">":
+		return af > bf
+	default:
+		return af >= bf
+	}
+}
+
+// parsePostfix parses a primary expression followed by path suffixes
+func (p *parser) parsePostfix() (evalFunc, error) {
+	eval, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case p.isPunct("."):
+			p.next()
+			key, err := p.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			eval = pipe(eval, index(key))
+
+		case p.isPunct("["):
+			suffix, err := p.parseBracket()
+			if err != nil {
+				return nil, err
+			}
+			eval = pipe(eval, suffix)
+
+		default:
+			return eval, nil
+		}
+	}
+}
+
+// parseKey parses the identifier or string after a '.'
+func (p *parser) parseKey() (string, error) {
+	if p.tok.kind != tokIdent && p.tok.kind != tokString {
+		return "", p.errorf("expected a key")
+	}
+	key := p.tok.text
+	p.next()
+	return key, nil
+}
+
+// parseBracket parses [], [n] or ["key"] after the opening bracket
+func (p *parser) parseBracket() (evalFunc, error) {
+	if err := p.expect("["); err != nil {
+		return nil, err
+	}
+
+	if p.isPunct("]") {
+		p.next()
+		return iterate, nil
+	}
+
+	var eval evalFunc
+	switch p.tok.kind {
+	case tokNumber:
+		n, err := strconv.Atoi(p.tok.text)
+		if err != nil {
+			return nil, p.errorf("invalid index %q", p.tok.text)
+		}
+		eval = arrayIndex(n)
+	case tokString:
+		eval = index(p.tok.text)
+	default:
+		return nil, p.errorf("expected an index")
+	}
+	p.next()
+
+	return eval, p.expect("]")
+}
+
+func identity(v interface{}) ([]interface{}, error) {
+	return []interface{}{v}, nil
+}
+
+func index(key string) evalFunc {
+	return func(v interface{}) ([]interface{}, error) {
+		switch obj := v.(type) {
+		case nil:
+			return []interface{}{nil}, nil
+		case map[string]interface{}:
+			return []interface{}{obj[key]}, nil
+		default:
+			return nil, fmt.Errorf("jq: cannot index %s with %q", typeName(v), key)
+		}
+	}
+}
+
+func arrayIndex(n int) evalFunc {
+	return func(v interface{}) ([]interface{}, error) {
+		switch arr := v.(type) {
+		case nil:
+			return []interface{}{nil}, nil
+		case []interface{}:
+			if n < 0 {
+				n += len(arr)
+			}
+			if n < 0 || n >= len(arr) {
+				return []interface{}{nil}, nil
+			}
+			return []interface{}{arr[n]}, nil
+		default:
+			return nil, fmt.Errorf("jq: cannot index %s with a number", typeName(v))
+		}
+	}
+}
+
+func iterate(v interface{}) ([]interface{}, error) {
+	switch c := v.(type) {
+	case []interface{}:
+		return c, nil
+	case map[string]interface{}:
+		var outs []interface{}
+		for _, k := range sortedKeys(c) {
+			outs = append(outs, c[k])
+		}
+		return outs, nil
+	default:
+		return nil, fmt.Errorf("jq: cannot iterate over %s", typeName(v))
+	}
+}
+
+func sortedKeys(obj map[string]interface{}) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	default:
+		return "object"
+	}
+}
+
+// parsePrimary parses ., .key, literals, constructors, (...) and functions
+func (p *parser) parsePrimary() (evalFunc, error) {
+	switch p.tok.kind {
+	case tokString:
+		s := p.tok.text
+		p.next()
+		return constant(s), nil
+
+	case tokNumber:
+		f, err := strconv.ParseFloat(p.tok.text, 64)
+		if err != nil {
+			return nil, p.errorf("invalid number %q", p.tok.text)
+		}
+		p.next()
+		return constant(f), nil
+
+	case tokIdent:
+		return p.parseFunction()
+	}
+
+	switch {
+	case p.isPunct("."):
+		p.next()
+		if p.tok.kind == tokIdent || p.tok.kind == tokString {
+			key, _ := p.parseKey()
+			return index(key), nil
+		}
+		return identity, nil
+
+	case p.isPunct("("):
+		p.next()
+		eval, err := p.parsePipe()
+		if err != nil {
+			return nil, err
+		}
+		return eval, p.expect(")")
+
+	case p.isPunct("["):
+		p.next()
+		if p.isPunct("]") {
+			p.next()
+			return constant([]interface{}{}), nil
+		}
+		eval, err := p.parsePipe()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect("]"); err != nil {
+			return nil, err
+		}
+		return func(v interface{}) ([]interface{}, error) {
+			outs, err := eval(v)
+			if outs == nil {
+				outs = []interface{}{}
+			}
+			return []interface{}{outs}, err
+		}, nil
+
+	case p.isPunct("{"):
+		return p.parseObject()
+	}
+
+	return nil, p.errorf("unexpected %q", p.tok.text)
+}
+
+func constant(c interface{}) evalFunc {
+	return func(interface{}) ([]interface{}, error) {
+		return []interface{}{c}, nil
+	}
+}
+
+// parseFunction parses literals like null and the supported functions
+func (p *parser) parseFunction() (evalFunc, error) {
+	name := p.tok.text
+	p.next()
+
+	switch name {
+	case "null":
+		return constant(nil), nil
+	case "true":
+		return constant(true), nil
+	case "false":
+		return constant(false), nil
+	case "select", "map":
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		arg, err := p.parsePipe()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(")"); err != nil {
+			return nil, err
+		}
+		if name == "map" {
+			return mapFunc(arg), nil
+		}
+		return selectFunc(arg), nil
+	}
+
+	return nil, p.errorf("unknown function %s", name)
+}
+
+func mapFunc(f evalFunc) evalFunc {
+	return func(v interface{}) ([]interface{}, error) {
+		items, err := iterate(v)
+		if err != nil {
+			return nil, err
+		}
+		outs := []interface{}{}
+		for _, item := range items {
+			res, err := f(item)
+			if err != nil {
+				return nil, err
+			}
+			outs = append(outs, res...)
+		}
+		return []interface{}{outs}, nil
+	}
+}
+
+func selectFunc(cond evalFunc) evalFunc {
+	return func(v interface{}) ([]interface{}, error) {
+		res, err := cond(v)
+		if err != nil {
+			return nil, err
+		}
+		var outs []interface{}
+		for _, r := range res {
+			if r != nil && r != false {
+				outs = append(outs, v)
+			}
+		}
+		return outs, nil
+	}
+}
+
+// parseObject parses {key: expr, "key": expr, key, ...}
+func (p *parser) parseObject() (evalFunc, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+
+	type field struct {
+		key  string
+		eval evalFunc
+	}
+	var fields []field
+
+	for !p.isPunct("}") {
+		if p.tok.kind != tokIdent && p.tok.kind != tokString {
+			return nil, p.errorf("expected an object key")
+		}
+		key := p.tok.text
+		p.next()
+
+		eval := index(key)
+		if p.isPunct(":") {
+			p.next()
+			var err error
+			if eval, err = p.parsePostfix(); err != nil {
+				return nil, err
+			}
+		}
+		fields = append(fields, field{key, eval})
+
+		if !p.isPunct(",") {
+			break
+		}
+		p.next()
+	}
+
+	if err := p.expect("}"); err != nil {
+		return nil, err
+	}
+
+	return func(v interface{}) ([]interface{}, error) {
+		// every combination of field outputs produces an object
+		objs := []map[string]interface{}{{}}
+		for _, f := range fields {
+			values, err := f.eval(v)
+			if err != nil {
+				return nil, err
+			}
+
+			var next []map[string]interface{}
+			for _, obj := range objs {
+				for _, value := range values {
+					o := make(map[string]interface{}, len(obj)+1)
+					for k, x := range obj {
+						o[k] = x
+					}
+					o[f.key] = value
+					next = append(next, o)
+				}
+			}
+			objs = next
+		}
+
+		outs := make([]interface{}, len(objs))
+		for i, obj := range objs {
+			outs[i] = obj
+		}
+		return outs, nil
+	}, nil
+}
@@ -0,0 +1,53 @@
+package jq
+
+import "testing"
+
+func TestTransform(t *testing.T) {
+	doc := []byte(`{"items":[{"id":1,"name":"a","tags":["x"]},{"id":2,"name":"b","tags":[]}],"total":2,"meta":{"next":null}}`)
+
+	testCases := []struct {
+		query    string
+		expected string
+	}{
+		{".", `{"items":[{"id":1,"name":"a","tags":["x"]},{"id":2,"name":"b","tags":[]}],"meta":{"next":null},"total":2}` + "\n"},
+		{".total", "2\n"},
+		{".items[0].name", `"a"` + "\n"},
+		{".items[-1].id", "2\n"},
+		{".items[].id", "1\n2\n"},
+		{".items[] | {id, title: .name}", `{"id":1,"title":"a"}` + "\n" + `{"id":2,"title":"b"}` + "\n"},
+		{"[.items[].name]", `["a","b"]` + "\n"},
+		{".items | map(.id)", "[1,2]\n"},
+		{".items[] | select(.id > 1) | .name", `"b"` + "\n"},
+		{".items[] | select(.name == \"a\") | .tags", `["x"]` + "\n"},
+		{".meta.next.deeper", "null\n"},
+		{".[\"total\"]", "2\n"},
+		{"{total: true, none: null}", `{"none":null,"total":true}` + "\n"},
+	}
+
+	for _, testCase := range testCases {
+		q, err := Compile(testCase.query)
+		if err != nil {
+			t.Errorf("query=%s: %v", testCase.query, err)
+			continue
+		}
+
+		out, err := q.Transform(doc)
+		if err != nil {
+			t.Errorf("query=%s: %v", testCase.query, err)
+			continue
+		}
+
+		if string(out) != testCase.expected {
+			t.Errorf("query=%s expected %q received %q", testCase.query, testCase.expected, out)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	// queries outside of the subset fail as well
+	for _, query := range []string{".items[", "unknown(.)", "{a:", ". | ", `."key`, ".a, .b", ".a[]?", "keys", "length", ".a + 1", ".a as $x | $x"} {
+		if _, err := Compile(query); err == nil {
+			t.Errorf("query=%s expected an error", query)
+		}
+	}
+}
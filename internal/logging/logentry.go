@@ -12,6 +12,13 @@ type LogEntry struct {
 	Result   bool          // whether or not the file was downloaded
 	NBytes   uint64        // number of bytes of the downloaded file
 	Duration time.Duration // how much time this download needed
+
+	Error      string // error of the last failed attempt
+	Err        error  // the error itself, for errors.Is and errors.As
+	StatusCode int    // http status code of the last response
+	Attempts   int    // number of attempts that were made
+	Capped     bool   // not downloaded because a limit of its host was reached
+	Skipped    bool   // not downloaded because the file exists already
 }
 
 // Print prints a LogEntry
@@ -0,0 +1,163 @@
+// Package metadb remembers the validators (ETag and Last-Modified) of
+// downloaded files, so that later runs can ask the servers whether the files
+// changed instead of downloading them again
+package metadb
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Record describes a downloaded file
+type Record struct {
+	Name         string // path of the file relative to the output directory
+	Size         int64  // size of the file
+	ETag         string // ETag header of the response, if any
+	LastModified string // Last-Modified header of the response, if any
+}
+
+// DB is a map of urls to records that is stored in a file. Every change is
+// appended to the file as a line
+// "url<TAB>name<TAB>size<TAB>etag<TAB>last-modified", a line with only the url
+// removes the url again. The file is compacted when it is opened. A DB is
+// safe for concurrent use.
+type DB struct {
+	lock    sync.Mutex
+	records map[string]Record
+	file    *os.File
+}
+
+// Open loads the records stored in path
+func Open(path string) (*DB, error) {
+	db := &DB{records: make(map[string]Record)}
+
+	if err := db.load(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// rewrite the file with the current records only
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	for url, record := range db.records {
+		if _, err = w.WriteString(line(url, record)); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err = f.Close(); err != nil {
+		return nil, err
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return nil, err
+	}
+
+	if db.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func (db *DB) load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), "\t")
+		if len(fields) == 1 {
+			delete(db.records, fields[0])
+			continue
+		}
+		if len(fields) != 5 {
+			continue
+		}
+
+		size, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		db.records[fields[0]] = Record{Name: fields[1], Size: size, ETag: fields[3], LastModified: fields[4]}
+	}
+
+	return scanner.Err()
+}
+
+func line(url string, record Record) string {
+	return fmt.Sprintf("%s\t%s\t%d\t%s\t%s\n", url, record.Name, record.Size, record.ETag, record.LastModified)
+}
+
+// Get returns the record of url
+func (db *DB) Get(url string) (Record, bool) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	record, ok := db.records[url]
+	return record, ok
+}
+
+// Put stores the record of url
+func (db *DB) Put(url string, record Record) error {
+	for _, s := range []string{url, record.Name, record.ETag, record.LastModified} {
+		if strings.ContainsAny(s, "\t\n") {
+			return fmt.Errorf("metadb: %q contains a tab or newline", s)
+		}
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if db.records[url] == record {
+		return nil
+	}
+	db.records[url] = record
+
+	_, err := db.file.WriteString(line(url, record))
+	return err
+}
+
+// Delete removes the record of url
+func (db *DB) Delete(url string) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if _, ok := db.records[url]; !ok {
+		return nil
+	}
+	delete(db.records, url)
+
+	_, err := db.file.WriteString(url + "\n")
+	return err
+}
+
+// Len returns the number of records
+func (db *DB) Len() int {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return len(db.records)
+}
+
+// Close closes the file of the DB
+func (db *DB) Close() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	return db.file.Close()
+}
@@ -0,0 +1,68 @@
+package metadb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "metadb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "meta.tsv")
+
+	// an overwritten record, a removed record and garbage
+	content := "http://a\ta.txt\t3\t\"v1\"\t\n" +
+		"http://a\ta.txt\t4\t\"v2\"\tMon, 02 Jan 2006 15:04:05 GMT\n" +
+		"http://b\tb.txt\t1\t\t\n" +
+		"http://b\n" +
+		"broken line\n" +
+		"http://c\tc.txt\tnot a size\t\t\n"
+	if err = ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if db.Len() != 1 {
+		t.Errorf("expected 1 record received %d", db.Len())
+	}
+	expected := Record{Name: "a.txt", Size: 4, ETag: `"v2"`, LastModified: "Mon, 02 Jan 2006 15:04:05 GMT"}
+	if record, ok := db.Get("http://a"); !ok || record != expected {
+		t.Errorf("expected %+v received %+v %v", expected, record, ok)
+	}
+
+	if err = db.Put("http://d", Record{Name: "sub/d.txt", Size: 10, ETag: `W/"x"`}); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Delete("http://a"); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Put("http://e", Record{Name: "e\tf"}); err == nil {
+		t.Error("expected an error for a name with a tab")
+	}
+	if err = db.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the changes survive reopening
+	db, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, ok := db.Get("http://a"); ok {
+		t.Error("expected http://a to be removed")
+	}
+	if record, ok := db.Get("http://d"); !ok || record.Name != "sub/d.txt" || record.Size != 10 || record.ETag != `W/"x"` {
+		t.Errorf("expected http://d to be stored, received %+v %v", record, ok)
+	}
+}
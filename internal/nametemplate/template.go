@@ -0,0 +1,109 @@
+package nametemplate
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// placeholder matches "{name}" and "{fn(url)}"
+var placeholder = regexp.MustCompile(`\{([a-z0-9]+(?:\(url\))?)\}`)
+
+// unsafeChars are replaced in values that end up in a single path element
+var unsafeChars = regexp.MustCompile(`[<>:"|?*\\/\x00-\x1f]`)
+
+// Placeholders lists the placeholders a template may contain
+var Placeholders = []string{
+	"host", "path", "dir", "basename", "name", "ext", "index", "date",
+	"md5(url)", "sha1(url)", "sha256(url)",
+}
+
+// Template builds output paths from urls, e.g. "{host}/{path}" or
+// "{date}/{index}.{ext}"
+type Template struct {
+	raw string
+}
+
+// Parse checks that s only contains known placeholders and returns its Template
+func Parse(s string) (*Template, error) {
+	for _, m := range placeholder.FindAllStringSubmatch(s, -1) {
+		known := false
+		for _, name := range Placeholders {
+			known = known || m[1] == name
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown placeholder %s in name template %q", m[0], s)
+		}
+	}
+
+	if strings.TrimSpace(s) == "" {
+		return nil, fmt.Errorf("empty name template")
+	}
+
+	return &Template{raw: s}, nil
+}
+
+// Execute returns the relative output path for the url u at position index of
+// the input list. The result never leaves the output directory.
+func (t *Template) Execute(u *url.URL, index int, now time.Time) string {
+	urlPath := strings.Trim(path.Clean("/"+u.Path), "/")
+	basename := path.Base("/" + urlPath)
+	if basename == "/" {
+		basename = "index"
+	}
+	ext := path.Ext(basename)
+	dir := path.Dir("/" + urlPath)
+
+	values := map[string]string{
+		"host":        u.Hostname(),
+		"path":        urlPath,
+		"dir":         strings.Trim(dir, "/"),
+		"basename":    basename,
+		"name":        strings.TrimSuffix(basename, ext),
+		"ext":         strings.TrimPrefix(ext, "."),
+		"index":       strconv.Itoa(index),
+		"date":        now.Format("2006-01-02"),
+		"md5(url)":    fmt.Sprintf("%x", md5.Sum([]byte(u.String()))),
+		"sha1(url)":   fmt.Sprintf("%x", sha1.Sum([]byte(u.String()))),
+		"sha256(url)": fmt.Sprintf("%x", sha256.Sum256([]byte(u.String()))),
+	}
+	if values["path"] == "" {
+		values["path"] = basename
+	}
+
+	name := placeholder.ReplaceAllStringFunc(t.raw, func(m string) string {
+		key := m[1 : len(m)-1]
+		v := values[key]
+		// only path and dir may introduce directories
+		if key != "path" && key != "dir" {
+			v = unsafeChars.ReplaceAllString(v, "_")
+		}
+		return v
+	})
+
+	return clean(name)
+}
+
+// clean turns name into a relative path without empty, "." or ".." elements
+func clean(name string) string {
+	var elems []string
+	for _, e := range strings.Split(strings.Replace(name, "\\", "/", -1), "/") {
+		if e == "" || e == "." || e == ".." {
+			continue
+		}
+		elems = append(elems, e)
+	}
+
+	if len(elems) == 0 {
+		return "index"
+	}
+
+	return strings.Join(elems, "/")
+}
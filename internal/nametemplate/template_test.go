@@ -0,0 +1,52 @@
+package nametemplate
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestExecute(t *testing.T) {
+	now := time.Date(2021, 3, 4, 10, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		template string
+		url      string
+		index    int
+		expected string
+	}{
+		{"{basename}", "https://example.com/a/b/c.jpg?x=1", 0, "c.jpg"},
+		{"{host}/{path}", "https://example.com/a/b/c.jpg", 0, "example.com/a/b/c.jpg"},
+		{"{host}/{dir}/{index}.{ext}", "https://example.com/a/b/c.jpg", 7, "example.com/a/b/7.jpg"},
+		{"{date}/{name}-{index}.{ext}", "https://example.com/c.tar.gz", 3, "2021-03-04/c.tar-3.gz"},
+		{"{sha1(url)}", "https://example.com/", 0, "b559c7edd3fb67374c1a25e739cdd7edd1d79949"},
+		{"{basename}", "https://example.com/", 0, "index"},
+		{"{path}", "https://example.com/../../etc/passwd", 0, "etc/passwd"},
+		{"../{host}/{basename}", "https://example.com/x", 0, "example.com/x"},
+	}
+
+	for _, testCase := range testCases {
+		tmpl, err := Parse(testCase.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		u, err := url.Parse(testCase.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if name := tmpl.Execute(u, testCase.index, now); name != testCase.expected {
+			t.Errorf("template=%s url=%s expected %s received %s", testCase.template, testCase.url, testCase.expected, name)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	if _, err := Parse("{host}/{unknown}"); err == nil {
+		t.Error("expected an error for an unknown placeholder")
+	}
+	if _, err := Parse("{host}/{sha1(url)}"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,141 @@
+// Package ndjson appends records to rotating newline delimited JSON files
+package ndjson
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Record is a single line of the output files
+type Record struct {
+	Url        string            `json:"url"`
+	Status     int               `json:"status"`
+	Time       time.Time         `json:"time"`
+	Body       json.RawMessage   `json:"body"`
+	BodyFormat string            `json:"bodyFormat"` // json, text or base64
+	Headers    map[string]string `json:"headers,omitempty"`
+}
+
+// NewRecord creates the record of a response. Bodies that are valid JSON are
+// embedded as they are, other text as a string and binary data as base64.
+func NewRecord(url string, status int, body []byte) Record {
+	rec := Record{Url: url, Status: status, Time: time.Now().UTC()}
+
+	var s string
+	switch {
+	case len(body) > 0 && json.Valid(body):
+		rec.Body, rec.BodyFormat = body, "json"
+		return rec
+	case utf8.Valid(body):
+		s, rec.BodyFormat = string(body), "text"
+	default:
+		s, rec.BodyFormat = base64.StdEncoding.EncodeToString(body), "base64"
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	_ = enc.Encode(s)
+	rec.Body = bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+	return rec
+}
+
+// Writer appends records to dir/part-NNNNN.ndjson and starts a new file once
+// the current one has reached maxSize bytes. It is safe for concurrent use.
+type Writer struct {
+	dir     string
+	maxSize int64
+
+	lock sync.Mutex
+	file *os.File
+	size int64
+	next int
+}
+
+// New creates a Writer. The numbering continues after the files that already
+// exist in dir, so that earlier output is never overwritten.
+func New(dir string, maxSize int64) (*Writer, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	existing, err := filepath.Glob(filepath.Join(dir, "part-*.ndjson"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(existing)
+
+	w := &Writer{dir: dir, maxSize: maxSize}
+	if len(existing) > 0 {
+		var n int
+		if _, err = fmt.Sscanf(filepath.Base(existing[len(existing)-1]), "part-%d.ndjson", &n); err == nil {
+			w.next = n + 1
+		}
+	}
+
+	return w, nil
+}
+
+// Write appends rec as a single line
+func (w *Writer) Write(rec Record) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(rec); err != nil {
+		return err
+	}
+	line := buf.Bytes()
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.file == nil || (w.maxSize > 0 && w.size > 0 && w.size+int64(len(line)) > w.maxSize) {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := w.file.Write(line)
+	w.size += int64(n)
+	return err
+}
+
+// rotate closes the current file and opens the next one
+func (w *Writer) rotate() error {
+	if w.file != nil {
+		if err := w.file.Close(); err != nil {
+			return err
+		}
+	}
+
+	name := filepath.Join(w.dir, fmt.Sprintf("part-%05d.ndjson", w.next))
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	w.file, w.size = f, 0
+	w.next++
+	return nil
+}
+
+// Close closes the current file
+func (w *Writer) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
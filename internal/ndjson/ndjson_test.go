@@ -0,0 +1,92 @@
+package ndjson
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewRecord(t *testing.T) {
+	testCases := []struct {
+		body           []byte
+		expectedBody   string
+		expectedFormat string
+	}{
+		{[]byte(`{"a": 1}`), `{"a": 1}`, "json"},
+		{[]byte("hello"), `"hello"`, "text"},
+		{[]byte{}, `""`, "text"},
+		{[]byte{0xff, 0x00}, `"/wA="`, "base64"},
+	}
+
+	for _, testCase := range testCases {
+		rec := NewRecord("http://example.com", 200, testCase.body)
+		if string(rec.Body) != testCase.expectedBody || rec.BodyFormat != testCase.expectedFormat {
+			t.Errorf("body=%q expected %s/%s received %s/%s", testCase.body,
+				testCase.expectedBody, testCase.expectedFormat, rec.Body, rec.BodyFormat)
+		}
+	}
+}
+
+func TestWriterRotates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ndjson")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := New(dir, 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err = w.Write(NewRecord("http://example.com/x", 200, []byte(`[1,2,3]`))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.ndjson"))
+	if len(files) < 2 {
+		t.Fatalf("expected the output to be rotated, received %d files", len(files))
+	}
+
+	lines := 0
+	for _, name := range files {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var rec Record
+			if err = json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+				t.Errorf("%s: %v", name, err)
+			}
+			lines++
+		}
+		f.Close()
+	}
+	if lines != 5 {
+		t.Errorf("expected 5 records received %d", lines)
+	}
+
+	// a second writer continues after the existing files
+	w, err = New(dir, 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Write(NewRecord("http://example.com/y", 404, nil)); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	after, _ := filepath.Glob(filepath.Join(dir, "*.ndjson"))
+	if len(after) != len(files)+1 {
+		t.Errorf("expected %d files received %d", len(files)+1, len(after))
+	}
+}
@@ -0,0 +1,173 @@
+// Package negcache remembers urls that permanently failed, so that later
+// runs can skip them
+package negcache
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Entry is a failed url of the cache
+type Entry struct {
+	Status int       // the status code the url was answered with
+	Time   time.Time // when the url failed
+}
+
+// Cache is a set of failed urls that is stored in a file. Every change is
+// appended to the file as a line "unix time<TAB>status<TAB>url", a status of
+// 0 removes the url again. The file is compacted when it is opened. A Cache
+// is safe for concurrent use.
+type Cache struct {
+	ttl time.Duration
+
+	lock    sync.Mutex
+	entries map[string]Entry
+	file    *os.File
+}
+
+// Open loads the cache stored in path. Entries older than ttl are dropped,
+// a ttl of 0 keeps them forever.
+func Open(path string, ttl time.Duration) (*Cache, error) {
+	c := &Cache{ttl: ttl, entries: make(map[string]Entry)}
+
+	if err := c.load(path); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// rewrite the file with the entries that are still valid
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	w := bufio.NewWriter(f)
+	for url, entry := range c.entries {
+		if _, err = fmt.Fprintf(w, "%d\t%d\t%s\n", entry.Time.Unix(), entry.Status, url); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if err = w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err = f.Close(); err != nil {
+		return nil, err
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return nil, err
+	}
+
+	if c.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (c *Cache) load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), "\t", 3)
+		if len(fields) != 3 {
+			continue
+		}
+
+		sec, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		status, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+
+		entry := Entry{Status: status, Time: time.Unix(sec, 0)}
+		if status == 0 || c.expired(entry) {
+			delete(c.entries, fields[2])
+			continue
+		}
+		c.entries[fields[2]] = entry
+	}
+
+	return scanner.Err()
+}
+
+func (c *Cache) expired(entry Entry) bool {
+	return c.ttl > 0 && time.Since(entry.Time) > c.ttl
+}
+
+// Get returns the entry of url, if it failed within the ttl
+func (c *Cache) Get(url string) (Entry, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.entries[url]
+	if ok && c.expired(entry) {
+		return Entry{}, false
+	}
+	return entry, ok
+}
+
+// Put records that url failed with status
+func (c *Cache) Put(url string, status int) error {
+	return c.append(url, Entry{Status: status, Time: time.Now()})
+}
+
+// Delete removes url from the cache
+func (c *Cache) Delete(url string) error {
+	c.lock.Lock()
+	_, ok := c.entries[url]
+	c.lock.Unlock()
+
+	if !ok {
+		return nil
+	}
+	return c.append(url, Entry{Time: time.Now()})
+}
+
+func (c *Cache) append(url string, entry Entry) error {
+	if strings.ContainsAny(url, "\t\n") {
+		return fmt.Errorf("negcache: url %q contains a tab or newline", url)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if entry.Status == 0 {
+		delete(c.entries, url)
+	} else {
+		c.entries[url] = entry
+	}
+
+	_, err := fmt.Fprintf(c.file, "%d\t%d\t%s\n", entry.Time.Unix(), entry.Status, url)
+	return err
+}
+
+// Len returns the number of urls in the cache
+func (c *Cache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.entries)
+}
+
+// Close closes the file of the cache
+func (c *Cache) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.file.Close()
+}
@@ -0,0 +1,71 @@
+package negcache
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "negcache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "cache.tsv")
+
+	// an expired entry, a removed entry and garbage
+	old := time.Now().Add(-48 * time.Hour).Unix()
+	recent := time.Now().Add(-time.Hour).Unix()
+	content := fmt.Sprintf("%d\t404\thttp://old\n%d\t410\thttp://gone\n%d\t404\thttp://fixed\n%d\t0\thttp://fixed\nbroken line\n", old, recent, recent, recent)
+	if err = ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := Open(path, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Len() != 1 {
+		t.Errorf("expected 1 entry received %d", c.Len())
+	}
+	if entry, ok := c.Get("http://gone"); !ok || entry.Status != 410 {
+		t.Errorf("expected http://gone to be cached with 410, received %v %v", entry, ok)
+	}
+	for _, url := range []string{"http://old", "http://fixed"} {
+		if _, ok := c.Get(url); ok {
+			t.Errorf("expected %s not to be cached", url)
+		}
+	}
+
+	if err = c.Put("http://new", 404); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Delete("http://gone"); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the changes survive reopening
+	c, err = Open(path, 24*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if _, ok := c.Get("http://new"); !ok {
+		t.Error("expected http://new to be cached")
+	}
+	if _, ok := c.Get("http://gone"); ok {
+		t.Error("expected http://gone to be removed")
+	}
+	if c.Len() != 1 {
+		t.Errorf("expected 1 entry received %d", c.Len())
+	}
+}
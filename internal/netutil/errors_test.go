@@ -0,0 +1,47 @@
+package netutil
+
+import (
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"syscall"
+	"testing"
+)
+
+func TestIsConnectError(t *testing.T) {
+	testCases := []struct {
+		err      error
+		expected bool
+	}{
+		{nil, false},
+		{&net.DNSError{Err: "no such host", Name: "example.invalid"}, true},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, true},
+		{&url.Error{Op: "Get", URL: "http://x", Err: &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}}, true},
+		{&net.OpError{Op: "proxyconnect", Net: "tcp", Err: syscall.ECONNREFUSED}, true},
+		{&url.Error{Op: "Get", URL: "https://x", Err: x509.UnknownAuthorityError{}}, true},
+		{errors.New("net/http: TLS handshake timeout"), true},
+		{errors.New("remote error: tls: handshake failure"), true},
+		{&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, false},
+		{io.ErrUnexpectedEOF, false},
+		{errors.New("checksum mismatch"), false},
+	}
+
+	for _, testCase := range testCases {
+		if received := IsConnectError(testCase.err); received != testCase.expected {
+			t.Errorf("%v expected %v received %v", testCase.err, testCase.expected, received)
+		}
+	}
+
+	// a real refused connection
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	if _, err = net.Dial("tcp", addr); !IsConnectError(err) {
+		t.Errorf("refused connection %v is not a connect error", err)
+	}
+}
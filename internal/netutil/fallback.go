@@ -0,0 +1,49 @@
+package netutil
+
+import (
+	"context"
+	"log"
+	"net"
+)
+
+// PortFallback wraps dial so that a host that can't be connected to on the
+// port of addr is tried on the given ports in turn, e.g. 8080 and 443 on
+// networks that block port 80. The error of the first attempt is returned if
+// all of them fail.
+func PortFallback(dial DialFunc, ports []string) DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, network, addr)
+		if err == nil || len(ports) == 0 {
+			return conn, err
+		}
+
+		host, port, splitErr := net.SplitHostPort(addr)
+		if splitErr != nil {
+			return nil, err
+		}
+		for _, fallback := range ports {
+			if fallback == port || ctx.Err() != nil {
+				continue
+			}
+			if conn, fallbackErr := dial(ctx, network, net.JoinHostPort(host, fallback)); fallbackErr == nil {
+				log.Printf("[DIAL] %s unreachable, connected to port %s instead", addr, fallback)
+				return conn, nil
+			}
+		}
+
+		return nil, err
+	}
+}
+
+// AddressFamily returns "IPv4" or "IPv6" for the address of a connection, ""
+// for other addresses like unix sockets
+func AddressFamily(addr net.Addr) string {
+	tcp, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return ""
+	}
+	if tcp.IP.To4() != nil {
+		return "IPv4"
+	}
+	return "IPv6"
+}
@@ -0,0 +1,67 @@
+package netutil
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+)
+
+func TestPortFallback(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	open := strconv.Itoa(l.Addr().(*net.TCPAddr).Port)
+
+	// a port that was just in use is most likely closed
+	closedListener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := strconv.Itoa(closedListener.Addr().(*net.TCPAddr).Port)
+	closedListener.Close()
+
+	dialer := &net.Dialer{}
+	testCases := []struct {
+		port     string
+		ports    []string
+		expected string // the port connected to, "" for an error
+	}{
+		{open, []string{closed}, open},
+		{closed, []string{closed, open}, open},
+		{closed, nil, ""},
+		{closed, []string{closed}, ""},
+	}
+
+	for _, testCase := range testCases {
+		dial := PortFallback(dialer.DialContext, testCase.ports)
+		conn, err := dial(context.Background(), "tcp", net.JoinHostPort("127.0.0.1", testCase.port))
+		received := ""
+		if err == nil {
+			received = strconv.Itoa(conn.RemoteAddr().(*net.TCPAddr).Port)
+			conn.Close()
+		}
+		if received != testCase.expected {
+			t.Errorf("%s %v: expected %q received %q (%v)", testCase.port, testCase.ports, testCase.expected, received, err)
+		}
+	}
+}
+
+func TestAddressFamily(t *testing.T) {
+	testCases := []struct {
+		addr     net.Addr
+		expected string
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 80}, "IPv4"},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 80}, "IPv6"},
+		{&net.UnixAddr{Name: "/tmp/socket", Net: "unix"}, ""},
+	}
+
+	for _, testCase := range testCases {
+		if received := AddressFamily(testCase.addr); received != testCase.expected {
+			t.Errorf("%v: expected %q received %q", testCase.addr, testCase.expected, received)
+		}
+	}
+}
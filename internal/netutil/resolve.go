@@ -0,0 +1,132 @@
+package netutil
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// probeTimeout is how long the latency probe waits for a candidate address
+const probeTimeout = 3 * time.Second
+
+// DialFunc is the signature of net.Dialer.DialContext
+type DialFunc func(ctx context.Context, network, addr string) (net.Conn, error)
+
+// Resolver overrides the addresses that host:port pairs are connected to,
+// like curl's --resolve option. When several candidate addresses are given
+// for a host:port, they are ordered by the time it takes to connect to them
+// the first time they are needed.
+type Resolver struct {
+	lock       sync.Mutex
+	candidates map[string][]string // host:port -> addresses with port
+	ordered    map[string]bool     // whether candidates[host:port] has been probed
+}
+
+// NewResolver returns a Resolver without any overrides
+func NewResolver() *Resolver {
+	return &Resolver{candidates: make(map[string][]string), ordered: make(map[string]bool)}
+}
+
+// Add adds an override in the form "host:port:address[,address...]".
+// IPv6 addresses may be enclosed in brackets.
+func (r *Resolver) Add(spec string) error {
+	parts := strings.SplitN(spec, ":", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return fmt.Errorf("invalid resolve entry %q, expected host:port:address", spec)
+	}
+
+	hostport := net.JoinHostPort(strings.ToLower(parts[0]), parts[1])
+	var addrs []string
+	for _, a := range strings.Split(parts[2], ",") {
+		a = strings.Trim(strings.TrimSpace(a), "[]")
+		if net.ParseIP(a) == nil {
+			return fmt.Errorf("invalid address %q in resolve entry %q", a, spec)
+		}
+		addrs = append(addrs, net.JoinHostPort(a, parts[1]))
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.candidates[hostport] = append(r.candidates[hostport], addrs...)
+	r.ordered[hostport] = len(r.candidates[hostport]) < 2
+
+	return nil
+}
+
+// addresses returns the candidate addresses for hostport, fastest first, or
+// nil if there is no override for it
+func (r *Resolver) addresses(ctx context.Context, dial DialFunc, network, hostport string) []string {
+	r.lock.Lock()
+	addrs, ordered := r.candidates[strings.ToLower(hostport)], r.ordered[strings.ToLower(hostport)]
+	r.lock.Unlock()
+
+	if len(addrs) == 0 || ordered {
+		return addrs
+	}
+
+	latencies := make(map[string]time.Duration, len(addrs))
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+
+	for _, a := range addrs {
+		wg.Add(1)
+		go func(a string) {
+			defer wg.Done()
+
+			probeCtx, cancel := context.WithTimeout(ctx, probeTimeout)
+			defer cancel()
+
+			start := time.Now()
+			latency := probeTimeout
+			if conn, err := dial(probeCtx, network, a); err == nil {
+				latency = time.Since(start)
+				_ = conn.Close()
+			}
+
+			lock.Lock()
+			latencies[a] = latency
+			lock.Unlock()
+		}(a)
+	}
+	wg.Wait()
+
+	sorted := append([]string(nil), addrs...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return latencies[sorted[i]] < latencies[sorted[j]]
+	})
+	log.Printf("[RESOLVE] %s candidates by latency: %v", hostport, sorted)
+
+	r.lock.Lock()
+	r.candidates[strings.ToLower(hostport)] = sorted
+	r.ordered[strings.ToLower(hostport)] = true
+	r.lock.Unlock()
+
+	return sorted
+}
+
+// Dialer wraps dial so that overridden host:port pairs are connected to their
+// candidate addresses, trying the next one whenever a connection fails
+func (r *Resolver) Dialer(dial DialFunc) DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		addrs := r.addresses(ctx, dial, network, addr)
+		if len(addrs) == 0 {
+			return dial(ctx, network, addr)
+		}
+
+		var err error
+		for _, a := range addrs {
+			var conn net.Conn
+			if conn, err = dial(ctx, network, a); err == nil {
+				return conn, nil
+			}
+		}
+
+		return nil, err
+	}
+}
@@ -0,0 +1,65 @@
+package netutil
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+)
+
+func TestResolverAdd(t *testing.T) {
+	testCases := []struct {
+		spec      string
+		expectErr bool
+	}{
+		{"example.com:443:127.0.0.1", false},
+		{"example.com:80:10.0.0.1,10.0.0.2", false},
+		{"example.com:443:[::1]", false},
+		{"example.com:443", true},
+		{"example.com:443:not-an-ip", true},
+	}
+
+	for _, testCase := range testCases {
+		err := NewResolver().Add(testCase.spec)
+		if (err != nil) != testCase.expectErr {
+			t.Errorf("spec=%#v returned err=%v", testCase.spec, err)
+		}
+	}
+}
+
+func TestResolverDialer(t *testing.T) {
+	r := NewResolver()
+	if err := r.Add("Example.com:80:10.0.0.1,10.0.0.2"); err != nil {
+		t.Fatal(err)
+	}
+
+	var dialed []string
+	var lock sync.Mutex
+	dial := func(ctx context.Context, network, addr string) (net.Conn, error) {
+		lock.Lock()
+		dialed = append(dialed, addr)
+		lock.Unlock()
+		if addr == "10.0.0.2:80" {
+			c, _ := net.Pipe()
+			return c, nil
+		}
+		return nil, errors.New("unreachable")
+	}
+
+	conn, err := r.Dialer(dial)(context.Background(), "tcp", "example.com:80")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = conn.Close()
+
+	// after probing both candidates the reachable one must be dialed first
+	if last := dialed[len(dialed)-1]; last != "10.0.0.2:80" || len(dialed) != 3 {
+		t.Errorf("dialed %v", dialed)
+	}
+
+	dialed = nil
+	if _, err = r.Dialer(dial)(context.Background(), "tcp", "other.com:80"); err == nil || dialed[0] != "other.com:80" {
+		t.Errorf("hosts without override must be dialed directly, dialed %v", dialed)
+	}
+}
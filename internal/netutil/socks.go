@@ -0,0 +1,137 @@
+package netutil
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// SOCKS5Dialer returns a DialFunc that connects to the destinations through
+// the SOCKS5 proxy at proxyAddr, using dial to reach the proxy. Host names
+// are sent to the proxy unresolved. A non-empty username is sent with
+// username/password authentication.
+func SOCKS5Dialer(dial DialFunc, proxyAddr, username, password string) DialFunc {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := dial(ctx, "tcp", proxyAddr)
+		if err != nil {
+			return nil, err
+		}
+
+		if deadline, ok := ctx.Deadline(); ok {
+			conn.SetDeadline(deadline)
+		} else {
+			conn.SetDeadline(time.Now().Add(30 * time.Second))
+		}
+		if err = socks5Connect(conn, addr, username, password); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("socks5 proxy %s: %v", proxyAddr, err)
+		}
+		conn.SetDeadline(time.Time{})
+
+		return conn, nil
+	}
+}
+
+// socks5Replies are the messages of the SOCKS5 reply codes
+var socks5Replies = []string{
+	"succeeded",
+	"general SOCKS server failure",
+	"connection not allowed by ruleset",
+	"network unreachable",
+	"host unreachable",
+	"connection refused",
+	"TTL expired",
+	"command not supported",
+	"address type not supported",
+}
+
+// socks5Connect runs the handshake of RFC 1928 and RFC 1929 that makes the
+// proxy connect conn to addr
+func socks5Connect(conn net.Conn, addr, username, password string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 65535 {
+		return fmt.Errorf("invalid port in %s", addr)
+	}
+	if len(host) > 255 || len(username) > 255 || len(password) > 255 {
+		return errors.New("host name or credentials too long")
+	}
+
+	method := byte(0x00) // no authentication
+	if username != "" {
+		method = 0x02 // username/password
+	}
+	if _, err = conn.Write([]byte{5, 1, method}); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err = io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 5 || reply[1] != method {
+		return errors.New("authentication method not accepted")
+	}
+
+	if method == 0x02 {
+		auth := append([]byte{1, byte(len(username))}, username...)
+		auth = append(append(auth, byte(len(password))), password...)
+		if _, err = conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err = io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return errors.New("authentication failed")
+		}
+	}
+
+	req := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		req = append(append(req, 3, byte(len(host))), host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(append(req, 1), ip4...)
+	} else {
+		req = append(append(req, 4), ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err = conn.Write(req); err != nil {
+		return err
+	}
+
+	header := make([]byte, 4)
+	if _, err = io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0 {
+		if int(header[1]) < len(socks5Replies) {
+			return errors.New(socks5Replies[header[1]])
+		}
+		return fmt.Errorf("connect failed with code %d", header[1])
+	}
+
+	// skip the bound address
+	var skip int
+	switch header[3] {
+	case 1:
+		skip = net.IPv4len
+	case 4:
+		skip = net.IPv6len
+	case 3:
+		if _, err = io.ReadFull(conn, header[:1]); err != nil {
+			return err
+		}
+		skip = int(header[0])
+	default:
+		return errors.New("invalid address type in reply")
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
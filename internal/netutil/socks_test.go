@@ -0,0 +1,95 @@
+package netutil
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// fakeSOCKS5 accepts one connection, checks the handshake and answers with
+// the destination it was asked for
+func fakeSOCKS5(t *testing.T, username, password string, code byte) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		greeting := make([]byte, 3)
+		io.ReadFull(conn, greeting)
+		conn.Write([]byte{5, greeting[2]})
+
+		if greeting[2] == 2 {
+			b := make([]byte, 2)
+			io.ReadFull(conn, b)
+			user := make([]byte, b[1])
+			io.ReadFull(conn, user)
+			io.ReadFull(conn, b[:1])
+			pass := make([]byte, b[0])
+			io.ReadFull(conn, pass)
+			if string(user) != username || string(pass) != password {
+				conn.Write([]byte{1, 1})
+				return
+			}
+			conn.Write([]byte{1, 0})
+		}
+
+		req := make([]byte, 5)
+		io.ReadFull(conn, req)
+		host := make([]byte, req[4])
+		io.ReadFull(conn, host)
+		port := make([]byte, 2)
+		io.ReadFull(conn, port)
+
+		conn.Write([]byte{5, code, 0, 1, 127, 0, 0, 1, 0, 80})
+		if code == 0 {
+			conn.Write([]byte(net.JoinHostPort(string(host), strconv.Itoa(int(port[0])<<8|int(port[1])))))
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func TestSOCKS5Dialer(t *testing.T) {
+	var d net.Dialer
+
+	testCases := []struct {
+		username, password string
+		code               byte
+		expected           string
+	}{
+		{"", "", 0, "example.onion:8001"},
+		{"user", "secret", 0, "example.onion:8001"},
+		{"user", "wrong", 0, "authentication failed"},
+		{"", "", 5, "connection refused"},
+	}
+
+	for _, testCase := range testCases {
+		addr := fakeSOCKS5(t, "user", "secret", testCase.code)
+		dial := SOCKS5Dialer(d.DialContext, addr, testCase.username, testCase.password)
+
+		conn, err := dial(context.Background(), "tcp", "example.onion:8001")
+		if err != nil {
+			if !strings.Contains(err.Error(), testCase.expected) {
+				t.Errorf("expected %q received %v", testCase.expected, err)
+			}
+			continue
+		}
+		b, _ := ioutil.ReadAll(conn)
+		conn.Close()
+		if string(b) != testCase.expected {
+			t.Errorf("expected %q received %q", testCase.expected, b)
+		}
+	}
+}
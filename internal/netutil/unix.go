@@ -0,0 +1,80 @@
+package netutil
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// UnixScheme is the url scheme for http requests over unix domain sockets.
+// The socket path and the request path are separated by a colon, e.g.
+// http+unix:///var/run/docker.sock:/v1.41/images/json
+const UnixScheme = "http+unix"
+
+// SplitUnixPath splits the path of a http+unix url into the socket path and
+// the path of the request
+func SplitUnixPath(p string) (socket, requestPath string, err error) {
+	i := strings.Index(p, ":")
+	if i <= 0 {
+		return "", "", fmt.Errorf("invalid %s path %q, expected /path/to/socket:/request/path", UnixScheme, p)
+	}
+
+	socket, requestPath = p[:i], p[i+1:]
+	if !strings.HasPrefix(requestPath, "/") {
+		requestPath = "/" + requestPath
+	}
+
+	return socket, requestPath, nil
+}
+
+// UnixTransport is a http.RoundTripper for http+unix urls. It keeps one
+// http.Transport per socket, created from New with its dialer replaced.
+type UnixTransport struct {
+	New func() *http.Transport
+
+	lock       sync.Mutex
+	transports map[string]*http.Transport
+}
+
+// RoundTrip sends req as a plain http request over the unix socket in its url
+func (u *UnixTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	socket, requestPath, err := SplitUnixPath(req.URL.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := req.Clone(req.Context())
+	r.URL.Scheme = "http"
+	r.URL.Host = "localhost"
+	r.URL.Path = requestPath
+	r.URL.RawPath = ""
+	r.Host = "localhost"
+
+	return u.transport(socket).RoundTrip(r)
+}
+
+// transport returns the http.Transport for socket
+func (u *UnixTransport) transport(socket string) *http.Transport {
+	u.lock.Lock()
+	defer u.lock.Unlock()
+
+	if u.transports == nil {
+		u.transports = make(map[string]*http.Transport)
+	}
+
+	t, ok := u.transports[socket]
+	if !ok {
+		t = u.New()
+		dialer := &net.Dialer{}
+		t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socket)
+		}
+		t.Proxy = nil
+		u.transports[socket] = t
+	}
+
+	return t
+}
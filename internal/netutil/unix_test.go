@@ -0,0 +1,57 @@
+package netutil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUnixTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "unix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = os.RemoveAll(dir); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	socket := filepath.Join(dir, "test.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Skip(err)
+	}
+
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, "path=%s", r.URL.Path)
+	})}
+	go func() {
+		_ = server.Serve(l)
+	}()
+	defer func() {
+		_ = server.Close()
+	}()
+
+	client := &http.Client{Transport: &UnixTransport{New: func() *http.Transport { return &http.Transport{} }}}
+	response, err := client.Get(UnixScheme + "://" + socket + ":/v1/images/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = response.Body.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if string(b) != "path=/v1/images/json" {
+		t.Errorf("received %q", b)
+	}
+}
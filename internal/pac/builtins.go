@@ -0,0 +1,216 @@
+package pac
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// builtins returns the functions that browsers provide to PAC files
+func (s *Script) builtins() map[string]interface{} {
+	return map[string]interface{}{
+		"isPlainHostName": builtin(func(args []interface{}) (interface{}, error) {
+			return !strings.Contains(arg(args, 0), "."), nil
+		}),
+		"dnsDomainIs": builtin(func(args []interface{}) (interface{}, error) {
+			return strings.HasSuffix(strings.ToLower(arg(args, 0)), strings.ToLower(arg(args, 1))), nil
+		}),
+		"localHostOrDomainIs": builtin(func(args []interface{}) (interface{}, error) {
+			host, hostdom := strings.ToLower(arg(args, 0)), strings.ToLower(arg(args, 1))
+			return host == hostdom || !strings.Contains(host, ".") && strings.HasPrefix(hostdom, host+"."), nil
+		}),
+		"dnsDomainLevels": builtin(func(args []interface{}) (interface{}, error) {
+			return float64(strings.Count(arg(args, 0), ".")), nil
+		}),
+		"shExpMatch": builtin(func(args []interface{}) (interface{}, error) {
+			return shExpMatch(arg(args, 0), arg(args, 1)), nil
+		}),
+		"isResolvable": builtin(func(args []interface{}) (interface{}, error) {
+			return s.resolve(arg(args, 0)) != "", nil
+		}),
+		"dnsResolve": builtin(func(args []interface{}) (interface{}, error) {
+			if ip := s.resolve(arg(args, 0)); ip != "" {
+				return ip, nil
+			}
+			return nil, nil
+		}),
+		"isInNet": builtin(func(args []interface{}) (interface{}, error) {
+			ip := net.ParseIP(s.resolve(arg(args, 0))).To4()
+			pattern := net.ParseIP(arg(args, 1)).To4()
+			mask := net.ParseIP(arg(args, 2)).To4()
+			if ip == nil || pattern == nil || mask == nil {
+				return false, nil
+			}
+			m := net.IPMask(mask)
+			return ip.Mask(m).Equal(pattern.Mask(m)), nil
+		}),
+		"myIpAddress": builtin(func([]interface{}) (interface{}, error) {
+			return s.myIPAddress(), nil
+		}),
+		"convert_addr": builtin(func(args []interface{}) (interface{}, error) {
+			ip := net.ParseIP(arg(args, 0)).To4()
+			if ip == nil {
+				return float64(0), nil
+			}
+			return float64(uint32(ip[0])<<24 | uint32(ip[1])<<16 | uint32(ip[2])<<8 | uint32(ip[3])), nil
+		}),
+		"weekdayRange": builtin(func(args []interface{}) (interface{}, error) {
+			return s.weekdayRange(args)
+		}),
+		"timeRange": builtin(func(args []interface{}) (interface{}, error) {
+			return s.timeRange(args)
+		}),
+		"alert": builtin(func([]interface{}) (interface{}, error) {
+			return nil, nil
+		}),
+	}
+}
+
+// arg returns argument i as a string, "" if it is missing
+func arg(args []interface{}, i int) string {
+	if i >= len(args) || args[i] == nil {
+		return ""
+	}
+	return toString(args[i])
+}
+
+// shExpMatch matches str against a shell pattern with * and ?
+func shExpMatch(str, pattern string) bool {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, c := range pattern {
+		switch c {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	return err == nil && re.MatchString(str)
+}
+
+// resolve returns the first IPv4 address of host, host itself if it is an
+// address and "" if it can't be resolved
+func (s *Script) resolve(host string) string {
+	if ip := net.ParseIP(host); ip != nil {
+		return host
+	}
+
+	lookup := s.LookupHost
+	if lookup == nil {
+		lookup = net.LookupHost
+	}
+	addrs, err := lookup(host)
+	if err != nil {
+		return ""
+	}
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil && ip.To4() != nil {
+			return addr
+		}
+	}
+	return ""
+}
+
+// myIPAddress returns the first IPv4 address of the network interfaces that
+// isn't a loopback address
+func (s *Script) myIPAddress() string {
+	if s.MyIPAddress != "" {
+		return s.MyIPAddress
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err == nil {
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && !ipnet.IP.IsLoopback() && ipnet.IP.To4() != nil {
+				return ipnet.IP.String()
+			}
+		}
+	}
+	return "127.0.0.1"
+}
+
+func (s *Script) now(args []interface{}) ([]interface{}, time.Time) {
+	now := time.Now()
+	if s.Now != nil {
+		now = s.Now()
+	}
+	if len(args) > 0 && arg(args, len(args)-1) == "GMT" {
+		return args[:len(args)-1], now.UTC()
+	}
+	return args, now.Local()
+}
+
+var weekdays = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+
+// weekdayRange implements weekdayRange(wd1[, wd2][, "GMT"])
+func (s *Script) weekdayRange(args []interface{}) (bool, error) {
+	args, now := s.now(args)
+
+	day := func(i int) (int, error) {
+		for d, name := range weekdays {
+			if strings.ToUpper(arg(args, i)) == name {
+				return d, nil
+			}
+		}
+		return 0, fmt.Errorf("pac: weekdayRange: invalid day %q", arg(args, i))
+	}
+
+	switch len(args) {
+	case 1:
+		d, err := day(0)
+		return err == nil && int(now.Weekday()) == d, err
+	case 2:
+		from, err := day(0)
+		if err != nil {
+			return false, err
+		}
+		to, err := day(1)
+		if err != nil {
+			return false, err
+		}
+		return inRange(int(now.Weekday()), from, to), nil
+	}
+	return false, fmt.Errorf("pac: weekdayRange: expected 1 or 2 days, received %d arguments", len(args))
+}
+
+// timeRange implements timeRange(hour1[, hour2]) and the variants with
+// minutes and seconds, each optionally followed by "GMT"
+func (s *Script) timeRange(args []interface{}) (bool, error) {
+	args, now := s.now(args)
+
+	n := make([]int, len(args))
+	for i := range args {
+		n[i] = int(toNumber(args[i]))
+	}
+	seconds := now.Hour()*3600 + now.Minute()*60 + now.Second()
+
+	switch len(args) {
+	case 1:
+		return now.Hour() == n[0], nil
+	case 2:
+		// the end hour is inclusive
+		return inRange(seconds, n[0]*3600, n[1]*3600+3599), nil
+	case 4:
+		return inRange(seconds, n[0]*3600+n[1]*60, n[2]*3600+n[3]*60+59), nil
+	case 6:
+		return inRange(seconds, n[0]*3600+n[1]*60+n[2], n[3]*3600+n[4]*60+n[5]), nil
+	}
+	return false, fmt.Errorf("pac: timeRange: expected 1, 2, 4 or 6 numbers, received %d arguments", len(args))
+}
+
+// inRange reports whether v is within from and to, which wraps around if to
+// is smaller than from
+func inRange(v, from, to int) bool {
+	if from <= to {
+		return v >= from && v <= to
+	}
+	return v >= from || v <= to
+}
@@ -0,0 +1,554 @@
+package pac
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Values are float64, string, bool, nil (null and undefined), *closure and
+// builtin
+
+type builtin func(args []interface{}) (interface{}, error)
+
+type closure struct {
+	f     *function
+	scope *scope
+}
+
+// scope holds the variables of a function call, or the globals
+type scope struct {
+	vars   map[string]interface{}
+	parent *scope
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{vars: map[string]interface{}{}, parent: parent}
+}
+
+func (s *scope) lookup(name string) (interface{}, bool) {
+	for ; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// set assigns an existing variable, or creates a global one like JavaScript
+// does for undeclared names
+func (s *scope) set(name string, v interface{}) {
+	for cur := s; ; cur = cur.parent {
+		if _, ok := cur.vars[name]; ok || cur.parent == nil {
+			cur.vars[name] = v
+			return
+		}
+	}
+}
+
+// maxSteps bounds the statements a single call may execute and maxDepth the
+// calls nested in it, so that a PAC file that recurses endlessly can't hang
+// the downloads or exhaust the stack
+const (
+	maxSteps = 1000000
+	maxDepth = 100
+)
+
+// errTooManySteps is returned when a call exceeds maxSteps or maxDepth
+var errTooManySteps = errors.New("pac: script runs too long")
+
+type interp struct {
+	steps int
+	depth int
+}
+
+// control is the outcome of a statement
+type control int
+
+const (
+	ctlNone control = iota
+	ctlReturn
+)
+
+type stmt interface {
+	exec(in *interp, s *scope) (control, interface{}, error)
+}
+
+type expr interface {
+	eval(in *interp, s *scope) (interface{}, error)
+}
+
+type blockStmt struct {
+	body []stmt
+}
+
+func (b *blockStmt) exec(in *interp, s *scope) (control, interface{}, error) {
+	return in.execAll(b.body, s)
+}
+
+func (in *interp) execAll(stmts []stmt, s *scope) (control, interface{}, error) {
+	for _, st := range stmts {
+		in.steps++
+		if in.steps > maxSteps {
+			return ctlNone, nil, errTooManySteps
+		}
+		ctl, v, err := st.exec(in, s)
+		if err != nil || ctl != ctlNone {
+			return ctl, v, err
+		}
+	}
+	return ctlNone, nil, nil
+}
+
+type funcStmt struct {
+	f *function
+}
+
+func (f *funcStmt) exec(_ *interp, s *scope) (control, interface{}, error) {
+	s.vars[f.f.name] = &closure{f.f, s}
+	return ctlNone, nil, nil
+}
+
+// hoist declares the functions of stmts before they run, so that they can be
+// called before their declaration like in JavaScript
+func hoist(stmts []stmt, s *scope) {
+	for _, st := range stmts {
+		if f, ok := st.(*funcStmt); ok {
+			f.exec(nil, s)
+		}
+	}
+}
+
+type varStmt struct {
+	names  []string
+	values []expr
+}
+
+func (v *varStmt) exec(in *interp, s *scope) (control, interface{}, error) {
+	for i, name := range v.names {
+		var value interface{}
+		if v.values[i] != nil {
+			var err error
+			if value, err = v.values[i].eval(in, s); err != nil {
+				return ctlNone, nil, err
+			}
+		} else if existing, ok := s.vars[name]; ok {
+			// var x; keeps the value of an existing x
+			value = existing
+		}
+		s.vars[name] = value
+	}
+	return ctlNone, nil, nil
+}
+
+type ifStmt struct {
+	cond            expr
+	then, otherwise stmt
+}
+
+func (i *ifStmt) exec(in *interp, s *scope) (control, interface{}, error) {
+	cond, err := i.cond.eval(in, s)
+	if err != nil {
+		return ctlNone, nil, err
+	}
+	if truthy(cond) {
+		return i.then.exec(in, s)
+	}
+	if i.otherwise != nil {
+		return i.otherwise.exec(in, s)
+	}
+	return ctlNone, nil, nil
+}
+
+type returnStmt struct {
+	value expr
+}
+
+func (r *returnStmt) exec(in *interp, s *scope) (control, interface{}, error) {
+	if r.value == nil {
+		return ctlReturn, nil, nil
+	}
+	v, err := r.value.eval(in, s)
+	return ctlReturn, v, err
+}
+
+type exprStmt struct {
+	e expr
+}
+
+func (e *exprStmt) exec(in *interp, s *scope) (control, interface{}, error) {
+	_, err := e.e.eval(in, s)
+	return ctlNone, nil, err
+}
+
+type function struct {
+	name   string
+	params []string
+	body   []stmt
+}
+
+func (in *interp) call(fn interface{}, args []interface{}) (interface{}, error) {
+	switch f := fn.(type) {
+	case builtin:
+		return f(args)
+
+	case *closure:
+		if in.depth >= maxDepth {
+			return nil, errTooManySteps
+		}
+		in.depth++
+		defer func() { in.depth-- }()
+
+		s := newScope(f.scope)
+		for i, param := range f.f.params {
+			var arg interface{}
+			if i < len(args) {
+				arg = args[i]
+			}
+			s.vars[param] = arg
+		}
+		hoist(f.f.body, s)
+		_, v, err := in.execAll(f.f.body, s)
+		return v, err
+	}
+
+	return nil, fmt.Errorf("pac: %s is not a function", typeOf(fn))
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (l *literal) eval(*interp, *scope) (interface{}, error) {
+	return l.value, nil
+}
+
+type identExpr struct {
+	name string
+}
+
+func (i *identExpr) eval(_ *interp, s *scope) (interface{}, error) {
+	v, ok := s.lookup(i.name)
+	if !ok {
+		return nil, fmt.Errorf("pac: %s is not defined", i.name)
+	}
+	return v, nil
+}
+
+type assignExpr struct {
+	target string
+	value  expr
+}
+
+func (a *assignExpr) eval(in *interp, s *scope) (interface{}, error) {
+	value, err := a.value.eval(in, s)
+	if err != nil {
+		return nil, err
+	}
+	s.set(a.target, value)
+	return value, nil
+}
+
+type condExpr struct {
+	cond, then, otherwise expr
+}
+
+func (c *condExpr) eval(in *interp, s *scope) (interface{}, error) {
+	cond, err := c.cond.eval(in, s)
+	if err != nil {
+		return nil, err
+	}
+	if truthy(cond) {
+		return c.then.eval(in, s)
+	}
+	return c.otherwise.eval(in, s)
+}
+
+type unaryExpr struct {
+	op      string
+	operand expr
+}
+
+func (u *unaryExpr) eval(in *interp, s *scope) (interface{}, error) {
+	v, err := u.operand.eval(in, s)
+	if err != nil {
+		return nil, err
+	}
+	if u.op == "!" {
+		return !truthy(v), nil
+	}
+	return -toNumber(v), nil
+}
+
+type binaryExpr struct {
+	op          string
+	left, right expr
+}
+
+func (b *binaryExpr) eval(in *interp, s *scope) (interface{}, error) {
+	left, err := b.left.eval(in, s)
+	if err != nil {
+		return nil, err
+	}
+
+	// || and && return one of their operands, like in JavaScript
+	switch b.op {
+	case "||":
+		if truthy(left) {
+			return left, nil
+		}
+		return b.right.eval(in, s)
+	case "&&":
+		if !truthy(left) {
+			return left, nil
+		}
+		return b.right.eval(in, s)
+	}
+
+	right, err := b.right.eval(in, s)
+	if err != nil {
+		return nil, err
+	}
+
+	switch b.op {
+	case "+":
+		return add(left, right), nil
+	case "===":
+		return strictEquals(left, right), nil
+	case "!==":
+		return !strictEquals(left, right), nil
+	case "==":
+		return looseEquals(left, right), nil
+	case "!=":
+		return !looseEquals(left, right), nil
+	}
+
+	// <, <=, > and >= compare strings lexically and everything else as
+	// numbers
+	ls, lok := left.(string)
+	rs, rok := right.(string)
+	var cmp float64
+	if lok && rok {
+		cmp = float64(strings.Compare(ls, rs))
+	} else {
+		l, r := toNumber(left), toNumber(right)
+		if math.IsNaN(l) || math.IsNaN(r) {
+			return false, nil
+		}
+		cmp = l - r
+	}
+	switch b.op {
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	}
+	return cmp >= 0, nil
+}
+
+type callExpr struct {
+	fn   expr
+	args []expr
+}
+
+func (c *callExpr) eval(in *interp, s *scope) (interface{}, error) {
+	args := make([]interface{}, len(c.args))
+	for i, arg := range c.args {
+		v, err := arg.eval(in, s)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+
+	// methods of strings
+	if member, ok := c.fn.(*memberExpr); ok {
+		object, err := member.object.eval(in, s)
+		if err != nil {
+			return nil, err
+		}
+		return callMethod(object, member.name, args)
+	}
+
+	fn, err := c.fn.eval(in, s)
+	if err != nil {
+		return nil, err
+	}
+	return in.call(fn, args)
+}
+
+type memberExpr struct {
+	object expr
+	name   string
+}
+
+func (m *memberExpr) eval(in *interp, s *scope) (interface{}, error) {
+	object, err := m.object.eval(in, s)
+	if err != nil {
+		return nil, err
+	}
+
+	if o, ok := object.(string); ok && m.name == "length" {
+		return float64(len(o)), nil
+	}
+	if object == nil {
+		return nil, fmt.Errorf("pac: can't read %s of %s", m.name, typeOf(object))
+	}
+	return nil, nil
+}
+
+// callMethod calls the string methods that PAC files use
+func callMethod(object interface{}, name string, args []interface{}) (interface{}, error) {
+	o, ok := object.(string)
+	str := func(i int) string {
+		if i < len(args) {
+			return toString(args[i])
+		}
+		return "undefined"
+	}
+	num := func(i int, def float64) float64 {
+		if i < len(args) && args[i] != nil {
+			return toNumber(args[i])
+		}
+		return def
+	}
+
+	switch {
+	case !ok:
+	case name == "toLowerCase":
+		return strings.ToLower(o), nil
+	case name == "toUpperCase":
+		return strings.ToUpper(o), nil
+	case name == "indexOf":
+		return float64(strings.Index(o, str(0))), nil
+	case name == "lastIndexOf":
+		return float64(strings.LastIndex(o, str(0))), nil
+	case name == "substring":
+		start, end := clamp(num(0, 0), len(o)), clamp(num(1, float64(len(o))), len(o))
+		if start > end {
+			start, end = end, start
+		}
+		return o[start:end], nil
+	}
+
+	return nil, fmt.Errorf("pac: %s has no method %s", typeOf(object), name)
+}
+
+// clamp converts a string index to an int within 0 and n
+func clamp(f float64, n int) int {
+	switch {
+	case math.IsNaN(f) || f < 0:
+		return 0
+	case f > float64(n):
+		return n
+	}
+	return int(f)
+}
+
+func truthy(v interface{}) bool {
+	switch x := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return x
+	case float64:
+		return x != 0 && !math.IsNaN(x)
+	case string:
+		return x != ""
+	}
+	return true
+}
+
+func toNumber(v interface{}) float64 {
+	switch x := v.(type) {
+	case float64:
+		return x
+	case bool:
+		if x {
+			return 1
+		}
+		return 0
+	case string:
+		s := strings.TrimSpace(x)
+		if s == "" {
+			return 0
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return math.NaN()
+		}
+		return f
+	case nil:
+		return math.NaN()
+	}
+	return math.NaN()
+}
+
+func toString(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return "undefined"
+	case bool:
+		return strconv.FormatBool(x)
+	case float64:
+		if math.IsNaN(x) {
+			return "NaN"
+		}
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case string:
+		return x
+	}
+	return "function"
+}
+
+// add implements +, which concatenates if one of the operands is a string
+func add(left, right interface{}) interface{} {
+	_, ls := left.(string)
+	_, rs := right.(string)
+	if ls || rs {
+		return toString(left) + toString(right)
+	}
+	return toNumber(left) + toNumber(right)
+}
+
+func strictEquals(left, right interface{}) bool {
+	switch left.(type) {
+	case float64, string, bool, nil:
+		return left == right
+	}
+	return false
+}
+
+func looseEquals(left, right interface{}) bool {
+	if left == nil || right == nil {
+		return left == nil && right == nil
+	}
+	_, lb := left.(bool)
+	_, rb := right.(bool)
+	_, ln := left.(float64)
+	_, rn := right.(float64)
+	if lb || rb || ln != rn {
+		return toNumber(left) == toNumber(right)
+	}
+	return strictEquals(left, right)
+}
+
+func typeOf(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "undefined"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case builtin, *closure:
+		return "function"
+	}
+	return "object"
+}
@@ -0,0 +1,159 @@
+// Package pac evaluates proxy auto-config (PAC) files, which choose the proxy
+// of every request with a JavaScript function, e.g.
+//
+//	function FindProxyForURL(url, host) {
+//		if (isPlainHostName(host) || dnsDomainIs(host, ".corp.example.com"))
+//			return "DIRECT";
+//		return "PROXY proxy.example.com:8080; DIRECT";
+//	}
+//
+// The files are run by a small interpreter for the part of JavaScript that
+// PAC files are mostly written in, not by a JavaScript engine:
+//
+//   - statements: function declarations, var (let, const), if/else, return,
+//     blocks and expressions, e.g. host = host.toLowerCase();
+//   - values: strings, numbers, true, false, null and undefined
+//   - operators: = to a variable, ||, &&, !, ==, !=, ===, !==, <, <=, >,
+//     >=, + on strings and numbers, unary -, ?: and parentheses
+//   - string methods: toLowerCase, toUpperCase, indexOf, lastIndexOf and
+//     substring, and the length property
+//
+// Everything else fails when the file is loaded, among it loops, arrays,
+// objects, regular expressions and arithmetic other than +. All helper
+// functions of the PAC standard are available except dateRange. A call
+// fails once it ran a million statements or nested a hundred calls.
+package pac
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Script is a loaded PAC file, it is safe for concurrent use
+type Script struct {
+	// LookupHost resolves host names for dnsResolve, isResolvable and
+	// isInNet, net.LookupHost if nil
+	LookupHost func(host string) ([]string, error)
+
+	// MyIPAddress is returned by myIpAddress(), the first address of the
+	// network interfaces if empty
+	MyIPAddress string
+
+	// Now returns the time for weekdayRange and timeRange, time.Now if nil
+	Now func() time.Time
+
+	// the scripts may change their global variables, calls are serialized
+	lock    sync.Mutex
+	globals *scope
+}
+
+// Parse parses and runs the top level code of a PAC file, which must define
+// FindProxyForURL
+func Parse(src string) (*Script, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	stmts, err := p.parseProgram()
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Script{}
+	builtins := &scope{vars: s.builtins()}
+	s.globals = newScope(builtins)
+
+	hoist(stmts, s.globals)
+	if _, _, err = (&interp{}).execAll(stmts, s.globals); err != nil {
+		return nil, err
+	}
+	if _, ok := s.globals.vars["FindProxyForURL"].(*closure); !ok {
+		return nil, fmt.Errorf("pac: FindProxyForURL is not defined")
+	}
+
+	return s, nil
+}
+
+// FindProxy calls FindProxyForURL(url, host) and returns its result, e.g.
+// "PROXY proxy.example.com:8080; DIRECT"
+func (s *Script) FindProxy(rawURL, host string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	in := &interp{}
+	v, err := in.call(s.globals.vars["FindProxyForURL"], []interface{}{rawURL, host})
+	if err != nil {
+		return "", err
+	}
+	result, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("pac: FindProxyForURL returned %s instead of a string", typeOf(v))
+	}
+	return result, nil
+}
+
+// ParseResult parses a result of FindProxyForURL into proxy urls, in the
+// order of preference. DIRECT is returned as a nil url.
+func ParseResult(result string) ([]*url.URL, error) {
+	var proxies []*url.URL
+
+	for _, part := range strings.Split(result, ";") {
+		fields := strings.Fields(part)
+		if len(fields) == 0 {
+			continue
+		}
+
+		kind := strings.ToUpper(fields[0])
+		if kind == "DIRECT" {
+			proxies = append(proxies, nil)
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("pac: invalid proxy %q", strings.TrimSpace(part))
+		}
+
+		var scheme string
+		switch kind {
+		case "PROXY", "HTTP":
+			scheme = "http"
+		case "HTTPS":
+			scheme = "https"
+		case "SOCKS", "SOCKS5":
+			scheme = "socks5"
+		default:
+			return nil, fmt.Errorf("pac: unsupported proxy type %s", fields[0])
+		}
+		proxies = append(proxies, &url.URL{Scheme: scheme, Host: fields[1]})
+	}
+
+	if len(proxies) == 0 {
+		return nil, fmt.Errorf("pac: no proxy in %q", result)
+	}
+	return proxies, nil
+}
+
+// Proxy returns the first proxy the script chooses for req, to be used as
+// the Proxy function of an http.Transport. Like browsers do, only the scheme
+// and host of https urls are passed to the script.
+func (s *Script) Proxy(req *http.Request) (*url.URL, error) {
+	u := *req.URL
+	if u.Scheme == "https" {
+		u.Path, u.RawPath, u.RawQuery = "/", "", ""
+	}
+	u.User, u.Fragment = nil, ""
+
+	result, err := s.FindProxy(u.String(), req.URL.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	proxies, err := ParseResult(result)
+	if err != nil {
+		return nil, err
+	}
+	return proxies[0], nil
+}
@@ -0,0 +1,226 @@
+package pac
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testScript = `
+// proxies of the example network
+var proxy = "PROXY proxy.example.com:8080";
+
+function FindProxyForURL(url, host) {
+	host = host.toLowerCase();
+
+	if (isPlainHostName(host) || localHostOrDomainIs(host, "printer.example.com"))
+		return "DIRECT";
+
+	if (host === "intranet.example.com" || host.indexOf("wiki.") == 0) {
+		return "DIRECT";
+	}
+
+	/* internal networks */
+	if (isInNet(dnsResolve(host), "10.0.0.0", "255.0.0.0") || shExpMatch(host, "*.local"))
+		return "DIRECT";
+
+	if (url.substring(0, 4) == "ftp:")
+		return "SOCKS socks.example.com:1080";
+
+	if (weekdayRange("SAT", "SUN"))
+		return "DIRECT";
+
+	return fallback(proxy) + "; DIRECT";
+}
+
+function fallback(p) {
+	return dnsDomainLevels(myIpAddress()) == 3 ? p : "DIRECT";
+}
+`
+
+func TestFindProxy(t *testing.T) {
+	script, err := Parse(testScript)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script.LookupHost = func(host string) ([]string, error) {
+		if host == "build.corp" {
+			return []string{"10.1.2.3"}, nil
+		}
+		return nil, errors.New("not found")
+	}
+	script.MyIPAddress = "192.168.1.20"
+	script.Now = func() time.Time { return time.Date(2020, 11, 4, 12, 0, 0, 0, time.UTC) } // a Wednesday
+
+	testCases := []struct {
+		url      string
+		host     string
+		expected string
+	}{
+		{"http://printer/", "printer", "DIRECT"},
+		{"http://printer.example.com/", "printer.example.com", "DIRECT"},
+		{"https://Wiki.example.com/", "Wiki.example.com", "DIRECT"},
+		{"http://build.corp/a", "build.corp", "DIRECT"},
+		{"http://nas.local/a", "nas.local", "DIRECT"},
+		{"ftp://files.example.org/a", "files.example.org", "SOCKS socks.example.com:1080"},
+		{"https://example.org/", "example.org", "PROXY proxy.example.com:8080; DIRECT"},
+	}
+
+	for _, testCase := range testCases {
+		received, err := script.FindProxy(testCase.url, testCase.host)
+		if err != nil {
+			t.Errorf("%s: %v", testCase.url, err)
+			continue
+		}
+		if received != testCase.expected {
+			t.Errorf("%s: expected %q received %q", testCase.url, testCase.expected, received)
+		}
+	}
+
+	script.Now = func() time.Time { return time.Date(2020, 11, 7, 12, 0, 0, 0, time.UTC) } // a Saturday
+	if received, _ := script.FindProxy("https://example.org/", "example.org"); received != "DIRECT" {
+		t.Errorf("weekend: expected DIRECT received %q", received)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	testCases := []struct {
+		src      string
+		expected string
+	}{
+		{`function FindProxyForURL(url, host) { return "DIRECT"`, "expected }"},
+		{`function f() { return "DIRECT"; }`, "FindProxyForURL is not defined"},
+		{`var s = "abc`, "unterminated string"},
+		{`function FindProxyForURL(url, host) { if (/x/.test(url)) return "DIRECT"; }`, "regular expressions and division are not supported"},
+		{`var direct = ["a", "b"];`, "arrays are not supported"},
+		{`function FindProxyForURL(url, host) { for (;;) {} }`, "line 1: for is not supported"},
+		{`function FindProxyForURL(url, host) {
+			while (true) {}
+		}`, "line 2: while is not supported"},
+		{`function FindProxyForURL(url, host) { return typeof host; }`, "typeof is not supported"},
+		{`function FindProxyForURL(url, host) { host += "x"; }`, "unexpected"},
+		{`undefinedFunction();`, "undefinedFunction is not defined"},
+	}
+
+	for _, testCase := range testCases {
+		_, err := Parse(testCase.src)
+		if err == nil || !strings.Contains(err.Error(), testCase.expected) {
+			t.Errorf("%s: expected an error containing %q received %v", testCase.src, testCase.expected, err)
+		}
+	}
+}
+
+func TestUnsupportedMethod(t *testing.T) {
+	script, err := Parse(`function FindProxyForURL(url, host) { return url.split("/"); }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "string has no method split"
+	if _, err = script.FindProxy("http://example.com/", "example.com"); err == nil || !strings.Contains(err.Error(), expected) {
+		t.Errorf("expected an error containing %q received %v", expected, err)
+	}
+}
+
+func TestEndlessRecursion(t *testing.T) {
+	script, err := Parse(`
+		function FindProxyForURL(url, host) { return deeper(host); }
+		function deeper(host) { return deeper(host + "."); }
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = script.FindProxy("http://example.com/", "example.com"); err != errTooManySteps {
+		t.Errorf("expected %v received %v", errTooManySteps, err)
+	}
+
+	// a hundred nested calls that each call the next twice
+	if script, err = Parse(`
+		function FindProxyForURL(url, host) { return branch(0); }
+		function branch(n) { return n > 99 ? "DIRECT" : branch(n + 1) + branch(n + 1); }
+	`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = script.FindProxy("http://example.com/", "example.com"); err != errTooManySteps {
+		t.Errorf("expected %v received %v", errTooManySteps, err)
+	}
+}
+
+func TestParseResult(t *testing.T) {
+	testCases := []struct {
+		result   string
+		expected []string
+	}{
+		{"DIRECT", []string{""}},
+		{"PROXY a:8080; SOCKS5 b:1080 ;DIRECT", []string{"http://a:8080", "socks5://b:1080", ""}},
+		{"HTTPS secure:443", []string{"https://secure:443"}},
+		{"", nil},
+		{"PROXY", nil},
+		{"QUIC a:443", nil},
+	}
+
+	for _, testCase := range testCases {
+		proxies, err := ParseResult(testCase.result)
+		if testCase.expected == nil {
+			if err == nil {
+				t.Errorf("%q: expected an error", testCase.result)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", testCase.result, err)
+			continue
+		}
+
+		var received []string
+		for _, proxy := range proxies {
+			if proxy == nil {
+				received = append(received, "")
+			} else {
+				received = append(received, proxy.String())
+			}
+		}
+		if strings.Join(received, ",") != strings.Join(testCase.expected, ",") {
+			t.Errorf("%q: expected %q received %q", testCase.result, testCase.expected, received)
+		}
+	}
+}
+
+func TestProxy(t *testing.T) {
+	script, err := Parse(`function FindProxyForURL(url, host) {
+		if (url.indexOf("secret") != -1) return "DIRECT";
+		return "PROXY p:3128";
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		url      string
+		expected string
+	}{
+		{"http://example.com/file", "http://p:3128"},
+		{"http://example.com/secret", ""},
+		// the path of https urls is not passed to the script
+		{"https://example.com/secret", "http://p:3128"},
+	}
+
+	for _, testCase := range testCases {
+		req, err := http.NewRequest("GET", testCase.url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proxy, err := script.Proxy(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		received := ""
+		if proxy != nil {
+			received = proxy.String()
+		}
+		if received != testCase.expected {
+			t.Errorf("%s: expected %q received %q", testCase.url, testCase.expected, received)
+		}
+	}
+}
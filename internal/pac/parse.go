@@ -0,0 +1,524 @@
+package pac
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokNumber
+	tokString
+	tokIdent
+	tokPunct
+)
+
+type token struct {
+	kind tokKind
+	text string
+	line int
+}
+
+// punctuators, longest first
+var punctuators = []string{
+	"===", "!==",
+	"==", "!=", "<=", ">=", "&&", "||",
+	"{", "}", "(", ")", ";", ",", ".", "?", ":",
+	"=", "<", ">", "+", "-", "!",
+}
+
+func isIdentByte(c byte, first bool) bool {
+	return c == '_' || c == '$' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || !first && c >= '0' && c <= '9'
+}
+
+// tokenize splits src into tokens, leaving out white space and comments
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	line := 1
+
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+
+		case c == ' ' || c == '\t' || c == '\r':
+			i++
+
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, fmt.Errorf("pac: line %d: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+
+		case c == '"' || c == '\'':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\n' {
+					return nil, fmt.Errorf("pac: line %d: unterminated string", line)
+				}
+				if src[j] == '\\' && j+1 < len(src) {
+					j++
+					switch src[j] {
+					case 'n':
+						b.WriteByte('\n')
+					case 't':
+						b.WriteByte('\t')
+					default:
+						b.WriteByte(src[j])
+					}
+					continue
+				}
+				b.WriteByte(src[j])
+			}
+			if j >= len(src) {
+				return nil, fmt.Errorf("pac: line %d: unterminated string", line)
+			}
+			tokens = append(tokens, token{tokString, b.String(), line})
+			i = j + 1
+
+		case c >= '0' && c <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{tokNumber, src[i:j], line})
+			i = j
+
+		case isIdentByte(c, true):
+			j := i
+			for j < len(src) && isIdentByte(src[j], false) {
+				j++
+			}
+			tokens = append(tokens, token{tokIdent, src[i:j], line})
+			i = j
+
+		default:
+			found := false
+			for _, punct := range punctuators {
+				if strings.HasPrefix(src[i:], punct) {
+					tokens = append(tokens, token{tokPunct, punct, line})
+					i += len(punct)
+					found = true
+					break
+				}
+			}
+			switch {
+			case found:
+			case c == '/':
+				return nil, fmt.Errorf("pac: line %d: regular expressions and division are not supported", line)
+			case c == '[' || c == ']':
+				return nil, fmt.Errorf("pac: line %d: arrays are not supported", line)
+			default:
+				return nil, fmt.Errorf("pac: line %d: unexpected %q", line, c)
+			}
+		}
+	}
+
+	return append(tokens, token{tokEOF, "", line}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the punctuator or keyword text
+func (p *parser) accept(text string) bool {
+	if t := p.peek(); (t.kind == tokPunct || t.kind == tokIdent) && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return p.errorf("expected %s", text)
+	}
+	return nil
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	t := p.peek()
+	found := strconv.Quote(t.text)
+	if t.kind == tokEOF {
+		found = "end of file"
+	}
+	return fmt.Errorf("pac: line %d: "+format+", found %s", append([]interface{}{t.line}, append(args, found)...)...)
+}
+
+func (p *parser) ident() (string, error) {
+	t := p.peek()
+	if t.kind == tokIdent && unsupported[t.text] {
+		return "", fmt.Errorf("pac: line %d: %s is not supported", t.line, t.text)
+	}
+	if t.kind != tokIdent || keywords[t.text] {
+		return "", p.errorf("expected a name")
+	}
+	p.pos++
+	return t.text, nil
+}
+
+var keywords = map[string]bool{
+	"function": true, "var": true, "let": true, "const": true, "if": true, "else": true,
+	"return": true, "true": true, "false": true, "null": true, "undefined": true,
+}
+
+// unsupported are the keywords of JavaScript that are outside of the subset,
+// they fail with a clearer error than an unknown name
+var unsupported = map[string]bool{
+	"for": true, "while": true, "do": true, "switch": true, "break": true, "continue": true,
+	"new": true, "typeof": true, "try": true, "throw": true, "delete": true, "in": true,
+}
+
+// parseProgram parses the statements of a whole file
+func (p *parser) parseProgram() ([]stmt, error) {
+	var stmts []stmt
+	for p.peek().kind != tokEOF {
+		s, err := p.parseStmt()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, s)
+	}
+	return stmts, nil
+}
+
+func (p *parser) parseBlock() ([]stmt, error) {
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var stmts []stmt
+	for !p.accept("}") {
+		if p.peek().kind == tokEOF {
+			return nil, p.errorf("expected }")
+		}
+		s, err := p.parseStmt()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, s)
+	}
+	return stmts, nil
+}
+
+func (p *parser) parseStmt() (stmt, error) {
+	switch {
+	case p.accept(";"):
+		return &blockStmt{}, nil
+
+	case p.peek().text == "{" && p.peek().kind == tokPunct:
+		body, err := p.parseBlock()
+		return &blockStmt{body}, err
+
+	case p.accept("function"):
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		f, err := p.parseFunction(name)
+		if err != nil {
+			return nil, err
+		}
+		return &funcStmt{f}, nil
+
+	case p.accept("var") || p.accept("let") || p.accept("const"):
+		s, err := p.parseVar()
+		if err != nil {
+			return nil, err
+		}
+		p.accept(";")
+		return s, nil
+
+	case p.accept("if"):
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		cond, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect(")"); err != nil {
+			return nil, err
+		}
+		s := &ifStmt{cond: cond}
+		if s.then, err = p.parseStmt(); err != nil {
+			return nil, err
+		}
+		if p.accept("else") {
+			if s.otherwise, err = p.parseStmt(); err != nil {
+				return nil, err
+			}
+		}
+		return s, nil
+
+	case p.accept("return"):
+		s := &returnStmt{}
+		if t := p.peek(); !(t.kind == tokPunct && (t.text == ";" || t.text == "}") || t.kind == tokEOF) {
+			var err error
+			if s.value, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+		}
+		p.accept(";")
+		return s, nil
+	}
+
+	e, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	p.accept(";")
+	return &exprStmt{e}, nil
+}
+
+func (p *parser) parseFunction(name string) (*function, error) {
+	f := &function{name: name}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	for !p.accept(")") {
+		if len(f.params) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		param, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		f.params = append(f.params, param)
+	}
+
+	var err error
+	f.body, err = p.parseBlock()
+	return f, err
+}
+
+// parseVar parses the declarations after var
+func (p *parser) parseVar() (*varStmt, error) {
+	s := &varStmt{}
+	for {
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		var value expr
+		if p.accept("=") {
+			if value, err = p.parseAssign(); err != nil {
+				return nil, err
+			}
+		}
+		s.names = append(s.names, name)
+		s.values = append(s.values, value)
+		if !p.accept(",") {
+			return s, nil
+		}
+	}
+}
+
+func (p *parser) parseExpr() (expr, error) {
+	return p.parseAssign()
+}
+
+func (p *parser) parseAssign() (expr, error) {
+	left, err := p.parseConditional()
+	if err != nil {
+		return nil, err
+	}
+
+	if !p.accept("=") {
+		return left, nil
+	}
+	target, ok := left.(*identExpr)
+	if !ok {
+		return nil, p.errorf("invalid assignment target")
+	}
+	value, err := p.parseAssign()
+	if err != nil {
+		return nil, err
+	}
+	return &assignExpr{target: target.name, value: value}, nil
+}
+
+func (p *parser) parseConditional() (expr, error) {
+	cond, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept("?") {
+		return cond, nil
+	}
+
+	then, err := p.parseAssign()
+	if err != nil {
+		return nil, err
+	}
+	if err = p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.parseAssign()
+	if err != nil {
+		return nil, err
+	}
+	return &condExpr{cond, then, otherwise}, nil
+}
+
+// binary operators by increasing precedence
+var precedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"===", "!==", "==", "!="},
+	{"<=", ">=", "<", ">"},
+	{"+"},
+}
+
+func (p *parser) parseBinary(level int) (expr, error) {
+	if level == len(precedence) {
+		return p.parseUnary()
+	}
+
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		op := ""
+		for _, candidate := range precedence[level] {
+			if p.accept(candidate) {
+				op = candidate
+				break
+			}
+		}
+		if op == "" {
+			return left, nil
+		}
+
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) parseUnary() (expr, error) {
+	for _, op := range []string{"!", "-"} {
+		if p.accept(op) {
+			operand, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			return &unaryExpr{op: op, operand: operand}, nil
+		}
+	}
+	return p.parsePostfix()
+}
+
+// parsePostfix parses a primary expression followed by calls and properties
+func (p *parser) parsePostfix() (expr, error) {
+	e, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		switch {
+		case p.accept("("):
+			call := &callExpr{fn: e}
+			for !p.accept(")") {
+				if len(call.args) > 0 {
+					if err = p.expect(","); err != nil {
+						return nil, err
+					}
+				}
+				arg, err := p.parseAssign()
+				if err != nil {
+					return nil, err
+				}
+				call.args = append(call.args, arg)
+			}
+			e = call
+
+		case p.accept("."):
+			t := p.next()
+			if t.kind != tokIdent {
+				return nil, p.errorf("expected a property name")
+			}
+			e = &memberExpr{object: e, name: t.text}
+
+		default:
+			return e, nil
+		}
+	}
+}
+
+func (p *parser) parsePrimary() (expr, error) {
+	t := p.peek()
+
+	switch t.kind {
+	case tokNumber:
+		p.pos++
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("pac: line %d: invalid number %q", t.line, t.text)
+		}
+		return &literal{f}, nil
+
+	case tokString:
+		p.pos++
+		return &literal{t.text}, nil
+
+	case tokIdent:
+		switch t.text {
+		case "true", "false":
+			p.pos++
+			return &literal{t.text == "true"}, nil
+		case "null", "undefined":
+			p.pos++
+			return &literal{nil}, nil
+		}
+		name, err := p.ident()
+		if err != nil {
+			return nil, err
+		}
+		return &identExpr{name}, nil
+
+	case tokPunct:
+		if p.accept("(") {
+			e, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			return e, p.expect(")")
+		}
+	}
+
+	return nil, p.errorf("unexpected token")
+}
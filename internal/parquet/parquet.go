@@ -0,0 +1,292 @@
+// Package parquet writes simple Apache Parquet files. It implements only
+// what -parquet-dir needs:
+//
+//   - flat schemas of int32, int64, string, binary and timestamp columns,
+//     required or optional
+//   - a single row group with one version 1 data page per column
+//   - PLAIN encoded values and RLE encoded definition levels
+//   - no compression, dictionaries, statistics or page indexes
+//
+// Nested and repeated columns, the other physical types and reading files
+// are not supported. A page is limited to 2GB, the largest size its header
+// can hold.
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"time"
+)
+
+const magic = "PAR1"
+
+// Type is the type of the values of a column
+type Type int
+
+const (
+	Int32     Type = iota // int32
+	Int64                 // int64
+	String                // string, stored as UTF8 annotated BYTE_ARRAY
+	Bytes                 // []byte
+	Timestamp             // time.Time, stored as INT64 milliseconds since the epoch
+)
+
+// Column describes a column of the file
+type Column struct {
+	Name     string
+	Type     Type
+	Optional bool // whether the values may be nil
+}
+
+// physical types, repetition types, converted types, encodings and page
+// types as defined by parquet.thrift
+const (
+	physicalInt32     = 1
+	physicalInt64     = 2
+	physicalByteArray = 6
+
+	repetitionRequired = 0
+	repetitionOptional = 1
+
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	pageData = 0
+)
+
+func (c Column) physicalType() int32 {
+	switch c.Type {
+	case Int32:
+		return physicalInt32
+	case Int64, Timestamp:
+		return physicalInt64
+	default:
+		return physicalByteArray
+	}
+}
+
+// encodeValue appends v in PLAIN encoding to buf
+func (c Column) encodeValue(buf *bytes.Buffer, v interface{}) error {
+	var b []byte
+	switch c.Type {
+	case Int32:
+		x, ok := v.(int32)
+		if !ok {
+			return fmt.Errorf("parquet: column %s expects int32, received %T", c.Name, v)
+		}
+		return binary.Write(buf, binary.LittleEndian, x)
+	case Int64:
+		x, ok := v.(int64)
+		if !ok {
+			return fmt.Errorf("parquet: column %s expects int64, received %T", c.Name, v)
+		}
+		return binary.Write(buf, binary.LittleEndian, x)
+	case Timestamp:
+		x, ok := v.(time.Time)
+		if !ok {
+			return fmt.Errorf("parquet: column %s expects time.Time, received %T", c.Name, v)
+		}
+		return binary.Write(buf, binary.LittleEndian, x.UnixNano()/int64(time.Millisecond))
+	case String:
+		x, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("parquet: column %s expects string, received %T", c.Name, v)
+		}
+		b = []byte(x)
+	default:
+		x, ok := v.([]byte)
+		if !ok {
+			return fmt.Errorf("parquet: column %s expects []byte, received %T", c.Name, v)
+		}
+		b = x
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, uint32(len(b))); err != nil {
+		return err
+	}
+	buf.Write(b)
+	return nil
+}
+
+// Encode writes a parquet file with the given columns and rows to w. Every
+// row holds one value per column, nil for missing values of optional columns.
+func Encode(w io.Writer, columns []Column, rows [][]interface{}) error {
+	var out bytes.Buffer
+	out.WriteString(magic)
+
+	chunks := make([]columnChunk, len(columns))
+	for i, col := range columns {
+		page, err := encodePage(col, i, rows)
+		if err != nil {
+			return err
+		}
+		if len(page) > math.MaxInt32 {
+			return fmt.Errorf("parquet: column %s holds %d bytes, more than a page can hold", col.Name, len(page))
+		}
+
+		var header compactWriter
+		header.beginStruct()
+		header.i32(1, pageData)
+		header.i32(2, int32(len(page)))
+		header.i32(3, int32(len(page)))
+		header.structField(5)
+		header.i32(1, int32(len(rows)))
+		header.i32(2, encodingPlain)
+		header.i32(3, encodingRLE)
+		header.i32(4, encodingRLE)
+		header.endStruct()
+		header.endStruct()
+
+		chunks[i] = columnChunk{
+			offset: int64(out.Len()),
+			size:   int64(header.buf.Len() + len(page)),
+		}
+		out.Write(header.buf.Bytes())
+		out.Write(page)
+	}
+
+	footer := encodeFooter(columns, chunks, int64(len(rows)))
+	out.Write(footer)
+	if err := binary.Write(&out, binary.LittleEndian, uint32(len(footer))); err != nil {
+		return err
+	}
+	out.WriteString(magic)
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+type columnChunk struct {
+	offset int64 // of the data page
+	size   int64 // including the page header
+}
+
+// encodePage returns the body of the data page of column i
+func encodePage(col Column, i int, rows [][]interface{}) ([]byte, error) {
+	var page bytes.Buffer
+
+	// optional columns start with their definition levels (1 for present
+	// values, 0 for nulls), RLE encoded and prefixed with their length
+	if col.Optional {
+		levels := make([]byte, len(rows))
+		for r, row := range rows {
+			if i < len(row) && row[i] != nil {
+				levels[r] = 1
+			}
+		}
+		encoded := encodeLevels(levels)
+		if err := binary.Write(&page, binary.LittleEndian, uint32(len(encoded))); err != nil {
+			return nil, err
+		}
+		page.Write(encoded)
+	}
+
+	for _, row := range rows {
+		if i >= len(row) {
+			return nil, fmt.Errorf("parquet: row has %d values, expected %d", len(row), i+1)
+		}
+		if row[i] == nil {
+			if !col.Optional {
+				return nil, fmt.Errorf("parquet: missing value for required column %s", col.Name)
+			}
+			continue
+		}
+		if err := col.encodeValue(&page, row[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	return page.Bytes(), nil
+}
+
+// encodeLevels encodes levels of bit width 1 as RLE runs of the
+// RLE/bit-packing hybrid encoding
+func encodeLevels(levels []byte) []byte {
+	var buf bytes.Buffer
+	var tmp [binary.MaxVarintLen64]byte
+
+	for start := 0; start < len(levels); {
+		end := start
+		for end < len(levels) && levels[end] == levels[start] {
+			end++
+		}
+		n := binary.PutUvarint(tmp[:], uint64(end-start)<<1)
+		buf.Write(tmp[:n])
+		buf.WriteByte(levels[start])
+		start = end
+	}
+
+	return buf.Bytes()
+}
+
+// encodeFooter returns the FileMetaData of the file
+func encodeFooter(columns []Column, chunks []columnChunk, numRows int64) []byte {
+	var w compactWriter
+	w.beginStruct()
+
+	w.i32(1, 1) // version
+
+	// the schema is a root element followed by the flat list of columns
+	w.structList(2, len(columns)+1)
+	w.beginStruct()
+	w.binary(4, "schema")
+	w.i32(5, int32(len(columns)))
+	w.endStruct()
+	for _, col := range columns {
+		w.beginStruct()
+		w.i32(1, col.physicalType())
+		if col.Optional {
+			w.i32(3, repetitionOptional)
+		} else {
+			w.i32(3, repetitionRequired)
+		}
+		w.binary(4, col.Name)
+		switch col.Type {
+		case String:
+			w.i32(6, convertedUTF8)
+		case Timestamp:
+			w.i32(6, convertedTimestampMillis)
+		}
+		w.endStruct()
+	}
+
+	w.i64(3, numRows)
+
+	var totalSize int64
+	for _, chunk := range chunks {
+		totalSize += chunk.size
+	}
+
+	w.structList(4, 1)
+	w.beginStruct()
+	w.structList(1, len(columns))
+	for i, col := range columns {
+		w.beginStruct()
+		w.i64(2, chunks[i].offset)
+		w.structField(3)
+		w.i32(1, col.physicalType())
+		w.i32List(2, []int32{encodingPlain, encodingRLE})
+		w.binaryList(3, []string{col.Name})
+		w.i32(4, 0) // uncompressed
+		w.i64(5, numRows)
+		w.i64(6, chunks[i].size)
+		w.i64(7, chunks[i].size)
+		w.i64(9, chunks[i].offset)
+		w.endStruct()
+		w.endStruct()
+	}
+	w.i64(2, totalSize)
+	w.i64(3, numRows)
+	w.endStruct()
+
+	w.binary(6, "massivedl")
+
+	w.endStruct()
+	return w.buf.Bytes()
+}
@@ -0,0 +1,178 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// compactReader decodes thrift compact structs into maps from field ids to
+// values, which is enough to inspect the metadata that Encode writes
+type compactReader struct {
+	b   []byte
+	pos int
+	t   *testing.T
+}
+
+func (r *compactReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b[r.pos:])
+	if n <= 0 {
+		r.t.Fatalf("invalid varint at %d", r.pos)
+	}
+	r.pos += n
+	return v
+}
+
+func (r *compactReader) zigzag() int64 {
+	v := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *compactReader) value(typ byte) interface{} {
+	switch typ {
+	case ctI32, ctI64:
+		return r.zigzag()
+	case ctBinary:
+		n := int(r.uvarint())
+		s := string(r.b[r.pos : r.pos+n])
+		r.pos += n
+		return s
+	case ctList:
+		h := r.b[r.pos]
+		r.pos++
+		size, elemType := int(h>>4), h&0x0f
+		if size == 15 {
+			size = int(r.uvarint())
+		}
+		list := make([]interface{}, size)
+		for i := range list {
+			list[i] = r.value(elemType)
+		}
+		return list
+	case ctStruct:
+		return r.readStruct()
+	}
+	r.t.Fatalf("unexpected type %d at %d", typ, r.pos)
+	return nil
+}
+
+func (r *compactReader) readStruct() map[int64]interface{} {
+	fields := make(map[int64]interface{})
+	var id int64
+	for {
+		h := r.b[r.pos]
+		r.pos++
+		if h == 0 {
+			return fields
+		}
+		if delta := int64(h >> 4); delta > 0 {
+			id += delta
+		} else {
+			id = r.zigzag()
+		}
+		fields[id] = r.value(h & 0x0f)
+	}
+}
+
+func TestEncode(t *testing.T) {
+	columns := []Column{
+		{Name: "url", Type: String},
+		{Name: "status", Type: Int32},
+		{Name: "time", Type: Timestamp},
+		{Name: "body", Type: Bytes, Optional: true},
+	}
+	now := time.Unix(1600000000, 0)
+	rows := [][]interface{}{
+		{"http://a", int32(200), now, []byte("hello")},
+		{"http://b", int32(404), now, nil},
+		{"http://c", int32(200), now, []byte("")},
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, columns, rows); err != nil {
+		t.Fatal(err)
+	}
+	b := buf.Bytes()
+
+	if string(b[:4]) != magic || string(b[len(b)-4:]) != magic {
+		t.Fatal("missing magic bytes")
+	}
+
+	footerLen := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	r := &compactReader{b: b[len(b)-8-footerLen : len(b)-8], t: t}
+	meta := r.readStruct()
+
+	if meta[3] != int64(3) {
+		t.Errorf("expected 3 rows received %v", meta[3])
+	}
+	if schema := meta[2].([]interface{}); len(schema) != 5 {
+		t.Errorf("expected 5 schema elements received %d", len(schema))
+	}
+
+	rowGroup := meta[4].([]interface{})[0].(map[int64]interface{})
+	chunks := rowGroup[1].([]interface{})
+	if len(chunks) != len(columns) {
+		t.Fatalf("expected %d column chunks received %d", len(columns), len(chunks))
+	}
+
+	// the page of the status column holds the plain encoded values
+	status := chunks[1].(map[int64]interface{})[3].(map[int64]interface{})
+	r = &compactReader{b: b, pos: int(status[9].(int64)), t: t}
+	header := r.readStruct()
+	page := b[r.pos : r.pos+int(header[3].(int64))]
+	for i, expected := range []int32{200, 404, 200} {
+		if v := int32(binary.LittleEndian.Uint32(page[i*4:])); v != expected {
+			t.Errorf("status[%d] expected %d received %d", i, expected, v)
+		}
+	}
+
+	// the page of the optional body column starts with its definition levels
+	body := chunks[3].(map[int64]interface{})[3].(map[int64]interface{})
+	r = &compactReader{b: b, pos: int(body[9].(int64)), t: t}
+	r.readStruct()
+	levelsLen := int(binary.LittleEndian.Uint32(b[r.pos:]))
+	levels := b[r.pos+4 : r.pos+4+levelsLen]
+	if !bytes.Equal(levels, []byte{1 << 1, 1, 1 << 1, 0, 1 << 1, 1}) {
+		t.Errorf("unexpected definition levels %v", levels)
+	}
+}
+
+func TestEncodeRejectsMissingValues(t *testing.T) {
+	columns := []Column{{Name: "url", Type: String}}
+	if err := Encode(ioutil.Discard, columns, [][]interface{}{{nil}}); err == nil {
+		t.Error("expected an error for a nil value in a required column")
+	}
+	if err := Encode(ioutil.Discard, columns, [][]interface{}{{int32(1)}}); err == nil {
+		t.Error("expected an error for a value of the wrong type")
+	}
+}
+
+func TestWriterRotates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "parquet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	w, err := NewWriter(dir, []Column{{Name: "n", Type: Int64}}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if err = w.Write([]interface{}{int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.parquet"))
+	if len(files) != 3 {
+		t.Errorf("expected 3 files received %d", len(files))
+	}
+}
@@ -0,0 +1,105 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// thrift compact protocol type ids
+const (
+	ctI32    = 5
+	ctI64    = 6
+	ctBinary = 8
+	ctList   = 9
+	ctStruct = 12
+)
+
+// compactWriter writes thrift structs in the compact protocol, which is
+// used for the page headers and the footer of parquet files
+type compactWriter struct {
+	buf    bytes.Buffer
+	fields []int16 // id of the last field of every open struct
+}
+
+func (w *compactWriter) uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(b[:], v)
+	w.buf.Write(b[:n])
+}
+
+func (w *compactWriter) zigzag(v int64) {
+	w.uvarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (w *compactWriter) fieldHeader(id int16, typ byte) {
+	last := &w.fields[len(w.fields)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		w.buf.WriteByte(byte(delta)<<4 | typ)
+	} else {
+		w.buf.WriteByte(typ)
+		w.zigzag(int64(id))
+	}
+	*last = id
+}
+
+func (w *compactWriter) beginStruct() {
+	w.fields = append(w.fields, 0)
+}
+
+func (w *compactWriter) endStruct() {
+	w.buf.WriteByte(0)
+	w.fields = w.fields[:len(w.fields)-1]
+}
+
+func (w *compactWriter) i32(id int16, v int32) {
+	w.fieldHeader(id, ctI32)
+	w.zigzag(int64(v))
+}
+
+func (w *compactWriter) i64(id int16, v int64) {
+	w.fieldHeader(id, ctI64)
+	w.zigzag(v)
+}
+
+func (w *compactWriter) binary(id int16, v string) {
+	w.fieldHeader(id, ctBinary)
+	w.uvarint(uint64(len(v)))
+	w.buf.WriteString(v)
+}
+
+func (w *compactWriter) listHeader(id int16, elemType byte, size int) {
+	w.fieldHeader(id, ctList)
+	if size < 15 {
+		w.buf.WriteByte(byte(size)<<4 | elemType)
+	} else {
+		w.buf.WriteByte(0xf0 | elemType)
+		w.uvarint(uint64(size))
+	}
+}
+
+func (w *compactWriter) i32List(id int16, values []int32) {
+	w.listHeader(id, ctI32, len(values))
+	for _, v := range values {
+		w.zigzag(int64(v))
+	}
+}
+
+func (w *compactWriter) binaryList(id int16, values []string) {
+	w.listHeader(id, ctBinary, len(values))
+	for _, v := range values {
+		w.uvarint(uint64(len(v)))
+		w.buf.WriteString(v)
+	}
+}
+
+// structField starts a struct valued field, it is closed with endStruct
+func (w *compactWriter) structField(id int16) {
+	w.fieldHeader(id, ctStruct)
+	w.beginStruct()
+}
+
+// structList starts a list of n structs, each one is started with
+// beginStruct and closed with endStruct
+func (w *compactWriter) structList(id int16, n int) {
+	w.listHeader(id, ctStruct, n)
+}
@@ -0,0 +1,92 @@
+package parquet
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Writer collects rows in memory and writes them to dir/part-NNNNN.parquet
+// whenever rowsPerFile rows have been collected, and when it is closed. It is
+// safe for concurrent use.
+type Writer struct {
+	dir         string
+	columns     []Column
+	rowsPerFile int
+
+	lock sync.Mutex
+	rows [][]interface{}
+	next int
+}
+
+// NewWriter creates a Writer. The numbering continues after the files that
+// already exist in dir, so that earlier output is never overwritten.
+func NewWriter(dir string, columns []Column, rowsPerFile int) (*Writer, error) {
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	existing, err := filepath.Glob(filepath.Join(dir, "part-*.parquet"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(existing)
+
+	w := &Writer{dir: dir, columns: columns, rowsPerFile: rowsPerFile}
+	if len(existing) > 0 {
+		var n int
+		if _, err = fmt.Sscanf(filepath.Base(existing[len(existing)-1]), "part-%d.parquet", &n); err == nil {
+			w.next = n + 1
+		}
+	}
+
+	return w, nil
+}
+
+// Write adds a row with one value per column
+func (w *Writer) Write(row []interface{}) error {
+	if len(row) != len(w.columns) {
+		return fmt.Errorf("parquet: row has %d values, expected %d", len(row), len(w.columns))
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.rows = append(w.rows, row)
+	if w.rowsPerFile > 0 && len(w.rows) >= w.rowsPerFile {
+		return w.flush()
+	}
+	return nil
+}
+
+// flush writes the collected rows into the next file
+func (w *Writer) flush() error {
+	if len(w.rows) == 0 {
+		return nil
+	}
+
+	name := filepath.Join(w.dir, fmt.Sprintf("part-%05d.parquet", w.next))
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	w.next++
+
+	err = Encode(f, w.columns, w.rows)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	w.rows = nil
+
+	return err
+}
+
+// Close writes the rows that are still collected
+func (w *Writer) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.flush()
+}
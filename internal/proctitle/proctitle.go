@@ -0,0 +1,9 @@
+// Package proctitle changes the command line that ps and top show for the
+// process, e.g. to report the progress of a long running job.
+package proctitle
+
+import "errors"
+
+// ErrUnsupported is returned by Set on operating systems where the title of
+// the process can't be changed, and if Init wasn't called
+var ErrUnsupported = errors.New("changing the process title is not supported on this system")
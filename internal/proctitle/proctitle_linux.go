@@ -0,0 +1,68 @@
+//go:build linux
+// +build linux
+
+package proctitle
+
+import (
+	"os"
+	"unsafe"
+)
+
+// argv is the memory of the command line arguments, which the kernel shows in
+// /proc/<pid>/cmdline
+var argv []byte
+
+type stringHeader struct {
+	data unsafe.Pointer
+	len  int
+}
+
+type sliceHeader struct {
+	data unsafe.Pointer
+	len  int
+	cap  int
+}
+
+// Init prepares Set. The strings of os.Args point into the memory of the
+// command line, they are replaced by copies so that Set doesn't change them.
+// It must be called before anything keeps a reference to them, e.g. before
+// the flags are parsed.
+func Init() {
+	if len(os.Args) == 0 || argv != nil {
+		return
+	}
+
+	// the arguments follow each other, separated by a NUL byte
+	first := (*stringHeader)(unsafe.Pointer(&os.Args[0]))
+	end := uintptr(first.data) + uintptr(first.len)
+	for i := 1; i < len(os.Args); i++ {
+		arg := (*stringHeader)(unsafe.Pointer(&os.Args[i]))
+		if uintptr(arg.data) != end+1 {
+			return
+		}
+		end = uintptr(arg.data) + uintptr(arg.len)
+	}
+	size := int(end - uintptr(first.data))
+	if size == 0 {
+		return
+	}
+
+	argv = *(*[]byte)(unsafe.Pointer(&sliceHeader{data: first.data, len: size, cap: size}))
+	for i, arg := range os.Args {
+		os.Args[i] = string([]byte(arg))
+	}
+}
+
+// Set changes the command line of the process to title, which is cut to the
+// length of the original command line
+func Set(title string) error {
+	if argv == nil {
+		return ErrUnsupported
+	}
+
+	n := copy(argv, title)
+	for i := n; i < len(argv); i++ {
+		argv[i] = 0
+	}
+	return nil
+}
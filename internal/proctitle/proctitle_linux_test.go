@@ -0,0 +1,30 @@
+package proctitle
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	Init()
+	if argv == nil {
+		t.Skip(ErrUnsupported)
+	}
+
+	// the testing flags still use the original command line
+	original := append([]byte(nil), argv...)
+	defer copy(argv, original)
+
+	if err := Set("proctitle test"); err != nil {
+		t.Fatal(err)
+	}
+
+	cmdline, err := ioutil.ReadFile("/proc/self/cmdline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(cmdline, []byte("proctitle test\x00")) {
+		t.Errorf("expected the new title, received %q", cmdline)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package proctitle
+
+// Init does nothing
+func Init() {}
+
+// Set returns ErrUnsupported
+func Set(title string) error {
+	return ErrUnsupported
+}
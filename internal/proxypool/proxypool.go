@@ -0,0 +1,195 @@
+// Package proxypool rotates requests over a list of proxies and stops using
+// proxies that fail repeatedly
+package proxypool
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// ErrNoProxies is returned when every proxy of the pool has been blacklisted
+var ErrNoProxies = errors.New("no working proxies left")
+
+// Pool is a list of proxies that are handed out in turn
+type Pool struct {
+	// Random picks a random proxy for every request instead of going
+	// through them in order
+	Random bool
+
+	// MaxFailures is the number of consecutive failures after which a proxy
+	// is no longer used, 0 never blacklists proxies
+	MaxFailures int
+
+	// OnBlacklist is called when a proxy is blacklisted, if set
+	OnBlacklist func(proxy *url.URL)
+
+	lock     sync.Mutex
+	proxies  []*proxy
+	next     int
+	shuffler *rand.Rand
+}
+
+type proxy struct {
+	url         *url.URL
+	failures    int
+	blacklisted bool
+}
+
+// New creates a pool of the given proxies
+func New(proxies []*url.URL) *Pool {
+	pool := &Pool{shuffler: rand.New(rand.NewSource(rand.Int63()))}
+	for _, u := range proxies {
+		pool.proxies = append(pool.proxies, &proxy{url: u})
+	}
+	return pool
+}
+
+// Parse reads a list of proxies, one url per line. Empty lines and lines
+// starting with # are ignored and host:port stands for an http proxy.
+func Parse(r io.Reader) ([]*url.URL, error) {
+	var proxies []*url.URL
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !strings.Contains(line, "://") {
+			line = "http://" + line
+		}
+
+		u, err := url.Parse(line)
+		if err != nil {
+			return nil, err
+		}
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, fmt.Errorf("proxy %s: unsupported scheme %s", u.Redacted(), u.Scheme)
+		}
+		proxies = append(proxies, u)
+	}
+
+	return proxies, scanner.Err()
+}
+
+// Next returns the proxy for the next request
+func (pool *Pool) Next() (*url.URL, error) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	var working []*proxy
+	if pool.Random {
+		for _, p := range pool.proxies {
+			if !p.blacklisted {
+				working = append(working, p)
+			}
+		}
+		if len(working) == 0 {
+			return nil, ErrNoProxies
+		}
+		return working[pool.shuffler.Intn(len(working))].url, nil
+	}
+
+	for range pool.proxies {
+		p := pool.proxies[pool.next%len(pool.proxies)]
+		pool.next++
+		if !p.blacklisted {
+			return p.url, nil
+		}
+	}
+	return nil, ErrNoProxies
+}
+
+// Report records the outcome of a request that was sent through u
+func (pool *Pool) Report(u *url.URL, ok bool) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	for _, p := range pool.proxies {
+		if p.url != u {
+			continue
+		}
+
+		if ok {
+			p.failures = 0
+			return
+		}
+
+		p.failures++
+		if pool.MaxFailures > 0 && p.failures >= pool.MaxFailures && !p.blacklisted {
+			p.blacklisted = true
+			if pool.OnBlacklist != nil {
+				pool.OnBlacklist(p.url)
+			}
+		}
+		return
+	}
+}
+
+// Working returns the number of proxies that are not blacklisted
+func (pool *Pool) Working() int {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	n := 0
+	for _, p := range pool.proxies {
+		if !p.blacklisted {
+			n++
+		}
+	}
+	return n
+}
+
+type contextKey struct{}
+
+// Proxy is the Proxy function for the http.Transport that is wrapped by a
+// Transport of the pool, it returns the proxy that Transport chose
+func Proxy(req *http.Request) (*url.URL, error) {
+	u, _ := req.Context().Value(contextKey{}).(*url.URL)
+	return u, nil
+}
+
+// Transport is an http.RoundTripper that sends every request through the
+// next proxy of Pool. The wrapped transport must use Proxy as its Proxy
+// function.
+type Transport struct {
+	Pool      *Pool
+	Transport http.RoundTripper
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	u, err := t.Pool.Next()
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := t.Transport.RoundTrip(req.WithContext(context.WithValue(req.Context(), contextKey{}, u)))
+	if err != nil {
+		t.Pool.Report(u, false)
+		return nil, err
+	}
+	t.Pool.Report(u, !proxyFailure(response.StatusCode))
+
+	return response, err
+}
+
+// proxyFailure reports whether a status code hints at a problem with the
+// proxy rather than with the requested file
+func proxyFailure(status int) bool {
+	switch status {
+	case http.StatusProxyAuthRequired, http.StatusTooManyRequests, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package proxypool
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	input := "# proxies\nhttp://a:8080\n\n10.0.0.1:3128\nsocks5://user:pw@b:1080\n"
+
+	proxies, err := Parse(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"http://a:8080", "http://10.0.0.1:3128", "socks5://user:pw@b:1080"}
+	if len(proxies) != len(expected) {
+		t.Fatalf("expected %d proxies received %d", len(expected), len(proxies))
+	}
+	for i := range expected {
+		if proxies[i].String() != expected[i] {
+			t.Errorf("expected %s received %s", expected[i], proxies[i])
+		}
+	}
+
+	if _, err = Parse(strings.NewReader("ftp://x:21")); err == nil {
+		t.Error("expected an error for an unsupported scheme")
+	}
+}
+
+func TestRoundRobinAndBlacklist(t *testing.T) {
+	proxies, _ := Parse(strings.NewReader("a:1\nb:2\nc:3"))
+	pool := New(proxies)
+	pool.MaxFailures = 2
+
+	var blacklisted []string
+	pool.OnBlacklist = func(u *url.URL) { blacklisted = append(blacklisted, u.Host) }
+
+	var order []string
+	for i := 0; i < 4; i++ {
+		u, _ := pool.Next()
+		order = append(order, u.Host)
+	}
+	if strings.Join(order, ",") != "a:1,b:2,c:3,a:1" {
+		t.Errorf("unexpected order %v", order)
+	}
+
+	// a success resets the failures
+	pool.Report(proxies[1], false)
+	pool.Report(proxies[1], true)
+	pool.Report(proxies[1], false)
+	if pool.Working() != 3 {
+		t.Errorf("expected 3 working proxies received %d", pool.Working())
+	}
+
+	pool.Report(proxies[1], false)
+	if pool.Working() != 2 || len(blacklisted) != 1 || blacklisted[0] != "b:2" {
+		t.Errorf("expected b:2 to be blacklisted, received %v", blacklisted)
+	}
+
+	for i := 0; i < 4; i++ {
+		if u, _ := pool.Next(); u.Host == "b:2" {
+			t.Error("blacklisted proxy was used")
+		}
+	}
+
+	pool.Report(proxies[0], false)
+	pool.Report(proxies[0], false)
+	pool.Report(proxies[2], false)
+	pool.Report(proxies[2], false)
+	if _, err := pool.Next(); !errors.Is(err, ErrNoProxies) {
+		t.Errorf("expected ErrNoProxies received %v", err)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	var seen []string
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a proxy receives the absolute url of the requested resource
+		seen = append(seen, r.URL.String())
+		if r.Header.Get("X-Fail") != "" {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer proxyServer.Close()
+
+	u, _ := url.Parse(proxyServer.URL)
+	pool := New([]*url.URL{u})
+	pool.MaxFailures = 1
+
+	client := &http.Client{Transport: &Transport{Pool: pool, Transport: &http.Transport{Proxy: Proxy}}}
+
+	response, err := client.Get("http://example.com/file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if len(seen) != 1 || seen[0] != "http://example.com/file" {
+		t.Errorf("request did not go through the proxy: %v", seen)
+	}
+
+	req, _ := http.NewRequest("GET", "http://example.com/other", nil)
+	req.Header.Set("X-Fail", "1")
+	if response, err = client.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+
+	if pool.Working() != 0 {
+		t.Error("expected the failing proxy to be blacklisted")
+	}
+	if _, err = client.Get("http://example.com/file"); err == nil {
+		t.Error("expected an error without working proxies")
+	}
+}
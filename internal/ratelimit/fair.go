@@ -0,0 +1,175 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// fairGrowth is how much more than in the last slice a group may use in the
+// next one, so that groups which are held back by their servers can still
+// speed up
+const fairGrowth = 1.25
+
+// FairShare splits a rate between groups of transfers by their weights, so
+// that the group that started first doesn't take all of it. Only groups with
+// running transfers get a share. The shares are rebalanced in time slices:
+// a group that used less than its share in the last slice keeps what it used
+// and a margin to grow, the rest goes to the other groups.
+type FairShare struct {
+	lock   sync.Mutex
+	rate   float64
+	groups map[string]*fairGroup
+	slice  time.Duration
+	stop   chan struct{}
+}
+
+// fairGroup is a group of transfers that share a limiter
+type fairGroup struct {
+	weight  float64
+	active  int // number of running transfers
+	limiter *Limiter
+	demand  float64 // bytes per second the group may use, < 0 if unknown
+}
+
+// NewFairShare starts a FairShare of bytesPerSec that rebalances every slice,
+// Stop must be called once it is no longer needed. A nil *FairShare is
+// returned if bytesPerSec is not positive, it hands out nil limiters.
+func NewFairShare(bytesPerSec int64, slice time.Duration) *FairShare {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+
+	f := &FairShare{rate: float64(bytesPerSec), groups: map[string]*fairGroup{}, slice: slice, stop: make(chan struct{})}
+	go f.run()
+	return f
+}
+
+func (f *FairShare) run() {
+	ticker := time.NewTicker(f.slice)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-f.stop:
+			return
+		case <-ticker.C:
+			f.lock.Lock()
+			f.measure(f.slice)
+			f.rebalance()
+			f.lock.Unlock()
+		}
+	}
+}
+
+// Stop stops rebalancing the shares
+func (f *FairShare) Stop() {
+	if f != nil {
+		close(f.stop)
+	}
+}
+
+// Start registers a running transfer of the group name with weight and
+// returns the limiter of the group. Done must be called once the transfer
+// is over.
+func (f *FairShare) Start(name string, weight float64) *Limiter {
+	if f == nil {
+		return nil
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	g, ok := f.groups[name]
+	if !ok {
+		g = &fairGroup{limiter: NewLimiter(int64(f.rate)), demand: -1}
+		f.groups[name] = g
+	}
+	g.weight = weight
+	g.active++
+	if g.active == 1 {
+		g.demand = -1
+		f.rebalance()
+	}
+	return g.limiter
+}
+
+// Done unregisters a transfer registered with Start
+func (f *FairShare) Done(name string) {
+	if f == nil {
+		return
+	}
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	g := f.groups[name]
+	if g.active--; g.active == 0 {
+		delete(f.groups, name)
+		f.rebalance()
+	}
+}
+
+// measure sets the demand of every group from its use during the last slice
+// of length d, f.lock must be held
+func (f *FairShare) measure(d time.Duration) {
+	for _, g := range f.groups {
+		g.demand = g.limiter.takeUsed() / d.Seconds() * fairGrowth
+	}
+}
+
+// rebalance sets the rates of the groups: every group gets its share by
+// weight, but no more than its demand, and what a group doesn't need is
+// split between the others. What no group needs is split between all of
+// them, so that none is held back by a demand that was measured while its
+// share was small. f.lock must be held.
+func (f *FairShare) rebalance() {
+	rates := make(map[*fairGroup]float64, len(f.groups))
+	pending := make([]*fairGroup, 0, len(f.groups))
+	for _, g := range f.groups {
+		pending = append(pending, g)
+	}
+
+	// a small share keeps idle groups from stalling once they receive data
+	minRate := f.rate / 100
+	remaining := f.rate
+	for len(pending) > 0 {
+		weights := 0.0
+		for _, g := range pending {
+			weights += g.weight
+		}
+
+		// the groups that need less than their share are settled first
+		available := remaining
+		unsettled := pending[:0]
+		for _, g := range pending {
+			if g.demand >= 0 && g.demand < available*g.weight/weights {
+				rates[g] = math.Max(g.demand, minRate)
+				remaining -= rates[g]
+				continue
+			}
+			unsettled = append(unsettled, g)
+		}
+		if len(unsettled) == len(pending) {
+			for _, g := range pending {
+				rates[g] = math.Max(remaining*g.weight/weights, minRate)
+			}
+			remaining = 0
+			break
+		}
+		pending = unsettled
+	}
+
+	if remaining > 0 {
+		weights := 0.0
+		for g := range rates {
+			weights += g.weight
+		}
+		for g := range rates {
+			rates[g] += remaining * g.weight / weights
+		}
+	}
+	for g, rate := range rates {
+		g.limiter.setRate(rate)
+	}
+}
@@ -0,0 +1,60 @@
+package ratelimit
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestFairShare(t *testing.T) {
+	testCases := []struct {
+		name     string
+		weights  []float64
+		demands  []float64 // < 0 for unknown
+		expected []float64
+	}{
+		{"single", []float64{1}, []float64{-1}, []float64{1000}},
+		{"equal", []float64{1, 1}, []float64{-1, -1}, []float64{500, 500}},
+		{"weighted", []float64{3, 1}, []float64{-1, -1}, []float64{750, 250}},
+		{"slow group", []float64{1, 1, 1}, []float64{100, -1, -1}, []float64{100, 450, 450}},
+		{"idle group", []float64{1, 1}, []float64{0, 2000}, []float64{10, 990}},
+		{"all slow", []float64{1, 1}, []float64{100, 200}, []float64{450, 550}},
+		{"cascade", []float64{1, 1, 1}, []float64{300, 400, -1}, []float64{300, 350, 350}},
+	}
+
+	for _, testCase := range testCases {
+		f := NewFairShare(1000, time.Hour)
+		limiters := make([]*Limiter, len(testCase.weights))
+		for i, weight := range testCase.weights {
+			limiters[i] = f.Start(string(rune('a'+i)), weight)
+		}
+
+		f.lock.Lock()
+		for i, demand := range testCase.demands {
+			f.groups[string(rune('a'+i))].demand = demand
+		}
+		f.rebalance()
+		f.lock.Unlock()
+		f.Stop()
+
+		for i, expected := range testCase.expected {
+			if received := limiters[i].rate; math.Abs(received-expected) > 0.001 {
+				t.Errorf("%s: group %d expected %.0f received %.0f", testCase.name, i, expected, received)
+			}
+		}
+	}
+
+	// the share of a group that is done goes to the others
+	f := NewFairShare(1000, time.Hour)
+	defer f.Stop()
+	a := f.Start("a", 1)
+	f.Start("b", 1)
+	f.Done("b")
+	if a.rate != 1000 {
+		t.Errorf("expected 1000 after the other group was done, received %.0f", a.rate)
+	}
+
+	if NewFairShare(0, time.Second).Start("a", 1) != nil {
+		t.Error("expected no limiter without a rate")
+	}
+}
@@ -0,0 +1,163 @@
+package ratelimit
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket that hands out bytes at a fixed rate. It is safe
+// for concurrent use, so a single Limiter can cap the combined speed of many
+// readers.
+type Limiter struct {
+	lock   sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64 // maximum number of tokens that can be saved up
+	tokens float64
+	last   time.Time
+	used   float64 // bytes handed out since the last call of takeUsed
+}
+
+// NewLimiter returns a Limiter for bytesPerSec, or nil if bytesPerSec is not
+// positive. A nil *Limiter never blocks.
+func NewLimiter(bytesPerSec int64) *Limiter {
+	if bytesPerSec <= 0 {
+		return nil
+	}
+
+	burst := burstFor(float64(bytesPerSec))
+	return &Limiter{rate: float64(bytesPerSec), burst: burst, tokens: burst, last: time.Now()}
+}
+
+// burstFor returns the burst of a rate: 100ms worth of data, but never less
+// than 1KB so that reads don't degrade into single bytes for very low rates
+func burstFor(rate float64) float64 {
+	if burst := rate / 10; burst > 1024 {
+		return burst
+	}
+	return 1024
+}
+
+// setRate changes the rate of l, the tokens saved up so far are kept
+func (l *Limiter) setRate(bytesPerSec float64) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.refill(time.Now())
+	l.rate = bytesPerSec
+	l.burst = burstFor(bytesPerSec)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+}
+
+// takeUsed returns the number of bytes handed out since the last call
+func (l *Limiter) takeUsed() float64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	used := l.used
+	l.used = 0
+	return used
+}
+
+// refill adds the tokens of the time since the last refill, l.lock must be
+// held
+func (l *Limiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+}
+
+// chunk returns the largest read size that should be requested at once
+func (l *Limiter) chunk() int {
+	if l == nil {
+		return 0
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	return int(l.burst)
+}
+
+// WaitN blocks until n bytes may be transferred or ctx is done, in which
+// case it returns the error of ctx
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	if l == nil || n <= 0 {
+		return nil
+	}
+
+	l.lock.Lock()
+	l.refill(time.Now())
+	l.used += float64(n)
+
+	// take the tokens right away and let the caller sleep off the debt, which
+	// keeps the order in which concurrent callers are served fair
+	l.tokens -= float64(n)
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.lock.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// reader is an io.Reader that is throttled by one or more limiters
+type reader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*Limiter
+	chunk    int
+}
+
+// NewReader returns an io.Reader that reads from r no faster than each of the
+// given limiters allows. nil limiters are ignored. Once ctx is done, reads
+// stop waiting for the limiters and fail with the error of ctx.
+func NewReader(ctx context.Context, r io.Reader, limiters ...*Limiter) io.Reader {
+	rd := &reader{ctx: ctx, r: r}
+	for _, l := range limiters {
+		if l == nil {
+			continue
+		}
+		rd.limiters = append(rd.limiters, l)
+		if rd.chunk == 0 || l.chunk() < rd.chunk {
+			rd.chunk = l.chunk()
+		}
+	}
+
+	if len(rd.limiters) == 0 {
+		return r
+	}
+
+	return rd
+}
+
+func (r *reader) Read(b []byte) (int, error) {
+	if len(b) > r.chunk {
+		b = b[:r.chunk]
+	}
+
+	n, err := r.r.Read(b)
+	for _, l := range r.limiters {
+		if waitErr := l.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestLimiterWaitN(t *testing.T) {
+	l := NewLimiter(1024)
+
+	// the burst is handed out at once
+	if err := l.WaitN(context.Background(), 1024); err != nil {
+		t.Fatalf("expected no error received %v", err)
+	}
+
+	// 10KB more take 10s, unless the context is canceled before
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	if err := l.WaitN(ctx, 10*1024); err != context.Canceled {
+		t.Errorf("expected %v received %v", context.Canceled, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected WaitN to return once canceled, it took %s", elapsed)
+	}
+
+	var nilLimiter *Limiter
+	if err := nilLimiter.WaitN(ctx, 1024); err != nil {
+		t.Errorf("expected a nil limiter not to block received %v", err)
+	}
+}
+
+func TestReaderCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	r := NewReader(ctx, bytes.NewReader(make([]byte, 100*1024)), NewLimiter(1024))
+	if _, err := ioutil.ReadAll(r); err != context.Canceled {
+		t.Errorf("expected %v received %v", context.Canceled, err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("expected the read to stop once canceled, it took %s", elapsed)
+	}
+}
@@ -0,0 +1,103 @@
+package ratelimit
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Watchdog aborts transfers that stall: when less than minSpeed bytes per
+// second were read through it during a whole window, it calls abort once,
+// which should cancel the request so that a blocked Read returns.
+type Watchdog struct {
+	lock     sync.Mutex
+	minSpeed int64
+	window   time.Duration
+	abort    func()
+	n        int64 // bytes read in the current window
+	stalled  bool
+	stop     chan struct{}
+}
+
+// NewWatchdog starts a Watchdog, Stop must be called once the transfer is
+// over. A nil *Watchdog is returned if minSpeed or window is not positive,
+// it never aborts.
+func NewWatchdog(minSpeed int64, window time.Duration, abort func()) *Watchdog {
+	if minSpeed <= 0 || window <= 0 {
+		return nil
+	}
+
+	w := &Watchdog{minSpeed: minSpeed, window: window, abort: abort, stop: make(chan struct{})}
+	go w.watch()
+	return w
+}
+
+func (w *Watchdog) watch() {
+	ticker := time.NewTicker(w.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			if w.check() {
+				w.abort()
+				return
+			}
+		}
+	}
+}
+
+// check ends a window and reports whether the transfer stalled during it
+func (w *Watchdog) check() bool {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	w.stalled = float64(w.n) < float64(w.minSpeed)*w.window.Seconds()
+	w.n = 0
+	return w.stalled
+}
+
+// Stop ends the watch of the transfer
+func (w *Watchdog) Stop() {
+	if w == nil {
+		return
+	}
+	close(w.stop)
+}
+
+// Stalled reports whether the Watchdog aborted the transfer
+func (w *Watchdog) Stalled() bool {
+	if w == nil {
+		return false
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	return w.stalled
+}
+
+// Reader returns a reader that counts the bytes read from r
+func (w *Watchdog) Reader(r io.Reader) io.Reader {
+	if w == nil {
+		return r
+	}
+	return &watchedReader{r: r, w: w}
+}
+
+type watchedReader struct {
+	r io.Reader
+	w *Watchdog
+}
+
+func (r *watchedReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+
+	r.w.lock.Lock()
+	r.w.n += int64(n)
+	r.w.lock.Unlock()
+
+	return n, err
+}
@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestWatchdog(t *testing.T) {
+	testCases := []struct {
+		name     string
+		chunk    int           // bytes sent at a time
+		interval time.Duration // between the chunks
+		expected bool
+	}{
+		{"fast", 1000, time.Millisecond, false},
+		{"slow", 1, 20 * time.Millisecond, true},
+	}
+
+	for _, testCase := range testCases {
+		r, w := io.Pipe()
+		aborted := make(chan struct{})
+		watchdog := NewWatchdog(10000, 100*time.Millisecond, func() {
+			close(aborted)
+			r.CloseWithError(io.ErrUnexpectedEOF)
+		})
+
+		// the writer sends for 350ms unless the transfer is aborted
+		go func() {
+			defer w.Close()
+			for start := time.Now(); time.Since(start) < 350*time.Millisecond; {
+				if _, err := w.Write(bytes.Repeat([]byte("x"), testCase.chunk)); err != nil {
+					return
+				}
+				time.Sleep(testCase.interval)
+			}
+		}()
+
+		_, err := io.Copy(ioutil.Discard, watchdog.Reader(r))
+		watchdog.Stop()
+
+		if watchdog.Stalled() != testCase.expected {
+			t.Errorf("%s: expected stalled=%v received %v (%v)", testCase.name, testCase.expected, watchdog.Stalled(), err)
+		}
+		select {
+		case <-aborted:
+			if !testCase.expected {
+				t.Errorf("%s: expected no abort", testCase.name)
+			}
+		default:
+			if testCase.expected {
+				t.Errorf("%s: expected an abort", testCase.name)
+			}
+		}
+	}
+
+	// a nil Watchdog never aborts
+	if watchdog := NewWatchdog(0, time.Second, nil); watchdog != nil || watchdog.Stalled() {
+		t.Error("expected a nil Watchdog for minSpeed 0")
+	}
+}
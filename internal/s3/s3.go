@@ -0,0 +1,477 @@
+// Package s3 downloads objects from Amazon S3 and compatible object stores
+// through an http.RoundTripper, so that s3://bucket/key urls can be fetched
+// with an http.Client like any other url.
+//
+// Requests are signed with AWS Signature Version 4 when credentials are
+// available, and sent anonymously otherwise, which works for public buckets.
+// Range and HEAD requests are passed on to the object store unchanged, and
+// Put uploads files.
+package s3
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scheme is the url scheme handled by Transport
+const Scheme = "s3"
+
+// DefaultRegion is used when neither Transport.Region nor the environment
+// name a region
+const DefaultRegion = "us-east-1"
+
+// emptyPayloadHash is the SHA-256 of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// unsignedPayload is signed instead of the hash of uploads, which would
+// require reading them twice
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// MaxPutSize is the largest object that can be uploaded with a single PUT
+const MaxPutSize = 5 << 30
+
+// ErrNoBucket is returned for urls without a bucket, like s3:///key
+var ErrNoBucket = errors.New("s3: url has no bucket")
+
+// Credentials are the AWS access keys that requests are signed with
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string // only for temporary credentials
+}
+
+// IsZero reports whether no credentials are set, requests are sent
+// anonymously then
+func (c Credentials) IsZero() bool {
+	return c.AccessKeyID == "" || c.SecretAccessKey == ""
+}
+
+// EnvCredentials returns the credentials of the environment variables
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, or else the
+// ones of the profile AWS_PROFILE ("default" if unset) in the shared
+// credentials file (AWS_SHARED_CREDENTIALS_FILE or ~/.aws/credentials). The
+// zero Credentials are returned if there are none.
+func EnvCredentials() Credentials {
+	c := Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if !c.IsZero() {
+		return c
+	}
+
+	path := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return Credentials{}
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+
+	profile := os.Getenv("AWS_PROFILE")
+	if profile == "" {
+		profile = "default"
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return Credentials{}
+	}
+	defer f.Close()
+
+	c, _ = ParseCredentials(f, profile)
+	return c
+}
+
+// ParseCredentials reads the credentials of profile from a shared
+// credentials file in ini format
+func ParseCredentials(r io.Reader, profile string) (Credentials, error) {
+	var c Credentials
+	section := ""
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' && line[len(line)-1] == ']' {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if section != profile {
+			continue
+		}
+
+		i := strings.Index(line, "=")
+		if i < 0 {
+			continue
+		}
+		value := strings.TrimSpace(line[i+1:])
+		switch strings.ToLower(strings.TrimSpace(line[:i])) {
+		case "aws_access_key_id":
+			c.AccessKeyID = value
+		case "aws_secret_access_key":
+			c.SecretAccessKey = value
+		case "aws_session_token":
+			c.SessionToken = value
+		}
+	}
+
+	return c, scanner.Err()
+}
+
+// Transport is an http.RoundTripper for s3 urls
+type Transport struct {
+	// Credentials sign the requests, they are sent anonymously if zero
+	Credentials Credentials
+
+	// Region of the buckets, DefaultRegion if empty. Buckets in other
+	// regions are found from the redirects of S3.
+	Region string
+
+	// Endpoint of an S3 compatible store like MinIO, e.g.
+	// http://localhost:9000. Buckets are addressed in the path of the
+	// endpoint. AWS is used if empty.
+	Endpoint string
+
+	// Transport sends the signed https requests, http.DefaultTransport is
+	// used if nil
+	Transport http.RoundTripper
+
+	// Now returns the time requests are signed at, time.Now if nil
+	Now func() time.Time
+
+	regions sync.Map // bucket name -> region, learnt from redirects
+}
+
+// FromEnv returns a Transport with the credentials of EnvCredentials, the
+// region of AWS_REGION or AWS_DEFAULT_REGION and the endpoint of
+// AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL
+func FromEnv() *Transport {
+	t := &Transport{
+		Credentials: EnvCredentials(),
+		Region:      os.Getenv("AWS_REGION"),
+		Endpoint:    os.Getenv("AWS_ENDPOINT_URL_S3"),
+	}
+	if t.Region == "" {
+		t.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if t.Endpoint == "" {
+		t.Endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+
+	return t
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Host == "" {
+		return nil, ErrNoBucket
+	}
+
+	return t.do(req.Context(), req.Method, req.URL.Host, strings.TrimPrefix(req.URL.Path, "/"), nil, req.Header, nil, 0)
+}
+
+// Put uploads size bytes of body as the object key in bucket. Objects larger
+// than MaxPutSize would need a multipart upload, which is not supported.
+func (t *Transport) Put(ctx context.Context, bucket, key string, body io.ReadSeeker, size int64) error {
+	if size > MaxPutSize {
+		return fmt.Errorf("s3: %s/%s: objects larger than 5GiB are not supported", bucket, key)
+	}
+
+	response, err := t.do(ctx, http.MethodPut, bucket, key, nil, nil, body, size)
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("s3: uploading %s/%s: %s", bucket, key, errorMessage(response.Status, b))
+	}
+
+	return nil
+}
+
+// List returns the keys of all objects in bucket that start with prefix.
+// Keys ending in "/", which S3 consoles create as folders, are left out.
+func (t *Transport) List(ctx context.Context, bucket, prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		response, err := t.do(ctx, http.MethodGet, bucket, "", query, nil, nil, 0)
+		if err != nil {
+			return nil, err
+		}
+		b, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if response.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("s3: listing %s/%s: %s", bucket, prefix, errorMessage(response.Status, b))
+		}
+
+		var result struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err = xml.Unmarshal(b, &result); err != nil {
+			return nil, fmt.Errorf("s3: listing %s/%s: %v", bucket, prefix, err)
+		}
+
+		for _, c := range result.Contents {
+			if !strings.HasSuffix(c.Key, "/") {
+				keys = append(keys, c.Key)
+			}
+		}
+
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return keys, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// errorMessage returns the message of an S3 error document, or status if
+// body isn't one
+func errorMessage(status string, body []byte) string {
+	var e struct {
+		Code    string
+		Message string
+	}
+	if xml.Unmarshal(body, &e) != nil || e.Code == "" {
+		return status
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// do sends a signed request for key in bucket, with size bytes of body if
+// body isn't nil. A request that S3 redirects to the region of the bucket is
+// repeated there once.
+func (t *Transport) do(ctx context.Context, method, bucket, key string, query url.Values, header http.Header, body io.ReadSeeker, size int64) (*http.Response, error) {
+	region := t.region(bucket)
+
+	for attempt := 0; ; attempt++ {
+		req, err := t.newRequest(ctx, method, bucket, key, query, header, region)
+		if err != nil {
+			return nil, err
+		}
+		if body != nil {
+			if _, err = body.Seek(0, io.SeekStart); err != nil {
+				return nil, err
+			}
+			// the transport must not close the body before a repetition
+			req.Body = ioutil.NopCloser(body)
+			req.ContentLength = size
+			req.Header.Set("X-Amz-Content-Sha256", unsignedPayload)
+		}
+		if !t.Credentials.IsZero() {
+			now := time.Now
+			if t.Now != nil {
+				now = t.Now
+			}
+			sign(req, t.Credentials, region, now())
+		}
+
+		response, err := t.transport().RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+
+		actual := response.Header.Get("X-Amz-Bucket-Region")
+		redirected := response.StatusCode == http.StatusMovedPermanently || response.StatusCode == http.StatusBadRequest
+		if attempt > 0 || !redirected || actual == "" || actual == region || t.Endpoint != "" {
+			return response, nil
+		}
+
+		response.Body.Close()
+		t.regions.Store(bucket, actual)
+		region = actual
+	}
+}
+
+func (t *Transport) transport() http.RoundTripper {
+	if t.Transport == nil {
+		return http.DefaultTransport
+	}
+	return t.Transport
+}
+
+func (t *Transport) region(bucket string) string {
+	if r, ok := t.regions.Load(bucket); ok {
+		return r.(string)
+	}
+	if t.Region == "" {
+		return DefaultRegion
+	}
+	return t.Region
+}
+
+// newRequest returns the https request for key in bucket
+func (t *Transport) newRequest(ctx context.Context, method, bucket, key string, query url.Values, header http.Header, region string) (*http.Request, error) {
+	var base string
+	switch {
+	case t.Endpoint != "":
+		base = strings.TrimSuffix(t.Endpoint, "/") + "/" + bucket
+	case strings.Contains(bucket, "."):
+		// the wildcard certificate of S3 doesn't match dotted bucket names
+		base = fmt.Sprintf("https://s3.%s.amazonaws.com/%s", region, bucket)
+	default:
+		base = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, region)
+	}
+
+	u, err := url.Parse(base + "/" + escape(key, false))
+	if err != nil {
+		return nil, err
+	}
+	u.RawQuery = canonicalQuery(query)
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if header != nil {
+		req.Header = header.Clone()
+	}
+
+	return req, nil
+}
+
+// sign adds the AWS Signature Version 4 headers to req. The payload hash of
+// an X-Amz-Content-Sha256 header is kept, requests without one are signed as
+// having an empty body.
+func sign(req *http.Request, c Credentials, region string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	payloadHash := req.Header.Get("X-Amz-Content-Sha256")
+	if payloadHash == "" {
+		payloadHash = emptyPayloadHash
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+	req.Header.Set("X-Amz-Date", amzDate)
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+	}
+
+	// the headers that proxies and the http client leave alone are signed
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "range" || lower == "content-type" || lower == "content-md5" {
+			trimmed := make([]string, len(values))
+			for i, v := range values {
+				trimmed[i] = strings.Join(strings.Fields(v), " ")
+			}
+			headers[lower] = strings.Join(trimmed, ",")
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hashHex(canonicalRequest)
+
+	key := hmacSHA256([]byte("AWS4"+c.SecretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hashHex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// canonicalQuery encodes query sorted by name, the way it is signed
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var parts []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			parts = append(parts, escape(name, true)+"="+escape(value, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// escape percent-encodes everything but the unreserved characters, and "/"
+// unless slash is set
+func escape(s string, slash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' && !slash {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
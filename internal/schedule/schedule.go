@@ -0,0 +1,211 @@
+// Package schedule decides whether downloads may run at a given time, by
+// daily time windows like 23:00-07:00 or by a cron expression.
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lookahead is how far Next looks for a change of a schedule
+const lookahead = 8 * 24 * time.Hour
+
+// Schedule tells the times at which downloads may run
+type Schedule interface {
+	// Active reports whether downloads may run in the minute of t
+	Active(t time.Time) bool
+}
+
+// Next returns the start of the first minute after t in which s.Active
+// differs from s.Active(t), or the zero time if that doesn't happen within
+// a week
+func Next(s Schedule, t time.Time) time.Time {
+	active := s.Active(t)
+	end := t.Add(lookahead)
+	for m := t.Truncate(time.Minute).Add(time.Minute); m.Before(end); m = m.Add(time.Minute) {
+		if s.Active(m) != active {
+			return m
+		}
+	}
+	return time.Time{}
+}
+
+// window is a daily time window, in minutes since midnight. Windows with
+// from > to span midnight, those with from == to the whole day.
+type window struct {
+	from, to int
+}
+
+// Windows are daily time windows, downloads may run in any of them
+type Windows []window
+
+// ParseWindows parses comma separated daily time windows of the form
+// HH:MM-HH:MM, e.g. 23:00-07:00 or 09:00-12:00,14:00-17:00
+func ParseWindows(s string) (Windows, error) {
+	var windows Windows
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		i := strings.Index(part, "-")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid time window %q, expected HH:MM-HH:MM", part)
+		}
+		from, err := parseClock(part[:i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid time window %q: %v", part, err)
+		}
+		to, err := parseClock(part[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid time window %q: %v", part, err)
+		}
+		windows = append(windows, window{from: from, to: to % (24 * 60)})
+	}
+	if len(windows) == 0 {
+		return nil, fmt.Errorf("no time window in %q", s)
+	}
+	return windows, nil
+}
+
+// parseClock parses a time of the day HH:MM, 24:00 included, into minutes
+// since midnight
+func parseClock(s string) (int, error) {
+	parts := strings.Split(strings.TrimSpace(s), ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	h, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil || len(parts[1]) != 2 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", s)
+	}
+	if h < 0 || m < 0 || m > 59 || h > 24 || h == 24 && m != 0 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return h*60 + m, nil
+}
+
+// Active reports whether t is in one of the windows
+func (w Windows) Active(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	for _, win := range w {
+		switch {
+		case win.from == win.to:
+			return true
+		case win.from < win.to && m >= win.from && m < win.to:
+			return true
+		case win.from > win.to && (m >= win.from || m < win.to):
+			return true
+		}
+	}
+	return false
+}
+
+// field is a parsed field of a cron expression, the set of its values
+type field struct {
+	values map[int]bool
+	any    bool // the field is *
+}
+
+func (f field) match(v int) bool {
+	return f.any || f.values[v]
+}
+
+// Cron is a cron expression with the five fields minute, hour, day of the
+// month, month and day of the week. Downloads may run in the minutes it
+// matches.
+type Cron struct {
+	minute, hour, dom, month, dow field
+}
+
+// ParseCron parses a cron expression, e.g. "* 0-6 * * 1-5" for the nights
+// of the working days. The fields are lists of values, ranges a-b, * and
+// steps like */15 or 8-18/2. The days of the week go from 0 (Sunday) to 7
+// (Sunday again).
+func ParseCron(s string) (*Cron, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q, expected 5 fields", s)
+	}
+
+	limits := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	parsed := make([]field, 5)
+	for i, f := range fields {
+		var err error
+		if parsed[i], err = parseField(f, limits[i][0], limits[i][1]); err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %v", s, err)
+		}
+	}
+	if parsed[4].values[7] {
+		parsed[4].values[0] = true
+	}
+
+	return &Cron{minute: parsed[0], hour: parsed[1], dom: parsed[2], month: parsed[3], dow: parsed[4]}, nil
+}
+
+// parseField parses a field of a cron expression with values from min to max
+func parseField(s string, min, max int) (field, error) {
+	f := field{values: make(map[int]bool)}
+	for _, part := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return f, fmt.Errorf("invalid step in %q", part)
+			}
+			part = part[:i]
+		}
+
+		from, to := min, max
+		switch {
+		case part == "*":
+			if step == 1 {
+				f.any = true
+			}
+		case strings.Contains(part, "-"):
+			i := strings.Index(part, "-")
+			var err1, err2 error
+			from, err1 = strconv.Atoi(part[:i])
+			to, err2 = strconv.Atoi(part[i+1:])
+			if err1 != nil || err2 != nil || from > to {
+				return f, fmt.Errorf("invalid range %q", part)
+			}
+		default:
+			var err error
+			if from, err = strconv.Atoi(part); err != nil {
+				return f, fmt.Errorf("invalid value %q", part)
+			}
+			to = from
+			if step > 1 {
+				to = max
+			}
+		}
+		if from < min || to > max {
+			return f, fmt.Errorf("%q is out of the range %d-%d", part, min, max)
+		}
+		for v := from; v <= to; v += step {
+			f.values[v] = true
+		}
+	}
+	return f, nil
+}
+
+// Active reports whether the cron expression matches the minute of t. Like
+// with cron, if both the day of the month and the day of the week are
+// restricted, either of them has to match.
+func (c *Cron) Active(t time.Time) bool {
+	if !c.minute.match(t.Minute()) || !c.hour.match(t.Hour()) || !c.month.match(int(t.Month())) {
+		return false
+	}
+	dom, dow := c.dom.match(t.Day()), c.dow.match(int(t.Weekday()))
+	if !c.dom.any && !c.dow.any {
+		return dom || dow
+	}
+	return dom && dow
+}
@@ -0,0 +1,111 @@
+package schedule
+
+import (
+	"testing"
+	"time"
+)
+
+func at(day, hour, minute int) time.Time {
+	// 2024-01-01 is a Monday
+	return time.Date(2024, time.January, day, hour, minute, 0, 0, time.UTC)
+}
+
+func TestWindows(t *testing.T) {
+	testCases := []struct {
+		windows  string
+		time     time.Time
+		expected bool
+	}{
+		{"23:00-07:00", at(1, 23, 0), true},
+		{"23:00-07:00", at(1, 3, 30), true},
+		{"23:00-07:00", at(1, 7, 0), false},
+		{"23:00-07:00", at(1, 12, 0), false},
+		{"09:00-12:00,14:00-17:00", at(1, 11, 59), true},
+		{"09:00-12:00,14:00-17:00", at(1, 13, 0), false},
+		{"09:00-12:00,14:00-17:00", at(1, 14, 0), true},
+		{"18:00-24:00", at(1, 23, 59), true},
+		{"18:00-24:00", at(1, 0, 0), false},
+		{"00:00-00:00", at(1, 12, 0), true},
+	}
+
+	for _, testCase := range testCases {
+		w, err := ParseWindows(testCase.windows)
+		if err != nil {
+			t.Fatalf("%s: %v", testCase.windows, err)
+		}
+		if received := w.Active(testCase.time); received != testCase.expected {
+			t.Errorf("%s at %s: expected %v received %v", testCase.windows, testCase.time.Format("15:04"), testCase.expected, received)
+		}
+	}
+}
+
+func TestParseWindowsErrors(t *testing.T) {
+	for _, s := range []string{"", "23:00", "25:00-07:00", "23:60-07:00", "24:30-01:00", "7-9", "ab:cd-07:00"} {
+		if _, err := ParseWindows(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestCron(t *testing.T) {
+	testCases := []struct {
+		cron     string
+		time     time.Time
+		expected bool
+	}{
+		{"* * * * *", at(1, 12, 0), true},
+		{"* 0-6 * * 1-5", at(1, 3, 0), true},
+		{"* 0-6 * * 1-5", at(1, 7, 0), false},
+		{"* 0-6 * * 1-5", at(6, 3, 0), false}, // Saturday
+		{"* * * * 0", at(7, 3, 0), true},      // Sunday
+		{"* * * * 7", at(7, 3, 0), true},
+		{"*/15 * * * *", at(1, 3, 45), true},
+		{"*/15 * * * *", at(1, 3, 46), false},
+		{"0-29 8-18/2 * * *", at(1, 10, 29), true},
+		{"0-29 8-18/2 * * *", at(1, 11, 0), false},
+		{"* * 15 * 1", at(1, 12, 0), true}, // a Monday, not the 15th
+		{"* * 15 * 1", at(2, 12, 0), false},
+		{"* * 1,15 1 *", at(15, 12, 0), true},
+		{"* * * 2 *", at(15, 12, 0), false},
+	}
+
+	for _, testCase := range testCases {
+		c, err := ParseCron(testCase.cron)
+		if err != nil {
+			t.Fatalf("%s: %v", testCase.cron, err)
+		}
+		if received := c.Active(testCase.time); received != testCase.expected {
+			t.Errorf("%q at %s: expected %v received %v", testCase.cron, testCase.time.Format(time.RFC1123), testCase.expected, received)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	for _, s := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "* * * * 8", "*/0 * * * *", "5-1 * * * *", "a * * * *"} {
+		if _, err := ParseCron(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	w, err := ParseWindows("23:00-07:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if received, expected := Next(w, at(1, 12, 30)), at(1, 23, 0); !received.Equal(expected) {
+		t.Errorf("expected %s received %s", expected, received)
+	}
+	if received, expected := Next(w, at(1, 23, 30)), at(2, 7, 0); !received.Equal(expected) {
+		t.Errorf("expected %s received %s", expected, received)
+	}
+
+	all, err := ParseWindows("00:00-00:00")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received := Next(all, at(1, 12, 0)); !received.IsZero() {
+		t.Errorf("expected no change received %s", received)
+	}
+}
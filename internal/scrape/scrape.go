@@ -0,0 +1,403 @@
+// Package scrape extracts the links of HTML pages. The elements are picked
+// with a subset of CSS selectors: type, universal, class, id and attribute
+// selectors, compounds of them and groups separated by commas. Combinators
+// like descendants aren't supported, as the page isn't parsed into a tree.
+package scrape
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// linkAttributes are the attributes that hold the link of an element, in the
+// order they are looked at
+var linkAttributes = []string{"href", "src", "data"}
+
+// rawTextElements hold text that is not parsed for tags
+var rawTextElements = map[string]bool{"script": true, "style": true, "textarea": true, "title": true}
+
+// Page holds the links of a page
+type Page struct {
+	Base  string   // the href of the <base> element, if there is one
+	Links []string // the links as they are written, in the order of the page
+}
+
+// element is a start tag of a page
+type element struct {
+	name  string
+	attrs map[string]string
+}
+
+// Links returns the links of the elements of the page in r that match sel
+func Links(r io.Reader, sel *Selector) (Page, error) {
+	var page Page
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return page, err
+	}
+
+	page.Links = make([]string, 0)
+	forEachElement(string(data), func(e element) {
+		if e.name == "base" && page.Base == "" {
+			page.Base = strings.TrimSpace(e.attrs["href"])
+		}
+		if !sel.matches(e) {
+			return
+		}
+		for _, name := range linkAttributes {
+			if link := strings.TrimSpace(e.attrs[name]); link != "" {
+				page.Links = append(page.Links, link)
+				return
+			}
+		}
+	})
+	return page, nil
+}
+
+// forEachElement calls fn for every start tag of the page s
+func forEachElement(s string, fn func(element)) {
+	for i := 0; i < len(s); {
+		lt := strings.IndexByte(s[i:], '<')
+		if lt < 0 {
+			return
+		}
+		i += lt
+		rest := s[i:]
+
+		switch {
+		case strings.HasPrefix(rest, "<!--"):
+			i = skipPast(s, i+4, "-->")
+		case strings.HasPrefix(rest, "<!"), strings.HasPrefix(rest, "<?"), strings.HasPrefix(rest, "</"):
+			i = skipPast(s, i+2, ">")
+		case len(rest) > 1 && isLetter(rest[1]):
+			var e element
+			e, i = parseTag(s, i+1)
+			fn(e)
+			if rawTextElements[e.name] {
+				i = skipRawText(s, i, e.name)
+			}
+		default:
+			i++
+		}
+	}
+}
+
+// skipPast returns the position after the next end in s from i, or the end
+// of s
+func skipPast(s string, i int, end string) int {
+	if j := strings.Index(s[i:], end); j >= 0 {
+		return i + j + len(end)
+	}
+	return len(s)
+}
+
+// skipRawText returns the position of the end tag of the raw text element
+// name that starts at i
+func skipRawText(s string, i int, name string) int {
+	if j := strings.Index(strings.ToLower(s[i:]), "</"+name); j >= 0 {
+		return i + j
+	}
+	return len(s)
+}
+
+func isLetter(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
+
+// parseTag parses the start tag whose name starts at i and returns it with
+// the position after it
+func parseTag(s string, i int) (element, int) {
+	start := i
+	for i < len(s) && !isSpace(s[i]) && s[i] != '>' && s[i] != '/' {
+		i++
+	}
+	e := element{name: strings.ToLower(s[start:i]), attrs: map[string]string{}}
+
+	for i < len(s) {
+		for i < len(s) && (isSpace(s[i]) || s[i] == '/') {
+			i++
+		}
+		if i >= len(s) || s[i] == '>' {
+			return e, i + 1
+		}
+
+		start = i
+		for i < len(s) && !isSpace(s[i]) && s[i] != '>' && s[i] != '/' && s[i] != '=' {
+			i++
+		}
+		if i == start {
+			i++ // a stray '='
+			continue
+		}
+		name := strings.ToLower(s[start:i])
+
+		for i < len(s) && isSpace(s[i]) {
+			i++
+		}
+		value := ""
+		if i < len(s) && s[i] == '=' {
+			i++
+			for i < len(s) && isSpace(s[i]) {
+				i++
+			}
+			if i < len(s) && (s[i] == '"' || s[i] == '\'') {
+				quote := s[i]
+				end := strings.IndexByte(s[i+1:], quote)
+				if end < 0 {
+					end = len(s) - i - 1
+				}
+				value = s[i+1 : i+1+end]
+				i += end + 2
+			} else {
+				start = i
+				for i < len(s) && !isSpace(s[i]) && s[i] != '>' {
+					i++
+				}
+				value = s[start:i]
+			}
+		}
+		// the first of duplicate attributes counts
+		if _, ok := e.attrs[name]; !ok {
+			e.attrs[name] = html.UnescapeString(value)
+		}
+	}
+	return e, len(s)
+}
+
+// Selector selects the elements of a page
+type Selector struct {
+	groups []compound
+}
+
+// compound is a compound selector, its parts all have to match
+type compound struct {
+	name    string // "" for any element
+	id      string
+	classes []string
+	attrs   []attrSelector
+}
+
+// attrSelector is an attribute selector like [href$=".pdf"]
+type attrSelector struct {
+	name, op, value string
+	fold            bool // the i flag: compare ignoring case
+}
+
+// ParseSelector parses a selector like `a[href$=".pdf"], a.download`
+func ParseSelector(s string) (*Selector, error) {
+	sel := &Selector{}
+	ps := &selectorParser{s: s}
+	for {
+		c, err := ps.parseCompound()
+		if err != nil {
+			return nil, fmt.Errorf("invalid selector %q: %v", s, err)
+		}
+		sel.groups = append(sel.groups, c)
+
+		end := ps.i
+		ps.skipSpaces()
+		if ps.i >= len(ps.s) {
+			return sel, nil
+		}
+		if ps.s[ps.i] != ',' {
+			return nil, fmt.Errorf("invalid selector %q: combinators like %q are not supported", s, ps.s[:end]+ps.s[end:ps.i+1])
+		}
+		ps.i++
+	}
+}
+
+type selectorParser struct {
+	s string
+	i int
+}
+
+func (ps *selectorParser) skipSpaces() {
+	for ps.i < len(ps.s) && isSpace(ps.s[ps.i]) {
+		ps.i++
+	}
+}
+
+// ident parses a name of an element, class, id or attribute
+func (ps *selectorParser) ident() string {
+	start := ps.i
+	for ps.i < len(ps.s) {
+		c := ps.s[ps.i]
+		if !isLetter(c) && !(c >= '0' && c <= '9') && c != '-' && c != '_' {
+			break
+		}
+		ps.i++
+	}
+	return ps.s[start:ps.i]
+}
+
+func (ps *selectorParser) parseCompound() (compound, error) {
+	var c compound
+	ps.skipSpaces()
+	start := ps.i
+	if ps.i < len(ps.s) && ps.s[ps.i] == '*' {
+		ps.i++
+	} else {
+		c.name = strings.ToLower(ps.ident())
+	}
+
+parts:
+	for ps.i < len(ps.s) {
+		switch ps.s[ps.i] {
+		case '.':
+			ps.i++
+			class := ps.ident()
+			if class == "" {
+				return c, errors.New("empty class")
+			}
+			c.classes = append(c.classes, class)
+		case '#':
+			ps.i++
+			if c.id = ps.ident(); c.id == "" {
+				return c, errors.New("empty id")
+			}
+		case '[':
+			ps.i++
+			attr, err := ps.parseAttr()
+			if err != nil {
+				return c, err
+			}
+			c.attrs = append(c.attrs, attr)
+		default:
+			break parts
+		}
+	}
+
+	switch {
+	case ps.i > start:
+		return c, nil
+	case ps.i < len(ps.s):
+		return c, fmt.Errorf("unexpected %q", ps.s[ps.i:])
+	}
+	return c, errors.New("empty selector")
+}
+
+// parseAttr parses an attribute selector after its [
+func (ps *selectorParser) parseAttr() (attrSelector, error) {
+	var a attrSelector
+	ps.skipSpaces()
+	if a.name = strings.ToLower(ps.ident()); a.name == "" {
+		return a, errors.New("empty attribute name")
+	}
+	ps.skipSpaces()
+	if ps.i < len(ps.s) && ps.s[ps.i] == ']' {
+		ps.i++
+		return a, nil
+	}
+
+	for _, op := range []string{"=", "~=", "|=", "^=", "$=", "*="} {
+		if strings.HasPrefix(ps.s[ps.i:], op) {
+			a.op = op
+		}
+	}
+	if a.op == "" {
+		return a, fmt.Errorf("unexpected %q in attribute selector", ps.s[ps.i:])
+	}
+	ps.i += len(a.op)
+	ps.skipSpaces()
+
+	if ps.i < len(ps.s) && (ps.s[ps.i] == '"' || ps.s[ps.i] == '\'') {
+		quote := ps.s[ps.i]
+		end := strings.IndexByte(ps.s[ps.i+1:], quote)
+		if end < 0 {
+			return a, errors.New("unterminated string")
+		}
+		a.value = ps.s[ps.i+1 : ps.i+1+end]
+		ps.i += end + 2
+	} else {
+		// unquoted values are taken up to the ], like .pdf in [href$=.pdf]
+		start := ps.i
+		for ps.i < len(ps.s) && ps.s[ps.i] != ']' && !isSpace(ps.s[ps.i]) {
+			ps.i++
+		}
+		a.value = ps.s[start:ps.i]
+	}
+
+	ps.skipSpaces()
+	if ps.i < len(ps.s) && (ps.s[ps.i] == 'i' || ps.s[ps.i] == 'I') {
+		a.fold = true
+		ps.i++
+		ps.skipSpaces()
+	}
+	if ps.i >= len(ps.s) || ps.s[ps.i] != ']' {
+		return a, errors.New("missing ]")
+	}
+	ps.i++
+	return a, nil
+}
+
+// matches reports whether e matches one of the groups of sel
+func (sel *Selector) matches(e element) bool {
+	for _, c := range sel.groups {
+		if c.matches(e) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c compound) matches(e element) bool {
+	if c.name != "" && c.name != e.name {
+		return false
+	}
+	if c.id != "" && e.attrs["id"] != c.id {
+		return false
+	}
+	classes := strings.Fields(e.attrs["class"])
+	for _, class := range c.classes {
+		if !contains(classes, class) {
+			return false
+		}
+	}
+	for _, a := range c.attrs {
+		value, ok := e.attrs[a.name]
+		if !ok || !a.matches(value) {
+			return false
+		}
+	}
+	return true
+}
+
+func (a attrSelector) matches(value string) bool {
+	want := a.value
+	if a.fold {
+		value, want = strings.ToLower(value), strings.ToLower(want)
+	}
+	switch a.op {
+	case "":
+		return true
+	case "=":
+		return value == want
+	case "~=":
+		return contains(strings.Fields(value), want)
+	case "|=":
+		return value == want || strings.HasPrefix(value, want+"-")
+	case "^=":
+		return want != "" && strings.HasPrefix(value, want)
+	case "$=":
+		return want != "" && strings.HasSuffix(value, want)
+	default: // *=
+		return want != "" && strings.Contains(value, want)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package scrape
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const testPage = `<!DOCTYPE html>
+<html><head>
+<title>a <a href="title.pdf"></title>
+<base href="https://example.com/files/">
+<link rel=stylesheet href=style.css>
+<script>document.write('<a href="script.pdf">')</script>
+</head><body>
+<!-- <a href="comment.pdf"> -->
+<a href="report.PDF" class="doc download">Report</a>
+<a id=main HREF='data.csv?a=1&amp;b=2'>Data</a>
+<a name="anchor">No link</a>
+<img src="/logo.png" alt="">
+<a href="https://other.example.com/x.pdf" data-kind=external>x</a>
+</body></html>`
+
+func TestLinks(t *testing.T) {
+	testCases := []struct {
+		selector string
+		expected []string
+	}{
+		{"a", []string{"report.PDF", "data.csv?a=1&b=2", "https://other.example.com/x.pdf"}},
+		{`a[href$=".pdf"]`, []string{"https://other.example.com/x.pdf"}},
+		{`a[href$=.pdf i]`, []string{"report.PDF", "https://other.example.com/x.pdf"}},
+		{"a.download, img", []string{"report.PDF", "/logo.png"}},
+		{"#main", []string{"data.csv?a=1&b=2"}},
+		{"a.doc.download[class~=doc]", []string{"report.PDF"}},
+		{"[data-kind]", []string{"https://other.example.com/x.pdf"}},
+		{`link[rel="stylesheet"]`, []string{"style.css"}},
+		{`*[href^=https]`, []string{"https://example.com/files/", "https://other.example.com/x.pdf"}},
+		{"video", []string{}},
+	}
+	for _, testCase := range testCases {
+		sel, err := ParseSelector(testCase.selector)
+		if err != nil {
+			t.Errorf("%s: %v", testCase.selector, err)
+			continue
+		}
+		received, err := Links(strings.NewReader(testPage), sel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(received.Links, testCase.expected) {
+			t.Errorf("%s: expected %q received %q", testCase.selector, testCase.expected, received.Links)
+		}
+		if received.Base != "https://example.com/files/" {
+			t.Errorf("%s: expected the base https://example.com/files/ received %q", testCase.selector, received.Base)
+		}
+	}
+}
+
+func TestParseSelector(t *testing.T) {
+	for _, invalid := range []string{"", "div a", "a > b", "a,", "a[href", `a[href="x]`, "a[href!=x]", ".", "#"} {
+		if _, err := ParseSelector(invalid); err == nil {
+			t.Errorf("%q expected an error", invalid)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package sharelink
+
+import (
+	"html"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// maxInterstitialSize is the maximum number of bytes read from a page that
+// may be a download confirmation page
+const maxInterstitialSize = 1 << 20
+
+var (
+	driveFilePath = regexp.MustCompile(`^/file/d/([^/]+)`)
+	formAction    = regexp.MustCompile(`(?is)<form[^>]*id="download-form"[^>]*action="([^"]+)"`)
+	hiddenInput   = regexp.MustCompile(`(?is)<input[^>]*type="hidden"[^>]*name="([^"]+)"[^>]*value="([^"]*)"`)
+	confirmParam  = regexp.MustCompile(`confirm=([0-9A-Za-z_-]+)`)
+)
+
+// driveDownload returns the direct download url of a Google Drive file
+func driveDownload(id string) *url.URL {
+	return &url.URL{
+		Scheme:   "https",
+		Host:     "drive.usercontent.google.com",
+		Path:     "/download",
+		RawQuery: url.Values{"id": {id}, "export": {"download"}, "confirm": {"t"}}.Encode(),
+	}
+}
+
+// Resolve rewrites the share links of Google Drive and Dropbox into urls that
+// download the file directly. ok is false if u is not a known share link.
+func Resolve(u *url.URL) (direct *url.URL, ok bool) {
+	host := strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+
+	switch host {
+	case "drive.google.com", "docs.google.com":
+		if m := driveFilePath.FindStringSubmatch(u.Path); m != nil {
+			return driveDownload(m[1]), true
+		}
+		if id := u.Query().Get("id"); id != "" && (u.Path == "/open" || u.Path == "/uc") {
+			return driveDownload(id), true
+		}
+
+	case "dropbox.com":
+		if strings.HasPrefix(u.Path, "/s/") || strings.HasPrefix(u.Path, "/scl/") {
+			q := u.Query()
+			if q.Get("dl") == "1" {
+				return u, false
+			}
+			q.Set("dl", "1")
+			d := *u
+			d.RawQuery = q.Encode()
+			return &d, true
+		}
+	}
+
+	return u, false
+}
+
+// ConfirmURL extracts the url behind the "download anyway" button of a Google
+// Drive virus scan warning page
+func ConfirmURL(base *url.URL, page []byte) (*url.URL, bool) {
+	if m := formAction.FindSubmatch(page); m != nil {
+		action, err := base.Parse(html.UnescapeString(string(m[1])))
+		if err != nil {
+			return nil, false
+		}
+
+		q := url.Values{}
+		for _, input := range hiddenInput.FindAllSubmatch(page, -1) {
+			q.Set(html.UnescapeString(string(input[1])), html.UnescapeString(string(input[2])))
+		}
+		action.RawQuery = q.Encode()
+
+		return action, true
+	}
+
+	// older pages link to the file with a confirm token
+	if m := confirmParam.FindSubmatch(page); m != nil {
+		confirm := *base
+		q := confirm.Query()
+		q.Set("confirm", string(m[1]))
+		confirm.RawQuery = q.Encode()
+		return &confirm, true
+	}
+
+	return nil, false
+}
+
+// Transport is a http.RoundTripper that resolves share links before passing
+// requests on to Transport, and follows download confirmation pages.
+type Transport struct {
+	Transport http.RoundTripper
+}
+
+// RoundTrip sends req to the direct download url of its share link
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if direct, ok := Resolve(req.URL); ok {
+		req = req.Clone(req.Context())
+		req.URL = direct
+		req.Host = ""
+	}
+
+	response, err := t.Transport.RoundTrip(req)
+	if err != nil || !isDriveInterstitial(response) {
+		return response, err
+	}
+
+	page, err := ioutil.ReadAll(io.LimitReader(response.Body, maxInterstitialSize))
+	_ = response.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	confirm, ok := ConfirmURL(req.URL, page)
+	if !ok {
+		// not a confirmation page after all, hand it out as it is
+		response.Body = ioutil.NopCloser(strings.NewReader(string(page)))
+		return response, nil
+	}
+
+	req = req.Clone(req.Context())
+	req.URL = confirm
+	req.Host = ""
+
+	return t.Transport.RoundTrip(req)
+}
+
+// isDriveInterstitial returns true if response may be a Google Drive page
+// that asks for confirmation before downloading a file
+func isDriveInterstitial(response *http.Response) bool {
+	host := response.Request.URL.Hostname()
+	if host != "drive.google.com" && host != "drive.usercontent.google.com" && host != "docs.google.com" {
+		return false
+	}
+
+	return response.StatusCode == http.StatusOK &&
+		strings.HasPrefix(response.Header.Get("Content-Type"), "text/html")
+}
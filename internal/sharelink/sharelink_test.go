@@ -0,0 +1,66 @@
+package sharelink
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	testCases := []struct {
+		link       string
+		expectedOk bool
+		expected   string
+	}{
+		{
+			"https://drive.google.com/file/d/1AbC_dEf/view?usp=sharing",
+			true,
+			"https://drive.usercontent.google.com/download?confirm=t&export=download&id=1AbC_dEf",
+		},
+		{
+			"https://drive.google.com/open?id=XYZ",
+			true,
+			"https://drive.usercontent.google.com/download?confirm=t&export=download&id=XYZ",
+		},
+		{
+			"https://www.dropbox.com/s/abc123/file.zip?dl=0",
+			true,
+			"https://www.dropbox.com/s/abc123/file.zip?dl=1",
+		},
+		{
+			"https://www.dropbox.com/scl/fi/abc/file.zip?rlkey=k&dl=0",
+			true,
+			"https://www.dropbox.com/scl/fi/abc/file.zip?dl=1&rlkey=k",
+		},
+		{"https://www.dropbox.com/s/abc123/file.zip?dl=1", false, ""},
+		{"https://example.com/file/d/123/view", false, ""},
+	}
+
+	for _, testCase := range testCases {
+		u, err := url.Parse(testCase.link)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		direct, ok := Resolve(u)
+		if ok != testCase.expectedOk || (ok && direct.String() != testCase.expected) {
+			t.Errorf("link=%s returned ok=%v url=%s", testCase.link, ok, direct)
+		}
+	}
+}
+
+func TestConfirmURL(t *testing.T) {
+	base, _ := url.Parse("https://drive.usercontent.google.com/download?id=XYZ&export=download")
+	page := []byte(`<html><form id="download-form" action="https://drive.usercontent.google.com/download" method="get">
+		<input type="hidden" name="id" value="XYZ"><input type="hidden" name="export" value="download">
+		<input type="hidden" name="confirm" value="t"><input type="hidden" name="uuid" value="1234-abcd"></form></html>`)
+
+	confirm, ok := ConfirmURL(base, page)
+	expected := "https://drive.usercontent.google.com/download?confirm=t&export=download&id=XYZ&uuid=1234-abcd"
+	if !ok || confirm.String() != expected {
+		t.Errorf("received ok=%v url=%s", ok, confirm)
+	}
+
+	if _, ok = ConfirmURL(base, []byte("<html>nothing here</html>")); ok {
+		t.Error("expected no confirm url")
+	}
+}
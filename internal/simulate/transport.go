@@ -0,0 +1,237 @@
+package simulate
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInjected is returned for simulated connection failures
+var ErrInjected = errors.New("simulated connection failure")
+
+// Transport is a http.RoundTripper that never touches the network. Every url
+// is answered with a generated file whose size, latency and failures are
+// derived from the url, the attempt number and the seed, so that two runs with
+// the same configuration behave identically regardless of scheduling.
+type Transport struct {
+	Latency     time.Duration // base latency of each response
+	FailureRate float64       // probability (0-1) that an attempt fails
+	MinSize     int64         // minimum size of a generated file
+	MaxSize     int64         // maximum size of a generated file
+	Seed        int64         // seed for all generated values
+	Chaos       Chaos         // faults injected on top of FailureRate
+
+	lock     sync.Mutex
+	attempts map[string]int
+}
+
+// Chaos describes faults that are injected into the responses of a
+// Transport, to check that retries, timeouts and checksums catch them. The
+// rates are probabilities (0-1) per attempt.
+type Chaos struct {
+	DropRate    float64       // the connection is dropped before the response
+	DelayRate   float64       // the response is held back for Delay
+	Delay       time.Duration // how long delayed responses are held back
+	CorruptRate float64       // a byte of the body is flipped
+}
+
+// ErrDropped is returned for connections dropped by Chaos
+var ErrDropped = errors.New("connection dropped by chaos testing")
+
+// roll returns a deterministic value in [0, 1) for the given key
+func (t *Transport) roll(key string) float64 {
+	h := fnv.New64a()
+	_, _ = fmt.Fprintf(h, "%d|%s", t.Seed, key)
+	return float64(h.Sum64()%1000000) / 1000000
+}
+
+// nextAttempt returns how many times url has been requested before
+func (t *Transport) nextAttempt(url string) int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.attempts == nil {
+		t.attempts = make(map[string]int)
+	}
+	n := t.attempts[url]
+	t.attempts[url]++
+
+	return n
+}
+
+// Size returns the size of the file that is generated for url
+func (t *Transport) Size(url string) int64 {
+	if t.MaxSize <= t.MinSize {
+		return t.MinSize
+	}
+	return t.MinSize + int64(t.roll("size|"+url)*float64(t.MaxSize-t.MinSize+1))
+}
+
+// RoundTrip answers req with a generated response
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	url := req.URL.String()
+	attempt := t.nextAttempt(url)
+	key := fmt.Sprintf("%s|%d", url, attempt)
+
+	// latency varies between 50% and 150% of the configured value
+	latency := time.Duration(float64(t.Latency) * (0.5 + t.roll("latency|"+key)))
+	if t.roll("delay|"+key) < t.Chaos.DelayRate {
+		latency += t.Chaos.Delay
+	}
+	select {
+	case <-time.After(latency):
+	case <-req.Context().Done():
+		return nil, req.Context().Err()
+	}
+	if t.roll("drop|"+key) < t.Chaos.DropRate {
+		return nil, ErrDropped
+	}
+
+	// failures are split evenly between refused connections, server errors
+	// and transfers that break off halfway
+	failure := t.roll("failure|" + key)
+	failed := failure < t.FailureRate
+	if failed && failure < t.FailureRate/3 {
+		return nil, ErrInjected
+	}
+
+	response := &http.Response{
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Request:    req,
+	}
+
+	if failed && failure < 2*t.FailureRate/3 {
+		response.StatusCode = http.StatusServiceUnavailable
+		response.Status = "503 Service Unavailable"
+		response.Body = ioutil.NopCloser(strings.NewReader("simulated server error"))
+		return response, nil
+	}
+
+	size := t.Size(url)
+	start, end := int64(0), size-1
+	response.StatusCode = http.StatusOK
+	response.Header.Set("Accept-Ranges", "bytes")
+
+	if r := req.Header.Get("Range"); r != "" {
+		var ok bool
+		if start, end, ok = parseRange(r, size); !ok {
+			response.StatusCode = http.StatusRequestedRangeNotSatisfiable
+			response.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			response.Body = ioutil.NopCloser(strings.NewReader(""))
+			return response, nil
+		}
+		response.StatusCode = http.StatusPartialContent
+		response.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	}
+
+	response.Status = fmt.Sprintf("%d %s", response.StatusCode, http.StatusText(response.StatusCode))
+	response.ContentLength = end - start + 1
+	response.Header.Set("Content-Length", strconv.FormatInt(response.ContentLength, 10))
+
+	// with corruption the complete files carry the digest of their intact
+	// content, against which the client can check them
+	if t.Chaos.CorruptRate > 0 && response.StatusCode == http.StatusOK {
+		response.Header.Set("Content-MD5", contentMD5(size))
+	}
+
+	body := &generator{offset: start, remaining: response.ContentLength, corruptAt: -1}
+	if failed {
+		body.failAfter = response.ContentLength / 2
+	}
+	if t.roll("corrupt|"+key) < t.Chaos.CorruptRate {
+		body.corruptAt = start + int64(t.roll("corrupt-at|"+key)*float64(response.ContentLength))
+	}
+	if req.Method == "HEAD" {
+		body.remaining = 0
+	}
+	response.Body = ioutil.NopCloser(body)
+
+	return response, nil
+}
+
+// parseRange parses a single "bytes=a-b" or "bytes=a-" range header
+func parseRange(r string, size int64) (start, end int64, ok bool) {
+	if !strings.HasPrefix(r, "bytes=") {
+		return 0, 0, false
+	}
+	bounds := strings.SplitN(strings.TrimPrefix(r, "bytes="), "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, false
+	}
+
+	start, err := strconv.ParseInt(bounds[0], 10, 64)
+	if err != nil || start >= size {
+		return 0, 0, false
+	}
+
+	end = size - 1
+	if bounds[1] != "" {
+		if end, err = strconv.ParseInt(bounds[1], 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	return start, end, true
+}
+
+// contentMD5 returns the base64 encoded md5 of a generated file of size
+// bytes, as sent in a Content-MD5 header
+func contentMD5(size int64) string {
+	h := md5.New()
+	_, _ = io.Copy(h, &generator{remaining: size, corruptAt: -1})
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// generator produces the content of a generated file, where the byte at
+// position i always has the value i%251
+type generator struct {
+	offset    int64
+	remaining int64
+	failAfter int64 // fail after this many bytes if > 0
+	read      int64
+	corruptAt int64 // position of a flipped byte, or -1
+}
+
+func (g *generator) Read(b []byte) (int, error) {
+	if g.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if g.failAfter > 0 && g.read >= g.failAfter {
+		return 0, io.ErrUnexpectedEOF
+	}
+
+	n := int64(len(b))
+	if n > g.remaining {
+		n = g.remaining
+	}
+	if g.failAfter > 0 && g.read+n > g.failAfter {
+		n = g.failAfter - g.read
+	}
+
+	for i := int64(0); i < n; i++ {
+		b[i] = byte((g.offset + i) % 251)
+	}
+	if g.corruptAt >= g.offset && g.corruptAt < g.offset+n {
+		b[g.corruptAt-g.offset] ^= 0xff
+	}
+	g.offset += n
+	g.remaining -= n
+	g.read += n
+
+	return int(n), nil
+}
@@ -0,0 +1,97 @@
+package simulate
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"testing"
+)
+
+func TestTransportIsDeterministic(t *testing.T) {
+	results := func() []int {
+		transport := &Transport{FailureRate: 0.5, MinSize: 10, MaxSize: 1000, Seed: 42}
+		client := &http.Client{Transport: transport}
+
+		var statuses []int
+		for i := 0; i < 20; i++ {
+			response, err := client.Get("http://example.com/file.bin")
+			if err != nil {
+				statuses = append(statuses, -1)
+				continue
+			}
+			b, _ := ioutil.ReadAll(response.Body)
+			statuses = append(statuses, response.StatusCode, len(b))
+		}
+		return statuses
+	}
+
+	first, second := results(), results()
+	if len(first) != len(second) {
+		t.Fatalf("runs differ: %v != %v", first, second)
+	}
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatalf("runs differ: %v != %v", first, second)
+		}
+	}
+}
+
+func TestTransportRange(t *testing.T) {
+	transport := &Transport{MinSize: 1000, MaxSize: 1000}
+	client := &http.Client{Transport: transport}
+
+	req, err := http.NewRequest("GET", "http://example.com/file.bin", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Range", "bytes=500-")
+
+	response, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.StatusCode != http.StatusPartialContent || len(b) != 500 || b[0] != byte(500%251) {
+		t.Errorf("received status=%d len=%d first byte=%d", response.StatusCode, len(b), b[0])
+	}
+	if cr := response.Header.Get("Content-Range"); cr != "bytes 500-999/1000" {
+		t.Errorf("received Content-Range %q", cr)
+	}
+}
+
+func TestTransportChaos(t *testing.T) {
+	transport := &Transport{MinSize: 1000, MaxSize: 1000, Chaos: Chaos{CorruptRate: 1}}
+	client := &http.Client{Transport: transport}
+
+	response, err := client.Get("http://example.com/file.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	corrupted := 0
+	for i := range b {
+		if b[i] != byte(i%251) {
+			corrupted++
+		}
+	}
+	if corrupted != 1 {
+		t.Errorf("expected 1 corrupted byte received %d", corrupted)
+	}
+	if received := response.Header.Get("Content-MD5"); received != contentMD5(1000) {
+		t.Errorf("expected Content-MD5 %s received %q", contentMD5(1000), received)
+	}
+
+	transport = &Transport{Chaos: Chaos{DropRate: 1}}
+	if _, err = (&http.Client{Transport: transport}).Get("http://example.com/file.bin"); !errors.Is(err, ErrDropped) {
+		t.Errorf("expected %v received %v", ErrDropped, err)
+	}
+}
@@ -0,0 +1,73 @@
+// Package sitemap reads the urls of XML sitemaps and sitemap index files, as
+// described on https://www.sitemaps.org. Sitemaps may be gzip compressed.
+package sitemap
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Sitemap holds the urls of a sitemap
+type Sitemap struct {
+	URLs     []string // the pages of a <urlset>
+	Sitemaps []string // the sitemaps of a <sitemapindex>
+}
+
+type location struct {
+	Loc string `xml:"loc"`
+}
+
+type document struct {
+	XMLName  xml.Name
+	URLs     []location `xml:"url"`
+	Sitemaps []location `xml:"sitemap"`
+}
+
+// gzipMagic are the first bytes of gzip compressed data
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Parse reads the sitemap or sitemap index in r
+func Parse(r io.Reader) (Sitemap, error) {
+	var sitemap Sitemap
+
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(len(gzipMagic)); err == nil && string(magic) == string(gzipMagic) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return sitemap, err
+		}
+		defer zr.Close()
+		r = zr
+	} else {
+		r = br
+	}
+
+	var doc document
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return sitemap, err
+	}
+	switch doc.XMLName.Local {
+	case "urlset":
+		sitemap.URLs = locations(doc.URLs)
+	case "sitemapindex":
+		sitemap.Sitemaps = locations(doc.Sitemaps)
+	default:
+		return sitemap, fmt.Errorf("expected <urlset> or <sitemapindex>, found <%s>", doc.XMLName.Local)
+	}
+	return sitemap, nil
+}
+
+// locations returns the non-empty locations of l
+func locations(l []location) []string {
+	urls := make([]string, 0, len(l))
+	for _, loc := range l {
+		if u := strings.TrimSpace(loc.Loc); u != "" {
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
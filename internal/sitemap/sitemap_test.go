@@ -0,0 +1,65 @@
+package sitemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		document string
+		expected Sitemap
+	}{
+		{`<?xml version="1.0" encoding="UTF-8"?>
+<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>http://example.com/</loc><lastmod>2020-01-01</lastmod></url>
+  <url><loc>
+    http://example.com/a?b=1&amp;c=2
+  </loc></url>
+  <url><loc></loc></url>
+</urlset>`, Sitemap{URLs: []string{"http://example.com/", "http://example.com/a?b=1&c=2"}}},
+		{`<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>http://example.com/sitemap1.xml.gz</loc></sitemap>
+  <sitemap><loc>http://example.com/sitemap2.xml</loc></sitemap>
+</sitemapindex>`, Sitemap{Sitemaps: []string{"http://example.com/sitemap1.xml.gz", "http://example.com/sitemap2.xml"}}},
+		{`<urlset></urlset>`, Sitemap{URLs: []string{}}},
+	}
+	for _, testCase := range testCases {
+		received, err := Parse(strings.NewReader(testCase.document))
+		if err != nil {
+			t.Errorf("%q: %v", testCase.document, err)
+			continue
+		}
+		if !reflect.DeepEqual(received, testCase.expected) {
+			t.Errorf("%q: expected %#v received %#v", testCase.document, testCase.expected, received)
+		}
+	}
+
+	for _, invalid := range []string{"", "<html></html>", "<urlset><url>"} {
+		if _, err := Parse(strings.NewReader(invalid)); err == nil {
+			t.Errorf("%q expected an error", invalid)
+		}
+	}
+}
+
+func TestParseGzip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(`<urlset><url><loc>http://example.com/a</loc></url></urlset>`)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	received, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"http://example.com/a"}; !reflect.DeepEqual(received.URLs, expected) {
+		t.Errorf("expected %v received %v", expected, received.URLs)
+	}
+}
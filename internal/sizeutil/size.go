@@ -0,0 +1,87 @@
+package sizeutil
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// units maps the supported suffixes to their size in bytes. Decimal units are
+// used for KB, MB, ... to match the mB figures printed in the statistics.
+var units = []struct {
+	suffix string
+	bytes  float64
+}{
+	{"KIB", 1 << 10},
+	{"MIB", 1 << 20},
+	{"GIB", 1 << 30},
+	{"TIB", 1 << 40},
+	{"KB", 1e3},
+	{"MB", 1e6},
+	{"GB", 1e9},
+	{"TB", 1e12},
+	{"K", 1e3},
+	{"M", 1e6},
+	{"G", 1e9},
+	{"T", 1e12},
+	{"B", 1},
+}
+
+// ParseSize parses a human readable size like "512", "1.5MB" or "2GiB" and
+// returns the number of bytes it describes.
+func ParseSize(s string) (int64, error) {
+	v := strings.ToUpper(strings.TrimSpace(s))
+	multiplier := 1.0
+
+	for _, unit := range units {
+		if strings.HasSuffix(v, unit.suffix) {
+			v = strings.TrimSpace(strings.TrimSuffix(v, unit.suffix))
+			multiplier = unit.bytes
+			break
+		}
+	}
+
+	// NaN, Inf and sizes beyond an int64 parse as floats, but have no
+	// number of bytes
+	n, err := strconv.ParseFloat(v, 64)
+	bytes := n * multiplier
+	if err != nil || n < 0 || math.IsNaN(bytes) || bytes >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+
+	return int64(bytes), nil
+}
+
+// ParseRate parses a transfer rate like "2MB/s", "200MBps" or "500KB" and
+// returns the number of bytes per second it describes.
+func ParseRate(s string) (int64, error) {
+	v := strings.TrimSpace(s)
+	switch {
+	case strings.HasSuffix(strings.ToLower(v), "/s"):
+		v = v[:len(v)-2]
+	case strings.HasSuffix(strings.ToLower(v), "ps"):
+		v = v[:len(v)-2]
+	}
+
+	n, err := ParseSize(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+
+	return n, nil
+}
+
+// FormatSize formats n bytes with the largest decimal unit that keeps the
+// value at or above 1, e.g. "1.50 GB"
+func FormatSize(n int64) string {
+	for _, unit := range []struct {
+		suffix string
+		bytes  float64
+	}{{"TB", 1e12}, {"GB", 1e9}, {"MB", 1e6}, {"KB", 1e3}} {
+		if float64(n) >= unit.bytes {
+			return fmt.Sprintf("%.2f %s", float64(n)/unit.bytes, unit.suffix)
+		}
+	}
+	return fmt.Sprintf("%d B", n)
+}
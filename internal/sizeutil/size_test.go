@@ -0,0 +1,93 @@
+package sizeutil
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	testCases := []struct {
+		size          string
+		expectedBytes int64
+		expectErr     bool
+	}{
+		{"0", 0, false},
+		{"512", 512, false},
+		{"512B", 512, false},
+		{"2KB", 2000, false},
+		{"1.5MB", 1500000, false},
+		{"100mb", 100000000, false},
+		{"1GiB", 1 << 30, false},
+		{"3 MiB", 3 << 20, false},
+		{"2G", 2000000000, false},
+		{"MB", 0, true},
+		{"-1MB", 0, true},
+		{"ten", 0, true},
+		{"NaN", 0, true},
+		{"Inf", 0, true},
+		{"+InfMB", 0, true},
+		{"1e400", 0, true},
+		{"9223372036854775807", 0, true},
+		{"10000000TB", 0, true},
+		{"9000000TB", 9000000000000000000, false},
+	}
+
+	for _, testCase := range testCases {
+		n, err := ParseSize(testCase.size)
+		if testCase.expectErr {
+			if err == nil {
+				t.Errorf("size=%#v expected an error, received %d", testCase.size, n)
+			}
+			continue
+		}
+
+		if err != nil || n != testCase.expectedBytes {
+			t.Errorf("size=%#v expected %d received %d (err=%v)", testCase.size, testCase.expectedBytes, n, err)
+		}
+	}
+}
+
+func TestParseRate(t *testing.T) {
+	testCases := []struct {
+		rate          string
+		expectedBytes int64
+		expectErr     bool
+	}{
+		{"2MB/s", 2000000, false},
+		{"200MBps", 200000000, false},
+		{"500KB", 500000, false},
+		{"1MiB/s", 1 << 20, false},
+		{"fast", 0, true},
+	}
+
+	for _, testCase := range testCases {
+		n, err := ParseRate(testCase.rate)
+		if testCase.expectErr {
+			if err == nil {
+				t.Errorf("rate=%#v expected an error, received %d", testCase.rate, n)
+			}
+			continue
+		}
+
+		if err != nil || n != testCase.expectedBytes {
+			t.Errorf("rate=%#v expected %d received %d (err=%v)", testCase.rate, testCase.expectedBytes, n, err)
+		}
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	testCases := []struct {
+		bytes    int64
+		expected string
+	}{
+		{0, "0 B"},
+		{999, "999 B"},
+		{1000, "1.00 KB"},
+		{1500000, "1.50 MB"},
+		{2 << 30, "2.15 GB"},
+		{3e12, "3.00 TB"},
+	}
+
+	for _, testCase := range testCases {
+		if s := FormatSize(testCase.bytes); s != testCase.expected {
+			t.Errorf("bytes=%d expected %q received %q", testCase.bytes, testCase.expected, s)
+		}
+	}
+}
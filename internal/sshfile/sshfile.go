@@ -0,0 +1,287 @@
+// Package sshfile downloads files from servers that are only reachable over
+// SSH, through an http.RoundTripper so that they can be fetched with an
+// http.Client like any other url.
+//
+// The files are read by running the ssh command with cat, tail, head and wc
+// on the server, and written with mkdir and cat, so the server must allow
+// shell commands. Authentication is
+// left to ssh: keys, the agent and ~/.ssh/config work as usual.
+package sshfile
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// url schemes that are handled by Transport
+var Schemes = []string{"sftp", "scp"}
+
+// sshConnectionError is the exit status of ssh when it could not connect
+const sshConnectionError = 255
+
+// Transport is an http.RoundTripper for sftp://[user@]host[:port]/path and
+// scp:// urls. Paths are absolute, /~/ refers to the home directory.
+type Transport struct {
+	// Command is the ssh executable, "ssh" if empty
+	Command string
+
+	// KeyFile is the private key to authenticate with, if set
+	KeyFile string
+
+	// ForwardAgent enables forwarding of the authentication agent
+	ForwardAgent bool
+
+	// Options are passed to ssh as -o options, e.g. StrictHostKeyChecking=accept-new
+	Options []string
+}
+
+// quote quotes s for a POSIX shell
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// remotePath returns the path of the url on the server, quoted for the shell
+func remotePath(u *url.URL) string {
+	if strings.HasPrefix(u.Path, "/~/") {
+		return quote(strings.TrimPrefix(u.Path, "/~/"))
+	}
+	return quote(u.Path)
+}
+
+// command creates the ssh command that runs remote on the server of u
+func (t *Transport) command(ctx context.Context, u *url.URL, remote string) *exec.Cmd {
+	args := []string{"-o", "BatchMode=yes"}
+	if port := u.Port(); port != "" {
+		args = append(args, "-p", port)
+	}
+	if t.KeyFile != "" {
+		args = append(args, "-i", t.KeyFile)
+	}
+	if t.ForwardAgent {
+		args = append(args, "-A")
+	}
+	for _, option := range t.Options {
+		args = append(args, "-o", option)
+	}
+
+	dest := u.Hostname()
+	if u.User != nil && u.User.Username() != "" {
+		dest = u.User.Username() + "@" + dest
+	}
+	args = append(args, "--", dest, remote)
+
+	name := t.Command
+	if name == "" {
+		name = "ssh"
+	}
+	return exec.CommandContext(ctx, name, args...)
+}
+
+// run runs remote and returns its output. A failure of the remote command
+// is returned as a *remoteError, a failure of ssh as another error.
+func (t *Transport) run(req *http.Request, remote string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := t.command(req.Context(), req.URL, remote)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+
+	err := checkExit(cmd.Run(), stderr.String())
+	return stdout.Bytes(), err
+}
+
+// remoteError is the failure of a command on the server
+type remoteError struct {
+	status int
+	stderr string
+}
+
+func (e *remoteError) Error() string {
+	return fmt.Sprintf("remote command failed with status %d: %s", e.status, strings.TrimSpace(e.stderr))
+}
+
+func checkExit(err error, stderr string) error {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return err
+	}
+	if exitErr.ExitCode() == sshConnectionError {
+		// ssh prefixes its own messages with "ssh:"
+		if msg := strings.TrimSpace(stderr); msg != "" {
+			return errors.New(msg)
+		}
+		return errors.New("ssh: connection failed")
+	}
+	return &remoteError{status: exitErr.ExitCode(), stderr: stderr}
+}
+
+func newResponse(req *http.Request, status int) *http.Response {
+	return &http.Response{
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode: status,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       http.NoBody,
+		Request:    req,
+	}
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return nil, fmt.Errorf("sshfile: method %s is not supported", req.Method)
+	}
+
+	path := remotePath(req.URL)
+
+	out, err := t.run(req, "wc -c < "+path)
+	var remoteErr *remoteError
+	if errors.As(err, &remoteErr) {
+		// the file does not exist or cannot be read
+		response := newResponse(req, http.StatusNotFound)
+		response.Body = ioutil.NopCloser(strings.NewReader(remoteErr.stderr))
+		response.ContentLength = int64(len(remoteErr.stderr))
+		return response, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(string(out)), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("sshfile: unexpected size %q", out)
+	}
+
+	response := newResponse(req, http.StatusOK)
+	response.Header.Set("Accept-Ranges", "bytes")
+	response.Header.Set("Content-Length", strconv.FormatInt(size, 10))
+	response.ContentLength = size
+
+	if req.Method == http.MethodHead {
+		return response, nil
+	}
+
+	remote := "cat -- " + path
+	if start, end, ok := parseRange(req.Header.Get("Range")); ok {
+		if start >= size {
+			response = newResponse(req, http.StatusRequestedRangeNotSatisfiable)
+			response.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+			return response, nil
+		}
+		if end < 0 || end >= size {
+			end = size - 1
+		}
+
+		n := end - start + 1
+		remote = fmt.Sprintf("tail -c +%d -- %s", start+1, path)
+		if end < size-1 {
+			remote += fmt.Sprintf(" | head -c %d", n)
+		}
+
+		response.Status, response.StatusCode = "206 Partial Content", http.StatusPartialContent
+		response.ContentLength = n
+		response.Header.Set("Content-Length", strconv.FormatInt(n, 10))
+		response.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+	}
+
+	cmd := t.command(req.Context(), req.URL, remote)
+	body := &commandBody{cmd: cmd}
+	cmd.Stderr = &body.stderr
+	if body.stdout, err = cmd.StdoutPipe(); err != nil {
+		return nil, err
+	}
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	response.Body = body
+
+	return response, nil
+}
+
+// Put writes the contents of r to the file of u on the server, creating the
+// directories that are missing
+func (t *Transport) Put(ctx context.Context, u *url.URL, r io.Reader) error {
+	dir := path.Dir(u.Path)
+	if strings.HasPrefix(u.Path, "/~/") {
+		dir = path.Dir(strings.TrimPrefix(u.Path, "/~/"))
+	}
+
+	var stderr bytes.Buffer
+	cmd := t.command(ctx, u, "mkdir -p -- "+quote(dir)+" && cat > "+remotePath(u))
+	cmd.Stdin, cmd.Stderr = r, &stderr
+
+	return checkExit(cmd.Run(), stderr.String())
+}
+
+// parseRange parses the single ranges "bytes=start-" and "bytes=start-end",
+// end is -1 if it is open
+func parseRange(s string) (start, end int64, ok bool) {
+	if !strings.HasPrefix(s, "bytes=") || strings.Contains(s, ",") {
+		return 0, 0, false
+	}
+	bounds := strings.SplitN(strings.TrimPrefix(s, "bytes="), "-", 2)
+	if len(bounds) != 2 {
+		return 0, 0, false
+	}
+
+	start, err := strconv.ParseInt(bounds[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	end = -1
+	if bounds[1] != "" {
+		if end, err = strconv.ParseInt(bounds[1], 10, 64); err != nil || end < start {
+			return 0, 0, false
+		}
+	}
+	return start, end, true
+}
+
+// commandBody is the output of a running command. Its exit status is checked
+// at the end of the output, so that failed transfers are not mistaken for
+// complete ones.
+type commandBody struct {
+	cmd    *exec.Cmd
+	stdout io.ReadCloser
+	stderr bytes.Buffer
+
+	once    sync.Once
+	waitErr error
+}
+
+func (b *commandBody) wait() error {
+	b.once.Do(func() {
+		b.waitErr = checkExit(b.cmd.Wait(), b.stderr.String())
+	})
+	return b.waitErr
+}
+
+func (b *commandBody) Read(p []byte) (int, error) {
+	n, err := b.stdout.Read(p)
+	if err == io.EOF {
+		if waitErr := b.wait(); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// Close stops the command if it is still running
+func (b *commandBody) Close() error {
+	if b.cmd.ProcessState == nil {
+		_ = b.cmd.Process.Kill()
+	}
+	_ = b.wait()
+	return nil
+}
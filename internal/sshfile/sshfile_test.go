@@ -0,0 +1,141 @@
+package sshfile
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeSSH is an ssh replacement that runs the remote command locally
+const fakeSSH = `#!/bin/sh
+while [ "$1" != "--" ]; do
+	if [ "$1" = "-p" ] && [ "$2" = "1" ]; then
+		echo "connection refused" >&2
+		exit 255
+	fi
+	shift
+done
+shift 2
+exec sh -c "$1"
+`
+
+func TestTransport(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell available")
+	}
+
+	dir, err := ioutil.TempDir("", "sshfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ssh := filepath.Join(dir, "ssh")
+	if err = ioutil.WriteFile(ssh, []byte(fakeSSH), 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "it's a file")
+	if err = ioutil.WriteFile(file, []byte("0123456789"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: &Transport{Command: ssh, KeyFile: "key", ForwardAgent: true}}
+	fileURL := "sftp://user@host" + strings.Replace(file, " ", "%20", -1)
+
+	testCases := []struct {
+		method         string
+		url            string
+		rangeHeader    string
+		expectedStatus int
+		expectedBody   string
+		expectedRange  string
+	}{
+		{"GET", fileURL, "", 200, "0123456789", ""},
+		{"GET", fileURL, "bytes=4-", 206, "456789", "bytes 4-9/10"},
+		{"GET", fileURL, "bytes=2-5", 206, "2345", "bytes 2-5/10"},
+		{"GET", fileURL, "bytes=10-", 416, "", "bytes */10"},
+		{"HEAD", fileURL, "", 200, "", ""},
+		{"GET", "scp://host" + filepath.Join(dir, "missing"), "", 404, "", ""},
+	}
+
+	for _, testCase := range testCases {
+		req, _ := http.NewRequest(testCase.method, testCase.url, nil)
+		if testCase.rangeHeader != "" {
+			req.Header.Set("Range", testCase.rangeHeader)
+		}
+
+		response, err := client.Do(req)
+		if err != nil {
+			t.Errorf("%s %s: %v", testCase.method, testCase.url, err)
+			continue
+		}
+		body, err := ioutil.ReadAll(response.Body)
+		response.Body.Close()
+		if err != nil {
+			t.Errorf("%s %s: %v", testCase.method, testCase.url, err)
+		}
+
+		if testCase.expectedStatus == 404 {
+			body = nil
+		}
+		if response.StatusCode != testCase.expectedStatus || string(body) != testCase.expectedBody ||
+			response.Header.Get("Content-Range") != testCase.expectedRange {
+			t.Errorf("%s %s range=%s expected %d %q %q received %d %q %q", testCase.method, testCase.url, testCase.rangeHeader,
+				testCase.expectedStatus, testCase.expectedBody, testCase.expectedRange,
+				response.StatusCode, body, response.Header.Get("Content-Range"))
+		}
+	}
+
+	// connection failures are errors rather than responses
+	if _, err = client.Get("sftp://host:1/file"); err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Errorf("expected a connection error received %v", err)
+	}
+}
+
+func TestPut(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("no shell available")
+	}
+
+	dir, err := ioutil.TempDir("", "sshfile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ssh := filepath.Join(dir, "ssh")
+	if err = ioutil.WriteFile(ssh, []byte(fakeSSH), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tr := &Transport{Command: ssh}
+	file := filepath.Join(dir, "new dir", "it's a file")
+	u, err := url.Parse("sftp://host" + strings.Replace(file, " ", "%20", -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = tr.Put(context.Background(), u, strings.NewReader("uploaded")); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadFile(file); err != nil || string(b) != "uploaded" {
+		t.Errorf("expected %q received %q %v", "uploaded", b, err)
+	}
+
+	u.Host = "host:1"
+	if err = tr.Put(context.Background(), u, strings.NewReader("")); err == nil {
+		t.Error("expected a connection error")
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if q := quote("it's"); q != `'it'\''s'` {
+		t.Errorf("unexpected quoting %s", q)
+	}
+}
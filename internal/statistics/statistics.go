@@ -2,6 +2,7 @@ package statistics
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -11,15 +12,29 @@ import (
 // Statistics - statistics about the downloads
 type Statistics struct {
 	lock                    *sync.RWMutex
-	TotalDownloads          int       `json:"totalDownloads"`
-	TotalDownloaded         int       `json:"totalDownloaded"`
-	TotalFailed             int       `json:"totalFailed"`
-	TotalDownloadedBytes    uint64    `json:"totalDownloadedBytes"`
-	AverageSpeedFilesPerSec float64   `json:"averageSpeedFilesPerSec"`
-	SpeedBytesPerSec        float64   `json:"speedBytesPerSec"`
-	StartTime               time.Time `json:"startTime"`
-	FilesRemaining          int       `json:"filesRemaining"`
-	AverageSpeedBytesPerSec float64   `json:"averageSpeedBytesPerSec"`
+	TotalDownloads          int              `json:"totalDownloads"`
+	TotalDownloaded         int              `json:"totalDownloaded"`
+	TotalFailed             int              `json:"totalFailed"`
+	TotalCapped             int              `json:"totalCapped,omitempty"`
+	TotalDownloadedBytes    uint64           `json:"totalDownloadedBytes"`
+	AverageSpeedFilesPerSec float64          `json:"averageSpeedFilesPerSec"`
+	SpeedBytesPerSec        float64          `json:"speedBytesPerSec"`
+	StartTime               time.Time        `json:"startTime"`
+	FilesRemaining          int              `json:"filesRemaining"`
+	AverageSpeedBytesPerSec float64          `json:"averageSpeedBytesPerSec"`
+	Routes                  map[string]Route `json:"routes,omitempty"`
+
+	costPerGB float64 // estimated egress cost in $ per GB, 0 if not estimated
+}
+
+// bytesPerGB is the unit of SetCostPerGB
+const bytesPerGB = 1000000000.0
+
+// Route - statistics about the downloads over one network route, e.g. tor
+type Route struct {
+	Downloaded      int    `json:"downloaded"`
+	Failed          int    `json:"failed"`
+	DownloadedBytes uint64 `json:"downloadedBytes"`
 }
 
 // New returns a new Statistics instance with start time the current time
@@ -27,6 +42,39 @@ func New() Statistics {
 	return Statistics{StartTime: time.Now(), lock: &sync.RWMutex{}}
 }
 
+// Resume returns statistics loaded from saved progress, ready to continue
+// counting: the speeds start from zero and the start time is the current time
+func Resume(loaded Statistics) Statistics {
+	stats := loaded
+	stats.lock = &sync.RWMutex{}
+	stats.AverageSpeedBytesPerSec = 0
+	stats.AverageSpeedFilesPerSec = 0
+	stats.SpeedBytesPerSec = 0
+	stats.StartTime = time.Now()
+	return stats
+}
+
+// SetCostPerGB sets the egress cost in $ per GB from which Cost estimates
+// the cost of the downloaded bytes, 0 leaves the cost out of the output
+func (stats *Statistics) SetCostPerGB(rate float64) {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+
+	stats.costPerGB = rate
+}
+
+// Cost returns the estimated egress cost of the downloaded bytes in $
+func (stats *Statistics) Cost() float64 {
+	stats.lock.RLock()
+	defer stats.lock.RUnlock()
+
+	return stats.cost()
+}
+
+func (stats *Statistics) cost() float64 {
+	return float64(stats.TotalDownloadedBytes) / bytesPerGB * stats.costPerGB
+}
+
 // Update updates the statistics from a new log entry
 func (stats *Statistics) Update(log logging.LogEntry) {
 	stats.lock.Lock()
@@ -34,23 +82,70 @@ func (stats *Statistics) Update(log logging.LogEntry) {
 
 	durationSoFar := (time.Now()).Sub(stats.StartTime)
 
-	if log.Result {
+	switch {
+	case log.Result:
 		stats.TotalDownloaded++
-	} else {
+	case log.Capped:
+		stats.TotalCapped++
+	default:
 		stats.TotalFailed++
 	}
 
-	stats.TotalDownloadedBytes += log.NBytes
-	stats.SpeedBytesPerSec = float64(log.NBytes) / log.Duration.Seconds()
+	// entries that were skipped without a transfer, like known dead urls,
+	// are counted without changing the speed
+	if log.Duration > 0 {
+		stats.SpeedBytesPerSec = float64(log.NBytes) / log.Duration.Seconds()
+	}
 	stats.AverageSpeedFilesPerSec = float64(stats.TotalDownloaded) / durationSoFar.Seconds()
 	stats.AverageSpeedBytesPerSec = float64(stats.TotalDownloadedBytes) / (durationSoFar.Seconds())
-	stats.FilesRemaining = stats.TotalDownloads - (stats.TotalDownloaded + stats.TotalFailed)
+	stats.FilesRemaining = stats.TotalDownloads - (stats.TotalDownloaded + stats.TotalFailed + stats.TotalCapped)
+
+}
+
+// UpdateRoute counts a new log entry in the statistics of route
+func (stats *Statistics) UpdateRoute(route string, log logging.LogEntry) {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+
+	if stats.Routes == nil {
+		stats.Routes = map[string]Route{}
+	}
+	r := stats.Routes[route]
+	if log.Result {
+		r.Downloaded++
+	} else {
+		r.Failed++
+	}
+	r.DownloadedBytes += log.NBytes
+	stats.Routes[route] = r
+}
+
+// AddDownloads adds n downloads to TotalDownloads, for downloads that are
+// queued while the others are running
+func (stats *Statistics) AddDownloads(n int) {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
 
+	stats.TotalDownloads += n
+	stats.FilesRemaining += n
+}
+
+// AddBytes adds n bytes to TotalDownloadedBytes as they are received, so
+// that the progress also moves during long downloads and for responses of
+// unknown length
+func (stats *Statistics) AddBytes(n uint64) {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+
+	stats.TotalDownloadedBytes += n
 }
 
 // PrintHeader prints the header of the statistics
 func (stats *Statistics) PrintHeader() {
-	fmt.Printf("\n%-9s | %-10s | %-10s | %-11s | %-7s | %-10s | %-11s |\n",
+	stats.lock.RLock()
+	defer stats.lock.RUnlock()
+
+	fmt.Printf("\n%-9s | %-10s | %-10s | %-11s | %-7s | %-10s | %-11s |",
 		"Downloads",
 		"Failures",
 		"Total mB",
@@ -59,6 +154,10 @@ func (stats *Statistics) PrintHeader() {
 		"Remaining",
 		"Avg mB/Sec",
 	)
+	if stats.costPerGB > 0 {
+		fmt.Printf(" %-9s |", "Est. cost")
+	}
+	fmt.Println()
 }
 
 // Print prints a row with the statistics
@@ -74,6 +173,30 @@ func (stats *Statistics) Print() {
 		stats.FilesRemaining,
 		stats.AverageSpeedBytesPerSec/1000000,
 	)
+	if stats.costPerGB > 0 {
+		fmt.Printf(" $%-8.2f |", stats.cost())
+	}
+}
+
+// PrintLine prints the statistics as a single line that ends with a newline,
+// which keeps the output readable when it is redirected to a file
+func (stats *Statistics) PrintLine() {
+	stats.lock.RLock()
+	defer stats.lock.RUnlock()
+
+	fmt.Printf("%s downloaded=%d failed=%d remaining=%d mB=%.2f files/sec=%.2f avg mB/sec=%.2f",
+		time.Now().Format("15:04:05"),
+		stats.TotalDownloaded,
+		stats.TotalFailed,
+		stats.FilesRemaining,
+		float64(stats.TotalDownloadedBytes)/1000000.0,
+		stats.AverageSpeedFilesPerSec,
+		stats.AverageSpeedBytesPerSec/1000000,
+	)
+	if stats.costPerGB > 0 {
+		fmt.Printf(" cost=$%.2f", stats.cost())
+	}
+	fmt.Println()
 }
 
 // PrintEnd is called on program exit and prints some useful final stats
@@ -84,5 +207,36 @@ func (stats *Statistics) PrintEnd() {
 	durationSoFar := (time.Now()).Sub(stats.StartTime)
 
 	fmt.Println("\n\nTotal time:", durationSoFar)
+	if stats.costPerGB > 0 {
+		fmt.Printf("Estimated egress cost: $%.2f (%.2f GB at $%g/GB)\n",
+			stats.cost(), float64(stats.TotalDownloadedBytes)/bytesPerGB, stats.costPerGB)
+	}
+
+	// the routes are only worth listing if more than one was taken
+	if len(stats.Routes) > 1 {
+		names := make([]string, 0, len(stats.Routes))
+		for name := range stats.Routes {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			r := stats.Routes[name]
+			fmt.Printf("%s: downloaded=%d failed=%d mB=%.2f\n", name, r.Downloaded, r.Failed, float64(r.DownloadedBytes)/1000000.0)
+		}
+	}
 	fmt.Println("Thank you for using massivedl")
 }
+
+// Snapshot returns a copy of the statistics that is safe to read while
+// downloads are running
+func (stats *Statistics) Snapshot() Statistics {
+	stats.lock.RLock()
+	defer stats.lock.RUnlock()
+
+	snapshot := *stats
+	snapshot.Routes = make(map[string]Route, len(stats.Routes))
+	for name, r := range stats.Routes {
+		snapshot.Routes[name] = r
+	}
+	return snapshot
+}
@@ -0,0 +1,41 @@
+package statistics
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/logging"
+)
+
+func TestUpdate(t *testing.T) {
+	stats := New()
+	stats.TotalDownloads = 4
+
+	stats.Update(logging.LogEntry{Result: true, NBytes: 1000, Duration: 2 * time.Second})
+	if stats.SpeedBytesPerSec != 500 {
+		t.Errorf("expected a speed of 500 received %v", stats.SpeedBytesPerSec)
+	}
+
+	// a result without a transfer, like a url of the negative cache
+	stats.Update(logging.LogEntry{})
+	if stats.SpeedBytesPerSec != 500 {
+		t.Errorf("expected the speed to stay 500 received %v", stats.SpeedBytesPerSec)
+	}
+
+	// a url of a host that reached -max-files-per-host
+	stats.Update(logging.LogEntry{Capped: true})
+	if stats.SpeedBytesPerSec != 500 {
+		t.Errorf("expected the speed to stay 500 received %v", stats.SpeedBytesPerSec)
+	}
+	if stats.TotalDownloaded != 1 || stats.TotalFailed != 1 || stats.TotalCapped != 1 || stats.FilesRemaining != 1 {
+		t.Errorf("expected 1 downloaded, 1 failed, 1 capped and 1 remaining received %d, %d, %d and %d",
+			stats.TotalDownloaded, stats.TotalFailed, stats.TotalCapped, stats.FilesRemaining)
+	}
+
+	// the statistics are saved with the progress
+	snapshot := stats.Snapshot()
+	if _, err := json.Marshal(snapshot); err != nil {
+		t.Errorf("expected the statistics to be saved received %v", err)
+	}
+}
@@ -0,0 +1,9 @@
+// Package term switches terminals into a mode in which single key presses
+// can be read as they happen
+package term
+
+import "errors"
+
+// ErrUnsupported is returned by Cbreak on operating systems where the mode
+// of terminals can't be changed
+var ErrUnsupported = errors.New("terminal modes are not supported on this system")
@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package term
+
+import "os"
+
+// Cbreak returns ErrUnsupported
+func Cbreak(f *os.File) (restore func() error, err error) {
+	return nil, ErrUnsupported
+}
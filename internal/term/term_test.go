@@ -0,0 +1,20 @@
+package term
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestCbreakNoTerminal(t *testing.T) {
+	f, err := ioutil.TempFile("", "term")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err = Cbreak(f); err == nil {
+		t.Error("expected an error for a file that isn't a terminal")
+	}
+}
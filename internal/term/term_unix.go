@@ -0,0 +1,40 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package term
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Cbreak turns off the line buffering and the echo of the terminal f, so
+// that every key press can be read from it as it happens. Ctrl+C and the
+// other keys that send signals keep working. The returned function restores
+// the previous mode. An error is returned if f isn't a terminal.
+func Cbreak(f *os.File) (restore func() error, err error) {
+	var old syscall.Termios
+	if err = ioctl(f.Fd(), ioctlReadTermios, &old); err != nil {
+		return nil, err
+	}
+
+	mode := old
+	mode.Lflag &^= syscall.ICANON | syscall.ECHO
+	mode.Cc[syscall.VMIN] = 1
+	mode.Cc[syscall.VTIME] = 0
+	if err = ioctl(f.Fd(), ioctlWriteTermios, &mode); err != nil {
+		return nil, err
+	}
+
+	return func() error {
+		return ioctl(f.Fd(), ioctlWriteTermios, &old)
+	}, nil
+}
+
+func ioctl(fd, request uintptr, termios *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(unsafe.Pointer(termios))); errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+// Package torctl speaks the control protocol of Tor, as far as needed to
+// authenticate and to send signals like NEWNYM, which makes Tor use new
+// circuits for all new connections.
+package torctl
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+// dialTimeout limits the connection to the control port
+const dialTimeout = 10 * time.Second
+
+// Conn is an authenticated connection to the control port
+type Conn struct {
+	text *textproto.Conn
+}
+
+// Dial connects to the control port at addr and authenticates with
+// password, or with the authentication cookie or no authentication at all if
+// password is empty and Tor offers them
+func Dial(addr, password string) (*Conn, error) {
+	netConn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Conn{text: textproto.NewConn(netConn)}
+	if err = c.authenticate(password); err != nil {
+		c.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// Close closes the connection
+func (c *Conn) Close() error {
+	return c.text.Close()
+}
+
+// Signal sends a signal like NEWNYM or RELOAD to Tor
+func (c *Conn) Signal(name string) error {
+	_, err := c.command("SIGNAL " + name)
+	return err
+}
+
+// command sends a command and returns the lines of a successful reply
+func (c *Conn) command(cmd string) ([]string, error) {
+	if err := c.text.PrintfLine("%s", cmd); err != nil {
+		return nil, err
+	}
+
+	_, message, err := c.text.ReadResponse(250)
+	if err != nil {
+		if protoErr, ok := err.(*textproto.Error); ok {
+			return nil, fmt.Errorf("torctl: %s: %d %s", strings.Fields(cmd)[0], protoErr.Code, protoErr.Msg)
+		}
+		return nil, err
+	}
+	return strings.Split(message, "\n"), nil
+}
+
+func (c *Conn) authenticate(password string) error {
+	if password != "" {
+		_, err := c.command("AUTHENTICATE " + quote(password))
+		return err
+	}
+
+	lines, err := c.command("PROTOCOLINFO 1")
+	if err != nil {
+		return err
+	}
+	methods, cookieFile := parseAuthLine(lines)
+
+	switch {
+	case methods["NULL"]:
+		_, err = c.command("AUTHENTICATE")
+		return err
+
+	case methods["COOKIE"] && cookieFile != "":
+		cookie, err := ioutil.ReadFile(cookieFile)
+		if err != nil {
+			return fmt.Errorf("torctl: reading the authentication cookie: %v", err)
+		}
+		_, err = c.command("AUTHENTICATE " + hex.EncodeToString(cookie))
+		return err
+
+	case methods["HASHEDPASSWORD"]:
+		return fmt.Errorf("torctl: the control port requires a password")
+	}
+
+	return fmt.Errorf("torctl: no supported authentication method")
+}
+
+// parseAuthLine reads the methods and the cookie file from the AUTH line of
+// a PROTOCOLINFO reply, e.g.
+//
+//	AUTH METHODS=COOKIE,SAFECOOKIE COOKIEFILE="/run/tor/control.authcookie"
+func parseAuthLine(lines []string) (map[string]bool, string) {
+	methods := map[string]bool{}
+	cookieFile := ""
+
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "AUTH ") {
+			continue
+		}
+		for _, field := range strings.Fields(line)[1:] {
+			if strings.HasPrefix(field, "METHODS=") {
+				for _, method := range strings.Split(strings.TrimPrefix(field, "METHODS="), ",") {
+					methods[method] = true
+				}
+			}
+		}
+
+		// the path is quoted and may contain spaces
+		if i := strings.Index(line, `COOKIEFILE="`); i >= 0 {
+			rest := line[i+len(`COOKIEFILE=`):]
+			end := 1
+			for end < len(rest) && rest[end] != '"' {
+				if rest[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end < len(rest) {
+				cookieFile = unquote(rest[:end+1])
+			}
+		}
+	}
+
+	return methods, cookieFile
+}
+
+// quote returns s as a quoted string of the control protocol
+func quote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// unquote reverses quote, s is returned unchanged if it isn't quoted
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+	return strings.NewReplacer(`\\`, `\`, `\"`, `"`).Replace(s[1 : len(s)-1])
+}
@@ -0,0 +1,126 @@
+package torctl
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeTor answers the commands of one connection like the control port of
+// Tor, with the given PROTOCOLINFO methods, and records the commands
+func fakeTor(t *testing.T, methods, cookieFile, secret string) (string, chan []string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	commands := make(chan []string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var received []string
+		defer func() { commands <- received }()
+
+		authenticated := false
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.TrimRight(line, "\r\n")
+			received = append(received, cmd)
+
+			switch {
+			case cmd == "PROTOCOLINFO 1":
+				conn.Write([]byte("250-PROTOCOLINFO 1\r\n250-AUTH METHODS=" + methods + ` COOKIEFILE="` + cookieFile + "\"\r\n250-VERSION Tor=\"0.4.8.9\"\r\n250 OK\r\n"))
+			case strings.HasPrefix(cmd, "AUTHENTICATE"):
+				if strings.TrimSpace(strings.TrimPrefix(cmd, "AUTHENTICATE")) != secret {
+					conn.Write([]byte("515 Authentication failed: Password did not match\r\n"))
+					return
+				}
+				authenticated = true
+				conn.Write([]byte("250 OK\r\n"))
+			case !authenticated:
+				conn.Write([]byte("514 Authentication required.\r\n"))
+				return
+			case cmd == "SIGNAL NEWNYM":
+				conn.Write([]byte("250 OK\r\n"))
+			default:
+				conn.Write([]byte("552 Unrecognized signal\r\n"))
+			}
+		}
+	}()
+
+	return l.Addr().String(), commands
+}
+
+func TestDial(t *testing.T) {
+	dir, err := ioutil.TempDir("", "torctl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cookieFile := filepath.Join(dir, "control auth cookie")
+	if err = ioutil.WriteFile(cookieFile, []byte{0xca, 0xfe}, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		methods  string
+		password string
+		secret   string
+		expected []string
+	}{
+		{"NULL", "", "", []string{"PROTOCOLINFO 1", "AUTHENTICATE", "SIGNAL NEWNYM"}},
+		{"COOKIE,SAFECOOKIE", "", "cafe", []string{"PROTOCOLINFO 1", "AUTHENTICATE cafe", "SIGNAL NEWNYM"}},
+		{"HASHEDPASSWORD", `pa"ss`, `"pa\"ss"`, []string{`AUTHENTICATE "pa\"ss"`, "SIGNAL NEWNYM"}},
+	}
+
+	for _, testCase := range testCases {
+		addr, commands := fakeTor(t, testCase.methods, cookieFile, testCase.secret)
+		c, err := Dial(addr, testCase.password)
+		if err != nil {
+			t.Errorf("%s: %v", testCase.methods, err)
+			continue
+		}
+		if err = c.Signal("NEWNYM"); err != nil {
+			t.Errorf("%s: %v", testCase.methods, err)
+		}
+		c.Close()
+
+		if received := <-commands; !reflect.DeepEqual(received, testCase.expected) {
+			t.Errorf("%s: expected %q received %q", testCase.methods, testCase.expected, received)
+		}
+	}
+}
+
+func TestDialErrors(t *testing.T) {
+	testCases := []struct {
+		methods  string
+		password string
+		expected string
+	}{
+		{"HASHEDPASSWORD", "", "requires a password"},
+		{"HASHEDPASSWORD", "wrong", "515 Authentication failed"},
+		{"SAFECOOKIE", "", "no supported authentication method"},
+	}
+
+	for _, testCase := range testCases {
+		addr, _ := fakeTor(t, testCase.methods, "", `"right"`)
+		_, err := Dial(addr, testCase.password)
+		if err == nil || !strings.Contains(err.Error(), testCase.expected) {
+			t.Errorf("%s: expected an error containing %q received %v", testCase.methods, testCase.expected, err)
+		}
+	}
+}
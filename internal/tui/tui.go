@@ -0,0 +1,127 @@
+// Package tui draws a progress view that is redrawn in place: one line per
+// worker with a progress bar, followed by summary lines
+package tui
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// barWidth is the number of characters between the brackets of a bar
+const barWidth = 20
+
+// Worker is the state of a worker in a frame
+type Worker struct {
+	ID      int
+	URL     string        // "" for an idle worker
+	Done    int64         // bytes of the current file that were received
+	Size    int64         // expected size of the file, < 0 if unknown
+	Elapsed time.Duration // since the worker started the file
+}
+
+// Speed returns the average speed of the current file in bytes per second
+func (w Worker) Speed() float64 {
+	if w.Elapsed <= 0 {
+		return 0
+	}
+	return float64(w.Done) / w.Elapsed.Seconds()
+}
+
+// Line formats w within width characters, e.g.
+//
+//	#3  [#######.............]  35%  1.20 mB/s   4s https://example.com/file
+func (w Worker) Line(width int) string {
+	if w.URL == "" {
+		return fmt.Sprintf("#%-3d idle", w.ID)
+	}
+
+	percent := "   ?"
+	if w.Size > 0 {
+		percent = fmt.Sprintf("%3d%%", int(100*min64(w.Done, w.Size)/w.Size))
+	}
+	line := fmt.Sprintf("#%-3d %s %s %6.2f mB/s %4s %s",
+		w.ID, Bar(w.Done, w.Size), percent, w.Speed()/1000000, shortDuration(w.Elapsed), w.URL)
+	return truncate(line, width)
+}
+
+// Bar draws the share of done in size, e.g. [#####...............]. Bars of
+// unknown sizes show a marker that moves with the received bytes.
+func Bar(done, size int64) string {
+	bar := []byte(strings.Repeat(".", barWidth))
+	if size > 0 {
+		filled := int(int64(barWidth) * min64(done, size) / size)
+		for i := 0; i < filled; i++ {
+			bar[i] = '#'
+		}
+	} else {
+		// the marker advances by one position per 100kB
+		pos := int(done/100000) % (barWidth - 2)
+		copy(bar[pos:], "<=>")
+	}
+	return "[" + string(bar) + "]"
+}
+
+// Screen redraws frames in place on a terminal
+type Screen struct {
+	w     io.Writer
+	lines int // number of lines of the last frame
+}
+
+// NewScreen returns a Screen that draws on w
+func NewScreen(w io.Writer) *Screen {
+	return &Screen{w: w}
+}
+
+// Draw replaces the previous frame with lines
+func (s *Screen) Draw(lines []string) error {
+	var b strings.Builder
+	if s.lines > 0 {
+		// back to the first line of the previous frame
+		fmt.Fprintf(&b, "\x1b[%dA", s.lines)
+	}
+	for _, line := range lines {
+		b.WriteString("\r\x1b[2K")
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	// a shorter frame leaves no lines of the previous one behind
+	for i := len(lines); i < s.lines; i++ {
+		b.WriteString("\r\x1b[2K\n")
+	}
+	if extra := s.lines - len(lines); extra > 0 {
+		fmt.Fprintf(&b, "\x1b[%dA", extra)
+	}
+
+	s.lines = len(lines)
+	_, err := io.WriteString(s.w, b.String())
+	return err
+}
+
+// shortDuration formats d in its largest unit, e.g. 4s, 12m or 3h
+func shortDuration(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	}
+	return fmt.Sprintf("%dh", int(d.Hours()))
+}
+
+// truncate shortens s to width characters, ending it with ~ if it was cut
+func truncate(s string, width int) string {
+	r := []rune(s)
+	if width <= 1 || len(r) <= width {
+		return s
+	}
+	return string(r[:width-1]) + "~"
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,63 @@
+package tui
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestBar(t *testing.T) {
+	testCases := []struct {
+		done, size int64
+		expected   string
+	}{
+		{0, 100, "[....................]"},
+		{50, 100, "[##########..........]"},
+		{100, 100, "[####################]"},
+		{150, 100, "[####################]"},
+		{0, -1, "[<=>.................]"},
+		{300000, -1, "[...<=>..............]"},
+	}
+
+	for _, testCase := range testCases {
+		if received := Bar(testCase.done, testCase.size); received != testCase.expected {
+			t.Errorf("%d/%d: expected %s received %s", testCase.done, testCase.size, testCase.expected, received)
+		}
+	}
+}
+
+func TestWorkerLine(t *testing.T) {
+	w := Worker{ID: 3, URL: "https://example.com/file", Done: 2000000, Size: 4000000, Elapsed: 2 * time.Second}
+	expected := "#3   [##########..........]  50%   1.00 mB/s   2s https://example.com/file"
+	if received := w.Line(200); received != expected {
+		t.Errorf("expected %q received %q", expected, received)
+	}
+
+	if received := w.Line(20); received != expected[:19]+"~" {
+		t.Errorf("expected the line to be cut, received %q", received)
+	}
+
+	if received := (Worker{ID: 1}).Line(80); received != "#1   idle" {
+		t.Errorf("expected an idle worker, received %q", received)
+	}
+}
+
+func TestScreen(t *testing.T) {
+	var buf bytes.Buffer
+	s := NewScreen(&buf)
+
+	if err := s.Draw([]string{"a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "\r\x1b[2Ka\n\r\x1b[2Kb\n"; buf.String() != expected {
+		t.Errorf("expected %q received %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := s.Draw([]string{"c"}); err != nil {
+		t.Fatal(err)
+	}
+	if expected := "\x1b[2A\r\x1b[2Kc\n\r\x1b[2K\n\x1b[1A"; buf.String() != expected {
+		t.Errorf("expected %q received %q", expected, buf.String())
+	}
+}
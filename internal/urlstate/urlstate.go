@@ -0,0 +1,200 @@
+// Package urlstate stores the state of every url of a run in a file, so that
+// the run can be continued whatever order its downloads finished in
+package urlstate
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Status is where the download of a url stands
+type Status string
+
+// the statuses of a url
+const (
+	Pending Status = "pending" // queued, not started yet
+	Active  Status = "active"  // being downloaded
+	Done    Status = "done"    // downloaded
+	Failed  Status = "failed"  // failed after all retries
+)
+
+// Record is the state of a url
+type Record struct {
+	URL      string `json:"url"`
+	Status   Status `json:"status"`
+	Bytes    int64  `json:"bytes,omitempty"`    // bytes received
+	Path     string `json:"path,omitempty"`     // where a done url was saved
+	Checksum string `json:"checksum,omitempty"` // verified checksum, e.g. sha256:<hex>
+	Attempts int    `json:"attempts,omitempty"` // requests sent, over all runs
+	Error    string `json:"error,omitempty"`    // last error of a failed url
+}
+
+// Store holds the records of the urls of a run in a file. Every change is
+// appended to the file as a JSON line, the last line of a url wins. The file
+// is compacted when it is opened, and the urls that were active when the
+// process stopped are pending again. A line that a crash cut off is skipped,
+// so its url keeps its previous record, and the compacted file replaces the
+// old one only once it is complete. A Store is safe for concurrent use.
+type Store struct {
+	lock    sync.Mutex
+	records map[string]Record
+	file    *os.File
+	w       *bufio.Writer
+}
+
+// Open loads the store in path, which is created if it doesn't exist
+func Open(path string) (*Store, error) {
+	s := &Store{records: make(map[string]Record)}
+
+	if err := s.load(path, true); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// rewrite the file with the current records
+	tmpPath := path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return nil, err
+	}
+	s.w = bufio.NewWriter(f)
+	for _, url := range s.sortedURLs("") {
+		if err = s.write(s.records[url]); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if err = s.w.Flush(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	// the new file must be on the disk before it replaces the old one, or a
+	// power loss could leave an empty file behind
+	if err = f.Sync(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err = f.Close(); err != nil {
+		return nil, err
+	}
+	if err = os.Rename(tmpPath, path); err != nil {
+		return nil, err
+	}
+
+	if s.file, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+		return nil, err
+	}
+	s.w = bufio.NewWriter(s.file)
+
+	return s, nil
+}
+
+// Read returns the records of the store in path by url without changing the
+// file, which may be in use by a running process
+func Read(path string) (map[string]Record, error) {
+	s := &Store{records: make(map[string]Record)}
+	if err := s.load(path, false); err != nil {
+		return nil, err
+	}
+	return s.records, nil
+}
+
+// load reads the records of path, with reset the active urls are pending
+func (s *Store) load(path string, reset bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		// a line cut off by a crash is skipped
+		var record Record
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil || record.URL == "" {
+			continue
+		}
+		if reset && record.Status == Active {
+			record.Status = Pending
+		}
+		s.records[record.URL] = record
+	}
+
+	return scanner.Err()
+}
+
+func (s *Store) write(record Record) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	if _, err = s.w.Write(b); err != nil {
+		return err
+	}
+	return s.w.WriteByte('\n')
+}
+
+// Put stores records, replacing the earlier records of their urls
+func (s *Store) Put(records ...Record) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, record := range records {
+		s.records[record.URL] = record
+		if err := s.write(record); err != nil {
+			return err
+		}
+	}
+	return s.w.Flush()
+}
+
+// Get returns the record of url
+func (s *Store) Get(url string) (Record, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	record, ok := s.records[url]
+	return record, ok
+}
+
+// URLs returns the urls with status, or all urls if status is empty, sorted
+func (s *Store) URLs(status Status) []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.sortedURLs(status)
+}
+
+func (s *Store) sortedURLs(status Status) []string {
+	urls := make([]string, 0, len(s.records))
+	for url, record := range s.records {
+		if status == "" || record.Status == status {
+			urls = append(urls, url)
+		}
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+// Counts returns the number of urls by status
+func (s *Store) Counts() map[Status]int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	counts := make(map[Status]int)
+	for _, record := range s.records {
+		counts[record.Status]++
+	}
+	return counts
+}
+
+// Close closes the file of the store
+func (s *Store) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.file.Close()
+}
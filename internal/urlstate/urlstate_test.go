@@ -0,0 +1,227 @@
+package urlstate
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "urlstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "run.state")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = s.Put(
+		Record{URL: "http://a", Status: Pending},
+		Record{URL: "http://b", Status: Pending},
+		Record{URL: "http://c", Status: Pending},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// finished out of order
+	if err = s.Put(Record{URL: "http://c", Status: Done, Bytes: 10, Path: "out/c", Checksum: "sha256:00", Attempts: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Put(Record{URL: "http://b", Status: Active}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// reopened, with a line cut off by a crash
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = f.WriteString(`{"url":"http://a","status":"do`); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	if s, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	testCases := []struct {
+		url      string
+		expected Record
+	}{
+		{"http://a", Record{URL: "http://a", Status: Pending}},
+		{"http://b", Record{URL: "http://b", Status: Pending}},
+		{"http://c", Record{URL: "http://c", Status: Done, Bytes: 10, Path: "out/c", Checksum: "sha256:00", Attempts: 1}},
+	}
+	for _, tc := range testCases {
+		received, ok := s.Get(tc.url)
+		if !ok || received != tc.expected {
+			t.Errorf("expected %+v received %+v (ok=%v)", tc.expected, received, ok)
+		}
+	}
+
+	if received := s.URLs(Pending); !reflect.DeepEqual(received, []string{"http://a", "http://b"}) {
+		t.Errorf("expected the pending urls a and b received %v", received)
+	}
+	expected := map[Status]int{Pending: 2, Done: 1}
+	if received := s.Counts(); !reflect.DeepEqual(received, expected) {
+		t.Errorf("expected %v received %v", expected, received)
+	}
+}
+
+func TestOpenTruncated(t *testing.T) {
+	dir, err := ioutil.TempDir("", "urlstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "run.state")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pending := Record{URL: "http://a", Status: Pending}
+	done := Record{URL: "http://a", Status: Done, Bytes: 10, Checksum: "sha256:00", Attempts: 1}
+	other := Record{URL: "http://b", Status: Failed, Error: "404 Not Found"}
+	for _, record := range []Record{pending, done, other} {
+		if err = s.Put(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the file as a crash may have left it after every byte that was written
+	for n := 0; n <= len(data); n++ {
+		if err = ioutil.WriteFile(path, data[:n], 0644); err != nil {
+			t.Fatal(err)
+		}
+		// a line is complete once its closing brace was written
+		complete := bytes.Count(data[:n], []byte("\n"))
+		if n > 0 && data[n-1] == '}' {
+			complete++
+		}
+
+		s, err := Open(path)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		a, okA := s.Get("http://a")
+		b, okB := s.Get("http://b")
+		switch {
+		case complete == 0 && (okA || okB):
+			t.Errorf("%d bytes: expected no records received %+v and %+v", n, a, b)
+		case complete == 1 && (a != pending || okB):
+			t.Errorf("%d bytes: expected %+v received %+v and %+v", n, pending, a, b)
+		case complete == 2 && (a != done || okB):
+			t.Errorf("%d bytes: expected %+v received %+v and %+v", n, done, a, b)
+		case complete == 3 && (a != done || b != other):
+			t.Errorf("%d bytes: expected %+v and %+v received %+v and %+v", n, done, other, a, b)
+		}
+
+		// the records that follow aren't lost to the cut off line
+		if err = s.Put(Record{URL: "http://c", Status: Pending}); err != nil {
+			t.Fatal(err)
+		}
+		if err = s.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if s, err = Open(path); err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if _, ok := s.Get("http://c"); !ok {
+			t.Errorf("%d bytes: expected the record that followed", n)
+		}
+		if err = s.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestOpenInterruptedCompaction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "urlstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "run.state")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Put(Record{URL: "http://a", Status: Done}); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// a crash while compacting leaves a partial file next to the store
+	if err = ioutil.WriteFile(path+".tmp", []byte(`{"url":"http://a","sta`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if s, err = Open(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	expected := Record{URL: "http://a", Status: Done}
+	if received, ok := s.Get("http://a"); !ok || received != expected {
+		t.Errorf("expected %+v received %+v (ok=%v)", expected, received, ok)
+	}
+	if _, err = os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("expected the partial file to be replaced received %v", err)
+	}
+}
+
+func TestRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "urlstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "run.state")
+
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err = s.Put(Record{URL: "http://a", Status: Active}, Record{URL: "http://b", Status: Done}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the store is still in use, the active url stays active
+	records, err := Read(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]Record{
+		"http://a": {URL: "http://a", Status: Active},
+		"http://b": {URL: "http://b", Status: Done},
+	}
+	if !reflect.DeepEqual(records, expected) {
+		t.Errorf("expected %v received %v", expected, records)
+	}
+
+	if _, err = Read(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Errorf("expected a missing file received %v", err)
+	}
+}
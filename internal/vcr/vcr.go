@@ -0,0 +1,174 @@
+package vcr
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotRecorded is returned by a Player for requests that were never recorded
+var ErrNotRecorded = errors.New("request was not recorded")
+
+// interaction is the metadata stored next to a recorded response body
+type interaction struct {
+	Method     string      `json:"method"`
+	URL        string      `json:"url"`
+	Range      string      `json:"range,omitempty"`
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+}
+
+// key returns the file name (without extension) that req is stored under
+func key(req *http.Request) string {
+	id := strings.Join([]string{req.Method, req.URL.String(), req.Header.Get("Range")}, " ")
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(id)))
+}
+
+// Recorder is a http.RoundTripper that passes requests on to Transport and
+// stores every response that is read completely in Dir.
+type Recorder struct {
+	Dir       string
+	Transport http.RoundTripper
+}
+
+// RoundTrip executes req and records its response
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	response, err := r.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	// the response is dropped if it can't be recorded, its connection is
+	// released
+	if err = os.MkdirAll(r.Dir, os.ModePerm); err != nil {
+		response.Body.Close()
+		return nil, err
+	}
+
+	base := filepath.Join(r.Dir, key(req))
+	tmp, err := ioutil.TempFile(r.Dir, ".recording")
+	if err != nil {
+		response.Body.Close()
+		return nil, err
+	}
+
+	response.Body = &recordingBody{
+		body: response.Body,
+		tmp:  tmp,
+		base: base,
+		meta: interaction{
+			Method:     req.Method,
+			URL:        req.URL.String(),
+			Range:      req.Header.Get("Range"),
+			StatusCode: response.StatusCode,
+			Header:     response.Header,
+		},
+	}
+
+	return response, nil
+}
+
+// recordingBody copies everything that is read from body into tmp and
+// stores the recording once body has been read to the end
+type recordingBody struct {
+	body     io.ReadCloser
+	tmp      *os.File
+	base     string
+	meta     interaction
+	complete bool
+}
+
+func (b *recordingBody) Read(p []byte) (int, error) {
+	n, err := b.body.Read(p)
+	if n > 0 {
+		if _, werr := b.tmp.Write(p[:n]); werr != nil {
+			return n, werr
+		}
+	}
+	if err == io.EOF {
+		b.complete = true
+	}
+	return n, err
+}
+
+func (b *recordingBody) Close() error {
+	err := b.body.Close()
+	if cerr := b.tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	// incomplete responses are not worth replaying
+	if !b.complete {
+		if rerr := os.Remove(b.tmp.Name()); err == nil {
+			err = rerr
+		}
+		return err
+	}
+
+	meta, merr := json.Marshal(b.meta)
+	if merr == nil {
+		merr = ioutil.WriteFile(b.base+".json", meta, os.ModePerm)
+	}
+	if merr == nil {
+		merr = os.Rename(b.tmp.Name(), b.base+".body")
+	}
+	if err == nil {
+		err = merr
+	}
+
+	return err
+}
+
+// Player is a http.RoundTripper that answers requests with the responses a
+// Recorder stored in Dir, without any network access.
+type Player struct {
+	Dir string
+}
+
+// RoundTrip replays the recorded response for req
+func (p *Player) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := filepath.Join(p.Dir, key(req))
+
+	b, err := ioutil.ReadFile(base + ".json")
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s %s: %w", req.Method, req.URL, ErrNotRecorded)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var meta interaction
+	if err = json.Unmarshal(b, &meta); err != nil {
+		return nil, err
+	}
+
+	body, err := os.Open(base + ".body")
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := body.Stat()
+	if err != nil {
+		_ = body.Close()
+		return nil, err
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", meta.StatusCode, http.StatusText(meta.StatusCode)),
+		StatusCode:    meta.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        meta.Header,
+		Body:          body,
+		ContentLength: fi.Size(),
+		Request:       req,
+	}, nil
+}
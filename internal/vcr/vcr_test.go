@@ -0,0 +1,111 @@
+package vcr
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vcr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err = os.RemoveAll(dir); err != nil {
+			t.Error(err)
+		}
+	}()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Path", r.URL.Path)
+		_, _ = fmt.Fprintf(w, "content of %s", r.URL.Path)
+	}))
+
+	recorder := &http.Client{Transport: &Recorder{Dir: dir, Transport: http.DefaultTransport}}
+	response, err := recorder.Get(server.URL + "/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ioutil.ReadAll(response.Body); err != nil {
+		t.Fatal(err)
+	}
+	if err = response.Body.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the replay must work without the server
+	server.Close()
+
+	player := &http.Client{Transport: &Player{Dir: dir}}
+	response, err = player.Get(server.URL + "/a.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = response.Body.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(b) != "content of /a.txt" || response.Header.Get("X-Path") != "/a.txt" {
+		t.Errorf("replayed body=%q header=%v", b, response.Header)
+	}
+
+	_, err = player.Get(server.URL + "/b.txt")
+	if !errors.Is(err, ErrNotRecorded) {
+		t.Errorf("expected ErrNotRecorded, received %v", err)
+	}
+}
+
+// closeTracker is a response body that remembers whether it was closed
+type closeTracker struct {
+	io.Reader
+	closed bool
+}
+
+func (c *closeTracker) Close() error {
+	c.closed = true
+	return nil
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestRecorderClosesUnrecordedBody(t *testing.T) {
+	file, err := ioutil.TempFile("", "vcr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	body := &closeTracker{Reader: strings.NewReader("content")}
+	transport := roundTripFunc(func(*http.Request) (*http.Response, error) {
+		return &http.Response{StatusCode: 200, Header: http.Header{}, Body: body}, nil
+	})
+
+	// a file is in the way of the directory, so nothing can be recorded
+	recorder := &Recorder{Dir: filepath.Join(file.Name(), "dir"), Transport: transport}
+	req := httptest.NewRequest("GET", "http://example.com/a.txt", nil)
+	if _, err = recorder.RoundTrip(req); err == nil {
+		t.Fatal("expected an error")
+	}
+	if !body.closed {
+		t.Error("expected the body of the response to be closed")
+	}
+}
@@ -0,0 +1,420 @@
+// Package yaml parses the subset of YAML that url manifests are written in,
+// not the whole language:
+//
+//   - block mappings and sequences, indented with spaces
+//   - flow sequences and mappings that fit on one line: [a, b], {a: 1}
+//   - plain, 'single' and "double" quoted scalars on one line
+//   - null, ~, true, false and numbers; every other plain scalar is a string
+//   - comments and a --- that starts the document
+//
+// Anchors, aliases, tags, directives, block and multi-line scalars and
+// streams of several documents fail to parse instead of being misread.
+package yaml
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// line is a line of the document without its indentation and comment
+type line struct {
+	number int
+	indent int
+	text   string
+}
+
+// parser holds the remaining lines of a document
+type parser struct {
+	lines []line
+	i     int
+}
+
+// Unmarshal parses a YAML document into the types of encoding/json:
+// map[string]interface{}, []interface{}, string, float64, bool and nil. An
+// empty document is nil.
+func Unmarshal(data []byte) (interface{}, error) {
+	lines, err := splitLines(string(data))
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, nil
+	}
+
+	ps := &parser{lines: lines}
+	value, err := ps.parseNode(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if ps.i < len(ps.lines) {
+		return nil, ps.errorf("unexpected %q", ps.lines[ps.i].text)
+	}
+	return value, nil
+}
+
+// splitLines returns the lines of data that hold content
+func splitLines(data string) ([]line, error) {
+	var lines []line
+	for n, text := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimLeft(text, " ")
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs can't be used for indentation", n+1)
+		}
+
+		content := strings.TrimSpace(stripComment(trimmed))
+		if content == "" || n == 0 && content == "---" {
+			continue
+		}
+		if content == "---" || content == "..." {
+			return nil, fmt.Errorf("line %d: only a single document is supported", n+1)
+		}
+		lines = append(lines, line{number: n + 1, indent: len(text) - len(trimmed), text: content})
+	}
+	return lines, nil
+}
+
+// stripComment removes a comment, which starts with a # at the start of the
+// text or after a space, outside of quotes
+func stripComment(text string) string {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" [{,", text[i-1]) >= 0):
+			quote = c
+		case c == '#' && (i == 0 || text[i-1] == ' ' || text[i-1] == '\t'):
+			return text[:i]
+		}
+	}
+	return text
+}
+
+func (ps *parser) errorf(format string, args ...interface{}) error {
+	number := ps.lines[len(ps.lines)-1].number
+	if ps.i < len(ps.lines) {
+		number = ps.lines[ps.i].number
+	}
+	return fmt.Errorf("line %d: %s", number, fmt.Sprintf(format, args...))
+}
+
+// parseNode parses the node whose lines start at indent
+func (ps *parser) parseNode(indent int) (interface{}, error) {
+	l := ps.lines[ps.i]
+	switch {
+	case l.indent != indent:
+		return nil, ps.errorf("unexpected indentation")
+	case isSequenceItem(l.text):
+		return ps.parseSequence(indent)
+	case keyEnd(l.text) >= 0:
+		return ps.parseMapping(indent)
+	}
+
+	ps.i++
+	return parseInline(l.text)
+}
+
+// isSequenceItem reports whether text is an item of a block sequence
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// parseSequence parses the items of a block sequence at indent
+func (ps *parser) parseSequence(indent int) (interface{}, error) {
+	items := []interface{}{}
+	for ps.i < len(ps.lines) && ps.lines[ps.i].indent == indent && isSequenceItem(ps.lines[ps.i].text) {
+		l := ps.lines[ps.i]
+		rest := strings.TrimLeft(l.text[1:], " ")
+
+		// the content after "- " is parsed as if it started a line of its
+		// own, so that the following lines of a mapping line up with it
+		if rest == "" {
+			ps.i++
+			item, err := ps.parseChild(indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+		ps.lines[ps.i] = line{number: l.number, indent: indent + len(l.text) - len(rest), text: rest}
+		item, err := ps.parseNode(ps.lines[ps.i].indent)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// parseMapping parses the pairs of a block mapping at indent
+func (ps *parser) parseMapping(indent int) (interface{}, error) {
+	pairs := map[string]interface{}{}
+	for ps.i < len(ps.lines) && ps.lines[ps.i].indent == indent && !isSequenceItem(ps.lines[ps.i].text) {
+		l := ps.lines[ps.i]
+		end := keyEnd(l.text)
+		if end < 0 {
+			return nil, ps.errorf("expected \"key: value\", found %q", l.text)
+		}
+		key, err := parseKey(l.text[:end])
+		if err != nil {
+			return nil, ps.errorf("%v", err)
+		}
+		if _, ok := pairs[key]; ok {
+			return nil, ps.errorf("duplicate key %q", key)
+		}
+
+		rest := strings.TrimSpace(l.text[end+1:])
+		ps.i++
+		if rest != "" {
+			if pairs[key], err = parseInline(rest); err != nil {
+				return nil, fmt.Errorf("line %d: %v", l.number, err)
+			}
+			continue
+		}
+
+		// the items of a sequence may be at the indentation of the key
+		if ps.i < len(ps.lines) && ps.lines[ps.i].indent == indent && isSequenceItem(ps.lines[ps.i].text) {
+			pairs[key], err = ps.parseSequence(indent)
+		} else {
+			pairs[key], err = ps.parseChild(indent)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pairs, nil
+}
+
+// parseChild parses the node that is indented deeper than its parent at
+// indent, or returns nil if there is none
+func (ps *parser) parseChild(indent int) (interface{}, error) {
+	if ps.i >= len(ps.lines) || ps.lines[ps.i].indent <= indent {
+		return nil, nil
+	}
+	return ps.parseNode(ps.lines[ps.i].indent)
+}
+
+// keyEnd returns the position of the colon that ends the key of a mapping
+// line, or -1 if text isn't one
+func keyEnd(text string) int {
+	var quote byte
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case i == 0 && (c == '"' || c == '\''):
+			quote = c
+		case i == 0 && (c == '[' || c == '{'):
+			return -1
+		case c == ':' && (i == len(text)-1 || text[i+1] == ' '):
+			return i
+		}
+	}
+	return -1
+}
+
+// parseKey parses the key of a mapping, which may be quoted
+func parseKey(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", fmt.Errorf("empty key")
+	}
+	if s[0] == '"' || s[0] == '\'' {
+		return parseQuoted(s)
+	}
+	return s, nil
+}
+
+// parseInline parses a value that is written on a single line: a flow
+// sequence, a flow mapping or a scalar
+func parseInline(s string) (interface{}, error) {
+	f := &flow{s: s}
+	value, err := f.parseValue(false)
+	if err != nil {
+		return nil, err
+	}
+	if f.skipSpaces(); f.i < len(f.s) {
+		return nil, fmt.Errorf("unexpected %q after the value", f.s[f.i:])
+	}
+	return value, nil
+}
+
+// flow parses the values of a single line
+type flow struct {
+	s string
+	i int
+}
+
+func (f *flow) skipSpaces() {
+	for f.i < len(f.s) && f.s[f.i] == ' ' {
+		f.i++
+	}
+}
+
+// unsupported are the characters that start the YAML constructs outside of
+// the subset, and those that YAML reserves, when they start a value
+const unsupported = "&*!|>%@`"
+
+// parseValue parses the value at the position of f. Plain scalars in flow
+// collections, inFlow, end at a comma or at the end of their collection.
+func (f *flow) parseValue(inFlow bool) (interface{}, error) {
+	f.skipSpaces()
+	if f.i >= len(f.s) {
+		return nil, nil
+	}
+
+	switch f.s[f.i] {
+	case '[':
+		return f.parseFlowSequence()
+	case '{':
+		return f.parseFlowMapping()
+	case '"', '\'':
+		end, err := quotedEnd(f.s[f.i:])
+		if err != nil {
+			return nil, err
+		}
+		value, err := parseQuoted(f.s[f.i : f.i+end])
+		f.i += end
+		return value, err
+	}
+
+	if strings.IndexByte(unsupported, f.s[f.i]) >= 0 {
+		return nil, fmt.Errorf("%q: anchors, aliases, tags and block scalars are not supported, quote the value if it is a string", f.s[f.i:])
+	}
+
+	start := f.i
+	for f.i < len(f.s) && !(inFlow && (f.s[f.i] == ',' || f.s[f.i] == ']' || f.s[f.i] == '}')) {
+		f.i++
+	}
+	return parsePlain(strings.TrimSpace(f.s[start:f.i])), nil
+}
+
+func (f *flow) parseFlowSequence() (interface{}, error) {
+	items := []interface{}{}
+	f.i++ // [
+	for {
+		if f.skipSpaces(); f.i < len(f.s) && f.s[f.i] == ']' {
+			f.i++
+			return items, nil
+		}
+		item, err := f.parseValue(true)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if err = f.endOfItem(']'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (f *flow) parseFlowMapping() (interface{}, error) {
+	pairs := map[string]interface{}{}
+	f.i++ // {
+	for {
+		if f.skipSpaces(); f.i < len(f.s) && f.s[f.i] == '}' {
+			f.i++
+			return pairs, nil
+		}
+
+		end := strings.Index(f.s[f.i:], ":")
+		if end < 0 {
+			return nil, fmt.Errorf("expected \"key: value\" in %q", f.s)
+		}
+		key, err := parseKey(f.s[f.i : f.i+end])
+		if err != nil {
+			return nil, err
+		}
+		f.i += end + 1
+		if pairs[key], err = f.parseValue(true); err != nil {
+			return nil, err
+		}
+		if err = f.endOfItem('}'); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// endOfItem skips the comma after an item of a flow collection, or stops
+// before the closing bracket
+func (f *flow) endOfItem(closing byte) error {
+	f.skipSpaces()
+	switch {
+	case f.i >= len(f.s):
+		return fmt.Errorf("missing %q in %q", closing, f.s)
+	case f.s[f.i] == ',':
+		f.i++
+	case f.s[f.i] != closing:
+		return fmt.Errorf("unexpected %q in %q", f.s[f.i], f.s)
+	}
+	return nil
+}
+
+// quotedEnd returns the position after the closing quote of the quoted
+// scalar at the start of s
+func quotedEnd(s string) (int, error) {
+	quote := s[0]
+	for i := 1; i < len(s); i++ {
+		switch {
+		case quote == '"' && s[i] == '\\':
+			i++
+		case s[i] == quote && quote == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			i++ // an escaped single quote
+		case s[i] == quote:
+			return i + 1, nil
+		}
+	}
+	return 0, fmt.Errorf("unterminated string %s", s)
+}
+
+// parseQuoted parses a quoted scalar including its quotes
+func parseQuoted(s string) (string, error) {
+	if end, err := quotedEnd(s); err != nil {
+		return "", err
+	} else if end != len(s) {
+		return "", fmt.Errorf("unexpected %q after the string", s[end:])
+	}
+
+	if s[0] == '\'' {
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	value, err := strconv.Unquote(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid string %s", s)
+	}
+	return value, nil
+}
+
+// number matches the plain scalars that are numbers
+var number = regexp.MustCompile(`^[-+]?(\d+\.?\d*|\.\d+)([eE][-+]?\d+)?$`)
+
+// parsePlain parses an unquoted scalar into a number, a bool, nil or a string
+func parsePlain(s string) interface{} {
+	switch s {
+	case "", "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if number.MatchString(s) {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return s
+}
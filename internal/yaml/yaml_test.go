@@ -0,0 +1,72 @@
+package yaml
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUnmarshal(t *testing.T) {
+	testCases := []struct {
+		document string
+		expected interface{}
+	}{
+		{"", nil},
+		{"# only a comment\n", nil},
+		{"hello", "hello"},
+		{"- a\n- b\n", []interface{}{"a", "b"}},
+		{"a: 1\nb: true\nc: ~\nd: 1.5\ne: 1e3\nf: 0x10\ng: 30s\n",
+			map[string]interface{}{"a": 1.0, "b": true, "c": nil, "d": 1.5, "e": 1000.0, "f": "0x10", "g": "30s"}},
+		{"---\n- url: http://example.com/a.zip # the file\n  name: a.zip\n- url: 'http://example.com/#b'\n",
+			[]interface{}{
+				map[string]interface{}{"url": "http://example.com/a.zip", "name": "a.zip"},
+				map[string]interface{}{"url": "http://example.com/#b"},
+			}},
+		{"- url: http://example.com/a\n  headers:\n    Accept: text/plain\n    X-Tag: [a, b]\n  mirrors:\n  - http://m1/a\n  - http://m2/a\n",
+			[]interface{}{map[string]interface{}{
+				"url":     "http://example.com/a",
+				"headers": map[string]interface{}{"Accept": "text/plain", "X-Tag": []interface{}{"a", "b"}},
+				"mirrors": []interface{}{"http://m1/a", "http://m2/a"},
+			}}},
+		{"- {url: http://example.com/a, priority: 2}\n- [1, \"two\", 'it''s']\n- []\n",
+			[]interface{}{
+				map[string]interface{}{"url": "http://example.com/a", "priority": 2.0},
+				[]interface{}{1.0, "two", "it's"},
+				[]interface{}{},
+			}},
+		{"-\n  - nested\n- \"quoted # not a comment\"\n",
+			[]interface{}{[]interface{}{"nested"}, "quoted # not a comment"}},
+		{"\"a: b\": c\nempty:\n", map[string]interface{}{"a: b": "c", "empty": nil}},
+		{"pattern: '*.jpg'\nsize: 5MB\n", map[string]interface{}{"pattern": "*.jpg", "size": "5MB"}},
+	}
+	for _, testCase := range testCases {
+		received, err := Unmarshal([]byte(testCase.document))
+		if err != nil {
+			t.Errorf("%q: %v", testCase.document, err)
+			continue
+		}
+		if !reflect.DeepEqual(received, testCase.expected) {
+			t.Errorf("%q: expected %#v received %#v", testCase.document, testCase.expected, received)
+		}
+	}
+
+	for _, invalid := range []string{
+		"a: 1\n  b: 2\n",
+		"- a\nb: 1\n",
+		"a: 1\na: 2\n",
+		"a: [1, 2\n",
+		"a: \"open\n",
+		"\ta: 1\n",
+		"- a\n---\n- b\n",
+		// outside of the subset
+		"base: &base {a: 1}\nother: *base\n",
+		"a: !!str 1\n",
+		"a: |\n  line 1\n  line 2\n",
+		"a: >\n  folded\n",
+		"- [1, *x]\n",
+		"%YAML 1.2\n",
+	} {
+		if _, err := Unmarshal([]byte(invalid)); err == nil {
+			t.Errorf("%q expected an error", invalid)
+		}
+	}
+}
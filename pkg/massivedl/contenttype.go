@@ -0,0 +1,45 @@
+package massivedl
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// matchContentType reports whether mediaType matches one of patterns, which
+// are media types or types followed by /* like image/*
+func matchContentType(mediaType string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if pattern == mediaType || pattern == "*/*" ||
+			strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkContentType returns ErrContentType if the Content-Type of a response
+// doesn't pass WithContentTypes. Responses without one only pass if no types
+// are accepted explicitly.
+func (d *Downloader) checkContentType(header http.Header) error {
+	if len(d.acceptTypes) == 0 && len(d.rejectTypes) == 0 {
+		return nil
+	}
+
+	value := header.Get("Content-Type")
+	mediaType, _, err := mime.ParseMediaType(value)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(value))
+	}
+
+	switch {
+	case len(d.acceptTypes) > 0 && mediaType == "":
+		return fmt.Errorf("%w: the response has no Content-Type", ErrContentType)
+	case len(d.acceptTypes) > 0 && !matchContentType(mediaType, d.acceptTypes):
+		return fmt.Errorf("%w: %s is not accepted", ErrContentType, mediaType)
+	case matchContentType(mediaType, d.rejectTypes):
+		return fmt.Errorf("%w: %s", ErrContentType, mediaType)
+	}
+	return nil
+}
@@ -0,0 +1,691 @@
+package massivedl
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/checksum"
+	"github.com/dimkouv/massivedl/internal/fileutil"
+	"github.com/dimkouv/massivedl/internal/httputil"
+	"github.com/dimkouv/massivedl/internal/netutil"
+	"github.com/dimkouv/massivedl/internal/ratelimit"
+	"github.com/dimkouv/massivedl/internal/sizeutil"
+)
+
+// what happens to files that still fail checksum verification after all
+// retries, see WithChecksumFailAction
+const (
+	ChecksumFailDelete = "delete" // remove the file
+	ChecksumFailKeep   = "keep"   // keep the file under its name
+	ChecksumFailRename = "rename" // keep the file as <name>.corrupt
+)
+
+// unknownSize is the ContentLength of responses without Content-Length, like
+// chunked ones
+const unknownSize = -1
+
+// PartSuffix is appended to the name of a file while it is being downloaded
+const PartSuffix = ".part"
+
+// validatorSuffix is appended to the name of a part file to store the ETag or
+// Last-Modified date of the response it was started from
+const validatorSuffix = ".validator"
+
+// maxRetryAfter caps how long a worker waits for the Retry-After header of a
+// response before it retries
+const maxRetryAfter = time.Minute
+
+// transfer is what the attempts of the download of a job share
+type transfer struct {
+	job      job
+	worker   int
+	header   http.Header
+	maxSize  int64
+	retries  int
+	limiter  *ratelimit.Limiter
+	progress *progressEmitter
+
+	writeTime int64 // nanoseconds spent writing into files, atomic
+}
+
+// download downloads j to filepath, retrying failed attempts.
+//
+// The data is written to filepath + PartSuffix and moved into place once the
+// transfer is complete and matches the checksum of the entry, if it has one.
+// A part file left behind by an earlier attempt or run is resumed instead of
+// being downloaded again from the start, possibly from another mirror. The
+// part file of a download that failed for good is kept for that unless
+// WithKeepPartial(false) is given.
+func (d *Downloader) download(ctx context.Context, worker int, j job, filepath string) Result {
+	res := Result{Entry: j.entry, Path: filepath}
+	start := time.Now()
+	t := &transfer{
+		job:      j,
+		worker:   worker,
+		maxSize:  d.maxSize,
+		retries:  d.retries,
+		progress: newProgressEmitter(d, j.entry, worker, filepath),
+	}
+	defer func() {
+		res.Duration = time.Since(start)
+		res.WriteTime = time.Duration(atomic.LoadInt64(&t.writeTime))
+	}()
+
+	// the limits of the entry replace the global ones
+	maxTime := d.maxTime
+	if j.entry.Timeout > 0 {
+		maxTime = j.entry.Timeout
+	}
+	if j.entry.MaxSize > 0 {
+		t.maxSize = j.entry.MaxSize
+	}
+	if j.entry.Retries != nil {
+		t.retries = *j.entry.Retries
+	}
+
+	// with WithFairShare the downloads of a job share its part of the rate
+	// limit
+	var done func()
+	t.limiter, done = d.jobLimiter(j.entry.Job)
+	defer done()
+
+	if d.memory != nil {
+		res.Path = ""
+		d.downloadMemory(ctx, t, maxTime, &res)
+		return res
+	}
+
+	// an entry that is named after the download is received under a name
+	// of its own
+	base := filepath
+	if filepath == "" {
+		sum := sha256.Sum256([]byte(strconv.Itoa(j.index) + " " + j.entry.URL))
+		base = path.Join(d.outputDir, ".massivedl-"+hex.EncodeToString(sum[:8]))
+	}
+
+	// create subdirectories if they do not exist
+	err := d.suspendable(ctx, func() error {
+		return os.MkdirAll(path.Dir(base), os.ModePerm)
+	})
+	if err != nil {
+		res.Err = err
+		return res
+	}
+
+	// a file that was saved before is reused instead
+	if filepath != "" && d.hooks.Reuse != nil {
+		if reused, err := d.hooks.Reuse(j.entry, filepath); reused {
+			res.Err = err
+			return res
+		}
+	}
+
+	// every failed attempt moves on to the next mirror, and every mirror is
+	// tried at least once
+	t.header = d.requestHeader(j)
+	urls := j.urls
+	if d.fastestMirror && len(urls) > 1 {
+		urls = d.fastestFirst(ctx, urls, t.header)
+	}
+	// conditional requests are answered with the whole file or not at all
+	conditional := t.header.Get("If-None-Match") != "" || t.header.Get("If-Modified-Since") != ""
+	var budget retryBudget
+
+	// the time limit covers all attempts
+	runCtx := ctx
+	ctx, cancel := context.WithCancel(runCtx)
+	if maxTime > 0 {
+		ctx, cancel = context.WithTimeout(runCtx, maxTime)
+	}
+	defer cancel()
+
+	for tries := 0; ; tries++ {
+		attemptStart := time.Now()
+		url := urls[tries%len(urls)]
+		partPath := base + PartSuffix
+		response := &Response{Index: j.index}
+		segmented := false
+		wholeBody := false // the response had the whole file as it was sent
+		var n int64
+		var err error
+
+		if d.segments > 1 && !conditional && !fileutil.FileOrPathExists(partPath) {
+			partPath = base + SegmentedPartSuffix
+			n, segmented, err = d.downloadSegmented(ctx, t, url, partPath, response)
+		}
+		if segmented {
+			res.StatusCode, response.StatusCode = http.StatusPartialContent, http.StatusPartialContent
+		} else {
+			var last *http.Response
+			partPath = base + PartSuffix
+			n, last, err = d.downloadPart(ctx, t, url, partPath)
+			if last != nil {
+				res.StatusCode = last.StatusCode
+				response.URL, response.StatusCode, response.Header = last.Request.URL, last.StatusCode, last.Header
+				wholeBody = last.StatusCode == http.StatusOK && !last.Uncompressed
+			}
+		}
+		res.Bytes += n
+		res.Attempts++
+
+		if err == nil && !j.checksum.IsZero() {
+			err = j.checksum.VerifyFile(partPath)
+		}
+		if err == nil && wholeBody && d.digestHeaders {
+			err = verifyDigestHeaders(partPath, response.Header)
+		}
+		rejected := false
+		if err == nil && d.hooks.Check != nil {
+			err = d.hooks.Check(j.entry, response, partPath, res.Attempts)
+			rejected = err != nil
+		}
+
+		if errors.Is(err, errNotModified) {
+			d.logln("[NOT MODIFIED]", url, filepath)
+			res.NotModified = true
+			res.Err = nil
+			break
+		}
+
+		// requests of a canceled run aren't retried, the part file is kept
+		// for the next one
+		if err != nil && runCtx.Err() != nil {
+			res.Err = err
+			break
+		}
+		// a full disk suspends the download instead of failing it, the part
+		// file is resumed once there is space again
+		if err != nil && d.hooks.Suspend != nil && d.hooks.Suspend(err) {
+			if !d.waitResumed(ctx, nil) {
+				res.Err = err
+				break
+			}
+			tries--
+			continue
+		}
+		if err != nil && ctx.Err() != nil {
+			d.logln("[MAX TIME]", url, filepath, err)
+			res.Err = fmt.Errorf("%w: exceeded the time limit of %s", err, maxTime)
+			break
+		}
+
+		if err != nil {
+			d.logln("[RETRY]", tries, url, filepath, err)
+			res.Err = err
+			lastTry := !budget.fail(d, err, t.retries) && tries >= len(urls)-1
+
+			// neither corrupted, rejected, too large or mistyped files nor
+			// the holes of an incomplete segmented download can be resumed
+			if errors.Is(err, ErrChecksumMismatch) && lastTry {
+				d.handleChecksumFailure(partPath, filepath)
+			} else if errors.Is(err, ErrChecksumMismatch) || rejected || errors.Is(err, ErrTooLarge) || errors.Is(err, ErrContentType) || segmented {
+				if err = os.Remove(partPath); err != nil && !os.IsNotExist(err) {
+					d.logln(err)
+				}
+			}
+			if lastTry {
+				break
+			}
+
+			wait := retryAfter(res.Err)
+			d.emit(Event{Type: EntryRetry, Entry: j.entry, Worker: worker, Path: filepath, Err: res.Err, Duration: time.Since(attemptStart), RetryAfter: wait})
+			if wait > 0 {
+				select {
+				case <-ctx.Done():
+				case <-time.After(wait):
+				}
+			}
+			continue
+		}
+
+		res.Err = d.save(t, partPath, filepath, response, &res)
+		if res.Err != nil {
+			d.logln(res.Err)
+		}
+		break
+	}
+
+	// without WithKeepPartial nothing is left to resume a failed download
+	// from
+	if res.Err != nil && !d.keepPartial {
+		for _, partPath := range []string{base + PartSuffix, base + SegmentedPartSuffix} {
+			if err := os.Remove(partPath); err != nil && !os.IsNotExist(err) {
+				d.logln(err)
+			}
+		}
+	}
+
+	// the validator is only needed while the part file can be resumed
+	if !fileutil.FileOrPathExists(base + PartSuffix) {
+		d.removeValidator(base + PartSuffix)
+	}
+
+	return res
+}
+
+// retryAfter returns how long the server asked to wait before err is
+// retried, at most maxRetryAfter
+func retryAfter(err error) time.Duration {
+	var httpErr *HTTPError
+	if !errors.As(err, &httpErr) {
+		return 0
+	}
+	if httpErr.RetryAfter > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return httpErr.RetryAfter
+}
+
+// save moves the complete part file of t into place, with the Save hook if
+// there is one. The path it was saved at is set in res.
+func (d *Downloader) save(t *transfer, partPath, filepath string, response *Response, res *Result) error {
+	if d.hooks.Save != nil {
+		saved, err := d.hooks.Save(t.job.entry, partPath, filepath, response)
+		if err == nil {
+			res.Path = saved
+		}
+		return err
+	}
+	if filepath == "" {
+		return d.nameAfterDownload(t.job, partPath, response, res)
+	}
+	return os.Rename(partPath, filepath)
+}
+
+// nameAfterDownload moves the complete part file of j into place under the
+// name its Namer gives it now that the data is known
+func (d *Downloader) nameAfterDownload(j job, partPath string, response *Response, res *Result) error {
+	sum, err := checksum.SumFile("sha256", partPath)
+	if err == nil {
+		response.Downloaded, response.Checksum = true, sum.String()
+		var name string
+		if name, err = d.namer.Name(j.entry, response); err == nil {
+			res.Path, err = d.outputPath(name)
+		}
+	}
+	if err != nil {
+		_ = os.Remove(partPath)
+		return err
+	}
+
+	if _, err = os.Stat(res.Path); err == nil && d.skipExisting {
+		res.Skipped = true
+		return os.Remove(partPath)
+	}
+	if err = os.MkdirAll(path.Dir(res.Path), os.ModePerm); err != nil {
+		return err
+	}
+	return os.Rename(partPath, res.Path)
+}
+
+// suspendable runs f until it succeeds or fails with an error that the
+// Suspend hook doesn't suspend the download for
+func (d *Downloader) suspendable(ctx context.Context, f func() error) error {
+	for {
+		err := f()
+		if err == nil || d.hooks.Suspend == nil || !d.hooks.Suspend(err) || !d.waitResumed(ctx, nil) {
+			return err
+		}
+	}
+}
+
+// requestHeader returns the request headers of j: the User-Agent, the
+// headers of WithHeader and of the Header hook, and those of the entry
+func (d *Downloader) requestHeader(j job) http.Header {
+	header := http.Header{}
+	if d.userAgent != "" {
+		header.Set("User-Agent", d.userAgent)
+	}
+	for name, values := range d.header {
+		header[name] = append([]string(nil), values...)
+	}
+	if d.hooks.Header != nil {
+		d.hooks.Header(j.entry, header)
+	}
+	for name, values := range j.entry.Header {
+		header[name] = append([]string(nil), values...)
+	}
+	return header
+}
+
+// stalledError describes a transfer that the watchdog of WithMinSpeed
+// aborted
+func (d *Downloader) stalledError() error {
+	return fmt.Errorf("%w: slower than %s/s for %s", errStalled, sizeutil.FormatSize(d.minSpeed), d.minSpeedTime)
+}
+
+// verifyDigestHeaders checks the file at partPath against the checksums its
+// response announced in Content-MD5, x-amz-checksum-* or x-goog-hash headers.
+// A mismatch is an ErrChecksumMismatch, so the download is retried.
+func verifyDigestHeaders(partPath string, header http.Header) error {
+	for _, sum := range checksum.FromHeader(header) {
+		if err := sum.VerifyFile(partPath); err != nil {
+			return fmt.Errorf("%s announced by the server: %w", sum.Algorithm, err)
+		}
+	}
+	return nil
+}
+
+// retryBudget counts the failed attempts of a download. Connection failures,
+// which rarely heal within moments, have their own budget of
+// WithConnectRetries retries, all other failures share the budget of
+// WithRetries. Responses with a status that WithRetryOn doesn't retry, files
+// that are too large and the Permanent errors of Fetchers are not retried at
+// all.
+type retryBudget struct {
+	connectFailures int
+	failures        int
+}
+
+// fail records a failed attempt and reports whether the budget allows
+// another one
+func (b *retryBudget) fail(d *Downloader, err error, retries int) bool {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) && !d.retryOn(httpErr.StatusCode) {
+		return false
+	}
+	if errors.Is(err, ErrTooLarge) || isPermanent(err) {
+		return false
+	}
+
+	if netutil.IsConnectError(err) {
+		b.connectFailures++
+		return b.connectFailures <= d.connectRetries
+	}
+	b.failures++
+	return b.failures <= retries
+}
+
+// downloadPart appends the remaining bytes of url to partPath and returns the
+// number of bytes that were written. If partPath already contains data a Range
+// request is sent, and the data is only appended when the server answers with
+// a 206 response starting at the expected offset. Any other successful answer
+// replaces the contents of partPath. The Range request carries the validator
+// of the response partPath was started from in an If-Range header, so that a
+// changed file is sent completely instead of being appended to the old one.
+// The response is returned with its body closed, or nil if no response was
+// received. Files larger than the max size of t fail with ErrTooLarge.
+func (d *Downloader) downloadPart(ctx context.Context, t *transfer, url, partPath string) (int64, *http.Response, error) {
+	var offset int64
+	if fi, err := os.Stat(partPath); err == nil {
+		offset = fi.Size()
+	}
+
+	validator := ""
+	if offset > 0 {
+		validator = readValidator(partPath)
+	}
+
+	// the watchdog cancels transfers that stall
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watchdog := ratelimit.NewWatchdog(d.minSpeed, d.minSpeedTime, cancel)
+	defer watchdog.Stop()
+
+	req, err := d.newRequest(ctx, "GET", url, t)
+	if err != nil {
+		return 0, nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if validator != "" {
+			req.Header.Set("If-Range", validator)
+		}
+	}
+
+	response, err := d.send(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer func() {
+		if err = response.Body.Close(); err != nil {
+			d.logf("error closing response body: %v", err)
+		}
+	}()
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	var body io.Reader = response.Body
+
+	if offset > 0 {
+		switch response.StatusCode {
+		case http.StatusPartialContent:
+			// servers that ignore If-Range still send the current validator
+			if httputil.Changed(validator, response.Header) {
+				if err = os.Remove(partPath); err != nil {
+					return 0, response, err
+				}
+				return 0, response, errRemoteChanged
+			}
+
+			var start int64
+			if start, body, err = httputil.PartialBody(response.Header, response.Body); err != nil {
+				return 0, response, err
+			}
+			if start != offset {
+				return 0, response, fmt.Errorf("server resumed at byte %d instead of %d", start, offset)
+			}
+			flags = os.O_WRONLY | os.O_APPEND
+
+		case http.StatusOK:
+			d.logln("[RESUME]", url, "server sent the whole file, restarting")
+
+		case http.StatusRequestedRangeNotSatisfiable:
+			// the part file is either complete or larger than the remote file
+			if response.Header.Get("Content-Range") == fmt.Sprintf("bytes */%d", offset) {
+				return 0, response, nil
+			}
+			if err = os.Remove(partPath); err != nil {
+				return 0, response, err
+			}
+			return 0, response, fmt.Errorf("unable to resume at byte %d, restarting", offset)
+		}
+	}
+
+	if response.StatusCode == http.StatusNotModified {
+		return 0, response, errNotModified
+	}
+
+	// error responses are not saved, a part file stays as it was
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return 0, response, newHTTPError(response)
+	}
+
+	// like HTML error pages sent with 200 instead of the file
+	if err = d.checkContentType(response.Header); err != nil {
+		return 0, response, err
+	}
+
+	if response.ContentLength == unknownSize {
+		d.logUnknownSize(url, response)
+	}
+
+	var done int64
+	if flags&os.O_APPEND != 0 {
+		done = offset
+	}
+	if t.maxSize > 0 && response.ContentLength != unknownSize && done+response.ContentLength > t.maxSize {
+		return 0, response, fmt.Errorf("%w: %s, the limit is %s", ErrTooLarge, sizeutil.FormatSize(done+response.ContentLength), sizeutil.FormatSize(t.maxSize))
+	}
+
+	// small new files are received into memory and written once they are
+	// complete, a broken transfer leaves nothing behind to resume
+	inMemory := d.memoryBelow > 0 && offset == 0 && response.ContentLength != unknownSize && response.ContentLength <= d.memoryBelow
+
+	if flags&os.O_TRUNC != 0 && !inMemory {
+		if err = writeValidator(partPath, httputil.Validator(response.Header)); err != nil {
+			return 0, response, err
+		}
+	}
+
+	size := int64(unknownSize)
+	if response.ContentLength != unknownSize {
+		size = done + response.ContentLength
+	}
+	t.progress.reset(done, size)
+
+	body = ratelimit.NewReader(ctx, body, t.limiter, ratelimit.NewLimiter(d.connRate))
+	if t.maxSize > 0 {
+		// servers that don't announce the size are cut off after one byte
+		// more than allowed
+		body = &maxSizeReader{r: io.LimitReader(body, t.maxSize-done+1), n: done, max: t.maxSize}
+	}
+	if inMemory {
+		buf := bytes.NewBuffer(make([]byte, 0, response.ContentLength))
+		nBytes, err := io.Copy(io.MultiWriter(buf, progressWriter{d, t.progress}), watchdog.Reader(body))
+		if err != nil && watchdog.Stalled() {
+			err = d.stalledError()
+		}
+		if err == nil {
+			start := time.Now()
+			err = ioutil.WriteFile(partPath, buf.Bytes(), os.ModePerm)
+			atomic.AddInt64(&t.writeTime, int64(time.Since(start)))
+		}
+		return nBytes, response, err
+	}
+
+	file, err := os.OpenFile(partPath, flags, os.ModePerm)
+	if err != nil {
+		return 0, response, err
+	}
+	defer func() {
+		if err = file.Close(); err != nil {
+			d.logf("unable to close file: %v", err)
+		}
+	}()
+
+	nBytes, err := io.Copy(io.MultiWriter(timedWriter{file, &t.writeTime}, progressWriter{d, t.progress}), watchdog.Reader(body))
+	if err != nil && watchdog.Stalled() {
+		err = d.stalledError()
+	}
+
+	return nBytes, response, err
+}
+
+// newRequest returns a request for url with the headers of t
+func (d *Downloader) newRequest(ctx context.Context, method, url string, t *transfer) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(withEntry(ctx, t.job.entry), method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header = t.header.Clone()
+	return req, nil
+}
+
+// maxSizeReader fails with ErrTooLarge once more than max bytes of a file
+// were read, n counts the bytes of the file received before
+type maxSizeReader struct {
+	r   io.Reader
+	n   int64
+	max int64
+}
+
+func (r *maxSizeReader) Read(b []byte) (int, error) {
+	n, err := r.r.Read(b)
+	r.n += int64(n)
+	if r.n > r.max {
+		return n, fmt.Errorf("%w: more than %s", ErrTooLarge, sizeutil.FormatSize(r.max))
+	}
+	return n, err
+}
+
+// progressWriter counts the bytes written to it in the Progress and the
+// statistics of the Downloader and in the EntryProgress events
+type progressWriter struct {
+	d        *Downloader
+	progress *progressEmitter
+}
+
+func (w progressWriter) Write(b []byte) (int, error) {
+	w.d.addBytes(int64(len(b)))
+	w.progress.add(int64(len(b)))
+	return len(b), nil
+}
+
+// timedWriter adds the time of the writes into w to total, in nanoseconds
+type timedWriter struct {
+	w     io.Writer
+	total *int64
+}
+
+func (w timedWriter) Write(b []byte) (int, error) {
+	start := time.Now()
+	n, err := w.w.Write(b)
+	atomic.AddInt64(w.total, int64(time.Since(start)))
+	return n, err
+}
+
+// readValidator returns the validator of partPath, or "" if there is none
+func readValidator(partPath string) string {
+	b, err := ioutil.ReadFile(partPath + validatorSuffix)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// writeValidator stores the validator of partPath, an empty validator
+// removes the stored one
+func writeValidator(partPath, validator string) error {
+	if validator == "" {
+		if err := os.Remove(partPath + validatorSuffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return ioutil.WriteFile(partPath+validatorSuffix, []byte(validator+"\n"), os.ModePerm)
+}
+
+// removeValidator removes the validator of partPath, if there is one
+func (d *Downloader) removeValidator(partPath string) {
+	if err := os.Remove(partPath + validatorSuffix); err != nil && !os.IsNotExist(err) {
+		d.logln(err)
+	}
+}
+
+// logUnknownSize notes that the size of a response is only known once its
+// body ended. Chunked and compressed bodies still tell a complete transfer
+// from a dropped connection, http bodies that just end with the connection
+// don't.
+func (d *Downloader) logUnknownSize(url string, response *http.Response) {
+	// other protocols have their own way to report the end of a file
+	httpURL := strings.HasPrefix(url, "http")
+	if len(response.TransferEncoding) > 0 || response.Uncompressed || !httpURL {
+		d.logln("[CHUNKED]", url, "size unknown until the transfer ends")
+	} else {
+		d.logln("[CHUNKED]", url, "size unknown, a dropped connection can't be told from the end of the file")
+	}
+}
+
+// handleChecksumFailure applies the action of WithChecksumFailAction to a
+// file that failed checksum verification for the last time
+func (d *Downloader) handleChecksumFailure(partPath, filepath string) {
+	var err error
+
+	switch {
+	case filepath == "":
+		err = os.Remove(partPath)
+	case d.checksumFail == ChecksumFailKeep:
+		err = os.Rename(partPath, filepath)
+	case d.checksumFail == ChecksumFailRename:
+		err = os.Rename(partPath, filepath+".corrupt")
+	default:
+		err = os.Remove(partPath)
+	}
+
+	if err != nil {
+		d.logln(err)
+	}
+}
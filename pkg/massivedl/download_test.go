@@ -0,0 +1,239 @@
+package massivedl
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testContent is the data of the files of fileServer
+var testContent = []byte(strings.Repeat("0123456789", 1000))
+
+// fileServer serves testContent with Range and If-Range support under /file,
+// without them under /no-ranges, fails the first request to /flaky and
+// answers 404 to anything else. It counts the requests by path.
+func fileServer(t *testing.T) (*httptest.Server, map[string]int) {
+	var lock sync.Mutex
+	requests := map[string]int{}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		requests[r.URL.Path]++
+		n := requests[r.URL.Path]
+		lock.Unlock()
+
+		switch r.URL.Path {
+		case "/file":
+			w.Header().Set("ETag", `"v1"`)
+			http.ServeContent(w, r, "file", time.Time{}, bytes.NewReader(testContent))
+		case "/no-ranges":
+			_, _ = w.Write(testContent)
+		case "/flaky":
+			if n == 1 {
+				http.Error(w, "try again", http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write(testContent)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, requests
+}
+
+// tempDir returns a directory that is removed after the test
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "massivedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Error(err)
+		}
+	})
+	return dir
+}
+
+// downloadEntry downloads entry with d like a worker does
+func downloadEntry(t *testing.T, d *Downloader, entry Entry) Result {
+	j, err := d.newJob(0, entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d.download(context.Background(), 0, j, j.path)
+}
+
+// checkFile fails t if the file at savePath doesn't hold testContent or if a
+// part file of it was left behind
+func checkFile(t *testing.T, name, savePath string) {
+	data, err := ioutil.ReadFile(savePath)
+	if err != nil || !bytes.Equal(data, testContent) {
+		t.Errorf("%s: expected %d bytes of content received %d (%v)", name, len(testContent), len(data), err)
+	}
+	for _, suffix := range []string{PartSuffix, SegmentedPartSuffix} {
+		if _, err = os.Stat(savePath + suffix); !os.IsNotExist(err) {
+			t.Errorf("%s: expected no %s file received %v", name, suffix, err)
+		}
+	}
+}
+
+func TestDownload(t *testing.T) {
+	server, requests := fileServer(t)
+	dir := tempDir(t)
+
+	goodSum := fmt.Sprintf("sha256:%x", sha256.Sum256(testContent))
+	badSum := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("other")))
+
+	testCases := []struct {
+		name             string
+		path             string
+		checksum         string
+		expectedResult   bool
+		expectedRequests int
+	}{
+		{"complete", "/file", goodSum, true, 1},
+		{"retried", "/flaky", "", true, 2},
+		{"not found", "/missing", "", false, 1},
+		{"checksum mismatch", "/no-ranges", badSum, false, 3},
+	}
+
+	d := New(WithOutputDir(dir), WithRetries(2))
+	for _, testCase := range testCases {
+		entry := Entry{URL: server.URL + testCase.path, Path: testCase.name, Checksum: testCase.checksum}
+		savePath := path.Join(dir, testCase.name)
+
+		res := downloadEntry(t, d, entry)
+		if (res.Err == nil) != testCase.expectedResult || requests[testCase.path] != testCase.expectedRequests {
+			t.Errorf("%s: expected result %v after %d requests received %v after %d", testCase.name,
+				testCase.expectedResult, testCase.expectedRequests, res.Err, requests[testCase.path])
+		}
+		if testCase.expectedResult {
+			checkFile(t, testCase.name, savePath)
+		} else if _, err := os.Stat(savePath); !os.IsNotExist(err) {
+			t.Errorf("%s: expected no file received %v", testCase.name, err)
+		}
+	}
+}
+
+func TestDownloadResume(t *testing.T) {
+	server, requests := fileServer(t)
+	dir := tempDir(t)
+
+	testCases := []struct {
+		name      string
+		part      []byte
+		validator string
+	}{
+		// the part file is resumed with a range request
+		{"resumed", testContent[:4000], `"v1"`},
+		// the file changed since the part file was started, so the server
+		// sends it whole because of If-Range instead of the missing range
+		{"changed", []byte(strings.Repeat("x", 4000)), `"v0"`},
+		// without a validator the range is taken as it comes
+		{"no validator", testContent[:10], ""},
+	}
+
+	d := New(WithOutputDir(dir), WithRetries(0))
+	for _, testCase := range testCases {
+		savePath := path.Join(dir, testCase.name)
+		partPath := savePath + PartSuffix
+		if err := ioutil.WriteFile(partPath, testCase.part, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if testCase.validator != "" {
+			if err := writeValidator(partPath, testCase.validator); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		res := downloadEntry(t, d, Entry{URL: server.URL + "/file", Path: testCase.name})
+		if res.Err != nil {
+			t.Errorf("%s: expected the download to succeed received %v", testCase.name, res.Err)
+		}
+		checkFile(t, testCase.name, savePath)
+		if _, err := os.Stat(partPath + validatorSuffix); !os.IsNotExist(err) {
+			t.Errorf("%s: expected the validator to be removed received %v", testCase.name, err)
+		}
+	}
+	if requests["/file"] != len(testCases) {
+		t.Errorf("expected %d requests received %d", len(testCases), requests["/file"])
+	}
+}
+
+func TestDownloadSegmented(t *testing.T) {
+	server, _ := fileServer(t)
+	dir := tempDir(t)
+
+	var lock sync.Mutex
+	var progress []int64
+	d := New(WithOutputDir(dir), WithRetries(0), WithSegments(4, 1), WithListener(func(event Event) {
+		if event.Type == EntryProgress {
+			lock.Lock()
+			defer lock.Unlock()
+			progress = append(progress, event.Bytes)
+		}
+	}))
+
+	// the server without range support gets a single request instead
+	for _, name := range []string{"file", "no-ranges"} {
+		res := downloadEntry(t, d, Entry{URL: server.URL + "/" + name, Path: name})
+		if res.Err != nil {
+			t.Errorf("%s: expected the download to succeed received %v", name, res.Err)
+		}
+		if res.Bytes != int64(len(testContent)) {
+			t.Errorf("%s: expected %d bytes received %d", name, len(testContent), res.Bytes)
+		}
+		checkFile(t, name, path.Join(dir, name))
+	}
+
+	// the segments share the progress of their download
+	for _, n := range progress {
+		if n > int64(len(testContent)) {
+			t.Errorf("expected at most %d bytes of progress received %d", len(testContent), n)
+		}
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	d := New(WithConnectRetries(1))
+
+	connectErr := &url.Error{Op: "Get", URL: "http://example.com", Err: &net.OpError{Op: "dial", Err: errors.New("refused")}}
+	testCases := []struct {
+		name     string
+		errs     []error
+		expected []bool
+	}{
+		{"retried", []error{errors.New("reset"), errors.New("reset"), errors.New("reset")}, []bool{true, true, false}},
+		{"retried status", []error{&HTTPError{StatusCode: 503}, &HTTPError{StatusCode: 429}, &HTTPError{StatusCode: 500}}, []bool{true, true, false}},
+		{"final status", []error{&HTTPError{StatusCode: 404}}, []bool{false}},
+		{"too large", []error{ErrTooLarge}, []bool{false}},
+		{"permanent", []error{Permanent(os.ErrNotExist)}, []bool{false}},
+		// connection failures have their own budget
+		{"connect", []error{connectErr, errors.New("reset"), connectErr}, []bool{true, true, false}},
+	}
+
+	for _, testCase := range testCases {
+		var budget retryBudget
+		for i, err := range testCase.errs {
+			if received := budget.fail(d, err, 2); received != testCase.expected[i] {
+				t.Errorf("%s: attempt %d: expected %v received %v", testCase.name, i+1, testCase.expected[i], received)
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package massivedl
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/checksum"
+	"github.com/dimkouv/massivedl/internal/httputil"
+)
+
+// The errors of Result.Err, test for them with errors.Is
+var (
+	// ErrChecksumMismatch is returned when a file doesn't match the
+	// Checksum of its entry, also after the retries
+	ErrChecksumMismatch = checksum.ErrMismatch
+
+	// ErrTooLarge is returned for files bigger than WithMaxSize allows
+	ErrTooLarge = errors.New("file too large")
+
+	// ErrFiltered is returned for entries that the filter of WithFilter
+	// rejected, they aren't downloaded
+	ErrFiltered = errors.New("filtered out")
+
+	// ErrUnsupportedScheme is returned for urls that aren't http or https
+	// and whose scheme wasn't added with RegisterScheme
+	ErrUnsupportedScheme = errors.New("unsupported scheme")
+
+	// ErrCapped is returned for the entries of a host that reached the
+	// limits of WithHostCaps, they aren't downloaded
+	ErrCapped = errors.New("capped")
+
+	// ErrContentType is returned for responses whose Content-Type doesn't
+	// pass WithContentTypes
+	ErrContentType = errors.New("content type rejected")
+
+	// ErrRunFinished is returned by Add once the run is over
+	ErrRunFinished = errors.New("the run has finished")
+)
+
+// errStalled is returned for transfers that were slower than the speed of
+// WithMinSpeed for its whole window
+var errStalled = errors.New("transfer stalled")
+
+// errRemoteChanged is returned when the remote file changed since a part
+// file was started, which therefore has to be downloaded again
+var errRemoteChanged = errors.New("remote file changed, restarting")
+
+// errNotModified is returned for 304 answers to the conditional requests of
+// the Header hook
+var errNotModified = errors.New("not modified")
+
+// HTTPError is returned when the server answered with a status that is not a
+// success, test for it with errors.As
+type HTTPError struct {
+	StatusCode int
+	Status     string        // e.g. "404 Not Found"
+	RetryAfter time.Duration // requested by the server with Retry-After
+}
+
+func newHTTPError(response *http.Response) *HTTPError {
+	status := response.Status
+	if status == "" {
+		status = fmt.Sprintf("%d %s", response.StatusCode, http.StatusText(response.StatusCode))
+	}
+	return &HTTPError{
+		StatusCode: response.StatusCode,
+		Status:     status,
+		RetryAfter: httputil.RetryAfter(response.Header, time.Now()),
+	}
+}
+
+func (e *HTTPError) Error() string {
+	return "server answered " + e.Status
+}
+
+// Temporary reports whether the request may succeed when it is sent again,
+// which is the case for 429 Too Many Requests and 5xx statuses
+func (e *HTTPError) Temporary() bool {
+	return temporaryStatus(e.StatusCode)
+}
+
+// temporaryStatus reports whether status is 429 Too Many Requests or a 5xx
+// status, which are retried unless WithRetryOn says otherwise
+func temporaryStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError
+}
@@ -0,0 +1,186 @@
+package massivedl
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// progressEventInterval is the minimum time between two EntryProgress events
+// of the same entry
+const progressEventInterval = 250 * time.Millisecond
+
+// EventType tells what an Event reports
+type EventType int
+
+const (
+	// EntryStarted is sent when a worker starts the download of an entry.
+	// Entries that are skipped or filtered out aren't started.
+	EntryStarted EventType = iota
+
+	// EntryProgress is sent while the data of an entry is received, at most
+	// every 250ms per entry
+	EntryProgress
+
+	// EntryRetry is sent when an attempt failed and the entry is tried
+	// again, after RetryAfter
+	EntryRetry
+
+	// EntryFinished is sent with the Result of every entry
+	EntryFinished
+
+	// WorkerChanged is sent when a worker changes its State
+	WorkerChanged
+
+	// BatchFinished is sent once when the run is over, before Run or Wait
+	// return
+	BatchFinished
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EntryStarted:
+		return "EntryStarted"
+	case EntryProgress:
+		return "EntryProgress"
+	case EntryRetry:
+		return "EntryRetry"
+	case EntryFinished:
+		return "EntryFinished"
+	case WorkerChanged:
+		return "WorkerChanged"
+	case BatchFinished:
+		return "BatchFinished"
+	}
+	return "EventType(" + strconv.Itoa(int(t)) + ")"
+}
+
+// WorkerState tells what a worker is doing, see WorkerChanged
+type WorkerState int
+
+const (
+	WorkerWaiting     WorkerState = iota // for the next entry
+	WorkerPaused                         // until the Downloader is resumed
+	WorkerDownloading                    // the Entry of the event
+	WorkerDelay                          // the delay of WithDelay or WithStagger
+	WorkerStopped                        // for good
+)
+
+func (s WorkerState) String() string {
+	switch s {
+	case WorkerWaiting:
+		return "WorkerWaiting"
+	case WorkerPaused:
+		return "WorkerPaused"
+	case WorkerDownloading:
+		return "WorkerDownloading"
+	case WorkerDelay:
+		return "WorkerDelay"
+	case WorkerStopped:
+		return "WorkerStopped"
+	}
+	return "WorkerState(" + strconv.Itoa(int(s)) + ")"
+}
+
+// Event reports the progress of a run to the listeners of WithListener
+type Event struct {
+	Type EventType
+
+	// Entry is the entry the event is about, the zero Entry for
+	// BatchFinished and for WorkerChanged between downloads
+	Entry Entry
+
+	// Worker is the id of the worker that sent the event, -1 for results
+	// of entries that no worker took, like the filtered ones
+	Worker int
+
+	// Path is the path the entry is saved at, once it is known
+	Path string
+
+	// Bytes is the size of the part file of the entry for EntryProgress,
+	// including data of earlier attempts
+	Bytes int64
+
+	// Size is the size of the file for EntryProgress, -1 if the server
+	// didn't send it
+	Size int64
+
+	// Err, Duration and RetryAfter are set for EntryRetry: the error of
+	// the failed attempt, how long it took and how long the worker waits
+	// before the next one
+	Err        error
+	Duration   time.Duration
+	RetryAfter time.Duration
+
+	// State is set for WorkerChanged
+	State WorkerState
+
+	// Result is set for EntryFinished
+	Result *Result
+
+	// Progress holds the counters of the run at the time of the event
+	Progress Progress
+}
+
+// Listener receives the events of a run. Listeners are called by the workers
+// while they download, so they must be quick and safe for concurrent use.
+type Listener func(Event)
+
+// emit sends an event to the listeners
+func (d *Downloader) emit(event Event) {
+	if len(d.listeners) == 0 {
+		return
+	}
+	event.Progress = d.Progress()
+	for _, l := range d.listeners {
+		l(event)
+	}
+}
+
+// setState sends the WorkerChanged event of worker
+func (d *Downloader) setState(worker int, state WorkerState, entry Entry) {
+	d.emit(Event{Type: WorkerChanged, Entry: entry, Worker: worker, State: state})
+}
+
+// progressEmitter sends the EntryProgress events of an entry, the segments
+// of a download share it
+type progressEmitter struct {
+	d      *Downloader
+	entry  Entry
+	worker int
+	path   string
+
+	lock  sync.Mutex
+	bytes int64
+	size  int64
+	last  time.Time
+}
+
+func newProgressEmitter(d *Downloader, entry Entry, worker int, path string) *progressEmitter {
+	return &progressEmitter{d: d, entry: entry, worker: worker, path: path, size: unknownSize, last: time.Now()}
+}
+
+// reset sets the bytes of the part file and the size of the file once the
+// response of an attempt arrived
+func (e *progressEmitter) reset(bytes, size int64) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.bytes, e.size = bytes, size
+}
+
+// add counts n received bytes and sends an event if the last one is long
+// enough ago
+func (e *progressEmitter) add(n int64) {
+	e.lock.Lock()
+	e.bytes += n
+	if time.Since(e.last) < progressEventInterval {
+		e.lock.Unlock()
+		return
+	}
+	e.last = time.Now()
+	event := Event{Type: EntryProgress, Entry: e.entry, Worker: e.worker, Path: e.path, Bytes: e.bytes, Size: e.size}
+	e.lock.Unlock()
+
+	e.d.emit(event)
+}
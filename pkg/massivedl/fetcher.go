@@ -0,0 +1,241 @@
+package massivedl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Fetcher downloads the urls of a scheme that isn't built in, see
+// RegisterScheme. The Downloader still schedules, retries, verifies and
+// counts the downloads, the Fetcher only opens the data of a url.
+type Fetcher interface {
+	Fetch(ctx context.Context, req *FetchRequest) (*FetchResponse, error)
+}
+
+// FetcherFunc lets an ordinary function be used as a Fetcher
+type FetcherFunc func(ctx context.Context, req *FetchRequest) (*FetchResponse, error)
+
+// Fetch calls f(ctx, req)
+func (f FetcherFunc) Fetch(ctx context.Context, req *FetchRequest) (*FetchResponse, error) {
+	return f(ctx, req)
+}
+
+// FetchRequest asks a Fetcher for the data of an entry
+type FetchRequest struct {
+	Entry Entry
+	URL   *url.URL
+
+	// Header holds the headers of WithHeader and the Entry, and the
+	// User-Agent. Fetchers use them as far as their protocol has a use
+	// for them.
+	Header http.Header
+
+	// Offset is the size of the part file of an earlier attempt. Fetchers
+	// that can resume send the data from there, the others from the start.
+	Offset int64
+}
+
+// FetchResponse is the data a Fetcher opened
+type FetchResponse struct {
+	// Body is read to the end and closed by the Downloader
+	Body io.ReadCloser
+
+	// Offset is where Body starts in the file, either the Offset of the
+	// FetchRequest or 0
+	Offset int64
+
+	// Length is the number of bytes of Body, -1 if it isn't known
+	Length int64
+}
+
+var (
+	schemesLock sync.RWMutex
+	schemes     = map[string]Fetcher{}
+)
+
+// RegisterScheme makes all Downloaders fetch the urls of scheme, e.g.
+// "artifact" for artifact://store/name, with f. It is meant to be called from
+// init functions and panics if the scheme was registered before, if f is nil
+// or for http and https, whose client is set with WithHTTPClient.
+//
+// Errors of f are retried like failed requests, unless they are wrapped with
+// Permanent.
+func RegisterScheme(scheme string, f Fetcher) {
+	scheme = strings.ToLower(scheme)
+	if f == nil {
+		panic("massivedl: RegisterScheme fetcher is nil")
+	}
+	if scheme == "" || scheme == "http" || scheme == "https" {
+		panic("massivedl: RegisterScheme can't register scheme " + scheme)
+	}
+
+	schemesLock.Lock()
+	defer schemesLock.Unlock()
+	if _, ok := schemes[scheme]; ok {
+		panic("massivedl: RegisterScheme called twice for scheme " + scheme)
+	}
+	schemes[scheme] = f
+}
+
+// TransportFetcher returns a Fetcher that sends the requests of its scheme
+// with rt, like an http.RoundTripper registered with
+// http.Transport.RegisterProtocol. Unlike those of other Fetchers, its
+// responses reach the Downloader as rt returns them, with their status codes
+// and headers.
+func TransportFetcher(rt http.RoundTripper) Fetcher {
+	return transportFetcher{rt}
+}
+
+// transportFetcher is the Fetcher of TransportFetcher
+type transportFetcher struct {
+	rt http.RoundTripper
+}
+
+// Fetch sends a GET request for req with the transport, for programs that
+// call the Fetcher themselves
+func (f transportFetcher) Fetch(ctx context.Context, req *FetchRequest) (*FetchResponse, error) {
+	r, err := http.NewRequestWithContext(ctx, "GET", req.URL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	r.Header = req.Header.Clone()
+	if r.Header == nil {
+		r.Header = http.Header{}
+	}
+	if req.Offset > 0 {
+		r.Header.Set("Range", fmt.Sprintf("bytes=%d-", req.Offset))
+	}
+
+	response, err := f.rt.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case response.StatusCode == http.StatusOK:
+		return &FetchResponse{Body: response.Body, Length: response.ContentLength}, nil
+	case response.StatusCode == http.StatusPartialContent && req.Offset > 0:
+		return &FetchResponse{Body: response.Body, Offset: req.Offset, Length: response.ContentLength}, nil
+	}
+	_ = response.Body.Close()
+	if err = newHTTPError(response); !temporaryStatus(response.StatusCode) {
+		err = Permanent(err)
+	}
+	return nil, err
+}
+
+// schemeFetcher returns the Fetcher registered for scheme, nil if there is
+// none
+func schemeFetcher(scheme string) Fetcher {
+	schemesLock.RLock()
+	defer schemesLock.RUnlock()
+	return schemes[scheme]
+}
+
+// entryKey is the context key of the entry of a request
+type entryKey struct{}
+
+// withEntry returns ctx with the entry that its requests download, for the
+// FetchRequests of Fetchers
+func withEntry(ctx context.Context, entry Entry) context.Context {
+	return context.WithValue(ctx, entryKey{}, entry)
+}
+
+// send sends req with the Fetcher of its scheme or the http client
+func (d *Downloader) send(req *http.Request) (*http.Response, error) {
+	switch f := schemeFetcher(req.URL.Scheme).(type) {
+	case nil:
+	case transportFetcher:
+		return f.rt.RoundTrip(req)
+	default:
+		return fetch(f, req)
+	}
+	return d.client.Do(req)
+}
+
+// fetch answers a GET request with f like an http server would: with the
+// whole file, or from the offset of the Range of a resumed download on if f
+// resumed it. Other requests fail, the Downloader falls back to what works
+// without them.
+func fetch(f Fetcher, req *http.Request) (*http.Response, error) {
+	if req.Method != "GET" {
+		return nil, fmt.Errorf("%s requests aren't supported for %s urls", req.Method, req.URL.Scheme)
+	}
+
+	var offset int64
+	if r := req.Header.Get("Range"); r != "" {
+		var err error
+		if offset, err = strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(r, "bytes="), "-"), 10, 64); err != nil {
+			return nil, fmt.Errorf("range %q isn't supported for %s urls", r, req.URL.Scheme)
+		}
+	}
+
+	entry, _ := req.Context().Value(entryKey{}).(Entry)
+	fetched, err := f.Fetch(req.Context(), &FetchRequest{Entry: entry, URL: req.URL, Header: req.Header, Offset: offset})
+	if err != nil {
+		return nil, err
+	}
+
+	response := &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{},
+		Body:          fetched.Body,
+		ContentLength: fetched.Length,
+		Request:       req,
+	}
+	switch {
+	case fetched.Offset == 0:
+	case fetched.Offset != offset:
+		_ = fetched.Body.Close()
+		return nil, fmt.Errorf("fetcher resumed at %d instead of %d", fetched.Offset, offset)
+	case fetched.Length == 0:
+		// the part file is complete already
+		_ = fetched.Body.Close()
+		response.StatusCode, response.Body = http.StatusRequestedRangeNotSatisfiable, http.NoBody
+		response.Header.Set("Content-Range", fmt.Sprintf("bytes */%d", offset))
+	case fetched.Length < 0:
+		response.StatusCode = http.StatusPartialContent
+		response.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/*", offset, int64(math.MaxInt64)))
+	default:
+		response.StatusCode = http.StatusPartialContent
+		response.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, offset+fetched.Length-1, offset+fetched.Length))
+	}
+	response.Status = fmt.Sprintf("%d %s", response.StatusCode, http.StatusText(response.StatusCode))
+	return response, nil
+}
+
+// permanentError marks an error of a Fetcher that isn't retried
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// Permanent wraps an error of a Fetcher so that the download isn't retried,
+// e.g. because the file doesn't exist. errors.Is and errors.As still find
+// err in it.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// isPermanent reports whether err was wrapped with Permanent
+func isPermanent(err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent)
+}
@@ -0,0 +1,170 @@
+package massivedl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// brokenReader returns the data of r, then err
+type brokenReader struct {
+	r   io.Reader
+	err error
+}
+
+func (b *brokenReader) Read(p []byte) (int, error) {
+	n, err := b.r.Read(p)
+	if err == io.EOF {
+		err = b.err
+	}
+	return n, err
+}
+
+func TestRegisterScheme(t *testing.T) {
+	files := map[string]string{"a": "hello", "cut": "resumed"}
+	var lock sync.Mutex
+	requests := map[string][]int64{} // offsets requested per file
+
+	RegisterScheme("MEM", FetcherFunc(func(ctx context.Context, req *FetchRequest) (*FetchResponse, error) {
+		name := req.URL.Host
+		lock.Lock()
+		requests[name] = append(requests[name], req.Offset)
+		first := len(requests[name]) == 1
+		lock.Unlock()
+
+		content, ok := files[name]
+		if !ok {
+			return nil, Permanent(os.ErrNotExist)
+		}
+		if req.Header.Get("User-Agent") != "test" {
+			return nil, errors.New("expected the User-Agent in the request")
+		}
+		// the first transfer of cut breaks off after 3 bytes
+		if name == "cut" && first {
+			body := &brokenReader{r: strings.NewReader(content[:3]), err: io.ErrUnexpectedEOF}
+			return &FetchResponse{Body: ioutil.NopCloser(body), Length: int64(len(content))}, nil
+		}
+		body := strings.NewReader(content[req.Offset:])
+		return &FetchResponse{Body: ioutil.NopCloser(body), Offset: req.Offset, Length: int64(body.Len())}, nil
+	}))
+
+	dir, err := ioutil.TempDir("", "massivedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testCases := []struct {
+		entry    Entry
+		content  string
+		attempts int
+		expected error
+	}{
+		{Entry{URL: "mem://a", Path: "a"}, "hello", 1, nil},
+		{Entry{URL: "mem://cut", Path: "cut"}, "resumed", 2, nil},
+		{Entry{URL: "mem://missing", Path: "missing"}, "", 1, os.ErrNotExist},
+		{Entry{URL: "mem://a", Path: "badsum", Checksum: "md5:00000000000000000000000000000000"}, "", 3, ErrChecksumMismatch},
+	}
+
+	entries := make([]Entry, len(testCases))
+	for i, testCase := range testCases {
+		entries[i] = testCase.entry
+	}
+
+	d := New(WithOutputDir(dir), WithDelay(0), WithRetries(2), WithUserAgent("test"))
+	results, err := d.Run(context.Background(), entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, testCase := range testCases {
+		res := results[i]
+		if !errors.Is(res.Err, testCase.expected) || (testCase.expected == nil) != (res.Err == nil) {
+			t.Errorf("%s: expected %v received %v", testCase.entry.URL, testCase.expected, res.Err)
+		}
+		if res.Attempts != testCase.attempts {
+			t.Errorf("%s: expected %d attempts received %d", testCase.entry.URL, testCase.attempts, res.Attempts)
+		}
+		if testCase.expected != nil {
+			continue
+		}
+		b, err := ioutil.ReadFile(filepath.Join(dir, testCase.entry.Path))
+		if err != nil {
+			t.Error(err)
+		} else if string(b) != testCase.content {
+			t.Errorf("%s: expected %q received %q", testCase.entry.URL, testCase.content, b)
+		}
+	}
+
+	// the second attempt resumed the part file
+	if offsets := requests["cut"]; len(offsets) != 2 || offsets[1] != 3 {
+		t.Errorf("expected cut to be resumed at 3, received offsets %v", offsets)
+	}
+
+	for _, scheme := range []string{"mem", "https"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("%s: expected RegisterScheme to panic", scheme)
+				}
+			}()
+			RegisterScheme(scheme, FetcherFunc(nil))
+		}()
+	}
+}
+
+// roundTripperFunc lets an ordinary function be used as an http.RoundTripper
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestTransportFetcher(t *testing.T) {
+	server := testServer()
+	defer server.Close()
+	serverURL, _ := url.Parse(server.URL)
+
+	// the urls of the scheme are sent to the test server over http
+	RegisterScheme("relayed", TransportFetcher(roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		req = req.Clone(req.Context())
+		req.URL.Scheme, req.URL.Host = serverURL.Scheme, serverURL.Host
+		return http.DefaultTransport.RoundTrip(req)
+	})))
+
+	dir, err := ioutil.TempDir("", "massivedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	d := New(WithOutputDir(dir), WithDelay(0), WithRetries(2))
+	results, err := d.Run(context.Background(), []Entry{
+		{URL: "relayed://files/ok", Path: "ok"},
+		{URL: "relayed://files/missing", Path: "missing"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if res := results[0]; res.Err != nil || res.StatusCode != http.StatusOK {
+		t.Errorf("ok: expected status 200 received %d (%v)", res.StatusCode, res.Err)
+	}
+	if b, err := ioutil.ReadFile(filepath.Join(dir, "ok")); err != nil || string(b) != "hello" {
+		t.Errorf("ok: expected %q received %q (%v)", "hello", b, err)
+	}
+
+	// the status code of the response is kept, so the 404 isn't retried
+	var httpErr *HTTPError
+	if res := results[1]; !errors.As(res.Err, &httpErr) || httpErr.StatusCode != http.StatusNotFound || res.Attempts != 1 {
+		t.Errorf("missing: expected a 404 after 1 attempt received %v after %d", res.Err, res.Attempts)
+	}
+}
@@ -0,0 +1,52 @@
+package massivedl
+
+import "net/http"
+
+// Hooks let a program take part in the downloads of a Downloader, see
+// WithHooks. All of them are optional. They are called by the workers, so
+// they must be safe for concurrent use.
+type Hooks struct {
+	// Wait is called by a worker before it takes the next entry and blocks
+	// as long as the worker should wait, e.g. outside of the hours the
+	// downloads are allowed in
+	Wait func(worker int)
+
+	// Skip is called before the download of an entry that is saved at
+	// path. If it returns true the entry isn't downloaded and res is its
+	// result, e.g. for a file that an earlier run saved or for a url that
+	// is known to fail.
+	Skip func(entry Entry, path string) (res Result, skip bool)
+
+	// Place is called right before the download of an entry. It returns
+	// the path the entry is saved at instead of path, e.g. on the disk
+	// with the most free space, and a function that is called once the
+	// download is over.
+	Place func(worker int, entry Entry, path string) (string, func())
+
+	// Header changes the request headers of an entry. It is called once
+	// per download, after the User-Agent and the headers of WithHeader
+	// were set and before those of the entry are.
+	Header func(entry Entry, header http.Header)
+
+	// Reuse is called before the first request of an entry. If it returns
+	// true the file was put at path without a download, e.g. linked to an
+	// identical file, and err is the outcome.
+	Reuse func(entry Entry, path string) (reused bool, err error)
+
+	// Check is called for every complete attempt after the checksums were
+	// verified. An error fails the attempt, which is retried from the
+	// start like a failed request.
+	Check func(entry Entry, response *Response, partPath string, attempt int) error
+
+	// Save moves the complete and verified part file of an entry into
+	// place instead of the Downloader, and returns the path the file was
+	// saved at. The Response holds the url, status and headers of the
+	// last response. Errors fail the entry without a retry.
+	Save func(entry Entry, partPath, path string, response *Response) (string, error)
+
+	// Suspend is called for the errors of writing a file. If it returns
+	// true the download waits until the Downloader is resumed and repeats
+	// the attempt without counting it, e.g. when the disk is full and
+	// Suspend paused the Downloader until there is space again.
+	Suspend func(err error) bool
+}
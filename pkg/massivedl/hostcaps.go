@@ -0,0 +1,71 @@
+package massivedl
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/dimkouv/massivedl/internal/sizeutil"
+)
+
+// hostCounts counts what was downloaded from every host in a run for
+// WithHostCaps
+type hostCounts struct {
+	lock   sync.Mutex
+	files  map[string]int   // downloads started or done, the failed ones don't count
+	bytes  map[string]int64 // bytes received
+	logged map[string]bool  // hosts whose cap was logged
+}
+
+// takeHostCap counts the download of j against the caps of its host. If the
+// host reached one it returns the capped result of j instead. The downloads
+// that are running when the bytes cap is reached still finish, so it can be
+// exceeded by them.
+func (d *Downloader) takeHostCap(j job, path string) (Result, bool) {
+	if d.maxFiles == 0 && d.maxBytes == 0 {
+		return Result{}, false
+	}
+
+	host := strings.ToLower(j.url.Host)
+	c := &d.hostCounts
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.files == nil {
+		c.files, c.bytes, c.logged = map[string]int{}, map[string]int64{}, map[string]bool{}
+	}
+
+	var reason string
+	switch {
+	case d.maxFiles > 0 && c.files[host] >= d.maxFiles:
+		reason = fmt.Sprintf("%s reached the limit of %d files", host, d.maxFiles)
+	case d.maxBytes > 0 && c.bytes[host] >= d.maxBytes:
+		reason = fmt.Sprintf("%s reached the limit of %s", host, sizeutil.FormatSize(d.maxBytes))
+	default:
+		c.files[host]++
+		return Result{}, false
+	}
+
+	if !c.logged[host] {
+		c.logged[host] = true
+		d.logf("[CAP] %s, its remaining urls are capped", reason)
+	}
+	return Result{Entry: j.entry, Path: path, Err: fmt.Errorf("%w, %s", ErrCapped, reason)}, true
+}
+
+// countHostCap counts the result of a download that takeHostCap let through
+func (d *Downloader) countHostCap(j job, res Result) {
+	if d.maxFiles == 0 && d.maxBytes == 0 {
+		return
+	}
+
+	host := strings.ToLower(j.url.Host)
+	c := &d.hostCounts
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if res.Err != nil {
+		c.files[host]--
+	}
+	c.bytes[host] += res.Bytes
+}
@@ -0,0 +1,20 @@
+package massivedl
+
+import (
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/ratelimit"
+)
+
+// fairShareSlice is how often WithFairShare rebalances the shares of the jobs
+const fairShareSlice = time.Second
+
+// jobLimiter returns the limiter of the downloads of job and a function to
+// call once the download is over. Without WithFairShare all downloads share
+// the limiter of WithRateLimit.
+func (d *Downloader) jobLimiter(job string) (*ratelimit.Limiter, func()) {
+	if d.fair == nil {
+		return d.limiter, func() {}
+	}
+	return d.fair.Start(job, d.fairShare(job)), func() { d.fair.Done(job) }
+}
@@ -0,0 +1,315 @@
+// Package massivedl downloads lists of files in parallel. It is the engine of
+// the massivedl command, for programs that embed the downloader instead of
+// running the command:
+//
+//	d := massivedl.New(massivedl.WithWorkers(8), massivedl.WithOutputDir("downloads"))
+//	results, err := d.Run(ctx, []massivedl.Entry{{URL: "https://example.com/a.zip"}})
+//
+// Every entry is downloaded into a part file that is moved into place once it
+// is complete and matches the checksum of the entry, if it has one. Failed
+// attempts are retried, a part file left behind by one of them is resumed.
+//
+// Run downloads a fixed list. Programs that keep adding entries while the
+// others are downloaded use Start, Add, Feed, Close and Wait instead.
+package massivedl
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/checksum"
+	"github.com/dimkouv/massivedl/internal/ratelimit"
+	"github.com/dimkouv/massivedl/internal/statistics"
+)
+
+// The defaults of New, which are those of the massivedl command
+const (
+	DefaultWorkers        = 20
+	DefaultMaxWorkers     = 256
+	DefaultRetries        = 3
+	DefaultConnectRetries = 1
+	DefaultDelay          = time.Second
+	DefaultOutputDir      = "downloads"
+	DefaultMinSpeedTime   = 30 * time.Second
+
+	// DefaultUserAgent is the User-Agent of a browser followed by massivedl,
+	// some servers refuse clients they don't know
+	DefaultUserAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_6) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/14.0.2 Safari/605.1.15 massivedl"
+)
+
+// Entry is a file to download
+type Entry struct {
+	// URL is the http or https url of the file, or one of a scheme added
+	// with RegisterScheme
+	URL string
+
+	// Mirrors are other urls of the same file. Every failed attempt moves
+	// on to the next url, and every url is tried at least once.
+	Mirrors []string
+
+	// Path is where the file is saved, relative to the output directory.
+	// The Namer of WithNamer names the entry if it is empty.
+	Path string
+
+	// Checksum is the expected digest of the file, e.g. "sha256:9f86d0...",
+	// the download fails if it doesn't match. Optional.
+	Checksum string
+
+	// Header holds extra request headers for this entry
+	Header http.Header
+
+	// Metadata is passed on untouched in the Result and the events of the
+	// entry, e.g. the ids a program correlates the downloads with
+	Metadata map[string]string
+
+	// Data is passed on untouched like Metadata, e.g. the record a program
+	// keeps of the entry
+	Data interface{}
+
+	// Priority orders the entries, higher ones are downloaded first
+	Priority int
+
+	// Job names the batch the entry belongs to. With WithFairShare the
+	// jobs take turns for the workers and share the rate limit.
+	Job string
+
+	// Timeout, MaxSize and Retries replace WithMaxTime, WithMaxSize and
+	// WithRetries for this entry, their zero values keep them
+	Timeout time.Duration
+	MaxSize int64
+	Retries *int
+}
+
+// Result is the outcome of the download of an Entry
+type Result struct {
+	Entry Entry
+
+	// Path is where the file was saved, including the output directory.
+	// It is empty with WithMemory.
+	Path string
+
+	// Skipped is true if the file already existed and wasn't downloaded
+	Skipped bool
+
+	// NotModified is true if the server answered a conditional request of
+	// the Header hook with 304 Not Modified, the file wasn't downloaded
+	NotModified bool
+
+	// StatusCode is the status of the last response, 0 if none was received
+	StatusCode int
+
+	// Bytes is the number of bytes received in all attempts
+	Bytes int64
+
+	// Attempts is the number of requests that were sent
+	Attempts int
+
+	// Duration is the time the download took, retries included
+	Duration time.Duration
+
+	// WriteTime is the part of Duration that was spent writing the data
+	// into files
+	WriteTime time.Duration
+
+	// Err is nil if the file was downloaded or skipped. It wraps one of the
+	// errors of errors.go or an *HTTPError where they apply.
+	Err error
+}
+
+// Progress holds the counters of a running Downloader
+type Progress struct {
+	Total      int   // entries added to the run
+	Downloaded int   // entries downloaded or skipped
+	Failed     int   // entries that failed for good, the capped ones included
+	Capped     int   // entries that failed with ErrCapped
+	Bytes      int64 // bytes received so far
+}
+
+// Downloader downloads entries with a number of parallel workers. A
+// Downloader may be used for several runs, but not for concurrent ones.
+type Downloader struct {
+	workers        int
+	maxWorkers     int
+	retries        int
+	connectRetries int
+	retryOn        func(status int) bool
+	delay          time.Duration
+	stagger        bool
+	maxPerHost     int
+	delayPerHost   time.Duration
+	hostLimit      func(host string) (int, time.Duration)
+	maxFiles       int   // per host, see WithHostCaps
+	maxBytes       int64 // per host, see WithHostCaps
+	outputDir      string
+	skipExisting   bool
+	keepPartial    bool
+	userAgent      string
+	header         http.Header
+	client         *http.Client
+	maxSize        int64
+	maxTime        time.Duration
+	minSpeed       int64
+	minSpeedTime   time.Duration
+	rate           int64
+	connRate       int64
+	fairShare      func(job string) float64
+	segments       int
+	segmentMinSize int64
+	memoryBelow    int64
+	digestHeaders  bool
+	checksumFail   string
+	acceptTypes    []string
+	rejectTypes    []string
+	fastestMirror  bool
+	filter         func(Entry) bool
+	namer          Namer
+	listeners      []Listener
+	memory         MemoryHandler
+	hooks          Hooks
+	stats          *statistics.Statistics
+	route          func(Entry) string
+	logger         *log.Logger
+
+	lock       sync.Mutex
+	run        *run // nil before the first Start
+	paused     chan struct{}
+	pausedOn   bool
+	limiter    *ratelimit.Limiter
+	fair       *ratelimit.FairShare
+	hostCounts hostCounts
+
+	total, downloaded, failed, capped, bytes int64 // counters of Progress
+}
+
+// New returns a Downloader with the defaults of the massivedl command,
+// changed by opts
+func New(opts ...Option) *Downloader {
+	d := &Downloader{
+		workers:        DefaultWorkers,
+		maxWorkers:     DefaultMaxWorkers,
+		retries:        DefaultRetries,
+		connectRetries: DefaultConnectRetries,
+		retryOn:        temporaryStatus,
+		delay:          DefaultDelay,
+		outputDir:      DefaultOutputDir,
+		skipExisting:   true,
+		keepPartial:    true,
+		userAgent:      DefaultUserAgent,
+		client:         http.DefaultClient,
+		minSpeedTime:   DefaultMinSpeedTime,
+		segments:       1,
+		digestHeaders:  true,
+		checksumFail:   ChecksumFailDelete,
+		namer:          basenameNamer,
+		paused:         make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	close(d.paused) // not paused
+	return d
+}
+
+// Progress returns the counters of the current or last run
+func (d *Downloader) Progress() Progress {
+	return Progress{
+		Total:      int(atomic.LoadInt64(&d.total)),
+		Downloaded: int(atomic.LoadInt64(&d.downloaded)),
+		Failed:     int(atomic.LoadInt64(&d.failed)),
+		Capped:     int(atomic.LoadInt64(&d.capped)),
+		Bytes:      atomic.LoadInt64(&d.bytes),
+	}
+}
+
+// job is an entry that is about to be downloaded
+type job struct {
+	index    int // in the order the entries were added to the run
+	entry    Entry
+	url      *url.URL
+	urls     []string // url and the mirrors
+	path     string   // empty until the download if the Namer needs the data
+	checksum checksum.Checksum
+}
+
+// newJob checks entry and finds the path it is saved under
+func (d *Downloader) newJob(index int, entry Entry) (job, error) {
+	u, err := url.Parse(entry.URL)
+	if err != nil {
+		return job{}, err
+	}
+	urls := append([]string{entry.URL}, entry.Mirrors...)
+	for _, raw := range urls {
+		if err = checkScheme(raw); err != nil {
+			return job{}, err
+		}
+	}
+	if d.filter != nil && !d.filter(entry) {
+		return job{}, ErrFiltered
+	}
+
+	j := job{index: index, entry: entry, url: u, urls: urls}
+	if entry.Checksum != "" {
+		if j.checksum, err = checksum.Parse(entry.Checksum); err != nil {
+			return job{}, err
+		}
+	}
+
+	name := entry.Path
+	if name == "" {
+		name, err = d.namer.Name(entry, &Response{Index: index})
+		if errors.Is(err, ErrNameAfterDownload) {
+			return j, nil
+		}
+		if err != nil {
+			return job{}, err
+		}
+	}
+	if j.path, err = d.outputPath(name); err != nil {
+		return job{}, err
+	}
+
+	return j, nil
+}
+
+// checkScheme returns ErrUnsupportedScheme for urls that are neither http
+// nor https and whose scheme wasn't added with RegisterScheme
+func checkScheme(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" && schemeFetcher(u.Scheme) == nil {
+		return &url.Error{Op: "parse", URL: raw, Err: ErrUnsupportedScheme}
+	}
+	return nil
+}
+
+// outputPath returns the path of the file name in the output directory,
+// which it never leaves
+func (d *Downloader) outputPath(name string) (string, error) {
+	name = path.Clean("/" + name)
+	if name == "/" {
+		return "", errors.New("empty file name")
+	}
+	return path.Join(d.outputDir, name), nil
+}
+
+// logln writes a line to the logger of WithLogger, if there is one
+func (d *Downloader) logln(v ...interface{}) {
+	if d.logger != nil {
+		d.logger.Println(v...)
+	}
+}
+
+// logf is logln with a format
+func (d *Downloader) logf(format string, v ...interface{}) {
+	if d.logger != nil {
+		d.logger.Printf(format, v...)
+	}
+}
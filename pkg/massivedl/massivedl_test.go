@@ -0,0 +1,276 @@
+package massivedl
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testServer serves /ok, /flaky (fails the first request), /large (100
+// bytes), /drip (sends its 10 bytes in two halves 300ms apart), /missing and
+// /slow (never answers before the request is canceled)
+func testServer() *httptest.Server {
+	var lock sync.Mutex
+	flaky := 0
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok", "/dir/ok":
+			_, _ = w.Write([]byte("hello"))
+		case "/flaky":
+			lock.Lock()
+			flaky++
+			n := flaky
+			lock.Unlock()
+			if n == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			_, _ = w.Write([]byte("flaky"))
+		case "/large":
+			_, _ = w.Write(make([]byte, 100))
+		case "/drip":
+			w.Header().Set("Content-Length", "10")
+			_, _ = w.Write([]byte("drip-"))
+			w.(http.Flusher).Flush()
+			time.Sleep(300 * time.Millisecond)
+			_, _ = w.Write([]byte("drop-"))
+		case "/slow":
+			<-r.Context().Done()
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+}
+
+func TestRun(t *testing.T) {
+	server := testServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "massivedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testCases := []struct {
+		entry    Entry
+		path     string
+		content  string
+		attempts int
+		failed   bool
+	}{
+		{Entry{URL: server.URL + "/ok", Metadata: map[string]string{"id": "1"}}, "ok", "hello", 1, false},
+		{Entry{URL: server.URL + "/dir/ok", Path: "sub/renamed"}, "sub/renamed", "hello", 1, false},
+		{Entry{URL: server.URL + "/flaky"}, "flaky", "flaky", 2, false},
+		{Entry{URL: server.URL + "/ok", Path: "sum", Checksum: "md5:5d41402abc4b2a76b9719d911017c592"}, "sum", "hello", 1, false},
+		{Entry{URL: server.URL + "/ok", Path: "badsum", Checksum: "md5:00000000000000000000000000000000"}, "badsum", "", 2, true},
+		{Entry{URL: server.URL + "/missing"}, "missing", "", 1, true},
+		{Entry{URL: "ftp://example.com/a"}, "", "", 0, true},
+	}
+
+	entries := make([]Entry, len(testCases))
+	for i, testCase := range testCases {
+		entries[i] = testCase.entry
+	}
+
+	d := New(WithOutputDir(dir), WithDelay(0), WithWorkers(3), WithRetries(1))
+	results, err := d.Run(context.Background(), entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	failed := 0
+	for i, testCase := range testCases {
+		res := results[i]
+		if res.Entry.Metadata["id"] != testCase.entry.Metadata["id"] {
+			t.Errorf("%s: expected metadata %v received %v", testCase.entry.URL, testCase.entry.Metadata, res.Entry.Metadata)
+		}
+		if (res.Err != nil) != testCase.failed {
+			t.Errorf("%s: expected failed=%v received %v", testCase.entry.URL, testCase.failed, res.Err)
+		}
+		if res.Attempts != testCase.attempts {
+			t.Errorf("%s: expected %d attempts received %d", testCase.entry.URL, testCase.attempts, res.Attempts)
+		}
+		if testCase.failed {
+			failed++
+			continue
+		}
+		if expected := filepath.Join(dir, testCase.path); res.Path != expected {
+			t.Errorf("%s: expected path %s received %s", testCase.entry.URL, expected, res.Path)
+		}
+		b, err := ioutil.ReadFile(res.Path)
+		if err != nil {
+			t.Error(err)
+		} else if string(b) != testCase.content {
+			t.Errorf("%s: expected %q received %q", testCase.entry.URL, testCase.content, b)
+		}
+	}
+
+	progress := d.Progress()
+	if progress.Total != len(testCases) || progress.Failed != failed || progress.Downloaded != len(testCases)-failed {
+		t.Errorf("unexpected progress %+v", progress)
+	}
+
+	// existing files are skipped
+	results, err = d.Run(context.Background(), entries[:1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !results[0].Skipped || results[0].Attempts != 0 {
+		t.Errorf("expected the existing file to be skipped, received %+v", results[0])
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	server := testServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "massivedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	entries := []Entry{
+		{URL: server.URL + "/slow"},
+		{URL: server.URL + "/ok"},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	results, err := New(WithOutputDir(dir), WithDelay(0), WithWorkers(1)).Run(ctx, entries)
+	if err != context.DeadlineExceeded {
+		t.Errorf("expected %v received %v", context.DeadlineExceeded, err)
+	}
+	for i, res := range results {
+		if res.Err == nil {
+			t.Errorf("%d: expected an error", i)
+		}
+	}
+	if results[1].Attempts != 0 {
+		t.Errorf("expected the second entry not to be started, received %+v", results[1])
+	}
+}
+
+func TestErrors(t *testing.T) {
+	server := testServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "massivedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testCases := []struct {
+		entry    Entry
+		expected error
+		status   int
+	}{
+		{Entry{URL: server.URL + "/ok", Path: "badsum", Checksum: "md5:00000000000000000000000000000000"}, ErrChecksumMismatch, 0},
+		{Entry{URL: server.URL + "/large"}, ErrTooLarge, 0},
+		{Entry{URL: server.URL + "/ok", Path: "filtered.tmp"}, ErrFiltered, 0},
+		{Entry{URL: "ftp://example.com/a"}, ErrUnsupportedScheme, 0},
+		{Entry{URL: server.URL + "/missing"}, nil, http.StatusNotFound},
+	}
+
+	entries := make([]Entry, len(testCases))
+	for i, testCase := range testCases {
+		entries[i] = testCase.entry
+	}
+
+	d := New(
+		WithOutputDir(dir),
+		WithDelay(0),
+		WithRetries(0),
+		WithMaxSize(10),
+		WithFilter(func(entry Entry) bool { return filepath.Ext(entry.Path) != ".tmp" }),
+	)
+	results, err := d.Run(context.Background(), entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, testCase := range testCases {
+		err := results[i].Err
+		if testCase.expected != nil && !errors.Is(err, testCase.expected) {
+			t.Errorf("%s: expected %v received %v", testCase.entry.URL, testCase.expected, err)
+		}
+		var httpErr *HTTPError
+		if testCase.status != 0 && (!errors.As(err, &httpErr) || httpErr.StatusCode != testCase.status) {
+			t.Errorf("%s: expected status %d received %v", testCase.entry.URL, testCase.status, err)
+		}
+	}
+
+	// nothing is left of the files that were too large
+	if _, err = os.Stat(filepath.Join(dir, "large.part")); !os.IsNotExist(err) {
+		t.Errorf("expected the part file to be removed, received %v", err)
+	}
+}
+
+func TestListener(t *testing.T) {
+	server := testServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "massivedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var lock sync.Mutex
+	var events []Event
+	d := New(WithOutputDir(dir), WithDelay(0), WithRetries(1), WithListener(func(event Event) {
+		lock.Lock()
+		defer lock.Unlock()
+		events = append(events, event)
+	}))
+
+	entries := []Entry{
+		{URL: server.URL + "/drip"},
+		{URL: server.URL + "/flaky"},
+		{URL: server.URL + "/missing"},
+		{URL: "ftp://example.com/a"},
+	}
+	if _, err = d.Run(context.Background(), entries); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := map[EventType]int{}
+	for _, event := range events {
+		counts[event.Type]++
+		// the second half arrives after 300ms
+		if event.Type == EntryProgress && (event.Bytes != 10 || event.Size != 10) {
+			t.Errorf("expected progress 10 of 10 bytes received %d of %d", event.Bytes, event.Size)
+		}
+		if event.Type == EntryFinished && event.Result == nil {
+			t.Error("expected a result in EntryFinished")
+		}
+		if event.Type == EntryRetry && !errors.As(event.Err, new(*HTTPError)) {
+			t.Errorf("expected the 503 of the failed attempt in EntryRetry received %v", event.Err)
+		}
+	}
+
+	// the 404 isn't retried, the workers are waiting, downloading and
+	// stopped at least once
+	expected := map[EventType]int{EntryStarted: 3, EntryProgress: 1, EntryRetry: 1, EntryFinished: 4, BatchFinished: 1}
+	for eventType, n := range expected {
+		if counts[eventType] != n {
+			t.Errorf("%s: expected %d events received %d", eventType, n, counts[eventType])
+		}
+	}
+	if counts[WorkerChanged] < 3 {
+		t.Errorf("expected at least 3 WorkerChanged events received %d", counts[WorkerChanged])
+	}
+	if last := events[len(events)-1]; last.Type != BatchFinished || last.Progress.Downloaded != 2 || last.Progress.Failed != 2 {
+		t.Errorf("expected BatchFinished with 2 downloads and 2 failures last, received %+v", last)
+	}
+}
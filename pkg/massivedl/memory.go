@@ -0,0 +1,109 @@
+package massivedl
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// MemoryHandler receives the data of an entry that was downloaded with
+// WithMemory. data is complete and matches the Checksum of the entry, it is
+// not used by the Downloader afterwards. An error fails the entry, it isn't
+// retried.
+type MemoryHandler func(entry Entry, data []byte) error
+
+// downloadMemory downloads the job of t into memory and passes it to the
+// MemoryHandler, retrying failed attempts like download does. Every attempt
+// starts from the beginning.
+func (d *Downloader) downloadMemory(runCtx context.Context, t *transfer, maxTime time.Duration, res *Result) {
+	j := t.job
+	t.header = d.requestHeader(j)
+	urls := j.urls
+	if d.fastestMirror && len(urls) > 1 {
+		urls = d.fastestFirst(runCtx, urls, t.header)
+	}
+	var budget retryBudget
+
+	// the time limit covers all attempts
+	ctx, cancel := context.WithCancel(runCtx)
+	if maxTime > 0 {
+		ctx, cancel = context.WithTimeout(runCtx, maxTime)
+	}
+	defer cancel()
+
+	for tries := 0; ; tries++ {
+		attemptStart := time.Now()
+		url := urls[tries%len(urls)]
+		data, status, err := d.fetchMemory(ctx, t, url)
+		res.Attempts++
+		res.Bytes += int64(len(data))
+		res.StatusCode = status
+
+		if err == nil && !j.checksum.IsZero() {
+			err = j.checksum.Verify(data)
+		}
+		if err == nil {
+			res.Err = d.memory(j.entry, data)
+			return
+		}
+
+		res.Err = err
+		if runCtx.Err() != nil {
+			return
+		}
+		if ctx.Err() != nil {
+			d.logln("[MAX TIME]", url, err)
+			res.Err = fmt.Errorf("%w: exceeded the time limit of %s", err, maxTime)
+			return
+		}
+		d.logln("[RETRY]", tries, url, err)
+		if !budget.fail(d, err, t.retries) && tries >= len(urls)-1 {
+			return
+		}
+
+		wait := retryAfter(err)
+		d.emit(Event{Type: EntryRetry, Entry: j.entry, Worker: t.worker, Err: err, Duration: time.Since(attemptStart), RetryAfter: wait})
+		if wait > 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(wait):
+			}
+		}
+	}
+}
+
+// fetchMemory reads the whole data of url
+func (d *Downloader) fetchMemory(ctx context.Context, t *transfer, url string) ([]byte, int, error) {
+	req, err := d.newRequest(ctx, "GET", url, t)
+	if err != nil {
+		return nil, 0, err
+	}
+	response, err := d.send(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return nil, response.StatusCode, newHTTPError(response)
+	}
+	if t.maxSize > 0 && response.ContentLength > t.maxSize {
+		return nil, response.StatusCode, fmt.Errorf("%w: %d bytes", ErrTooLarge, response.ContentLength)
+	}
+
+	t.progress.reset(0, response.ContentLength)
+	var body io.Reader = response.Body
+	if t.maxSize > 0 {
+		// one byte more tells files that are too large apart
+		body = io.LimitReader(body, t.maxSize+1)
+	}
+	var buf bytes.Buffer
+	_, err = io.Copy(io.MultiWriter(&buf, progressWriter{d, t.progress}), body)
+	if err == nil && t.maxSize > 0 && int64(buf.Len()) > t.maxSize {
+		err = fmt.Errorf("%w: more than %d bytes", ErrTooLarge, t.maxSize)
+	}
+	return buf.Bytes(), response.StatusCode, err
+}
@@ -0,0 +1,93 @@
+package massivedl
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestWithMemory(t *testing.T) {
+	server := testServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "massivedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	errRejected := errors.New("rejected")
+	var lock sync.Mutex
+	received := map[string]string{}
+	handle := func(entry Entry, data []byte) error {
+		if entry.Path == "reject" {
+			return errRejected
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		received[entry.URL] = string(data)
+		return nil
+	}
+
+	testCases := []struct {
+		entry    Entry
+		content  string
+		attempts int
+		expected error
+	}{
+		{Entry{URL: server.URL + "/ok"}, "hello", 1, nil},
+		{Entry{URL: server.URL + "/flaky"}, "flaky", 2, nil},
+		{Entry{URL: server.URL + "/drip", Checksum: "md5:00000000000000000000000000000000"}, "", 2, ErrChecksumMismatch},
+		{Entry{URL: server.URL + "/large"}, "", 1, ErrTooLarge},
+		{Entry{URL: server.URL + "/dir/ok", Path: "reject"}, "", 1, errRejected},
+	}
+
+	entries := make([]Entry, len(testCases))
+	for i, testCase := range testCases {
+		entries[i] = testCase.entry
+	}
+
+	// the failed attempts are retried like those of files
+	retries := 0
+	listener := func(event Event) {
+		if event.Type == EntryRetry {
+			lock.Lock()
+			defer lock.Unlock()
+			retries++
+		}
+	}
+
+	d := New(WithOutputDir(dir), WithDelay(0), WithRetries(1), WithMaxSize(10), WithMemory(handle), WithListener(listener))
+	results, err := d.Run(context.Background(), entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, testCase := range testCases {
+		res := results[i]
+		if !errors.Is(res.Err, testCase.expected) || (testCase.expected == nil) != (res.Err == nil) {
+			t.Errorf("%s: expected %v received %v", testCase.entry.URL, testCase.expected, res.Err)
+		}
+		if res.Attempts != testCase.attempts {
+			t.Errorf("%s: expected %d attempts received %d", testCase.entry.URL, testCase.attempts, res.Attempts)
+		}
+		if res.Path != "" {
+			t.Errorf("%s: expected no path received %s", testCase.entry.URL, res.Path)
+		}
+		if received[testCase.entry.URL] != testCase.content {
+			t.Errorf("%s: expected %q received %q", testCase.entry.URL, testCase.content, received[testCase.entry.URL])
+		}
+	}
+
+	if retries != 2 {
+		t.Errorf("expected 2 EntryRetry events received %d", retries)
+	}
+
+	// nothing was written to the output directory
+	if files, err := ioutil.ReadDir(dir); err != nil || len(files) != 0 {
+		t.Errorf("expected an empty output directory, received %d files (%v)", len(files), err)
+	}
+}
@@ -0,0 +1,65 @@
+package massivedl
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// mirrorProbeTimeout is how long the fastest mirror selection waits for answers
+const mirrorProbeTimeout = 10 * time.Second
+
+// fastestFirst sends a HEAD request to all urls at once and sorts them by
+// their response time. Urls that fail or do not answer in time keep their
+// order at the end of the list.
+func (d *Downloader) fastestFirst(ctx context.Context, urls []string, header http.Header) []string {
+	latencies := make([]time.Duration, len(urls))
+	ctx, cancel := context.WithTimeout(ctx, mirrorProbeTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := range urls {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			latencies[i] = mirrorProbeTimeout
+			req, err := http.NewRequestWithContext(ctx, "HEAD", urls[i], nil)
+			if err != nil {
+				return
+			}
+			req.Header = header.Clone()
+
+			start := time.Now()
+			response, err := d.send(req)
+			if err != nil {
+				return
+			}
+			if err = response.Body.Close(); err != nil {
+				d.logf("error closing response body: %v", err)
+			}
+			if response.StatusCode < 400 {
+				latencies[i] = time.Since(start)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	order := make([]int, len(urls))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool { return latencies[order[a]] < latencies[order[b]] })
+
+	sorted := make([]string, len(urls))
+	for i, j := range order {
+		sorted[i] = urls[j]
+	}
+	if latencies[order[0]] < mirrorProbeTimeout {
+		d.logln("[MIRROR]", "fastest", sorted[0], latencies[order[0]])
+	}
+
+	return sorted
+}
@@ -0,0 +1,203 @@
+package massivedl
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/nametemplate"
+)
+
+// ErrNameAfterDownload is returned by a Namer that can't name an entry
+// before its data was downloaded, e.g. after the checksum of the data. The
+// Namer is asked again once the download is complete. Only a Namer that
+// declares it with an AfterDownload method returns it, see NamesAfterDownload.
+var ErrNameAfterDownload = errors.New("named after the download")
+
+// Namer chooses the path an entry without a Path is saved under, relative to
+// the output directory, see WithNamer. The path never leaves the output
+// directory, ".." elements are dropped.
+type Namer interface {
+	Name(entry Entry, response *Response) (string, error)
+}
+
+// NamerFunc lets an ordinary function be used as a Namer
+type NamerFunc func(entry Entry, response *Response) (string, error)
+
+// Name calls f(entry, response)
+func (f NamerFunc) Name(entry Entry, response *Response) (string, error) {
+	return f(entry, response)
+}
+
+// Response is what a Namer knows about an entry. Before the download only
+// Index is set, the Namer may return ErrNameAfterDownload to be asked again
+// with the rest once the data is complete and verified.
+type Response struct {
+	// Index is the position of the entry in the entries passed to Run
+	Index int
+
+	// Downloaded is true once the data was downloaded and the fields below
+	// are set
+	Downloaded bool
+
+	// URL is the url the data came from, after redirects
+	URL *url.URL
+
+	// StatusCode and Header are those of the last response, 0 and nil for
+	// Fetchers
+	StatusCode int
+	Header     http.Header
+
+	// Checksum is the sha256 checksum of the data, e.g. "sha256:9f86d0..."
+	Checksum string
+}
+
+// AfterDownload is implemented by the Namers that name entries once their
+// data was downloaded, like content-hash. NamesAfterDownload(true) declares
+// that they return ErrNameAfterDownload before the download, for every
+// entry.
+type AfterDownload interface {
+	NamesAfterDownload() bool
+}
+
+// NamerFactory returns a Namer of a strategy. arg is what follows the colon
+// of a spec like "template:{host}/{path}", empty without one.
+type NamerFactory func(arg string) (Namer, error)
+
+var (
+	namersLock sync.RWMutex
+	namers     = map[string]NamerFactory{
+		"basename":      noArg("basename", basenameNamer),
+		"preserve-path": noArg("preserve-path", mustTemplateNamer("{path}")),
+		"content-hash":  noArg("content-hash", contentHashNamer{}),
+		"template":      templateNamer,
+	}
+)
+
+// RegisterNamer adds a strategy to the ones NewNamer knows, besides the
+// built-in basename, preserve-path, content-hash and template. It is meant
+// to be called from init functions and panics if the strategy exists already
+// or if f is nil.
+func RegisterNamer(strategy string, f NamerFactory) {
+	if f == nil {
+		panic("massivedl: RegisterNamer factory is nil")
+	}
+	if strategy == "" || strings.Contains(strategy, ":") {
+		panic("massivedl: RegisterNamer can't register strategy " + strategy)
+	}
+
+	namersLock.Lock()
+	defer namersLock.Unlock()
+	if _, ok := namers[strategy]; ok {
+		panic("massivedl: RegisterNamer called twice for strategy " + strategy)
+	}
+	namers[strategy] = f
+}
+
+// NewNamer returns the Namer of spec, a strategy optionally followed by a
+// colon and its argument:
+//
+//	basename                 the last element of the url path (the default)
+//	preserve-path            the url path, e.g. a/b/c.zip
+//	content-hash             the sha256 checksum of the data and the extension of the url
+//	template:{host}/{path}   a template with the placeholders of -name-template
+func NewNamer(spec string) (Namer, error) {
+	strategy, arg := spec, ""
+	if i := strings.Index(spec, ":"); i >= 0 {
+		strategy, arg = spec[:i], spec[i+1:]
+	}
+
+	namersLock.RLock()
+	f, ok := namers[strategy]
+	namersLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown naming strategy %q (known: %s)", strategy, strings.Join(NamerStrategies(), ", "))
+	}
+	return f(arg)
+}
+
+// NamerStrategies returns the strategies NewNamer knows, sorted
+func NamerStrategies() []string {
+	namersLock.RLock()
+	defer namersLock.RUnlock()
+
+	strategies := make([]string, 0, len(namers))
+	for strategy := range namers {
+		strategies = append(strategies, strategy)
+	}
+	sort.Strings(strategies)
+	return strategies
+}
+
+// NamesAfterDownload reports whether n names entries only once their data was
+// downloaded, like content-hash does. Namers declare it by implementing
+// AfterDownload, n isn't called.
+func NamesAfterDownload(n Namer) bool {
+	a, ok := n.(AfterDownload)
+	return ok && a.NamesAfterDownload()
+}
+
+// noArg returns a factory of n for a strategy without an argument
+func noArg(strategy string, n Namer) NamerFactory {
+	return func(arg string) (Namer, error) {
+		if arg != "" {
+			return nil, fmt.Errorf("naming strategy %s takes no argument", strategy)
+		}
+		return n, nil
+	}
+}
+
+// basenameNamer names an entry after the last element of its url path
+var basenameNamer = mustTemplateNamer("{basename}")
+
+// contentHashNamer names an entry after the checksum of its data, so that
+// identical files get the same name
+type contentHashNamer struct{}
+
+// NamesAfterDownload implements AfterDownload
+func (contentHashNamer) NamesAfterDownload() bool {
+	return true
+}
+
+// Name implements Namer
+func (contentHashNamer) Name(entry Entry, response *Response) (string, error) {
+	if !response.Downloaded {
+		return "", ErrNameAfterDownload
+	}
+	u, err := url.Parse(entry.URL)
+	if err != nil {
+		return "", err
+	}
+	sum := response.Checksum[strings.Index(response.Checksum, ":")+1:]
+	return sum + path.Ext(path.Base(u.Path)), nil
+}
+
+// templateNamer is the factory of the template strategy
+func templateNamer(arg string) (Namer, error) {
+	tmpl, err := nametemplate.Parse(arg)
+	if err != nil {
+		return nil, err
+	}
+	return NamerFunc(func(entry Entry, response *Response) (string, error) {
+		u, err := url.Parse(entry.URL)
+		if err != nil {
+			return "", err
+		}
+		return tmpl.Execute(u, response.Index, time.Now()), nil
+	}), nil
+}
+
+// mustTemplateNamer returns the Namer of a built-in template
+func mustTemplateNamer(raw string) Namer {
+	n, err := templateNamer(raw)
+	if err != nil {
+		panic(err)
+	}
+	return n
+}
@@ -0,0 +1,142 @@
+package massivedl
+
+import (
+	"context"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewNamer(t *testing.T) {
+	u, _ := url.Parse("http://example.com/a/b/c.zip")
+	downloaded := &Response{Index: 7, Downloaded: true, URL: u, Checksum: "sha256:2cf24dba"}
+
+	testCases := []struct {
+		spec     string
+		url      string
+		response *Response
+		expected string
+	}{
+		{"basename", "http://example.com/a/b/c.zip", &Response{}, "c.zip"},
+		{"basename", "http://example.com/", &Response{}, "index"},
+		{"preserve-path", "http://example.com/a/b/c.zip", &Response{}, "a/b/c.zip"},
+		{"template:{host}/{index}.{ext}", "http://example.com/a/b/c.zip", &Response{Index: 7}, "example.com/7.zip"},
+		{"content-hash", "http://example.com/a/b/c.zip", downloaded, "2cf24dba.zip"},
+	}
+	for _, testCase := range testCases {
+		n, err := NewNamer(testCase.spec)
+		if err != nil {
+			t.Errorf("%s: %v", testCase.spec, err)
+			continue
+		}
+		received, err := n.Name(Entry{URL: testCase.url}, testCase.response)
+		if err != nil || received != testCase.expected {
+			t.Errorf("%s: expected %s received %s (%v)", testCase.spec, testCase.expected, received, err)
+		}
+	}
+
+	n, _ := NewNamer("content-hash")
+	if _, err := n.Name(Entry{URL: "http://example.com/a"}, &Response{}); err != ErrNameAfterDownload {
+		t.Errorf("expected %v before the download received %v", ErrNameAfterDownload, err)
+	}
+	if !NamesAfterDownload(n) {
+		t.Error("expected content-hash to name entries after the download")
+	}
+	if n, _ = NewNamer("basename"); NamesAfterDownload(n) {
+		t.Error("expected basename to name entries before the download")
+	}
+	// a Namer that doesn't declare it is never asked to name an empty entry
+	n = NamerFunc(func(entry Entry, _ *Response) (string, error) {
+		t.Errorf("expected the namer not to be called received %+v", entry)
+		return "", ErrNameAfterDownload
+	})
+	if NamesAfterDownload(n) {
+		t.Error("expected a NamerFunc to name entries before the download")
+	}
+
+	for _, invalid := range []string{"unknown", "basename:x", "template:{nope}", "template:"} {
+		if _, err := NewNamer(invalid); err == nil {
+			t.Errorf("%s: expected an error", invalid)
+		}
+	}
+}
+
+func TestRegisterNamer(t *testing.T) {
+	defer func() {
+		namersLock.Lock()
+		delete(namers, "upper")
+		namersLock.Unlock()
+	}()
+
+	RegisterNamer("upper", func(arg string) (Namer, error) {
+		return NamerFunc(func(entry Entry, _ *Response) (string, error) {
+			return arg + "-" + filepath.Base(entry.URL), nil
+		}), nil
+	})
+	n, err := NewNamer("upper:x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received, _ := n.Name(Entry{URL: "http://example.com/a"}, &Response{}); received != "x-a" {
+		t.Errorf("expected x-a received %s", received)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a strategy registered twice")
+		}
+	}()
+	RegisterNamer("basename", func(string) (Namer, error) { return nil, nil })
+}
+
+func TestRunContentHash(t *testing.T) {
+	server := testServer()
+	defer server.Close()
+
+	dir, err := ioutil.TempDir("", "massivedl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	n, err := NewNamer("content-hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := []Entry{
+		{URL: server.URL + "/ok"},
+		{URL: server.URL + "/dir/ok"},
+		{URL: server.URL + "/ok", Path: "named"},
+		{URL: server.URL + "/missing"},
+	}
+	results, err := New(WithOutputDir(dir), WithDelay(0), WithWorkers(1), WithRetries(0), WithNamer(n)).Run(context.Background(), entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// both urls serve "hello", the second one is skipped
+	expected := filepath.Join(dir, "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824")
+	if results[0].Err != nil || results[0].Path != expected || results[0].Skipped {
+		t.Errorf("expected %s received %+v", expected, results[0])
+	}
+	if results[1].Err != nil || results[1].Path != expected || !results[1].Skipped {
+		t.Errorf("expected %s to be skipped received %+v", expected, results[1])
+	}
+	if results[2].Path != filepath.Join(dir, "named") {
+		t.Errorf("expected the Path of the entry received %s", results[2].Path)
+	}
+	if results[3].Err == nil {
+		t.Error("expected the missing file to fail")
+	}
+
+	// no part files are left behind
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("expected 2 files received %d", len(files))
+	}
+}
@@ -0,0 +1,329 @@
+package massivedl
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/statistics"
+)
+
+// Option changes a setting of a Downloader, see New
+type Option func(*Downloader)
+
+// WithWorkers sets the number of parallel downloads, DefaultWorkers by
+// default
+func WithWorkers(n int) Option {
+	return func(d *Downloader) {
+		if n < 1 {
+			n = 1
+		}
+		d.workers = n
+	}
+}
+
+// WithMaxWorkers sets the most workers a run has, also after SetWorkers,
+// DefaultMaxWorkers by default
+func WithMaxWorkers(n int) Option {
+	return func(d *Downloader) {
+		d.maxWorkers = n
+	}
+}
+
+// WithRetries sets how often a failed download is retried, DefaultRetries
+// by default. Responses with a status that WithRetryOn doesn't retry and
+// files that are too large are not retried.
+func WithRetries(n int) Option {
+	return func(d *Downloader) {
+		if n < 0 {
+			n = 0
+		}
+		d.retries = n
+	}
+}
+
+// WithConnectRetries sets how often a download whose connection failed is
+// retried, DefaultConnectRetries by default. Connection failures rarely
+// heal within moments, so they have a budget of their own.
+func WithConnectRetries(n int) Option {
+	return func(d *Downloader) {
+		if n < 0 {
+			n = 0
+		}
+		d.connectRetries = n
+	}
+}
+
+// WithRetryOn sets the statuses of the responses that are retried, by
+// default 429 Too Many Requests and the 5xx statuses
+func WithRetryOn(retry func(status int) bool) Option {
+	return func(d *Downloader) {
+		if retry != nil {
+			d.retryOn = retry
+		}
+	}
+}
+
+// WithDelay makes every worker wait delay after each download,
+// DefaultDelay by default
+func WithDelay(delay time.Duration) Option {
+	return func(d *Downloader) {
+		d.delay = delay
+	}
+}
+
+// WithStagger spreads the first requests of the workers evenly over the
+// delay of WithDelay instead of sending them at once
+func WithStagger(stagger bool) Option {
+	return func(d *Downloader) {
+		d.stagger = stagger
+	}
+}
+
+// WithMaxPerHost limits the number of parallel downloads from the same host,
+// 0 (the default) means no limit
+func WithMaxPerHost(n int) Option {
+	return func(d *Downloader) {
+		d.maxPerHost = n
+	}
+}
+
+// WithDelayPerHost sets the minimum time between two requests to the same
+// host
+func WithDelayPerHost(delay time.Duration) Option {
+	return func(d *Downloader) {
+		d.delayPerHost = delay
+	}
+}
+
+// WithHostLimit replaces the limits of WithMaxPerHost and WithDelayPerHost
+// for some hosts, limit returns the limits of host. Zero values keep the
+// general limits.
+func WithHostLimit(limit func(host string) (maxPerHost int, delayPerHost time.Duration)) Option {
+	return func(d *Downloader) {
+		d.hostLimit = limit
+	}
+}
+
+// WithHostCaps stops downloading from a host once files were downloaded
+// from it or bytes received, the remaining entries of the host fail with
+// ErrCapped. 0 means no cap. The downloads that are running when the bytes
+// cap is reached still finish, so it can be exceeded by them.
+func WithHostCaps(files int, bytes int64) Option {
+	return func(d *Downloader) {
+		d.maxFiles, d.maxBytes = files, bytes
+	}
+}
+
+// WithOutputDir sets the directory the files are saved in,
+// DefaultOutputDir by default
+func WithOutputDir(dir string) Option {
+	return func(d *Downloader) {
+		d.outputDir = dir
+	}
+}
+
+// WithSkipExisting sets whether files that already exist are skipped, which
+// they are by default
+func WithSkipExisting(skip bool) Option {
+	return func(d *Downloader) {
+		d.skipExisting = skip
+	}
+}
+
+// WithKeepPartial sets whether the part file of a download that failed for
+// good is kept so that a later run resumes it, which it is by default
+func WithKeepPartial(keep bool) Option {
+	return func(d *Downloader) {
+		d.keepPartial = keep
+	}
+}
+
+// WithUserAgent sets the User-Agent of the requests, DefaultUserAgent by
+// default
+func WithUserAgent(userAgent string) Option {
+	return func(d *Downloader) {
+		d.userAgent = userAgent
+	}
+}
+
+// WithHeader adds headers to every request, the headers of an Entry take
+// precedence
+func WithHeader(header http.Header) Option {
+	return func(d *Downloader) {
+		if d.header == nil {
+			d.header = http.Header{}
+		}
+		for name, values := range header {
+			d.header[name] = append(d.header[name], values...)
+		}
+	}
+}
+
+// WithMaxSize makes downloads of files bigger than n bytes fail with
+// ErrTooLarge, 0 (the default) means no limit
+func WithMaxSize(n int64) Option {
+	return func(d *Downloader) {
+		d.maxSize = n
+	}
+}
+
+// WithMaxTime limits the time a download may take, all its attempts
+// together. 0 (the default) means no limit.
+func WithMaxTime(max time.Duration) Option {
+	return func(d *Downloader) {
+		d.maxTime = max
+	}
+}
+
+// WithMinSpeed aborts the attempts that receive less than bytesPerSec for
+// window, DefaultMinSpeedTime if it is 0. They are retried like failed
+// requests. 0 (the default) disables the check.
+func WithMinSpeed(bytesPerSec int64, window time.Duration) Option {
+	return func(d *Downloader) {
+		if window <= 0 {
+			window = DefaultMinSpeedTime
+		}
+		d.minSpeed, d.minSpeedTime = bytesPerSec, window
+	}
+}
+
+// WithRateLimit limits the bytes per second of all downloads together
+// and of every connection, 0 means no limit
+func WithRateLimit(bytesPerSec, perConn int64) Option {
+	return func(d *Downloader) {
+		d.rate, d.connRate = bytesPerSec, perConn
+	}
+}
+
+// WithFairShare makes the jobs of the entries take turns for the workers
+// and split the rate limit of WithRateLimit in proportion to their weight
+func WithFairShare(weight func(job string) float64) Option {
+	return func(d *Downloader) {
+		d.fairShare = weight
+	}
+}
+
+// WithSegments downloads files of at least minSize bytes with n parallel
+// range requests, if their server supports them. Downloads with conditional
+// headers and resumed ones use a single request.
+func WithSegments(n int, minSize int64) Option {
+	return func(d *Downloader) {
+		d.segments, d.segmentMinSize = n, minSize
+	}
+}
+
+// WithMemoryBuffer receives new files of at most n bytes into memory and
+// writes them once they are complete, which saves small writes but leaves
+// nothing to resume. 0 (the default) writes all files as they arrive.
+func WithMemoryBuffer(n int64) Option {
+	return func(d *Downloader) {
+		d.memoryBelow = n
+	}
+}
+
+// WithDigestHeaders sets whether files are checked against the checksums
+// their server announces in Content-MD5, x-amz-checksum-* or x-goog-hash
+// headers, which they are by default
+func WithDigestHeaders(verify bool) Option {
+	return func(d *Downloader) {
+		d.digestHeaders = verify
+	}
+}
+
+// WithChecksumFailAction sets what happens to a file that still doesn't
+// match its checksum after the retries: ChecksumFailDelete (the default),
+// ChecksumFailKeep or ChecksumFailRename
+func WithChecksumFailAction(action string) Option {
+	return func(d *Downloader) {
+		d.checksumFail = action
+	}
+}
+
+// WithContentTypes fails the responses whose Content-Type isn't one of
+// accept, unless it is empty, or is one of reject with ErrContentType. The
+// types are media types like application/zip or types followed by /* like
+// image/*.
+func WithContentTypes(accept, reject []string) Option {
+	return func(d *Downloader) {
+		d.acceptTypes, d.rejectTypes = accept, reject
+	}
+}
+
+// WithFastestMirror tries the url of an entry that answers a HEAD request
+// first before the others, instead of the order they are listed in
+func WithFastestMirror(fastest bool) Option {
+	return func(d *Downloader) {
+		d.fastestMirror = fastest
+	}
+}
+
+// WithFilter only downloads the entries for which keep returns true, the
+// others fail with ErrFiltered
+func WithFilter(keep func(Entry) bool) Option {
+	return func(d *Downloader) {
+		d.filter = keep
+	}
+}
+
+// WithNamer sets the Namer that names the entries without a Path, by
+// default they are named after the last element of their url path. See
+// NewNamer for the built-in strategies.
+func WithNamer(n Namer) Option {
+	return func(d *Downloader) {
+		if n != nil {
+			d.namer = n
+		}
+	}
+}
+
+// WithListener adds a listener for the events of the runs
+func WithListener(l Listener) Option {
+	return func(d *Downloader) {
+		d.listeners = append(d.listeners, l)
+	}
+}
+
+// WithHooks sets the hooks that take part in the downloads, see Hooks
+func WithHooks(hooks Hooks) Option {
+	return func(d *Downloader) {
+		d.hooks = hooks
+	}
+}
+
+// WithStatistics counts the results and the received bytes in the
+// statistics of the massivedl command, route names the route of an entry
+// and may be nil. Skipped files aren't counted. Other programs read
+// Progress and the events.
+func WithStatistics(s *statistics.Statistics, route func(Entry) string) Option {
+	return func(d *Downloader) {
+		d.stats, d.route = s, route
+	}
+}
+
+// WithLogger logs the retries and other details of the downloads to l,
+// nothing is logged by default
+func WithLogger(l *log.Logger) Option {
+	return func(d *Downloader) {
+		d.logger = l
+	}
+}
+
+// WithMemory keeps the downloads in memory and passes them to handle instead
+// of writing them to the output directory, e.g. to use the Downloader as the
+// fetch engine of a service. Every entry is held in memory as a whole, so
+// WithMaxSize should limit their size. Interrupted transfers can't be
+// resumed, their retries start over.
+func WithMemory(handle MemoryHandler) Option {
+	return func(d *Downloader) {
+		d.memory = handle
+	}
+}
+
+// WithHTTPClient sets the client that sends the requests, e.g. to use a
+// proxy or a transport of its own. http.DefaultClient by default.
+func WithHTTPClient(client *http.Client) Option {
+	return func(d *Downloader) {
+		d.client = client
+	}
+}
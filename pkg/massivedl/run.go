@@ -0,0 +1,533 @@
+package massivedl
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/hostlimit"
+	"github.com/dimkouv/massivedl/internal/ratelimit"
+)
+
+// errRunning is returned by Start while the last run isn't over
+var errRunning = errors.New("massivedl: the Downloader is already running")
+
+// errNotStarted is returned by Add, Feed and Wait before Start
+var errNotStarted = errors.New("massivedl: the Downloader wasn't started")
+
+// run is the state of a run between Start and Wait. Entries can be added
+// until the results of all added entries were handled, no feed is open and
+// Close was called, then the run is drained.
+type run struct {
+	ctx    context.Context
+	handle func(index int, res Result)
+	queue  *hostlimit.Queue
+
+	lock       sync.Mutex
+	cond       *sync.Cond
+	count      int           // number of added entries
+	handled    int           // number of results passed to handle
+	feeds      int           // number of open feeds, see Feed
+	closed     bool          // Close was called
+	drained    bool          // no more entries can be added
+	results    []indexResult // results waiting for handle
+	unfinished map[int]Entry // added entries without a result, by index
+
+	delivered chan struct{} // closed once the results goroutine returns
+	stop      chan struct{} // closed once the run is drained or canceled
+	stopOnce  sync.Once
+
+	// the worker pool
+	jobs    chan job
+	quit    chan struct{} // every value sent on quit stops one idle worker
+	size    int
+	nextID  int
+	workers sync.WaitGroup
+}
+
+// indexResult is a result along with the index of its entry
+type indexResult struct {
+	index int
+	res   Result
+}
+
+// Start starts a run that downloads the entries passed to Add, Wait waits
+// for its end. handle is called with the result of every entry, one result
+// at a time and in the order they are in. It may call Add, e.g. for the
+// links of a downloaded page.
+//
+// The run ends once all added entries are done, no feed of Feed is open
+// and Close was called, or when ctx is canceled. The results of the
+// downloads that are running when ctx is canceled are dropped.
+func (d *Downloader) Start(ctx context.Context, handle func(Result)) error {
+	return d.start(ctx, func(_ int, res Result) {
+		if handle != nil {
+			handle(res)
+		}
+	})
+}
+
+// start is Start with the index of the entry of every result
+func (d *Downloader) start(ctx context.Context, handle func(index int, res Result)) error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.run != nil && !d.run.over() {
+		return errRunning
+	}
+
+	r := &run{
+		ctx:        ctx,
+		handle:     handle,
+		queue:      hostlimit.New(d.maxPerHost, d.delayPerHost),
+		unfinished: map[int]Entry{},
+		delivered:  make(chan struct{}),
+		stop:       make(chan struct{}),
+		jobs:       make(chan job),
+		quit:       make(chan struct{}),
+	}
+	r.cond = sync.NewCond(&r.lock)
+	if d.hostLimit != nil {
+		r.queue.SetHostLimit(func(host string) hostlimit.Limit {
+			n, delay := d.hostLimit(host)
+			return hostlimit.Limit{MaxPerHost: n, DelayPerHost: delay}
+		})
+	}
+
+	for _, counter := range []*int64{&d.total, &d.downloaded, &d.failed, &d.capped, &d.bytes} {
+		atomic.StoreInt64(counter, 0)
+	}
+	d.hostCounts = hostCounts{}
+	if d.fairShare != nil {
+		d.fair, d.limiter = ratelimit.NewFairShare(d.rate, fairShareSlice), nil
+	} else {
+		d.fair, d.limiter = nil, ratelimit.NewLimiter(d.rate)
+	}
+	d.run = r
+
+	go r.deliver()
+	go func() {
+		// wakes up the results goroutine, which stops the run
+		select {
+		case <-ctx.Done():
+			r.lock.Lock()
+			r.cond.Broadcast()
+			r.lock.Unlock()
+		case <-r.delivered:
+		}
+	}()
+	go d.dispatch(r)
+	d.resize(r, d.workers)
+
+	return nil
+}
+
+// current returns the run of the last Start
+func (d *Downloader) current() *run {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.run
+}
+
+// Add queues entries for download in the run of Start. It fails with
+// ErrRunFinished once the run is over.
+func (d *Downloader) Add(entries ...Entry) error {
+	r := d.current()
+	if r == nil {
+		return errNotStarted
+	}
+
+	r.lock.Lock()
+	if r.drained {
+		r.lock.Unlock()
+		return ErrRunFinished
+	}
+	if err := r.ctx.Err(); err != nil {
+		r.lock.Unlock()
+		return err
+	}
+
+	// entries that can't be downloaded fail once the lock is released, the
+	// count keeps the run from being drained until then
+	var failed []indexResult
+	for _, entry := range entries {
+		index := r.count
+		r.count++
+		atomic.AddInt64(&d.total, 1)
+		r.unfinished[index] = entry
+
+		j, err := d.newJob(index, entry)
+		if err != nil {
+			failed = append(failed, indexResult{index: index, res: Result{Entry: entry, Err: err}})
+			continue
+		}
+		// with WithFairShare the jobs also take turns for the workers
+		lane := ""
+		if d.fairShare != nil {
+			lane = entry.Job
+		}
+		r.queue.PushPriority(j.url.Host, lane, entry.Priority, j)
+	}
+	r.lock.Unlock()
+
+	for _, f := range failed {
+		d.finish(r, f.index, f.res, -1)
+	}
+	return nil
+}
+
+// Feed registers a source that keeps adding entries, like a directory that
+// is watched for new lists. The run doesn't end while a feed is open, done
+// closes it.
+func (d *Downloader) Feed() (done func()) {
+	r := d.current()
+	if r == nil {
+		return func() {}
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.feeds++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			r.lock.Lock()
+			defer r.lock.Unlock()
+
+			r.feeds--
+			r.cond.Broadcast()
+		})
+	}
+}
+
+// Close tells the run of Start that no more entries are added other than
+// through handle and the open feeds, so it ends once they are done
+func (d *Downloader) Close() {
+	r := d.current()
+	if r == nil {
+		return
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.closed = true
+	r.cond.Broadcast()
+}
+
+// Wait waits for the end of the run of Start and for its workers to stop.
+// It returns the error of the context of the run if it was canceled.
+func (d *Downloader) Wait() error {
+	r := d.current()
+	if r == nil {
+		return errNotStarted
+	}
+
+	<-r.delivered
+	r.stopWorkers()
+	r.workers.Wait()
+
+	// the entries that weren't done fail with the error of the context,
+	// in the order they were added
+	err := r.ctx.Err()
+	if err != nil {
+		r.lock.Lock()
+		indexes := make([]int, 0, len(r.unfinished))
+		for index := range r.unfinished {
+			indexes = append(indexes, index)
+		}
+		sort.Ints(indexes)
+		canceled := make([]Entry, len(indexes))
+		for i, index := range indexes {
+			canceled[i] = r.unfinished[index]
+		}
+		r.unfinished = map[int]Entry{}
+		r.lock.Unlock()
+
+		for _, entry := range canceled {
+			d.count(Result{Entry: entry, Err: err}, -1)
+		}
+	}
+	if d.fair != nil {
+		d.fair.Stop()
+	}
+
+	d.emit(Event{Type: BatchFinished})
+	return err
+}
+
+// Run downloads entries and returns their results in the same order. If ctx
+// is canceled the downloads that are running are aborted, Run returns
+// ctx.Err() along with the results, in which the entries that weren't
+// downloaded have the error of ctx.
+func (d *Downloader) Run(ctx context.Context, entries []Entry) ([]Result, error) {
+	results := make([]Result, len(entries))
+	done := make([]bool, len(entries))
+	err := d.start(ctx, func(index int, res Result) {
+		results[index], done[index] = res, true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err = d.Add(entries...); err == nil {
+		d.Close()
+	}
+	if waitErr := d.Wait(); err == nil {
+		err = waitErr
+	}
+
+	for i := range results {
+		if !done[i] {
+			results[i] = Result{Entry: entries[i], Err: err}
+		}
+	}
+	return results, err
+}
+
+// over reports whether the run ended
+func (r *run) over() bool {
+	select {
+	case <-r.delivered:
+		return true
+	default:
+		return false
+	}
+}
+
+// deliver passes the results to handle until the run is drained or its
+// context canceled, the results that are in by then are still handled
+func (r *run) deliver() {
+	defer close(r.delivered)
+	defer r.stopWorkers()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	for {
+		if len(r.results) > 0 {
+			next := r.results[0]
+			r.results = r.results[1:]
+			r.lock.Unlock()
+			r.handle(next.index, next.res)
+			r.lock.Lock()
+			r.handled++
+			continue
+		}
+		if r.ctx.Err() != nil || r.handled == r.count && r.feeds == 0 && r.closed {
+			r.drained = true
+			r.queue.Close()
+			return
+		}
+		r.cond.Wait()
+	}
+}
+
+// stopWorkers stops the idle workers and those that wait between downloads
+func (r *run) stopWorkers() {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+}
+
+// finish counts the result of the entry at index and passes it on to
+// handle, worker is -1 for results of no worker
+func (d *Downloader) finish(r *run, index int, res Result, worker int) {
+	d.record(res)
+	d.count(res, worker)
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.unfinished, index)
+	r.results = append(r.results, indexResult{index: index, res: res})
+	r.cond.Broadcast()
+}
+
+// count adds res to the counters of Progress and sends its EntryFinished
+// event
+func (d *Downloader) count(res Result, worker int) {
+	switch {
+	case res.Err == nil:
+		atomic.AddInt64(&d.downloaded, 1)
+	case errors.Is(res.Err, ErrCapped):
+		atomic.AddInt64(&d.capped, 1)
+		atomic.AddInt64(&d.failed, 1)
+	default:
+		atomic.AddInt64(&d.failed, 1)
+	}
+	d.emit(Event{Type: EntryFinished, Entry: res.Entry, Worker: worker, Path: res.Path, Result: &res})
+}
+
+// addBytes counts n received bytes
+func (d *Downloader) addBytes(n int64) {
+	atomic.AddInt64(&d.bytes, n)
+	if d.stats != nil && n > 0 {
+		d.stats.AddBytes(uint64(n))
+	}
+}
+
+// dispatch passes the jobs of the host queue to the workers, the queue
+// decides which one is next
+func (d *Downloader) dispatch(r *run) {
+	for {
+		_, item, ok := r.queue.Pop()
+		if !ok {
+			return
+		}
+		select {
+		case r.jobs <- item.(job):
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// SetWorkers starts or stops workers of the running run until there are n
+// of them, at most as many as WithMaxWorkers allows. Workers that are
+// stopped finish their current download first. Before Start it sets the
+// number of workers like WithWorkers.
+func (d *Downloader) SetWorkers(n int) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.workers = n
+	if d.run != nil {
+		d.resize(d.run, n)
+	}
+}
+
+// Workers returns the number of workers
+func (d *Downloader) Workers() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.run != nil && !d.run.over() {
+		d.run.lock.Lock()
+		defer d.run.lock.Unlock()
+		return d.run.size
+	}
+	return d.workers
+}
+
+// resize starts or stops workers of r until there are n of them, d.lock
+// must be held
+func (d *Downloader) resize(r *run, n int) {
+	if n < 1 {
+		n = 1
+	}
+	if max := d.maxWorkers; max > 0 && n > max {
+		n = max
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	// the workers of a finished run don't come back
+	if r.drained {
+		return
+	}
+
+	for ; r.size < n; r.size++ {
+		r.workers.Add(1)
+		go d.work(r, r.nextID, n)
+		r.nextID++
+	}
+
+	if stop := r.size - n; stop > 0 {
+		r.size = n
+		go func() {
+			for i := 0; i < stop; i++ {
+				select {
+				case r.quit <- struct{}{}:
+				case <-r.stop:
+					return
+				}
+			}
+		}()
+	}
+}
+
+// Pause holds back the workers before they take their next download, the
+// running downloads are finished
+func (d *Downloader) Pause() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if !d.pausedOn {
+		d.pausedOn = true
+		d.paused = make(chan struct{})
+	}
+}
+
+// Resume lets the workers go on after Pause
+func (d *Downloader) Resume() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.pausedOn {
+		d.pausedOn = false
+		close(d.paused)
+	}
+}
+
+// Paused reports whether the downloads are paused
+func (d *Downloader) Paused() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.pausedOn
+}
+
+// SetMaxPerHost sets the number of parallel downloads from the same host
+// like WithMaxPerHost, also while the downloads are running
+func (d *Downloader) SetMaxPerHost(n int) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	d.maxPerHost = n
+	if d.run != nil {
+		d.run.queue.SetMaxPerHost(n)
+	}
+}
+
+// MaxPerHost returns the limit of WithMaxPerHost and SetMaxPerHost
+func (d *Downloader) MaxPerHost() int {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.maxPerHost
+}
+
+// The reasons why none of the entries of a host can start, see HostState
+const (
+	BlockedMaxPerHost   = hostlimit.BlockedMax   // the host has its maximum of downloads running
+	BlockedDelayPerHost = hostlimit.BlockedDelay // the host was contacted less than its delay ago
+)
+
+// HostState describes a host with entries that wait or are being downloaded
+type HostState struct {
+	Host    string
+	Pending int
+	Active  int
+	Blocked string    // BlockedMaxPerHost, BlockedDelayPerHost or "" if an entry can start
+	Until   time.Time // end of the delay of BlockedDelayPerHost
+}
+
+// Hosts returns the state of the hosts of the running run, ordered by host
+func (d *Downloader) Hosts() []HostState {
+	r := d.current()
+	if r == nil {
+		return nil
+	}
+
+	var hosts []HostState
+	for _, s := range r.queue.State() {
+		hosts = append(hosts, HostState(s))
+	}
+	return hosts
+}
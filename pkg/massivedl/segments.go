@@ -0,0 +1,208 @@
+package massivedl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/dimkouv/massivedl/internal/httputil"
+	"github.com/dimkouv/massivedl/internal/ratelimit"
+)
+
+// SegmentedPartSuffix is used instead of PartSuffix for segmented downloads.
+// Their part files have holes until every segment is complete, so they must
+// never be picked up by the sequential resume logic.
+const SegmentedPartSuffix = ".seg.part"
+
+// sectionWriter writes to a file sequentially, starting at a fixed offset
+type sectionWriter struct {
+	file   *os.File
+	offset int64
+}
+
+func (w *sectionWriter) Write(b []byte) (int, error) {
+	n, err := w.file.WriteAt(b, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
+
+// probeRanges sends a HEAD request to url and returns the size of the remote
+// file if the server advertises support for byte ranges, or unknownSize
+// otherwise, and the validator of the file for If-Range requests. The url,
+// status and headers of the answer are recorded in last.
+func (d *Downloader) probeRanges(ctx context.Context, t *transfer, url string, last *Response) (int64, string, error) {
+	req, err := d.newRequest(ctx, "HEAD", url, t)
+	if err != nil {
+		return unknownSize, "", err
+	}
+
+	response, err := d.send(req)
+	if err != nil {
+		return unknownSize, "", err
+	}
+	if err = response.Body.Close(); err != nil {
+		d.logf("error closing response body: %v", err)
+	}
+
+	if response.StatusCode != http.StatusOK || response.Header.Get("Accept-Ranges") != "bytes" {
+		return unknownSize, "", nil
+	}
+	// files of the wrong type are downloaded normally, downloadPart rejects
+	// them before their data is sent
+	if d.checkContentType(response.Header) != nil {
+		return unknownSize, "", nil
+	}
+
+	last.URL, last.StatusCode, last.Header = response.Request.URL, response.StatusCode, response.Header
+	return response.ContentLength, httputil.Validator(response.Header), nil
+}
+
+// downloadSegmented downloads url into segPath using the parallel range
+// requests of WithSegments, which are written directly to their offsets in
+// the file. ok is false when the file is too small or the server does not
+// support ranges, in which case nothing has been written and the caller
+// should download it normally. All segments together are throttled by the
+// limiter of t.
+func (d *Downloader) downloadSegmented(ctx context.Context, t *transfer, url, segPath string, last *Response) (nBytes int64, ok bool, err error) {
+	size, validator, err := d.probeRanges(ctx, t, url, last)
+	if err != nil || size < d.segmentMinSize {
+		if err == nil && size == unknownSize {
+			d.logln("[SEGMENTS]", url, "size or range support unknown, using a single connection")
+		}
+		return 0, false, nil
+	}
+	// files that are too large are rejected by downloadPart before their
+	// data is sent
+	if t.maxSize > 0 && size > t.maxSize {
+		return 0, false, nil
+	}
+
+	file, err := os.OpenFile(segPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, os.ModePerm)
+	if err != nil {
+		return 0, true, err
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			d.logf("unable to close file: %v", err)
+		}
+	}()
+
+	if err = file.Truncate(size); err != nil {
+		return 0, true, err
+	}
+	t.progress.reset(0, size)
+
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+
+	segments := int64(d.segments)
+	for i := int64(0); i < segments; i++ {
+		start := i * size / segments
+		end := (i+1)*size/segments - 1
+		if end < start {
+			continue
+		}
+
+		wg.Add(1)
+		go func(start, end int64) {
+			defer wg.Done()
+
+			n, segErr := d.downloadSegment(ctx, t, url, file, start, end, validator)
+
+			lock.Lock()
+			defer lock.Unlock()
+			nBytes += n
+			if segErr != nil && err == nil {
+				err = segErr
+			}
+		}(start, end)
+	}
+	wg.Wait()
+
+	return nBytes, true, err
+}
+
+// downloadSegment downloads the bytes start-end (inclusive) of url into file,
+// resuming from the last written byte whenever an attempt fails. Attempts
+// stop when the file no longer matches validator.
+func (d *Downloader) downloadSegment(ctx context.Context, t *transfer, url string, file *os.File, start, end int64, validator string) (int64, error) {
+	w := &sectionWriter{file: file, offset: start}
+	var err error
+
+	var budget retryBudget
+
+	for tries := 0; ; tries++ {
+		if err = d.fetchRange(ctx, t, url, w, end, validator); err == nil || errors.Is(err, errRemoteChanged) || ctx.Err() != nil {
+			break
+		}
+		d.logln("[RETRY SEGMENT]", tries, url, fmt.Sprintf("%d-%d", w.offset, end), err)
+		if !budget.fail(d, err, t.retries) {
+			break
+		}
+	}
+
+	return w.offset - start, err
+}
+
+// fetchRange requests the bytes w.offset-end of url and copies them into w,
+// unless the file no longer matches validator
+func (d *Downloader) fetchRange(ctx context.Context, t *transfer, url string, w *sectionWriter, end int64, validator string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	watchdog := ratelimit.NewWatchdog(d.minSpeed, d.minSpeedTime, cancel)
+	defer watchdog.Stop()
+
+	req, err := d.newRequest(ctx, "GET", url, t)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", w.offset, end))
+	if validator != "" {
+		req.Header.Set("If-Range", validator)
+	}
+
+	response, err := d.send(req)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err = response.Body.Close(); err != nil {
+			d.logf("error closing response body: %v", err)
+		}
+	}()
+
+	// a changed file is sent completely instead of the range
+	if response.StatusCode == http.StatusOK && validator != "" || httputil.Changed(validator, response.Header) {
+		return errRemoteChanged
+	}
+	if response.StatusCode >= http.StatusMultipleChoices {
+		return newHTTPError(response)
+	}
+	if response.StatusCode != http.StatusPartialContent {
+		return fmt.Errorf("expected status 206 for a range request, received %d", response.StatusCode)
+	}
+
+	start, body, err := httputil.PartialBody(response.Header, response.Body)
+	if err != nil {
+		return err
+	}
+	if start != w.offset {
+		return fmt.Errorf("server sent range starting at byte %d instead of %d", start, w.offset)
+	}
+
+	remaining := end - w.offset + 1
+	body = ratelimit.NewReader(ctx, body, t.limiter, ratelimit.NewLimiter(d.connRate))
+	n, err := io.Copy(io.MultiWriter(timedWriter{w, &t.writeTime}, progressWriter{d, t.progress}), watchdog.Reader(io.LimitReader(body, remaining)))
+	if err != nil && watchdog.Stalled() {
+		err = d.stalledError()
+	}
+	if err == nil && n < remaining {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return err
+}
@@ -0,0 +1,165 @@
+package massivedl
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/dimkouv/massivedl/internal/fileutil"
+	"github.com/dimkouv/massivedl/internal/logging"
+)
+
+// work takes the jobs of r until the run stops or the worker is quit. n is
+// the number of workers the pool was started with, for WithStagger.
+func (d *Downloader) work(r *run, id, n int) {
+	defer r.workers.Done()
+	defer d.setState(id, WorkerStopped, Entry{})
+
+	if d.stagger {
+		d.setState(id, WorkerDelay, Entry{})
+		if !r.sleep(staggerDelay(id, n, d.delay)) {
+			return
+		}
+	}
+
+	for {
+		if d.Paused() {
+			d.setState(id, WorkerPaused, Entry{})
+		}
+		if !d.waitResumed(r.ctx, r.stop) {
+			return
+		}
+		if d.hooks.Wait != nil {
+			d.hooks.Wait(id)
+		}
+		d.setState(id, WorkerWaiting, Entry{})
+
+		var j job
+		select {
+		case <-r.quit:
+			return
+		case <-r.stop:
+			return
+		case j = <-r.jobs:
+		}
+		if r.ctx.Err() != nil {
+			return
+		}
+
+		res, downloaded := d.process(r, id, j)
+		if downloaded && r.ctx.Err() != nil {
+			// aborted with the run, neither finished nor failed
+			return
+		}
+		r.queue.Done(j.url.Host)
+		d.finish(r, j.index, res, id)
+		if !downloaded {
+			continue
+		}
+
+		d.setState(id, WorkerDelay, Entry{})
+		if !r.sleep(d.delay) {
+			return
+		}
+	}
+}
+
+// process downloads j, unless it is skipped or capped. downloaded is false
+// for the entries that no request was sent for.
+func (d *Downloader) process(r *run, id int, j job) (res Result, downloaded bool) {
+	if j.path != "" && d.skipExisting && fileutil.FileOrPathExists(j.path) {
+		return Result{Entry: j.entry, Path: j.path, Skipped: true}, false
+	}
+	if j.path != "" && d.hooks.Skip != nil {
+		if res, skip := d.hooks.Skip(j.entry, j.path); skip {
+			res.Entry = j.entry
+			return res, false
+		}
+	}
+	if res, capped := d.takeHostCap(j, j.path); capped {
+		return res, false
+	}
+
+	path, release := j.path, func() {}
+	if path != "" && d.hooks.Place != nil {
+		path, release = d.hooks.Place(id, j.entry, path)
+	}
+	d.setState(id, WorkerDownloading, j.entry)
+	d.emit(Event{Type: EntryStarted, Entry: j.entry, Worker: id, Path: path})
+	res = d.download(r.ctx, id, j, path)
+	release()
+	d.countHostCap(j, res)
+
+	return res, true
+}
+
+// staggerDelay returns how long worker id waits before its first request so
+// that the first requests of n workers are spread evenly over delay
+func staggerDelay(id, n int, delay time.Duration) time.Duration {
+	if n < 1 {
+		return 0
+	}
+	return delay * time.Duration(id%n) / time.Duration(n)
+}
+
+// sleep waits for delay, it returns false if the run stops meanwhile
+func (r *run) sleep(delay time.Duration) bool {
+	select {
+	case <-r.ctx.Done():
+		return false
+	case <-r.stop:
+		return false
+	case <-time.After(delay):
+		return true
+	}
+}
+
+// waitResumed blocks while the downloads are paused, it returns false if ctx
+// is canceled or stop closed meanwhile
+func (d *Downloader) waitResumed(ctx context.Context, stop <-chan struct{}) bool {
+	d.lock.Lock()
+	paused := d.paused
+	d.lock.Unlock()
+
+	select {
+	case <-paused:
+		return true
+	case <-ctx.Done():
+		return false
+	case <-stop:
+		return false
+	}
+}
+
+// record counts res in the statistics of WithStatistics. Skipped files
+// aren't counted, the statistics only count what the run did.
+func (d *Downloader) record(res Result) {
+	if d.stats == nil || res.Skipped {
+		return
+	}
+
+	entry := logEntry(res)
+	d.stats.Update(entry)
+	if d.route != nil && !entry.Capped {
+		d.stats.UpdateRoute(d.route(res.Entry), entry)
+	}
+}
+
+// logEntry converts res for the statistics
+func logEntry(res Result) logging.LogEntry {
+	entry := logging.LogEntry{
+		Url:        res.Entry.URL,
+		Name:       res.Path,
+		Result:     res.Err == nil,
+		NBytes:     uint64(res.Bytes),
+		Duration:   res.Duration,
+		StatusCode: res.StatusCode,
+		Attempts:   res.Attempts,
+		Skipped:    res.Skipped,
+	}
+	if res.Err != nil {
+		entry.Error = res.Err.Error()
+		entry.Capped = errors.Is(res.Err, ErrCapped)
+	}
+	return entry
+}